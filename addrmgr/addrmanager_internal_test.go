@@ -1,17 +1,19 @@
 package addrmgr
 
 import (
+	"bytes"
 	"io/ioutil"
 	"math/rand"
 	"net"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/organicbitcoin/obtcd/wire"
 )
 
-// randAddr generates a *wire.NetAddress backed by a random IPv4/IPv6 address.
-func randAddr(t *testing.T) *wire.NetAddress {
+// randAddr generates a *wire.NetAddressV2 backed by a random IPv4/IPv6 address.
+func randAddr(t *testing.T) *wire.NetAddressV2 {
 	t.Helper()
 
 	ipv4 := rand.Intn(2) == 0
@@ -30,22 +32,44 @@ func randAddr(t *testing.T) *wire.NetAddress {
 		ip = b[:]
 	}
 
-	return &wire.NetAddress{
-		Services: wire.ServiceFlag(rand.Uint64()),
-		IP:       ip,
-		Port:     uint16(rand.Uint32()),
+	return wire.NewNetAddressV2IPPort(ip, uint16(rand.Uint32()),
+		wire.ServiceFlag(rand.Uint64()))
+}
+
+// randOverlayAddr generates a *wire.NetAddressV2 backed by a random address of
+// the passed overlay network.
+func randOverlayAddr(t *testing.T, netID wire.NetworkID) *wire.NetAddressV2 {
+	t.Helper()
+
+	size := 32
+	if netID == wire.NetIDCJDNS {
+		size = 16
+	}
+	addr := make([]byte, size)
+	if _, err := rand.Read(addr); err != nil {
+		t.Fatal(err)
 	}
+	if netID == wire.NetIDCJDNS {
+		addr[0] = 0xfc
+	}
+
+	return wire.NewNetAddressV2(time.Now(), wire.ServiceFlag(rand.Uint64()),
+		netID, addr, uint16(rand.Uint32()))
 }
 
 // assertAddr ensures that the two addresses match. The timestamp is not
 // checked as it does not affect uniquely identifying a specific address.
-func assertAddr(t *testing.T, got, expected *wire.NetAddress) {
+func assertAddr(t *testing.T, got, expected *wire.NetAddressV2) {
 	if got.Services != expected.Services {
 		t.Fatalf("expected address services %v, got %v",
 			expected.Services, got.Services)
 	}
-	if !got.IP.Equal(expected.IP) {
-		t.Fatalf("expected address IP %v, got %v", expected.IP, got.IP)
+	if got.NetID != expected.NetID {
+		t.Fatalf("expected address network %v, got %v",
+			expected.NetID, got.NetID)
+	}
+	if !bytes.Equal(got.Addr, expected.Addr) {
+		t.Fatalf("expected address %x, got %x", expected.Addr, got.Addr)
 	}
 	if got.Port != expected.Port {
 		t.Fatalf("expected address port %d, got %d", expected.Port,
@@ -56,7 +80,7 @@ func assertAddr(t *testing.T, got, expected *wire.NetAddress) {
 // assertAddrs ensures that the manager's address cache matches the given
 // expected addresses.
 func assertAddrs(t *testing.T, addrMgr *AddrManager,
-	expectedAddrs map[string]*wire.NetAddress) {
+	expectedAddrs map[string]*wire.NetAddressV2) {
 
 	t.Helper()
 
@@ -96,7 +120,7 @@ func TestAddrManagerSerialization(t *testing.T) {
 	// We'll be adding 5 random addresses to the manager.
 	const numAddrs = 5
 
-	expectedAddrs := make(map[string]*wire.NetAddress, numAddrs)
+	expectedAddrs := make(map[string]*wire.NetAddressV2, numAddrs)
 	for i := 0; i < numAddrs; i++ {
 		addr := randAddr(t)
 		expectedAddrs[NetAddressKey(addr)] = addr
@@ -141,7 +165,7 @@ func TestAddrManagerV1ToV2(t *testing.T) {
 	// each addresses' services will not be stored.
 	const numAddrs = 5
 
-	expectedAddrs := make(map[string]*wire.NetAddress, numAddrs)
+	expectedAddrs := make(map[string]*wire.NetAddressV2, numAddrs)
 	for i := 0; i < numAddrs; i++ {
 		addr := randAddr(t)
 		expectedAddrs[NetAddressKey(addr)] = addr
@@ -192,3 +216,49 @@ func TestAddrManagerV1ToV2(t *testing.T) {
 	addrMgr.loadPeers()
 	assertAddrs(t, addrMgr, expectedAddrs)
 }
+
+// TestAddrManagerOverlayNetworks ensures that Tor v3, I2P and CJDNS addresses
+// are serialized and deserialized along with their network.
+func TestAddrManagerOverlayNetworks(t *testing.T) {
+	t.Parallel()
+
+	tempDir, err := ioutil.TempDir("", "addrmgr")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	addrMgr := New(tempDir, nil)
+
+	netIDs := []wire.NetworkID{wire.NetIDTorV3, wire.NetIDI2P,
+		wire.NetIDCJDNS}
+	expectedAddrs := make(map[string]*wire.NetAddressV2, len(netIDs))
+	for _, netID := range netIDs {
+		addr := randOverlayAddr(t, netID)
+		expectedAddrs[NetAddressKey(addr)] = addr
+		addrMgr.AddAddress(addr, randOverlayAddr(t, netID))
+	}
+	assertAddrs(t, addrMgr, expectedAddrs)
+
+	addrMgr.savePeers()
+	addrMgr = New(tempDir, nil)
+	addrMgr.loadPeers()
+	assertAddrs(t, addrMgr, expectedAddrs)
+
+	// Prior versions don't store the network, so CJDNS addresses are
+	// restored as IPv6 addresses.
+	addrMgr.version = 2
+	addrMgr.savePeers()
+	addrMgr = New(tempDir, nil)
+	addrMgr.loadPeers()
+	for _, addr := range addrMgr.getAddresses() {
+		want := expectedAddrs[NetAddressKey(addr)].NetID
+		if want == wire.NetIDCJDNS {
+			want = wire.NetIDIPv6
+		}
+		if addr.NetID != want {
+			t.Fatalf("expected address network %v, got %v", want,
+				addr.NetID)
+		}
+	}
+}
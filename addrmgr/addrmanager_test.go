@@ -19,7 +19,7 @@ import (
 // naTest is used to describe a test to be performed against the NetAddressKey
 // method.
 type naTest struct {
-	in   wire.NetAddress
+	in   wire.NetAddressV2
 	want string
 }
 
@@ -93,7 +93,7 @@ func addNaTests() {
 
 func addNaTest(ip string, port uint16, want string) {
 	nip := net.ParseIP(ip)
-	na := *wire.NewNetAddressIPPort(nip, port, wire.SFNodeNetwork)
+	na := *wire.NewNetAddressV2IPPort(nip, port, wire.SFNodeNetwork)
 	test := naTest{na, want}
 	naTests = append(naTests, test)
 }
@@ -157,37 +157,37 @@ func TestAddAddressByIP(t *testing.T) {
 
 func TestAddLocalAddress(t *testing.T) {
 	var tests = []struct {
-		address  wire.NetAddress
+		address  wire.NetAddressV2
 		priority addrmgr.AddressPriority
 		valid    bool
 	}{
 		{
-			wire.NetAddress{IP: net.ParseIP("192.168.0.100")},
+			*wire.NewNetAddressV2IPPort(net.ParseIP("192.168.0.100"), 0, 0),
 			addrmgr.InterfacePrio,
 			false,
 		},
 		{
-			wire.NetAddress{IP: net.ParseIP("204.124.1.1")},
+			*wire.NewNetAddressV2IPPort(net.ParseIP("204.124.1.1"), 0, 0),
 			addrmgr.InterfacePrio,
 			true,
 		},
 		{
-			wire.NetAddress{IP: net.ParseIP("204.124.1.1")},
+			*wire.NewNetAddressV2IPPort(net.ParseIP("204.124.1.1"), 0, 0),
 			addrmgr.BoundPrio,
 			true,
 		},
 		{
-			wire.NetAddress{IP: net.ParseIP("::1")},
+			*wire.NewNetAddressV2IPPort(net.ParseIP("::1"), 0, 0),
 			addrmgr.InterfacePrio,
 			false,
 		},
 		{
-			wire.NetAddress{IP: net.ParseIP("fe80::1")},
+			*wire.NewNetAddressV2IPPort(net.ParseIP("fe80::1"), 0, 0),
 			addrmgr.InterfacePrio,
 			false,
 		},
 		{
-			wire.NetAddress{IP: net.ParseIP("2620:100::1")},
+			*wire.NewNetAddressV2IPPort(net.ParseIP("2620:100::1"), 0, 0),
 			addrmgr.InterfacePrio,
 			true,
 		},
@@ -197,12 +197,12 @@ func TestAddLocalAddress(t *testing.T) {
 		result := amgr.AddLocalAddress(&test.address, test.priority)
 		if result == nil && !test.valid {
 			t.Errorf("TestAddLocalAddress test #%d failed: %s should have "+
-				"been accepted", x, test.address.IP)
+				"been accepted", x, test.address.IP())
 			continue
 		}
 		if result != nil && test.valid {
 			t.Errorf("TestAddLocalAddress test #%d failed: %s should not have "+
-				"been accepted", x, test.address.IP)
+				"been accepted", x, test.address.IP())
 			continue
 		}
 	}
@@ -230,6 +230,31 @@ func TestAttempt(t *testing.T) {
 	}
 }
 
+func TestServices(t *testing.T) {
+	n := addrmgr.New("testservices", lookupFunc)
+
+	// Add a new address and get it
+	err := n.AddAddressByIP(someIP + ":8333")
+	if err != nil {
+		t.Fatalf("Adding address failed: %v", err)
+	}
+	na := n.GetAddress().NetAddress()
+
+	want := wire.SFNodeNetwork | wire.SFNodeP2PV2
+	n.SetServices(na, want)
+	if got := n.Services(na); got != want {
+		t.Errorf("Services: got %v, want %v", got, want)
+	}
+
+	unknown, err := n.HostToNetAddress("1.2.3.4", 8333, 0)
+	if err != nil {
+		t.Fatalf("HostToNetAddress failed: %v", err)
+	}
+	if got := n.Services(unknown); got != 0 {
+		t.Errorf("Services of unknown address: got %v, want 0", got)
+	}
+}
+
 func TestConnected(t *testing.T) {
 	n := addrmgr.New("testconnected", lookupFunc)
 
@@ -257,7 +282,7 @@ func TestNeedMoreAddresses(t *testing.T) {
 	if !b {
 		t.Errorf("Expected that we need more addresses")
 	}
-	addrs := make([]*wire.NetAddress, addrsToAdd)
+	addrs := make([]*wire.NetAddressV2, addrsToAdd)
 
 	var err error
 	for i := 0; i < addrsToAdd; i++ {
@@ -268,7 +293,7 @@ func TestNeedMoreAddresses(t *testing.T) {
 		}
 	}
 
-	srcAddr := wire.NewNetAddressIPPort(net.IPv4(173, 144, 173, 111), 8333, 0)
+	srcAddr := wire.NewNetAddressV2IPPort(net.IPv4(173, 144, 173, 111), 8333, 0)
 
 	n.AddAddresses(addrs, srcAddr)
 	numAddrs := n.NumAddresses()
@@ -285,7 +310,7 @@ func TestNeedMoreAddresses(t *testing.T) {
 func TestGood(t *testing.T) {
 	n := addrmgr.New("testgood", lookupFunc)
 	addrsToAdd := 64 * 64
-	addrs := make([]*wire.NetAddress, addrsToAdd)
+	addrs := make([]*wire.NetAddressV2, addrsToAdd)
 
 	var err error
 	for i := 0; i < addrsToAdd; i++ {
@@ -296,7 +321,7 @@ func TestGood(t *testing.T) {
 		}
 	}
 
-	srcAddr := wire.NewNetAddressIPPort(net.IPv4(173, 144, 173, 111), 8333, 0)
+	srcAddr := wire.NewNetAddressV2IPPort(net.IPv4(173, 144, 173, 111), 8333, 0)
 
 	n.AddAddresses(addrs, srcAddr)
 	for _, addr := range addrs {
@@ -331,8 +356,8 @@ func TestGetAddress(t *testing.T) {
 	if ka == nil {
 		t.Fatalf("Did not get an address where there is one in the pool")
 	}
-	if ka.NetAddress().IP.String() != someIP {
-		t.Errorf("Wrong IP: got %v, want %v", ka.NetAddress().IP.String(), someIP)
+	if ka.NetAddress().IP().String() != someIP {
+		t.Errorf("Wrong IP: got %v, want %v", ka.NetAddress().IP().String(), someIP)
 	}
 
 	// Mark this as a good address and get it
@@ -341,8 +366,8 @@ func TestGetAddress(t *testing.T) {
 	if ka == nil {
 		t.Fatalf("Did not get an address where there is one in the pool")
 	}
-	if ka.NetAddress().IP.String() != someIP {
-		t.Errorf("Wrong IP: got %v, want %v", ka.NetAddress().IP.String(), someIP)
+	if ka.NetAddress().IP().String() != someIP {
+		t.Errorf("Wrong IP: got %v, want %v", ka.NetAddress().IP().String(), someIP)
 	}
 
 	numAddrs := n.NumAddresses()
@@ -352,51 +377,51 @@ func TestGetAddress(t *testing.T) {
 }
 
 func TestGetBestLocalAddress(t *testing.T) {
-	localAddrs := []wire.NetAddress{
-		{IP: net.ParseIP("192.168.0.100")},
-		{IP: net.ParseIP("::1")},
-		{IP: net.ParseIP("fe80::1")},
-		{IP: net.ParseIP("2001:470::1")},
+	localAddrs := []wire.NetAddressV2{
+		*wire.NewNetAddressV2IPPort(net.ParseIP("192.168.0.100"), 0, 0),
+		*wire.NewNetAddressV2IPPort(net.ParseIP("::1"), 0, 0),
+		*wire.NewNetAddressV2IPPort(net.ParseIP("fe80::1"), 0, 0),
+		*wire.NewNetAddressV2IPPort(net.ParseIP("2001:470::1"), 0, 0),
 	}
 
 	var tests = []struct {
-		remoteAddr wire.NetAddress
-		want0      wire.NetAddress
-		want1      wire.NetAddress
-		want2      wire.NetAddress
-		want3      wire.NetAddress
+		remoteAddr wire.NetAddressV2
+		want0      wire.NetAddressV2
+		want1      wire.NetAddressV2
+		want2      wire.NetAddressV2
+		want3      wire.NetAddressV2
 	}{
 		{
 			// Remote connection from public IPv4
-			wire.NetAddress{IP: net.ParseIP("204.124.8.1")},
-			wire.NetAddress{IP: net.IPv4zero},
-			wire.NetAddress{IP: net.IPv4zero},
-			wire.NetAddress{IP: net.ParseIP("204.124.8.100")},
-			wire.NetAddress{IP: net.ParseIP("fd87:d87e:eb43:25::1")},
+			*wire.NewNetAddressV2IPPort(net.ParseIP("204.124.8.1"), 0, 0),
+			*wire.NewNetAddressV2IPPort(net.IPv4zero, 0, 0),
+			*wire.NewNetAddressV2IPPort(net.IPv4zero, 0, 0),
+			*wire.NewNetAddressV2IPPort(net.ParseIP("204.124.8.100"), 0, 0),
+			*wire.NewNetAddressV2IPPort(net.ParseIP("fd87:d87e:eb43:25::1"), 0, 0),
 		},
 		{
 			// Remote connection from private IPv4
-			wire.NetAddress{IP: net.ParseIP("172.16.0.254")},
-			wire.NetAddress{IP: net.IPv4zero},
-			wire.NetAddress{IP: net.IPv4zero},
-			wire.NetAddress{IP: net.IPv4zero},
-			wire.NetAddress{IP: net.IPv4zero},
+			*wire.NewNetAddressV2IPPort(net.ParseIP("172.16.0.254"), 0, 0),
+			*wire.NewNetAddressV2IPPort(net.IPv4zero, 0, 0),
+			*wire.NewNetAddressV2IPPort(net.IPv4zero, 0, 0),
+			*wire.NewNetAddressV2IPPort(net.IPv4zero, 0, 0),
+			*wire.NewNetAddressV2IPPort(net.IPv4zero, 0, 0),
 		},
 		{
 			// Remote connection from public IPv6
-			wire.NetAddress{IP: net.ParseIP("2602:100:abcd::102")},
-			wire.NetAddress{IP: net.IPv6zero},
-			wire.NetAddress{IP: net.ParseIP("2001:470::1")},
-			wire.NetAddress{IP: net.ParseIP("2001:470::1")},
-			wire.NetAddress{IP: net.ParseIP("2001:470::1")},
+			*wire.NewNetAddressV2IPPort(net.ParseIP("2602:100:abcd::102"), 0, 0),
+			*wire.NewNetAddressV2IPPort(net.IPv6zero, 0, 0),
+			*wire.NewNetAddressV2IPPort(net.ParseIP("2001:470::1"), 0, 0),
+			*wire.NewNetAddressV2IPPort(net.ParseIP("2001:470::1"), 0, 0),
+			*wire.NewNetAddressV2IPPort(net.ParseIP("2001:470::1"), 0, 0),
 		},
 		/* XXX
 		{
 			// Remote connection from Tor
-			wire.NetAddress{IP: net.ParseIP("fd87:d87e:eb43::100")},
-			wire.NetAddress{IP: net.IPv4zero},
-			wire.NetAddress{IP: net.ParseIP("204.124.8.100")},
-			wire.NetAddress{IP: net.ParseIP("fd87:d87e:eb43:25::1")},
+			*wire.NewNetAddressV2IPPort(net.ParseIP("fd87:d87e:eb43::100"), 0, 0),
+			*wire.NewNetAddressV2IPPort(net.IPv4zero, 0, 0),
+			*wire.NewNetAddressV2IPPort(net.ParseIP("204.124.8.100"), 0, 0),
+			*wire.NewNetAddressV2IPPort(net.ParseIP("fd87:d87e:eb43:25::1"), 0, 0),
 		},
 		*/
 	}
@@ -406,9 +431,9 @@ func TestGetBestLocalAddress(t *testing.T) {
 	// Test against default when there's no address
 	for x, test := range tests {
 		got := amgr.GetBestLocalAddress(&test.remoteAddr)
-		if !test.want0.IP.Equal(got.IP) {
+		if !test.want0.IP().Equal(got.IP()) {
 			t.Errorf("TestGetBestLocalAddress test1 #%d failed for remote address %s: want %s got %s",
-				x, test.remoteAddr.IP, test.want1.IP, got.IP)
+				x, test.remoteAddr.IP(), test.want1.IP(), got.IP())
 			continue
 		}
 	}
@@ -420,37 +445,37 @@ func TestGetBestLocalAddress(t *testing.T) {
 	// Test against want1
 	for x, test := range tests {
 		got := amgr.GetBestLocalAddress(&test.remoteAddr)
-		if !test.want1.IP.Equal(got.IP) {
+		if !test.want1.IP().Equal(got.IP()) {
 			t.Errorf("TestGetBestLocalAddress test1 #%d failed for remote address %s: want %s got %s",
-				x, test.remoteAddr.IP, test.want1.IP, got.IP)
+				x, test.remoteAddr.IP(), test.want1.IP(), got.IP())
 			continue
 		}
 	}
 
 	// Add a public IP to the list of local addresses.
-	localAddr := wire.NetAddress{IP: net.ParseIP("204.124.8.100")}
+	localAddr := *wire.NewNetAddressV2IPPort(net.ParseIP("204.124.8.100"), 0, 0)
 	amgr.AddLocalAddress(&localAddr, addrmgr.InterfacePrio)
 
 	// Test against want2
 	for x, test := range tests {
 		got := amgr.GetBestLocalAddress(&test.remoteAddr)
-		if !test.want2.IP.Equal(got.IP) {
+		if !test.want2.IP().Equal(got.IP()) {
 			t.Errorf("TestGetBestLocalAddress test2 #%d failed for remote address %s: want %s got %s",
-				x, test.remoteAddr.IP, test.want2.IP, got.IP)
+				x, test.remoteAddr.IP(), test.want2.IP(), got.IP())
 			continue
 		}
 	}
 	/*
 		// Add a Tor generated IP address
-		localAddr = wire.NetAddress{IP: net.ParseIP("fd87:d87e:eb43:25::1")}
+		localAddr = *wire.NewNetAddressV2IPPort(net.ParseIP("fd87:d87e:eb43:25::1"), 0, 0)
 		amgr.AddLocalAddress(&localAddr, addrmgr.ManualPrio)
 
 		// Test against want3
 		for x, test := range tests {
 			got := amgr.GetBestLocalAddress(&test.remoteAddr)
-			if !test.want3.IP.Equal(got.IP) {
+			if !test.want3.IP().Equal(got.IP()) {
 				t.Errorf("TestGetBestLocalAddress test3 #%d failed for remote address %s: want %s got %s",
-					x, test.remoteAddr.IP, test.want3.IP, got.IP)
+					x, test.remoteAddr.IP(), test.want3.IP(), got.IP())
 				continue
 			}
 		}
@@ -470,3 +495,54 @@ func TestNetAddressKey(t *testing.T) {
 	}
 
 }
+
+// TestHostToNetAddress ensures Tor and I2P host names are converted to
+// addresses of their respective networks and back to the same key.
+func TestHostToNetAddress(t *testing.T) {
+	n := addrmgr.New("testhosttonetaddress", lookupFunc)
+
+	tests := []struct {
+		host  string
+		netID wire.NetworkID
+		valid bool
+	}{
+		{"ryugyn6yrno5f3rb.onion", wire.NetIDTorV2, true},
+		{"pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion",
+			wire.NetIDTorV3, true},
+		// Invalid checksum.
+		{"pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscrya.onion",
+			wire.NetIDTorV3, false},
+		{"ukeu3k5oycgaauneqgtnvselmt4yemvoilkln7jpvamvfx7dnkdq.b32.i2p",
+			wire.NetIDI2P, true},
+		{"173.194.115.66", wire.NetIDIPv4, true},
+	}
+
+	for _, test := range tests {
+		na, err := n.HostToNetAddress(test.host, 8333, wire.SFNodeNetwork)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("HostToNetAddress %s: expected error",
+					test.host)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("HostToNetAddress %s: unexpected error: %v",
+				test.host, err)
+			continue
+		}
+		if na.NetID != test.netID {
+			t.Errorf("HostToNetAddress %s: got network %v, want %v",
+				test.host, na.NetID, test.netID)
+		}
+		if !addrmgr.IsRoutable(na) {
+			t.Errorf("HostToNetAddress %s: address is not routable",
+				test.host)
+		}
+		want := net.JoinHostPort(test.host, "8333")
+		if key := addrmgr.NetAddressKey(na); key != want {
+			t.Errorf("NetAddressKey %s: got %s, want %s", test.host,
+				key, want)
+		}
+	}
+}
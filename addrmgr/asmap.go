@@ -0,0 +1,350 @@
+// Copyright (c) 2013-2015 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package addrmgr
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/bits"
+	"net"
+
+	"github.com/organicbitcoin/obtcd/wire"
+)
+
+// asmapInvalid is returned by the asmap decoding functions when a value
+// straddles the end of the map.
+const asmapInvalid = 0xffffffff
+
+// asmapInstruction is an instruction of the program an asmap is encoded as.
+type asmapInstruction uint32
+
+const (
+	asmapReturn asmapInstruction = iota
+	asmapJump
+	asmapMatch
+	asmapDefault
+)
+
+// The bit sizes of the variable length encodings of the instruction types,
+// ASNs, match values and jump offsets of an asmap.
+var (
+	asmapTypeBitSizes  = []uint8{0, 0, 1}
+	asmapASNBitSizes   = []uint8{15, 16, 17, 18, 19, 20, 21, 22, 23, 24}
+	asmapMatchBitSizes = []uint8{1, 2, 3, 4, 5, 6, 7, 8}
+	asmapJumpBitSizes  = []uint8{5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16,
+		17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30}
+)
+
+// ErrInvalidASMap describes an error due to an asmap that is not well formed.
+var ErrInvalidASMap = errors.New("invalid asmap")
+
+// ASMap maps IP addresses to the autonomous system (AS) that announces them.
+// It uses the compact binary encoding produced by the asmap tool of Bitcoin
+// Core, which represents the map as a program that is executed against the
+// bits of an IPv6 address (IPv4 addresses are mapped into IPv6) and returns
+// the autonomous system number (ASN).
+//
+// The address manager groups addresses by the ASN they belong to when an
+// ASMap is in use, which prevents an attacker that controls many address
+// ranges within a single network from filling the address tables or being
+// selected for many outbound connections.
+type ASMap struct {
+	bits []bool
+}
+
+// asmapReader decodes the values of an asmap starting at a bit position.
+type asmapReader struct {
+	bits []bool
+	pos  int
+}
+
+// decodeBits decodes a value using the variable length encoding of the asmap
+// format.  The value starts at minVal and for each of the passed bit sizes, a
+// set bit means the value is larger than can be represented with that size.
+// The mantissa follows the first unset bit.
+func (r *asmapReader) decodeBits(minVal uint32, bitSizes []uint8) uint32 {
+	val := minVal
+	for i, size := range bitSizes {
+		var bit bool
+		if i+1 != len(bitSizes) {
+			if r.pos == len(r.bits) {
+				break
+			}
+			bit = r.bits[r.pos]
+			r.pos++
+		}
+		if bit {
+			val += 1 << size
+			continue
+		}
+
+		for b := uint8(0); b < size; b++ {
+			if r.pos == len(r.bits) {
+				return asmapInvalid
+			}
+			if r.bits[r.pos] {
+				val += 1 << (size - 1 - b)
+			}
+			r.pos++
+		}
+		return val
+	}
+	return asmapInvalid
+}
+
+func (r *asmapReader) decodeType() asmapInstruction {
+	return asmapInstruction(r.decodeBits(0, asmapTypeBitSizes))
+}
+
+func (r *asmapReader) decodeASN() uint32 {
+	return r.decodeBits(1, asmapASNBitSizes)
+}
+
+func (r *asmapReader) decodeMatch() uint32 {
+	return r.decodeBits(2, asmapMatchBitSizes)
+}
+
+func (r *asmapReader) decodeJump() uint32 {
+	return r.decodeBits(17, asmapJumpBitSizes)
+}
+
+// checkASMap returns whether the passed bits form a well formed asmap program
+// for inputs of the passed number of bits.  This ensures executing the program
+// always terminates with a RETURN instruction.
+func checkASMap(asmap []bool, inputBits int) bool {
+	type jump struct {
+		offset int
+		bits   int
+	}
+	var jumps []jump
+
+	r := asmapReader{bits: asmap}
+	prevOpcode := asmapJump
+	hadIncompleteMatch := false
+	for r.pos != len(asmap) {
+		// Jumping into the middle of the previous instruction is invalid.
+		if len(jumps) != 0 && r.pos >= jumps[len(jumps)-1].offset {
+			return false
+		}
+
+		switch opcode := r.decodeType(); opcode {
+		case asmapReturn:
+			// A RETURN immediately after a DEFAULT could be combined
+			// into a single RETURN.
+			if prevOpcode == asmapDefault {
+				return false
+			}
+			if r.decodeASN() == asmapInvalid {
+				return false
+			}
+			if len(jumps) == 0 {
+				// Nothing left to execute, so only up to seven
+				// zero bits of padding may remain.
+				if len(asmap)-r.pos > 7 {
+					return false
+				}
+				for ; r.pos != len(asmap); r.pos++ {
+					if asmap[r.pos] {
+						return false
+					}
+				}
+				return true
+			}
+
+			// Continue as if the latest jump was taken, which must
+			// lead to the next instruction.
+			last := jumps[len(jumps)-1]
+			if r.pos != last.offset {
+				return false
+			}
+			inputBits = last.bits
+			jumps = jumps[:len(jumps)-1]
+			prevOpcode = asmapJump
+
+		case asmapJump:
+			offset := r.decodeJump()
+			if offset == asmapInvalid {
+				return false
+			}
+			if int64(offset) > int64(len(asmap)-r.pos) {
+				return false
+			}
+			if inputBits == 0 {
+				return false
+			}
+			inputBits--
+			target := r.pos + int(offset)
+			if len(jumps) != 0 && target >= jumps[len(jumps)-1].offset {
+				return false
+			}
+			jumps = append(jumps, jump{offset: target, bits: inputBits})
+			prevOpcode = asmapJump
+
+		case asmapMatch:
+			match := r.decodeMatch()
+			if match == asmapInvalid {
+				return false
+			}
+			matchLen := bits.Len32(match) - 1
+			if prevOpcode != asmapMatch {
+				hadIncompleteMatch = false
+			}
+
+			// Only a single match within a sequence of matches may
+			// be shorter than eight bits.
+			if matchLen < 8 && hadIncompleteMatch {
+				return false
+			}
+			hadIncompleteMatch = matchLen < 8
+			if inputBits < matchLen {
+				return false
+			}
+			inputBits -= matchLen
+			prevOpcode = asmapMatch
+
+		case asmapDefault:
+			if prevOpcode == asmapDefault {
+				return false
+			}
+			if r.decodeASN() == asmapInvalid {
+				return false
+			}
+			prevOpcode = asmapDefault
+
+		default:
+			return false
+		}
+	}
+
+	// The end was reached without a RETURN instruction.
+	return false
+}
+
+// NewASMap returns the asmap encoded by the passed bytes.  The bits of each
+// byte are read starting with the least significant bit.  ErrInvalidASMap is
+// returned when the encoding is not well formed.
+func NewASMap(data []byte) (*ASMap, error) {
+	asmap := make([]bool, 0, len(data)*8)
+	for _, b := range data {
+		for bit := uint(0); bit < 8; bit++ {
+			asmap = append(asmap, (b>>bit)&1 == 1)
+		}
+	}
+	if !checkASMap(asmap, 128) {
+		return nil, ErrInvalidASMap
+	}
+	return &ASMap{bits: asmap}, nil
+}
+
+// LoadASMap reads and decodes the asmap file at the passed path.
+func LoadASMap(path string) (*ASMap, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	asmap, err := NewASMap(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return asmap, nil
+}
+
+// Lookup returns the ASN the passed IP address belongs to or zero if it is not
+// part of any autonomous system in the map.
+func (m *ASMap) Lookup(ip net.IP) uint32 {
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return 0
+	}
+	input := make([]bool, 0, 128)
+	for _, b := range ip16 {
+		for bit := uint(0); bit < 8; bit++ {
+			input = append(input, (b>>(7-bit))&1 == 1)
+		}
+	}
+
+	r := asmapReader{bits: m.bits}
+	remaining := len(input)
+	defaultASN := uint32(0)
+	for r.pos != len(m.bits) {
+		switch r.decodeType() {
+		case asmapReturn:
+			asn := r.decodeASN()
+			if asn == asmapInvalid {
+				return 0
+			}
+			return asn
+
+		case asmapJump:
+			offset := r.decodeJump()
+			if offset == asmapInvalid || remaining == 0 ||
+				int64(offset) >= int64(len(m.bits)-r.pos) {
+
+				return 0
+			}
+			if input[len(input)-remaining] {
+				r.pos += int(offset)
+			}
+			remaining--
+
+		case asmapMatch:
+			match := r.decodeMatch()
+			if match == asmapInvalid {
+				return 0
+			}
+			matchLen := bits.Len32(match) - 1
+			if remaining < matchLen {
+				return 0
+			}
+			for bit := 0; bit < matchLen; bit++ {
+				want := (match>>uint(matchLen-1-bit))&1 == 1
+				if input[len(input)-remaining] != want {
+					return defaultASN
+				}
+				remaining--
+			}
+
+		case asmapDefault:
+			defaultASN = r.decodeASN()
+			if defaultASN == asmapInvalid {
+				return 0
+			}
+
+		default:
+			return 0
+		}
+	}
+
+	// Well formed maps always end with a RETURN instruction.
+	return 0
+}
+
+// mappedIP returns the IP address of the passed address which is looked up in
+// an asmap.  This is the embedded IPv4 address for IPv6 addresses which tunnel
+// or translate IPv4 addresses.  Nil is returned for addresses of overlay
+// networks which have no IP address.
+func mappedIP(na *wire.NetAddressV2) net.IP {
+	if na.NetID != wire.NetIDIPv4 && na.NetID != wire.NetIDIPv6 {
+		return nil
+	}
+
+	ip := na.IP()
+	switch {
+	case IsIPv4(na):
+		return ip.To16()
+	case IsRFC6145(na) || IsRFC6052(na):
+		return net.IP(ip[12:16]).To16()
+	case IsRFC3964(na):
+		return net.IP(ip[2:6]).To16()
+	case IsRFC4380(na):
+		v4 := make(net.IP, 4)
+		for i, b := range ip[12:16] {
+			v4[i] = b ^ 0xff
+		}
+		return v4.To16()
+	}
+	return ip
+}
@@ -0,0 +1,196 @@
+// Copyright (c) 2013-2015 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package addrmgr
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/organicbitcoin/obtcd/wire"
+)
+
+// asmapWriter builds asmap programs for tests.
+type asmapWriter struct {
+	bits []bool
+}
+
+// encodeBits appends a value using the variable length encoding the asmap
+// decoder expects.
+func (w *asmapWriter) encodeBits(val, minVal uint32, bitSizes []uint8) {
+	val -= minVal
+	for i, size := range bitSizes {
+		if i+1 != len(bitSizes) {
+			if val >= 1<<size {
+				w.bits = append(w.bits, true)
+				val -= 1 << size
+				continue
+			}
+			w.bits = append(w.bits, false)
+		}
+		for b := uint8(0); b < size; b++ {
+			w.bits = append(w.bits, (val>>(size-1-b))&1 == 1)
+		}
+		return
+	}
+}
+
+func (w *asmapWriter) ret(asn uint32) {
+	w.encodeBits(uint32(asmapReturn), 0, asmapTypeBitSizes)
+	w.encodeBits(asn, 1, asmapASNBitSizes)
+}
+
+func (w *asmapWriter) jump(offset uint32) {
+	w.encodeBits(uint32(asmapJump), 0, asmapTypeBitSizes)
+	w.encodeBits(offset, 17, asmapJumpBitSizes)
+}
+
+func (w *asmapWriter) defaultASN(asn uint32) {
+	w.encodeBits(uint32(asmapDefault), 0, asmapTypeBitSizes)
+	w.encodeBits(asn, 1, asmapASNBitSizes)
+}
+
+// match appends instructions matching the passed bytes of the input.
+func (w *asmapWriter) match(prefix ...byte) {
+	for _, b := range prefix {
+		w.encodeBits(uint32(asmapMatch), 0, asmapTypeBitSizes)
+		w.encodeBits(0x100|uint32(b), 2, asmapMatchBitSizes)
+	}
+}
+
+// bytes returns the program packed into bytes starting with the least
+// significant bit of each byte.
+func (w *asmapWriter) bytes() []byte {
+	data := make([]byte, (len(w.bits)+7)/8)
+	for i, bit := range w.bits {
+		if bit {
+			data[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return data
+}
+
+// ipv4Prefix is the prefix of IPv4 addresses mapped into IPv6.
+var ipv4Prefix = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff}
+
+// testASMap returns an asmap which maps 200.0.0.0/8 to AS 666 and no other
+// addresses.
+func testASMap(t *testing.T) *ASMap {
+	t.Helper()
+
+	var w asmapWriter
+	w.match(ipv4Prefix...)
+	w.match(200)
+	w.ret(666)
+	asmap, err := NewASMap(w.bytes())
+	if err != nil {
+		t.Fatalf("NewASMap: %v", err)
+	}
+	return asmap
+}
+
+// TestASMapLookup ensures addresses are mapped to the expected autonomous
+// systems.
+func TestASMapLookup(t *testing.T) {
+	// Map IPv4 addresses in 0.0.0.0/1 to AS 1, those in 128.0.0.0/1 to
+	// AS 2 and everything else to AS 7.
+	var w asmapWriter
+	w.defaultASN(7)
+	w.match(ipv4Prefix...)
+	var ret asmapWriter
+	ret.ret(1)
+	w.jump(uint32(len(ret.bits)))
+	w.ret(1)
+	w.ret(2)
+	jumpMap, err := NewASMap(w.bytes())
+	if err != nil {
+		t.Fatalf("NewASMap: %v", err)
+	}
+
+	tests := []struct {
+		asmap *ASMap
+		ip    string
+		want  uint32
+	}{
+		{jumpMap, "1.2.3.4", 1},
+		{jumpMap, "127.255.255.255", 1},
+		{jumpMap, "128.0.0.1", 2},
+		{jumpMap, "200.1.1.1", 2},
+		{jumpMap, "2001:db8::1", 7},
+		{testASMap(t), "200.1.2.3", 666},
+		{testASMap(t), "201.1.2.3", 0},
+		{testASMap(t), "2001:db8::1", 0},
+	}
+	for i, test := range tests {
+		got := test.asmap.Lookup(net.ParseIP(test.ip))
+		if got != test.want {
+			t.Errorf("#%d: Lookup(%s) = %d, want %d", i, test.ip, got,
+				test.want)
+		}
+	}
+}
+
+// TestASMapInvalid ensures malformed asmaps are rejected.
+func TestASMapInvalid(t *testing.T) {
+	var truncated asmapWriter
+	truncated.match(ipv4Prefix...)
+	truncated.ret(666)
+	data := truncated.bytes()
+
+	var noReturn asmapWriter
+	noReturn.match(ipv4Prefix...)
+
+	var doubleDefault asmapWriter
+	doubleDefault.defaultASN(1)
+	doubleDefault.defaultASN(2)
+	doubleDefault.ret(3)
+
+	tests := [][]byte{
+		nil,
+		data[:len(data)-1],
+		noReturn.bytes(),
+		doubleDefault.bytes(),
+		append(append([]byte(nil), data...), 0xff),
+	}
+	for i, test := range tests {
+		if _, err := NewASMap(test); err != ErrInvalidASMap {
+			t.Errorf("#%d: unexpected error - got %v, want %v", i,
+				err, ErrInvalidASMap)
+		}
+	}
+}
+
+// TestGroupKeyASMap ensures the address manager groups addresses by autonomous
+// system when an asmap is in use.
+func TestGroupKeyASMap(t *testing.T) {
+	amgr := New("", nil)
+	addr := func(ip string) *wire.NetAddressV2 {
+		return wire.NewNetAddressV2IPPort(net.ParseIP(ip), 8333, 0)
+	}
+	tor := wire.NewNetAddressV2(time.Now(), 0, wire.NetIDTorV3,
+		make([]byte, 32), 8333)
+
+	if got := amgr.GroupKey(addr("200.1.2.3")); got != "200.1.0.0" {
+		t.Fatalf("unexpected group without asmap %q", got)
+	}
+
+	amgr.UseASMap(testASMap(t))
+	tests := []struct {
+		na   *wire.NetAddressV2
+		want string
+	}{
+		{addr("200.1.2.3"), "as666"},
+		{addr("200.200.2.3"), "as666"},
+		{addr("2002:c801:0203::1"), "as666"},
+		{addr("201.1.2.3"), "201.1.0.0"},
+		{tor, GroupKey(tor)},
+	}
+	for i, test := range tests {
+		if got := amgr.GroupKey(test.na); got != test.want {
+			t.Errorf("#%d: GroupKey(%v) = %q, want %q", i, test.na,
+				got, test.want)
+		}
+	}
+}
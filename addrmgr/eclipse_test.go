@@ -0,0 +1,202 @@
+// Copyright (c) 2013-2015 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package addrmgr
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/organicbitcoin/obtcd/wire"
+)
+
+// eclipseHarness simulates an address manager whose new table is being filled
+// by an attacker.  Honest addresses, some of which have been connected to, are
+// spread over many network groups.  The attacker controls a handful of source
+// addresses which announce a flood of fresher addresses in the network groups
+// the attacker runs nodes in.
+type eclipseHarness struct {
+	amgr     *AddrManager
+	honest   map[string]struct{}
+	attacker map[string]struct{}
+}
+
+// Parameters of the simulated address tables.
+const (
+	numHonestAddrs      = 400
+	numHonestTried      = 64
+	numAttackerSources  = 4
+	attackerAddrsPerSrc = 5000
+)
+
+// ipAddr returns an address for the passed IPv4 octets.
+func ipAddr(a, b, c, d byte, ts time.Time) *wire.NetAddressV2 {
+	na := wire.NewNetAddressV2IPPort(net.IPv4(a, b, c, d), 8333,
+		wire.SFNodeNetwork)
+	na.Timestamp = ts
+	return na
+}
+
+// newEclipseHarness returns a harness with the honest addresses added and the
+// attacker addresses of the passed network groups, given by the second octet
+// of addresses in 200.0.0.0/8, announced.  An asmap is used when passed.
+func newEclipseHarness(t *testing.T, attackerGroups int, asmap *ASMap) *eclipseHarness {
+	t.Helper()
+
+	h := &eclipseHarness{
+		amgr:     New("", nil),
+		honest:   make(map[string]struct{}),
+		attacker: make(map[string]struct{}),
+	}
+	if asmap != nil {
+		h.amgr.UseASMap(asmap)
+	}
+
+	// Each honest address is in a distinct /16 and announced by a peer in
+	// yet another one.
+	old := time.Now().Add(-time.Hour)
+	for i := 0; i < numHonestAddrs; i++ {
+		na := ipAddr(byte(1+i/200), byte(i%200), 1, 1, old)
+		src := ipAddr(byte(60+i/200), byte(i%200), 1, 1, old)
+		h.amgr.AddAddress(na, src)
+		if i < numHonestTried {
+			h.amgr.Good(na)
+		}
+		h.honest[NetAddressKey(na)] = struct{}{}
+	}
+
+	// The attacker addresses are newer, so they win whenever a new bucket
+	// needs to make room.
+	now := time.Now()
+	for s := 0; s < numAttackerSources; s++ {
+		src := ipAddr(210, byte(s), 1, 1, now)
+		for i := 0; i < attackerAddrsPerSrc; i++ {
+			na := ipAddr(200, byte(i%attackerGroups), byte(i/256),
+				byte(i), now)
+			h.amgr.AddAddress(na, src)
+			h.attacker[NetAddressKey(na)] = struct{}{}
+		}
+	}
+	return h
+}
+
+// selectOutbound mimics the outbound peer selection of the server, which picks
+// addresses from distinct network groups, and returns the number of honest and
+// attacker addresses selected for the passed number of slots.
+func (h *eclipseHarness) selectOutbound(slots int) (int, int) {
+	groups := make(map[string]struct{})
+	var honest, attacker int
+	for slot := 0; slot < slots; slot++ {
+		for tries := 0; tries < 100; tries++ {
+			ka := h.amgr.GetAddress()
+			key := h.amgr.GroupKey(ka.NetAddress())
+			if _, ok := groups[key]; ok {
+				continue
+			}
+			groups[key] = struct{}{}
+
+			addrKey := NetAddressKey(ka.NetAddress())
+			if _, ok := h.attacker[addrKey]; ok {
+				attacker++
+			} else {
+				honest++
+			}
+			break
+		}
+	}
+	return honest, attacker
+}
+
+// TestEclipseNewTable ensures an attacker announcing a flood of addresses from
+// a few source groups is confined to a limited portion of the new table and
+// is unable to push the honest addresses out of the tried table.
+func TestEclipseNewTable(t *testing.T) {
+	h := newEclipseHarness(t, 16, nil)
+	amgr := h.amgr
+
+	attackerBuckets := 0
+	honestNew := 0
+	for _, bucket := range amgr.addrNew {
+		hasAttacker := false
+		for key := range bucket {
+			if _, ok := h.attacker[key]; ok {
+				hasAttacker = true
+			} else {
+				honestNew++
+			}
+		}
+		if hasAttacker {
+			attackerBuckets++
+		}
+	}
+
+	// Addresses from a single source group are spread over a limited
+	// number of new buckets.
+	maxBuckets := numAttackerSources * newBucketsPerGroup
+	if attackerBuckets > maxBuckets {
+		t.Fatalf("attacker occupies %d new buckets, want at most %d",
+			attackerBuckets, maxBuckets)
+	}
+
+	// Most of the honest addresses which have not been connected to
+	// remain in the new table.
+	wantNew := (numHonestAddrs - numHonestTried) / 2
+	if honestNew < wantNew {
+		t.Fatalf("%d honest addresses remain in the new table, want "+
+			"at least %d", honestNew, wantNew)
+	}
+
+	// The tried table only contains the honest addresses.
+	if amgr.nTried != numHonestTried {
+		t.Fatalf("unexpected number of tried addresses - got %d, "+
+			"want %d", amgr.nTried, numHonestTried)
+	}
+	for _, bucket := range amgr.addrTried {
+		for e := bucket.Front(); e != nil; e = e.Next() {
+			key := NetAddressKey(e.Value.(*KnownAddress).na)
+			if _, ok := h.honest[key]; !ok {
+				t.Fatalf("attacker address %s in tried table", key)
+			}
+		}
+	}
+}
+
+// TestEclipseOutboundSelection ensures selecting outbound peers from distinct
+// network groups bounds the number of slots the attacker can take by the
+// number of network groups it runs nodes in, and that grouping by autonomous
+// system bounds it further when the attacker's groups share one.
+func TestEclipseOutboundSelection(t *testing.T) {
+	const slots = 10
+	const attackerGroups = 4
+
+	h := newEclipseHarness(t, attackerGroups, nil)
+	for i := 0; i < 50; i++ {
+		honest, attacker := h.selectOutbound(slots)
+		if attacker > attackerGroups {
+			t.Fatalf("attacker got %d outbound slots, want at most %d",
+				attacker, attackerGroups)
+		}
+		if honest+attacker != slots {
+			t.Fatalf("selected %d addresses, want %d",
+				honest+attacker, slots)
+		}
+	}
+
+	// All of 200.0.0.0/8 is a single autonomous system in the test asmap,
+	// so the attacker is limited to one slot regardless of how many /16s
+	// it announces addresses in.
+	h = newEclipseHarness(t, 64, testASMap(t))
+	for i := 0; i < 50; i++ {
+		honest, attacker := h.selectOutbound(slots)
+		if attacker > 1 {
+			t.Fatalf("attacker got %d outbound slots with asmap, "+
+				"want at most 1", attacker)
+		}
+		if honest+attacker != slots {
+			t.Fatalf("selected %d addresses, want %d",
+				honest+attacker, slots)
+		}
+	}
+}
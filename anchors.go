@@ -0,0 +1,77 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// anchorsFilename is the name of the file in the data directory which holds
+// the addresses of the block-relay-only peers connected to at shutdown.
+const anchorsFilename = "anchors.json"
+
+// saveAnchors writes the passed addresses of block-relay-only peers to the
+// anchors file so they are reconnected to on the next start.  Reconnecting to
+// the same peers makes it harder for an attacker to partition the node by
+// forcing it to restart.
+func saveAnchors(dataDir string, addrs []string) {
+	filePath := filepath.Join(dataDir, anchorsFilename)
+	w, err := os.Create(filePath)
+	if err != nil {
+		srvrLog.Errorf("Error opening file %s: %v", filePath, err)
+		return
+	}
+	enc := json.NewEncoder(w)
+	defer w.Close()
+	if err := enc.Encode(addrs); err != nil {
+		srvrLog.Errorf("Failed to encode file %s: %v", filePath, err)
+		return
+	}
+}
+
+// loadAnchors returns up to max addresses from the anchors file and removes
+// the file so the anchors are only tried once even when the node is not shut
+// down cleanly.
+func loadAnchors(dataDir string, max int) []net.Addr {
+	filePath := filepath.Join(dataDir, anchorsFilename)
+	r, err := os.Open(filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			srvrLog.Warnf("Error opening file %s: %v", filePath, err)
+		}
+		return nil
+	}
+	var addrs []string
+	err = json.NewDecoder(r).Decode(&addrs)
+	r.Close()
+	if err := os.Remove(filePath); err != nil {
+		srvrLog.Warnf("Failed to remove %s: %v", filePath, err)
+	}
+	if err != nil {
+		srvrLog.Warnf("Failed to decode file %s: %v", filePath, err)
+		return nil
+	}
+
+	anchors := make([]net.Addr, 0, len(addrs))
+	for _, addr := range addrs {
+		if len(anchors) == max {
+			break
+		}
+		netAddr, err := addrStringToNetAddr(addr)
+		if err != nil {
+			srvrLog.Debugf("Skipping anchor %s: %v", addr, err)
+			continue
+		}
+		anchors = append(anchors, netAddr)
+	}
+	if len(anchors) > 0 {
+		srvrLog.Infof("Loaded %d anchor %s", len(anchors),
+			pickNoun(uint64(len(anchors)), "connection", "connections"))
+	}
+	return anchors
+}
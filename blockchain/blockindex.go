@@ -232,6 +232,11 @@ type blockIndex struct {
 	sync.RWMutex
 	index map[chainhash.Hash]*blockNode
 	dirty map[*blockNode]struct{}
+
+	// bestHeader is the node with the most cumulative work which was not
+	// known to be invalid when it was added, whether or not its block is
+	// available.
+	bestHeader *blockNode
 }
 
 // newBlockIndex returns a new empty instance of a block index.  The index will
@@ -284,6 +289,23 @@ func (bi *blockIndex) AddNode(node *blockNode) {
 // This function is NOT safe for concurrent access.
 func (bi *blockIndex) addNode(node *blockNode) {
 	bi.index[node.hash] = node
+
+	if !node.status.KnownInvalid() && (bi.bestHeader == nil ||
+		node.workSum.Cmp(bi.bestHeader.workSum) > 0) {
+
+		bi.bestHeader = node
+	}
+}
+
+// BestHeader returns the node with the most cumulative work in the block index
+// which was not known to be invalid when it was added.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) BestHeader() *blockNode {
+	bi.RLock()
+	node := bi.bestHeader
+	bi.RUnlock()
+	return node
 }
 
 // NodeStatus provides concurrent-safe access to the status field of a node.
@@ -8,6 +8,7 @@ package blockchain
 import (
 	"container/list"
 	"fmt"
+	"math/big"
 	"sync"
 	"time"
 
@@ -102,12 +103,25 @@ type BlockChain struct {
 	// separate mutex.
 	checkpoints         []chaincfg.Checkpoint
 	checkpointsByHeight map[int32]*chaincfg.Checkpoint
+	assumeValid         *chainhash.Hash
 	db                  database.DB
 	chainParams         *chaincfg.Params
 	timeSource          MedianTimeSource
 	sigCache            *txscript.SigCache
 	indexManager        IndexManager
 	hashCache           *txscript.HashCache
+	utxoCache           *utxoCache
+
+	// snapshotBase is the base block of the utxo snapshot the chain state
+	// was loaded from or nil when it was not loaded from one.  It is set
+	// when the instance is created and can't be changed afterwards.
+	snapshotBase *blockNode
+
+	// snapshotTaxSweepHeight is the highest height of the utxos swept by
+	// the tax transactions of the latest block up to the base block of the
+	// utxo snapshot which contains any, or -1 when there is none.  It is
+	// set along with snapshotBase.
+	snapshotTaxSweepHeight int32
 
 	// The following fields are calculated based upon the provided chain
 	// parameters.  They are also set when the instance is created and
@@ -145,6 +159,11 @@ type BlockChain struct {
 	nextCheckpoint *chaincfg.Checkpoint
 	checkpointNode *blockNode
 
+	// snapshotValidated indicates whether the history up to the base block
+	// of the utxo snapshot the chain state was loaded from has been
+	// validated.  It is protected by the chain lock.
+	snapshotValidated bool
+
 	// The state is used as a fairly efficient way to cache information
 	// about the current best chain state that is returned to callers when
 	// requested.  It operates on the principle of MVCC such that any time a
@@ -629,14 +648,6 @@ func (b *BlockChain) connectBlock(node *blockNode, block *btcutil.Block,
 			return err
 		}
 
-		// Update the utxo set using the state of the utxo view.  This
-		// entails removing all of the utxos spent and adding the new
-		// ones created by the block.
-		err = dbPutUtxoView(dbTx, view)
-		if err != nil {
-			return err
-		}
-
 		// Update the transaction spend journal by adding a record for
 		// the block that contains all txos spent by it.
 		err = dbPutSpendJournalEntry(dbTx, block.Hash(), stxos)
@@ -660,13 +671,26 @@ func (b *BlockChain) connectBlock(node *blockNode, block *btcutil.Block,
 		return err
 	}
 
+	// Update the utxo cache using the state of the utxo view.  This entails
+	// removing all of the utxos spent and adding the new ones created by
+	// the block.  The changes are written to the database once the cache
+	// is flushed, and are replayed from the block on startup otherwise.
+	b.utxoCache.commit(view)
+
 	// Prune fully spent entries and mark all entries in the view unmodified
-	// now that the modifications have been committed to the database.
+	// now that the modifications have been committed to the cache.
 	view.commit()
 
 	// This node is now the end of the best chain.
 	b.bestChain.SetTip(node)
 
+	// Flush the utxo cache when it is full or has not been flushed for a
+	// while.
+	err = b.utxoCache.maybeFlush(&node.hash)
+	if err != nil {
+		return err
+	}
+
 	// Update the state for the best block.  Notice how this replaces the
 	// entire struct instead of updating the existing one.  This effectively
 	// allows the old version to act as a snapshot which callers can use
@@ -727,6 +751,11 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *btcutil.Block, view
 	state := newBestState(prevNode, blockSize, blockWeight, numTxns,
 		newTotalTxns, prevNode.CalcPastMedianTime())
 
+	// Disconnecting a block is rare, so the utxo set changes made by it are
+	// written to the database right away along with the rest of the utxo
+	// cache instead of being replayed on startup.
+	b.utxoCache.commit(view)
+
 	err = b.db.Update(func(dbTx database.Tx) error {
 		// Update best block state.
 		err := dbPutBestState(dbTx, state, node.workSum)
@@ -744,7 +773,7 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *btcutil.Block, view
 		// Update the utxo set using the state of the utxo view.  This
 		// entails restoring all of the utxos spent and removing the new
 		// ones created by the block.
-		err = dbPutUtxoView(dbTx, view)
+		err = b.utxoCache.writeToDB(dbTx, &prevNode.hash)
 		if err != nil {
 			return err
 		}
@@ -778,6 +807,7 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *btcutil.Block, view
 	if err != nil {
 		return err
 	}
+	b.utxoCache.markFlushed()
 
 	// Prune fully spent entries and mark all entries in the view unmodified
 	// now that the modifications have been committed to the database.
@@ -855,6 +885,16 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 		}
 	}
 
+	// Flush the utxo cache before disconnecting any blocks since restoring
+	// the outputs spent by them might need to search the utxo set in the
+	// database, and so the utxo set is never consistent with a block which
+	// is no longer in the main chain.
+	if detachNodes.Len() != 0 {
+		if err := b.utxoCache.flush(&tip.hash); err != nil {
+			return err
+		}
+	}
+
 	// Track the old and new best chains heads.
 	oldBest := tip
 	newBest := tip
@@ -893,7 +933,7 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 
 		// Load all of the utxos referenced by the block that aren't
 		// already in the view.
-		err = view.fetchInputUtxos(b.db, block)
+		err = view.fetchInputUtxos(b.utxoCache, block, b.chainParams)
 		if err != nil {
 			return err
 		}
@@ -913,7 +953,12 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 		detachBlocks = append(detachBlocks, block)
 		detachSpentTxOuts = append(detachSpentTxOuts, stxos)
 
-		err = view.disconnectTransactions(b.db, block, stxos)
+		keepBurned, err := b.keepsBurnedOutputs(n)
+		if err != nil {
+			return err
+		}
+		err = view.disconnectTransactions(b.db, block, stxos,
+			b.chainParams, keepBurned)
 		if err != nil {
 			return err
 		}
@@ -960,11 +1005,16 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 		// checkConnectBlock gets skipped, we still need to update the UTXO
 		// view.
 		if b.index.NodeStatus(n).KnownValid() {
-			err = view.fetchInputUtxos(b.db, block)
+			err = view.fetchInputUtxos(b.utxoCache, block, b.chainParams)
 			if err != nil {
 				return err
 			}
-			err = view.connectTransactions(block, nil)
+			keepBurned, err := b.keepsBurnedOutputs(n)
+			if err != nil {
+				return err
+			}
+			err = view.connectTransactions(block, nil,
+				b.chainParams, keepBurned)
 			if err != nil {
 				return err
 			}
@@ -1012,15 +1062,19 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 
 		// Load all of the utxos referenced by the block that aren't
 		// already in the view.
-		err := view.fetchInputUtxos(b.db, block)
+		err := view.fetchInputUtxos(b.utxoCache, block, b.chainParams)
 		if err != nil {
 			return err
 		}
 
 		// Update the view to unspend all of the spent txos and remove
 		// the utxos created by the block.
+		keepBurned, err := b.keepsBurnedOutputs(n)
+		if err != nil {
+			return err
+		}
 		err = view.disconnectTransactions(b.db, block,
-			detachSpentTxOuts[i])
+			detachSpentTxOuts[i], b.chainParams, keepBurned)
 		if err != nil {
 			return err
 		}
@@ -1039,7 +1093,7 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 
 		// Load all of the utxos referenced by the block that aren't
 		// already in the view.
-		err := view.fetchInputUtxos(b.db, block)
+		err := view.fetchInputUtxos(b.utxoCache, block, b.chainParams)
 		if err != nil {
 			return err
 		}
@@ -1048,8 +1102,13 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 		// as spent and add all transactions being created by this block
 		// to it.  Also, provide an stxo slice so the spent txout
 		// details are generated.
+		keepBurned, err := b.keepsBurnedOutputs(n)
+		if err != nil {
+			return err
+		}
 		stxos := make([]SpentTxOut, 0, countSpentOutputs(block))
-		err = view.connectTransactions(block, &stxos)
+		err = view.connectTransactions(block, &stxos, b.chainParams,
+			keepBurned)
 		if err != nil {
 			return err
 		}
@@ -1138,11 +1197,16 @@ func (b *BlockChain) connectBestChain(node *blockNode, block *btcutil.Block, fla
 		// utxos, spend them, and add the new utxos being created by
 		// this block.
 		if fastAdd {
-			err := view.fetchInputUtxos(b.db, block)
+			err := view.fetchInputUtxos(b.utxoCache, block, b.chainParams)
+			if err != nil {
+				return false, err
+			}
+			keepBurned, err := b.keepsBurnedOutputs(node)
 			if err != nil {
 				return false, err
 			}
-			err = view.connectTransactions(block, &stxos)
+			err = view.connectTransactions(block, &stxos, b.chainParams,
+				keepBurned)
 			if err != nil {
 				return false, err
 			}
@@ -1226,6 +1290,7 @@ func (b *BlockChain) connectBestChain(node *blockNode, block *btcutil.Block, fla
 // factors are used to guess, but the key factors that allow the chain to
 // believe it is current are:
 //  - Latest block height is after the latest checkpoint (if enabled)
+//  - Best chain has at least the minimum chain work of the network (if set)
 //  - Latest block has a timestamp newer than 24 hours ago
 //
 // This function MUST be called with the chain state lock held (for reads).
@@ -1237,6 +1302,15 @@ func (b *BlockChain) isCurrent() bool {
 		return false
 	}
 
+	// Not current if the best chain has less work than the minimum a chain
+	// of the network is known to have (when set).  This prevents a node
+	// which was only served a low-work chain by its peers from believing it
+	// is synced.
+	minWork := b.chainParams.MinimumChainWork
+	if minWork != nil && b.bestChain.Tip().workSum.Cmp(minWork) < 0 {
+		return false
+	}
+
 	// Not current if the latest best block has a timestamp before 24 hours
 	// ago.
 	//
@@ -1250,6 +1324,7 @@ func (b *BlockChain) isCurrent() bool {
 // factors are used to guess, but the key factors that allow the chain to
 // believe it is current are:
 //  - Latest block height is after the latest checkpoint (if enabled)
+//  - Best chain has at least the minimum chain work of the network (if set)
 //  - Latest block has a timestamp newer than 24 hours ago
 //
 // This function is safe for concurrent access.
@@ -1285,6 +1360,19 @@ func (b *BlockChain) HeaderByHash(hash *chainhash.Hash) (wire.BlockHeader, error
 	return node.Header(), nil
 }
 
+// ChainWork returns the total work of the chain ending with the block or block
+// header identified by the given hash or an error if it doesn't exist.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ChainWork(hash *chainhash.Hash) (*big.Int, error) {
+	node := b.index.LookupNode(hash)
+	if node == nil {
+		return nil, fmt.Errorf("block %s is not known", hash)
+	}
+
+	return new(big.Int).Set(node.workSum), nil
+}
+
 // MainChainHasBlock returns whether or not the block with the given hash is in
 // the main chain.
 //
@@ -1661,6 +1749,15 @@ type Config struct {
 	// checkpoints.
 	Checkpoints []chaincfg.Checkpoint
 
+	// AssumeValid is the hash of a block whose ancestors, including the
+	// block itself, are assumed to have valid scripts once it is buried deep
+	// enough in the best known header chain and that chain has at least the
+	// minimum chain work of ChainParams.  Script validation is skipped for
+	// these blocks while all other rules are still enforced.
+	//
+	// This field can be nil if the caller wishes to validate all scripts.
+	AssumeValid *chainhash.Hash
+
 	// TimeSource defines the median time source to use for things such as
 	// block processing and determining whether or not the chain is current.
 	//
@@ -1694,6 +1791,14 @@ type Config struct {
 	// This field can be nil if the caller is not interested in using a
 	// signature cache.
 	HashCache *txscript.HashCache
+
+	// UtxoCacheMaxSize defines the maximum number of bytes the unspent
+	// transaction outputs which are not flushed to the database yet may
+	// use in memory.
+	//
+	// This field can be zero to flush the utxo set changes to the database
+	// after every block.
+	UtxoCacheMaxSize uint64
 }
 
 // New returns a BlockChain instance using the provided configuration details.
@@ -1734,6 +1839,7 @@ func New(config *Config) (*BlockChain, error) {
 	b := BlockChain{
 		checkpoints:         config.Checkpoints,
 		checkpointsByHeight: checkpointsByHeight,
+		assumeValid:         config.AssumeValid,
 		db:                  config.DB,
 		chainParams:         params,
 		timeSource:          config.TimeSource,
@@ -1744,6 +1850,7 @@ func New(config *Config) (*BlockChain, error) {
 		blocksPerRetarget:   int32(targetTimespan / targetTimePerBlock),
 		index:               newBlockIndex(config.DB, params),
 		hashCache:           config.HashCache,
+		utxoCache:           newUtxoCache(config.DB, config.UtxoCacheMaxSize),
 		bestChain:           newChainView(nil),
 		orphans:             make(map[chainhash.Hash]*orphanBlock),
 		prevOrphans:         make(map[chainhash.Hash][]*orphanBlock),
@@ -1763,6 +1870,17 @@ func New(config *Config) (*BlockChain, error) {
 		return nil, err
 	}
 
+	// Load whether the chain state was loaded from a utxo snapshot.
+	if err := b.loadSnapshotBase(); err != nil {
+		return nil, err
+	}
+
+	// Replay the utxo set changes which were not flushed to the database
+	// before the last shutdown, if any.
+	if err := b.initConsistentUtxoState(config.Interrupt); err != nil {
+		return nil, err
+	}
+
 	// Initialize and catch up all of the currently active optional indexes
 	// as needed.
 	if config.IndexManager != nil {
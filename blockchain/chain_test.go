@@ -5,6 +5,7 @@
 package blockchain
 
 import (
+	"math/big"
 	"reflect"
 	"testing"
 	"time"
@@ -111,6 +112,81 @@ func TestHaveBlock(t *testing.T) {
 	}
 }
 
+// TestProcessBlockHeader ensures headers processed ahead of their blocks are
+// added to the block index without being considered available and that the
+// blocks are accepted once they arrive.
+func TestProcessBlockHeader(t *testing.T) {
+	blocks, err := loadBlocks("blk_0_to_4.dat.bz2")
+	if err != nil {
+		t.Fatalf("Error loading file: %v\n", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("processblockheader",
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+	chain.TstSetCoinbaseMaturity(1)
+
+	// A header which does not connect must be rejected.
+	orphanHeader := &Block100000.Header
+	err = chain.ProcessBlockHeader(orphanHeader, BFNone)
+	rerr, ok := err.(RuleError)
+	if !ok || rerr.ErrorCode != ErrPreviousBlockUnknown {
+		t.Fatalf("ProcessBlockHeader: unexpected error - got %v, want %v",
+			err, ErrPreviousBlockUnknown)
+	}
+
+	for i := 1; i < len(blocks); i++ {
+		header := &blocks[i].MsgBlock().Header
+		if err := chain.ProcessBlockHeader(header, BFNone); err != nil {
+			t.Fatalf("ProcessBlockHeader fail on header %d: %v", i, err)
+		}
+
+		// Processing the same header again is not an error.
+		if err := chain.ProcessBlockHeader(header, BFNone); err != nil {
+			t.Fatalf("ProcessBlockHeader fail on duplicate header "+
+				"%d: %v", i, err)
+		}
+
+		have, err := chain.HaveBlock(blocks[i].Hash())
+		if err != nil {
+			t.Fatalf("HaveBlock: %v", err)
+		}
+		if have {
+			t.Fatalf("HaveBlock reported header %d as a block", i)
+		}
+	}
+
+	tipWork, err := chain.ChainWork(blocks[len(blocks)-1].Hash())
+	if err != nil {
+		t.Fatalf("ChainWork: %v", err)
+	}
+	bestWork, err := chain.ChainWork(&chain.BestSnapshot().Hash)
+	if err != nil {
+		t.Fatalf("ChainWork: %v", err)
+	}
+	if tipWork.Cmp(bestWork) <= 0 {
+		t.Fatalf("header chain work %v does not exceed best chain work",
+			tipWork)
+	}
+
+	for i := 1; i < len(blocks); i++ {
+		isMainChain, isOrphan, err := chain.ProcessBlock(blocks[i], BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock fail on block %d: %v", i, err)
+		}
+		if isOrphan || !isMainChain {
+			t.Fatalf("ProcessBlock block %d: orphan %v, main chain %v",
+				i, isOrphan, isMainChain)
+		}
+	}
+	if chain.BestSnapshot().Hash != *blocks[len(blocks)-1].Hash() {
+		t.Fatalf("unexpected best block %v", chain.BestSnapshot().Hash)
+	}
+}
+
 // TestCalcSequenceLock tests the LockTimeToSequence function, and the
 // CalcSequenceLock method of a Chain instance. The tests exercise several
 // combinations of inputs to the CalcSequenceLock function in order to ensure
@@ -146,7 +222,8 @@ func TestCalcSequenceLock(t *testing.T) {
 		}},
 	})
 	utxoView := NewUtxoViewpoint()
-	utxoView.AddTxOuts(targetTx, int32(numBlocksToActivate)-4)
+	utxoView.AddTxOuts(targetTx, int32(numBlocksToActivate)-4,
+		chain.chainParams)
 	utxoView.SetBestHash(&node.hash)
 
 	// Create a utxo that spends the fake utxo created above for use in the
@@ -187,7 +264,8 @@ func TestCalcSequenceLock(t *testing.T) {
 
 	// Adding a utxo with a height of 0x7fffffff indicates that the output
 	// is currently unmined.
-	utxoView.AddTxOuts(btcutil.NewTx(unConfTx), 0x7fffffff)
+	utxoView.AddTxOuts(btcutil.NewTx(unConfTx), 0x7fffffff,
+		chain.chainParams)
 
 	tests := []struct {
 		tx      *wire.MsgTx
@@ -964,3 +1042,179 @@ func TestIntervalBlockHashes(t *testing.T) {
 		}
 	}
 }
+
+// TestIsAssumedValid ensures only the assume-valid block and its ancestors are
+// assumed to have valid scripts and only when the best header chain contains
+// the assume-valid block, has enough work and buries the block deep enough.
+func TestIsAssumedValid(t *testing.T) {
+	// Construct a synthetic block chain with a block index consisting of
+	// the following structure, where the best header buries block 4 by
+	// exactly the required depth.
+	// 	genesis -> 1 -> 2 -> ... -> 8 -> 9 -> 10 -> ... -> best
+	// 	                        \-> 6a -> 7a
+	params := chaincfg.MainNetParams
+	chain := newFakeChain(&params)
+	genesis := chain.bestChain.Genesis()
+	workNodes := func(parent *blockNode, version int32, numNodes int) []*blockNode {
+		nodes := make([]*blockNode, numNodes)
+		for i := range nodes {
+			nodes[i] = newFakeNode(parent, version,
+				params.PowLimitBits, time.Unix(parent.timestamp+1, 0))
+			parent = nodes[i]
+		}
+		return nodes
+	}
+	burialDepth := int32(assumeValidBurialTime / params.TargetTimePerBlock)
+	branch0Nodes := workNodes(genesis, 1, int(4+burialDepth))
+	branch1Nodes := workNodes(branch0Nodes[4], 2, 2)
+	for _, node := range append(branch0Nodes, branch1Nodes...) {
+		chain.index.AddNode(node)
+	}
+	assumeValidNode := branch0Nodes[7]
+	bestHeader := branch0Nodes[len(branch0Nodes)-1]
+	if got := chain.index.BestHeader(); got != bestHeader {
+		t.Fatalf("BestHeader: unexpected node at height %d", got.height)
+	}
+
+	tests := []struct {
+		name        string
+		assumeValid *chainhash.Hash
+		minWork     *big.Int
+		invalid     bool
+		bestHeader  *blockNode // defaults to the actual best header
+		node        *blockNode
+		want        bool
+	}{{
+		name: "no assume-valid block",
+		node: branch0Nodes[0],
+		want: false,
+	}, {
+		name:        "unknown assume-valid block",
+		assumeValid: &chainhash.Hash{0x01},
+		node:        branch0Nodes[0],
+		want:        false,
+	}, {
+		name:        "genesis block",
+		assumeValid: &assumeValidNode.hash,
+		node:        genesis,
+		want:        true,
+	}, {
+		name:        "ancestor",
+		assumeValid: &assumeValidNode.hash,
+		node:        branch0Nodes[3],
+		want:        true,
+	}, {
+		name:        "assume-valid block not buried deep enough",
+		assumeValid: &assumeValidNode.hash,
+		node:        assumeValidNode,
+		want:        false,
+	}, {
+		name:        "ancestor not buried deep enough",
+		assumeValid: &assumeValidNode.hash,
+		node:        branch0Nodes[4],
+		want:        false,
+	}, {
+		name:        "descendant",
+		assumeValid: &assumeValidNode.hash,
+		node:        branch0Nodes[8],
+		want:        false,
+	}, {
+		name:        "side chain",
+		assumeValid: &assumeValidNode.hash,
+		node:        branch1Nodes[0],
+		want:        false,
+	}, {
+		name:        "assume-valid block not in best header chain",
+		assumeValid: &assumeValidNode.hash,
+		bestHeader:  branch1Nodes[1],
+		node:        genesis,
+		want:        false,
+	}, {
+		name:        "enough work",
+		assumeValid: &assumeValidNode.hash,
+		minWork:     bestHeader.workSum,
+		node:        branch0Nodes[3],
+		want:        true,
+	}, {
+		name:        "not enough work",
+		assumeValid: &assumeValidNode.hash,
+		minWork:     new(big.Int).Add(bestHeader.workSum, bigOne),
+		node:        branch0Nodes[3],
+		want:        false,
+	}, {
+		name:        "assume-valid block has enough work itself only",
+		assumeValid: &assumeValidNode.hash,
+		minWork:     assumeValidNode.workSum,
+		bestHeader:  assumeValidNode,
+		node:        genesis,
+		want:        false,
+	}, {
+		name:        "invalid assume-valid block",
+		assumeValid: &assumeValidNode.hash,
+		invalid:     true,
+		node:        branch0Nodes[3],
+		want:        false,
+	}}
+
+	for _, test := range tests {
+		chain.assumeValid = test.assumeValid
+		params.MinimumChainWork = test.minWork
+		chain.index.bestHeader = bestHeader
+		if test.bestHeader != nil {
+			chain.index.bestHeader = test.bestHeader
+		}
+		if test.invalid {
+			chain.index.SetStatusFlags(assumeValidNode, statusValidateFailed)
+		} else {
+			chain.index.UnsetStatusFlags(assumeValidNode, statusValidateFailed)
+		}
+
+		got := chain.isAssumedValid(test.node)
+		if got != test.want {
+			t.Errorf("%s: unexpected result -- got %v, want %v",
+				test.name, got, test.want)
+		}
+	}
+}
+
+// TestIsCurrentMinimumChainWork ensures the chain is only considered current
+// once the best chain has at least the minimum chain work of the network.
+func TestIsCurrentMinimumChainWork(t *testing.T) {
+	// Construct a synthetic block chain with recent block timestamps so
+	// only the chain work determines whether the chain is current.
+	params := chaincfg.RegressionNetParams
+	chain := newFakeChain(&params)
+	tip := chain.bestChain.Tip()
+	now := time.Unix(chain.timeSource.AdjustedTime().Unix(), 0)
+	for i := 0; i < 5; i++ {
+		tip = newFakeNode(tip, 1, params.PowLimitBits, now)
+		chain.index.AddNode(tip)
+	}
+	chain.bestChain.SetTip(tip)
+
+	tests := []struct {
+		name    string
+		minWork *big.Int
+		want    bool
+	}{{
+		name: "no minimum chain work",
+		want: true,
+	}, {
+		name:    "enough work",
+		minWork: tip.workSum,
+		want:    true,
+	}, {
+		name:    "not enough work",
+		minWork: new(big.Int).Add(tip.workSum, bigOne),
+		want:    false,
+	}}
+
+	for _, test := range tests {
+		params.MinimumChainWork = test.minWork
+		got := chain.isCurrent()
+		if got != test.want {
+			t.Errorf("%s: unexpected result -- got %v, want %v",
+				test.name, got, test.want)
+		}
+	}
+}
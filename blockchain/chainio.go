@@ -27,7 +27,7 @@ const (
 
 	// latestUtxoSetBucketVersion is the current version of the utxo set
 	// bucket that is used to track all unspent outputs.
-	latestUtxoSetBucketVersion = 2
+	latestUtxoSetBucketVersion = 4
 
 	// latestSpendJournalBucketVersion is the current version of the spend
 	// journal bucket that is used to track all spent transactions for use
@@ -66,7 +66,24 @@ var (
 
 	// utxoSetBucketName is the name of the db bucket used to house the
 	// unspent transaction output set.
-	utxoSetBucketName = []byte("utxosetv2")
+	utxoSetBucketName = []byte("utxosetv3")
+
+	// utxoStateConsistencyKeyName is the name of the db key used to store
+	// the hash of the block up to which the unspent transaction output set
+	// in the database is consistent.  It trails the best chain state while
+	// the utxo cache holds changes which have not been flushed yet.
+	utxoStateConsistencyKeyName = []byte("utxostateconsistency")
+
+	// snapshotBaseKeyName is the name of the db key used to store the hash
+	// of the base block of the utxo snapshot the chain state was loaded
+	// from followed by whether the history up to it has been validated and
+	// the tax sweep height as of the base block.
+	snapshotBaseKeyName = []byte("utxosnapshotbase")
+
+	// snapshotHeightIndexBucketName is the name of the db bucket used to
+	// house the utxo set keys of the utxos loaded from a utxo snapshot
+	// prefixed by the height of the block which created them.
+	snapshotHeightIndexBucketName = []byte("utxosnapshotheightidx")
 
 	// byteOrder is the preferred byte order used for serializing numeric
 	// fields for storage in the database.
@@ -515,10 +532,11 @@ func dbRemoveSpendJournalEntry(dbTx database.Tx, blockHash *chainhash.Hash) erro
 //
 // The serialized value format is:
 //
-//   <header code><compressed txout>
+//   <header code><expiry height><compressed txout>
 //
 //   Field                Type     Size
 //   header code          VLQ      variable
+//   expiry height        VLQ      variable
 //   compressed txout
 //     compressed amount  VLQ      variable
 //     compressed script  []byte   variable
@@ -527,16 +545,22 @@ func dbRemoveSpendJournalEntry(dbTx database.Tx, blockHash *chainhash.Hash) erro
 //   bit 0 - containing transaction is a coinbase
 //   bits 1-x - height of the block that contains the unspent txout
 //
+// The expiry height is the height of the first block in which the unspent
+// txout is expired.  Whether the unspent txout is expired is not stored since
+// it is relative to the height it is checked at.
+//
 // Example 1:
 // From tx in main blockchain:
 // Blk 1, 0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098:0
 //
-//    03320496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52
-//    <><------------------------------------------------------------------>
-//     |                                          |
-//   header code                         compressed txout
+//    0395bb52320496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52
+//    <><----><------------------------------------------------------------------>
+//     |    |                                     |
+//     |  expiry height                   compressed txout
+//   header code
 //
 //  - header code: 0x03 (coinbase, height 1)
+//  - expiry height: 0x95bb52 (368210)
 //  - compressed txout:
 //    - 0x32: VLQ-encoded compressed amount for 5000000000 (50 BTC)
 //    - 0x04: special script type pay-to-pubkey
@@ -546,12 +570,14 @@ func dbRemoveSpendJournalEntry(dbTx database.Tx, blockHash *chainhash.Hash) erro
 // From tx in main blockchain:
 // Blk 113931, 4a16969aa4764dd7507fc1de7f0baa4850a246de90c45e59a3207f9a26b5036f:2
 //
-//    8cf316800900b8025be1b3efc63b0ad48e7f9f10e87544528d58
-//    <----><------------------------------------------>
-//      |                             |
-//   header code             compressed txout
+//    8cf3169cb55c800900b8025be1b3efc63b0ad48e7f9f10e87544528d58
+//    <----><----><------------------------------------------>
+//      |     |                       |
+//      | expiry height        compressed txout
+//   header code
 //
 //  - header code: 0x8cf316 (not coinbase, height 113931)
+//  - expiry height: 0x9cb55c (482140)
 //  - compressed txout:
 //    - 0x8009: VLQ-encoded compressed amount for 15000000 (0.15 BTC)
 //    - 0x00: special script type pay-to-pubkey-hash
@@ -561,12 +587,14 @@ func dbRemoveSpendJournalEntry(dbTx database.Tx, blockHash *chainhash.Hash) erro
 // From tx in main blockchain:
 // Blk 338156, 1b02d1c8cfef60a189017b9a420c682cf4a0028175f2f563209e4ff61c8c3620:22
 //
-//    a8a2588ba5b9e763011dd46a006572d820e448e12d2bbb38640bc718e6
-//    <----><-------------------------------------------------->
-//      |                             |
-//   header code             compressed txout
+//    a8a258aa8d3d8ba5b9e763011dd46a006572d820e448e12d2bbb38640bc718e6
+//    <----><----><-------------------------------------------------->
+//      |     |                       |
+//      | expiry height        compressed txout
+//   header code
 //
 //  - header code: 0xa8a258 (not coinbase, height 338156)
+//  - expiry height: 0xaa8d3d (706365)
 //  - compressed txout:
 //    - 0x8ba5b9e763: VLQ-encoded compressed amount for 366875659 (3.66875659 BTC)
 //    - 0x01: special script type pay-to-script-hash
@@ -604,6 +632,28 @@ func outpointKey(outpoint wire.OutPoint) *[]byte {
 	return key
 }
 
+// decodeOutpointKey returns the outpoint the passed utxo set key, which was
+// created by the outpointKey function, refers to.
+func decodeOutpointKey(key []byte) (wire.OutPoint, error) {
+	var outpoint wire.OutPoint
+	if len(key) <= chainhash.HashSize {
+		return outpoint, database.Error{
+			ErrorCode:   database.ErrCorruption,
+			Description: fmt.Sprintf("corrupt utxo key %x", key),
+		}
+	}
+	copy(outpoint.Hash[:], key[:chainhash.HashSize])
+	idx, bytesRead := deserializeVLQ(key[chainhash.HashSize:])
+	if bytesRead != len(key)-chainhash.HashSize {
+		return outpoint, database.Error{
+			ErrorCode:   database.ErrCorruption,
+			Description: fmt.Sprintf("corrupt utxo key %x", key),
+		}
+	}
+	outpoint.Index = uint32(idx)
+	return outpoint, nil
+}
+
 // recycleOutpointKey puts the provided byte slice, which should have been
 // obtained via the outpointKey function, back on the free list.
 func recycleOutpointKey(key *[]byte) {
@@ -643,13 +693,15 @@ func serializeUtxoEntry(entry *utxo.UtxoEntry) ([]byte, error) {
 	}
 
 	// Calculate the size needed to serialize the entry.
-	size := serializeSizeVLQ(headerCode) +
+	expiryHeight := uint64(entry.ExpiryHeight)
+	size := serializeSizeVLQ(headerCode) + serializeSizeVLQ(expiryHeight) +
 		compressedTxOutSize(uint64(entry.Amount), entry.PkScript)
 
-	// Serialize the header code followed by the compressed unspent
-	// transaction output.
+	// Serialize the header code and the expiry height followed by the
+	// compressed unspent transaction output.
 	serialized := make([]byte, size)
 	offset := putVLQ(serialized, headerCode)
+	offset += putVLQ(serialized[offset:], expiryHeight)
 	offset += putCompressedTxOut(serialized[offset:], uint64(entry.Amount),
 		entry.PkScript)
 
@@ -673,6 +725,14 @@ func deserializeUtxoEntry(serialized []byte) (*utxo.UtxoEntry, error) {
 	isCoinBase := code&0x01 != 0
 	blockHeight := int32(code >> 1)
 
+	// Deserialize the expiry height.
+	expiryHeight, bytesRead := deserializeVLQ(serialized[offset:])
+	offset += bytesRead
+	if offset >= len(serialized) {
+		return nil, errDeserialize("unexpected end of data after " +
+			"expiry height")
+	}
+
 	// Decode the compressed unspent transaction output.
 	amount, pkScript, _, err := decodeCompressedTxOut(serialized[offset:])
 	if err != nil {
@@ -681,10 +741,11 @@ func deserializeUtxoEntry(serialized []byte) (*utxo.UtxoEntry, error) {
 	}
 
 	entry := &utxo.UtxoEntry{
-		Amount:      int64(amount),
-		PkScript:    pkScript,
-		BlockHeight: blockHeight,
-		PackedFlags: 0,
+		Amount:       int64(amount),
+		PkScript:     pkScript,
+		BlockHeight:  blockHeight,
+		ExpiryHeight: int32(expiryHeight),
+		PackedFlags:  0,
 	}
 	if isCoinBase {
 		entry.PackedFlags |= utxo.TfCoinBase
@@ -767,6 +828,45 @@ func dbFetchUtxoEntry(dbTx database.Tx, outpoint wire.OutPoint) (*utxo.UtxoEntry
 	return entry, nil
 }
 
+// dbForEachUtxoEntry uses an existing database transaction to invoke the passed
+// function with every entry in the utxo set in the order of their serialized
+// keys, which are passed along with the outpoints they identify.  The
+// iteration stops early when the function returns an error, in which case that
+// error is returned.
+//
+// The passed key and entry are only valid during the call.
+func dbForEachUtxoEntry(dbTx database.Tx, fn func(key []byte, outpoint wire.OutPoint, entry *utxo.UtxoEntry) error) error {
+	cursor := dbTx.Metadata().Bucket(utxoSetBucketName).Cursor()
+	for ok := cursor.First(); ok; ok = cursor.Next() {
+		// The keys are serialized as <hash><index> where the index is a
+		// VLQ.
+		key := cursor.Key()
+		outpoint, err := decodeOutpointKey(key)
+		if err != nil {
+			return err
+		}
+
+		entry, err := deserializeUtxoEntry(cursor.Value())
+		if err != nil {
+			// Ensure any deserialization errors are returned as
+			// database corruption errors.
+			if isDeserializeErr(err) {
+				return database.Error{
+					ErrorCode: database.ErrCorruption,
+					Description: fmt.Sprintf("corrupt utxo "+
+						"entry for %v: %v", outpoint, err),
+				}
+			}
+			return err
+		}
+
+		if err := fn(key, outpoint, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // dbPutUtxoView uses an existing database transaction to update the utxo set
 // in the database based on the provided utxo view contents and state.  In
 // particular, only the entries that have been marked as modified are written
@@ -985,6 +1085,104 @@ func deserializeBestChainState(serializedData []byte) (bestChainState, error) {
 	return state, nil
 }
 
+// dbPutUtxoStateConsistency uses an existing database transaction to record
+// the hash of the block up to which the utxo set in the database is consistent.
+func dbPutUtxoStateConsistency(dbTx database.Tx, hash *chainhash.Hash) error {
+	return dbTx.Metadata().Put(utxoStateConsistencyKeyName, hash[:])
+}
+
+// dbFetchUtxoStateConsistency uses an existing database transaction to fetch
+// the hash of the block up to which the utxo set in the database is consistent.
+// It returns nil when the database does not record it.
+func dbFetchUtxoStateConsistency(dbTx database.Tx) (*chainhash.Hash, error) {
+	serialized := dbTx.Metadata().Get(utxoStateConsistencyKeyName)
+	if serialized == nil {
+		return nil, nil
+	}
+	if len(serialized) != chainhash.HashSize {
+		return nil, database.Error{
+			ErrorCode: database.ErrCorruption,
+			Description: fmt.Sprintf("corrupt utxo state "+
+				"consistency %x", serialized),
+		}
+	}
+
+	var hash chainhash.Hash
+	copy(hash[:], serialized)
+	return &hash, nil
+}
+
+// snapshotStatus describes whether the history up to the base block of the
+// utxo snapshot the chain state was loaded from has been validated.
+type snapshotStatus byte
+
+const (
+	// snapshotUnvalidated indicates the history up to the base block has
+	// not been validated yet, so the snapshot is assumed to be valid.
+	snapshotUnvalidated snapshotStatus = iota
+
+	// snapshotValidated indicates the utxo set of the validated history
+	// matches the snapshot.
+	snapshotValidated
+
+	// snapshotInvalid indicates the utxo set of the validated history does
+	// not match the snapshot, so the chain state loaded from it must be
+	// discarded.
+	snapshotInvalid
+)
+
+// snapshotBaseState is the state of the utxo snapshot the chain state was
+// loaded from as it is stored in the database.
+type snapshotBaseState struct {
+	hash           chainhash.Hash
+	status         snapshotStatus
+	taxSweepHeight int32
+}
+
+// snapshotBaseStateSize is the size of a serialized snapshot base state.
+const snapshotBaseStateSize = chainhash.HashSize + 1 + 4
+
+// dbPutSnapshotBase uses an existing database transaction to record the state
+// of the utxo snapshot the chain state was loaded from.
+//
+// The state is serialized as <hash><status><tax sweep height> where the hash
+// is the one of the base block, the status is a single byte and the tax sweep
+// height is an int32 encoded as a uint32.
+func dbPutSnapshotBase(dbTx database.Tx, state *snapshotBaseState) error {
+	serialized := make([]byte, snapshotBaseStateSize)
+	copy(serialized, state.hash[:])
+	serialized[chainhash.HashSize] = byte(state.status)
+	byteOrder.PutUint32(serialized[chainhash.HashSize+1:],
+		uint32(state.taxSweepHeight))
+	return dbTx.Metadata().Put(snapshotBaseKeyName, serialized)
+}
+
+// dbFetchSnapshotBase uses an existing database transaction to fetch the state
+// of the utxo snapshot the chain state was loaded from.  It returns nil when
+// the chain state was not loaded from a utxo snapshot.
+func dbFetchSnapshotBase(dbTx database.Tx) (*snapshotBaseState, error) {
+	serialized := dbTx.Metadata().Get(snapshotBaseKeyName)
+	if serialized == nil {
+		return nil, nil
+	}
+	if len(serialized) != snapshotBaseStateSize ||
+		snapshotStatus(serialized[chainhash.HashSize]) > snapshotInvalid {
+
+		return nil, database.Error{
+			ErrorCode: database.ErrCorruption,
+			Description: fmt.Sprintf("corrupt utxo snapshot base %x",
+				serialized),
+		}
+	}
+
+	var state snapshotBaseState
+	copy(state.hash[:], serialized)
+	state.status = snapshotStatus(serialized[chainhash.HashSize])
+	state.taxSweepHeight = int32(byteOrder.Uint32(
+		serialized[chainhash.HashSize+1:]))
+	return &state, nil
+}
+
 // dbPutBestState uses an existing database transaction to update the best chain
 // state with the given parameters.
 func dbPutBestState(dbTx database.Tx, snapshot *BestState, workSum *big.Int) error {
@@ -1000,6 +1198,60 @@ func dbPutBestState(dbTx database.Tx, snapshot *BestState, workSum *big.Int) err
 	return dbTx.Metadata().Put(chainStateKeyName, serializedData)
 }
 
+// dbCreateChainStateBuckets uses an existing database transaction to create
+// the buckets which house the chain state and store their versions.
+func dbCreateChainStateBuckets(dbTx database.Tx) error {
+	meta := dbTx.Metadata()
+
+	// Create the bucket that houses the block index data.
+	_, err := meta.CreateBucket(blockIndexBucketName)
+	if err != nil {
+		return err
+	}
+
+	// Create the bucket that houses the chain block hash to height
+	// index.
+	_, err = meta.CreateBucket(hashIndexBucketName)
+	if err != nil {
+		return err
+	}
+
+	// Create the bucket that houses the chain block height to hash
+	// index.
+	_, err = meta.CreateBucket(heightIndexBucketName)
+	if err != nil {
+		return err
+	}
+
+	// Create the bucket that houses the spend journal data and
+	// store its version.
+	_, err = meta.CreateBucket(spendJournalBucketName)
+	if err != nil {
+		return err
+	}
+	err = dbPutVersion(dbTx, utxoSetVersionKeyName,
+		latestUtxoSetBucketVersion)
+	if err != nil {
+		return err
+	}
+
+	// Create the bucket that houses the utxo set and store its
+	// version.  Note that the genesis block coinbase transaction is
+	// intentionally not inserted here since it is not spendable by
+	// consensus rules.
+	_, err = meta.CreateBucket(utxoSetBucketName)
+	if err != nil {
+		return err
+	}
+	err = dbPutVersion(dbTx, spendJournalVersionKeyName,
+		latestSpendJournalBucketVersion)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // createChainState initializes both the database and the chain state to the
 // genesis block.  This includes creating the necessary buckets and inserting
 // the genesis block, so it must only be called on an uninitialized database.
@@ -1026,50 +1278,7 @@ func (b *BlockChain) createChainState() error {
 	// Create the initial the database chain state including creating the
 	// necessary index buckets and inserting the genesis block.
 	err := b.db.Update(func(dbTx database.Tx) error {
-		meta := dbTx.Metadata()
-
-		// Create the bucket that houses the block index data.
-		_, err := meta.CreateBucket(blockIndexBucketName)
-		if err != nil {
-			return err
-		}
-
-		// Create the bucket that houses the chain block hash to height
-		// index.
-		_, err = meta.CreateBucket(hashIndexBucketName)
-		if err != nil {
-			return err
-		}
-
-		// Create the bucket that houses the chain block height to hash
-		// index.
-		_, err = meta.CreateBucket(heightIndexBucketName)
-		if err != nil {
-			return err
-		}
-
-		// Create the bucket that houses the spend journal data and
-		// store its version.
-		_, err = meta.CreateBucket(spendJournalBucketName)
-		if err != nil {
-			return err
-		}
-		err = dbPutVersion(dbTx, utxoSetVersionKeyName,
-			latestUtxoSetBucketVersion)
-		if err != nil {
-			return err
-		}
-
-		// Create the bucket that houses the utxo set and store its
-		// version.  Note that the genesis block coinbase transaction is
-		// intentionally not inserted here since it is not spendable by
-		// consensus rules.
-		_, err = meta.CreateBucket(utxoSetBucketName)
-		if err != nil {
-			return err
-		}
-		err = dbPutVersion(dbTx, spendJournalVersionKeyName,
-			latestSpendJournalBucketVersion)
+		err := dbCreateChainStateBuckets(dbTx)
 		if err != nil {
 			return err
 		}
@@ -420,10 +420,11 @@ func TestUtxoSerialization(t *testing.T) {
 			entry: &utxo.UtxoEntry{
 				Amount:      5000000000,
 				PkScript:    hexToBytes("410496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52da7589379515d4e0a604f8141781e62294721166bf621e73a82cbf2342c858eeac"),
-				BlockHeight: 1,
-				PackedFlags: utxo.TfCoinBase,
+				BlockHeight:  1,
+				ExpiryHeight: 368210,
+				PackedFlags:  utxo.TfCoinBase,
 			},
-			serialized: hexToBytes("03320496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52"),
+			serialized: hexToBytes("0395bb52320496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52"),
 		},
 		// From tx in main blockchain:
 		// 0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098:0
@@ -444,10 +445,27 @@ func TestUtxoSerialization(t *testing.T) {
 			entry: &utxo.UtxoEntry{
 				Amount:      1000000,
 				PkScript:    hexToBytes("76a914ee8bd501094a7d5ca318da2506de35e1cb025ddc88ac"),
-				BlockHeight: 100001,
-				PackedFlags: 0,
+				BlockHeight:  100001,
+				ExpiryHeight: 468210,
+				PackedFlags:  0,
+			},
+			serialized: hexToBytes("8b99429bc8720700ee8bd501094a7d5ca318da2506de35e1cb025ddc"),
+		},
+		// From tx in main blockchain:
+		// 8131ffb0a2c945ecaf9b9063e59558784f9c3a74741ce6ae2a18d0571dac15bb:1
+		//
+		// The expired flag is relative to the height it was checked at,
+		// so it is not stored.
+		{
+			name: "height 100001, not coinbase, expired",
+			entry: &utxo.UtxoEntry{
+				Amount:       1000000,
+				PkScript:     hexToBytes("76a914ee8bd501094a7d5ca318da2506de35e1cb025ddc88ac"),
+				BlockHeight:  100001,
+				ExpiryHeight: 468210,
+				PackedFlags:  utxo.TfExpired,
 			},
-			serialized: hexToBytes("8b99420700ee8bd501094a7d5ca318da2506de35e1cb025ddc"),
+			serialized: hexToBytes("8b99429bc8720700ee8bd501094a7d5ca318da2506de35e1cb025ddc"),
 		},
 		// From tx in main blockchain:
 		// 8131ffb0a2c945ecaf9b9063e59558784f9c3a74741ce6ae2a18d0571dac15bb:1
@@ -527,6 +545,17 @@ func TestUtxoSerialization(t *testing.T) {
 				utxoEntry.IsCoinBase(), test.entry.IsCoinBase())
 			continue
 		}
+		if utxoEntry.ExpiryHeight != test.entry.ExpiryHeight {
+			t.Errorf("deserializeUtxoEntry #%d (%s) mismatched "+
+				"expiry height: got %d, want %d", i, test.name,
+				utxoEntry.ExpiryHeight, test.entry.ExpiryHeight)
+			continue
+		}
+		if utxoEntry.IsExpired() {
+			t.Errorf("deserializeUtxoEntry #%d (%s) unexpected "+
+				"expired flag", i, test.name)
+			continue
+		}
 	}
 }
 
@@ -581,10 +610,15 @@ func TestUtxoEntryDeserializeErrors(t *testing.T) {
 			errType:    errDeserialize(""),
 		},
 		{
-			name:       "incomplete compressed txout",
+			name:       "no data after expiry height",
 			serialized: hexToBytes("0232"),
 			errType:    errDeserialize(""),
 		},
+		{
+			name:       "incomplete compressed txout",
+			serialized: hexToBytes("023232"),
+			errType:    errDeserialize(""),
+		},
 	}
 
 	for _, test := range tests {
@@ -18,6 +18,11 @@ import (
 // best block chain that a good checkpoint candidate must be.
 const CheckpointConfirmations = 2016
 
+// assumeValidBurialTime is the amount of time the blocks of the best header
+// chain after a block must take at the target block spacing for the scripts of
+// the block to be assumed valid.
+const assumeValidBurialTime = 14 * 24 * time.Hour
+
 // newHashFromStr converts the passed big-endian hex string into a
 // chainhash.Hash.  It only differs from the one available in chainhash in that
 // it ignores the error since it will only (and must only) be called with
@@ -55,6 +60,45 @@ func (b *BlockChain) LatestCheckpoint() *chaincfg.Checkpoint {
 	return &b.checkpoints[len(b.checkpoints)-1]
 }
 
+// isAssumedValid returns whether the scripts of the passed block are assumed
+// to be valid.  That is only the case when the block is an ancestor of the
+// assume-valid block, or the block itself, and the assume-valid block is part
+// of the best known header chain, which has at least the minimum chain work
+// and is at least assumeValidBurialTime worth of blocks longer than the passed
+// block.  These requirements ensure a node which was fed a header chain with
+// the assume-valid block by a peer still validates the scripts unless the chain
+// is the expensive one the default was chosen from, and that blocks are not
+// assumed valid while the honest chain has not been seen to build on them.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) isAssumedValid(node *blockNode) bool {
+	if b.assumeValid == nil {
+		return false
+	}
+	assumeValidNode := b.index.LookupNode(b.assumeValid)
+	if assumeValidNode == nil ||
+		b.index.NodeStatus(assumeValidNode).KnownInvalid() ||
+		assumeValidNode.Ancestor(node.height) != node {
+
+		return false
+	}
+
+	bestHeader := b.index.BestHeader()
+	if b.index.NodeStatus(bestHeader).KnownInvalid() ||
+		bestHeader.Ancestor(assumeValidNode.height) != assumeValidNode {
+
+		return false
+	}
+	minWork := b.chainParams.MinimumChainWork
+	if minWork != nil && bestHeader.workSum.Cmp(minWork) < 0 {
+		return false
+	}
+
+	burialDepth := int32(assumeValidBurialTime /
+		b.chainParams.TargetTimePerBlock)
+	return bestHeader.height-node.height >= burialDepth
+}
+
 // verifyCheckpoint returns whether the passed block height and hash combination
 // match the checkpoint data.  It also returns true if there is no checkpoint
 // data for the passed block height.
@@ -193,7 +193,8 @@ func loadUtxoView(filename string) (*UtxoViewpoint, error) {
 	// <tx hash><output index><serialized utxo len><serialized utxo>
 	//
 	// The output index and serialized utxo len are little endian uint32s
-	// and the serialized utxo uses the format described in chainio.go.
+	// and the serialized utxo uses the version 2 format described in
+	// upgrade.go.
 
 	filename = filepath.Join("testdata", filename)
 	fi, err := os.Open(filename)
@@ -244,8 +245,9 @@ func loadUtxoView(filename string) (*UtxoViewpoint, error) {
 			return nil, err
 		}
 
-		// Deserialize it and add it to the view.
-		entry, err := deserializeUtxoEntry(serialized)
+		// Deserialize it and add it to the view.  The test data uses
+		// the version 2 utxo set format.
+		entry, err := deserializeUtxoEntryV2(serialized)
 		if err != nil {
 			return nil, err
 		}
@@ -256,9 +258,9 @@ func loadUtxoView(filename string) (*UtxoViewpoint, error) {
 }
 
 // convertUtxoStore reads a utxostore from the legacy format and writes it back
-// out using the latest format.  It is only useful for converting utxostore data
-// used in the tests, which has already been done.  However, the code is left
-// available for future reference.
+// out using the version 2 format.  It is only useful for converting utxostore
+// data used in the tests, which has already been done.  However, the code is
+// left available for future reference.
 func convertUtxoStore(r io.Reader, w io.Writer) error {
 	// The old utxostore file format was:
 	// <tx hash><serialized utxo len><serialized utxo>
@@ -302,8 +304,9 @@ func convertUtxoStore(r io.Reader, w io.Writer) error {
 		// Loop through all of the utxos and write them out in the new
 		// format.
 		for outputIdx, entry := range entries {
-			// Reserialize the entries using the new format.
-			serialized, err := serializeUtxoEntry(entry)
+			// Reserialize the entries using the version 2 format
+			// loaded by loadUtxoView.
+			serialized, err := serializeUtxoEntryV2(entry)
 			if err != nil {
 				return err
 			}
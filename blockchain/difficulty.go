@@ -8,6 +8,7 @@ import (
 	"math/big"
 	"time"
 
+	"github.com/organicbitcoin/obtcd/chaincfg"
 	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
 )
 
@@ -152,6 +153,44 @@ func CalcWork(bits uint32) *big.Int {
 	return new(big.Int).Div(oneLsh256, denominator)
 }
 
+// PermittedDifficultyTransition returns whether the difficulty bits of the block
+// at the passed height may change from oldBits, the bits of its parent, to
+// newBits under the difficulty retarget rules of the passed network.  It only
+// requires the two headers, so it is suitable for checking headers which have
+// not been connected to the block index.  Networks which allow the special
+// minimum difficulty reduction permit any transition.
+func PermittedDifficultyTransition(params *chaincfg.Params, height int32, oldBits, newBits uint32) bool {
+	if params.ReduceMinDifficulty {
+		return true
+	}
+
+	// The difficulty may only change at retarget intervals.
+	blocksPerRetarget := int32(params.TargetTimespan / params.TargetTimePerBlock)
+	if height%blocksPerRetarget != 0 {
+		return oldBits == newBits
+	}
+
+	// The target may increase or decrease by at most the adjustment factor,
+	// and may not exceed the proof of work limit.  The bounds are rounded
+	// through the compact representation just like the retarget itself.
+	factor := big.NewInt(params.RetargetAdjustmentFactor)
+	oldTarget := CompactToBig(oldBits)
+	newTarget := CompactToBig(newBits)
+
+	largest := new(big.Int).Mul(oldTarget, factor)
+	if largest.Cmp(params.PowLimit) > 0 {
+		largest.Set(params.PowLimit)
+	}
+	largest = CompactToBig(BigToCompact(largest))
+	if newTarget.Cmp(largest) > 0 {
+		return false
+	}
+
+	smallest := new(big.Int).Div(oldTarget, factor)
+	smallest = CompactToBig(BigToCompact(smallest))
+	return newTarget.Cmp(smallest) >= 0
+}
+
 // calcEasiestDifficulty calculates the easiest possible difficulty that a block
 // can have given starting difficulty bits and a duration.  It is mainly used to
 // verify that claimed proof of work by a block is sane as compared to a
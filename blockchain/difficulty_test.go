@@ -7,6 +7,8 @@ package blockchain
 import (
 	"math/big"
 	"testing"
+
+	"github.com/organicbitcoin/obtcd/chaincfg"
 )
 
 // TestBigToCompact ensures BigToCompact converts big integers to the expected
@@ -73,3 +75,39 @@ func TestCalcWork(t *testing.T) {
 		}
 	}
 }
+
+// TestPermittedDifficultyTransition ensures difficulty changes are only
+// permitted at retarget intervals and within the adjustment bounds.
+func TestPermittedDifficultyTransition(t *testing.T) {
+	params := &chaincfg.MainNetParams
+	const oldBits = 0x1d00ffff
+	tests := []struct {
+		name    string
+		height  int32
+		newBits uint32
+		want    bool
+	}{
+		{"unchanged outside retarget", 2015, oldBits, true},
+		{"changed outside retarget", 2015, 0x1c7fffff, false},
+		{"unchanged at retarget", 2016, oldBits, true},
+		{"harder within bounds", 2016, 0x1c3fffc0, true},
+		{"harder beyond bounds", 2016, 0x1c3fff00, false},
+		{"easier beyond pow limit", 2016, 0x1d01ffff, false},
+		{"harder at old bound", 4032, 0x1c3fffc0, true},
+	}
+
+	for _, test := range tests {
+		got := PermittedDifficultyTransition(params, test.height, oldBits,
+			test.newBits)
+		if got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// Networks which allow minimum difficulty blocks permit any transition.
+	if !PermittedDifficultyTransition(&chaincfg.TestNet3Params, 2015,
+		oldBits, 0x1c3fff00) {
+
+		t.Error("testnet transition not permitted")
+	}
+}
@@ -224,7 +224,7 @@ const (
 	// UTXO expired in reguar tx
 	ErrExpiredRegularUTXO
 
-	// Immature block height for adding tax transaction
+	// Tax transactions in a block before the taxation deployment is active
 	ErrImmatureHeightForTaxTx
 
 	// Unexpired Tax UTXO
@@ -244,6 +244,15 @@ const (
 
 	// Unmatched Tax transaction sequence
 	ErrUnmatchedTaxTxSequence
+
+	// Expired coinbase UTXO swept before full expiry begins
+	ErrCoinbaseTaxUTXO
+
+	// Utxo snapshot is malformed or does not match the pinned hash
+	ErrBadUtxoSnapshot
+
+	// Block does not provide a valid solution to the signet challenge
+	ErrBadSignetSolution
 )
 
 // Map of ErrorCode values back to their constant names for pretty printing.
@@ -299,6 +308,9 @@ var errorCodeStrings = map[ErrorCode]string{
 	ErrBadAddress:                "ErrBadAddress",
 	ErrWrongTaxAmount:            "ErrWrongTaxAmount",
 	ErrUnmatchedTaxTxSequence:    "ErrUnmatchedTaxTxSequence",
+	ErrCoinbaseTaxUTXO:           "ErrCoinbaseTaxUTXO",
+	ErrBadUtxoSnapshot:           "ErrBadUtxoSnapshot",
+	ErrBadSignetSolution:         "ErrBadSignetSolution",
 }
 
 // String returns the ErrorCode as a human-readable name.
@@ -146,6 +146,32 @@ func TestFullBlocks(t *testing.T) {
 	}
 	defer teardownFunc()
 
+	runFullBlockTests(t, chain, tests)
+}
+
+// TestFullBlocksExpiry ensures all of the full expiry tests generated by the
+// fullblocktests package have the expected result when processed via
+// ProcessBlock.
+func TestFullBlocksExpiry(t *testing.T) {
+	tests, params, err := fullblocktests.GenerateExpiry()
+	if err != nil {
+		t.Fatalf("failed to generate tests: %v", err)
+	}
+
+	// Create a new database and chain instance to run tests against.
+	chain, teardownFunc, err := chainSetup("fullblockexpirytest", params)
+	if err != nil {
+		t.Errorf("Failed to setup chain instance: %v", err)
+		return
+	}
+	defer teardownFunc()
+
+	runFullBlockTests(t, chain, tests)
+}
+
+// runFullBlockTests processes the blocks in the provided tests against the
+// passed chain instance and ensures each of them has the expected result.
+func runFullBlockTests(t *testing.T, chain *blockchain.BlockChain, tests [][]fullblocktests.TestInstance) {
 	// testAcceptedBlock attempts to process the block in the provided test
 	// instance and ensures that it was accepted according to the flags
 	// specified in the test.
@@ -0,0 +1,349 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package fullblocktests
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/organicbitcoin/obtcd/blockchain"
+	"github.com/organicbitcoin/obtcd/chaincfg"
+	"github.com/organicbitcoin/obtcd/txscript"
+	"github.com/organicbitcoin/obtcd/wire"
+	"github.com/organicbitcoin/btcutil"
+)
+
+const (
+	// expiryCoinbaseMaturity, expiryValidChainLength and
+	// expiryFullExpiryBeginHeight are the parameters of the network used
+	// by the full expiry tests.  They are kept small so outputs expire
+	// after a handful of blocks.
+	expiryCoinbaseMaturity      = 10
+	expiryValidChainLength      = 20
+	expiryFullExpiryBeginHeight = 30
+
+	// burnAmount is the amount burned by the OP_RETURN outputs in the full
+	// expiry tests.
+	burnAmount = btcutil.Amount(btcutil.SatoshiPerBitcoin)
+)
+
+// expiryNetParams returns the network parameters used by the full expiry
+// tests.  They are the regression test network parameters with a short valid
+// chain length where every block is a confirmation window of its own, so the
+// taxation deployment locks in once the first block signals for it and is
+// active from the third block on.
+func expiryNetParams() *chaincfg.Params {
+	params := chaincfg.RegressionNetParams
+	params.Name = "expirytest"
+	params.CoinbaseMaturity = expiryCoinbaseMaturity
+	params.ValidChainLength = expiryValidChainLength
+	params.FullExpiryBeginHeight = expiryFullExpiryBeginHeight
+	params.RuleChangeActivationThreshold = 1
+	params.MinerConfirmationWindow = 1
+	return &params
+}
+
+// signalTaxation returns a function that itself takes a block and modifies it
+// by setting its version to signal for the taxation deployment as defined by
+// BIP0009.
+func signalTaxation(params *chaincfg.Params) func(*wire.MsgBlock) {
+	return func(b *wire.MsgBlock) {
+		bit := params.Deployments[chaincfg.DeploymentTaxation].BitNumber
+		b.Header.Version = 0x20000000 | 1<<bit
+	}
+}
+
+// createBurnTx creates a transaction that spends from the provided spendable
+// output and burns burnAmount of it in an OP_RETURN output.  The rest is paid
+// to an OP_TRUE script in the first output.
+func createBurnTx(spend *spendableOut) *wire.MsgTx {
+	burnTx := wire.NewMsgTx(1)
+	burnTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: spend.prevOut,
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	burnTx.AddTxOut(wire.NewTxOut(int64(spend.amount-burnAmount),
+		opTrueScript))
+	burnTx.AddTxOut(wire.NewTxOut(int64(burnAmount),
+		uniqueOpReturnScript()))
+	return burnTx
+}
+
+// createTaxTx creates a tax transaction that sweeps the provided expired
+// output, which pays to an OP_TRUE script, along with any provided expired
+// outputs which burned coins.  The output paying to an OP_TRUE script is paid
+// back its amount minus the maximum tax allowed by the passed parameters while
+// the burned outputs are released in their entirety.  It returns the
+// transaction and the total amount of tax it pays to the miner.
+//
+// Since the miner doesn't own the swept outputs, the inputs carry a dummy
+// witness instead of signatures so the transaction is serialized as a tax
+// transaction.
+func createTaxTx(params *chaincfg.Params, spend *spendableOut, burned ...*spendableOut) (*wire.MsgTx, btcutil.Amount) {
+	taxTx := wire.NewMsgTx(1)
+	taxTx.Type = 0x11
+	for _, out := range append([]*spendableOut{spend}, burned...) {
+		taxTx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: out.prevOut,
+			Sequence:         wire.MaxTxInSequenceNum,
+			Witness:          wire.TxWitness{{txscript.OP_TRUE}},
+		})
+	}
+
+	tax := spend.amount * btcutil.Amount(params.TaxRate) / 100
+	taxTx.AddTxOut(wire.NewTxOut(int64(spend.amount-tax), opTrueScript))
+	for _, out := range burned {
+		tax += out.amount
+	}
+	return taxTx, tax
+}
+
+// additionalTaxTxs returns a function that itself takes a block and modifies it
+// by adding the provided tax transactions and the tax they pay to the coinbase.
+func additionalTaxTxs(taxTxs []*wire.MsgTx, tax btcutil.Amount) func(*wire.MsgBlock) {
+	return func(b *wire.MsgBlock) {
+		for _, tx := range taxTxs {
+			b.AddTransaction(tx)
+		}
+		b.Transactions[0].TxOut[0].Value += int64(tax)
+	}
+}
+
+// GenerateExpiry returns a slice of tests that can be used to exercise the
+// consensus validation rules for sweeping expired coinbase outputs and
+// releasing the coins burned by expired OP_RETURN outputs along with the
+// network parameters the tests must be run against.
+//
+// The tests are run against a network where outputs expire after a short
+// valid chain length so they can be kept small.
+func GenerateExpiry() (tests [][]TestInstance, params *chaincfg.Params, err error) {
+	// In order to simplify the generation code which really should never
+	// fail unless the test code itself is broken, panics are used
+	// internally.  This deferred func ensures any panics don't escape the
+	// generator by replacing the named error return with the underlying
+	// panic error.
+	defer func() {
+		if r := recover(); r != nil {
+			tests = nil
+
+			switch rt := r.(type) {
+			case string:
+				err = errors.New(rt)
+			case error:
+				err = rt
+			default:
+				err = errors.New("Unknown panic")
+			}
+		}
+	}()
+
+	// Create a test generator instance initialized with the genesis block
+	// as the tip.
+	params = expiryNetParams()
+	g, err := makeTestGenerator(params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Define some convenience helper functions to populate the tests slice
+	// with test instances that have the described characteristics.
+	//
+	// accepted creates and appends a single acceptBlock test instance for
+	// the current tip which expects the block to be accepted to the main
+	// chain.
+	//
+	// rejected creates and appends a single rejectBlock test instance for
+	// the current tip.
+	accepted := func() {
+		tests = append(tests, []TestInstance{
+			AcceptedBlock{g.tipName, g.tip, g.tipHeight, true, false},
+		})
+	}
+	rejected := func(code blockchain.ErrorCode) {
+		tests = append(tests, []TestInstance{
+			RejectedBlock{g.tipName, g.tip, g.tipHeight, code},
+		})
+	}
+
+	// coinbaseOuts houses the coinbase output of each block by height.
+	coinbaseOuts := make(map[int32]*spendableOut)
+	nextBlock := func(blockName string, mungers ...func(*wire.MsgBlock)) {
+		g.nextBlock(blockName, nil, mungers...)
+		op := makeSpendableOut(g.tip, 0, 0)
+		coinbaseOuts[g.tipHeight] = &op
+	}
+
+	// sweepCoinbases returns the tax transactions which sweep the coinbase
+	// outputs of the blocks at the passed heights along with the total
+	// tax they pay.
+	sweepCoinbases := func(heights ...int32) ([]*wire.MsgTx, btcutil.Amount) {
+		var taxTxs []*wire.MsgTx
+		var totalTax btcutil.Amount
+		for _, height := range heights {
+			taxTx, tax := createTaxTx(params, coinbaseOuts[height])
+			taxTxs = append(taxTxs, taxTx)
+			totalTax += tax
+		}
+		return taxTxs, totalTax
+	}
+
+	// heightRange returns the heights from start to end, both inclusive,
+	// except the skipped heights.
+	heightRange := func(start, end int32, skip ...int32) []int32 {
+		var heights []int32
+	nextHeight:
+		for height := start; height <= end; height++ {
+			for _, skipHeight := range skip {
+				if height == skipHeight {
+					continue nextHeight
+				}
+			}
+			heights = append(heights, height)
+		}
+		return heights
+	}
+
+	// ---------------------------------------------------------------------
+	// Generate enough blocks for the first coinbase output to expire while
+	// activating taxation.
+	//
+	//   genesis -> bx1 -> bx2 -> ... -> bx21
+	// ---------------------------------------------------------------------
+
+	nextBlock("bx1", signalTaxation(params))
+	accepted()
+
+	// Attempt to sweep the coinbase output of bx1 before taxation is
+	// active.
+	//
+	//   genesis -> bx1
+	//                  \-> bx2a
+	taxTxs, tax := sweepCoinbases(1)
+	nextBlock("bx2a", additionalTaxTxs(taxTxs, tax))
+	rejected(blockchain.ErrImmatureHeightForTaxTx)
+
+	g.setTip("bx1")
+	for i := int32(2); i <= expiryValidChainLength+1; i++ {
+		nextBlock(fmt.Sprintf("bx%d", i))
+		accepted()
+	}
+
+	// Attempt to sweep the expired coinbase output of bx1 before full
+	// expiry begins.
+	//
+	//   ... -> bx21
+	//                \-> bx22a
+	taxTxs, tax = sweepCoinbases(1)
+	nextBlock("bx22a", additionalTaxTxs(taxTxs, tax))
+	rejected(blockchain.ErrCoinbaseTaxUTXO)
+
+	// Burn coins before full expiry begins.  The burned output is not kept
+	// in the utxo set.
+	//
+	//   ... -> bx21 -> bx22
+	g.setTip("bx21")
+	preActivationBurnTx := createBurnTx(coinbaseOuts[2])
+	nextBlock("bx22", additionalTx(preActivationBurnTx))
+	accepted()
+
+	for i := int32(23); i < expiryFullExpiryBeginHeight; i++ {
+		nextBlock(fmt.Sprintf("bx%d", i))
+		accepted()
+	}
+
+	// Burn coins once full expiry begins.  The burned output is kept in
+	// the utxo set.
+	//
+	//   ... -> bx29 -> bx30
+	burnTx := createBurnTx(coinbaseOuts[10])
+	nextBlock("bx30", additionalTx(burnTx))
+	accepted()
+
+	// Attempt to sweep the coins burned before full expiry began.
+	//
+	//   ... -> bx30
+	//              \-> bx31a
+	preActivationBurned := makeSpendableOutForTx(preActivationBurnTx, 1)
+	taxTx, tax := createTaxTx(params, coinbaseOuts[1],
+		&preActivationBurned)
+	nextBlock("bx31a", additionalTaxTxs([]*wire.MsgTx{taxTx}, tax))
+	rejected(blockchain.ErrMissingTxOut)
+
+	// Sweep the expired coinbase output of bx1 now that full expiry began.
+	//
+	//   ... -> bx30 -> bx31
+	g.setTip("bx30")
+	taxTxs, tax = sweepCoinbases(1)
+	nextBlock("bx31", additionalTaxTxs(taxTxs, tax))
+	accepted()
+
+	for i := int32(32); i < 40; i++ {
+		nextBlock(fmt.Sprintf("bx%d", i))
+		accepted()
+	}
+
+	// Attempt to sweep the expired coinbase outputs of bx4 through bx19
+	// while skipping the one of bx3.  The coinbase outputs of bx2 and bx10
+	// were already spent.
+	//
+	//   ... -> bx39
+	//              \-> bx40a
+	taxTxs, tax = sweepCoinbases(heightRange(4, 19, 10)...)
+	nextBlock("bx40a", additionalTaxTxs(taxTxs, tax))
+	rejected(blockchain.ErrUnmatchedTaxTxSequence)
+
+	// Sweep the expired coinbase outputs of bx3 through bx19 in sequence.
+	//
+	//   ... -> bx39 -> bx40
+	g.setTip("bx39")
+	taxTxs, tax = sweepCoinbases(heightRange(3, 19, 10)...)
+	nextBlock("bx40", additionalTaxTxs(taxTxs, tax))
+	accepted()
+
+	for i := int32(41); i < 52; i++ {
+		nextBlock(fmt.Sprintf("bx%d", i))
+		accepted()
+	}
+
+	// Sweep the remaining expired outputs of bx20 through bx30, which
+	// includes the change outputs of both burn transactions and the coins
+	// burned after full expiry began.  These are all of the outputs left in
+	// those blocks, so they become free of utxos.
+	taxTxs, tax = sweepCoinbases(heightRange(20, 30)...)
+	preActivationChange := makeSpendableOutForTx(preActivationBurnTx, 0)
+	taxTx, burnTax := createTaxTx(params, &preActivationChange)
+	taxTxs = append(taxTxs, taxTx)
+	tax += burnTax
+	change := makeSpendableOutForTx(burnTx, 0)
+	burned := makeSpendableOutForTx(burnTx, 1)
+	taxTx, burnTax = createTaxTx(params, &change, &burned)
+
+	// Attempt to leave the burned coins in the utxo set.
+	//
+	//   ... -> bx51
+	//              \-> bx52a
+	changeTaxTx, changeTax := createTaxTx(params, &change)
+	nextBlock("bx52a", additionalTaxTxs(append(taxTxs[:len(taxTxs):len(taxTxs)],
+		changeTaxTx), tax+changeTax))
+	rejected(blockchain.ErrUnmatchedTaxTxSequence)
+
+	// Attempt to claim more than the released burned coins.
+	//
+	//   ... -> bx51
+	//              \-> bx52b
+	g.setTip("bx51")
+	nextBlock("bx52b", additionalTaxTxs(append(taxTxs[:len(taxTxs):len(taxTxs)],
+		taxTx), tax+burnTax), additionalCoinbase(1))
+	rejected(blockchain.ErrBadCoinbaseValue)
+
+	// Release the burned coins to the miner in their entirety.
+	//
+	//   ... -> bx51 -> bx52
+	g.setTip("bx51")
+	nextBlock("bx52", additionalTaxTxs(append(taxTxs, taxTx), tax+burnTax))
+	accepted()
+
+	return tests, params, nil
+}
@@ -441,6 +441,56 @@ func TestCheckTxTaxAmount(t *testing.T) {
 	}
 }
 
+func TestTaxedInputAmounts(t *testing.T) {
+	params := &chaincfg.MainNetParams
+
+	// PK1 contains addr: 12hnbu1xVuYF4VhaXYFyw6Pq2eudj3CG4g
+	pk1, _ := hex.DecodeString("76a91412aed4a2fed565f0f473b3688e60246576710f2a88ac")
+	inputAmount1 := int64(params.DustSatoshiAmount) * int64(10)
+	tax1 := inputAmount1 * int64(params.TaxRate) / 100
+	// PK2 contains addr: 1ECRPUBJECFWcB73R6ydUQNJ6Ane8qYPr2
+	pk2, _ := hex.DecodeString("76a91490c2917e7a89f3ec8a1bb82db92661dcab14fcc488ac")
+	inputAmount2 := int64(params.DustSatoshiAmount)
+
+	taxTx := wire.NewMsgTx(int32(1))
+	taxTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{0x01}},
+	})
+	taxTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{0x02}},
+	})
+	taxTx.AddTxOut(&wire.TxOut{Value: inputAmount1 - tax1, PkScript: pk1})
+	stxos := []SpentTxOut{
+		{Amount: inputAmount1, PkScript: pk1, Height: 6000},
+		{Amount: inputAmount2, PkScript: pk2, Height: 6000},
+	}
+
+	// The first input is taxed at the tax rate while the dust input is
+	// swept entirely.
+	taxed, err := TaxedInputAmounts(btcutil.NewTx(taxTx), stxos, params)
+	if err != nil {
+		t.Fatalf("TaxedInputAmounts: unexpected error: %v", err)
+	}
+	want := []int64{tax1, inputAmount2}
+	if len(taxed) != len(want) {
+		t.Fatalf("TaxedInputAmounts: got %d amounts, want %d",
+			len(taxed), len(want))
+	}
+	for i := range want {
+		if taxed[i] != want[i] {
+			t.Errorf("TaxedInputAmounts #%d: got %d, want %d", i,
+				taxed[i], want[i])
+		}
+	}
+
+	// A mismatched number of spent outputs must be rejected.
+	_, err = TaxedInputAmounts(btcutil.NewTx(taxTx), stxos[:1], params)
+	if err == nil {
+		t.Fatal("TaxedInputAmounts: expected error for mismatched " +
+			"spent outputs")
+	}
+}
+
 func TestFetchAndValidateExpiredUtxosAndLargestHeight(t *testing.T) {
 	ctl := gomock.NewController(t)
 	mockedUtxoViewPoint := mock_blockchain.NewMockUtxoViewpointInterface(ctl)
@@ -534,7 +584,9 @@ func TestFetchAndValidateExpiredUtxosAndLargestHeight(t *testing.T) {
 		},
 	).Return(utxo22)
 
-	expectedUtxos, expectedHeight, error := fetchAndValidateExpiredUtxosAndLargestHeight(taxTxs, mockedUtxoViewPoint)
+	expectedUtxos, expectedHeight, error := fetchAndValidateExpiredUtxosAndLargestHeight(taxTxs,
+		mockedUtxoViewPoint, 8001+chaincfg.MainNetParams.ValidChainLength,
+		&chaincfg.MainNetParams, false)
 
 	if error != nil {
 		t.Errorf("something went wrong when testing FetchAndValidateExpiredUtxosAndLargestHeight %v", error)
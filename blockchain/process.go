@@ -10,6 +10,7 @@ import (
 
 	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
 	"github.com/organicbitcoin/obtcd/database"
+	"github.com/organicbitcoin/obtcd/wire"
 	"github.com/organicbitcoin/btcutil"
 )
 
@@ -34,12 +35,14 @@ const (
 )
 
 // blockExists determines whether a block with the given hash exists either in
-// the main chain or any side chains.
+// the main chain or any side chains.  Blocks for which only the header is
+// known are not considered to exist.
 //
 // This function is safe for concurrent access.
 func (b *BlockChain) blockExists(hash *chainhash.Hash) (bool, error) {
 	// Check block index first (could be main chain or side chain blocks).
-	if b.index.HaveBlock(hash) {
+	node := b.index.LookupNode(hash)
+	if node != nil && b.index.NodeStatus(node).HaveData() {
 		return true, nil
 	}
 
@@ -242,3 +245,57 @@ func (b *BlockChain) ProcessBlock(block *btcutil.Block, flags BehaviorFlags) (bo
 
 	return isMainChain, false, nil
 }
+
+// ProcessBlockHeader is the main workhorse for handling insertion of new block
+// headers into the block index ahead of their blocks.  It includes
+// functionality such as rejecting duplicate headers, ensuring headers follow
+// all context free and contextual rules, and inserting them into the block
+// index without block data.
+//
+// Unlike blocks, headers which do not connect to a known header are rejected
+// with ErrPreviousBlockUnknown rather than being held as orphans.  Headers are
+// written to the database along with the next change to the block index.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ProcessBlockHeader(header *wire.BlockHeader, flags BehaviorFlags) error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	// Nothing more to do when the header is already known, unless it has
+	// been found to be invalid.
+	blockHash := header.BlockHash()
+	if node := b.index.LookupNode(&blockHash); node != nil {
+		if b.index.NodeStatus(node).KnownInvalid() {
+			str := fmt.Sprintf("block %v is known to be invalid",
+				blockHash)
+			return ruleError(ErrInvalidAncestorBlock, str)
+		}
+		return nil
+	}
+
+	prevHash := &header.PrevBlock
+	prevNode := b.index.LookupNode(prevHash)
+	if prevNode == nil {
+		str := fmt.Sprintf("previous block %s is unknown", prevHash)
+		return ruleError(ErrPreviousBlockUnknown, str)
+	} else if b.index.NodeStatus(prevNode).KnownInvalid() {
+		str := fmt.Sprintf("previous block %s is known to be invalid", prevHash)
+		return ruleError(ErrInvalidAncestorBlock, str)
+	}
+
+	err := checkBlockHeaderSanity(header, b.chainParams.PowLimit,
+		b.timeSource, flags)
+	if err != nil {
+		return err
+	}
+	err = b.checkBlockHeaderContext(header, prevNode, flags)
+	if err != nil {
+		return err
+	}
+
+	b.index.AddNode(newBlockNode(header, prevNode))
+
+	log.Tracef("Accepted block header %v", blockHash)
+
+	return nil
+}
@@ -238,6 +238,19 @@ func ValidateTransactionScripts(tx *btcutil.Tx, utxoView *UtxoViewpoint,
 	return validator.Validate(txValItems)
 }
 
+// spendsBurnedOutput returns whether the passed input spends an output in the
+// provided view which burns coins.
+func spendsBurnedOutput(txIn *wire.TxIn, utxoView *UtxoViewpoint) bool {
+	entry := utxoView.LookupEntry(txIn.PreviousOutPoint)
+	if entry == nil {
+		return false
+	}
+	return isBurnedOutput(&wire.TxOut{
+		Value:    entry.Amount,
+		PkScript: entry.PkScript,
+	})
+}
+
 // checkBlockScripts executes and validates the scripts for all transactions in
 // the passed block using multiple goroutines.
 func checkBlockScripts(block *btcutil.Block, utxoView *UtxoViewpoint,
@@ -284,6 +297,13 @@ func checkBlockScripts(block *btcutil.Block, utxoView *UtxoViewpoint,
 				continue
 			}
 
+			// Skip the inputs of tax transactions which release
+			// expired burned coins since no script can spend the
+			// provably unspendable outputs which burned them.
+			if tx.IsTaxTx() && spendsBurnedOutput(txIn, utxoView) {
+				continue
+			}
+
 			txVI := &txValidateItem{
 				txInIndex: txInIdx,
 				txIn:      txIn,
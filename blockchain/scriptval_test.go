@@ -9,7 +9,9 @@ import (
 	"runtime"
 	"testing"
 
+	"github.com/organicbitcoin/btcutil"
 	"github.com/organicbitcoin/obtcd/txscript"
+	"github.com/organicbitcoin/obtcd/wire"
 )
 
 // TestCheckBlockScripts ensures that validating the all of the scripts in a
@@ -47,3 +49,90 @@ func TestCheckBlockScripts(t *testing.T) {
 		return
 	}
 }
+
+// TestCheckBlockScriptsTaxTxs ensures the scripts of tax transactions are
+// validated apart from the inputs which release burned coins.
+func TestCheckBlockScriptsTaxTxs(t *testing.T) {
+	// Create an output which requires a signature to be spent along with
+	// an output which burns coins.
+	pkScript, err := txscript.NewScriptBuilder().AddOp(txscript.OP_DUP).
+		AddOp(txscript.OP_HASH160).AddData(make([]byte, 20)).
+		AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG).
+		Script()
+	if err != nil {
+		t.Fatalf("unable to create script: %v", err)
+	}
+	prevTx := wire.NewMsgTx(1)
+	prevTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Index: 0},
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	prevTx.AddTxOut(wire.NewTxOut(5000, pkScript))
+	prevTx.AddTxOut(wire.NewTxOut(1000, []byte{txscript.OP_RETURN}))
+	view := NewUtxoViewpoint()
+	view.addTxOuts(btcutil.NewTx(prevTx), 1, 1000, true)
+
+	// createTx returns a transaction of the passed type which spends the
+	// given outputs of the previous transaction without any signatures.
+	createTx := func(txType byte, indexes ...uint32) *wire.MsgTx {
+		tx := wire.NewMsgTx(1)
+		tx.Type = txType
+		for _, index := range indexes {
+			tx.AddTxIn(&wire.TxIn{
+				PreviousOutPoint: wire.OutPoint{
+					Hash:  prevTx.TxHash(),
+					Index: index,
+				},
+				Sequence: wire.MaxTxInSequenceNum,
+				Witness:  wire.TxWitness{{txscript.OP_TRUE}},
+			})
+		}
+		tx.AddTxOut(wire.NewTxOut(500, pkScript))
+		return tx
+	}
+
+	tests := []struct {
+		name  string
+		tx    *wire.MsgTx
+		valid bool
+	}{
+		{
+			name:  "tax tx sweeping output without signature",
+			tx:    createTx(0x11, 0),
+			valid: false,
+		},
+		{
+			name:  "tax tx releasing burned coins",
+			tx:    createTx(0x11, 1),
+			valid: true,
+		},
+		{
+			name:  "tax tx releasing burned coins and sweeping output",
+			tx:    createTx(0x11, 1, 0),
+			valid: false,
+		},
+		{
+			name:  "regular tx spending burned output",
+			tx:    createTx(0x00, 1),
+			valid: false,
+		},
+	}
+
+	for _, test := range tests {
+		block := btcutil.NewBlock(&wire.MsgBlock{
+			Transactions: []*wire.MsgTx{test.tx},
+		})
+		err := checkBlockScripts(block, view, txscript.ScriptBip16,
+			nil, nil)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !test.valid {
+			if _, ok := err.(RuleError); !ok {
+				t.Errorf("%s: expected rule error, got %v",
+					test.name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,362 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
+	"github.com/organicbitcoin/obtcd/txscript"
+	"github.com/organicbitcoin/obtcd/wire"
+	"github.com/organicbitcoin/btcutil"
+)
+
+const (
+	// signetScriptFlags are the script flags used to verify the solution
+	// of a signet block against the challenge of the network as defined
+	// by BIP0325.
+	signetScriptFlags = txscript.ScriptBip16 |
+		txscript.ScriptVerifyWitness |
+		txscript.ScriptVerifyDERSignatures |
+		txscript.ScriptStrictMultiSig
+
+	// signetBlockDataLen is the length of the block data committed to by
+	// the solution of a signet block.  It consists of the version, the
+	// previous block hash, the signet merkle root and the timestamp of the
+	// block.
+	signetBlockDataLen = 4 + chainhash.HashSize*2 + 4
+)
+
+// SignetHeader is the prefix marker of the data push within the witness
+// commitment output of a signet block which holds the solution to the
+// challenge of the network as defined by BIP0325.
+var SignetHeader = []byte{0xec, 0xc7, 0xda, 0xa2}
+
+// appendSignetPush appends the passed data to the script as a data push
+// using the smallest push opcode able to hold it.  Unlike the script builder,
+// it never converts the data to a small integer opcode, which matches the
+// serialization BIP0325 mandates for the witness commitment once the solution
+// is removed from it.
+func appendSignetPush(script, data []byte) []byte {
+	dataLen := len(data)
+	switch {
+	case dataLen < txscript.OP_PUSHDATA1:
+		script = append(script, byte(dataLen))
+	case dataLen <= 0xff:
+		script = append(script, txscript.OP_PUSHDATA1, byte(dataLen))
+	case dataLen <= 0xffff:
+		var buf [2]byte
+		binary.LittleEndian.PutUint16(buf[:], uint16(dataLen))
+		script = append(script, txscript.OP_PUSHDATA2)
+		script = append(script, buf[:]...)
+	default:
+		var buf [4]byte
+		binary.LittleEndian.PutUint32(buf[:], uint32(dataLen))
+		script = append(script, txscript.OP_PUSHDATA4)
+		script = append(script, buf[:]...)
+	}
+	return append(script, data...)
+}
+
+// signetCommitment describes the signet solution held by the witness
+// commitment script of a block.
+type signetCommitment struct {
+	// solution is the data following the signet header in the data push
+	// holding the solution.
+	solution []byte
+
+	// stripped is the witness commitment script with the solution removed
+	// as serialized by BIP0325.
+	stripped []byte
+
+	// pushStart and pushEnd are the offsets of the data push holding the
+	// solution within the original script.
+	pushStart int
+	pushEnd   int
+}
+
+// extractSignetSolution returns the signet solution held by the passed witness
+// commitment script, or nil when there is none.  The solution is the data
+// following the signet header of the first data push which starts with it and
+// holds more data.
+//
+// The script without the solution is serialized the way BIP0325 mandates,
+// which means every data push is serialized again using the smallest push
+// opcode able to hold it, empty data pushes are serialized as their opcode and
+// anything from an invalid data push onwards is dropped.
+func extractSignetSolution(script []byte) *signetCommitment {
+	var commitment *signetCommitment
+	stripped := make([]byte, 0, len(script))
+	i := 0
+out:
+	for i < len(script) {
+		pushStart := i
+		opcode := script[i]
+		i++
+
+		// Determine the length of the data pushed by the opcode, if any.
+		var dataLen int
+		switch {
+		case opcode > txscript.OP_0 && opcode < txscript.OP_PUSHDATA1:
+			dataLen = int(opcode)
+		case opcode == txscript.OP_PUSHDATA1:
+			if len(script)-i < 1 {
+				break out
+			}
+			dataLen = int(script[i])
+			i++
+		case opcode == txscript.OP_PUSHDATA2:
+			if len(script)-i < 2 {
+				break out
+			}
+			dataLen = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		case opcode == txscript.OP_PUSHDATA4:
+			if len(script)-i < 4 {
+				break out
+			}
+			dataLen = int(binary.LittleEndian.Uint32(script[i:]))
+			i += 4
+		}
+		if dataLen < 0 || dataLen > len(script)-i {
+			break
+		}
+		data := script[i : i+dataLen]
+		i += dataLen
+
+		if len(data) == 0 {
+			stripped = append(stripped, opcode)
+			continue
+		}
+		if commitment == nil && len(data) > len(SignetHeader) &&
+			bytes.HasPrefix(data, SignetHeader) {
+
+			commitment = &signetCommitment{
+				solution:  data[len(SignetHeader):],
+				pushStart: pushStart,
+				pushEnd:   i,
+			}
+			data = data[:len(SignetHeader)]
+		}
+		stripped = appendSignetPush(stripped, data)
+	}
+
+	if commitment != nil {
+		commitment.stripped = stripped
+	}
+	return commitment
+}
+
+// witnessCommitmentIndex returns the index of the output of the passed
+// coinbase transaction which holds the witness commitment, or -1 when there is
+// none.  It locates the output the same way ExtractWitnessCommitment does.
+func witnessCommitmentIndex(coinbase *wire.MsgTx) int {
+	for i := len(coinbase.TxOut) - 1; i >= 0; i-- {
+		pkScript := coinbase.TxOut[i].PkScript
+		if len(pkScript) >= CoinbaseWitnessPkScriptLength &&
+			bytes.HasPrefix(pkScript, WitnessMagicBytes) {
+
+			return i
+		}
+	}
+	return -1
+}
+
+// parseSignetSolution parses the passed signet solution into the signature
+// script and the witness it consists of.
+func parseSignetSolution(solution []byte) ([]byte, wire.TxWitness, error) {
+	r := bytes.NewReader(solution)
+	sigScript, err := wire.ReadVarBytes(r, 0, uint32(len(solution)),
+		"signet signature script")
+	if err != nil {
+		return nil, nil, err
+	}
+	count, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if count > uint64(r.Len()) {
+		return nil, nil, fmt.Errorf("signet solution witness has %d "+
+			"items which exceeds the %d remaining bytes", count,
+			r.Len())
+	}
+	var witness wire.TxWitness
+	if count > 0 {
+		witness = make(wire.TxWitness, count)
+	}
+	for i := range witness {
+		witness[i], err = wire.ReadVarBytes(r, 0, uint32(len(solution)),
+			"signet witness item")
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if r.Len() != 0 {
+		return nil, nil, fmt.Errorf("signet solution has %d trailing "+
+			"bytes", r.Len())
+	}
+	return sigScript, witness, nil
+}
+
+// serializeSignetSolution returns the passed signature script and witness
+// serialized as a signet solution.
+func serializeSignetSolution(sigScript []byte, witness wire.TxWitness) []byte {
+	var buf bytes.Buffer
+	_ = wire.WriteVarBytes(&buf, 0, sigScript)
+	_ = wire.WriteVarInt(&buf, 0, uint64(len(witness)))
+	for _, item := range witness {
+		_ = wire.WriteVarBytes(&buf, 0, item)
+	}
+	return buf.Bytes()
+}
+
+// SignetTxs returns the virtual transactions BIP0325 defines for the passed
+// block on the signet with the passed challenge.  The first one spends nothing
+// and pays to the challenge while committing to the block, and the second one
+// spends it using the solution held by the block, if any.  A block is valid on
+// the signet when the second transaction is a valid spend of the first one, so
+// signing its input with the keys of the challenge produces the solution of the
+// block.  Since the block is committed to with the signet header left in place
+// of its solution, the block has to hold a solution, which may be empty, when
+// the transactions are created for signing.
+//
+// An error is returned when the block has no witness commitment to hold the
+// solution or when the solution it holds is malformed.
+func SignetTxs(block *wire.MsgBlock, challenge []byte) (*wire.MsgTx, *wire.MsgTx, error) {
+	if len(block.Transactions) == 0 {
+		return nil, nil, ruleError(ErrNoTransactions, "signet block "+
+			"does not contain any transactions")
+	}
+	coinbase := block.Transactions[0]
+	commitmentIdx := witnessCommitmentIndex(coinbase)
+	if commitmentIdx < 0 {
+		return nil, nil, ruleError(ErrBadSignetSolution, "signet block "+
+			"does not contain a witness commitment")
+	}
+
+	// Extract the solution from the witness commitment and parse it.
+	commitment := coinbase.TxOut[commitmentIdx]
+	stripped := commitment.PkScript
+	var sigScript []byte
+	var witness wire.TxWitness
+	if signet := extractSignetSolution(commitment.PkScript); signet != nil {
+		stripped = signet.stripped
+		var err error
+		sigScript, witness, err = parseSignetSolution(signet.solution)
+		if err != nil {
+			str := fmt.Sprintf("malformed signet solution: %v", err)
+			return nil, nil, ruleError(ErrBadSignetSolution, str)
+		}
+	}
+
+	// Calculate the merkle root of the block with the solution removed
+	// from the witness commitment of the coinbase transaction.
+	modifiedCoinbase := *coinbase
+	modifiedCoinbase.TxOut = make([]*wire.TxOut, len(coinbase.TxOut))
+	copy(modifiedCoinbase.TxOut, coinbase.TxOut)
+	modifiedCoinbase.TxOut[commitmentIdx] = wire.NewTxOut(commitment.Value,
+		stripped)
+	txns := make([]*btcutil.Tx, 0, len(block.Transactions))
+	txns = append(txns, btcutil.NewTx(&modifiedCoinbase))
+	for _, tx := range block.Transactions[1:] {
+		txns = append(txns, btcutil.NewTx(tx))
+	}
+	merkles := BuildMerkleTreeStore(txns, false)
+	merkleRoot := merkles[len(merkles)-1]
+
+	// Serialize the parts of the block header the solution commits to.
+	var blockData [signetBlockDataLen]byte
+	header := &block.Header
+	binary.LittleEndian.PutUint32(blockData[0:4], uint32(header.Version))
+	copy(blockData[4:36], header.PrevBlock[:])
+	copy(blockData[36:68], merkleRoot[:])
+	binary.LittleEndian.PutUint32(blockData[68:72],
+		uint32(header.Timestamp.Unix()))
+
+	toSpend := wire.NewMsgTx(0)
+	toSpend.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+		SignatureScript: appendSignetPush([]byte{txscript.OP_0},
+			blockData[:]),
+		Sequence: 0,
+	})
+	toSpend.AddTxOut(wire.NewTxOut(0, challenge))
+
+	toSign := wire.NewMsgTx(0)
+	toSign.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: toSpend.TxHash()},
+		SignatureScript:  sigScript,
+		Witness:          witness,
+		Sequence:         0,
+	})
+	toSign.AddTxOut(wire.NewTxOut(0, []byte{txscript.OP_RETURN}))
+
+	return toSpend, toSign, nil
+}
+
+// SetSignetSolution stores the passed signature script and witness as the
+// signet solution of the passed block, replacing the previous one if any, and
+// updates the merkle root of the block accordingly.  The solution is typically
+// obtained by signing the input of the second transaction returned by
+// SignetTxs.
+func SetSignetSolution(block *wire.MsgBlock, sigScript []byte, witness wire.TxWitness) error {
+	if len(block.Transactions) == 0 {
+		return ruleError(ErrNoTransactions, "signet block does not "+
+			"contain any transactions")
+	}
+	coinbase := block.Transactions[0]
+	commitmentIdx := witnessCommitmentIndex(coinbase)
+	if commitmentIdx < 0 {
+		return ruleError(ErrBadSignetSolution, "signet block does not "+
+			"contain a witness commitment")
+	}
+
+	// Remove the current solution, if any, and append the new one.
+	commitment := coinbase.TxOut[commitmentIdx]
+	pkScript := commitment.PkScript
+	if signet := extractSignetSolution(pkScript); signet != nil {
+		pkScript = append(pkScript[:signet.pushStart:signet.pushStart],
+			pkScript[signet.pushEnd:]...)
+	}
+	data := append(append([]byte(nil), SignetHeader...),
+		serializeSignetSolution(sigScript, witness)...)
+	commitment.PkScript = appendSignetPush(pkScript, data)
+
+	// The merkle root changes along with the coinbase transaction.
+	txns := make([]*btcutil.Tx, 0, len(block.Transactions))
+	for _, tx := range block.Transactions {
+		txns = append(txns, btcutil.NewTx(tx))
+	}
+	merkles := BuildMerkleTreeStore(txns, false)
+	block.Header.MerkleRoot = *merkles[len(merkles)-1]
+	return nil
+}
+
+// checkSignetSolution ensures the passed block provides a valid solution to the
+// passed signet challenge as defined by BIP0325.  The genesis block does not
+// have to provide one.
+func checkSignetSolution(block *btcutil.Block, challenge []byte, genesisHash *chainhash.Hash) error {
+	if block.Hash().IsEqual(genesisHash) {
+		return nil
+	}
+
+	toSpend, toSign, err := SignetTxs(block.MsgBlock(), challenge)
+	if err != nil {
+		return err
+	}
+	vm, err := txscript.NewEngine(toSpend.TxOut[0].PkScript, toSign, 0,
+		signetScriptFlags, nil, txscript.NewTxSigHashes(toSign), 0)
+	if err == nil {
+		err = vm.Execute()
+	}
+	if err != nil {
+		str := fmt.Sprintf("block %v does not provide a valid signet "+
+			"solution: %v", block.Hash(), err)
+		return ruleError(ErrBadSignetSolution, str)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/organicbitcoin/obtcd/btcec"
+	"github.com/organicbitcoin/obtcd/chaincfg"
+	"github.com/organicbitcoin/obtcd/txscript"
+	"github.com/organicbitcoin/obtcd/wire"
+	"github.com/organicbitcoin/btcutil"
+)
+
+// TestExtractSignetSolution ensures the signet solution is extracted from a
+// witness commitment script and the script without the solution is serialized
+// as defined by BIP0325.
+func TestExtractSignetSolution(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		script   []byte
+		solution []byte
+		stripped []byte
+	}{{
+		name:   "no solution",
+		script: []byte{txscript.OP_RETURN, 0x04, 0xec, 0xc7, 0xda, 0xa2},
+	}, {
+		name: "solution",
+		script: []byte{txscript.OP_RETURN, 0x05, 0xec, 0xc7, 0xda,
+			0xa2, 0x01},
+		solution: []byte{0x01},
+		stripped: []byte{txscript.OP_RETURN, 0x04, 0xec, 0xc7, 0xda,
+			0xa2},
+	}, {
+		name: "only first solution",
+		script: []byte{0x05, 0xec, 0xc7, 0xda, 0xa2, 0x01, 0x05, 0xec,
+			0xc7, 0xda, 0xa2, 0x02},
+		solution: []byte{0x01},
+		stripped: []byte{0x04, 0xec, 0xc7, 0xda, 0xa2, 0x05, 0xec,
+			0xc7, 0xda, 0xa2, 0x02},
+	}, {
+		name: "non-canonical pushes",
+		script: []byte{txscript.OP_PUSHDATA1, 0x01, 0x01,
+			txscript.OP_PUSHDATA1, 0x00, txscript.OP_PUSHDATA2, 0x05,
+			0x00, 0xec, 0xc7, 0xda, 0xa2, 0x01},
+		solution: []byte{0x01},
+		stripped: []byte{0x01, 0x01, txscript.OP_PUSHDATA1, 0x04,
+			0xec, 0xc7, 0xda, 0xa2},
+	}, {
+		name: "truncated push",
+		script: []byte{0x05, 0xec, 0xc7, 0xda, 0xa2, 0x01, 0x02,
+			0x01},
+		solution: []byte{0x01},
+		stripped: []byte{0x04, 0xec, 0xc7, 0xda, 0xa2},
+	}}
+
+	for _, test := range tests {
+		commitment := extractSignetSolution(test.script)
+		if test.solution == nil {
+			if commitment != nil {
+				t.Errorf("%s: unexpected solution %x", test.name,
+					commitment.solution)
+			}
+			continue
+		}
+		if commitment == nil {
+			t.Errorf("%s: solution not found", test.name)
+			continue
+		}
+		if !bytes.Equal(commitment.solution, test.solution) {
+			t.Errorf("%s: unexpected solution - got %x, want %x",
+				test.name, commitment.solution, test.solution)
+		}
+		if !bytes.Equal(commitment.stripped, test.stripped) {
+			t.Errorf("%s: unexpected stripped script - got %x, "+
+				"want %x", test.name, commitment.stripped,
+				test.stripped)
+		}
+	}
+}
+
+// TestSignetSolution ensures blocks are only accepted on a signet when they
+// provide a valid solution to its challenge.
+func TestSignetSolution(t *testing.T) {
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatalf("NewPrivateKey: unexpected error: %v", err)
+	}
+	challenge, err := txscript.NewScriptBuilder().
+		AddData(key.PubKey().SerializeCompressed()).
+		AddOp(txscript.OP_CHECKSIG).Script()
+	if err != nil {
+		t.Fatalf("Script: unexpected error: %v", err)
+	}
+
+	// Use the regression test network with a signet challenge so blocks
+	// are cheap to solve.
+	params := chaincfg.RegressionNetParams
+	params.SignetChallenge = challenge
+	chain, teardownFunc, err := chainSetup("signetsolution", &params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// Create a block with a witness commitment to hold the solution.
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+		SignatureScript:  []byte{txscript.OP_1, txscript.OP_1},
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	coinbase.AddTxOut(wire.NewTxOut(CalcBlockSubsidy(1, &params),
+		[]byte{txscript.OP_TRUE}))
+	coinbase.AddTxOut(wire.NewTxOut(0, append(append([]byte(nil),
+		WitnessMagicBytes...), make([]byte, 32)...)))
+	genesis := params.GenesisBlock.Header
+	block := &wire.MsgBlock{
+		Header: wire.BlockHeader{
+			Version:    vbTopBits,
+			PrevBlock:  *params.GenesisHash,
+			MerkleRoot: coinbase.TxHash(),
+			Timestamp:  genesis.Timestamp.Add(time.Second),
+			Bits:       params.PowLimitBits,
+		},
+		Transactions: []*wire.MsgTx{coinbase},
+	}
+	solve := func(block *wire.MsgBlock) *btcutil.Block {
+		target := CompactToBig(block.Header.Bits)
+		for {
+			hash := block.Header.BlockHash()
+			if HashToBig(&hash).Cmp(target) <= 0 {
+				break
+			}
+			block.Header.Nonce++
+		}
+		return btcutil.NewBlock(block)
+	}
+	sign := func(block *wire.MsgBlock) {
+		if err := SetSignetSolution(block, nil, nil); err != nil {
+			t.Fatalf("SetSignetSolution: unexpected error: %v", err)
+		}
+		_, toSign, err := SignetTxs(block, challenge)
+		if err != nil {
+			t.Fatalf("SignetTxs: unexpected error: %v", err)
+		}
+		sig, err := txscript.RawTxInSignature(toSign, 0, challenge,
+			txscript.SigHashAll, key)
+		if err != nil {
+			t.Fatalf("RawTxInSignature: unexpected error: %v", err)
+		}
+		sigScript, err := txscript.NewScriptBuilder().AddData(sig).Script()
+		if err != nil {
+			t.Fatalf("Script: unexpected error: %v", err)
+		}
+		if err := SetSignetSolution(block, sigScript, nil); err != nil {
+			t.Fatalf("SetSignetSolution: unexpected error: %v", err)
+		}
+	}
+
+	// Ensure the block is rejected without a solution.
+	_, _, err = chain.ProcessBlock(solve(block), BFNone)
+	if rerr, ok := err.(RuleError); !ok ||
+		rerr.ErrorCode != ErrBadSignetSolution {
+
+		t.Fatalf("ProcessBlock: unexpected error for unsigned block: %v",
+			err)
+	}
+
+	// Ensure the block is rejected when it changes after being signed.
+	sign(block)
+	err = checkSignetSolution(btcutil.NewBlock(block), challenge,
+		params.GenesisHash)
+	if err != nil {
+		t.Fatalf("checkSignetSolution: unexpected error for signed "+
+			"block: %v", err)
+	}
+	block.Header.Timestamp = block.Header.Timestamp.Add(time.Second)
+	_, _, err = chain.ProcessBlock(solve(block), BFNone)
+	if rerr, ok := err.(RuleError); !ok ||
+		rerr.ErrorCode != ErrBadSignetSolution {
+
+		t.Fatalf("ProcessBlock: unexpected error for modified block: %v",
+			err)
+	}
+
+	// Sign the block again, which replaces the previous solution, and
+	// ensure it is accepted.
+	sign(block)
+	commitment := block.Transactions[0].TxOut[1].PkScript
+	if bytes.Count(commitment, SignetHeader) != 1 {
+		t.Fatalf("SetSignetSolution: previous solution not replaced %x",
+			commitment)
+	}
+	isMainChain, _, err := chain.ProcessBlock(solve(block), BFNone)
+	if err != nil {
+		t.Fatalf("ProcessBlock: unexpected error for signed block: %v",
+			err)
+	}
+	if !isMainChain {
+		t.Fatal("ProcessBlock: signed block not on the main chain")
+	}
+}
@@ -0,0 +1,794 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/organicbitcoin/obtcd/chaincfg"
+	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
+	"github.com/organicbitcoin/obtcd/database"
+	"github.com/organicbitcoin/obtcd/utxo"
+	"github.com/organicbitcoin/obtcd/wire"
+	"github.com/organicbitcoin/btcutil"
+)
+
+// -----------------------------------------------------------------------------
+// A utxo snapshot contains the unspent transaction output set as of a block
+// along with everything needed to initialize a chain state at that block, so a
+// node can be bootstrapped from it without processing the history of the
+// chain first.
+//
+// The serialized format is:
+//
+//   <header><block headers><base block><utxo records>
+//
+//   Field            Type             Size
+//   header           utxoSnapshotHeader  99
+//   block headers    []wire.BlockHeader  80 * (base height - 1)
+//   base block       wire.MsgBlock       variable
+//   utxo records     []utxo record       variable
+//
+// The header is serialized as:
+//
+//   Field            Type             Size
+//   magic            [5]byte          5
+//   version          uint16           2
+//   network          wire.BitcoinNet  4
+//   base block hash  chainhash.Hash   32
+//   base height      uint32           4
+//   total txns       uint64           8
+//   num txouts       uint64           8
+//   txoutset hash    chainhash.Hash   32
+//   tax sweep height int32            4
+//
+// The tax sweep height is the highest height of the utxos swept by the tax
+// transactions of the latest block up to the base block which contains any, or
+// -1 when there is none.  The blocks after the base block have to sweep the
+// expired utxos from the following heights.
+//
+// The block headers are those of the blocks between the genesis block and the
+// base block in height order.
+//
+// Each utxo record is serialized as:
+//
+//   <hash><output index><entry size><entry>
+//
+//   Field            Type             Size
+//   hash             chainhash.Hash   32
+//   output index     VLQ              variable
+//   entry size       VLQ              variable
+//   entry            utxo entry       entry size
+//
+// The records are ordered by their serialized outpoints, which are the keys of
+// the utxo set bucket, and the entries are serialized the same way as in the
+// utxo set bucket.
+//
+// The txoutset hash is the double sha256 of all of the serialized utxo records
+// in order.  A snapshot can only be loaded when the hash and the tax sweep
+// height are pinned for its base block in the chain parameters.
+// -----------------------------------------------------------------------------
+
+const (
+	// utxoSnapshotVersion is the current version of the utxo snapshot
+	// format.
+	utxoSnapshotVersion = 2
+
+	// utxoSnapshotHeaderSize is the size of the serialized header of a
+	// utxo snapshot.
+	utxoSnapshotHeaderSize = 5 + 2 + 4 + chainhash.HashSize + 4 + 8 + 8 +
+		chainhash.HashSize + 4
+
+	// utxoSnapshotBatchSize is the number of utxos written to the database
+	// in a single transaction while loading a utxo snapshot.
+	utxoSnapshotBatchSize = 100000
+
+	// maxUtxoSnapshotEntrySize is the maximum size of a serialized utxo
+	// entry in a utxo snapshot.  It is used to reject records which are
+	// obviously malformed before allocating memory for them.
+	maxUtxoSnapshotEntrySize = 2 * wire.MaxBlockPayload
+)
+
+// utxoSnapshotMagic identifies the start of a utxo snapshot.
+var utxoSnapshotMagic = [5]byte{'u', 't', 'x', 'o', 0xff}
+
+// UtxoSnapshotInfo describes the utxo set contained in a utxo snapshot.
+type UtxoSnapshotInfo struct {
+	BlockHash      chainhash.Hash // The hash of the base block.
+	Height         int32          // The height of the base block.
+	TotalTxns      uint64         // The total number of txns in the chain.
+	NumTxOuts      uint64         // The number of unspent outputs.
+	TxOutSetHash   chainhash.Hash // The hash committing to the utxo set.
+	TaxSweepHeight int32          // The height swept by the latest tax txns.
+}
+
+// serializeUtxoSnapshotHeader returns the serialized header of a utxo snapshot
+// for the passed network.
+func serializeUtxoSnapshotHeader(net wire.BitcoinNet, info *UtxoSnapshotInfo) []byte {
+	serialized := make([]byte, utxoSnapshotHeaderSize)
+	offset := copy(serialized, utxoSnapshotMagic[:])
+	binary.LittleEndian.PutUint16(serialized[offset:], utxoSnapshotVersion)
+	offset += 2
+	binary.LittleEndian.PutUint32(serialized[offset:], uint32(net))
+	offset += 4
+	offset += copy(serialized[offset:], info.BlockHash[:])
+	binary.LittleEndian.PutUint32(serialized[offset:], uint32(info.Height))
+	offset += 4
+	binary.LittleEndian.PutUint64(serialized[offset:], info.TotalTxns)
+	offset += 8
+	binary.LittleEndian.PutUint64(serialized[offset:], info.NumTxOuts)
+	offset += 8
+	offset += copy(serialized[offset:], info.TxOutSetHash[:])
+	binary.LittleEndian.PutUint32(serialized[offset:],
+		uint32(info.TaxSweepHeight))
+	return serialized
+}
+
+// deserializeUtxoSnapshotHeader parses the passed serialized header of a utxo
+// snapshot for the passed network.
+func deserializeUtxoSnapshotHeader(serialized []byte, net wire.BitcoinNet) (*UtxoSnapshotInfo, error) {
+	if len(serialized) != utxoSnapshotHeaderSize ||
+		!bytes.Equal(serialized[:5], utxoSnapshotMagic[:]) {
+
+		return nil, ruleError(ErrBadUtxoSnapshot, "utxo snapshot has an "+
+			"invalid header")
+	}
+	offset := 5
+	version := binary.LittleEndian.Uint16(serialized[offset:])
+	offset += 2
+	if version != utxoSnapshotVersion {
+		str := fmt.Sprintf("utxo snapshot version %d is not supported",
+			version)
+		return nil, ruleError(ErrBadUtxoSnapshot, str)
+	}
+	snapshotNet := wire.BitcoinNet(binary.LittleEndian.Uint32(serialized[offset:]))
+	offset += 4
+	if snapshotNet != net {
+		str := fmt.Sprintf("utxo snapshot is for network %v instead of "+
+			"%v", snapshotNet, net)
+		return nil, ruleError(ErrBadUtxoSnapshot, str)
+	}
+
+	var info UtxoSnapshotInfo
+	offset += copy(info.BlockHash[:], serialized[offset:])
+	info.Height = int32(binary.LittleEndian.Uint32(serialized[offset:]))
+	offset += 4
+	info.TotalTxns = binary.LittleEndian.Uint64(serialized[offset:])
+	offset += 8
+	info.NumTxOuts = binary.LittleEndian.Uint64(serialized[offset:])
+	offset += 8
+	offset += copy(info.TxOutSetHash[:], serialized[offset:])
+	info.TaxSweepHeight = int32(binary.LittleEndian.Uint32(
+		serialized[offset:]))
+	return &info, nil
+}
+
+// serializeUtxoSnapshotRecord returns the serialized utxo record for the passed
+// utxo set key and entry in a utxo snapshot.
+func serializeUtxoSnapshotRecord(key []byte, entry *utxo.UtxoEntry) ([]byte, error) {
+	serializedEntry, err := serializeUtxoEntry(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	size := len(key) + serializeSizeVLQ(uint64(len(serializedEntry))) +
+		len(serializedEntry)
+	serialized := make([]byte, size)
+	offset := copy(serialized, key)
+	offset += putVLQ(serialized[offset:], uint64(len(serializedEntry)))
+	copy(serialized[offset:], serializedEntry)
+	return serialized, nil
+}
+
+// readSnapshotVLQ reads a variable-length quantity from the passed reader and
+// appends its serialized bytes to the passed slice.
+func readSnapshotVLQ(r *bufio.Reader, serialized []byte) (uint64, []byte, error) {
+	var n uint64
+	for i := 0; ; i++ {
+		// A VLQ of a 64-bit number never takes more than 10 bytes.
+		if i == 10 {
+			return 0, nil, ruleError(ErrBadUtxoSnapshot, "utxo "+
+				"snapshot contains an invalid variable-length "+
+				"quantity")
+		}
+		val, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		serialized = append(serialized, val)
+		n = (n << 7) | uint64(val&0x7f)
+		if val&0x80 != 0x80 {
+			break
+		}
+		n++
+	}
+	return n, serialized, nil
+}
+
+// readUtxoSnapshotRecord reads a serialized utxo record from the passed reader
+// and returns the utxo set key and serialized entry it contains along with the
+// serialized record itself.
+func readUtxoSnapshotRecord(r *bufio.Reader) ([]byte, []byte, []byte, error) {
+	record := make([]byte, chainhash.HashSize, chainhash.HashSize+16)
+	if _, err := io.ReadFull(r, record); err != nil {
+		return nil, nil, nil, err
+	}
+	_, record, err := readSnapshotVLQ(r, record)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	keyLen := len(record)
+	entrySize, record, err := readSnapshotVLQ(r, record)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if entrySize == 0 || entrySize > maxUtxoSnapshotEntrySize {
+		str := fmt.Sprintf("utxo snapshot contains an entry of invalid "+
+			"size %d", entrySize)
+		return nil, nil, nil, ruleError(ErrBadUtxoSnapshot, str)
+	}
+	entryOffset := len(record)
+	record = append(record, make([]byte, entrySize)...)
+	if _, err := io.ReadFull(r, record[entryOffset:]); err != nil {
+		return nil, nil, nil, err
+	}
+	return record[:keyLen], record[entryOffset:], record, nil
+}
+
+// hashUtxoSet returns the hash committing to the utxo set in the database as
+// it is serialized in a utxo snapshot along with the number of outputs in it.
+// The passed function is invoked with every serialized utxo record unless it
+// is nil.
+func hashUtxoSet(dbTx database.Tx, fn func(record []byte) error) (*chainhash.Hash, uint64, error) {
+	hasher := sha256.New()
+	var numTxOuts uint64
+	err := dbForEachUtxoEntry(dbTx, func(key []byte, _ wire.OutPoint,
+		entry *utxo.UtxoEntry) error {
+
+		record, err := serializeUtxoSnapshotRecord(key, entry)
+		if err != nil {
+			return err
+		}
+		hasher.Write(record)
+		numTxOuts++
+
+		if fn != nil {
+			return fn(record)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	hash := chainhash.HashH(hasher.Sum(nil))
+	return &hash, numTxOuts, nil
+}
+
+// snapshotHeightIndexKey returns the key of the utxo with the passed utxo set
+// key created at the passed height in the snapshot height index.  The height is
+// serialized in big endian so the keys are ordered by height.
+func snapshotHeightIndexKey(height int32, key []byte) []byte {
+	indexKey := make([]byte, 4+len(key))
+	binary.BigEndian.PutUint32(indexKey, uint32(height))
+	copy(indexKey[4:], key)
+	return indexKey
+}
+
+// dbFetchSnapshotOutPoints uses an existing database transaction to return the
+// outpoints of the utxos created at the passed height which were loaded from
+// the utxo snapshot the chain state was loaded from.
+func dbFetchSnapshotOutPoints(dbTx database.Tx, height int32) ([]wire.OutPoint, error) {
+	var prefix [4]byte
+	binary.BigEndian.PutUint32(prefix[:], uint32(height))
+
+	var outPoints []wire.OutPoint
+	bucket := dbTx.Metadata().Bucket(snapshotHeightIndexBucketName)
+	cursor := bucket.Cursor()
+	for ok := cursor.Seek(prefix[:]); ok; ok = cursor.Next() {
+		key := cursor.Key()
+		if !bytes.HasPrefix(key, prefix[:]) {
+			break
+		}
+		outpoint, err := decodeOutpointKey(key[len(prefix):])
+		if err != nil {
+			return nil, err
+		}
+		outPoints = append(outPoints, outpoint)
+	}
+	return outPoints, nil
+}
+
+// findAssumeUtxo returns the utxo snapshot pinned in the passed chain
+// parameters for the block with the passed hash or nil when there is none.
+func findAssumeUtxo(chainParams *chaincfg.Params, hash *chainhash.Hash) *chaincfg.AssumeUtxo {
+	for i := range chainParams.AssumeUtxo {
+		if chainParams.AssumeUtxo[i].BlockHash.IsEqual(hash) {
+			return &chainParams.AssumeUtxo[i]
+		}
+	}
+	return nil
+}
+
+// DumpUtxoSnapshot writes a utxo snapshot of the utxo set as of the end of the
+// main chain to the passed writer and returns its description.  The snapshot is
+// written from a consistent view of the database, so blocks continue to be
+// processed while it is written.  See the documentation of LoadUtxoSnapshot
+// for how it is used.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) DumpUtxoSnapshot(w io.Writer) (*UtxoSnapshotInfo, error) {
+	// The blocks before the base of a utxo snapshot the chain state was
+	// loaded from are not available to include.
+	if b.snapshotBase != nil {
+		return nil, AssertError("a utxo snapshot can't be dumped from " +
+			"a chain state loaded from a utxo snapshot")
+	}
+
+	// Write all of the utxo set changes to the database and open a read
+	// only transaction on it while the chain lock is held, so the utxo set
+	// it sees is the one as of the end of the main chain.
+	b.chainLock.Lock()
+	tip := b.bestChain.Tip()
+	if err := b.utxoCache.flush(&tip.hash); err != nil {
+		b.chainLock.Unlock()
+		return nil, err
+	}
+	b.stateLock.RLock()
+	totalTxns := b.stateSnapshot.TotalTxns
+	b.stateLock.RUnlock()
+	dbTx, err := b.db.Begin(false)
+	b.chainLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	defer dbTx.Rollback()
+
+	info := &UtxoSnapshotInfo{
+		BlockHash: tip.hash,
+		Height:    tip.height,
+		TotalTxns: totalTxns,
+	}
+	info.TaxSweepHeight, err = b.dbFetchTaxSweepHeight(dbTx, tip)
+	if err != nil {
+		return nil, err
+	}
+
+	// The utxo set is read twice since the hash committing to it is part
+	// of the header.
+	hash, numTxOuts, err := hashUtxoSet(dbTx, nil)
+	if err != nil {
+		return nil, err
+	}
+	info.TxOutSetHash = *hash
+	info.NumTxOuts = numTxOuts
+	_, err = w.Write(serializeUtxoSnapshotHeader(b.chainParams.Net, info))
+	if err != nil {
+		return nil, err
+	}
+
+	for height := int32(1); height < tip.height; height++ {
+		header := tip.Ancestor(height).Header()
+		if err := header.Serialize(w); err != nil {
+			return nil, err
+		}
+	}
+	blockBytes, err := dbTx.FetchBlock(&tip.hash)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(blockBytes); err != nil {
+		return nil, err
+	}
+
+	_, _, err = hashUtxoSet(dbTx, func(record []byte) error {
+		_, err := w.Write(record)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// LoadUtxoSnapshot initializes the chain state of the passed database, which
+// must not contain a chain state yet, from the utxo snapshot read from the
+// passed reader and returns its description.  The hash committing to the utxo
+// set of the snapshot and its tax sweep height must be pinned for its base
+// block in the passed chain parameters.
+//
+// A chain instance created for the database starts at the base block of the
+// snapshot.  Only the headers of the blocks before the base block are known
+// to it, so the history up to the base block has to be validated in the
+// background by a separate chain instance and the snapshot is assumed to be
+// valid until ValidateSnapshot confirms it.  The utxos created before the base
+// block are indexed by height, so the tax transactions of the blocks after it
+// can be validated without the history.  The database must be discarded when
+// an error is returned.
+func LoadUtxoSnapshot(db database.DB, chainParams *chaincfg.Params, r io.Reader, interrupt <-chan struct{}) (*UtxoSnapshotInfo, error) {
+	br := bufio.NewReader(r)
+	serializedHeader := make([]byte, utxoSnapshotHeaderSize)
+	if _, err := io.ReadFull(br, serializedHeader); err != nil {
+		return nil, err
+	}
+	info, err := deserializeUtxoSnapshotHeader(serializedHeader,
+		chainParams.Net)
+	if err != nil {
+		return nil, err
+	}
+	pinned := findAssumeUtxo(chainParams, &info.BlockHash)
+	if pinned == nil || pinned.Height != info.Height ||
+		!pinned.TxOutSetHash.IsEqual(&info.TxOutSetHash) ||
+		pinned.TaxSweepHeight != info.TaxSweepHeight {
+
+		str := fmt.Sprintf("utxo snapshot for block %v (height %d) "+
+			"with hash %v and tax sweep height %d is not pinned in "+
+			"the chain parameters", info.BlockHash, info.Height,
+			info.TxOutSetHash, info.TaxSweepHeight)
+		return nil, ruleError(ErrBadUtxoSnapshot, str)
+	}
+
+	var initialized bool
+	err = db.View(func(dbTx database.Tx) error {
+		initialized = dbTx.Metadata().Get(chainStateKeyName) != nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if initialized {
+		return nil, AssertError("utxo snapshots can only be loaded " +
+			"into an uninitialized database")
+	}
+
+	// Load the headers of the blocks up to the base block.  The hash of
+	// the base block is pinned, so it is enough to ensure they link
+	// together.
+	log.Infof("Loading block headers up to height %d from the utxo "+
+		"snapshot...", info.Height)
+	genesisBlock := btcutil.NewBlock(chainParams.GenesisBlock)
+	genesis := newBlockNode(&chainParams.GenesisBlock.Header, nil)
+	genesis.status = statusDataStored | statusValid
+	nodes := make([]*blockNode, 1, info.Height+1)
+	nodes[0] = genesis
+	for height := int32(1); height < info.Height; height++ {
+		var header wire.BlockHeader
+		if err := header.Deserialize(br); err != nil {
+			return nil, err
+		}
+		parent := nodes[len(nodes)-1]
+		if header.PrevBlock != parent.hash {
+			str := fmt.Sprintf("utxo snapshot header at height %d "+
+				"does not link to the previous header", height)
+			return nil, ruleError(ErrBadUtxoSnapshot, str)
+		}
+		node := newBlockNode(&header, parent)
+		node.status = statusValid
+		nodes = append(nodes, node)
+	}
+
+	// Load the base block and ensure its transactions match the header.
+	var msgBlock wire.MsgBlock
+	if err := msgBlock.Deserialize(br); err != nil {
+		return nil, err
+	}
+	block := btcutil.NewBlock(&msgBlock)
+	block.SetHeight(info.Height)
+	baseNode := genesis
+	if info.Height > 0 {
+		parent := nodes[len(nodes)-1]
+		if msgBlock.Header.PrevBlock != parent.hash {
+			return nil, ruleError(ErrBadUtxoSnapshot, "utxo snapshot "+
+				"base block does not link to the previous header")
+		}
+		baseNode = newBlockNode(&msgBlock.Header, parent)
+		baseNode.status = statusDataStored | statusValid
+		nodes = append(nodes, baseNode)
+	}
+	if baseNode.hash != info.BlockHash {
+		return nil, ruleError(ErrBadUtxoSnapshot, "utxo snapshot base "+
+			"block does not match the header")
+	}
+	merkles := BuildMerkleTreeStore(block.Transactions(), false)
+	if *merkles[len(merkles)-1] != msgBlock.Header.MerkleRoot {
+		return nil, ruleError(ErrBadUtxoSnapshot, "utxo snapshot base "+
+			"block has an invalid merkle root")
+	}
+
+	// Load the utxo set in batches while committing to it.
+	log.Infof("Loading %d unspent transaction outputs from the utxo "+
+		"snapshot...", info.NumTxOuts)
+	err = db.Update(func(dbTx database.Tx) error {
+		if err := dbCreateChainStateBuckets(dbTx); err != nil {
+			return err
+		}
+		_, err := dbTx.Metadata().CreateBucket(
+			snapshotHeightIndexBucketName)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	hasher := sha256.New()
+	var prevKey []byte
+	for loaded := uint64(0); loaded < info.NumTxOuts; {
+		batchSize := info.NumTxOuts - loaded
+		if batchSize > utxoSnapshotBatchSize {
+			batchSize = utxoSnapshotBatchSize
+		}
+		err := db.Update(func(dbTx database.Tx) error {
+			meta := dbTx.Metadata()
+			utxoBucket := meta.Bucket(utxoSetBucketName)
+			indexBucket := meta.Bucket(snapshotHeightIndexBucketName)
+			for i := uint64(0); i < batchSize; i++ {
+				key, serializedEntry, record, err :=
+					readUtxoSnapshotRecord(br)
+				if err != nil {
+					return err
+				}
+				if bytes.Compare(key, prevKey) <= 0 {
+					return ruleError(ErrBadUtxoSnapshot, "utxo "+
+						"snapshot records are not ordered")
+				}
+				entry, err := deserializeUtxoEntry(serializedEntry)
+				if err != nil {
+					str := fmt.Sprintf("utxo snapshot contains "+
+						"an invalid entry: %v", err)
+					return ruleError(ErrBadUtxoSnapshot, str)
+				}
+				hasher.Write(record)
+				prevKey = key
+
+				err = utxoBucket.Put(key, serializedEntry)
+				if err != nil {
+					return err
+				}
+				if entry.BlockHeight >= info.Height {
+					continue
+				}
+				indexKey := snapshotHeightIndexKey(
+					entry.BlockHeight, key)
+				if err := indexBucket.Put(indexKey, nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		loaded += batchSize
+		log.Infof("Loaded %d of %d unspent transaction outputs", loaded,
+			info.NumTxOuts)
+
+		if interruptRequested(interrupt) {
+			return nil, errInterruptRequested
+		}
+	}
+	hash := chainhash.HashH(hasher.Sum(nil))
+	if hash != info.TxOutSetHash {
+		str := fmt.Sprintf("utxo set of the utxo snapshot has hash %v "+
+			"instead of the pinned %v", hash, info.TxOutSetHash)
+		return nil, ruleError(ErrBadUtxoSnapshot, str)
+	}
+
+	// Store the block index and chain state at the base block.  The chain
+	// state is written last since it marks the database as initialized.
+	numTxns := uint64(len(msgBlock.Transactions))
+	state := newBestState(baseNode, uint64(msgBlock.SerializeSize()),
+		uint64(GetBlockWeight(block)), numTxns, info.TotalTxns,
+		baseNode.CalcPastMedianTime())
+	err = db.Update(func(dbTx database.Tx) error {
+		for _, node := range nodes {
+			if err := dbStoreBlockNode(dbTx, node); err != nil {
+				return err
+			}
+			err := dbPutBlockIndex(dbTx, &node.hash, node.height)
+			if err != nil {
+				return err
+			}
+		}
+		if err := dbStoreBlock(dbTx, genesisBlock); err != nil {
+			return err
+		}
+		if err := dbStoreBlock(dbTx, block); err != nil {
+			return err
+		}
+		err := dbPutUtxoStateConsistency(dbTx, &baseNode.hash)
+		if err != nil {
+			return err
+		}
+		err = dbPutSnapshotBase(dbTx, &snapshotBaseState{
+			hash:           baseNode.hash,
+			status:         snapshotUnvalidated,
+			taxSweepHeight: info.TaxSweepHeight,
+		})
+		if err != nil {
+			return err
+		}
+		return dbPutBestState(dbTx, state, baseNode.workSum)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Loaded utxo snapshot at block %v (height %d)",
+		info.BlockHash, info.Height)
+	return info, nil
+}
+
+// loadSnapshotBase loads whether the chain state was loaded from a utxo
+// snapshot from the database.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) loadSnapshotBase() error {
+	var state *snapshotBaseState
+	err := b.db.View(func(dbTx database.Tx) error {
+		var err error
+		state, err = dbFetchSnapshotBase(dbTx)
+		return err
+	})
+	if err != nil || state == nil {
+		return err
+	}
+	if state.status == snapshotInvalid {
+		str := fmt.Sprintf("chain state was loaded from the utxo "+
+			"snapshot at block %v, which does not match the "+
+			"validated history", state.hash)
+		return ruleError(ErrBadUtxoSnapshot, str)
+	}
+
+	node := b.index.LookupNode(&state.hash)
+	if node == nil || !b.bestChain.Contains(node) {
+		return AssertError(fmt.Sprintf("utxo snapshot base %v is not in "+
+			"the main chain", state.hash))
+	}
+	b.snapshotBase = node
+	b.snapshotTaxSweepHeight = state.taxSweepHeight
+	b.snapshotValidated = state.status == snapshotValidated
+	if !b.snapshotValidated {
+		log.Infof("Chain state was loaded from the utxo snapshot at "+
+			"height %d, which is assumed to be valid until the "+
+			"history is validated", node.height)
+	}
+	return nil
+}
+
+// IsSnapshotInvalid returns whether the chain state in the passed database was
+// loaded from a utxo snapshot which turned out not to match the validated
+// history.  Such a database must be discarded in favor of the one the history
+// was validated in.
+func IsSnapshotInvalid(db database.DB) (bool, error) {
+	var state *snapshotBaseState
+	err := db.View(func(dbTx database.Tx) error {
+		var err error
+		state, err = dbFetchSnapshotBase(dbTx)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return state != nil && state.status == snapshotInvalid, nil
+}
+
+// SnapshotBase returns the hash and height of the base block of the utxo
+// snapshot the chain state was loaded from.  The hash is nil when the chain
+// state was not loaded from a utxo snapshot.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) SnapshotBase() (*chainhash.Hash, int32) {
+	if b.snapshotBase == nil {
+		return nil, 0
+	}
+	return &b.snapshotBase.hash, b.snapshotBase.height
+}
+
+// IsSnapshotValidated returns whether the history up to the base block of the
+// utxo snapshot the chain state was loaded from has been validated.  It returns
+// false when the chain state was not loaded from a utxo snapshot.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) IsSnapshotValidated() bool {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	return b.snapshotValidated
+}
+
+// ValidateSnapshot validates the utxo snapshot the chain state was loaded from
+// against the passed chain instance, which must have validated the history up
+// to the base block of the snapshot on its own and not beyond.  The snapshot is
+// marked validated when the utxo set and the tax sweep height of the passed
+// chain instance match the ones pinned for the snapshot.  Otherwise, the
+// snapshot is marked invalid, so the chain state loaded from it is never used
+// again, and an ErrBadUtxoSnapshot rule error is returned.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ValidateSnapshot(history *BlockChain) error {
+	if b.snapshotBase == nil {
+		return AssertError("chain state was not loaded from a utxo " +
+			"snapshot")
+	}
+	base := b.snapshotBase
+	pinned := findAssumeUtxo(b.chainParams, &base.hash)
+	if pinned == nil {
+		str := fmt.Sprintf("utxo snapshot for block %v is no longer "+
+			"pinned in the chain parameters", base.hash)
+		return ruleError(ErrBadUtxoSnapshot, str)
+	}
+
+	// Commit to the utxo set of the validated history the same way the
+	// snapshot does.
+	history.chainLock.Lock()
+	tip := history.bestChain.Tip()
+	if tip.hash != base.hash {
+		history.chainLock.Unlock()
+		str := fmt.Sprintf("validated chain ends at block %v (height "+
+			"%d) instead of the utxo snapshot base %v (height %d)",
+			tip.hash, tip.height, base.hash, base.height)
+		return AssertError(str)
+	}
+	var hash *chainhash.Hash
+	var taxSweepHeight int32
+	err := history.utxoCache.flush(&tip.hash)
+	if err == nil {
+		err = history.db.View(func(dbTx database.Tx) error {
+			var err error
+			hash, _, err = hashUtxoSet(dbTx, nil)
+			if err != nil {
+				return err
+			}
+			taxSweepHeight, err = history.dbFetchTaxSweepHeight(dbTx,
+				tip)
+			return err
+		})
+	}
+	history.chainLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	var mismatch string
+	switch {
+	case !hash.IsEqual(pinned.TxOutSetHash):
+		mismatch = fmt.Sprintf("validated utxo set at block %v has "+
+			"hash %v instead of the pinned %v", base.hash, hash,
+			pinned.TxOutSetHash)
+	case taxSweepHeight != b.snapshotTaxSweepHeight:
+		mismatch = fmt.Sprintf("validated history up to block %v has "+
+			"tax sweep height %d instead of the pinned %d",
+			base.hash, taxSweepHeight, b.snapshotTaxSweepHeight)
+	}
+	status := snapshotValidated
+	if mismatch != "" {
+		status = snapshotInvalid
+	}
+
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+	err = b.db.Update(func(dbTx database.Tx) error {
+		return dbPutSnapshotBase(dbTx, &snapshotBaseState{
+			hash:           base.hash,
+			status:         status,
+			taxSweepHeight: b.snapshotTaxSweepHeight,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if mismatch != "" {
+		return ruleError(ErrBadUtxoSnapshot, mismatch)
+	}
+	b.snapshotValidated = true
+	log.Infof("Validated the utxo snapshot at block %v (height %d)",
+		base.hash, base.height)
+	return nil
+}
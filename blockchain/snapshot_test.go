@@ -0,0 +1,273 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/organicbitcoin/obtcd/chaincfg"
+	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
+	"github.com/organicbitcoin/obtcd/database"
+	"github.com/organicbitcoin/obtcd/wire"
+)
+
+// createSnapshotTestDb returns a new database without a chain state along with
+// a function to remove it.
+func createSnapshotTestDb(t *testing.T) (database.DB, func()) {
+	dbPath, err := ioutil.TempDir("", "utxosnapshot")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	db, err := database.Create(testDbType, filepath.Join(dbPath, "db"),
+		blockDataNet)
+	if err != nil {
+		os.RemoveAll(dbPath)
+		t.Fatalf("Error creating db: %v", err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dbPath)
+	}
+}
+
+// isBadUtxoSnapshotErr returns whether the passed error is an
+// ErrBadUtxoSnapshot rule error.
+func isBadUtxoSnapshotErr(err error) bool {
+	rerr, ok := err.(RuleError)
+	return ok && rerr.ErrorCode == ErrBadUtxoSnapshot
+}
+
+// TestUtxoSnapshot ensures a utxo snapshot dumped from a chain instance can be
+// loaded into a new database only when its hash is pinned, that the chain
+// state loaded from it matches the original one, and that it is validated
+// against the history.
+func TestUtxoSnapshot(t *testing.T) {
+	blocks, err := loadBlocks("blk_0_to_4.dat.bz2")
+	if err != nil {
+		t.Fatalf("Error loading file: %v\n", err)
+	}
+
+	history, teardownFunc, err := chainSetup("utxosnapshot",
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+	history.TstSetCoinbaseMaturity(1)
+	for i := 1; i < len(blocks); i++ {
+		_, _, err := history.ProcessBlock(blocks[i], BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock fail on block %v: %v\n", i, err)
+		}
+	}
+
+	// Dump the utxo set at the tip.
+	var snapshot bytes.Buffer
+	info, err := history.DumpUtxoSnapshot(&snapshot)
+	if err != nil {
+		t.Fatalf("DumpUtxoSnapshot: unexpected error: %v", err)
+	}
+	tip := blocks[len(blocks)-1]
+	if info.BlockHash != *tip.Hash() || info.Height != tip.Height() {
+		t.Fatalf("DumpUtxoSnapshot: unexpected base %v (height %d)",
+			info.BlockHash, info.Height)
+	}
+	if info.NumTxOuts != uint64(len(blocks)) {
+		t.Fatalf("DumpUtxoSnapshot: unexpected number of outputs %d",
+			info.NumTxOuts)
+	}
+	if info.TaxSweepHeight != -1 {
+		t.Fatalf("DumpUtxoSnapshot: unexpected tax sweep height %d",
+			info.TaxSweepHeight)
+	}
+
+	// Ensure the snapshot is rejected when its hash is not pinned.
+	db, teardownDb := createSnapshotTestDb(t)
+	_, err = LoadUtxoSnapshot(db, history.chainParams,
+		bytes.NewReader(snapshot.Bytes()), nil)
+	teardownDb()
+	if !isBadUtxoSnapshotErr(err) {
+		t.Fatalf("LoadUtxoSnapshot: unexpected error for unpinned "+
+			"snapshot: %v", err)
+	}
+
+	// Ensure the snapshot is rejected when its utxo set does not match the
+	// pinned hash.
+	params := *history.chainParams
+	params.AssumeUtxo = []chaincfg.AssumeUtxo{{
+		Height:         info.Height,
+		BlockHash:      &info.BlockHash,
+		TxOutSetHash:   &info.TxOutSetHash,
+		TaxSweepHeight: 0,
+	}}
+	db, teardownDb = createSnapshotTestDb(t)
+	_, err = LoadUtxoSnapshot(db, &params, bytes.NewReader(snapshot.Bytes()),
+		nil)
+	teardownDb()
+	if !isBadUtxoSnapshotErr(err) {
+		t.Fatalf("LoadUtxoSnapshot: unexpected error for mismatched "+
+			"tax sweep height: %v", err)
+	}
+	params.AssumeUtxo[0].TaxSweepHeight = info.TaxSweepHeight
+	tampered := append([]byte(nil), snapshot.Bytes()...)
+	tampered[len(tampered)-1] ^= 0xff
+	db, teardownDb = createSnapshotTestDb(t)
+	_, err = LoadUtxoSnapshot(db, &params, bytes.NewReader(tampered), nil)
+	teardownDb()
+	if !isBadUtxoSnapshotErr(err) {
+		t.Fatalf("LoadUtxoSnapshot: unexpected error for tampered "+
+			"snapshot: %v", err)
+	}
+
+	// Load the snapshot and ensure the chain state matches the original.
+	db, teardownDb = createSnapshotTestDb(t)
+	defer teardownDb()
+	loaded, err := LoadUtxoSnapshot(db, &params,
+		bytes.NewReader(snapshot.Bytes()), nil)
+	if err != nil {
+		t.Fatalf("LoadUtxoSnapshot: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, info) {
+		t.Fatalf("LoadUtxoSnapshot: unexpected info - got %+v, want %+v",
+			loaded, info)
+	}
+	chain, err := New(&Config{
+		DB:          db,
+		ChainParams: &params,
+		TimeSource:  NewMedianTime(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create chain instance: %v", err)
+	}
+	got, want := chain.BestSnapshot(), history.BestSnapshot()
+	if got.Hash != want.Hash || got.Height != want.Height ||
+		got.TotalTxns != want.TotalTxns || got.Bits != want.Bits {
+
+		t.Fatalf("unexpected best state - got %+v, want %+v", got, want)
+	}
+	baseHash, baseHeight := chain.SnapshotBase()
+	if baseHash == nil || *baseHash != info.BlockHash ||
+		baseHeight != info.Height {
+
+		t.Fatalf("SnapshotBase: unexpected base %v (height %d)",
+			baseHash, baseHeight)
+	}
+	if chain.IsSnapshotValidated() {
+		t.Fatal("IsSnapshotValidated: snapshot validated before the " +
+			"history")
+	}
+	for _, block := range blocks {
+		outpoint := wire.OutPoint{Hash: *block.Transactions()[0].Hash()}
+		wantEntry, err := history.FetchUtxoEntry(outpoint)
+		if err != nil {
+			t.Fatalf("FetchUtxoEntry: unexpected error: %v", err)
+		}
+		gotEntry, err := chain.FetchUtxoEntry(outpoint)
+		if err != nil {
+			t.Fatalf("FetchUtxoEntry: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(gotEntry, wantEntry) {
+			t.Fatalf("FetchUtxoEntry: unexpected entry - got %+v, "+
+				"want %+v", gotEntry, wantEntry)
+		}
+	}
+
+	// Ensure the utxos created before the base block, which is not
+	// available, are fetched by height the same way as from the history.
+	var numUtxos int
+	for height := int32(1); height <= info.Height; height++ {
+		want, err := history.FetchUtxosByHeight(height)
+		if err != nil {
+			t.Fatalf("FetchUtxosByHeight: unexpected error: %v", err)
+		}
+		got, err := chain.FetchUtxosByHeight(height)
+		if err != nil {
+			t.Fatalf("FetchUtxosByHeight: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("FetchUtxosByHeight(%d): unexpected utxos - "+
+				"got %+v, want %+v", height, got, want)
+		}
+		numUtxos += len(got)
+	}
+	if uint64(numUtxos) != info.NumTxOuts {
+		t.Fatalf("FetchUtxosByHeight: unexpected number of utxos %d",
+			numUtxos)
+	}
+
+	// Validate the snapshot and ensure it stays validated once the chain
+	// instance is created again.
+	if err := chain.ValidateSnapshot(history); err != nil {
+		t.Fatalf("ValidateSnapshot: unexpected error: %v", err)
+	}
+	chain, err = New(&Config{
+		DB:          db,
+		ChainParams: &params,
+		TimeSource:  NewMedianTime(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create chain instance: %v", err)
+	}
+	if !chain.IsSnapshotValidated() {
+		t.Fatal("IsSnapshotValidated: snapshot not validated after " +
+			"restarting")
+	}
+
+	// Ensure a snapshot can't be loaded into an initialized database.
+	_, err = LoadUtxoSnapshot(db, &params, bytes.NewReader(snapshot.Bytes()),
+		nil)
+	if _, ok := err.(AssertError); !ok {
+		t.Fatalf("LoadUtxoSnapshot: unexpected error for initialized "+
+			"database: %v", err)
+	}
+
+	// Ensure a snapshot which does not match the validated history is
+	// marked invalid and the chain state loaded from it is not used again.
+	db, teardownDb = createSnapshotTestDb(t)
+	defer teardownDb()
+	_, err = LoadUtxoSnapshot(db, &params, bytes.NewReader(snapshot.Bytes()),
+		nil)
+	if err != nil {
+		t.Fatalf("LoadUtxoSnapshot: unexpected error: %v", err)
+	}
+	chain, err = New(&Config{
+		DB:          db,
+		ChainParams: &params,
+		TimeSource:  NewMedianTime(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create chain instance: %v", err)
+	}
+	params.AssumeUtxo[0].TxOutSetHash = &chainhash.Hash{0x01}
+	err = chain.ValidateSnapshot(history)
+	if !isBadUtxoSnapshotErr(err) {
+		t.Fatalf("ValidateSnapshot: unexpected error for mismatched "+
+			"hash: %v", err)
+	}
+	if chain.IsSnapshotValidated() {
+		t.Fatal("IsSnapshotValidated: snapshot validated with a " +
+			"mismatched hash")
+	}
+	invalid, err := IsSnapshotInvalid(db)
+	if err != nil {
+		t.Fatalf("IsSnapshotInvalid: unexpected error: %v", err)
+	}
+	if !invalid {
+		t.Fatal("IsSnapshotInvalid: snapshot not marked invalid")
+	}
+	_, err = New(&Config{
+		DB:          db,
+		ChainParams: &params,
+		TimeSource:  NewMedianTime(),
+	})
+	if !isBadUtxoSnapshotErr(err) {
+		t.Fatalf("New: unexpected error for invalid snapshot: %v", err)
+	}
+}
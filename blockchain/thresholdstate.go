@@ -7,6 +7,7 @@ package blockchain
 import (
 	"fmt"
 
+	"github.com/organicbitcoin/obtcd/chaincfg"
 	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
 )
 
@@ -286,6 +287,31 @@ func (b *BlockChain) IsDeploymentActive(deploymentID uint32) (bool, error) {
 	return state == ThresholdActive, nil
 }
 
+// buriedDeploymentHeight returns the height of the first block for which the
+// passed deployment is active on the networks where it is buried at a fixed
+// height, so it is never voted in there.  It returns zero when the deployment
+// is activated through the version bits instead.
+func buriedDeploymentHeight(params *chaincfg.Params, deploymentID uint32) int32 {
+	switch deploymentID {
+	case chaincfg.DeploymentCSV:
+		return params.CSVHeight
+
+	case chaincfg.DeploymentSegwit:
+		return params.SegwitHeight
+
+	case chaincfg.DeploymentTaxation:
+		// Taxation is active for the blocks after its begin height.
+		if params.TaxationBeginHeight > 0 {
+			return params.TaxationBeginHeight + 1
+		}
+
+	case chaincfg.DeploymentFullExpiry:
+		return params.FullExpiryBeginHeight
+	}
+
+	return 0
+}
+
 // deploymentState returns the current rule change threshold for a given
 // deploymentID. The threshold is evaluated from the point of view of the block
 // node passed in as the first argument to this method.
@@ -295,12 +321,24 @@ func (b *BlockChain) IsDeploymentActive(deploymentID uint32) (bool, error) {
 // desired.  In other words, the returned deployment state is for the block
 // AFTER the passed node.
 //
+// Deployments which are buried at a height on the network, as reported by
+// buriedDeploymentHeight, are reported as active from that height instead.
+//
 // This function MUST be called with the chain state lock held (for writes).
 func (b *BlockChain) deploymentState(prevNode *blockNode, deploymentID uint32) (ThresholdState, error) {
 	if deploymentID > uint32(len(b.chainParams.Deployments)) {
 		return ThresholdFailed, DeploymentError(deploymentID)
 	}
 
+	// The state of a buried deployment only depends on the height of the
+	// block.
+	if height := buriedDeploymentHeight(b.chainParams, deploymentID); height > 0 {
+		if prevNode != nil && prevNode.height+1 >= height {
+			return ThresholdActive, nil
+		}
+		return ThresholdDefined, nil
+	}
+
 	deployment := &b.chainParams.Deployments[deploymentID]
 	checker := deploymentChecker{deployment: deployment, chain: b}
 	cache := &b.deploymentCaches[deploymentID]
@@ -7,6 +7,7 @@ package blockchain
 import (
 	"testing"
 
+	"github.com/organicbitcoin/obtcd/chaincfg"
 	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
 )
 
@@ -132,3 +133,119 @@ nextTest:
 		}
 	}
 }
+
+// TestBuriedTaxationState ensures the taxation and full expiry deployments are
+// reported as active from their buried heights on the networks which define
+// them and that the outputs which burn coins are only kept once both are
+// active.
+func TestBuriedTaxationState(t *testing.T) {
+	params := chaincfg.MainNetParams
+	params.TaxationBeginHeight = 5
+	params.FullExpiryBeginHeight = 3
+	chain := newFakeChain(&params)
+	nodes := chainedNodes(chain.bestChain.Genesis(), 8)
+
+	tests := []struct {
+		height          int32
+		state           ThresholdState
+		fullExpiryState ThresholdState
+		keepBurned      bool
+	}{
+		{1, ThresholdDefined, ThresholdDefined, false},
+		{2, ThresholdDefined, ThresholdDefined, false},
+		{3, ThresholdDefined, ThresholdActive, false},
+		{5, ThresholdDefined, ThresholdActive, false},
+		{6, ThresholdActive, ThresholdActive, true},
+		{8, ThresholdActive, ThresholdActive, true},
+	}
+	for _, test := range tests {
+		node := nodes[test.height-1]
+		state, err := chain.deploymentState(node.parent,
+			chaincfg.DeploymentTaxation)
+		if err != nil {
+			t.Fatalf("deploymentState (height %d): unexpected "+
+				"error: %v", test.height, err)
+		}
+		if state != test.state {
+			t.Errorf("deploymentState (height %d): got %v, want %v",
+				test.height, state, test.state)
+		}
+		state, err = chain.deploymentState(node.parent,
+			chaincfg.DeploymentFullExpiry)
+		if err != nil {
+			t.Fatalf("deploymentState (height %d): unexpected "+
+				"error: %v", test.height, err)
+		}
+		if state != test.fullExpiryState {
+			t.Errorf("full expiry deploymentState (height %d): "+
+				"got %v, want %v", test.height, state,
+				test.fullExpiryState)
+		}
+
+		keepBurned, err := chain.keepsBurnedOutputs(node)
+		if err != nil {
+			t.Fatalf("keepsBurnedOutputs (height %d): unexpected "+
+				"error: %v", test.height, err)
+		}
+		if keepBurned != test.keepBurned {
+			t.Errorf("keepsBurnedOutputs (height %d): got %v, "+
+				"want %v", test.height, keepBurned,
+				test.keepBurned)
+		}
+	}
+
+	// Without a buried height, the deployments are only active once they
+	// are voted in, so burned outputs are not kept before then.
+	for _, id := range []uint32{chaincfg.DeploymentTaxation,
+		chaincfg.DeploymentFullExpiry} {
+
+		params := params
+		if id == chaincfg.DeploymentTaxation {
+			params.TaxationBeginHeight = 0
+		} else {
+			params.FullExpiryBeginHeight = 0
+		}
+		chain = newFakeChain(&params)
+		nodes = chainedNodes(chain.bestChain.Genesis(), 8)
+		keepBurned, err := chain.keepsBurnedOutputs(nodes[7])
+		if err != nil {
+			t.Fatalf("keepsBurnedOutputs: unexpected error: %v", err)
+		}
+		if keepBurned {
+			t.Errorf("keepsBurnedOutputs: kept burned outputs "+
+				"before deployment %d is active", id)
+		}
+	}
+}
+
+// TestBuriedSoftForkState ensures the CSV and segwit deployments are reported
+// as active from their buried heights on signet without being voted in.
+func TestBuriedSoftForkState(t *testing.T) {
+	params := chaincfg.SigNetParams([]byte{0x51})
+	chain := newFakeChain(&params)
+	nodes := chainedNodes(chain.bestChain.Genesis(), 2)
+
+	for _, id := range []uint32{chaincfg.DeploymentCSV,
+		chaincfg.DeploymentSegwit} {
+
+		tests := []struct {
+			prevNode *blockNode
+			want     ThresholdState
+		}{
+			{nil, ThresholdDefined},
+			{chain.bestChain.Genesis(), ThresholdActive},
+			{nodes[1], ThresholdActive},
+		}
+		for _, test := range tests {
+			state, err := chain.deploymentState(test.prevNode, id)
+			if err != nil {
+				t.Fatalf("deploymentState: unexpected error: %v",
+					err)
+			}
+			if state != test.want {
+				t.Errorf("deployment %d state: got %v, want %v",
+					id, state, test.want)
+			}
+		}
+	}
+}
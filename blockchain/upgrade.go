@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/organicbitcoin/obtcd/chaincfg"
 	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
 	"github.com/organicbitcoin/obtcd/database"
 	"github.com/organicbitcoin/obtcd/utxo"
@@ -490,7 +491,7 @@ func upgradeUtxoSetToV2(db database.DB, interrupt <-chan struct{}) error {
 			// Add an entry for each utxo into the new bucket using
 			// the new format.
 			for txOutIdx, utxo := range utxos {
-				reserialized, err := serializeUtxoEntry(utxo)
+				reserialized, err := serializeUtxoEntryV2(utxo)
 				if err != nil {
 					return 0, err
 				}
@@ -574,6 +575,248 @@ func upgradeUtxoSetToV2(db database.DB, interrupt <-chan struct{}) error {
 	return nil
 }
 
+// -----------------------------------------------------------------------------
+// The version 2 unspent transaction output (utxo) set format keyed each entry by
+// its outpoint just like the current format, but the serialized value omitted
+// the expiry height:
+//
+//   <header code><compressed txout>
+//
+//   Field                Type     Size
+//   header code          VLQ      variable
+//   compressed txout
+//     compressed amount  VLQ      variable
+//     compressed script  []byte   variable
+//
+// The serialized header code format is:
+//   bit 0 - containing transaction is a coinbase
+//   bits 1-x - height of the block that contains the unspent txout
+//
+// Example:
+// From tx in main blockchain:
+// Blk 113931, 4a16969aa4764dd7507fc1de7f0baa4850a246de90c45e59a3207f9a26b5036f:2
+//
+//    8cf316800900b8025be1b3efc63b0ad48e7f9f10e87544528d58
+//    <----><------------------------------------------>
+//      |                             |
+//   header code             compressed txout
+//
+//  - header code: 0x8cf316 (not coinbase, height 113931)
+//  - compressed txout:
+//    - 0x8009: VLQ-encoded compressed amount for 15000000 (0.15 BTC)
+//    - 0x00: special script type pay-to-pubkey-hash
+//    - 0xb8...58: pubkey hash
+// -----------------------------------------------------------------------------
+
+// serializeUtxoEntryV2 returns the entry serialized to the version 2 format
+// described above.  It is only used by the upgrade from version 1 to 2.
+func serializeUtxoEntryV2(entry *utxo.UtxoEntry) ([]byte, error) {
+	// Spent outputs have no serialization.
+	if entry.IsSpent() {
+		return nil, nil
+	}
+
+	// Encode the header code.
+	headerCode := uint64(entry.BlockHeight) << 1
+	if entry.IsCoinBase() {
+		headerCode |= 0x01
+	}
+
+	// Serialize the header code followed by the compressed unspent
+	// transaction output.
+	size := serializeSizeVLQ(headerCode) +
+		compressedTxOutSize(uint64(entry.Amount), entry.PkScript)
+	serialized := make([]byte, size)
+	offset := putVLQ(serialized, headerCode)
+	putCompressedTxOut(serialized[offset:], uint64(entry.Amount),
+		entry.PkScript)
+
+	return serialized, nil
+}
+
+// deserializeUtxoEntryV2 decodes a utxo entry from the passed serialized byte
+// slice using the version 2 format described above.  The expiry height of the
+// returned entry is not set.
+func deserializeUtxoEntryV2(serialized []byte) (*utxo.UtxoEntry, error) {
+	// Deserialize the header code.
+	code, offset := deserializeVLQ(serialized)
+	if offset >= len(serialized) {
+		return nil, errDeserialize("unexpected end of data after header")
+	}
+
+	// Decode the header code.
+	//
+	// Bit 0 indicates whether the containing transaction is a coinbase.
+	// Bits 1-x encode height of containing transaction.
+	isCoinBase := code&0x01 != 0
+	blockHeight := int32(code >> 1)
+
+	// Decode the compressed unspent transaction output.
+	amount, pkScript, _, err := decodeCompressedTxOut(serialized[offset:])
+	if err != nil {
+		return nil, errDeserialize(fmt.Sprintf("unable to decode "+
+			"utxo: %v", err))
+	}
+
+	entry := &utxo.UtxoEntry{
+		Amount:      int64(amount),
+		PkScript:    pkScript,
+		BlockHeight: blockHeight,
+	}
+	if isCoinBase {
+		entry.PackedFlags |= utxo.TfCoinBase
+	}
+
+	return entry, nil
+}
+
+// upgradeUtxoSetToV3 migrates the utxo set entries from version 2 to 3 in
+// batches.  Version 3 adds the expiry height, which is calculated from the
+// valid chain length of the passed network.  It is guaranteed to updated if
+// this returns without failure.
+func upgradeUtxoSetToV3(db database.DB, chainParams *chaincfg.Params, interrupt <-chan struct{}) error {
+	// Hardcoded bucket names so updates to the global values do not affect
+	// old upgrades.
+	var (
+		v2BucketName = []byte("utxosetv2")
+		v3BucketName = []byte("utxosetv3")
+	)
+
+	log.Infof("Upgrading utxo set to v3.  This will take a while...")
+	start := time.Now()
+
+	// Create the new utxo set bucket as needed.
+	err := db.Update(func(dbTx database.Tx) error {
+		_, err := dbTx.Metadata().CreateBucketIfNotExists(v3BucketName)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// doBatch contains the primary logic for upgrading the utxo set from
+	// version 2 to 3 in batches for the same reasons the upgrade to
+	// version 2 is done in batches.
+	//
+	// It returns the number of utxos processed.
+	const maxUtxos = 200000
+	doBatch := func(dbTx database.Tx) (uint32, error) {
+		v2Bucket := dbTx.Metadata().Bucket(v2BucketName)
+		v3Bucket := dbTx.Metadata().Bucket(v3BucketName)
+		v2Cursor := v2Bucket.Cursor()
+
+		// Migrate utxos so long as the max number of utxos for this
+		// batch has not been exceeded.
+		var numUtxos uint32
+		for ok := v2Cursor.First(); ok && numUtxos < maxUtxos; ok =
+			v2Cursor.Next() {
+
+			// The outpoint keys are unchanged.
+			key := v2Cursor.Key()
+			entry, err := deserializeUtxoEntryV2(v2Cursor.Value())
+			if err != nil {
+				return 0, err
+			}
+			entry.ExpiryHeight = utxo.CalcExpiryHeight(
+				entry.BlockHeight, chainParams.ValidChainLength)
+
+			reserialized, err := serializeUtxoEntry(entry)
+			if err != nil {
+				return 0, err
+			}
+			err = v3Bucket.Put(key, reserialized)
+			if err != nil {
+				return 0, err
+			}
+
+			// Remove old entry.
+			err = v2Bucket.Delete(key)
+			if err != nil {
+				return 0, err
+			}
+
+			numUtxos++
+
+			if interruptRequested(interrupt) {
+				// No error here so the database transaction
+				// is not cancelled and therefore outstanding
+				// work is written to disk.
+				break
+			}
+		}
+
+		return numUtxos, nil
+	}
+
+	// Migrate all entries in batches for the reasons mentioned above.
+	var totalUtxos uint64
+	for {
+		var numUtxos uint32
+		err := db.Update(func(dbTx database.Tx) error {
+			var err error
+			numUtxos, err = doBatch(dbTx)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		if interruptRequested(interrupt) {
+			return errInterruptRequested
+		}
+
+		if numUtxos == 0 {
+			break
+		}
+
+		totalUtxos += uint64(numUtxos)
+		log.Infof("Migrated %d utxos (%d total)", numUtxos, totalUtxos)
+	}
+
+	// Remove the old bucket and update the utxo set version once it has
+	// been fully migrated.
+	err = db.Update(func(dbTx database.Tx) error {
+		err := dbTx.Metadata().DeleteBucket(v2BucketName)
+		if err != nil {
+			return err
+		}
+
+		return dbPutVersion(dbTx, utxoSetVersionKeyName, 3)
+	})
+	if err != nil {
+		return err
+	}
+
+	seconds := int64(time.Since(start) / time.Second)
+	log.Infof("Done upgrading utxo set.  Total utxos: %d in %d seconds",
+		totalUtxos, seconds)
+	return nil
+}
+
+// upgradeUtxoSetToV4 updates the utxo set from version 3 to 4.  Version 4
+// keeps the outputs which burn coins in the blocks in which full expiry is
+// active.  Since those outputs can't be recovered from the utxo set, the
+// upgrade fails when any such block was connected to it already, in which case
+// the chain needs to be synced again.  Otherwise, only the version is updated.
+func (b *BlockChain) upgradeUtxoSetToV4() error {
+	tip := b.bestChain.Tip()
+	keepBurned, err := b.keepsBurnedOutputs(tip)
+	if err != nil {
+		return err
+	}
+	if keepBurned {
+		return fmt.Errorf("the utxo set lacks the outputs which burn "+
+			"coins in the blocks in which full expiry is active "+
+			"(best height %d) -- delete the block database and "+
+			"sync the chain again", tip.height)
+	}
+
+	log.Infof("Upgrading utxo set to v4")
+	return b.db.Update(func(dbTx database.Tx) error {
+		return dbPutVersion(dbTx, utxoSetVersionKeyName, 4)
+	})
+}
+
 // maybeUpgradeDbBuckets checks the database version of the buckets used by this
 // package and performs any needed upgrades to bring them to the latest version.
 //
@@ -601,5 +844,20 @@ func (b *BlockChain) maybeUpgradeDbBuckets(interrupt <-chan struct{}) error {
 		}
 	}
 
+	// Update the utxo set to v3 if needed.
+	if utxoSetVersion < 3 {
+		err := upgradeUtxoSetToV3(b.db, b.chainParams, interrupt)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Update the utxo set to v4 if needed.
+	if utxoSetVersion < 4 {
+		if err := b.upgradeUtxoSetToV4(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
@@ -5,10 +5,15 @@
 package blockchain
 
 import (
+	"bytes"
 	"reflect"
 	"testing"
 
+	"github.com/organicbitcoin/obtcd/chaincfg"
+	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
+	"github.com/organicbitcoin/obtcd/database"
 	"github.com/organicbitcoin/obtcd/utxo"
+	"github.com/organicbitcoin/obtcd/wire"
 )
 
 // TestDeserializeUtxoEntryV0 ensures deserializing unspent trasaction output
@@ -116,3 +121,227 @@ func TestDeserializeUtxoEntryV0(t *testing.T) {
 		}
 	}
 }
+
+// TestUtxoEntryV2Serialization ensures serializing and deserializing unspent
+// trasaction output entries using the version 2 format works as expected.
+func TestUtxoEntryV2Serialization(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		entry      *utxo.UtxoEntry
+		serialized []byte
+	}{
+		// From tx in main blockchain:
+		// 0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098:0
+		{
+			name: "height 1, coinbase",
+			entry: &utxo.UtxoEntry{
+				Amount:      5000000000,
+				PkScript:    hexToBytes("410496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52da7589379515d4e0a604f8141781e62294721166bf621e73a82cbf2342c858eeac"),
+				BlockHeight: 1,
+				PackedFlags: utxo.TfCoinBase,
+			},
+			serialized: hexToBytes("03320496b538e853519c726a2c91e61ec11600ae1390813a627c66fb8be7947be63c52"),
+		},
+		// From tx in main blockchain:
+		// 8131ffb0a2c945ecaf9b9063e59558784f9c3a74741ce6ae2a18d0571dac15bb:1
+		{
+			name: "height 100001, not coinbase",
+			entry: &utxo.UtxoEntry{
+				Amount:      1000000,
+				PkScript:    hexToBytes("76a914ee8bd501094a7d5ca318da2506de35e1cb025ddc88ac"),
+				BlockHeight: 100001,
+				PackedFlags: 0,
+			},
+			serialized: hexToBytes("8b99420700ee8bd501094a7d5ca318da2506de35e1cb025ddc"),
+		},
+	}
+
+	for i, test := range tests {
+		// Ensure the utxo entry serializes to the expected value.
+		gotBytes, err := serializeUtxoEntryV2(test.entry)
+		if err != nil {
+			t.Errorf("serializeUtxoEntryV2 #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		if !bytes.Equal(gotBytes, test.serialized) {
+			t.Errorf("serializeUtxoEntryV2 #%d (%s): mismatched "+
+				"bytes - got %x, want %x", i, test.name,
+				gotBytes, test.serialized)
+			continue
+		}
+
+		// Ensure the serialized bytes are decoded back to the expected
+		// entry.
+		entry, err := deserializeUtxoEntryV2(test.serialized)
+		if err != nil {
+			t.Errorf("deserializeUtxoEntryV2 #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(entry, test.entry) {
+			t.Errorf("deserializeUtxoEntryV2 #%d (%s) mismatched "+
+				"entries: got %+v, want %+v", i, test.name,
+				entry, test.entry)
+			continue
+		}
+	}
+}
+
+// TestUpgradeUtxoSetToV3 ensures the utxo set is migrated from version 2 to 3
+// such that the entries get the expiry height of the network and can be
+// queried for expiry by height.
+func TestUpgradeUtxoSetToV3(t *testing.T) {
+	chain, teardown, err := chainSetup("upgradeutxosetv3",
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardown()
+
+	// Replace the utxo set with a version 2 one.
+	outpoint := wire.OutPoint{Hash: chainhash.Hash{0x01}, Index: 2}
+	entry := &utxo.UtxoEntry{
+		Amount:      1000000,
+		PkScript:    hexToBytes("76a914ee8bd501094a7d5ca318da2506de35e1cb025ddc88ac"),
+		BlockHeight: 100001,
+	}
+	err = chain.db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		if err := meta.DeleteBucket(utxoSetBucketName); err != nil {
+			return err
+		}
+		v2Bucket, err := meta.CreateBucket([]byte("utxosetv2"))
+		if err != nil {
+			return err
+		}
+		serialized, err := serializeUtxoEntryV2(entry)
+		if err != nil {
+			return err
+		}
+		key := outpointKey(outpoint)
+		if err := v2Bucket.Put(*key, serialized); err != nil {
+			return err
+		}
+		return dbPutVersion(dbTx, utxoSetVersionKeyName, 2)
+	})
+	if err != nil {
+		t.Fatalf("Failed to create version 2 utxo set: %v", err)
+	}
+
+	if err := chain.maybeUpgradeDbBuckets(nil); err != nil {
+		t.Fatalf("Failed to upgrade utxo set: %v", err)
+	}
+
+	err = chain.db.View(func(dbTx database.Tx) error {
+		if dbTx.Metadata().Bucket([]byte("utxosetv2")) != nil {
+			t.Error("version 2 utxo set bucket not removed")
+		}
+		version := dbFetchVersion(dbTx, utxoSetVersionKeyName)
+		if version != latestUtxoSetBucketVersion {
+			t.Errorf("unexpected utxo set version - got %d, want %d",
+				version, latestUtxoSetBucketVersion)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to check upgraded utxo set: %v", err)
+	}
+
+	// The expiry height of the migrated entry is the height of the first
+	// block after the valid chain length.
+	got, err := chain.FetchUtxoEntry(outpoint)
+	if err != nil || got == nil {
+		t.Fatalf("Failed to fetch migrated utxo: %v", err)
+	}
+	wantExpiry := entry.BlockHeight +
+		chaincfg.MainNetParams.ValidChainLength + 1
+	if got.ExpiryHeight != wantExpiry || got.Amount != entry.Amount ||
+		got.BlockHeight != entry.BlockHeight {
+
+		t.Fatalf("unexpected migrated utxo %+v", got)
+	}
+
+	tests := []struct {
+		height  int32
+		expired bool
+	}{
+		{wantExpiry - 1, false},
+		{wantExpiry, true},
+	}
+	for _, test := range tests {
+		expired, err := chain.IsUtxoExpired(outpoint, test.height)
+		if err != nil {
+			t.Fatalf("IsUtxoExpired: unexpected error: %v", err)
+		}
+		if expired != test.expired {
+			t.Errorf("IsUtxoExpired at height %d: got %v, want %v",
+				test.height, expired, test.expired)
+		}
+	}
+
+	// Querying an unknown output is an error.
+	unknown := wire.OutPoint{Hash: chainhash.Hash{0x02}}
+	if _, err := chain.IsUtxoExpired(unknown, wantExpiry); err == nil {
+		t.Error("IsUtxoExpired: no error for unknown output")
+	}
+}
+
+// TestUpgradeUtxoSetToV4 ensures a version 3 utxo set is only upgraded to
+// version 4 when no block in which full expiry is active was connected to it.
+func TestUpgradeUtxoSetToV4(t *testing.T) {
+	params := chaincfg.RegressionNetParams
+	params.TaxationBeginHeight = 1
+	params.FullExpiryBeginHeight = 3
+	chain, teardown, err := chainSetup("upgradeutxosetv4", &params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardown()
+
+	setVersion3 := func() {
+		err := chain.db.Update(func(dbTx database.Tx) error {
+			return dbPutVersion(dbTx, utxoSetVersionKeyName, 3)
+		})
+		if err != nil {
+			t.Fatalf("Failed to set utxo set version: %v", err)
+		}
+	}
+	fetchVersion := func() uint32 {
+		var version uint32
+		err := chain.db.View(func(dbTx database.Tx) error {
+			version = dbFetchVersion(dbTx, utxoSetVersionKeyName)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to fetch utxo set version: %v", err)
+		}
+		return version
+	}
+
+	// The utxo set is upgraded while full expiry is not active yet.
+	nodes := chainedNodes(chain.bestChain.Genesis(), 3)
+	chain.bestChain.SetTip(nodes[1])
+	setVersion3()
+	if err := chain.maybeUpgradeDbBuckets(nil); err != nil {
+		t.Fatalf("Failed to upgrade utxo set: %v", err)
+	}
+	if version := fetchVersion(); version != latestUtxoSetBucketVersion {
+		t.Fatalf("unexpected utxo set version - got %d, want %d",
+			version, latestUtxoSetBucketVersion)
+	}
+
+	// The upgrade fails once a block in which full expiry is active was
+	// connected since the utxo set lacks its burned outputs.
+	chain.bestChain.SetTip(nodes[2])
+	setVersion3()
+	if err := chain.maybeUpgradeDbBuckets(nil); err == nil {
+		t.Fatal("Upgraded utxo set lacking burned outputs")
+	}
+	if version := fetchVersion(); version != 3 {
+		t.Fatalf("unexpected utxo set version - got %d, want 3",
+			version)
+	}
+}
@@ -0,0 +1,446 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
+	"github.com/organicbitcoin/obtcd/database"
+	"github.com/organicbitcoin/obtcd/utxo"
+	"github.com/organicbitcoin/obtcd/wire"
+	"github.com/organicbitcoin/btcutil"
+)
+
+const (
+	// utxoCacheEntryOverhead is the approximate number of bytes a cached
+	// entry takes in memory in addition to its public key script.  It
+	// accounts for the outpoint key and the entry pointer in the map as
+	// well as the entry itself and the map bookkeeping.
+	utxoCacheEntryOverhead = 36 + 8 + 48 + 16
+
+	// utxoFlushPeriodicInterval is the duration after which the utxo cache
+	// is flushed even when it is not full so the work lost on a crash is
+	// bounded.
+	utxoFlushPeriodicInterval = time.Minute * 5
+)
+
+// utxoCache is a write-back cache of the unspent transaction output set of the
+// main chain which sits in front of the database.
+//
+// The views used to validate and connect blocks load their entries through
+// the cache and the modified entries of the views are committed to the cache
+// once a block is connected.  The modified entries are only written to the
+// database when the cache is flushed, which happens when it exceeds its memory
+// budget, periodically, before the main chain is reorganized and on shutdown.
+//
+// The hash of the block up to which the utxo set in the database is consistent
+// is written along with every flush, so the changes made by the blocks
+// connected after the last flush can be replayed on startup after a crash.
+type utxoCache struct {
+	db database.DB
+
+	// maxTotalMemoryUsage is the memory budget of the cache in bytes.  The
+	// cache is flushed after every block when it is zero.
+	maxTotalMemoryUsage uint64
+
+	// The following fields are protected by the mutex.
+	//
+	// cachedEntries houses the cached entries keyed by outpoint.  Entries
+	// which are spent but not flushed yet are kept so they are removed
+	// from the database on the next flush.
+	//
+	// totalEntryMemory is the approximate memory usage of the cached
+	// entries in bytes.
+	mtx              sync.Mutex
+	cachedEntries    map[wire.OutPoint]*utxo.UtxoEntry
+	totalEntryMemory uint64
+	lastFlushTime    time.Time
+}
+
+// newUtxoCache returns a new utxo cache for the utxo set in the passed
+// database with the passed memory budget in bytes.
+func newUtxoCache(db database.DB, maxTotalMemoryUsage uint64) *utxoCache {
+	return &utxoCache{
+		db:                  db,
+		maxTotalMemoryUsage: maxTotalMemoryUsage,
+		cachedEntries:       make(map[wire.OutPoint]*utxo.UtxoEntry),
+		lastFlushTime:       time.Now(),
+	}
+}
+
+// entryMemory returns the approximate memory usage of the passed cached entry.
+func entryMemory(entry *utxo.UtxoEntry) uint64 {
+	return utxoCacheEntryOverhead + uint64(len(entry.PkScript))
+}
+
+// totalMemoryUsage returns the approximate memory usage of the cache in bytes.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) totalMemoryUsage() uint64 {
+	c.mtx.Lock()
+	usage := c.totalEntryMemory
+	c.mtx.Unlock()
+	return usage
+}
+
+// addEntry adds the passed entry to the cache, replacing any existing entry
+// for the outpoint.
+//
+// This function MUST be called with the cache lock held.
+func (c *utxoCache) addEntry(outpoint wire.OutPoint, entry *utxo.UtxoEntry) {
+	c.removeEntry(outpoint)
+	c.cachedEntries[outpoint] = entry
+	c.totalEntryMemory += entryMemory(entry)
+}
+
+// removeEntry removes the entry for the passed outpoint from the cache.
+//
+// This function MUST be called with the cache lock held.
+func (c *utxoCache) removeEntry(outpoint wire.OutPoint) {
+	if entry, ok := c.cachedEntries[outpoint]; ok {
+		c.totalEntryMemory -= entryMemory(entry)
+		delete(c.cachedEntries, outpoint)
+	}
+}
+
+// fetchEntries returns the unspent entries for the passed outpoints.  Entries
+// which are not cached are loaded from the database and added to the cache.
+// The returned entries are copies which the caller is free to modify and nil
+// is returned for outpoints without an unspent output.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) fetchEntries(outpoints []wire.OutPoint) ([]*utxo.UtxoEntry, error) {
+	return c.fetchEntriesInternal(outpoints, true)
+}
+
+// fetchEntriesUncached returns the unspent entries for the passed outpoints
+// the same way as fetchEntries except entries which are loaded from the
+// database are not added to the cache.  This is useful for loading outputs
+// which are unlikely to be needed again, such as the historical outputs
+// checked by the taxation rules, without evicting the cached entries.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) fetchEntriesUncached(outpoints []wire.OutPoint) ([]*utxo.UtxoEntry, error) {
+	return c.fetchEntriesInternal(outpoints, false)
+}
+
+// fetchEntriesInternal is the implementation of fetchEntries and
+// fetchEntriesUncached.  Entries loaded from the database are only added to the
+// cache when addLoaded is set.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) fetchEntriesInternal(outpoints []wire.OutPoint, addLoaded bool) ([]*utxo.UtxoEntry, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	entries := make([]*utxo.UtxoEntry, len(outpoints))
+	var missing []int
+	for i, outpoint := range outpoints {
+		entry, ok := c.cachedEntries[outpoint]
+		if !ok {
+			missing = append(missing, i)
+			continue
+		}
+		if !entry.IsSpent() {
+			entries[i] = entry
+		}
+	}
+
+	// Load the entries which are not cached from the database.  Missing
+	// entries are not cached since they are most likely requested by
+	// transactions which are orphans or double spends.
+	if len(missing) > 0 {
+		err := c.db.View(func(dbTx database.Tx) error {
+			for _, i := range missing {
+				entry, err := dbFetchUtxoEntry(dbTx, outpoints[i])
+				if err != nil {
+					return err
+				}
+				if entry == nil {
+					continue
+				}
+
+				if addLoaded {
+					c.addEntry(outpoints[i], entry)
+				}
+				entries[i] = entry
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Hand out copies so modifications made to the entries by the caller
+	// do not affect the cache.
+	for i, entry := range entries {
+		if entry != nil {
+			entries[i] = entry.Clone()
+			entries[i].PackedFlags &^= utxo.TfModified | utxo.TfFresh
+		}
+	}
+	return entries, nil
+}
+
+// fetchEntry returns the unspent entry for the passed outpoint or nil when
+// there is none.  See fetchEntries for more details.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) fetchEntry(outpoint wire.OutPoint) (*utxo.UtxoEntry, error) {
+	entries, err := c.fetchEntries([]wire.OutPoint{outpoint})
+	if err != nil {
+		return nil, err
+	}
+	return entries[0], nil
+}
+
+// commit applies the modified entries of the passed view, which represents
+// the state of the end of the main chain, to the cache.  The view itself is
+// left untouched.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) commit(view *UtxoViewpoint) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for outpoint, entry := range view.entries {
+		if entry == nil || !entry.IsModified() {
+			continue
+		}
+
+		// Outputs which are not in the database yet only need to be
+		// written when they are unspent.  Outputs which are spent are
+		// removed from the database on the next flush.  The cached
+		// entry knows best whether the output is in the database, but
+		// entries loaded into the view may have been evicted from the
+		// cache since.
+		fresh := entry.IsFresh()
+		cached, ok := c.cachedEntries[outpoint]
+		if ok {
+			fresh = cached.IsFresh()
+		}
+		if entry.IsSpent() {
+			if fresh {
+				c.removeEntry(outpoint)
+				continue
+			}
+			c.addEntry(outpoint, &utxo.UtxoEntry{
+				PackedFlags: utxo.TfSpent | utxo.TfModified,
+			})
+			continue
+		}
+
+		cached = entry.Clone()
+		cached.PackedFlags &^= utxo.TfFresh
+		cached.PackedFlags |= utxo.TfModified
+		if fresh {
+			cached.PackedFlags |= utxo.TfFresh
+		}
+		c.addEntry(outpoint, cached)
+	}
+}
+
+// writeToDB uses an existing database transaction to write the modified
+// entries of the cache to the database along with the hash of the block the
+// utxo set is consistent with once written.  The cache must be marked flushed
+// with markFlushed once the transaction is committed.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) writeToDB(dbTx database.Tx, bestHash *chainhash.Hash) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	utxoBucket := dbTx.Metadata().Bucket(utxoSetBucketName)
+	for outpoint, entry := range c.cachedEntries {
+		if !entry.IsModified() {
+			continue
+		}
+
+		// Remove the utxo entry if it is spent.
+		key := outpointKey(outpoint)
+		if entry.IsSpent() {
+			err := utxoBucket.Delete(*key)
+			recycleOutpointKey(key)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		serialized, err := serializeUtxoEntry(entry)
+		if err != nil {
+			recycleOutpointKey(key)
+			return err
+		}
+		err = utxoBucket.Put(*key, serialized)
+		// NOTE: The key is intentionally not recycled here since the
+		// database interface contract prohibits modifications.  It will
+		// be garbage collected normally when the database is done with
+		// it.
+		if err != nil {
+			return err
+		}
+	}
+
+	return dbPutUtxoStateConsistency(dbTx, bestHash)
+}
+
+// markFlushed marks all of the cached entries as written to the database once
+// the transaction passed to writeToDB has been committed.  The cache is
+// emptied when it exceeds its memory budget.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) markFlushed() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.totalEntryMemory > c.maxTotalMemoryUsage {
+		c.cachedEntries = make(map[wire.OutPoint]*utxo.UtxoEntry)
+		c.totalEntryMemory = 0
+	} else {
+		for outpoint, entry := range c.cachedEntries {
+			if entry.IsSpent() {
+				c.removeEntry(outpoint)
+				continue
+			}
+			entry.PackedFlags &^= utxo.TfModified | utxo.TfFresh
+		}
+	}
+	c.lastFlushTime = time.Now()
+}
+
+// flush writes the modified entries of the cache to the database along with
+// the hash of the block the utxo set is consistent with.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) flush(bestHash *chainhash.Hash) error {
+	err := c.db.Update(func(dbTx database.Tx) error {
+		return c.writeToDB(dbTx, bestHash)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.markFlushed()
+	return nil
+}
+
+// needsFlush returns whether the cache should be flushed after connecting a
+// block because it exceeds its memory budget or it has not been flushed for
+// the periodic flush interval.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) needsFlush() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.totalEntryMemory > c.maxTotalMemoryUsage ||
+		time.Since(c.lastFlushTime) > utxoFlushPeriodicInterval
+}
+
+// maybeFlush flushes the cache when needed after the block with the passed
+// hash has been connected.
+//
+// This function is safe for concurrent access.
+func (c *utxoCache) maybeFlush(bestHash *chainhash.Hash) error {
+	if !c.needsFlush() {
+		return nil
+	}
+	return c.flush(bestHash)
+}
+
+// initConsistentUtxoState ensures the utxo set is consistent with the best
+// chain state.  The changes made by the blocks connected after the utxo set was
+// last flushed, which are lost when the process is not shut down cleanly, are
+// replayed from the blocks stored in the database.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) initConsistentUtxoState(interrupt <-chan struct{}) error {
+	tip := b.bestChain.Tip()
+	var consistentHash *chainhash.Hash
+	err := b.db.View(func(dbTx database.Tx) error {
+		var err error
+		consistentHash, err = dbFetchUtxoStateConsistency(dbTx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Databases which do not record the consistency were written without
+	// a cache, so the utxo set is consistent with the best chain state.
+	if consistentHash == nil {
+		return b.db.Update(func(dbTx database.Tx) error {
+			return dbPutUtxoStateConsistency(dbTx, &tip.hash)
+		})
+	}
+	if *consistentHash == tip.hash {
+		return nil
+	}
+
+	// The main chain is never reorganized without flushing first, so the
+	// block the utxo set is consistent with must be in the main chain.
+	node := b.index.LookupNode(consistentHash)
+	if node == nil || !b.bestChain.Contains(node) {
+		return AssertError(fmt.Sprintf("utxo set is consistent with "+
+			"block %v which is not in the main chain", consistentHash))
+	}
+
+	log.Infof("Reconstructing utxo set from height %d to %d.  This "+
+		"might take a while...", node.height+1, tip.height)
+	for node = b.bestChain.Next(node); node != nil; node = b.bestChain.Next(node) {
+		var block *btcutil.Block
+		err := b.db.View(func(dbTx database.Tx) error {
+			var err error
+			block, err = dbFetchBlockByNode(dbTx, node)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		view := NewUtxoViewpoint()
+		err = view.fetchInputUtxos(b.utxoCache, block, b.chainParams)
+		if err != nil {
+			return err
+		}
+		keepBurned, err := b.keepsBurnedOutputs(node)
+		if err != nil {
+			return err
+		}
+		err = view.connectTransactions(block, nil, b.chainParams,
+			keepBurned)
+		if err != nil {
+			return err
+		}
+		b.utxoCache.commit(view)
+
+		// Flush regularly so the progress is kept when interrupted.
+		if err := b.utxoCache.maybeFlush(&node.hash); err != nil {
+			return err
+		}
+		if interruptRequested(interrupt) {
+			return errInterruptRequested
+		}
+	}
+
+	return b.utxoCache.flush(&tip.hash)
+}
+
+// FlushUtxoCache writes all of the changes to the utxo set which are held in
+// memory to the database.  It should be called before shutting down so the
+// changes do not have to be replayed on the next start.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) FlushUtxoCache() error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	return b.utxoCache.flush(&b.bestChain.Tip().hash)
+}
@@ -0,0 +1,369 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/organicbitcoin/obtcd/chaincfg"
+	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
+	"github.com/organicbitcoin/obtcd/database"
+	"github.com/organicbitcoin/obtcd/txscript"
+	"github.com/organicbitcoin/obtcd/utxo"
+	"github.com/organicbitcoin/obtcd/wire"
+)
+
+// dbHasUtxoEntry returns whether the utxo set in the passed database contains
+// an entry for the passed outpoint.
+func dbHasUtxoEntry(t *testing.T, db database.DB, outpoint wire.OutPoint) bool {
+	var entry *utxo.UtxoEntry
+	err := db.View(func(dbTx database.Tx) error {
+		var err error
+		entry, err = dbFetchUtxoEntry(dbTx, outpoint)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("dbFetchUtxoEntry: unexpected error: %v", err)
+	}
+	return entry != nil
+}
+
+// TestUtxoCache ensures the utxo cache only writes the changes to the utxo set
+// to the database when it is flushed and that it never writes outputs which are
+// created and spent in between flushes.
+func TestUtxoCache(t *testing.T) {
+	chain, teardownFunc, err := chainSetup("utxocache",
+		&chaincfg.RegressionNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	newEntry := func(height int32) *utxo.UtxoEntry {
+		return &utxo.UtxoEntry{
+			Amount:      5000,
+			PkScript:    []byte{txscript.OP_TRUE},
+			BlockHeight: height,
+			PackedFlags: utxo.TfModified | utxo.TfFresh,
+		}
+	}
+	stored := wire.OutPoint{Hash: chainhash.Hash{0x01}}
+	transient := wire.OutPoint{Hash: chainhash.Hash{0x02}}
+	hash := chainhash.Hash{0x03}
+
+	// Add an output and ensure it is only written to the database once the
+	// cache is flushed.
+	cache := newUtxoCache(chain.db, 1<<20)
+	view := NewUtxoViewpoint()
+	view.entries[stored] = newEntry(1)
+	cache.commit(view)
+	view.commit()
+	if dbHasUtxoEntry(t, chain.db, stored) {
+		t.Fatal("output written to the database before flushing")
+	}
+	entry, err := cache.fetchEntry(stored)
+	if err != nil {
+		t.Fatalf("fetchEntry: unexpected error: %v", err)
+	}
+	want := &utxo.UtxoEntry{
+		Amount:      5000,
+		PkScript:    []byte{txscript.OP_TRUE},
+		BlockHeight: 1,
+	}
+	if !reflect.DeepEqual(entry, want) {
+		t.Fatalf("fetchEntry: unexpected entry - got %+v, want %+v",
+			entry, want)
+	}
+	if err := cache.flush(&hash); err != nil {
+		t.Fatalf("flush: unexpected error: %v", err)
+	}
+	if !dbHasUtxoEntry(t, chain.db, stored) {
+		t.Fatal("output not written to the database after flushing")
+	}
+	var consistentHash *chainhash.Hash
+	err = chain.db.View(func(dbTx database.Tx) error {
+		var err error
+		consistentHash, err = dbFetchUtxoStateConsistency(dbTx)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("dbFetchUtxoStateConsistency: unexpected error: %v", err)
+	}
+	if consistentHash == nil || *consistentHash != hash {
+		t.Fatalf("unexpected consistent hash - got %v, want %v",
+			consistentHash, hash)
+	}
+
+	// Spend the stored output and add and spend another one before
+	// flushing.  Only the spend of the stored output may reach the
+	// database.
+	view = NewUtxoViewpoint()
+	if err := view.fetchUtxosMain(cache, map[wire.OutPoint]struct{}{
+		stored: {},
+	}); err != nil {
+		t.Fatalf("fetchUtxosMain: unexpected error: %v", err)
+	}
+	view.LookupEntry(stored).Spend()
+	view.entries[transient] = newEntry(2)
+	view.entries[transient].Spend()
+	cache.commit(view)
+	if _, ok := cache.cachedEntries[transient]; ok {
+		t.Fatal("output created and spent before flushing is cached")
+	}
+	entry, err = cache.fetchEntry(stored)
+	if err != nil {
+		t.Fatalf("fetchEntry: unexpected error: %v", err)
+	}
+	if entry != nil {
+		t.Fatalf("fetchEntry: unexpected entry for spent output %+v",
+			entry)
+	}
+	if !dbHasUtxoEntry(t, chain.db, stored) {
+		t.Fatal("spent output removed from the database before " +
+			"flushing")
+	}
+	if err := cache.flush(&hash); err != nil {
+		t.Fatalf("flush: unexpected error: %v", err)
+	}
+	if dbHasUtxoEntry(t, chain.db, stored) {
+		t.Fatal("spent output not removed from the database after " +
+			"flushing")
+	}
+	if len(cache.cachedEntries) != 0 || cache.totalMemoryUsage() != 0 {
+		t.Fatalf("unexpected cached entries after flushing %d (%d "+
+			"bytes)", len(cache.cachedEntries),
+			cache.totalMemoryUsage())
+	}
+}
+
+// TestUtxoCacheEviction ensures outputs loaded into a view which are evicted
+// from the cache before being spent are still removed from the database.
+func TestUtxoCacheEviction(t *testing.T) {
+	chain, teardownFunc, err := chainSetup("utxocacheeviction",
+		&chaincfg.RegressionNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// Store an output in the database through a cache without a memory
+	// budget, which is emptied on every flush.
+	outpoint := wire.OutPoint{Hash: chainhash.Hash{0x01}}
+	hash := chainhash.Hash{0x02}
+	cache := newUtxoCache(chain.db, 0)
+	view := NewUtxoViewpoint()
+	view.entries[outpoint] = &utxo.UtxoEntry{
+		Amount:      5000,
+		PkScript:    []byte{txscript.OP_TRUE},
+		BlockHeight: 1,
+		PackedFlags: utxo.TfModified | utxo.TfFresh,
+	}
+	cache.commit(view)
+	view.commit()
+	if err := cache.flush(&hash); err != nil {
+		t.Fatalf("flush: unexpected error: %v", err)
+	}
+	if len(cache.cachedEntries) != 0 {
+		t.Fatalf("unexpected cached entries after flushing %d",
+			len(cache.cachedEntries))
+	}
+
+	// Spend the output from the view which still holds it and ensure the
+	// spend reaches the database.
+	view.LookupEntry(outpoint).Spend()
+	cache.commit(view)
+	if err := cache.flush(&hash); err != nil {
+		t.Fatalf("flush: unexpected error: %v", err)
+	}
+	if dbHasUtxoEntry(t, chain.db, outpoint) {
+		t.Fatal("spent output not removed from the database")
+	}
+}
+
+// TestUtxoCacheReplay ensures the utxo set changes which were not flushed to
+// the database before the chain instance went away are replayed when a new
+// instance is created.
+func TestUtxoCacheReplay(t *testing.T) {
+	blocks, err := loadBlocks("blk_0_to_4.dat.bz2")
+	if err != nil {
+		t.Fatalf("Error loading file: %v\n", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("utxocachereplay",
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// Process the blocks without ever flushing the utxo cache.
+	chain.utxoCache = newUtxoCache(chain.db, 1<<30)
+	chain.TstSetCoinbaseMaturity(1)
+	for i := 1; i < len(blocks); i++ {
+		_, _, err := chain.ProcessBlock(blocks[i], BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock fail on block %v: %v\n", i, err)
+		}
+	}
+	tip := blocks[len(blocks)-1]
+	coinbase := wire.OutPoint{Hash: *tip.Transactions()[0].Hash()}
+	want, err := chain.FetchUtxoEntry(coinbase)
+	if err != nil || want == nil {
+		t.Fatalf("FetchUtxoEntry: unexpected result %v (%v)", want, err)
+	}
+	if dbHasUtxoEntry(t, chain.db, coinbase) {
+		t.Fatal("output written to the database before flushing")
+	}
+
+	// Create a new chain instance on the same database as if the process
+	// was restarted after a crash and ensure the utxo set is caught up.
+	chain, err = New(&Config{
+		DB:          chain.db,
+		ChainParams: chain.chainParams,
+		TimeSource:  NewMedianTime(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create chain instance: %v", err)
+	}
+	if !dbHasUtxoEntry(t, chain.db, coinbase) {
+		t.Fatal("output not written to the database after replaying")
+	}
+	got, err := chain.FetchUtxoEntry(coinbase)
+	if err != nil {
+		t.Fatalf("FetchUtxoEntry: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("FetchUtxoEntry: unexpected entry after replaying - "+
+			"got %+v, want %+v", got, want)
+	}
+	var consistentHash *chainhash.Hash
+	err = chain.db.View(func(dbTx database.Tx) error {
+		var err error
+		consistentHash, err = dbFetchUtxoStateConsistency(dbTx)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("dbFetchUtxoStateConsistency: unexpected error: %v", err)
+	}
+	if consistentHash == nil || *consistentHash != *tip.Hash() {
+		t.Fatalf("unexpected consistent hash - got %v, want %v",
+			consistentHash, tip.Hash())
+	}
+}
+
+// benchmarkProcessBlocks benchmarks processing the bundled test blocks with the
+// passed utxo cache memory budget.
+func benchmarkProcessBlocks(b *testing.B, utxoCacheMaxSize uint64) {
+	blocks, err := loadBlocks("blk_0_to_4.dat.bz2")
+	if err != nil {
+		b.Fatalf("Error loading file: %v\n", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		chain, teardownFunc, err := chainSetup("benchutxocache",
+			&chaincfg.MainNetParams)
+		if err != nil {
+			b.Fatalf("Failed to setup chain instance: %v", err)
+		}
+		chain.utxoCache = newUtxoCache(chain.db, utxoCacheMaxSize)
+		chain.TstSetCoinbaseMaturity(1)
+		b.StartTimer()
+
+		for j := 1; j < len(blocks); j++ {
+			_, _, err := chain.ProcessBlock(blocks[j], BFNone)
+			if err != nil {
+				b.Fatalf("ProcessBlock fail on block %v: %v\n",
+					j, err)
+			}
+		}
+		if err := chain.FlushUtxoCache(); err != nil {
+			b.Fatalf("FlushUtxoCache: unexpected error: %v", err)
+		}
+
+		b.StopTimer()
+		teardownFunc()
+		b.StartTimer()
+	}
+}
+
+// BenchmarkProcessBlocksNoUtxoCache benchmarks processing the bundled test
+// blocks while flushing the utxo set changes after every block.
+func BenchmarkProcessBlocksNoUtxoCache(b *testing.B) {
+	benchmarkProcessBlocks(b, 0)
+}
+
+// BenchmarkProcessBlocksUtxoCache benchmarks processing the bundled test blocks
+// while keeping the utxo set changes in memory until the end.
+func BenchmarkProcessBlocksUtxoCache(b *testing.B) {
+	benchmarkProcessBlocks(b, 250*1024*1024)
+}
+
+// TestUtxoCacheFetchUncached ensures fetching entries without caching them
+// reads the outputs stored in the database without adding them to the cache
+// while still honoring the cached state of the outputs.
+func TestUtxoCacheFetchUncached(t *testing.T) {
+	chain, teardownFunc, err := chainSetup("utxocacheuncached",
+		&chaincfg.RegressionNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	stored := wire.OutPoint{Hash: chainhash.Hash{0x01}}
+	spent := wire.OutPoint{Hash: chainhash.Hash{0x02}}
+	hash := chainhash.Hash{0x03}
+
+	// Store two outputs in the database.
+	cache := newUtxoCache(chain.db, 1<<20)
+	view := NewUtxoViewpoint()
+	for _, outpoint := range []wire.OutPoint{stored, spent} {
+		view.entries[outpoint] = &utxo.UtxoEntry{
+			Amount:      5000,
+			PkScript:    []byte{txscript.OP_TRUE},
+			BlockHeight: 1,
+			PackedFlags: utxo.TfModified | utxo.TfFresh,
+		}
+	}
+	cache.commit(view)
+	if err := cache.flush(&hash); err != nil {
+		t.Fatalf("flush: unexpected error: %v", err)
+	}
+
+	// Spend one of them without flushing a new cache so the other one is
+	// only stored in the database.
+	cache = newUtxoCache(chain.db, 1<<20)
+	view = NewUtxoViewpoint()
+	if err := view.fetchUtxosMain(cache, map[wire.OutPoint]struct{}{
+		spent: {},
+	}); err != nil {
+		t.Fatalf("fetchUtxosMain: unexpected error: %v", err)
+	}
+	view.LookupEntry(spent).Spend()
+	cache.commit(view)
+	numCached := len(cache.cachedEntries)
+
+	entries, err := cache.fetchEntriesUncached([]wire.OutPoint{stored,
+		spent})
+	if err != nil {
+		t.Fatalf("fetchEntriesUncached: unexpected error: %v", err)
+	}
+	if entries[0] == nil || entries[0].BlockHeight != 1 {
+		t.Fatalf("fetchEntriesUncached: unexpected entry for stored "+
+			"output %+v", entries[0])
+	}
+	if entries[1] != nil {
+		t.Fatalf("fetchEntriesUncached: unexpected entry for spent "+
+			"output %+v", entries[1])
+	}
+	if _, ok := cache.cachedEntries[stored]; ok ||
+		len(cache.cachedEntries) != numCached {
+
+		t.Fatal("fetchEntriesUncached: output loaded from the " +
+			"database was cached")
+	}
+}
@@ -7,6 +7,7 @@ package blockchain
 import (
 	"fmt"
 
+	"github.com/organicbitcoin/obtcd/chaincfg"
 	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
 	"github.com/organicbitcoin/obtcd/database"
 	"github.com/organicbitcoin/obtcd/txscript"
@@ -52,13 +53,50 @@ func (view *UtxoViewpoint) LookupEntry(outpoint wire.OutPoint) *utxo.UtxoEntry {
 	return view.entries[outpoint]
 }
 
+// isBurnedOutput returns whether the passed output burns coins by paying them
+// to a provably unspendable script, such as an OP_RETURN output with a value.
+func isBurnedOutput(txOut *wire.TxOut) bool {
+	return txOut.Value > 0 && txscript.IsUnspendable(txOut.PkScript)
+}
+
+// isFullExpiryActive returns whether full expiry is active for the block AFTER
+// the passed node.  It is active once both the full expiry and the taxation
+// deployments are active.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) isFullExpiryActive(prevNode *blockNode) (bool, error) {
+	state, err := b.deploymentState(prevNode, chaincfg.DeploymentFullExpiry)
+	if err != nil || state != ThresholdActive {
+		return false, err
+	}
+
+	state, err = b.deploymentState(prevNode, chaincfg.DeploymentTaxation)
+	if err != nil {
+		return false, err
+	}
+	return state == ThresholdActive, nil
+}
+
+// keepsBurnedOutputs returns whether the outputs which burn coins in the block
+// of the passed node are kept in the utxo set.  They are kept once full expiry
+// is active so the burned coins can be swept by a tax transaction after they
+// expire.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) keepsBurnedOutputs(node *blockNode) (bool, error) {
+	return b.isFullExpiryActive(node.parent)
+}
+
 // addTxOut adds the specified output to the view if it is not provably
-// unspendable.  When the view already has an entry for the output, it will be
-// marked unspent.  All fields will be updated for existing entries since it's
-// possible it has changed during a reorg.
-func (view *UtxoViewpoint) addTxOut(outpoint wire.OutPoint, txOut *wire.TxOut, isCoinBase bool, blockHeight int32) {
+// unspendable, unless it burns coins and keepBurned is set.  When the view
+// already has an entry for the output, it will be marked unspent.  All fields
+// will be updated for existing entries since it's possible it has changed
+// during a reorg.  An expiry height of zero means it is not known.
+func (view *UtxoViewpoint) addTxOut(outpoint wire.OutPoint, txOut *wire.TxOut, isCoinBase bool, blockHeight, expiryHeight int32, keepBurned bool) {
 	// Don't add provably unspendable outputs.
-	if txscript.IsUnspendable(txOut.PkScript) {
+	if txscript.IsUnspendable(txOut.PkScript) &&
+		!(keepBurned && isBurnedOutput(txOut)) {
+
 		return
 	}
 
@@ -66,16 +104,22 @@ func (view *UtxoViewpoint) addTxOut(outpoint wire.OutPoint, txOut *wire.TxOut, i
 	// possible (although extremely unlikely) that the existing entry is
 	// being replaced by a different transaction with the same hash.  This
 	// is allowed so long as the previous transaction is fully spent.
+	//
+	// New entries are marked fresh since the output can't be in the
+	// database yet.
+	flags := utxo.TfModified
 	entry := view.LookupEntry(outpoint)
 	if entry == nil {
 		entry = new(utxo.UtxoEntry)
 		view.entries[outpoint] = entry
+		flags |= utxo.TfFresh
 	}
 
 	entry.Amount = txOut.Value
 	entry.PkScript = txOut.PkScript
 	entry.BlockHeight = blockHeight
-	entry.PackedFlags = utxo.TfModified
+	entry.ExpiryHeight = expiryHeight
+	entry.PackedFlags = flags
 	if isCoinBase {
 		entry.PackedFlags |= utxo.TfCoinBase
 	}
@@ -84,8 +128,9 @@ func (view *UtxoViewpoint) addTxOut(outpoint wire.OutPoint, txOut *wire.TxOut, i
 // AddTxOut adds the specified output of the passed transaction to the view if
 // it exists and is not provably unspendable.  When the view already has an
 // entry for the output, it will be marked unspent.  All fields will be updated
-// for existing entries since it's possible it has changed during a reorg.
-func (view *UtxoViewpoint) AddTxOut(tx *btcutil.Tx, txOutIdx uint32, blockHeight int32) {
+// for existing entries since it's possible it has changed during a reorg.  The
+// expiry height of the output is determined by the passed chain parameters.
+func (view *UtxoViewpoint) AddTxOut(tx *btcutil.Tx, txOutIdx uint32, blockHeight int32, chainParams *chaincfg.Params) {
 	// Can't add an output for an out of bounds index.
 	if txOutIdx >= uint32(len(tx.MsgTx().TxOut)) {
 		return
@@ -97,14 +142,29 @@ func (view *UtxoViewpoint) AddTxOut(tx *btcutil.Tx, txOutIdx uint32, blockHeight
 	// is allowed so long as the previous transaction is fully spent.
 	prevOut := wire.OutPoint{Hash: *tx.Hash(), Index: txOutIdx}
 	txOut := tx.MsgTx().TxOut[txOutIdx]
-	view.addTxOut(prevOut, txOut, IsCoinBase(tx), blockHeight)
+	expiryHeight := utxo.CalcExpiryHeight(blockHeight,
+		chainParams.ValidChainLength)
+	view.addTxOut(prevOut, txOut, IsCoinBase(tx), blockHeight,
+		expiryHeight, false)
 }
 
 // AddTxOuts adds all outputs in the passed transaction which are not provably
 // unspendable to the view.  When the view already has entries for any of the
 // outputs, they are simply marked unspent.  All fields will be updated for
+// existing entries since it's possible it has changed during a reorg.  The
+// expiry height of the outputs is determined by the passed chain parameters.
+func (view *UtxoViewpoint) AddTxOuts(tx *btcutil.Tx, blockHeight int32, chainParams *chaincfg.Params) {
+	expiryHeight := utxo.CalcExpiryHeight(blockHeight,
+		chainParams.ValidChainLength)
+	view.addTxOuts(tx, blockHeight, expiryHeight, false)
+}
+
+// addTxOuts adds all outputs in the passed transaction which are not provably
+// unspendable to the view along with the outputs which burn coins when
+// keepBurned is set.  When the view already has entries for any of the
+// outputs, they are simply marked unspent.  All fields will be updated for
 // existing entries since it's possible it has changed during a reorg.
-func (view *UtxoViewpoint) AddTxOuts(tx *btcutil.Tx, blockHeight int32) {
+func (view *UtxoViewpoint) addTxOuts(tx *btcutil.Tx, blockHeight, expiryHeight int32, keepBurned bool) {
 	// Loop all of the transaction outputs and add those which are not
 	// provably unspendable.
 	isCoinBase := IsCoinBase(tx)
@@ -116,7 +176,8 @@ func (view *UtxoViewpoint) AddTxOuts(tx *btcutil.Tx, blockHeight int32) {
 		// same hash.  This is allowed so long as the previous
 		// transaction is fully spent.
 		prevOut.Index = uint32(txOutIdx)
-		view.addTxOut(prevOut, txOut, isCoinBase, blockHeight)
+		view.addTxOut(prevOut, txOut, isCoinBase, blockHeight,
+			expiryHeight, keepBurned)
 	}
 }
 
@@ -124,12 +185,18 @@ func (view *UtxoViewpoint) AddTxOuts(tx *btcutil.Tx, blockHeight int32) {
 // passed transaction and marking all utxos that the transactions spend as
 // spent.  In addition, when the 'stxos' argument is not nil, it will be updated
 // to append an entry for each spent txout.  An error will be returned if the
-// view does not contain the required utxos.
-func (view *UtxoViewpoint) connectTransaction(tx *btcutil.Tx, blockHeight int32, stxos *[]SpentTxOut) error {
+// view does not contain the required utxos.  Outputs which burn coins are
+// added as well when the keep burned flag is set, which must be the case when
+// keepsBurnedOutputs is true for the block.  The passed chain parameters
+// determine the expiry height of the new utxos.
+func (view *UtxoViewpoint) connectTransaction(tx *btcutil.Tx, blockHeight int32, stxos *[]SpentTxOut, chainParams *chaincfg.Params, keepBurned bool) error {
+	expiryHeight := utxo.CalcExpiryHeight(blockHeight,
+		chainParams.ValidChainLength)
+
 	// Coinbase transactions don't have any inputs to spend.
 	if IsCoinBase(tx) {
 		// Add the transaction's outputs as available utxos.
-		view.AddTxOuts(tx, blockHeight)
+		view.addTxOuts(tx, blockHeight, expiryHeight, keepBurned)
 		return nil
 	}
 
@@ -164,7 +231,7 @@ func (view *UtxoViewpoint) connectTransaction(tx *btcutil.Tx, blockHeight int32,
 	}
 
 	// Add the transaction's outputs as available utxos.
-	view.AddTxOuts(tx, blockHeight)
+	view.addTxOuts(tx, blockHeight, expiryHeight, keepBurned)
 	return nil
 }
 
@@ -172,10 +239,12 @@ func (view *UtxoViewpoint) connectTransaction(tx *btcutil.Tx, blockHeight int32,
 // of the transactions in the passed block, marking all utxos the transactions
 // spend as spent, and setting the best hash for the view to the passed block.
 // In addition, when the 'stxos' argument is not nil, it will be updated to
-// append an entry for each spent txout.
-func (view *UtxoViewpoint) connectTransactions(block *btcutil.Block, stxos *[]SpentTxOut) error {
+// append an entry for each spent txout.  The keep burned flag is passed
+// through to connectTransaction.
+func (view *UtxoViewpoint) connectTransactions(block *btcutil.Block, stxos *[]SpentTxOut, chainParams *chaincfg.Params, keepBurned bool) error {
 	for _, tx := range block.Transactions() {
-		err := view.connectTransaction(tx, block.Height(), stxos)
+		err := view.connectTransaction(tx, block.Height(), stxos,
+			chainParams, keepBurned)
 		if err != nil {
 			return err
 		}
@@ -216,8 +285,9 @@ func (view *UtxoViewpoint) fetchEntryByHash(db database.DB, hash *chainhash.Hash
 // disconnectTransactions updates the view by removing all of the transactions
 // created by the passed block, restoring all utxos the transactions spent by
 // using the provided spent txo information, and setting the best hash for the
-// view to the block before the passed block.
-func (view *UtxoViewpoint) disconnectTransactions(db database.DB, block *btcutil.Block, stxos []SpentTxOut) error {
+// view to the block before the passed block.  The keep burned flag must match
+// the one the block was connected with.
+func (view *UtxoViewpoint) disconnectTransactions(db database.DB, block *btcutil.Block, stxos []SpentTxOut, chainParams *chaincfg.Params, keepBurned bool) error {
 	// Sanity check the correct number of stxos are provided.
 	if len(stxos) != countSpentOutputs(block) {
 		return AssertError("disconnectTransactions called with bad " +
@@ -253,7 +323,9 @@ func (view *UtxoViewpoint) disconnectTransactions(db database.DB, block *btcutil
 		txHash := tx.Hash()
 		prevOut := wire.OutPoint{Hash: *txHash}
 		for txOutIdx, txOut := range tx.MsgTx().TxOut {
-			if txscript.IsUnspendable(txOut.PkScript) {
+			if txscript.IsUnspendable(txOut.PkScript) &&
+				!(keepBurned && isBurnedOutput(txOut)) {
+
 				continue
 			}
 
@@ -334,6 +406,8 @@ func (view *UtxoViewpoint) disconnectTransactions(db database.DB, block *btcutil
 			entry.Amount = stxo.Amount
 			entry.PkScript = stxo.PkScript
 			entry.BlockHeight = stxo.Height
+			entry.ExpiryHeight = utxo.CalcExpiryHeight(stxo.Height,
+				chainParams.ValidChainLength)
 			entry.PackedFlags = utxo.TfModified
 			if stxo.IsCoinBase {
 				entry.PackedFlags |= utxo.TfCoinBase
@@ -369,6 +443,7 @@ func (view *UtxoViewpoint) commit() {
 		}
 
 		entry.PackedFlags ^= utxo.TfModified
+		entry.PackedFlags &^= utxo.TfFresh
 	}
 }
 
@@ -379,7 +454,7 @@ func (view *UtxoViewpoint) commit() {
 // Upon completion of this function, the view will contain an entry for each
 // requested outpoint.  Spent outputs, or those which otherwise don't exist,
 // will result in a nil entry in the view.
-func (view *UtxoViewpoint) fetchUtxosMain(db database.DB, outpoints map[wire.OutPoint]struct{}) error {
+func (view *UtxoViewpoint) fetchUtxosMain(cache *utxoCache, outpoints map[wire.OutPoint]struct{}) error {
 	// Nothing to do if there are no requested outputs.
 	if len(outpoints) == 0 {
 		return nil
@@ -392,24 +467,25 @@ func (view *UtxoViewpoint) fetchUtxosMain(db database.DB, outpoints map[wire.Out
 	// will result in nil entries in the view.  This is intentionally done
 	// so other code can use the presence of an entry in the store as a way
 	// to unnecessarily avoid attempting to reload it from the database.
-	return db.View(func(dbTx database.Tx) error {
-		for outpoint := range outpoints {
-			entry, err := dbFetchUtxoEntry(dbTx, outpoint)
-			if err != nil {
-				return err
-			}
-
-			view.entries[outpoint] = entry
-		}
+	needed := make([]wire.OutPoint, 0, len(outpoints))
+	for outpoint := range outpoints {
+		needed = append(needed, outpoint)
+	}
+	entries, err := cache.fetchEntries(needed)
+	if err != nil {
+		return err
+	}
+	for i, outpoint := range needed {
+		view.entries[outpoint] = entries[i]
+	}
 
-		return nil
-	})
+	return nil
 }
 
 // fetchUtxos loads the unspent transaction outputs for the provided set of
-// outputs into the view from the database as needed unless they already exist
+// outputs into the view from the utxo cache as needed unless they already exist
 // in the view in which case they are ignored.
-func (view *UtxoViewpoint) fetchUtxos(db database.DB, outpoints map[wire.OutPoint]struct{}) error {
+func (view *UtxoViewpoint) fetchUtxos(cache *utxoCache, outpoints map[wire.OutPoint]struct{}) error {
 	// Nothing to do if there are no requested outputs.
 	if len(outpoints) == 0 {
 		return nil
@@ -426,8 +502,8 @@ func (view *UtxoViewpoint) fetchUtxos(db database.DB, outpoints map[wire.OutPoin
 		neededSet[outpoint] = struct{}{}
 	}
 
-	// Request the input utxos from the database.
-	return view.fetchUtxosMain(db, neededSet)
+	// Request the input utxos from the utxo cache.
+	return view.fetchUtxosMain(cache, neededSet)
 }
 
 // fetchInputUtxos loads the unspent transaction outputs for the inputs
@@ -435,7 +511,7 @@ func (view *UtxoViewpoint) fetchUtxos(db database.DB, outpoints map[wire.OutPoin
 // database as needed.  In particular, referenced entries that are earlier in
 // the block are added to the view and entries that are already in the view are
 // not modified.
-func (view *UtxoViewpoint) fetchInputUtxos(db database.DB, block *btcutil.Block) error {
+func (view *UtxoViewpoint) fetchInputUtxos(cache *utxoCache, block *btcutil.Block, chainParams *chaincfg.Params) error {
 	// Build a map of in-flight transactions because some of the inputs in
 	// this block could be referencing other transactions earlier in this
 	// block which are not yet in the chain.
@@ -467,7 +543,8 @@ func (view *UtxoViewpoint) fetchInputUtxos(db database.DB, block *btcutil.Block)
 				i >= inFlightIndex {
 
 				originTx := transactions[inFlightIndex]
-				view.AddTxOuts(originTx, block.Height())
+				view.AddTxOuts(originTx, block.Height(),
+					chainParams)
 				continue
 			}
 
@@ -481,8 +558,8 @@ func (view *UtxoViewpoint) fetchInputUtxos(db database.DB, block *btcutil.Block)
 		}
 	}
 
-	// Request the input utxos from the database.
-	return view.fetchUtxosMain(db, neededSet)
+	// Request the input utxos from the utxo cache.
+	return view.fetchUtxosMain(cache, neededSet)
 }
 
 // NewUtxoViewpoint returns a new empty unspent transaction output view.
@@ -518,11 +595,61 @@ func (b *BlockChain) FetchUtxoView(tx *btcutil.Tx) (*UtxoViewpoint, error) {
 	// chain.
 	view := NewUtxoViewpoint()
 	b.chainLock.RLock()
-	err := view.fetchUtxosMain(b.db, neededSet)
+	err := view.fetchUtxosMain(b.utxoCache, neededSet)
 	b.chainLock.RUnlock()
 	return view, err
 }
 
+// ForEachUtxo invokes the provided function with every unspent transaction
+// output in the utxo set as of the last time it was flushed to the database.
+// The utxo cache is flushed first, so the iterated utxo set is at least as
+// recent as the best chain state at the time of the call.  The hash and height
+// of the block the iterated utxo set corresponds to are returned.  The
+// iteration stops early when the function returns an error, in which case that
+// error is returned.
+//
+// The utxo set is read from a consistent database snapshot, so the chain lock
+// is not held while iterating and the chain may advance concurrently.  The
+// entries passed to the function must not be retained since they reference
+// memory that is only valid during the iteration.
+func (b *BlockChain) ForEachUtxo(fn func(outpoint wire.OutPoint, entry *utxo.UtxoEntry) error) (*chainhash.Hash, int32, error) {
+	if err := b.FlushUtxoCache(); err != nil {
+		return nil, 0, err
+	}
+
+	var bestHash chainhash.Hash
+	var bestHeight int32
+	err := b.db.View(func(dbTx database.Tx) error {
+		// Load the block the utxo set is consistent with from the same
+		// snapshot as the utxo set.
+		hash, err := dbFetchUtxoStateConsistency(dbTx)
+		if err != nil {
+			return err
+		}
+		var node *blockNode
+		if hash != nil {
+			node = b.index.LookupNode(hash)
+		}
+		if node == nil {
+			return AssertError("utxo set is not consistent with " +
+				"any known block")
+		}
+		bestHash = node.hash
+		bestHeight = node.height
+
+		return dbForEachUtxoEntry(dbTx, func(_ []byte,
+			outpoint wire.OutPoint, entry *utxo.UtxoEntry) error {
+
+			return fn(outpoint, entry)
+		})
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &bestHash, bestHeight, nil
+}
+
 // FetchUtxoEntry loads and returns the requested unspent transaction output
 // from the point of view of the end of the main chain.
 //
@@ -537,15 +664,29 @@ func (b *BlockChain) FetchUtxoEntry(outpoint wire.OutPoint) (*utxo.UtxoEntry, er
 	b.chainLock.RLock()
 	defer b.chainLock.RUnlock()
 
-	var entry *utxo.UtxoEntry
-	err := b.db.View(func(dbTx database.Tx) error {
-		var err error
-		entry, err = dbFetchUtxoEntry(dbTx, outpoint)
-		return err
-	})
+	return b.utxoCache.fetchEntry(outpoint)
+}
+
+// IsUtxoExpired returns whether the requested unspent transaction output from
+// the point of view of the end of the main chain is expired at the passed
+// height.  Unlike the expired flag of the entries returned by FetchUtxoEntry,
+// which reflects the height an output was last checked at while validating a
+// block, the result only depends on the output and the passed height.
+//
+// An error is returned when the output does not exist in the main chain or has
+// been spent.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) IsUtxoExpired(outpoint wire.OutPoint, height int32) (bool, error) {
+	entry, err := b.FetchUtxoEntry(outpoint)
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+	if entry == nil || entry.IsSpent() {
+		str := fmt.Sprintf("output %v either does not exist or has "+
+			"already been spent", outpoint)
+		return false, ruleError(ErrMissingTxOut, str)
 	}
 
-	return entry, nil
+	return entry.IsExpiredAt(height), nil
 }
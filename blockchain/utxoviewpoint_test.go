@@ -1,9 +1,16 @@
 package blockchain
 
 import (
+	"errors"
 	"testing"
 
+	"github.com/organicbitcoin/obtcd/chaincfg"
+	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
+	"github.com/organicbitcoin/obtcd/database"
+	"github.com/organicbitcoin/obtcd/txscript"
 	"github.com/organicbitcoin/obtcd/utxo"
+	"github.com/organicbitcoin/obtcd/wire"
+	"github.com/organicbitcoin/btcutil"
 )
 
 func TestCheckExpired(t *testing.T) {
@@ -12,9 +19,96 @@ func TestCheckExpired(t *testing.T) {
 		BlockHeight: 100001,
 	}
 
-	if utxoEntry.CheckExpired(200000) {
+	if utxoEntry.CheckExpired(200000,
+		chaincfg.MainNetParams.ValidChainLength) {
 		t.Error("Entry should not expired")
 	}
+
+	// The expired flag must reflect the height the entry was last checked
+	// at.
+	if !utxoEntry.CheckExpired(500000,
+		chaincfg.MainNetParams.ValidChainLength) ||
+		!utxoEntry.IsExpired() {
+
+		t.Error("Entry should expired")
+	}
+	if utxoEntry.CheckExpired(200000,
+		chaincfg.MainNetParams.ValidChainLength) ||
+		utxoEntry.IsExpired() {
+
+		t.Error("Entry should not expired after checking a lower height")
+	}
+}
+
+// TestAddTxOutsExpiryHeight ensures the outputs added to a view have their
+// expiry height set according to the chain parameters.
+func TestAddTxOutsExpiryHeight(t *testing.T) {
+	params := &chaincfg.MainNetParams
+	msgTx := wire.NewMsgTx(1)
+	msgTx.AddTxIn(&wire.TxIn{})
+	msgTx.AddTxOut(wire.NewTxOut(1000, []byte{txscript.OP_TRUE}))
+	msgTx.AddTxOut(wire.NewTxOut(2000, []byte{txscript.OP_TRUE}))
+	tx := btcutil.NewTx(msgTx)
+
+	const height = 1000
+	wantExpiry := utxo.CalcExpiryHeight(height, params.ValidChainLength)
+	view := NewUtxoViewpoint()
+	view.AddTxOut(tx, 0, height, params)
+	entry := view.LookupEntry(wire.OutPoint{Hash: *tx.Hash(), Index: 0})
+	if entry == nil || entry.ExpiryHeight != wantExpiry {
+		t.Fatalf("AddTxOut: unexpected entry %+v, want expiry height "+
+			"%d", entry, wantExpiry)
+	}
+	if !entry.IsExpiredAt(wantExpiry) {
+		t.Error("AddTxOut: entry should expired at its expiry height")
+	}
+
+	view = NewUtxoViewpoint()
+	view.AddTxOuts(tx, height, params)
+	for i := range msgTx.TxOut {
+		outpoint := wire.OutPoint{Hash: *tx.Hash(), Index: uint32(i)}
+		entry := view.LookupEntry(outpoint)
+		if entry == nil || entry.ExpiryHeight != wantExpiry {
+			t.Fatalf("AddTxOuts: unexpected entry %+v for %v, "+
+				"want expiry height %d", entry, outpoint,
+				wantExpiry)
+		}
+	}
+
+	// The expiry height of unmined outputs is not known.
+	view = NewUtxoViewpoint()
+	view.AddTxOuts(tx, 0x7fffffff, params)
+	entry = view.LookupEntry(wire.OutPoint{Hash: *tx.Hash(), Index: 0})
+	if entry == nil || entry.ExpiryHeight != 0 {
+		t.Fatalf("AddTxOuts: unexpected entry %+v for unmined output",
+			entry)
+	}
+}
+
+func TestIsExpiredAt(t *testing.T) {
+	validChainLength := chaincfg.MainNetParams.ValidChainLength
+	utxoEntry := &utxo.UtxoEntry{
+		Amount:       1000000,
+		BlockHeight:  100001,
+		ExpiryHeight: utxo.CalcExpiryHeight(100001, validChainLength),
+	}
+
+	// The expiry height must agree with CheckExpired.
+	for _, height := range []int32{utxoEntry.ExpiryHeight - 1,
+		utxoEntry.ExpiryHeight} {
+
+		want := utxoEntry.Clone().CheckExpired(height, validChainLength)
+		if got := utxoEntry.IsExpiredAt(height); got != want {
+			t.Errorf("IsExpiredAt(%d) = %v, want %v", height, got,
+				want)
+		}
+	}
+
+	// Entries without an expiry height are never reported expired.
+	utxoEntry.ExpiryHeight = 0
+	if utxoEntry.IsExpiredAt(1 << 30) {
+		t.Error("Entry without expiry height should not expired")
+	}
 }
 
 func TestIsExpired(t *testing.T) {
@@ -49,3 +143,85 @@ func TestExpired(t *testing.T) {
 		t.Error("Entry should expired after set tfExpired flag")
 	}
 }
+
+// TestForEachUtxo ensures every entry in the utxo set is iterated along with
+// the best chain state the utxo set corresponds to.
+func TestForEachUtxo(t *testing.T) {
+	chain, teardownFunc, err := chainSetup("foreachutxo",
+		&chaincfg.RegressionNetParams)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// Add a couple of entries to the utxo set.
+	want := map[wire.OutPoint]*utxo.UtxoEntry{
+		{Hash: chainhash.Hash{0x01}, Index: 0}: {
+			Amount:      5000,
+			PkScript:    []byte{txscript.OP_TRUE},
+			BlockHeight: 1,
+			PackedFlags: utxo.TfCoinBase | utxo.TfModified,
+		},
+		{Hash: chainhash.Hash{0x02}, Index: 300}: {
+			Amount:      7000,
+			PkScript:    []byte{txscript.OP_TRUE},
+			BlockHeight: 2,
+			PackedFlags: utxo.TfModified,
+		},
+	}
+	view := NewUtxoViewpoint()
+	for outpoint, entry := range want {
+		view.entries[outpoint] = entry.Clone()
+	}
+	err = chain.db.Update(func(dbTx database.Tx) error {
+		return dbPutUtxoView(dbTx, view)
+	})
+	if err != nil {
+		t.Fatalf("Failed to store utxo view: %v", err)
+	}
+
+	got := make(map[wire.OutPoint]*utxo.UtxoEntry)
+	hash, height, err := chain.ForEachUtxo(func(outpoint wire.OutPoint,
+		entry *utxo.UtxoEntry) error {
+
+		got[outpoint] = entry
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ForEachUtxo: unexpected error: %v", err)
+	}
+	genesisHash := chaincfg.RegressionNetParams.GenesisHash
+	if *hash != *genesisHash || height != 0 {
+		t.Fatalf("ForEachUtxo: unexpected best state - got %v (%d), "+
+			"want %v (0)", hash, height, genesisHash)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ForEachUtxo: unexpected number of entries - got %d, "+
+			"want %d", len(got), len(want))
+	}
+	for outpoint, wantEntry := range want {
+		entry, ok := got[outpoint]
+		if !ok {
+			t.Fatalf("ForEachUtxo: missing entry for %v", outpoint)
+		}
+		if entry.Amount != wantEntry.Amount ||
+			entry.BlockHeight != wantEntry.BlockHeight ||
+			entry.IsCoinBase() != wantEntry.IsCoinBase() {
+
+			t.Fatalf("ForEachUtxo: unexpected entry for %v - got "+
+				"%+v, want %+v", outpoint, entry, wantEntry)
+		}
+	}
+
+	// Ensure an error returned by the function stops the iteration.
+	errStop := errors.New("stop")
+	var calls int
+	_, _, err = chain.ForEachUtxo(func(wire.OutPoint, *utxo.UtxoEntry) error {
+		calls++
+		return errStop
+	})
+	if err != errStop || calls != 1 {
+		t.Fatalf("ForEachUtxo: unexpected result - got %v after %d "+
+			"calls, want %v after 1 call", err, calls, errStop)
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/organicbitcoin/btcutil"
 	"github.com/organicbitcoin/obtcd/chaincfg"
 	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
+	"github.com/organicbitcoin/obtcd/database"
 	"github.com/organicbitcoin/obtcd/txscript"
 	"github.com/organicbitcoin/obtcd/utxo"
 	"github.com/organicbitcoin/obtcd/wire"
@@ -714,6 +715,16 @@ func (b *BlockChain) checkBlockHeaderContext(header *wire.BlockHeader, prevNode
 		return ruleError(ErrForkTooOld, str)
 	}
 
+	// Prevent blocks which fork the main chain at or before the base of the
+	// utxo snapshot the chain state was loaded from since the blocks before
+	// it are not available to disconnect.
+	if b.snapshotBase != nil && blockHeight <= b.snapshotBase.height {
+		str := fmt.Sprintf("block at height %d forks the main chain "+
+			"before the utxo snapshot base at height %d",
+			blockHeight, b.snapshotBase.height)
+		return ruleError(ErrForkTooOld, str)
+	}
+
 	// Reject outdated block versions once a majority of the network
 	// has upgraded.  These were originally voted on by BIP0034,
 	// BIP0065, and BIP0066.
@@ -831,6 +842,19 @@ func (b *BlockChain) checkBlockContext(block *btcutil.Block, prevNode *blockNode
 				return ruleError(ErrBlockWeightTooHigh, str)
 			}
 		}
+
+		// Ensure the block provides a valid solution to the challenge
+		// of the network on signet.  Block templates are checked
+		// without proof of work before they are solved and thus
+		// signed, so the solution is not checked for them either.
+		challenge := b.chainParams.SignetChallenge
+		if challenge != nil && flags&BFNoPoWCheck != BFNoPoWCheck {
+			err := checkSignetSolution(block, challenge,
+				b.chainParams.GenesisHash)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -858,7 +882,7 @@ func (b *BlockChain) checkBIP0030(node *blockNode, block *btcutil.Block, view *U
 			fetchSet[prevOut] = struct{}{}
 		}
 	}
-	err := view.fetchUtxos(b.db, fetchSet)
+	err := view.fetchUtxos(b.utxoCache, fetchSet)
 	if err != nil {
 		return err
 	}
@@ -887,9 +911,14 @@ func (b *BlockChain) checkBIP0030(node *blockNode, block *btcutil.Block, view *U
 // the bitcoins and therefore allowed to spend them.  As it checks the inputs,
 // it also calculates the total fees for the transaction and returns that value.
 //
+// Once the taxation deployment is active for the block the transaction is
+// included in, which the enforce taxation flag indicates, expired outputs may
+// only be spent by tax transactions and tax transactions must pay the expected
+// tax amount.
+//
 // NOTE: The transaction MUST have already been sanity checked with the
 // CheckTransactionSanity function prior to calling this function.
-func CheckTransactionInputs(tx *btcutil.Tx, txHeight int32, utxoView *UtxoViewpoint, chainParams *chaincfg.Params) (int64, error) {
+func CheckTransactionInputs(tx *btcutil.Tx, txHeight int32, utxoView *UtxoViewpoint, chainParams *chaincfg.Params, enforceTaxation bool) (int64, error) {
 	// Coinbase transactions have no inputs.
 	if IsCoinBase(tx) {
 		return 0, nil
@@ -927,9 +956,9 @@ func CheckTransactionInputs(tx *btcutil.Tx, txHeight int32, utxoView *UtxoViewpo
 
 		// OBTC check
 		// After obtc activated, tax tx must use expired utxo, reguar tx must not
-		if txHeight > chainParams.TaxationBeginHeight {
+		if enforceTaxation {
 			// Must set the TfExpired value to check if utxo has been expired
-			utxo.CheckExpired(txHeight)
+			utxo.CheckExpired(txHeight, chainParams.ValidChainLength)
 
 			if tx.IsTaxTx() && !utxo.IsExpired() {
 				// unexpired tax utxo
@@ -977,7 +1006,7 @@ func CheckTransactionInputs(tx *btcutil.Tx, txHeight int32, utxoView *UtxoViewpo
 	}
 
 	// Check tax amount
-	if txHeight > chainParams.TaxationBeginHeight && tx.IsTaxTx() {
+	if enforceTaxation && tx.IsTaxTx() {
 		_, err := checkTxTaxAmount(tx, utxoView, chainParams)
 		if err != nil {
 			return 0, err
@@ -1078,7 +1107,7 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 	//
 	// These utxo entries are needed for verification of things such as
 	// transaction inputs, counting pay-to-script-hashes, and scripts.
-	err := view.fetchInputUtxos(b.db, block)
+	err := view.fetchInputUtxos(b.utxoCache, block, b.chainParams)
 	if err != nil {
 		return err
 	}
@@ -1098,6 +1127,23 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 	}
 	enforceSegWit := segwitState == ThresholdActive
 
+	// Query for the Version Bits state for the taxation deployment.  Once
+	// it is active, expired outputs may only be swept by tax transactions.
+	taxationState, err := b.deploymentState(node.parent,
+		chaincfg.DeploymentTaxation)
+	if err != nil {
+		return err
+	}
+	enforceTaxation := taxationState == ThresholdActive
+
+	// Once full expiry is active, tax transactions may sweep expired
+	// coinbase outputs and the outputs which burn coins are kept in the
+	// utxo set.
+	fullExpiry, err := b.isFullExpiryActive(node.parent)
+	if err != nil {
+		return err
+	}
+
 	// The number of signature operations must be less than the maximum
 	// allowed per block.  Note that the preliminary sanity checks on a
 	// block also include a check similar to this one, but this check
@@ -1131,6 +1177,17 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 		}
 	}
 
+	// Validate tax transactions if the block contain any tax transactions.
+	// This must be done before the transactions are connected to the view
+	// since that marks the expired utxos they sweep as spent.
+	if block.HasTaxTransactions() {
+		taxTxErr := b.validateTaxTransactions(block, view,
+			enforceTaxation, fullExpiry)
+		if taxTxErr != nil {
+			return taxTxErr
+		}
+	}
+
 	// Perform several checks on the inputs for each transaction.  Also
 	// accumulate the total fees.  This could technically be combined with
 	// the loop above instead of running another loop over the transactions,
@@ -1141,7 +1198,7 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 	var totalFees int64
 	for _, tx := range transactions {
 		txFee, err := CheckTransactionInputs(tx, node.height, view,
-			b.chainParams)
+			b.chainParams, enforceTaxation)
 		if err != nil {
 			return err
 		}
@@ -1159,7 +1216,8 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 		// provably unspendable as available utxos.  Also, the passed
 		// spent txos slice is updated to contain an entry for each
 		// spent txout in the order each transaction spends them.
-		err = view.connectTransaction(tx, node.height, stxos)
+		err = view.connectTransaction(tx, node.height, stxos,
+			b.chainParams, fullExpiry)
 		if err != nil {
 			return err
 		}
@@ -1183,14 +1241,6 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 		return ruleError(ErrBadCoinbaseValue, str)
 	}
 
-	// Validate tax transactions if the block contain any tax transactions
-	if block.HasTaxTransactions() {
-		taxTxErr := b.validateTaxTransactions(block, view)
-		if taxTxErr != nil {
-			return taxTxErr
-		}
-	}
-
 	// Don't run scripts if this node is before the latest known good
 	// checkpoint since the validity is verified via the checkpoints (all
 	// transactions are included in the merkle root hash and any changes
@@ -1203,6 +1253,14 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *btcutil.Block, vi
 		runScripts = false
 	}
 
+	// Likewise, don't run scripts for blocks assumed to be valid, which
+	// allows syncing quickly without maintaining checkpoints.  Only the
+	// scripts are skipped, so the taxation and expiry rules are still
+	// enforced.
+	if runScripts && b.isAssumedValid(node) {
+		runScripts = false
+	}
+
 	// Blocks created after the BIP0016 activation time need to have the
 	// pay-to-script-hash checks enabled.
 	var scriptFlags txscript.ScriptFlags
@@ -1332,6 +1390,7 @@ func (b *BlockChain) CheckConnectBlockTemplate(block *btcutil.Block) error {
 // checkTaxTransactionInputsAmount verifies:
 // 1. all input transfer certain amount to coinbase address if the input utxo is larger than dust satoshi amount.
 // 2. all input transfer all amount to coinbase address if the input utxo is lower or equal than dust satoshi amount.
+// 3. all input transfer all amount to coinbase address if the input utxo burned coins, since burned coins are fully released.
 func checkTxTaxAmount(tx *btcutil.Tx, utxoView UtxoViewpointInterface, chainParams *chaincfg.Params) (int64, error) {
 	// Build input hash and output hash
 	// [key, value] -> [addressArray, amount]
@@ -1347,12 +1406,16 @@ func checkTxTaxAmount(tx *btcutil.Tx, utxoView UtxoViewpointInterface, chainPara
 		if utxo == nil {
 			return errAmount, ruleError(ErrMissingTxOut, "tax input is not an utxo")
 		}
+		totalTaxAmount += utxo.Amount
+		// Burned coins are fully released, so they are never paid back
+		if txscript.IsUnspendable(utxo.PkScript) {
+			continue
+		}
 		addresses, err := concatAddressesFromPkScript(utxo.PkScript, chainParams)
 		if err != nil {
 			return errAmount, ruleError(ErrBadAddress, "Invalid output address")
 		}
 		inputMap[addresses] = utxo.Amount
-		totalTaxAmount += utxo.Amount
 	}
 
 	// Put output address and amount to the outputHash
@@ -1393,6 +1456,55 @@ func checkTxTaxAmount(tx *btcutil.Tx, utxoView UtxoViewpointInterface, chainPara
 	return totalTaxAmount, nil
 }
 
+// TaxedInputAmounts returns the amount swept as tax from each input of the
+// passed tax transaction, in input order, given the outputs it spends.  The
+// spent outputs must be in the same order as the transaction inputs.
+//
+// Inputs are matched with the outputs paying back to the same addresses in the
+// same way as checkTxTaxAmount, so a matched input is taxed the difference
+// between its amount and the returned amount while any other input, such as a
+// dust output or an output which burned coins, is swept in its entirety.
+func TaxedInputAmounts(tx *btcutil.Tx, stxos []SpentTxOut, chainParams *chaincfg.Params) ([]int64, error) {
+	msgTx := tx.MsgTx()
+	if len(stxos) != len(msgTx.TxIn) {
+		str := fmt.Sprintf("got %d spent outputs for %d inputs",
+			len(stxos), len(msgTx.TxIn))
+		return nil, AssertError(str)
+	}
+
+	// Map each address key to the index of the input it refers to.  Later
+	// inputs replace earlier ones with the same key to mirror the
+	// consensus checks.
+	inputIdxs := make(map[string]int)
+	taxed := make([]int64, len(stxos))
+	for i := range stxos {
+		taxed[i] = stxos[i].Amount
+		if txscript.IsUnspendable(stxos[i].PkScript) {
+			continue
+		}
+		addresses, err := concatAddressesFromPkScript(stxos[i].PkScript,
+			chainParams)
+		if err != nil {
+			return nil, ruleError(ErrBadAddress, "Invalid output address")
+		}
+		inputIdxs[addresses] = i
+	}
+
+	// Deduct the amount paid back to the owner of each matched input.
+	for _, txOut := range msgTx.TxOut {
+		addresses, err := concatAddressesFromPkScript(txOut.PkScript,
+			chainParams)
+		if err != nil {
+			return nil, ruleError(ErrBadAddress, "Invalid output address")
+		}
+		if i, ok := inputIdxs[addresses]; ok {
+			taxed[i] = stxos[i].Amount - txOut.Value
+		}
+	}
+
+	return taxed, nil
+}
+
 func concatAddressesFromPkScript(pkScript []byte, chainParams *chaincfg.Params) (string, error) {
 	_, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript, chainParams)
 	if err != nil {
@@ -1420,17 +1532,26 @@ func FetchTaxTransactions(block *btcutil.Block) []*btcutil.Tx {
 
 // fetchAndValidateExpiredUtxosAndLargestHeight returns the highest block height from a given block
 // taxTxs is an array of all tax transactions from a given block
-func fetchAndValidateExpiredUtxosAndLargestHeight(taxTxs []*btcutil.Tx, utxoView UtxoViewpointInterface) (map[wire.OutPoint]*utxo.UtxoEntry, int32, error) {
+// blockHeight is the height of the block containing the tax transactions
+// fullExpiry is whether full expiry is active for the block
+func fetchAndValidateExpiredUtxosAndLargestHeight(taxTxs []*btcutil.Tx, utxoView UtxoViewpointInterface, blockHeight int32, chainParams *chaincfg.Params, fullExpiry bool) (map[wire.OutPoint]*utxo.UtxoEntry, int32, error) {
 	expiredUtxos := make(map[wire.OutPoint]*utxo.UtxoEntry)
 	height := int32(0)
 
 	for _, tx := range taxTxs {
 		for _, txInput := range tx.MsgTx().TxIn {
 			utxo := utxoView.LookupEntry(txInput.PreviousOutPoint)
+			if utxo == nil || utxo.IsSpent() {
+				return nil, int32(0), ruleError(ErrMissingTxOut, "tax input is not an utxo")
+			}
 			// all utxos must be expired
-			if utxo == nil || utxo.IsSpent() || utxo.IsCoinBase() || !utxo.IsExpired() {
+			if !utxo.CheckExpired(blockHeight, chainParams.ValidChainLength) {
 				return nil, int32(0), ruleError(ErrUnexpiredTaxUTXO, "utxos in tax transactions must be expired")
 			}
+			// coinbase utxos can only be swept once full expiry begins
+			if utxo.IsCoinBase() && !fullExpiry {
+				return nil, int32(0), ruleError(ErrCoinbaseTaxUTXO, "coinbase utxos can not be swept before full expiry begins")
+			}
 			if expiredUtxos[txInput.PreviousOutPoint] == nil {
 				expiredUtxos[txInput.PreviousOutPoint] = utxo
 				if height < utxo.BlockHeight {
@@ -1451,10 +1572,16 @@ func fetchAndValidateExpiredUtxosAndLargestHeight(taxTxs []*btcutil.Tx, utxoView
 // This function serves a block for the function "fetchHighestTaxTxInputHeight"
 // Warning: if there's no tax transactions on the chain, it will recusively reach to the genesis
 func FetchPrevBlockHasTaxTxs(b Interface, block *btcutil.Block) (*btcutil.Block, error) {
+	return fetchPrevBlockHasTaxTxs(b, block, 1)
+}
+
+// fetchPrevBlockHasTaxTxs returns the latest previous block that contain tax
+// transactions without looking at blocks below the passed height.
+func fetchPrevBlockHasTaxTxs(b Interface, block *btcutil.Block, minHeight int32) (*btcutil.Block, error) {
 	var prevBlock *btcutil.Block
 	var err error
 
-	for h := block.Height() - 1; h > 0; h-- {
+	for h := block.Height() - 1; h >= minHeight; h-- {
 		prevBlock, err = b.BlockByHeight(h)
 		if err != nil {
 			return nil, err
@@ -1466,6 +1593,58 @@ func FetchPrevBlockHasTaxTxs(b Interface, block *btcutil.Block) (*btcutil.Block,
 	return nil, nil
 }
 
+// fetchSpentInputsView returns a view which contains the utxos spent by the
+// transactions in the passed block as restored from its spend journal.  The
+// block must be part of the main chain.
+func (b *BlockChain) fetchSpentInputsView(block *btcutil.Block) (*UtxoViewpoint, error) {
+	var view *UtxoViewpoint
+	err := b.db.View(func(dbTx database.Tx) error {
+		var err error
+		view, err = dbFetchSpentInputsView(dbTx, block, b.chainParams)
+		return err
+	})
+	return view, err
+}
+
+// dbFetchSpentInputsView uses an existing database transaction to return a
+// view which contains the utxos spent by the transactions in the passed block
+// as restored from its spend journal.  The block must be part of the main
+// chain.
+func dbFetchSpentInputsView(dbTx database.Tx, block *btcutil.Block, chainParams *chaincfg.Params) (*UtxoViewpoint, error) {
+	stxos, err := dbFetchSpendJournalEntry(dbTx, block)
+	if err != nil {
+		return nil, err
+	}
+
+	view := NewUtxoViewpoint()
+	stxoIdx := 0
+	for _, tx := range block.Transactions()[1:] {
+		for _, txIn := range tx.MsgTx().TxIn {
+			if stxoIdx >= len(stxos) {
+				return nil, AssertError(fmt.Sprintf("missing "+
+					"spend journal entries for block %v",
+					block.Hash()))
+			}
+			stxo := &stxos[stxoIdx]
+			stxoIdx++
+
+			var flags utxo.TxoFlags
+			if stxo.IsCoinBase {
+				flags |= utxo.TfCoinBase
+			}
+			view.entries[txIn.PreviousOutPoint] = &utxo.UtxoEntry{
+				Amount:      stxo.Amount,
+				PkScript:    stxo.PkScript,
+				BlockHeight: stxo.Height,
+				ExpiryHeight: utxo.CalcExpiryHeight(stxo.Height,
+					chainParams.ValidChainLength),
+				PackedFlags: flags,
+			}
+		}
+	}
+	return view, nil
+}
+
 // fetchHighestTaxTxInputHeight returns the highest height of tax transaction input block.
 // The block given in the parameter must contain tax transactions
 func (b *BlockChain) fetchHighestTaxTxInputHeight(block *btcutil.Block, utxoView UtxoViewpointInterface) int32 {
@@ -1484,6 +1663,60 @@ func (b *BlockChain) fetchHighestTaxTxInputHeight(block *btcutil.Block, utxoView
 	return maxHeight
 }
 
+// fetchTaxSweepHeight returns the highest height of the utxos swept by the tax
+// transactions of the latest block before the passed one which contains any,
+// or -1 when there is no such block.  The blocks up to the base of a utxo
+// snapshot the chain state was loaded from are not available, so the height
+// as of the base block is stored along with the snapshot.
+func (b *BlockChain) fetchTaxSweepHeight(block *btcutil.Block) (int32, error) {
+	minHeight := int32(1)
+	if b.snapshotBase != nil {
+		minHeight = b.snapshotBase.height + 1
+	}
+	prevBlock, err := fetchPrevBlockHasTaxTxs(b, block, minHeight)
+	if err != nil {
+		return 0, err
+	}
+	if prevBlock == nil {
+		if b.snapshotBase != nil {
+			return b.snapshotTaxSweepHeight, nil
+		}
+		return -1, nil
+	}
+
+	// The utxos it swept are spent, so they are restored from its spend
+	// journal.
+	prevView, err := b.fetchSpentInputsView(prevBlock)
+	if err != nil {
+		return 0, err
+	}
+	return b.fetchHighestTaxTxInputHeight(prevBlock, prevView), nil
+}
+
+// dbFetchTaxSweepHeight uses an existing database transaction to return the
+// highest height of the utxos swept by the tax transactions of the latest block
+// which contains any among the passed main chain block and its ancestors, or -1
+// when there is no such block.  It is used to record the height as of the base
+// block of a utxo snapshot.
+func (b *BlockChain) dbFetchTaxSweepHeight(dbTx database.Tx, node *blockNode) (int32, error) {
+	for ; node != nil && node.height > 0; node = node.parent {
+		block, err := dbFetchBlockByNode(dbTx, node)
+		if err != nil {
+			return 0, err
+		}
+		if !block.HasTaxTransactions() {
+			continue
+		}
+
+		view, err := dbFetchSpentInputsView(dbTx, block, b.chainParams)
+		if err != nil {
+			return 0, err
+		}
+		return b.fetchHighestTaxTxInputHeight(block, view), nil
+	}
+	return -1, nil
+}
+
 // FetchUtxosInRange returns an array that contains utxos from a given range of blocks
 // It does not guarantee to return expired utxos
 // The expiration should be controlled by fromHeight and toHeight
@@ -1517,31 +1750,60 @@ func FetchUtxosInRange(b Interface, fromHeight int32, toHeight int32) (map[wire.
 // FetchUtxosByHeight returns an hash map that contains utxos from a given block height
 // It does not guarantee to return expired utxos
 // The expiration should be controlled by the height
+// It reads the utxo set through the utxo cache without acquiring the chain lock,
+// so it can be used while validating a block.  Outputs which are not cached are
+// read from the database without being added to the cache
 func (b *BlockChain) FetchUtxosByHeight(height int32) (map[wire.OutPoint]*utxo.UtxoEntry, error) {
-	block, err := b.BlockByHeight(height)
+	outPoints, err := b.fetchOutPointsByHeight(height)
 	if err != nil {
 		return nil, err
 	}
 
-	// Retrieve all txOut from utxoBucket (single dn bucket) via FetchUtxoEntry
+	// Retrieve all txOut from the utxo cache or the database, it won't
+	// return entries it cannot find.  The historical outputs are not added
+	// to the cache since they would evict the recent outputs blocks spend.
+	entries, err := b.utxoCache.fetchEntriesUncached(outPoints)
+	if err != nil {
+		return nil, err
+	}
 	utxos := make(map[wire.OutPoint]*utxo.UtxoEntry)
-	for _, tx := range block.Transactions() {
-		for i := range tx.MsgTx().TxOut {
-			// Fetch utxo from utxoBucket, it won't return error if it cannot find entry
-			outPoint := *wire.NewOutPoint(tx.Hash(), uint32(i))
-			entry, err := b.FetchUtxoEntry(outPoint)
-			if err != nil {
-				return nil, err
-			}
-			if entry != nil {
-				utxos[outPoint] = entry
-			}
+	for i, entry := range entries {
+		if entry != nil {
+			utxos[outPoints[i]] = entry
 		}
 	}
 
 	return utxos, nil
 }
 
+// fetchOutPointsByHeight returns the outpoints of the outputs created by the
+// main chain block at the given height.  The blocks before the base of a utxo
+// snapshot the chain state was loaded from are not available, so only the
+// outputs which were unspent as of the base block are returned for them.
+func (b *BlockChain) fetchOutPointsByHeight(height int32) ([]wire.OutPoint, error) {
+	if b.snapshotBase != nil && height < b.snapshotBase.height {
+		var outPoints []wire.OutPoint
+		err := b.db.View(func(dbTx database.Tx) error {
+			var err error
+			outPoints, err = dbFetchSnapshotOutPoints(dbTx, height)
+			return err
+		})
+		return outPoints, err
+	}
+
+	block, err := b.BlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	var outPoints []wire.OutPoint
+	for _, tx := range block.Transactions() {
+		for i := range tx.MsgTx().TxOut {
+			outPoints = append(outPoints, *wire.NewOutPoint(tx.Hash(), uint32(i)))
+		}
+	}
+	return outPoints, nil
+}
+
 // validateTaxTransactions check all tax transactions in a given block.
 // Assume that block contains tax transactions
 // They should satisfy:
@@ -1553,10 +1815,12 @@ func (b *BlockChain) FetchUtxosByHeight(height int32) (map[wire.OutPoint]*utxo.U
 // Please note this function is used in these scenarios:
 // 1. add a new block to the blockchain, triggered by checkConnectBlock
 // 2. mining
-func (b *BlockChain) validateTaxTransactions(block *btcutil.Block, utxoView *UtxoViewpoint) error {
-	// block height must larger than hark forked height, otherwise skip (check this outside this function)
-	if block.Height() <= b.chainParams.TaxationBeginHeight {
-		return ruleError(ErrImmatureHeightForTaxTx, "Too early to have tax transactions in this block height")
+func (b *BlockChain) validateTaxTransactions(block *btcutil.Block, utxoView *UtxoViewpoint, enforceTaxation, fullExpiry bool) error {
+	// Tax transactions are only allowed once the taxation deployment is
+	// active.
+	if !enforceTaxation {
+		return ruleError(ErrImmatureHeightForTaxTx, "Too early to have "+
+			"tax transactions before the taxation deployment is active")
 	}
 
 	// Get all tax transactions
@@ -1577,23 +1841,19 @@ func (b *BlockChain) validateTaxTransactions(block *btcutil.Block, utxoView *Utx
 		// This prevents prioritized mining large amout expired utxos.
 		// 2. Check double spend
 		// 3. All utxos must be expired
-		expiredUtxos, toExpiredUtxoHeight, err := fetchAndValidateExpiredUtxosAndLargestHeight(taxTxs, utxoView)
+		expiredUtxos, toExpiredUtxoHeight, err := fetchAndValidateExpiredUtxosAndLargestHeight(taxTxs, utxoView, block.Height(), b.chainParams, fullExpiry)
 		if err != nil {
 			return err
 		}
-		// Fetch the lastest previous block that contain tax transactions
-		prevBlock, err := FetchPrevBlockHasTaxTxs(b, block)
+		// Fetch the largest height of expired utxos swept by the lastest
+		// previous block that contain tax transactions
+		// The block on the fromExpiredUtxoHeight should not contain any utxos at this point
+		fromExpiredUtxoHeight, err := b.fetchTaxSweepHeight(block)
 		if err != nil {
 			return err
 		}
-		if prevBlock == nil {
-			// Can not find tax transactions, skip this validation
-			return nil
-		}
-		// Fetch the largest height of expired utxos from previous block
-		// The block on the fromExpiredUtxoHeight should not contain any utxos at this point
-		fromExpiredUtxoHeight := b.fetchHighestTaxTxInputHeight(prevBlock, utxoView)
 		if fromExpiredUtxoHeight == -1 {
+			// Can not find tax transactions, skip this validation
 			return nil
 		}
 		// Increase it to start checking from next block
@@ -1605,9 +1865,12 @@ func (b *BlockChain) validateTaxTransactions(block *btcutil.Block, utxoView *Utx
 		}
 		// All these utxos must be expired
 		for _, utxo := range expectedExpiredUtxos {
-			if utxo == nil || utxo.IsSpent() || utxo.IsCoinBase() || !utxo.IsExpired() {
+			if utxo == nil || utxo.IsSpent() || !utxo.CheckExpired(block.Height(), b.chainParams.ValidChainLength) {
 				return ruleError(ErrUnexpiredTaxUTXO, "utxos in tax transactions must be expired")
 			}
+			if utxo.IsCoinBase() && !fullExpiry {
+				return ruleError(ErrCoinbaseTaxUTXO, "coinbase utxos can not be swept before full expiry begins")
+			}
 		}
 		// Tax transactions refers to expired utxos in sequence
 		for k := range expiredUtxos {
@@ -15,6 +15,7 @@ import (
 	"runtime/debug"
 	"runtime/pprof"
 
+	"github.com/organicbitcoin/obtcd/blockchain"
 	"github.com/organicbitcoin/obtcd/blockchain/indexers"
 	"github.com/organicbitcoin/obtcd/database"
 	"github.com/organicbitcoin/obtcd/limits"
@@ -144,9 +145,29 @@ func btcdMain(serverChan chan<- *server) error {
 		return nil
 	}
 
+	// Load the chain state bootstrapped from a utxo snapshot if there is
+	// one.
+	snapshotDB, err := loadSnapshotDB(interrupt)
+	if err != nil {
+		btcdLog.Errorf("%v", err)
+		return err
+	}
+	if snapshotDB != nil {
+		defer func() {
+			btcdLog.Infof("Gracefully shutting down the snapshot " +
+				"database...")
+			snapshotDB.Close()
+		}()
+	}
+
+	// Return now if an interrupt signal was triggered.
+	if interruptRequested(interrupt) {
+		return nil
+	}
+
 	// Create server and start it.
-	server, err := newServer(cfg.Listeners, db, activeNetParams.Params,
-		interrupt)
+	server, err := newServer(cfg.Listeners, db, snapshotDB,
+		activeNetParams.Params, interrupt)
 	if err != nil {
 		// TODO: this logging could do with some beautifying.
 		btcdLog.Errorf("Unable to start server on %v: %v",
@@ -296,6 +317,93 @@ func loadBlockDB() (database.DB, error) {
 	return db, nil
 }
 
+// snapshotDbPath returns the path to the database holding the chain state
+// bootstrapped from a utxo snapshot given a database type.
+func snapshotDbPath(dbType string) string {
+	return blockDbPath(dbType) + "_snapshot"
+}
+
+// loadSnapshotDB loads the database holding the chain state bootstrapped from a
+// utxo snapshot and returns a handle to it.  The database is created from the
+// utxo snapshot when the loadtxoutset option is given.  It returns nil when
+// there is no such database or when the snapshot turned out not to match the
+// validated history, in which case the database is removed so the node
+// continues from the validated history.
+func loadSnapshotDB(interrupt <-chan struct{}) (database.DB, error) {
+	if cfg.DbType == "memdb" {
+		return nil, nil
+	}
+
+	// The regression test is special in that it needs a clean database for
+	// each run, so remove it now if it already exists.
+	dbPath := snapshotDbPath(cfg.DbType)
+	removeRegressionDB(dbPath)
+
+	db, err := database.Open(cfg.DbType, dbPath, activeNetParams.Net)
+	if err == nil {
+		invalid, err := blockchain.IsSnapshotInvalid(db)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		if invalid {
+			db.Close()
+			btcdLog.Warnf("Removing snapshot database '%s' since the "+
+				"utxo snapshot does not match the validated "+
+				"history", dbPath)
+			if err := os.RemoveAll(dbPath); err != nil {
+				return nil, err
+			}
+			if cfg.LoadTxOutSet != "" {
+				return nil, fmt.Errorf("the utxo snapshot "+
+					"loaded into '%s' was invalid -- remove "+
+					"the loadtxoutset option", dbPath)
+			}
+			return nil, nil
+		}
+		if cfg.LoadTxOutSet != "" {
+			db.Close()
+			return nil, fmt.Errorf("a utxo snapshot has already been "+
+				"loaded into '%s' -- remove the loadtxoutset "+
+				"option", dbPath)
+		}
+		btcdLog.Infof("Loaded snapshot database from '%s'", dbPath)
+		return db, nil
+	}
+	// Return the error if it's not because the database doesn't exist.
+	if dbErr, ok := err.(database.Error); !ok || dbErr.ErrorCode !=
+		database.ErrDbDoesNotExist {
+
+		return nil, err
+	}
+	if cfg.LoadTxOutSet == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(cfg.LoadTxOutSet)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	btcdLog.Infof("Loading utxo snapshot from '%s' into '%s'",
+		cfg.LoadTxOutSet, dbPath)
+	db, err = database.Create(cfg.DbType, dbPath, activeNetParams.Net)
+	if err != nil {
+		return nil, err
+	}
+	_, err = blockchain.LoadUtxoSnapshot(db, activeNetParams.Params, f,
+		interrupt)
+	if err != nil {
+		// Remove the partially loaded chain state so the snapshot can
+		// be loaded again.
+		db.Close()
+		os.RemoveAll(dbPath)
+		return nil, fmt.Errorf("unable to load utxo snapshot: %v", err)
+	}
+	return db, nil
+}
+
 func main() {
 	// Use all processor cores.
 	runtime.GOMAXPROCS(runtime.NumCPU())
@@ -0,0 +1,261 @@
+// Copyright (c) 2023 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcec
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/big"
+)
+
+// EllSwiftEncodingSize is the size of an ElligatorSwift encoded public key.
+const EllSwiftEncodingSize = 64
+
+// ellswiftECDHTag is the tag of the tagged hash used to derive the shared
+// secret of an ElligatorSwift x-only ECDH exchange as defined by BIP0324.
+const ellswiftECDHTag = "bip324_ellswift_xonly_ecdh"
+
+var (
+	// fieldSeven is the curve constant b of secp256k1.
+	fieldSeven = big.NewInt(7)
+
+	// fieldMinus3Sqrt is a square root of -3 modulo the field prime.
+	fieldMinus3Sqrt = func() *big.Int {
+		p := S256().P
+		minus3 := new(big.Int).Sub(p, big.NewInt(3))
+		return new(big.Int).Exp(minus3, S256().QPlus1Div4(), p)
+	}()
+
+	// errEllSwiftEncode is returned when no ElligatorSwift encoding could be
+	// found for a public key.  This can only happen when the source of
+	// randomness is broken.
+	errEllSwiftEncode = errors.New("unable to find ElligatorSwift encoding")
+)
+
+// fieldOp wraps the modular arithmetic over the secp256k1 field used by the
+// ElligatorSwift encoding.  The results are always reduced modulo the prime.
+type fieldOp struct {
+	p *big.Int
+}
+
+func (f fieldOp) mod(a *big.Int) *big.Int { return a.Mod(a, f.p) }
+
+func (f fieldOp) add(a, b *big.Int) *big.Int {
+	return f.mod(new(big.Int).Add(a, b))
+}
+
+func (f fieldOp) sub(a, b *big.Int) *big.Int {
+	return f.mod(new(big.Int).Sub(a, b))
+}
+
+func (f fieldOp) mul(a, b *big.Int) *big.Int {
+	return f.mod(new(big.Int).Mul(a, b))
+}
+
+func (f fieldOp) neg(a *big.Int) *big.Int {
+	return f.mod(new(big.Int).Neg(a))
+}
+
+// div returns a/b.  The divisor must not be zero.
+func (f fieldOp) div(a, b *big.Int) *big.Int {
+	return f.mul(a, new(big.Int).ModInverse(b, f.p))
+}
+
+// sqrt returns a square root of a or nil when a is not a square.
+func (f fieldOp) sqrt(a *big.Int) *big.Int {
+	r := new(big.Int).Exp(a, S256().QPlus1Div4(), f.p)
+	if f.mul(r, r).Cmp(a) != 0 {
+		return nil
+	}
+	return r
+}
+
+// curveRHS returns x^3 + 7.
+func (f fieldOp) curveRHS(x *big.Int) *big.Int {
+	return f.add(f.mul(f.mul(x, x), x), fieldSeven)
+}
+
+// isValidX returns whether x is the x coordinate of a point on the curve.
+func (f fieldOp) isValidX(x *big.Int) bool {
+	return f.sqrt(f.curveRHS(x)) != nil
+}
+
+// xSwiftEC maps the field elements u and t to the x coordinate of a point on
+// the curve as defined by the ElligatorSwift encoding.
+func xSwiftEC(u, t *big.Int) *big.Int {
+	f := fieldOp{p: S256().P}
+	u = f.mod(new(big.Int).Set(u))
+	t = f.mod(new(big.Int).Set(t))
+	if u.Sign() == 0 {
+		u.SetInt64(1)
+	}
+	if t.Sign() == 0 {
+		t.SetInt64(1)
+	}
+	if f.add(f.curveRHS(u), f.mul(t, t)).Sign() == 0 {
+		t = f.add(t, t)
+	}
+
+	// X = (u^3 + 7 - t^2) / (2t)
+	// Y = (X + t) / (sqrt(-3) * u)
+	x := f.div(f.sub(f.curveRHS(u), f.mul(t, t)), f.add(t, t))
+	y := f.div(f.add(x, t), f.mul(fieldMinus3Sqrt, u))
+
+	// The first of u + 4Y^2, (-X/Y - u)/2 and (X/Y - u)/2 which is on the
+	// curve is the result.  At least one of them always is.
+	two := big.NewInt(2)
+	xy := f.div(x, y)
+	candidates := []*big.Int{
+		f.add(u, f.mul(big.NewInt(4), f.mul(y, y))),
+		f.div(f.sub(f.neg(xy), u), two),
+		f.div(f.sub(xy, u), two),
+	}
+	for _, c := range candidates {
+		if f.isValidX(c) {
+			return c
+		}
+	}
+	return candidates[2]
+}
+
+// xSwiftECInv returns a field element t such that xSwiftEC(u, t) == x, or nil
+// when there is none for the passed selector.  The selector, which ranges from
+// 0 to 7, chooses which of the up to eight preimages is returned.
+func xSwiftECInv(x, u *big.Int, c byte) *big.Int {
+	f := fieldOp{p: S256().P}
+	g := f.curveRHS(u)
+
+	var s, v *big.Int
+	if c&2 == 0 {
+		// x is the second or third candidate of the decoding, which it
+		// only decodes to when the other one is not on the curve.
+		if f.isValidX(f.sub(f.neg(x), u)) {
+			return nil
+		}
+		v = new(big.Int).Set(x)
+		d := f.add(f.add(f.mul(u, u), f.mul(u, v)), f.mul(v, v))
+		if d.Sign() == 0 {
+			return nil
+		}
+		s = f.div(f.neg(g), d)
+	} else {
+		// x is the first candidate of the decoding.
+		s = f.sub(x, u)
+		if s.Sign() == 0 {
+			return nil
+		}
+		r := f.sqrt(f.neg(f.mul(s, f.add(f.mul(big.NewInt(4), g),
+			f.mul(big.NewInt(3), f.mul(s, f.mul(u, u)))))))
+		if r == nil || (c&1 != 0 && r.Sign() == 0) {
+			return nil
+		}
+		v = f.div(f.add(f.neg(u), f.div(r, s)), big.NewInt(2))
+	}
+	if c&1 != 0 {
+		v = f.sub(f.neg(u), v)
+	}
+
+	w := f.sqrt(s)
+	if w == nil {
+		return nil
+	}
+	if c&4 != 0 {
+		w = f.neg(w)
+	}
+
+	// t = w * (u * (sqrt(-3) - 1) / 2 - v)
+	a := f.div(f.mul(u, f.sub(fieldMinus3Sqrt, big.NewInt(1))),
+		big.NewInt(2))
+	return f.mul(w, f.sub(a, v))
+}
+
+// EllSwiftDecode returns the x coordinate of the public key encoded by the
+// passed ElligatorSwift encoding.  Every 64-byte string decodes to a valid
+// x coordinate.
+func EllSwiftDecode(enc [EllSwiftEncodingSize]byte) *big.Int {
+	u := new(big.Int).SetBytes(enc[:32])
+	t := new(big.Int).SetBytes(enc[32:])
+	return xSwiftEC(u, t)
+}
+
+// EllSwiftEncode returns a random ElligatorSwift encoding of the passed public
+// key using randomness read from rand.  The encoding is indistinguishable from
+// 64 uniformly random bytes and only preserves the x coordinate of the key.
+func EllSwiftEncode(pubKey *PublicKey, rand io.Reader) ([EllSwiftEncodingSize]byte, error) {
+	var enc [EllSwiftEncodingSize]byte
+	p := S256().P
+	var buf [33]byte
+	for i := 0; i < 1000; i++ {
+		if _, err := io.ReadFull(rand, buf[:]); err != nil {
+			return enc, err
+		}
+		u := new(big.Int).SetBytes(buf[:32])
+		u.Mod(u, p)
+		if u.Sign() == 0 {
+			continue
+		}
+		t := xSwiftECInv(pubKey.X, u, buf[32]&7)
+		if t == nil || xSwiftEC(u, t).Cmp(pubKey.X) != 0 {
+			continue
+		}
+
+		copy(enc[:32], paddedAppend(32, nil, u.Bytes()))
+		copy(enc[32:], paddedAppend(32, nil, t.Bytes()))
+		return enc, nil
+	}
+	return enc, errEllSwiftEncode
+}
+
+// NewEllSwiftKey generates a new private key along with a random
+// ElligatorSwift encoding of its public key.
+func NewEllSwiftKey() (*PrivateKey, [EllSwiftEncodingSize]byte, error) {
+	privKey, err := NewPrivateKey(S256())
+	if err != nil {
+		return nil, [EllSwiftEncodingSize]byte{}, err
+	}
+	enc, err := EllSwiftEncode(privKey.PubKey(), rand.Reader)
+	if err != nil {
+		return nil, [EllSwiftEncodingSize]byte{}, err
+	}
+	return privKey, enc, nil
+}
+
+// EllSwiftECDH computes the BIP0324 shared secret between the passed private
+// key and the public key of the remote party encoded by theirEnc.  ourEnc is
+// the encoding of our own public key, and initiator is whether we initiated
+// the exchange, which decides the order in which both encodings are hashed.
+func EllSwiftECDH(privKey *PrivateKey, ourEnc, theirEnc [EllSwiftEncodingSize]byte,
+	initiator bool) ([32]byte, error) {
+
+	curve := S256()
+	x := EllSwiftDecode(theirEnc)
+	y, err := decompressPoint(curve, x, false)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	// The x coordinate of the shared point does not depend on which of the
+	// two points with the decoded x coordinate is used.
+	sx, _ := curve.ScalarMult(x, y, privKey.D.Bytes())
+	sharedX := paddedAppend(32, nil, sx.Bytes())
+
+	encA, encB := ourEnc, theirEnc
+	if !initiator {
+		encA, encB = theirEnc, ourEnc
+	}
+
+	tagHash := sha256.Sum256([]byte(ellswiftECDHTag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	h.Write(encA[:])
+	h.Write(encB[:])
+	h.Write(sharedX)
+	var secret [32]byte
+	copy(secret[:], h.Sum(nil))
+	return secret, nil
+}
@@ -100,6 +100,19 @@ func NewDecodeScriptCmd(hexScript string) *DecodeScriptCmd {
 	}
 }
 
+// DumpTxOutSetCmd defines the dumptxoutset JSON-RPC command.
+type DumpTxOutSetCmd struct {
+	Path string
+}
+
+// NewDumpTxOutSetCmd returns a new instance which can be used to issue a
+// dumptxoutset JSON-RPC command.
+func NewDumpTxOutSetCmd(path string) *DumpTxOutSetCmd {
+	return &DumpTxOutSetCmd{
+		Path: path,
+	}
+}
+
 // GetAddedNodeInfoCmd defines the getaddednodeinfo JSON-RPC command.
 type GetAddedNodeInfoCmd struct {
 	DNS  bool
@@ -127,26 +140,106 @@ func NewGetBestBlockHashCmd() *GetBestBlockHashCmd {
 	return &GetBestBlockHashCmd{}
 }
 
-// GetBlockCmd defines the getblock JSON-RPC command.
+// BlockVerbosity is the verbosity level of the getblock JSON-RPC command.  It
+// replaces the verbose flag of the command, so the levels of that flag are
+// marshalled as booleans and booleans are accepted when unmarshalling.
+type BlockVerbosity int
+
+const (
+	// BlockVerbosityHex returns the serialized block as a hex-encoded
+	// string.
+	BlockVerbosityHex BlockVerbosity = 0
+
+	// BlockVerbosityTxIDs returns the block as a JSON object with the
+	// hashes of its transactions.
+	BlockVerbosityTxIDs BlockVerbosity = 1
+
+	// BlockVerbosityTx returns the block as a JSON object with each of its
+	// transactions as a JSON object.
+	BlockVerbosityTx BlockVerbosity = 2
+
+	// BlockVerbosityPrevOut returns the same as BlockVerbosityTx along with
+	// the previous output of each input, the fee of each transaction and
+	// the amounts taxed by tax transactions.
+	BlockVerbosityPrevOut BlockVerbosity = 3
+)
+
+// MarshalJSON marshals the verbosity level as a boolean when it is one of the
+// levels of the verbose flag and as a number otherwise.
+func (v BlockVerbosity) MarshalJSON() ([]byte, error) {
+	switch v {
+	case BlockVerbosityHex:
+		return json.Marshal(false)
+	case BlockVerbosityTxIDs:
+		return json.Marshal(true)
+	}
+	return json.Marshal(int(v))
+}
+
+// UnmarshalJSON unmarshals the verbosity level from either a number or a
+// boolean, where false is BlockVerbosityHex and true is BlockVerbosityTxIDs.
+func (v *BlockVerbosity) UnmarshalJSON(data []byte) error {
+	var verbose bool
+	if err := json.Unmarshal(data, &verbose); err == nil {
+		*v = BlockVerbosityHex
+		if verbose {
+			*v = BlockVerbosityTxIDs
+		}
+		return nil
+	}
+
+	var level int
+	if err := json.Unmarshal(data, &level); err != nil {
+		return err
+	}
+	*v = BlockVerbosity(level)
+	return nil
+}
+
+// NewBlockVerbosity returns a pointer to a copy of the passed verbosity level.
+func NewBlockVerbosity(v BlockVerbosity) *BlockVerbosity {
+	return &v
+}
+
+// GetBlockCmd defines the getblock JSON-RPC command.  The verbose tx flag is a
+// btcd extension which raises the verbosity level from BlockVerbosityTxIDs to
+// BlockVerbosityTx.
 type GetBlockCmd struct {
 	Hash      string
-	Verbose   *bool `jsonrpcdefault:"true"`
-	VerboseTx *bool `jsonrpcdefault:"false"`
+	Verbosity *BlockVerbosity `jsonrpcdefault:"1"`
+	VerboseTx *bool           `jsonrpcdefault:"false"`
 }
 
 // NewGetBlockCmd returns a new instance which can be used to issue a getblock
-// JSON-RPC command.
+// JSON-RPC command.  The verbose flag selects either BlockVerbosityHex or
+// BlockVerbosityTxIDs.  Use NewGetBlockVerbosityCmd for the other levels.
 //
 // The parameters which are pointers indicate they are optional.  Passing nil
 // for optional parameters will use the default value.
 func NewGetBlockCmd(hash string, verbose, verboseTx *bool) *GetBlockCmd {
+	var verbosity *BlockVerbosity
+	if verbose != nil {
+		verbosity = NewBlockVerbosity(BlockVerbosityHex)
+		if *verbose {
+			*verbosity = BlockVerbosityTxIDs
+		}
+	}
 	return &GetBlockCmd{
 		Hash:      hash,
-		Verbose:   verbose,
+		Verbosity: verbosity,
 		VerboseTx: verboseTx,
 	}
 }
 
+// NewGetBlockVerbosityCmd returns a new instance which can be used to issue a
+// getblock JSON-RPC command with the passed verbosity level.
+func NewGetBlockVerbosityCmd(hash string, verbosity BlockVerbosity) *GetBlockCmd {
+	return &GetBlockCmd{
+		Hash:      hash,
+		Verbosity: &verbosity,
+	}
+}
+
 // GetBlockChainInfoCmd defines the getblockchaininfo JSON-RPC command.
 type GetBlockChainInfoCmd struct{}
 
@@ -193,6 +286,64 @@ func NewGetBlockHeaderCmd(hash string, verbose *bool) *GetBlockHeaderCmd {
 	}
 }
 
+// HashOrHeight houses either a block hash or a block height.  It marshals to
+// and from a JSON string when the hash is set and a JSON number otherwise so it
+// can be used for parameters which accept either form.
+type HashOrHeight struct {
+	Hash   *string `json:"hash"`
+	Height *int32  `json:"height"`
+}
+
+// MarshalJSON provides a custom Marshal method for HashOrHeight.
+func (h HashOrHeight) MarshalJSON() ([]byte, error) {
+	if h.Hash != nil {
+		return json.Marshal(*h.Hash)
+	}
+	if h.Height != nil {
+		return json.Marshal(*h.Height)
+	}
+	return []byte("null"), nil
+}
+
+// UnmarshalJSON provides a custom Unmarshal method for HashOrHeight.  A JSON
+// string is treated as a block hash while a JSON number is treated as a block
+// height.
+func (h *HashOrHeight) UnmarshalJSON(data []byte) error {
+	var hash string
+	if err := json.Unmarshal(data, &hash); err == nil {
+		h.Hash = &hash
+		h.Height = nil
+		return nil
+	}
+
+	var height int32
+	if err := json.Unmarshal(data, &height); err != nil {
+		return makeError(ErrInvalidType, "hash or height must be a "+
+			"string or a 32-bit integer")
+	}
+	h.Hash = nil
+	h.Height = &height
+	return nil
+}
+
+// GetBlockStatsCmd defines the getblockstats JSON-RPC command.
+type GetBlockStatsCmd struct {
+	HashOrHeight HashOrHeight
+	Stats        *[]string
+}
+
+// NewGetBlockStatsCmd returns a new instance which can be used to issue a
+// getblockstats JSON-RPC command.
+//
+// The parameters which are pointers indicate they are optional.  Passing nil
+// for optional parameters will use the default value.
+func NewGetBlockStatsCmd(hashOrHeight HashOrHeight, stats *[]string) *GetBlockStatsCmd {
+	return &GetBlockStatsCmd{
+		HashOrHeight: hashOrHeight,
+		Stats:        stats,
+	}
+}
+
 // TemplateRequest is a request object as defined in BIP22
 // (https://en.bitcoin.it/wiki/BIP_0022), it is optionally provided as an
 // pointer argument to GetBlockTemplateCmd.
@@ -606,6 +757,26 @@ func NewReconsiderBlockCmd(blockHash string) *ReconsiderBlockCmd {
 	}
 }
 
+// ScanTxOutSetCmd defines the scantxoutset JSON-RPC command.  The action is
+// one of "start", "abort" or "status" and the scan objects are the output
+// descriptors to match and are only required for the "start" action.
+type ScanTxOutSetCmd struct {
+	Action      string
+	ScanObjects *[]string
+}
+
+// NewScanTxOutSetCmd returns a new instance which can be used to issue a
+// scantxoutset JSON-RPC command.
+//
+// The parameters which are pointers indicate they are optional.  Passing nil
+// for optional parameters will use the default value.
+func NewScanTxOutSetCmd(action string, scanObjects *[]string) *ScanTxOutSetCmd {
+	return &ScanTxOutSetCmd{
+		Action:      action,
+		ScanObjects: scanObjects,
+	}
+}
+
 // SearchRawTransactionsCmd defines the searchrawtransactions JSON-RPC command.
 type SearchRawTransactionsCmd struct {
 	Address     string
@@ -781,6 +952,7 @@ func init() {
 	MustRegisterCmd("createrawtransaction", (*CreateRawTransactionCmd)(nil), flags)
 	MustRegisterCmd("decoderawtransaction", (*DecodeRawTransactionCmd)(nil), flags)
 	MustRegisterCmd("decodescript", (*DecodeScriptCmd)(nil), flags)
+	MustRegisterCmd("dumptxoutset", (*DumpTxOutSetCmd)(nil), flags)
 	MustRegisterCmd("getaddednodeinfo", (*GetAddedNodeInfoCmd)(nil), flags)
 	MustRegisterCmd("getbestblockhash", (*GetBestBlockHashCmd)(nil), flags)
 	MustRegisterCmd("getblock", (*GetBlockCmd)(nil), flags)
@@ -788,6 +960,7 @@ func init() {
 	MustRegisterCmd("getblockcount", (*GetBlockCountCmd)(nil), flags)
 	MustRegisterCmd("getblockhash", (*GetBlockHashCmd)(nil), flags)
 	MustRegisterCmd("getblockheader", (*GetBlockHeaderCmd)(nil), flags)
+	MustRegisterCmd("getblockstats", (*GetBlockStatsCmd)(nil), flags)
 	MustRegisterCmd("getblocktemplate", (*GetBlockTemplateCmd)(nil), flags)
 	MustRegisterCmd("getcfilter", (*GetCFilterCmd)(nil), flags)
 	MustRegisterCmd("getcfilterheader", (*GetCFilterHeaderCmd)(nil), flags)
@@ -815,6 +988,7 @@ func init() {
 	MustRegisterCmd("ping", (*PingCmd)(nil), flags)
 	MustRegisterCmd("preciousblock", (*PreciousBlockCmd)(nil), flags)
 	MustRegisterCmd("reconsiderblock", (*ReconsiderBlockCmd)(nil), flags)
+	MustRegisterCmd("scantxoutset", (*ScanTxOutSetCmd)(nil), flags)
 	MustRegisterCmd("searchrawtransactions", (*SearchRawTransactionsCmd)(nil), flags)
 	MustRegisterCmd("sendrawtransaction", (*SendRawTransactionCmd)(nil), flags)
 	MustRegisterCmd("setgenerate", (*SetGenerateCmd)(nil), flags)
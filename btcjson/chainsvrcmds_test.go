@@ -103,6 +103,17 @@ func TestChainSvrCmds(t *testing.T) {
 			marshalled:   `{"jsonrpc":"1.0","method":"decodescript","params":["00"],"id":1}`,
 			unmarshalled: &btcjson.DecodeScriptCmd{HexScript: "00"},
 		},
+		{
+			name: "dumptxoutset",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("dumptxoutset", "utxo.dat")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewDumpTxOutSetCmd("utxo.dat")
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"dumptxoutset","params":["utxo.dat"],"id":1}`,
+			unmarshalled: &btcjson.DumpTxOutSetCmd{Path: "utxo.dat"},
+		},
 		{
 			name: "getaddednodeinfo",
 			newCmd: func() (interface{}, error) {
@@ -150,7 +161,7 @@ func TestChainSvrCmds(t *testing.T) {
 			marshalled: `{"jsonrpc":"1.0","method":"getblock","params":["123"],"id":1}`,
 			unmarshalled: &btcjson.GetBlockCmd{
 				Hash:      "123",
-				Verbose:   btcjson.Bool(true),
+				Verbosity: btcjson.NewBlockVerbosity(btcjson.BlockVerbosityTxIDs),
 				VerboseTx: btcjson.Bool(false),
 			},
 		},
@@ -160,8 +171,8 @@ func TestChainSvrCmds(t *testing.T) {
 				// Intentionally use a source param that is
 				// more pointers than the destination to
 				// exercise that path.
-				verbosePtr := btcjson.Bool(true)
-				return btcjson.NewCmd("getblock", "123", &verbosePtr)
+				verbosityPtr := btcjson.NewBlockVerbosity(btcjson.BlockVerbosityTxIDs)
+				return btcjson.NewCmd("getblock", "123", &verbosityPtr)
 			},
 			staticCmd: func() interface{} {
 				return btcjson.NewGetBlockCmd("123", btcjson.Bool(true), nil)
@@ -169,14 +180,14 @@ func TestChainSvrCmds(t *testing.T) {
 			marshalled: `{"jsonrpc":"1.0","method":"getblock","params":["123",true],"id":1}`,
 			unmarshalled: &btcjson.GetBlockCmd{
 				Hash:      "123",
-				Verbose:   btcjson.Bool(true),
+				Verbosity: btcjson.NewBlockVerbosity(btcjson.BlockVerbosityTxIDs),
 				VerboseTx: btcjson.Bool(false),
 			},
 		},
 		{
 			name: "getblock required optional2",
 			newCmd: func() (interface{}, error) {
-				return btcjson.NewCmd("getblock", "123", true, true)
+				return btcjson.NewCmd("getblock", "123", 1, true)
 			},
 			staticCmd: func() interface{} {
 				return btcjson.NewGetBlockCmd("123", btcjson.Bool(true), btcjson.Bool(true))
@@ -184,10 +195,41 @@ func TestChainSvrCmds(t *testing.T) {
 			marshalled: `{"jsonrpc":"1.0","method":"getblock","params":["123",true,true],"id":1}`,
 			unmarshalled: &btcjson.GetBlockCmd{
 				Hash:      "123",
-				Verbose:   btcjson.Bool(true),
+				Verbosity: btcjson.NewBlockVerbosity(btcjson.BlockVerbosityTxIDs),
 				VerboseTx: btcjson.Bool(true),
 			},
 		},
+		{
+			name: "getblock verbosity hex",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getblock", "123", 0)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetBlockCmd("123", btcjson.Bool(false), nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getblock","params":["123",false],"id":1}`,
+			unmarshalled: &btcjson.GetBlockCmd{
+				Hash:      "123",
+				Verbosity: btcjson.NewBlockVerbosity(btcjson.BlockVerbosityHex),
+				VerboseTx: btcjson.Bool(false),
+			},
+		},
+		{
+			name: "getblock verbosity prevout",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getblock", "123", 3)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetBlockVerbosityCmd("123",
+					btcjson.BlockVerbosityPrevOut)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getblock","params":["123",3],"id":1}`,
+			unmarshalled: &btcjson.GetBlockCmd{
+				Hash:      "123",
+				Verbosity: btcjson.NewBlockVerbosity(btcjson.BlockVerbosityPrevOut),
+				VerboseTx: btcjson.Bool(false),
+			},
+		},
 		{
 			name: "getblockchaininfo",
 			newCmd: func() (interface{}, error) {
@@ -235,6 +277,40 @@ func TestChainSvrCmds(t *testing.T) {
 				Verbose: btcjson.Bool(true),
 			},
 		},
+		{
+			name: "getblockstats height",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getblockstats",
+					btcjson.HashOrHeight{Height: btcjson.Int32(123)})
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetBlockStatsCmd(
+					btcjson.HashOrHeight{Height: btcjson.Int32(123)}, nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getblockstats","params":[123],"id":1}`,
+			unmarshalled: &btcjson.GetBlockStatsCmd{
+				HashOrHeight: btcjson.HashOrHeight{Height: btcjson.Int32(123)},
+			},
+		},
+		{
+			name: "getblockstats hash with stats",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getblockstats",
+					btcjson.HashOrHeight{Hash: btcjson.String("123")},
+					`["minfee","taxtxs"]`)
+			},
+			staticCmd: func() interface{} {
+				stats := []string{"minfee", "taxtxs"}
+				return btcjson.NewGetBlockStatsCmd(
+					btcjson.HashOrHeight{Hash: btcjson.String("123")},
+					&stats)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getblockstats","params":["123",["minfee","taxtxs"]],"id":1}`,
+			unmarshalled: &btcjson.GetBlockStatsCmd{
+				HashOrHeight: btcjson.HashOrHeight{Hash: btcjson.String("123")},
+				Stats:        &[]string{"minfee", "taxtxs"},
+			},
+		},
 		{
 			name: "getblocktemplate",
 			newCmd: func() (interface{}, error) {
@@ -753,6 +829,34 @@ func TestChainSvrCmds(t *testing.T) {
 				BlockHash: "123",
 			},
 		},
+		{
+			name: "scantxoutset",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("scantxoutset", "status")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewScanTxOutSetCmd("status", nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"scantxoutset","params":["status"],"id":1}`,
+			unmarshalled: &btcjson.ScanTxOutSetCmd{
+				Action:      "status",
+				ScanObjects: nil,
+			},
+		},
+		{
+			name: "scantxoutset scanobjects",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("scantxoutset", "start", []string{"addr(1Address)", "raw(51)"})
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewScanTxOutSetCmd("start", &[]string{"addr(1Address)", "raw(51)"})
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"scantxoutset","params":["start",["addr(1Address)","raw(51)"]],"id":1}`,
+			unmarshalled: &btcjson.ScanTxOutSetCmd{
+				Action:      "start",
+				ScanObjects: &[]string{"addr(1Address)", "raw(51)"},
+			},
+		},
 		{
 			name: "searchrawtransactions",
 			newCmd: func() (interface{}, error) {
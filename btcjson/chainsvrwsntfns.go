@@ -224,24 +224,33 @@ func NewRescanFinishedNtfn(hash string, height int32, time int64) *RescanFinishe
 	}
 }
 
-// RescanProgressNtfn defines the rescanprogress JSON-RPC notification.
+// RescanProgressNtfn defines the rescanprogress JSON-RPC notification.  The
+// filter hits and blocks fetched are only set when the rescan uses committed
+// filters to skip blocks.
 //
 // NOTE: Deprecated. Not used with rescanblocks command.
 type RescanProgressNtfn struct {
-	Hash   string
-	Height int32
-	Time   int64
+	Hash          string
+	Height        int32
+	Time          int64
+	FilterHits    *uint32
+	BlocksFetched *uint32
 }
 
 // NewRescanProgressNtfn returns a new instance which can be used to issue a
 // rescanprogress JSON-RPC notification.
 //
+// The parameters which are pointers indicate they are optional.  Passing nil
+// for optional parameters will omit them.
+//
 // NOTE: Deprecated. Not used with rescanblocks command.
-func NewRescanProgressNtfn(hash string, height int32, time int64) *RescanProgressNtfn {
+func NewRescanProgressNtfn(hash string, height int32, time int64, filterHits, blocksFetched *uint32) *RescanProgressNtfn {
 	return &RescanProgressNtfn{
-		Hash:   hash,
-		Height: height,
-		Time:   time,
+		Hash:          hash,
+		Height:        height,
+		Time:          time,
+		FilterHits:    filterHits,
+		BlocksFetched: blocksFetched,
 	}
 }
 
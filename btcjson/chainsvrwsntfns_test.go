@@ -159,7 +159,7 @@ func TestChainSvrWsNtfns(t *testing.T) {
 				return btcjson.NewCmd("rescanprogress", "123", 100000, 12345678)
 			},
 			staticNtfn: func() interface{} {
-				return btcjson.NewRescanProgressNtfn("123", 100000, 12345678)
+				return btcjson.NewRescanProgressNtfn("123", 100000, 12345678, nil, nil)
 			},
 			marshalled: `{"jsonrpc":"1.0","method":"rescanprogress","params":["123",100000,12345678],"id":null}`,
 			unmarshalled: &btcjson.RescanProgressNtfn{
@@ -168,6 +168,24 @@ func TestChainSvrWsNtfns(t *testing.T) {
 				Time:   12345678,
 			},
 		},
+		{
+			name: "rescanprogress with filter stats",
+			newNtfn: func() (interface{}, error) {
+				return btcjson.NewCmd("rescanprogress", "123", 100000, 12345678, 5, 7)
+			},
+			staticNtfn: func() interface{} {
+				return btcjson.NewRescanProgressNtfn("123", 100000, 12345678,
+					btcjson.Uint32(5), btcjson.Uint32(7))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"rescanprogress","params":["123",100000,12345678,5,7],"id":null}`,
+			unmarshalled: &btcjson.RescanProgressNtfn{
+				Hash:          "123",
+				Height:        100000,
+				Time:          12345678,
+				FilterHits:    btcjson.Uint32(5),
+				BlocksFetched: btcjson.Uint32(7),
+			},
+		},
 		{
 			name: "txaccepted",
 			newNtfn: func() (interface{}, error) {
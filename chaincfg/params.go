@@ -5,6 +5,8 @@
 package chaincfg
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"math"
 	"math/big"
@@ -38,6 +40,11 @@ var (
 	// simNetPowLimit is the highest proof of work value a Bitcoin block
 	// can have for the simulation test network.  It is the value 2^255 - 1.
 	simNetPowLimit = new(big.Int).Sub(new(big.Int).Lsh(bigOne, 255), bigOne)
+
+	// sigNetPowLimit is the highest proof of work value a Bitcoin block
+	// can have for the signet test networks.  It is the value
+	// 0x0377ae << 216.
+	sigNetPowLimit = hexToBig("00000377ae000000000000000000000000000000000000000000000000000000")
 )
 
 // Checkpoint identifies a known good point in the block chain.  Using
@@ -52,6 +59,22 @@ type Checkpoint struct {
 	Hash   *chainhash.Hash
 }
 
+// AssumeUtxo identifies a known good utxo snapshot a node may be bootstrapped
+// from.  The hash commits to every unspent transaction output, including the
+// expiry state, as of the block it is based on.  See the documentation for
+// blockchain.LoadUtxoSnapshot for details.
+//
+// TaxSweepHeight is the highest height of the utxos swept by the tax
+// transactions of the latest block up to the base block which contains any,
+// or -1 when there is none.  It is needed to validate the tax transactions
+// of the blocks after the base block.
+type AssumeUtxo struct {
+	Height         int32
+	BlockHash      *chainhash.Hash
+	TxOutSetHash   *chainhash.Hash
+	TaxSweepHeight int32
+}
+
 // DNSSeed identifies a DNS seed.
 type DNSSeed struct {
 	// Host defines the hostname of the seed.
@@ -96,6 +119,19 @@ const (
 	// includes the deployment of BIPS 141, 142, 144, 145, 147 and 173.
 	DeploymentSegwit
 
+	// DeploymentTaxation defines the rule change deployment ID for the
+	// taxation package.  The taxation package includes the expiry of
+	// unspent outputs after the valid chain length, the tax transactions
+	// which sweep the expired outputs and the limits on the lock times of
+	// transactions spending outputs which are about to expire.
+	DeploymentTaxation
+
+	// DeploymentFullExpiry defines the rule change deployment ID for full
+	// expiry.  Once it and taxation are active, tax transactions may sweep
+	// expired coinbase outputs and the outputs which burn coins are kept
+	// in the utxo set so the burned coins are released once they expire.
+	DeploymentFullExpiry
+
 	// NOTE: DefinedDeployments must always come last since it is used to
 	// determine how many defined deployments there currently are.
 
@@ -140,6 +176,13 @@ type Params struct {
 	BIP0065Height int32
 	BIP0066Height int32
 
+	// CSVHeight and SegwitHeight define the block heights from which the
+	// CSV and segwit soft-fork packages are active on the networks where
+	// they are buried.  A zero value activates the package through its
+	// deployment instead.
+	CSVHeight    int32
+	SegwitHeight int32
+
 	// CoinbaseMaturity is the number of blocks required before newly mined
 	// coins (coinbase transactions) can be spent.
 	CoinbaseMaturity uint16
@@ -178,9 +221,34 @@ type Params struct {
 	// GenerateSupported specifies whether or not CPU mining is allowed.
 	GenerateSupported bool
 
+	// SignetChallenge is the script every block but the genesis block must
+	// provide a solution for as defined by BIP0325.  It is only set for
+	// signet networks.
+	SignetChallenge []byte
+
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints []Checkpoint
 
+	// AssumeUtxo lists the utxo snapshots which may be loaded to bootstrap
+	// a node, ordered from oldest to newest.  Bootstrapping from a utxo
+	// snapshot is unavailable on a network without any.
+	AssumeUtxo []AssumeUtxo
+
+	// MinimumChainWork is the total amount of work a header chain must
+	// have before its headers are stored in the block index during the
+	// initial headers sync.  Header chains with less work are only
+	// tracked in summary form and the peers serving them are penalized.
+	// A nil value disables the check.
+	MinimumChainWork *big.Int
+
+	// AssumeValid is the hash of the default block whose ancestors are
+	// assumed to have valid scripts once it is buried deep enough in the
+	// best known header chain and that chain has at least
+	// MinimumChainWork.  It is only useful beyond the latest checkpoint
+	// since the scripts before it are not validated anyway.  A nil value
+	// validates all scripts by default.
+	AssumeValid *chainhash.Hash
+
 	// These fields are related to voting on consensus rule changes as
 	// defined by BIP0009.
 	//
@@ -223,9 +291,23 @@ type Params struct {
 	// Valid blockchain length
 	ValidChainLength int32
 
-	// obtc hardfork start from this height
+	// TaxationBeginHeight is the height after which taxation is active on
+	// the networks where it is buried, such as the networks which activated
+	// it before DeploymentTaxation was defined.  The deployment is never
+	// started on these networks.  A zero value activates taxation through
+	// DeploymentTaxation instead.
 	TaxationBeginHeight int32
 
+	// FullExpiryBeginHeight is the height from which full expiry is active
+	// on the networks where it is buried.  Once taxation is active, tax
+	// transactions in blocks from this height may sweep expired coinbase
+	// outputs.  Outputs which burn coins by paying them to a provably
+	// unspendable script, such as OP_RETURN outputs, in blocks from this
+	// height are kept in the utxo set so the burned coins are fully
+	// released once they expire.  A zero value activates full expiry
+	// through DeploymentFullExpiry instead.
+	FullExpiryBeginHeight int32
+
 	// Taxation params
 	TaxRate                    uint16
 	TaxTxUrgentWeight          uint16
@@ -266,6 +348,7 @@ var MainNetParams = Params{
 	GenerateSupported:        false,
 	ValidChainLength:         368208, // = (7y x 365d x 24h + 2d x 24h) x 6
 	TaxationBeginHeight:      600000, // approx from 2019-10-01
+	FullExpiryBeginHeight:    0,      // activated by DeploymentFullExpiry
 	TaxTxCommonWeight:        20,     // 20% by default
 	TaxTxUrgentWeight:        50,     // 50% by default
 	TaxRate:                  30,     // 30% by default
@@ -300,6 +383,16 @@ var MainNetParams = Params{
 		{560000, newHashFromStr("0000000000000000002c7b276daf6efb2b6aa68e2ce3be67ef925b3264ae7122")},
 	},
 
+	// Utxo snapshots ordered from oldest to newest.  None are pinned yet,
+	// so nodes can't be bootstrapped from a utxo snapshot until one is.
+	AssumeUtxo: nil,
+
+	// The total work of the main chain as of block 506067.
+	MinimumChainWork: hexToBig("f91c579d57cad4bc5278cc"),
+
+	// Block 563378, after the latest checkpoint.
+	AssumeValid: newHashFromStr("0000000000000000000f1c54590ee18d15ec70e68c8cd4cfbadb1b4f11697eee"),
+
 	// Consensus rule change deployments.
 	//
 	// The miner confirmation window is defined as:
@@ -322,6 +415,16 @@ var MainNetParams = Params{
 			StartTime:  1479168000, // November 15, 2016 UTC
 			ExpireTime: 1510704000, // November 15, 2017 UTC.
 		},
+		DeploymentTaxation: {
+			BitNumber:  2,
+			StartTime:  math.MaxInt64, // Buried at TaxationBeginHeight
+			ExpireTime: math.MaxInt64, // Buried at TaxationBeginHeight
+		},
+		DeploymentFullExpiry: {
+			BitNumber:  3,
+			StartTime:  1798761600, // January 1, 2027 UTC
+			ExpireTime: 1830297600, // January 1, 2028 UTC
+		},
 	},
 
 	// Mempool parameters
@@ -373,7 +476,7 @@ var RegressionNetParams = Params{
 	MinDiffReductionTime:       time.Minute * 20, // TargetTimePerBlock * 2
 	GenerateSupported:          true,
 	ValidChainLength:           368208, // = (7y x 365d x 24h + 2d x 24h) x 6
-	TaxationBeginHeight:        600000, // approx from 2019-10-01
+	FullExpiryBeginHeight:      600000, // effective once taxation is active
 	TaxTxCommonWeight:          20,     // 20% by default
 	TaxTxUrgentWeight:          50,     // 50% by default
 	TaxRate:                    30,     // 30% by default
@@ -383,6 +486,10 @@ var RegressionNetParams = Params{
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints: nil,
 
+	// Utxo snapshots ordered from oldest to newest.  None are pinned yet,
+	// so nodes can't be bootstrapped from a utxo snapshot until one is.
+	AssumeUtxo: nil,
+
 	// Consensus rule change deployments.
 	//
 	// The miner confirmation window is defined as:
@@ -405,6 +512,16 @@ var RegressionNetParams = Params{
 			StartTime:  0,             // Always available for vote
 			ExpireTime: math.MaxInt64, // Never expires.
 		},
+		DeploymentTaxation: {
+			BitNumber:  2,
+			StartTime:  0,             // Always available for vote
+			ExpireTime: math.MaxInt64, // Never expires
+		},
+		DeploymentFullExpiry: {
+			BitNumber:  3,
+			StartTime:  math.MaxInt64, // Buried at FullExpiryBeginHeight
+			ExpireTime: math.MaxInt64, // Buried at FullExpiryBeginHeight
+		},
 	},
 
 	// Mempool parameters
@@ -460,6 +577,7 @@ var TestNet3Params = Params{
 	GenerateSupported:          false,
 	ValidChainLength:           368208, // = (7y x 365d x 24h + 2d x 24h) x 6
 	TaxationBeginHeight:        600000, // approx from 2019-10-01
+	FullExpiryBeginHeight:      0,      // activated by DeploymentFullExpiry
 	TaxTxCommonWeight:          20,     // 20% by default
 	TaxTxUrgentWeight:          50,     // 50% by default
 	TaxRate:                    30,     // 30% by default
@@ -484,6 +602,16 @@ var TestNet3Params = Params{
 		{1300007, newHashFromStr("0000000072eab69d54df75107c052b26b0395b44f77578184293bf1bb1dbd9fa")},
 	},
 
+	// Utxo snapshots ordered from oldest to newest.  None are pinned yet,
+	// so nodes can't be bootstrapped from a utxo snapshot until one is.
+	AssumeUtxo: nil,
+
+	// The total work of the test network as of early 2018.
+	MinimumChainWork: hexToBig("2830dab7f76dbb7d63"),
+
+	// Block 1354312, after the latest checkpoint.
+	AssumeValid: newHashFromStr("0000000000000037a8cd3e06cd5edbfe9dd1dbcc5dacab279376ef7cfc2b4c75"),
+
 	// Consensus rule change deployments.
 	//
 	// The miner confirmation window is defined as:
@@ -506,6 +634,16 @@ var TestNet3Params = Params{
 			StartTime:  1462060800, // May 1, 2016 UTC
 			ExpireTime: 1493596800, // May 1, 2017 UTC.
 		},
+		DeploymentTaxation: {
+			BitNumber:  2,
+			StartTime:  math.MaxInt64, // Buried at TaxationBeginHeight
+			ExpireTime: math.MaxInt64, // Buried at TaxationBeginHeight
+		},
+		DeploymentFullExpiry: {
+			BitNumber:  3,
+			StartTime:  1798761600, // January 1, 2027 UTC
+			ExpireTime: 1830297600, // January 1, 2028 UTC
+		},
 	},
 
 	// Mempool parameters
@@ -561,7 +699,7 @@ var SimNetParams = Params{
 	MinDiffReductionTime:       time.Minute * 20, // TargetTimePerBlock * 2
 	GenerateSupported:          true,
 	ValidChainLength:           368208, // = (7y x 365d x 24h + 2d x 24h) x 6
-	TaxationBeginHeight:        600000, // approx from 2019-10-01
+	FullExpiryBeginHeight:      600000, // effective once taxation is active
 	TaxTxCommonWeight:          20,     // 20% by default
 	TaxTxUrgentWeight:          50,     // 50% by default
 	TaxRate:                    30,     // 30% by default
@@ -571,6 +709,10 @@ var SimNetParams = Params{
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints: nil,
 
+	// Utxo snapshots ordered from oldest to newest.  None are pinned yet,
+	// so nodes can't be bootstrapped from a utxo snapshot until one is.
+	AssumeUtxo: nil,
+
 	// Consensus rule change deployments.
 	//
 	// The miner confirmation window is defined as:
@@ -593,6 +735,16 @@ var SimNetParams = Params{
 			StartTime:  0,             // Always available for vote
 			ExpireTime: math.MaxInt64, // Never expires.
 		},
+		DeploymentTaxation: {
+			BitNumber:  2,
+			StartTime:  0,             // Always available for vote
+			ExpireTime: math.MaxInt64, // Never expires
+		},
+		DeploymentFullExpiry: {
+			BitNumber:  3,
+			StartTime:  math.MaxInt64, // Buried at FullExpiryBeginHeight
+			ExpireTime: math.MaxInt64, // Buried at FullExpiryBeginHeight
+		},
 	},
 
 	// Mempool parameters
@@ -618,6 +770,127 @@ var SimNetParams = Params{
 	HDCoinType: 115, // ASCII for s
 }
 
+// SigNetParams returns the network parameters for the signet test Bitcoin
+// network defined by the passed challenge script as defined by BIP0325.  Blocks
+// on a signet are only valid when they carry a solution to its challenge, so
+// the network is only extended by those who can sign for it, which keeps the
+// block production steady unlike the normal test network.
+//
+// Every signet shares the same genesis block and its network magic is derived
+// from the challenge, so nodes using different challenges never connect to each
+// other.  Taxation and the full expiry of outputs are enabled early and outputs
+// expire after about a month in order for them to be exercised publicly.
+func SigNetParams(challenge []byte) Params {
+	return Params{
+		Name:        "signet",
+		Net:         SigNetMagic(challenge),
+		DefaultPort: "38333",
+		DNSSeeds:    []DNSSeed{},
+
+		// Chain parameters
+		GenesisBlock:               &sigNetGenesisBlock,
+		GenesisHash:                &sigNetGenesisHash,
+		PowLimit:                   sigNetPowLimit,
+		PowLimitBits:               0x1e0377ae,
+		BIP0034Height:              1,
+		BIP0065Height:              1,
+		BIP0066Height:              1,
+		CSVHeight:                  1,
+		SegwitHeight:               1,
+		CoinbaseMaturity:           100,
+		SubsidyReductionInterval:   210000,
+		TargetTimespan:             time.Hour * 24 * 14, // 14 days
+		TargetTimePerBlock:         time.Minute * 10,    // 10 minutes
+		RetargetAdjustmentFactor:   4,                   // 25% less, 400% more
+		ReduceMinDifficulty:        false,
+		MinDiffReductionTime:       time.Minute * 20, // TargetTimePerBlock * 2
+		GenerateSupported:          true,
+		SignetChallenge:            challenge,
+		ValidChainLength:           4320,  // = 30d x 24h x 6
+		TaxationBeginHeight:        1000,  // early to test taxation publicly
+		FullExpiryBeginHeight:      1000,  // effective once taxation is active
+		TaxTxCommonWeight:          20,    // 20% by default
+		TaxTxUrgentWeight:          50,    // 50% by default
+		TaxRate:                    30,    // 30% by default
+		DustSatoshiAmount:          54600, // 10x dust definition
+		UrgentExpiredUtxoThreshold: 100,   // in block length
+
+		// Checkpoints ordered from oldest to newest.
+		Checkpoints: nil,
+
+		// Utxo snapshots ordered from oldest to newest.  None are pinned
+		// yet, so nodes can't be bootstrapped from a utxo snapshot until
+		// one is.
+		AssumeUtxo: nil,
+
+		// Consensus rule change deployments.
+		//
+		// The miner confirmation window is defined as:
+		//   target proof of work timespan / target proof of work spacing
+		RuleChangeActivationThreshold: 1512, // 75% of MinerConfirmationWindow
+		MinerConfirmationWindow:       2016,
+		Deployments: [DefinedDeployments]ConsensusDeployment{
+			DeploymentTestDummy: {
+				BitNumber:  28,
+				StartTime:  0,             // Always available for vote
+				ExpireTime: math.MaxInt64, // Never expires
+			},
+			DeploymentCSV: {
+				BitNumber:  0,
+				StartTime:  math.MaxInt64, // Buried at CSVHeight
+				ExpireTime: math.MaxInt64, // Buried at CSVHeight
+			},
+			DeploymentSegwit: {
+				BitNumber:  1,
+				StartTime:  math.MaxInt64, // Buried at SegwitHeight
+				ExpireTime: math.MaxInt64, // Buried at SegwitHeight
+			},
+			DeploymentTaxation: {
+				BitNumber:  2,
+				StartTime:  math.MaxInt64, // Buried at TaxationBeginHeight
+				ExpireTime: math.MaxInt64, // Buried at TaxationBeginHeight
+			},
+			DeploymentFullExpiry: {
+				BitNumber:  3,
+				StartTime:  math.MaxInt64, // Buried at FullExpiryBeginHeight
+				ExpireTime: math.MaxInt64, // Buried at FullExpiryBeginHeight
+			},
+		},
+
+		// Mempool parameters
+		RelayNonStdTxs: true,
+
+		// Human-readable part for Bech32 encoded segwit addresses, as
+		// defined in BIP 173.
+		Bech32HRPSegwit: "tb", // always tb for test net
+
+		// Address encoding magics
+		PubKeyHashAddrID:        0x6f, // starts with m or n
+		ScriptHashAddrID:        0xc4, // starts with 2
+		WitnessPubKeyHashAddrID: 0x03, // starts with QW
+		WitnessScriptHashAddrID: 0x28, // starts with T7n
+		PrivateKeyID:            0xef, // starts with 9 (uncompressed) or c (compressed)
+
+		// BIP32 hierarchical deterministic extended key magics
+		HDPrivateKeyID: [4]byte{0x04, 0x35, 0x83, 0x94}, // starts with tprv
+		HDPublicKeyID:  [4]byte{0x04, 0x35, 0x87, 0xcf}, // starts with tpub
+
+		// BIP44 coin type used in the hierarchical deterministic path for
+		// address generation.
+		HDCoinType: 1,
+	}
+}
+
+// SigNetMagic returns the network magic of the signet defined by the passed
+// challenge script.  As defined by BIP0325, it is the first four bytes of the
+// double sha256 hash of the challenge serialized with its length prefix.
+func SigNetMagic(challenge []byte) wire.BitcoinNet {
+	var buf bytes.Buffer
+	_ = wire.WriteVarBytes(&buf, 0, challenge)
+	hash := chainhash.DoubleHashB(buf.Bytes())
+	return wire.BitcoinNet(binary.LittleEndian.Uint32(hash[:4]))
+}
+
 var (
 	// ErrDuplicateNet describes an error where the parameters for a Bitcoin
 	// network could not be set due to the network already being a standard
@@ -743,6 +1016,17 @@ func newHashFromStr(hexStr string) *chainhash.Hash {
 	return hash
 }
 
+// hexToBig converts the passed big-endian hex string into a big integer.  It
+// panics on an error since it will only (and must only) be called with
+// hard-coded, and therefore known good, values.
+func hexToBig(hexStr string) *big.Int {
+	n, ok := new(big.Int).SetString(hexStr, 16)
+	if !ok {
+		panic("invalid hex in source file: " + hexStr)
+	}
+	return n
+}
+
 func init() {
 	// Register all default networks when the package is initialized.
 	mustRegister(&MainNetParams)
@@ -4,7 +4,11 @@
 
 package chaincfg
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/organicbitcoin/obtcd/wire"
+)
 
 // TestInvalidHashStr ensures the newShaHashFromStr function panics when used to
 // with an invalid hash string.
@@ -33,3 +37,25 @@ func TestMustRegisterPanic(t *testing.T) {
 	// Intentionally try to register duplicate params to force a panic.
 	mustRegister(&MainNetParams)
 }
+
+// TestSigNetMagic ensures the network magic of a signet is derived from its
+// challenge script as defined by BIP0325.
+func TestSigNetMagic(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		challenge []byte
+		want      wire.BitcoinNet
+	}{
+		{[]byte{}, 0x58e00614},
+		{[]byte{0x51}, 0xbd6fd254},
+	}
+
+	for i, test := range tests {
+		params := SigNetParams(test.challenge)
+		if params.Net != test.want {
+			t.Errorf("SigNetParams #%d: unexpected magic - got %v, "+
+				"want %v", i, params.Net, test.want)
+		}
+	}
+}
@@ -0,0 +1,481 @@
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/organicbitcoin/obtcd/blockchain"
+	"github.com/organicbitcoin/obtcd/chaincfg"
+	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
+	"github.com/organicbitcoin/obtcd/wire"
+)
+
+// deploymentNames maps the names used for the consensus rule change
+// deployments in chain parameter files to the deployments.
+var deploymentNames = map[string]int{
+	"testdummy":  chaincfg.DeploymentTestDummy,
+	"csv":        chaincfg.DeploymentCSV,
+	"segwit":     chaincfg.DeploymentSegwit,
+	"taxation":   chaincfg.DeploymentTaxation,
+	"fullexpiry": chaincfg.DeploymentFullExpiry,
+}
+
+// reservedNetNames are the names of the standard networks, which custom
+// networks must not use since the name selects the data directory.
+var reservedNetNames = map[string]struct{}{
+	"mainnet":  {},
+	"testnet":  {},
+	"testnet3": {},
+	"regtest":  {},
+	"simnet":   {},
+	"signet":   {},
+}
+
+// paramsDuration is a duration in a chain parameter file.  It is given as a
+// string such as "10m" or "336h".
+type paramsDuration time.Duration
+
+// UnmarshalJSON parses the duration from a JSON string.
+func (d *paramsDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = paramsDuration(duration)
+	return nil
+}
+
+// paramsHex is a byte slice in a chain parameter file.  It is given as a hex
+// encoded string.
+type paramsHex []byte
+
+// UnmarshalJSON parses the bytes from a hex encoded JSON string.
+func (h *paramsHex) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	*h = b
+	return nil
+}
+
+// paramsHash is a hash in a chain parameter file.  It is given as a byte-reversed
+// hex encoded string like everywhere else.
+type paramsHash chainhash.Hash
+
+// UnmarshalJSON parses the hash from a hex encoded JSON string.
+func (h *paramsHash) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	hash, err := chainhash.NewHashFromStr(s)
+	if err != nil {
+		return err
+	}
+	*h = paramsHash(*hash)
+	return nil
+}
+
+// chainParamsFile describes the contents of a chain parameter file.  The fields
+// mirror the ones of chaincfg.Params.
+type chainParamsFile struct {
+	Name        string `json:"name"`
+	Net         uint32 `json:"net"`
+	DefaultPort string `json:"default_port"`
+	RPCPort     string `json:"rpc_port"`
+	DNSSeeds    []struct {
+		Host         string `json:"host"`
+		HasFiltering bool   `json:"has_filtering"`
+	} `json:"dns_seeds"`
+
+	// The genesis block uses the coinbase transaction of the main network
+	// unless another one is given.
+	Genesis struct {
+		Version    int32       `json:"version"`
+		Timestamp  int64       `json:"timestamp"`
+		Bits       uint32      `json:"bits"`
+		Nonce      uint32      `json:"nonce"`
+		CoinbaseTx paramsHex   `json:"coinbase_tx"`
+		Hash       *paramsHash `json:"hash"`
+	} `json:"genesis"`
+
+	PowLimit                 paramsHex      `json:"pow_limit"`
+	PowLimitBits             uint32         `json:"pow_limit_bits"`
+	BIP0034Height            int32          `json:"bip0034_height"`
+	BIP0065Height            int32          `json:"bip0065_height"`
+	BIP0066Height            int32          `json:"bip0066_height"`
+	CoinbaseMaturity         uint16         `json:"coinbase_maturity"`
+	SubsidyReductionInterval int32          `json:"subsidy_reduction_interval"`
+	TargetTimespan           paramsDuration `json:"target_timespan"`
+	TargetTimePerBlock       paramsDuration `json:"target_time_per_block"`
+	RetargetAdjustmentFactor int64          `json:"retarget_adjustment_factor"`
+	ReduceMinDifficulty      bool           `json:"reduce_min_difficulty"`
+	MinDiffReductionTime     paramsDuration `json:"min_diff_reduction_time"`
+	GenerateSupported        bool           `json:"generate_supported"`
+	SignetChallenge          paramsHex      `json:"signet_challenge"`
+
+	Checkpoints []struct {
+		Height int32      `json:"height"`
+		Hash   paramsHash `json:"hash"`
+	} `json:"checkpoints"`
+	AssumeUtxo []struct {
+		Height         int32      `json:"height"`
+		BlockHash      paramsHash `json:"block_hash"`
+		TxOutSetHash   paramsHash `json:"txoutset_hash"`
+		TaxSweepHeight int32      `json:"tax_sweep_height"`
+	} `json:"assume_utxo"`
+	MinimumChainWork paramsHex   `json:"minimum_chain_work"`
+	AssumeValid      *paramsHash `json:"assume_valid"`
+
+	RuleChangeActivationThreshold uint32 `json:"rule_change_activation_threshold"`
+	MinerConfirmationWindow       uint32 `json:"miner_confirmation_window"`
+	Deployments                   map[string]struct {
+		BitNumber  uint8  `json:"bit_number"`
+		StartTime  uint64 `json:"start_time"`
+		ExpireTime uint64 `json:"expire_time"`
+	} `json:"deployments"`
+
+	RelayNonStdTxs bool `json:"relay_non_std_txs"`
+
+	Bech32HRPSegwit         string    `json:"bech32_hrp_segwit"`
+	PubKeyHashAddrID        byte      `json:"pubkey_hash_addr_id"`
+	ScriptHashAddrID        byte      `json:"script_hash_addr_id"`
+	PrivateKeyID            byte      `json:"private_key_id"`
+	WitnessPubKeyHashAddrID byte      `json:"witness_pubkey_hash_addr_id"`
+	WitnessScriptHashAddrID byte      `json:"witness_script_hash_addr_id"`
+	HDPrivateKeyID          paramsHex `json:"hd_private_key_id"`
+	HDPublicKeyID           paramsHex `json:"hd_public_key_id"`
+	HDCoinType              uint32    `json:"hd_coin_type"`
+
+	ValidChainLength           int32  `json:"valid_chain_length"`
+	TaxationBeginHeight        int32  `json:"taxation_begin_height"`
+	FullExpiryBeginHeight      int32  `json:"full_expiry_begin_height"`
+	TaxRate                    uint16 `json:"tax_rate"`
+	TaxTxUrgentWeight          uint16 `json:"tax_tx_urgent_weight"`
+	TaxTxCommonWeight          uint16 `json:"tax_tx_common_weight"`
+	DustSatoshiAmount          uint16 `json:"dust_satoshi_amount"`
+	UrgentExpiredUtxoThreshold uint16 `json:"urgent_expired_utxo_threshold"`
+}
+
+// validatePort returns an error when the passed string is not a valid port.
+func validatePort(port string) error {
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || n == 0 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// loadChainParams loads the network parameters defined by the passed chain
+// parameter file.  The file is decoded as TOML or JSON depending on its
+// extension and every field of the network parameters must be given, except
+// the optional ones which are empty for the standard test networks.  The
+// parameters are validated, but not registered.
+func loadChainParams(path string) (*params, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert TOML files to JSON so both formats are decoded the same way.
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+	case ".toml":
+		var tree map[string]interface{}
+		if _, err := toml.Decode(string(data), &tree); err != nil {
+			return nil, err
+		}
+		data, err = json.Marshal(tree)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported chain parameter file "+
+			"format %q -- use .toml or .json", filepath.Ext(path))
+	}
+
+	var file chainParamsFile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, err
+	}
+	return file.params()
+}
+
+// params converts the chain parameter file to validated network parameters.
+func (f *chainParamsFile) params() (*params, error) {
+	// Validate the network identity.
+	if f.Name == "" || f.Name != filepath.Base(f.Name) || f.Name == "." ||
+		f.Name == ".." {
+
+		return nil, fmt.Errorf("invalid network name %q", f.Name)
+	}
+	if _, ok := reservedNetNames[f.Name]; ok {
+		return nil, fmt.Errorf("network name %q is used by a standard "+
+			"network", f.Name)
+	}
+	if f.Net == 0 {
+		return nil, fmt.Errorf("network magic must not be zero")
+	}
+	if err := validatePort(f.DefaultPort); err != nil {
+		return nil, fmt.Errorf("default_port: %v", err)
+	}
+	if err := validatePort(f.RPCPort); err != nil {
+		return nil, fmt.Errorf("rpc_port: %v", err)
+	}
+
+	// Validate the proof of work parameters.  The limit defaults to the
+	// one its compact representation encodes, but may be given exactly.
+	powLimit := blockchain.CompactToBig(f.PowLimitBits)
+	if powLimit.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid pow_limit_bits %08x",
+			f.PowLimitBits)
+	}
+	if f.PowLimit != nil {
+		exact := new(big.Int).SetBytes(f.PowLimit)
+		if exact.Cmp(powLimit) < 0 {
+			return nil, fmt.Errorf("pow_limit is below the limit " +
+				"pow_limit_bits encodes")
+		}
+		powLimit = exact
+	}
+	genesisTarget := blockchain.CompactToBig(f.Genesis.Bits)
+	if genesisTarget.Sign() <= 0 || genesisTarget.Cmp(powLimit) > 0 {
+
+		return nil, fmt.Errorf("genesis bits %08x are not within the "+
+			"proof of work limit", f.Genesis.Bits)
+	}
+	if f.TargetTimePerBlock <= 0 || f.TargetTimespan < f.TargetTimePerBlock {
+		return nil, fmt.Errorf("target_timespan must be at least " +
+			"target_time_per_block, which must be positive")
+	}
+	if f.RetargetAdjustmentFactor < 1 {
+		return nil, fmt.Errorf("retarget_adjustment_factor must be " +
+			"positive")
+	}
+	if f.ReduceMinDifficulty && f.MinDiffReductionTime <= 0 {
+		return nil, fmt.Errorf("min_diff_reduction_time must be " +
+			"positive when reduce_min_difficulty is set")
+	}
+	if f.SubsidyReductionInterval <= 0 {
+		return nil, fmt.Errorf("subsidy_reduction_interval must be " +
+			"positive")
+	}
+
+	// Validate the expiry and taxation parameters.
+	if f.ValidChainLength <= 0 {
+		return nil, fmt.Errorf("valid_chain_length must be positive")
+	}
+	if f.TaxationBeginHeight < 0 || f.FullExpiryBeginHeight < 0 {
+		return nil, fmt.Errorf("taxation_begin_height and " +
+			"full_expiry_begin_height must not be negative")
+	}
+	if f.TaxRate > 100 || f.TaxTxUrgentWeight > 100 ||
+		f.TaxTxCommonWeight > 100 {
+
+		return nil, fmt.Errorf("tax_rate, tax_tx_urgent_weight and " +
+			"tax_tx_common_weight are percentages and must not " +
+			"exceed 100")
+	}
+
+	// Validate the rule change deployments.
+	if f.MinerConfirmationWindow == 0 ||
+		f.RuleChangeActivationThreshold == 0 ||
+		f.RuleChangeActivationThreshold > f.MinerConfirmationWindow {
+
+		return nil, fmt.Errorf("rule_change_activation_threshold must " +
+			"be positive and not exceed miner_confirmation_window")
+	}
+	var deployments [chaincfg.DefinedDeployments]chaincfg.ConsensusDeployment
+	usedBits := make(map[uint8]string)
+	for name, deployment := range f.Deployments {
+		id, ok := deploymentNames[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown deployment %q", name)
+		}
+		if deployment.BitNumber >= 29 {
+			return nil, fmt.Errorf("deployment %q: bit_number must "+
+				"be below 29", name)
+		}
+		if other, ok := usedBits[deployment.BitNumber]; ok {
+			return nil, fmt.Errorf("deployments %q and %q use the "+
+				"same bit", name, other)
+		}
+		if deployment.StartTime > deployment.ExpireTime {
+			return nil, fmt.Errorf("deployment %q: start_time is "+
+				"after expire_time", name)
+		}
+		usedBits[deployment.BitNumber] = name
+		deployments[id] = chaincfg.ConsensusDeployment{
+			BitNumber:  deployment.BitNumber,
+			StartTime:  deployment.StartTime,
+			ExpireTime: deployment.ExpireTime,
+		}
+	}
+	for name := range deploymentNames {
+		if _, ok := f.Deployments[name]; !ok {
+			return nil, fmt.Errorf("missing deployment %q", name)
+		}
+	}
+
+	// Validate the address encoding magics.
+	if f.Bech32HRPSegwit == "" {
+		return nil, fmt.Errorf("bech32_hrp_segwit must not be empty")
+	}
+	if len(f.HDPrivateKeyID) != 4 || len(f.HDPublicKeyID) != 4 {
+		return nil, fmt.Errorf("hd_private_key_id and hd_public_key_id " +
+			"must be 4 bytes")
+	}
+
+	// Create the genesis block.
+	coinbaseTx := chaincfg.MainNetParams.GenesisBlock.Transactions[0]
+	if f.Genesis.CoinbaseTx != nil {
+		coinbaseTx = new(wire.MsgTx)
+		err := coinbaseTx.Deserialize(bytes.NewReader(f.Genesis.CoinbaseTx))
+		if err != nil {
+			return nil, fmt.Errorf("invalid genesis coinbase_tx: %v",
+				err)
+		}
+	}
+	genesisBlock := &wire.MsgBlock{
+		Header: wire.BlockHeader{
+			Version:    f.Genesis.Version,
+			MerkleRoot: coinbaseTx.TxHash(),
+			Timestamp:  time.Unix(f.Genesis.Timestamp, 0),
+			Bits:       f.Genesis.Bits,
+			Nonce:      f.Genesis.Nonce,
+		},
+		Transactions: []*wire.MsgTx{coinbaseTx},
+	}
+	genesisHash := genesisBlock.BlockHash()
+	if f.Genesis.Hash != nil && chainhash.Hash(*f.Genesis.Hash) != genesisHash {
+		return nil, fmt.Errorf("genesis hash %v does not match the "+
+			"genesis block hash %v", chainhash.Hash(*f.Genesis.Hash),
+			genesisHash)
+	}
+
+	// Convert the checkpoints and utxo snapshots, which must be ordered
+	// from oldest to newest.
+	var checkpoints []chaincfg.Checkpoint
+	for i, checkpoint := range f.Checkpoints {
+		if checkpoint.Height <= 0 || i > 0 &&
+			checkpoint.Height <= f.Checkpoints[i-1].Height {
+
+			return nil, fmt.Errorf("checkpoints must have positive " +
+				"heights ordered from oldest to newest")
+		}
+		hash := chainhash.Hash(checkpoint.Hash)
+		checkpoints = append(checkpoints, chaincfg.Checkpoint{
+			Height: checkpoint.Height,
+			Hash:   &hash,
+		})
+	}
+	var assumeUtxo []chaincfg.AssumeUtxo
+	for i, snapshot := range f.AssumeUtxo {
+		if snapshot.Height <= 0 || i > 0 &&
+			snapshot.Height <= f.AssumeUtxo[i-1].Height {
+
+			return nil, fmt.Errorf("assume_utxo snapshots must have " +
+				"positive heights ordered from oldest to newest")
+		}
+		blockHash := chainhash.Hash(snapshot.BlockHash)
+		txOutSetHash := chainhash.Hash(snapshot.TxOutSetHash)
+		assumeUtxo = append(assumeUtxo, chaincfg.AssumeUtxo{
+			Height:         snapshot.Height,
+			BlockHash:      &blockHash,
+			TxOutSetHash:   &txOutSetHash,
+			TaxSweepHeight: snapshot.TaxSweepHeight,
+		})
+	}
+	var minimumChainWork *big.Int
+	if f.MinimumChainWork != nil {
+		minimumChainWork = new(big.Int).SetBytes(f.MinimumChainWork)
+	}
+
+	var assumeValid *chainhash.Hash
+	if f.AssumeValid != nil {
+		hash := chainhash.Hash(*f.AssumeValid)
+		assumeValid = &hash
+	}
+
+	dnsSeeds := make([]chaincfg.DNSSeed, 0, len(f.DNSSeeds))
+	for _, seed := range f.DNSSeeds {
+		dnsSeeds = append(dnsSeeds, chaincfg.DNSSeed{
+			Host:         seed.Host,
+			HasFiltering: seed.HasFiltering,
+		})
+	}
+
+	chainParams := &chaincfg.Params{
+		Name:                          f.Name,
+		Net:                           wire.BitcoinNet(f.Net),
+		DefaultPort:                   f.DefaultPort,
+		DNSSeeds:                      dnsSeeds,
+		GenesisBlock:                  genesisBlock,
+		GenesisHash:                   &genesisHash,
+		PowLimit:                      powLimit,
+		PowLimitBits:                  f.PowLimitBits,
+		BIP0034Height:                 f.BIP0034Height,
+		BIP0065Height:                 f.BIP0065Height,
+		BIP0066Height:                 f.BIP0066Height,
+		CoinbaseMaturity:              f.CoinbaseMaturity,
+		SubsidyReductionInterval:      f.SubsidyReductionInterval,
+		TargetTimespan:                time.Duration(f.TargetTimespan),
+		TargetTimePerBlock:            time.Duration(f.TargetTimePerBlock),
+		RetargetAdjustmentFactor:      f.RetargetAdjustmentFactor,
+		ReduceMinDifficulty:           f.ReduceMinDifficulty,
+		MinDiffReductionTime:          time.Duration(f.MinDiffReductionTime),
+		GenerateSupported:             f.GenerateSupported,
+		SignetChallenge:               f.SignetChallenge,
+		Checkpoints:                   checkpoints,
+		AssumeUtxo:                    assumeUtxo,
+		MinimumChainWork:              minimumChainWork,
+		AssumeValid:                   assumeValid,
+		RuleChangeActivationThreshold: f.RuleChangeActivationThreshold,
+		MinerConfirmationWindow:       f.MinerConfirmationWindow,
+		Deployments:                   deployments,
+		RelayNonStdTxs:                f.RelayNonStdTxs,
+		Bech32HRPSegwit:               f.Bech32HRPSegwit,
+		PubKeyHashAddrID:              f.PubKeyHashAddrID,
+		ScriptHashAddrID:              f.ScriptHashAddrID,
+		PrivateKeyID:                  f.PrivateKeyID,
+		WitnessPubKeyHashAddrID:       f.WitnessPubKeyHashAddrID,
+		WitnessScriptHashAddrID:       f.WitnessScriptHashAddrID,
+		HDCoinType:                    f.HDCoinType,
+		ValidChainLength:              f.ValidChainLength,
+		TaxationBeginHeight:           f.TaxationBeginHeight,
+		FullExpiryBeginHeight:         f.FullExpiryBeginHeight,
+		TaxRate:                       f.TaxRate,
+		TaxTxUrgentWeight:             f.TaxTxUrgentWeight,
+		TaxTxCommonWeight:             f.TaxTxCommonWeight,
+		DustSatoshiAmount:             f.DustSatoshiAmount,
+		UrgentExpiredUtxoThreshold:    f.UrgentExpiredUtxoThreshold,
+	}
+	copy(chainParams.HDPrivateKeyID[:], f.HDPrivateKeyID)
+	copy(chainParams.HDPublicKeyID[:], f.HDPublicKeyID)
+
+	return &params{Params: chainParams, rpcPort: f.RPCPort}, nil
+}
@@ -0,0 +1,329 @@
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/organicbitcoin/obtcd/chaincfg"
+	"github.com/organicbitcoin/obtcd/wire"
+)
+
+// customNetTOML defines the parameters of the regression test network under
+// another name and network magic.
+const customNetTOML = `
+# A copy of the regression test network.
+name = "customnet"
+net = 305419896
+default_port = "18544"
+rpc_port = '18534'
+dns_seeds = []
+
+pow_limit = "7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"
+pow_limit_bits = 545259519
+bip0034_height = 100_000_000
+bip0065_height = 1351
+bip0066_height = 1251
+coinbase_maturity = 100
+subsidy_reduction_interval = 150
+target_timespan = "336h"
+target_time_per_block = "10m"
+retarget_adjustment_factor = 4
+reduce_min_difficulty = true
+min_diff_reduction_time = "20m"
+generate_supported = true
+
+rule_change_activation_threshold = 108
+miner_confirmation_window = 144
+assume_valid = "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206"
+
+relay_non_std_txs = true
+bech32_hrp_segwit = "bcrt"
+pubkey_hash_addr_id = 111
+script_hash_addr_id = 196
+private_key_id = 239
+witness_pubkey_hash_addr_id = 0
+witness_script_hash_addr_id = 0
+hd_private_key_id = "04358394"
+hd_public_key_id = "043587cf"
+hd_coin_type = 1
+
+valid_chain_length = 368208
+taxation_begin_height = 0
+full_expiry_begin_height = 600000
+tax_rate = 30
+tax_tx_urgent_weight = 50
+tax_tx_common_weight = 20
+dust_satoshi_amount = 54600
+urgent_expired_utxo_threshold = 100
+
+[genesis]
+version = 1
+timestamp = 1296688602
+bits = 545259519
+nonce = 2
+hash = "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206"
+
+[deployments]
+testdummy = { bit_number = 28, start_time = 0, expire_time = 9223372036854775807 }
+csv = { bit_number = 0, start_time = 0, expire_time = 9223372036854775807 }
+
+[deployments.segwit]
+bit_number = 1
+start_time = 0
+expire_time = 9223372036854775807
+
+[deployments.taxation]
+bit_number = 2
+start_time = 0
+expire_time = 9223372036854775807
+
+[deployments.fullexpiry]
+bit_number = 3
+start_time = 9223372036854775807
+expire_time = 9223372036854775807
+`
+
+// customNetJSON defines the same network as customNetTOML.
+const customNetJSON = `{
+	"name": "customnet",
+	"net": 305419896,
+	"default_port": "18544",
+	"rpc_port": "18534",
+	"dns_seeds": [],
+	"pow_limit": "7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+	"pow_limit_bits": 545259519,
+	"bip0034_height": 100000000,
+	"bip0065_height": 1351,
+	"bip0066_height": 1251,
+	"coinbase_maturity": 100,
+	"subsidy_reduction_interval": 150,
+	"target_timespan": "336h",
+	"target_time_per_block": "10m",
+	"retarget_adjustment_factor": 4,
+	"reduce_min_difficulty": true,
+	"min_diff_reduction_time": "20m",
+	"generate_supported": true,
+	"rule_change_activation_threshold": 108,
+	"miner_confirmation_window": 144,
+	"assume_valid": "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206",
+	"relay_non_std_txs": true,
+	"bech32_hrp_segwit": "bcrt",
+	"pubkey_hash_addr_id": 111,
+	"script_hash_addr_id": 196,
+	"private_key_id": 239,
+	"witness_pubkey_hash_addr_id": 0,
+	"witness_script_hash_addr_id": 0,
+	"hd_private_key_id": "04358394",
+	"hd_public_key_id": "043587cf",
+	"hd_coin_type": 1,
+	"valid_chain_length": 368208,
+	"taxation_begin_height": 0,
+	"full_expiry_begin_height": 600000,
+	"tax_rate": 30,
+	"tax_tx_urgent_weight": 50,
+	"tax_tx_common_weight": 20,
+	"dust_satoshi_amount": 54600,
+	"urgent_expired_utxo_threshold": 100,
+	"genesis": {
+		"version": 1,
+		"timestamp": 1296688602,
+		"bits": 545259519,
+		"nonce": 2,
+		"hash": "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206"
+	},
+	"deployments": {
+		"testdummy": {"bit_number": 28, "start_time": 0, "expire_time": 9223372036854775807},
+		"csv": {"bit_number": 0, "start_time": 0, "expire_time": 9223372036854775807},
+		"segwit": {"bit_number": 1, "start_time": 0, "expire_time": 9223372036854775807},
+		"taxation": {"bit_number": 2, "start_time": 0, "expire_time": 9223372036854775807},
+		"fullexpiry": {"bit_number": 3, "start_time": 9223372036854775807, "expire_time": 9223372036854775807}
+	}
+}`
+
+// writeChainParams writes the passed chain parameter file to a temporary
+// directory and returns its path.
+func writeChainParams(t *testing.T, dir, name, contents string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("WriteFile: unexpected error: %v", err)
+	}
+	return path
+}
+
+// TestLoadChainParams ensures chain parameter files in both formats define the
+// expected network parameters.
+func TestLoadChainParams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chainparams")
+	if err != nil {
+		t.Fatalf("TempDir: unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	want := chaincfg.RegressionNetParams
+	want.Name = "customnet"
+	want.Net = wire.BitcoinNet(0x12345678)
+	want.DefaultPort = "18544"
+	want.AssumeValid = want.GenesisHash
+
+	for _, path := range []string{
+		writeChainParams(t, dir, "customnet.toml", customNetTOML),
+		writeChainParams(t, dir, "customnet.json", customNetJSON),
+	} {
+		got, err := loadChainParams(path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", path, err)
+			continue
+		}
+		if got.rpcPort != "18534" {
+			t.Errorf("%s: unexpected rpc port %q", path, got.rpcPort)
+		}
+		if !reflect.DeepEqual(got.Params, &want) {
+			t.Errorf("%s: mismatched params - got %+v, want %+v",
+				path, got.Params, &want)
+		}
+	}
+}
+
+// TestLoadChainParamsErrors ensures invalid chain parameter files are
+// rejected.
+func TestLoadChainParamsErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chainparams")
+	if err != nil {
+		t.Fatalf("TempDir: unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name   string
+		old    string // text of customNetJSON to replace
+		new    string // replacement text
+		errStr string // part of the expected error
+	}{{
+		name:   "reserved name",
+		old:    `"name": "customnet"`,
+		new:    `"name": "regtest"`,
+		errStr: "standard network",
+	}, {
+		name:   "path in name",
+		old:    `"name": "customnet"`,
+		new:    `"name": "../customnet"`,
+		errStr: "invalid network name",
+	}, {
+		name:   "invalid port",
+		old:    `"default_port": "18544"`,
+		new:    `"default_port": "port"`,
+		errStr: "default_port",
+	}, {
+		name:   "unknown field",
+		old:    `"hd_coin_type": 1,`,
+		new:    `"hd_coin_type": 1, "hd_coin": 1,`,
+		errStr: "unknown field",
+	}, {
+		name:   "genesis hash mismatch",
+		old:    `"nonce": 2`,
+		new:    `"nonce": 3`,
+		errStr: "does not match",
+	}, {
+		name:   "genesis above pow limit",
+		old:    `"bits": 545259519`,
+		new:    `"bits": 562036735`,
+		errStr: "proof of work limit",
+	}, {
+		name:   "negative pow limit",
+		old:    `"pow_limit_bits": 545259519`,
+		new:    `"pow_limit_bits": 545259521`,
+		errStr: "invalid pow_limit_bits",
+	}, {
+		name:   "threshold above window",
+		old:    `"rule_change_activation_threshold": 108`,
+		new:    `"rule_change_activation_threshold": 145`,
+		errStr: "miner_confirmation_window",
+	}, {
+		name:   "unknown deployment",
+		old:    `"segwit": {`,
+		new:    `"unused": {`,
+		errStr: "unknown deployment",
+	}, {
+		name:   "duplicate deployment bit",
+		old:    `"bit_number": 1,`,
+		new:    `"bit_number": 0,`,
+		errStr: "same bit",
+	}, {
+		name:   "negative taxation height",
+		old:    `"taxation_begin_height": 0`,
+		new:    `"taxation_begin_height": -1`,
+		errStr: "must not be negative",
+	}, {
+		name:   "excessive tax rate",
+		old:    `"tax_rate": 30`,
+		new:    `"tax_rate": 101`,
+		errStr: "must not exceed 100",
+	}, {
+		name:   "short hd key id",
+		old:    `"hd_public_key_id": "043587cf"`,
+		new:    `"hd_public_key_id": "0435"`,
+		errStr: "4 bytes",
+	}, {
+		name:   "invalid json",
+		old:    `"rpc_port": "18534",`,
+		new:    `"rpc_port": "18534"`,
+		errStr: "invalid character",
+	}}
+
+	for _, test := range tests {
+		contents := strings.Replace(customNetJSON, test.old, test.new, 1)
+		if contents == customNetJSON {
+			t.Fatalf("%s: %q not found", test.name, test.old)
+		}
+		path := writeChainParams(t, dir, "customnet.json", contents)
+		_, err := loadChainParams(path)
+		if err == nil || !strings.Contains(err.Error(), test.errStr) {
+			t.Errorf("%s: unexpected error - got %v, want %q",
+				test.name, err, test.errStr)
+		}
+	}
+
+	// Ensure TOML files are decoded with the same checks and malformed ones
+	// are rejected.
+	for _, test := range []struct {
+		name   string
+		old    string // text of customNetTOML to replace
+		new    string // replacement text
+		errStr string // part of the expected error
+	}{{
+		name:   "unknown toml field",
+		old:    "hd_coin_type = 1",
+		new:    "hd_coin_type = 1\nhd_coin = 1",
+		errStr: "unknown field",
+	}, {
+		name:   "invalid toml",
+		old:    `rpc_port = '18534'`,
+		new:    `rpc_port = '18534`,
+		errStr: "rpc_port",
+	}} {
+		contents := strings.Replace(customNetTOML, test.old, test.new, 1)
+		if contents == customNetTOML {
+			t.Fatalf("%s: %q not found", test.name, test.old)
+		}
+		path := writeChainParams(t, dir, "customnet.toml", contents)
+		_, err := loadChainParams(path)
+		if err == nil || !strings.Contains(err.Error(), test.errStr) {
+			t.Errorf("%s: unexpected error - got %v, want %q",
+				test.name, err, test.errStr)
+		}
+	}
+
+	// Ensure files in unsupported formats are rejected.
+	path := writeChainParams(t, dir, "customnet.yaml", customNetTOML)
+	if _, err := loadChainParams(path); err == nil {
+		t.Error("loaded chain parameter file of unsupported format")
+	}
+}
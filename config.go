@@ -8,6 +8,7 @@ import (
 	"bufio"
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -24,6 +25,7 @@ import (
 	flags "github.com/jessevdk/go-flags"
 	"github.com/organicbitcoin/btcutil"
 	"github.com/organicbitcoin/obtcd/blockchain"
+	"github.com/organicbitcoin/obtcd/btcec"
 	"github.com/organicbitcoin/obtcd/chaincfg"
 	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
 	"github.com/organicbitcoin/obtcd/connmgr"
@@ -61,6 +63,7 @@ const (
 	defaultMaxOrphanTransactions = 100
 	defaultMaxOrphanTxSize       = 100000
 	defaultSigCacheMaxSize       = 100000
+	defaultUtxoCacheMaxSizeMiB   = 250
 	sampleConfigFilename         = "sample-btcd.conf"
 	defaultTxIndex               = false
 	defaultAddrIndex             = false
@@ -105,7 +108,8 @@ type config struct {
 	DisableBanning       bool          `long:"nobanning" description:"Disable banning of misbehaving peers"`
 	BanDuration          time.Duration `long:"banduration" description:"How long to ban misbehaving peers.  Valid time units are {s, m, h}.  Minimum 1 second"`
 	BanThreshold         uint32        `long:"banthreshold" description:"Maximum allowed ban score before disconnecting and banning misbehaving peers."`
-	Whitelists           []string      `long:"whitelist" description:"Add an IP network or IP that will not be banned. (eg. 192.168.1.0/24 or ::1)"`
+	Whitelists           []string      `long:"whitelist" description:"Grant permissions to peers connecting from an IP network or IP using [flags@]network where flags is a comma separated list of noban, relay, mempool, download, addr or all.  Peers are only exempt from banning when no flags are given. (eg. 192.168.1.0/24 or relay,noban@::1)"`
+	ASMap                string        `long:"asmap" description:"File mapping IP addresses to autonomous systems which is used to diversify outbound peers by network (asmap format of Bitcoin Core)"`
 	RPCUser              string        `short:"u" long:"rpcuser" description:"Username for RPC connections"`
 	RPCPass              string        `short:"P" long:"rpcpass" default-mask:"-" description:"Password for RPC connections"`
 	RPCLimitUser         string        `long:"rpclimituser" description:"Username for limited RPC connections"`
@@ -128,12 +132,20 @@ type config struct {
 	OnionProxyUser       string        `long:"onionuser" description:"Username for onion proxy server"`
 	OnionProxyPass       string        `long:"onionpass" default-mask:"-" description:"Password for onion proxy server"`
 	NoOnion              bool          `long:"noonion" description:"Disable connecting to tor hidden services"`
+	I2PProxy             string        `long:"i2pproxy" description:"Connect to I2P addresses via SOCKS5 proxy (eg. 127.0.0.1:4447)"`
+	CJDNSReachable       bool          `long:"cjdnsreachable" description:"Connect to CJDNS addresses (fc00::/8) through the local CJDNS interface"`
+	V2Transport          bool          `long:"v2transport" description:"Use the encrypted v2 transport (BIP0324) with peers and advertise support for it"`
 	TorIsolation         bool          `long:"torisolation" description:"Enable Tor stream isolation by randomizing user credentials for each connection."`
 	TestNet3             bool          `long:"testnet" description:"Use the test network"`
 	RegressionTest       bool          `long:"regtest" description:"Use the regression test network"`
 	SimNet               bool          `long:"simnet" description:"Use the simulation test network"`
+	SigNet               bool          `long:"signet" description:"Use the signet test network defined by the signetchallenge option"`
+	SigNetChallenge      string        `long:"signetchallenge" description:"Hex encoded challenge script every block on the signet test network must provide a solution for (BIP0325)"`
+	SigNetMiningKeys     []string      `long:"signetminingkey" description:"Add the specified WIF encoded private key to the list of keys used to sign generated blocks on the signet test network -- The keys must be able to solve the signet challenge if the generate option is set"`
+	ChainParams          string        `long:"chainparams" description:"Use the custom network defined by the specified TOML (.toml) or JSON (.json) chain parameter file"`
 	AddCheckpoints       []string      `long:"addcheckpoint" description:"Add a custom checkpoint.  Format: '<height>:<hash>'"`
 	DisableCheckpoints   bool          `long:"nocheckpoints" description:"Disable built-in checkpoints.  Don't do this unless you know what you're doing."`
+	AssumeValid          string        `long:"assumevalid" description:"Skip script validation for the specified block and its ancestors once it is buried in the best header chain, which must have the minimum chain work of the network -- Defaults to the block of the active network if any, use 0 to validate all scripts"`
 	DbType               string        `long:"dbtype" description:"Database backend to use for the Block Chain"`
 	Profile              string        `long:"profile" description:"Enable HTTP profiling on given port -- NOTE port must be between 1024 and 65536"`
 	CPUProfile           string        `long:"cpuprofile" description:"Write CPU profile to the specified file"`
@@ -156,7 +168,10 @@ type config struct {
 	NoCFilters           bool          `long:"nocfilters" description:"Disable committed filtering (CF) support"`
 	DropCfIndex          bool          `long:"dropcfindex" description:"Deletes the index used for committed filtering (CF) support from the database on start up and then exits."`
 	SigCacheMaxSize      uint          `long:"sigcachemaxsize" description:"The maximum number of entries in the signature verification cache"`
+	UtxoCacheMaxSizeMiB  uint          `long:"utxocachemaxsize" description:"The maximum size in MiB of the unspent transaction outputs kept in memory before they are flushed to the database (0 = flush after every block)"`
+	LoadTxOutSet         string        `long:"loadtxoutset" description:"Bootstrap the chain state from the utxo snapshot at the given path, which was written by the dumptxoutset RPC and is pinned in the chain parameters, and validate the history up to it in the background"`
 	BlocksOnly           bool          `long:"blocksonly" description:"Do not accept transactions from remote peers."`
+	MaxUploadTarget      uint64        `long:"maxuploadtarget" description:"Try to keep the bytes sent to peers under the given target in MiB per 24h.  Blocks older than a week are no longer served to peers without the download permission once the target is about to be reached (0 = no limit)"`
 	TxIndex              bool          `long:"txindex" description:"Maintain a full hash-based transaction index which makes all transactions available via the getrawtransaction RPC"`
 	DropTxIndex          bool          `long:"droptxindex" description:"Deletes the hash-based transaction index from the database on start up and then exits."`
 	AddrIndex            bool          `long:"addrindex" description:"Maintain a full address-based transaction index which makes the searchrawtransactions RPC available"`
@@ -166,10 +181,13 @@ type config struct {
 	lookup               func(string) ([]net.IP, error)
 	oniondial            func(string, string, time.Duration) (net.Conn, error)
 	dial                 func(string, string, time.Duration) (net.Conn, error)
+	dialer               *connmgr.NetworkDialer
 	addCheckpoints       []chaincfg.Checkpoint
+	assumeValid          *chainhash.Hash
 	miningAddrs          []btcutil.Address
+	signetKeys           []*btcec.PrivateKey
 	minRelayTxFee        btcutil.Amount
-	whitelists           []*net.IPNet
+	whitelists           []*whitelist
 }
 
 // serviceOptions defines the configuration options for the daemon as a service on
@@ -426,6 +444,7 @@ func loadConfig() (*config, []string, error) {
 		BlockPrioritySize:    mempool.DefaultBlockPrioritySize,
 		MaxOrphanTxs:         defaultMaxOrphanTransactions,
 		SigCacheMaxSize:      defaultSigCacheMaxSize,
+		UtxoCacheMaxSizeMiB:  defaultUtxoCacheMaxSizeMiB,
 		Generate:             defaultGenerate,
 		TxIndex:              defaultTxIndex,
 		AddrIndex:            defaultAddrIndex,
@@ -546,9 +565,90 @@ func loadConfig() (*config, []string, error) {
 		activeNetParams = &simNetParams
 		cfg.DisableDNSSeed = true
 	}
+	if cfg.SigNet {
+		numNets++
+		activeNetParams = &sigNetParams
+	}
+	if cfg.ChainParams != "" {
+		numNets++
+	}
 	if numNets > 1 {
-		str := "%s: The testnet, regtest, segnet, and simnet params " +
-			"can't be used together -- choose one of the four"
+		str := "%s: The testnet, regtest, segnet, simnet, signet, and " +
+			"chainparams params can't be used together -- choose " +
+			"one of the six"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// The signet test network is defined by its challenge, so its chain
+	// parameters are created and registered once the challenge is known.
+	if cfg.SigNet {
+		if cfg.SigNetChallenge == "" {
+			str := "%s: the signet flag is set, but there is no " +
+				"signet challenge specified"
+			err := fmt.Errorf(str, funcName)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+		challenge, err := hex.DecodeString(cfg.SigNetChallenge)
+		if err != nil || len(challenge) == 0 {
+			str := "%s: the signet challenge '%s' is not a valid " +
+				"hex encoded script"
+			err := fmt.Errorf(str, funcName, cfg.SigNetChallenge)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+		chainParams := chaincfg.SigNetParams(challenge)
+		if err := chaincfg.Register(&chainParams); err != nil {
+			str := "%s: failed to register the signet params: %v"
+			err := fmt.Errorf(str, funcName, err)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+		sigNetParams.Params = &chainParams
+	} else if cfg.SigNetChallenge != "" {
+		str := "%s: the signetchallenge option can only be used with " +
+			"the signet flag"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// Load and register the custom network defined by the chain parameter
+	// file.  There is nowhere to seed peers from when the file defines no
+	// DNS seeds.
+	if cfg.ChainParams != "" {
+		chainParams, err := loadChainParams(cleanAndExpandPath(cfg.ChainParams))
+		if err != nil {
+			str := "%s: failed to load the chain parameter file: %v"
+			err := fmt.Errorf(str, funcName, err)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+		if err := chaincfg.Register(chainParams.Params); err != nil {
+			str := "%s: failed to register the chain params: %v"
+			err := fmt.Errorf(str, funcName, err)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+		activeNetParams = chainParams
+		if len(chainParams.DNSSeeds) == 0 {
+			cfg.DisableDNSSeed = true
+		}
+	}
+
+	// Blocks are only signed on networks defined by a signet challenge.
+	if len(cfg.SigNetMiningKeys) > 0 && activeNetParams.SignetChallenge == nil {
+		str := "%s: the signetminingkey option can only be used on a " +
+			"signet network"
 		err := fmt.Errorf(str, funcName)
 		fmt.Fprintln(os.Stderr, err)
 		fmt.Fprintln(os.Stderr, usageMessage)
@@ -589,6 +689,14 @@ func loadConfig() (*config, []string, error) {
 	cfg.LogDir = cleanAndExpandPath(cfg.LogDir)
 	cfg.LogDir = filepath.Join(cfg.LogDir, netName(activeNetParams))
 
+	// Expand the path of the asmap file.
+	if cfg.LoadTxOutSet != "" {
+		cfg.LoadTxOutSet = cleanAndExpandPath(cfg.LoadTxOutSet)
+	}
+	if cfg.ASMap != "" {
+		cfg.ASMap = cleanAndExpandPath(cfg.ASMap)
+	}
+
 	// Special show command to list supported subsystems and exit.
 	if cfg.DebugLevel == "show" {
 		fmt.Println("Supported subsystems", supportedSubsystems())
@@ -617,6 +725,28 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// The chain state loaded from a utxo snapshot is kept in a separate
+	// database, which the memory database does not support.
+	if cfg.LoadTxOutSet != "" && cfg.DbType == "memdb" {
+		str := "%s: The loadtxoutset option can't be used with the " +
+			"memdb database type"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// Utxo snapshots can only be loaded when they are pinned in the chain
+	// parameters of the active network.
+	if cfg.LoadTxOutSet != "" && len(activeNetParams.AssumeUtxo) == 0 {
+		str := "%s: The loadtxoutset option can't be used since no " +
+			"utxo snapshots are pinned for the %s network"
+		err := fmt.Errorf(str, funcName, activeNetParams.Name)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Validate profile port number
 	if cfg.Profile != "" {
 		profilePort, err := strconv.Atoi(cfg.Profile)
@@ -638,35 +768,21 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
-	// Validate any given whitelisted IP addresses and networks.
+	// Validate any given whitelisted IP addresses and networks along with
+	// their permissions.
 	if len(cfg.Whitelists) > 0 {
-		var ip net.IP
-		cfg.whitelists = make([]*net.IPNet, 0, len(cfg.Whitelists))
+		cfg.whitelists = make([]*whitelist, 0, len(cfg.Whitelists))
 
-		for _, addr := range cfg.Whitelists {
-			_, ipnet, err := net.ParseCIDR(addr)
+		for _, entry := range cfg.Whitelists {
+			wl, err := parseWhitelist(entry)
 			if err != nil {
-				ip = net.ParseIP(addr)
-				if ip == nil {
-					str := "%s: The whitelist value of '%s' is invalid"
-					err = fmt.Errorf(str, funcName, addr)
-					fmt.Fprintln(os.Stderr, err)
-					fmt.Fprintln(os.Stderr, usageMessage)
-					return nil, nil, err
-				}
-				var bits int
-				if ip.To4() == nil {
-					// IPv6
-					bits = 128
-				} else {
-					bits = 32
-				}
-				ipnet = &net.IPNet{
-					IP:   ip,
-					Mask: net.CIDRMask(bits, bits),
-				}
+				str := "%s: The whitelist value of '%s' is invalid: %v"
+				err = fmt.Errorf(str, funcName, entry, err)
+				fmt.Fprintln(os.Stderr, err)
+				fmt.Fprintln(os.Stderr, usageMessage)
+				return nil, nil, err
 			}
-			cfg.whitelists = append(cfg.whitelists, ipnet)
+			cfg.whitelists = append(cfg.whitelists, wl)
 		}
 	}
 
@@ -896,6 +1012,40 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Check the signet mining keys are valid and save parsed versions.
+	cfg.signetKeys = make([]*btcec.PrivateKey, 0, len(cfg.SigNetMiningKeys))
+	for _, strKey := range cfg.SigNetMiningKeys {
+		wif, err := btcutil.DecodeWIF(strKey)
+		if err != nil {
+			str := "%s: signet mining key failed to decode: %v"
+			err := fmt.Errorf(str, funcName, err)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+		if !wif.IsForNet(activeNetParams.Params) {
+			str := "%s: signet mining key is on the wrong network"
+			err := fmt.Errorf(str, funcName)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+		cfg.signetKeys = append(cfg.signetKeys, wif.PrivKey)
+	}
+
+	// Ensure there is at least one key to sign the generated blocks with
+	// when the generate flag is set on a signet network.
+	if cfg.Generate && activeNetParams.SignetChallenge != nil &&
+		len(cfg.signetKeys) == 0 {
+
+		str := "%s: the generate flag is set, but there are no signet " +
+			"mining keys specified"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Add default port to all listener addresses if needed and remove
 	// duplicate addresses.
 	cfg.Listeners = normalizeAddresses(cfg.Listeners,
@@ -962,6 +1112,23 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Use the assume-valid block of the active network unless another one
+	// is specified.  A value of 0 disables the optimization.
+	switch cfg.AssumeValid {
+	case "":
+		cfg.assumeValid = activeNetParams.AssumeValid
+	case "0":
+	default:
+		cfg.assumeValid, err = chainhash.NewHashFromStr(cfg.AssumeValid)
+		if err != nil {
+			str := "%s: Error parsing assumevalid block hash: %v"
+			err := fmt.Errorf(str, funcName, err)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+	}
+
 	// Tor stream isolation requires either proxy or onion proxy to be set.
 	if cfg.TorIsolation && cfg.Proxy == "" && cfg.OnionProxy == "" {
 		str := "%s: Tor stream isolation requires either proxy or " +
@@ -1076,6 +1243,38 @@ func loadConfig() (*config, []string, error) {
 		}
 	}
 
+	// Setup the I2P address dial function when an I2P proxy is specified.
+	// I2P addresses are unreachable otherwise.
+	var i2pdial connmgr.DialFunc
+	if cfg.I2PProxy != "" {
+		_, _, err := net.SplitHostPort(cfg.I2PProxy)
+		if err != nil {
+			str := "%s: I2P proxy address '%s' is invalid: %v"
+			err := fmt.Errorf(str, funcName, cfg.I2PProxy, err)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+
+		proxy := &socks.Proxy{Addr: cfg.I2PProxy}
+		i2pdial = proxy.DialTimeout
+	}
+
+	// CJDNS addresses are dialed directly through the local CJDNS
+	// interface when --cjdnsreachable is specified.
+	var cjdnsdial connmgr.DialFunc
+	if cfg.CJDNSReachable {
+		cjdnsdial = net.DialTimeout
+	}
+
+	cfg.dialer = &connmgr.NetworkDialer{
+		Default: cfg.dial,
+		Onion:   cfg.oniondial,
+		I2P:     i2pdial,
+		CJDNS:   cjdnsdial,
+		Timeout: defaultConnectTimeout,
+	}
+
 	// Warn about missing config file only after all other configuration is
 	// done.  This prevents the warning on help messages and invalid
 	// options.  Note this should go directly before the return.
@@ -1157,13 +1356,10 @@ func createDefaultConfigFile(destinationPath string) error {
 // dial function depending on the address and configuration options.  For
 // example, .onion addresses will be dialed using the onion specific proxy if
 // one was specified, but will otherwise use the normal dial function (which
-// could itself use a proxy or not).
+// could itself use a proxy or not), while .i2p addresses are dialed using the
+// I2P proxy.
 func btcdDial(addr net.Addr) (net.Conn, error) {
-	if strings.Contains(addr.String(), ".onion:") {
-		return cfg.oniondial(addr.Network(), addr.String(),
-			defaultConnectTimeout)
-	}
-	return cfg.dial(addr.Network(), addr.String(), defaultConnectTimeout)
+	return cfg.dialer.Dial(addr)
 }
 
 // btcdLookup resolves the IP of the given host using the correct DNS lookup
@@ -1171,12 +1367,15 @@ func btcdDial(addr net.Addr) (net.Conn, error) {
 // be resolved using tor when the --proxy flag was specified unless --noonion
 // was also specified in which case the normal system DNS resolver will be used.
 //
-// Any attempt to resolve a tor address (.onion) will return an error since they
-// are not intended to be resolved outside of the tor proxy.
+// Any attempt to resolve a tor (.onion) or i2p (.i2p) address will return an
+// error since they are not intended to be resolved outside of their proxies.
 func btcdLookup(host string) ([]net.IP, error) {
 	if strings.HasSuffix(host, ".onion") {
 		return nil, fmt.Errorf("attempt to resolve tor address %s", host)
 	}
+	if strings.HasSuffix(host, ".i2p") {
+		return nil, fmt.Errorf("attempt to resolve i2p address %s", host)
+	}
 
 	return cfg.lookup(host)
 }
@@ -52,6 +52,49 @@ const (
 	ConnDisconnected
 )
 
+// ConnType identifies the purpose of an outbound connection.
+type ConnType uint8
+
+const (
+	// ConnTypeFullRelay is an outbound connection which relays blocks,
+	// transactions and addresses.  Permanent connections are of this type.
+	ConnTypeFullRelay ConnType = iota
+
+	// ConnTypeBlockRelay is an outbound connection which only relays
+	// blocks.  Since such connections don't reveal which transactions and
+	// addresses a node knows about, they are harder for an attacker to
+	// identify and make it more difficult to partition the node from the
+	// network.
+	ConnTypeBlockRelay
+
+	// ConnTypeFeeler is a short-lived outbound connection made to test
+	// whether an address that has not been connected to yet is reachable.
+	// Feeler connections are not retried or replaced.
+	ConnTypeFeeler
+
+	// ConnTypeManual is an outbound connection which was explicitly
+	// requested rather than made to fill the connection targets.  Manual
+	// connections are only retried when they are permanent.
+	ConnTypeManual
+)
+
+// connTypeStrings is a map of connection types back to their constant names
+// for pretty printing.
+var connTypeStrings = map[ConnType]string{
+	ConnTypeFullRelay:  "ConnTypeFullRelay",
+	ConnTypeBlockRelay: "ConnTypeBlockRelay",
+	ConnTypeFeeler:     "ConnTypeFeeler",
+	ConnTypeManual:     "ConnTypeManual",
+}
+
+// String returns the ConnType in human-readable form.
+func (t ConnType) String() string {
+	if s, ok := connTypeStrings[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown ConnType (%d)", uint8(t))
+}
+
 // ConnReq is the connection request to a network address. If permanent, the
 // connection will be retried on disconnection.
 type ConnReq struct {
@@ -60,6 +103,7 @@ type ConnReq struct {
 
 	Addr      net.Addr
 	Permanent bool
+	Type      ConnType
 
 	conn       net.Conn
 	state      ConnState
@@ -124,6 +168,21 @@ type Config struct {
 	// maintain. Defaults to 8.
 	TargetOutbound uint32
 
+	// TargetBlockRelay is the number of outbound block-relay-only
+	// connections to maintain in addition to TargetOutbound.
+	TargetBlockRelay uint32
+
+	// Anchors are the addresses of block-relay-only connections from a
+	// previous run.  They are connected to before any new addresses are
+	// requested for block-relay-only connections.
+	Anchors []net.Addr
+
+	// FeelerInterval is the interval at which a feeler connection is made
+	// to an address obtained from GetFeelerAddress while all of the
+	// TargetOutbound connections are established.  Feeler connections are
+	// disabled when it is zero.
+	FeelerInterval time.Duration
+
 	// RetryDuration is the duration to wait before retrying connection
 	// requests. Defaults to 5s.
 	RetryDuration time.Duration
@@ -140,6 +199,10 @@ type Config struct {
 	// to.  If nil, no new connections will be made automatically.
 	GetNewAddress func() (net.Addr, error)
 
+	// GetFeelerAddress is a way to get an address to make a feeler
+	// connection to.  If nil, no feeler connections will be made.
+	GetFeelerAddress func() (net.Addr, error)
+
 	// Dial connects to the address on the named network. It cannot be nil.
 	Dial func(net.Addr) (net.Conn, error)
 }
@@ -183,6 +246,33 @@ type ConnManager struct {
 	failedAttempts uint64
 	requests       chan interface{}
 	quit           chan struct{}
+
+	// anchors holds the anchors which have not been connected to yet.
+	anchorsMtx sync.Mutex
+	anchors    []net.Addr
+}
+
+// targetConns returns the number of automatic connections of the passed type
+// to maintain.
+func (cm *ConnManager) targetConns(connType ConnType) uint32 {
+	switch connType {
+	case ConnTypeFullRelay:
+		return cm.cfg.TargetOutbound
+	case ConnTypeBlockRelay:
+		return cm.cfg.TargetBlockRelay
+	}
+	return 0
+}
+
+// countConns returns the number of connections of the passed type.
+func countConns(conns map[uint64]*ConnReq, connType ConnType) uint32 {
+	var n uint32
+	for _, c := range conns {
+		if c.Type == connType {
+			n++
+		}
+	}
+	return n
 }
 
 // handleFailedConn handles a connection failed due to a disconnect or any
@@ -204,17 +294,17 @@ func (cm *ConnManager) handleFailedConn(c *ConnReq) {
 		time.AfterFunc(d, func() {
 			cm.Connect(c)
 		})
-	} else if cm.cfg.GetNewAddress != nil {
+	} else if cm.cfg.GetNewAddress != nil && cm.targetConns(c.Type) > 0 {
 		cm.failedAttempts++
 		if cm.failedAttempts >= maxFailedAttempts {
 			log.Debugf("Max failed connection attempts reached: [%d] "+
 				"-- retrying connection in: %v", maxFailedAttempts,
 				cm.cfg.RetryDuration)
 			time.AfterFunc(cm.cfg.RetryDuration, func() {
-				cm.NewConnReq()
+				cm.newConnReq(c.Type)
 			})
 		} else {
-			go cm.NewConnReq()
+			go cm.newConnReq(c.Type)
 		}
 	}
 }
@@ -234,11 +324,27 @@ func (cm *ConnManager) connHandler() {
 
 		// conns represents the set of all actively connected peers.
 		conns = make(map[uint64]*ConnReq, cm.cfg.TargetOutbound)
+
+		// feelerC fires when the next feeler connection is due.
+		feelerC <-chan time.Time
 	)
 
+	if cm.cfg.FeelerInterval > 0 && cm.cfg.GetFeelerAddress != nil {
+		feelerTicker := time.NewTicker(cm.cfg.FeelerInterval)
+		defer feelerTicker.Stop()
+		feelerC = feelerTicker.C
+	}
+
 out:
 	for {
 		select {
+		case <-feelerC:
+			// Feelers are only useful once all of the regular
+			// outbound connections are established.
+			if countConns(conns, ConnTypeFullRelay) >= cm.cfg.TargetOutbound {
+				go cm.newConnReq(ConnTypeFeeler)
+			}
+
 		case req := <-cm.requests:
 			switch msg := req.(type) {
 
@@ -322,7 +428,8 @@ out:
 				// re added to the pending map, so that
 				// subsequent processing of connections and
 				// failures do not ignore the request.
-				if uint32(len(conns)) < cm.cfg.TargetOutbound ||
+				if countConns(conns, connReq.Type) <
+					cm.targetConns(connReq.Type) ||
 					connReq.Permanent {
 
 					connReq.updateState(ConnPending)
@@ -344,6 +451,9 @@ out:
 				connReq.updateState(ConnFailing)
 				log.Debugf("Failed to connect to %v: %v",
 					connReq, msg.err)
+				if connReq.Type == ConnTypeFeeler {
+					delete(pending, connReq.id)
+				}
 				cm.handleFailedConn(connReq)
 			}
 
@@ -359,14 +469,45 @@ out:
 // NewConnReq creates a new connection request and connects to the
 // corresponding address.
 func (cm *ConnManager) NewConnReq() {
+	cm.newConnReq(ConnTypeFullRelay)
+}
+
+// nextAnchor removes and returns the next anchor which has not been connected
+// to yet or nil when there are none left.
+func (cm *ConnManager) nextAnchor() net.Addr {
+	cm.anchorsMtx.Lock()
+	defer cm.anchorsMtx.Unlock()
+
+	if len(cm.anchors) == 0 {
+		return nil
+	}
+	addr := cm.anchors[0]
+	cm.anchors = cm.anchors[1:]
+	return addr
+}
+
+// newConnReq creates a new connection request of the passed type and connects
+// to the corresponding address.
+func (cm *ConnManager) newConnReq(connType ConnType) {
 	if atomic.LoadInt32(&cm.stop) != 0 {
 		return
 	}
 	if cm.cfg.GetNewAddress == nil {
 		return
 	}
+	getAddress := cm.cfg.GetNewAddress
+	switch connType {
+	case ConnTypeBlockRelay:
+		if anchor := cm.nextAnchor(); anchor != nil {
+			getAddress = func() (net.Addr, error) {
+				return anchor, nil
+			}
+		}
+	case ConnTypeFeeler:
+		getAddress = cm.cfg.GetFeelerAddress
+	}
 
-	c := &ConnReq{}
+	c := &ConnReq{Type: connType}
 	atomic.StoreUint64(&c.id, atomic.AddUint64(&cm.connReqCount, 1))
 
 	// Submit a request of a pending connection attempt to the connection
@@ -388,7 +529,7 @@ func (cm *ConnManager) NewConnReq() {
 		return
 	}
 
-	addr, err := cm.cfg.GetNewAddress()
+	addr, err := getAddress()
 	if err != nil {
 		select {
 		case cm.requests <- handleFailed{c, err}:
@@ -529,6 +670,9 @@ func (cm *ConnManager) Start() {
 	for i := atomic.LoadUint64(&cm.connReqCount); i < uint64(cm.cfg.TargetOutbound); i++ {
 		go cm.NewConnReq()
 	}
+	for i := uint32(0); i < cm.cfg.TargetBlockRelay; i++ {
+		go cm.newConnReq(ConnTypeBlockRelay)
+	}
 }
 
 // Wait blocks until the connection manager halts gracefully.
@@ -572,6 +716,7 @@ func New(cfg *Config) (*ConnManager, error) {
 		cfg:      *cfg, // Copy so caller can't mutate
 		requests: make(chan interface{}),
 		quit:     make(chan struct{}),
+		anchors:  append([]net.Addr(nil), cfg.Anchors...),
 	}
 	return &cm, nil
 }
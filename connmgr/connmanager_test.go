@@ -216,6 +216,136 @@ func TestTargetOutbound(t *testing.T) {
 	cmgr.Stop()
 }
 
+// TestTargetBlockRelay tests that the target number of block-relay-only
+// connections is maintained in addition to the target outbound connections
+// and that anchors are connected to first.
+func TestTargetBlockRelay(t *testing.T) {
+	targetOutbound := uint32(3)
+	targetBlockRelay := uint32(2)
+	anchor := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 18555}
+	connected := make(chan *ConnReq)
+	cmgr, err := New(&Config{
+		TargetOutbound:   targetOutbound,
+		TargetBlockRelay: targetBlockRelay,
+		Anchors:          []net.Addr{anchor},
+		Dial:             mockDialer,
+		GetNewAddress: func() (net.Addr, error) {
+			return &net.TCPAddr{
+				IP:   net.ParseIP("127.0.0.1"),
+				Port: 18555,
+			}, nil
+		},
+		OnConnection: func(c *ConnReq, conn net.Conn) {
+			connected <- c
+		},
+	})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	cmgr.Start()
+
+	counts := make(map[ConnType]uint32)
+	anchors := 0
+	var blockRelay *ConnReq
+	for i := uint32(0); i < targetOutbound+targetBlockRelay; i++ {
+		c := <-connected
+		counts[c.Type]++
+		if c.Addr.String() == anchor.String() {
+			if c.Type != ConnTypeBlockRelay {
+				t.Fatalf("anchor connected as %v", c.Type)
+			}
+			anchors++
+		}
+		if c.Type == ConnTypeBlockRelay {
+			blockRelay = c
+		}
+	}
+	if counts[ConnTypeFullRelay] != targetOutbound ||
+		counts[ConnTypeBlockRelay] != targetBlockRelay {
+
+		t.Fatalf("unexpected connection types %v", counts)
+	}
+	if anchors != 1 {
+		t.Fatalf("anchor connected %d times, want 1", anchors)
+	}
+
+	// A disconnected block-relay-only connection is replaced by another
+	// one.
+	cmgr.Disconnect(blockRelay.ID())
+	select {
+	case c := <-connected:
+		if c.Type != ConnTypeBlockRelay {
+			t.Fatalf("block relay connection replaced by %v", c.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("block relay connection not replaced")
+	}
+	cmgr.Stop()
+}
+
+// TestFeelerConnections tests that feeler connections are made to the
+// addresses returned by GetFeelerAddress once the target outbound connections
+// are established and that they are not replaced when they disconnect.
+func TestFeelerConnections(t *testing.T) {
+	targetOutbound := uint32(2)
+	feelerAddr := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 18555}
+	connected := make(chan *ConnReq)
+	cmgr, err := New(&Config{
+		TargetOutbound: targetOutbound,
+		FeelerInterval: 10 * time.Millisecond,
+		Dial:           mockDialer,
+		GetNewAddress: func() (net.Addr, error) {
+			return &net.TCPAddr{
+				IP:   net.ParseIP("127.0.0.1"),
+				Port: 18555,
+			}, nil
+		},
+		GetFeelerAddress: func() (net.Addr, error) {
+			return feelerAddr, nil
+		},
+		OnConnection: func(c *ConnReq, conn net.Conn) {
+			connected <- c
+		},
+	})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	cmgr.Start()
+
+	var feeler *ConnReq
+	for feeler == nil {
+		select {
+		case c := <-connected:
+			if c.Type == ConnTypeFeeler {
+				feeler = c
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no feeler connection made")
+		}
+	}
+	if feeler.Addr.String() != feelerAddr.String() {
+		t.Fatalf("feeler connected to %v, want %v", feeler.Addr,
+			feelerAddr)
+	}
+
+	// Disconnecting the feeler must not result in a regular outbound
+	// connection replacing it.
+	cmgr.Disconnect(feeler.ID())
+	timeout := time.After(50 * time.Millisecond)
+	for {
+		select {
+		case c := <-connected:
+			if c.Type != ConnTypeFeeler {
+				t.Fatalf("feeler replaced by %v connection", c.Type)
+			}
+			continue
+		case <-timeout:
+		}
+		break
+	}
+	cmgr.Stop()
+}
+
 // TestRetryPermanent tests that permanent connection requests are retried.
 //
 // We make a permanent connection request using Connect, disconnect it using
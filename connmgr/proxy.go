@@ -0,0 +1,76 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package connmgr
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// cjdnsNet is the IPv6 address block used by CJDNS (fc00::/8).
+var cjdnsNet = net.IPNet{IP: net.ParseIP("fc00::"), Mask: net.CIDRMask(8, 128)}
+
+// DialFunc is the signature of the functions used to connect to an address on
+// the named network, such as net.DialTimeout or the DialTimeout method of a
+// SOCKS5 proxy.
+type DialFunc func(network, addr string, timeout time.Duration) (net.Conn, error)
+
+// NetworkDialer connects to addresses through the dial function configured for
+// the network they belong to.  This allows Tor, I2P and CJDNS addresses to be
+// dialed through their respective proxies or interfaces while all other
+// addresses use the default dial function.  Networks without a dial function
+// are considered unreachable.
+type NetworkDialer struct {
+	// Default dials IPv4 and IPv6 addresses as well as any host names.
+	Default DialFunc
+
+	// Onion dials Tor .onion addresses.
+	Onion DialFunc
+
+	// I2P dials I2P .i2p addresses.
+	I2P DialFunc
+
+	// CJDNS dials CJDNS addresses, which are IPv6 addresses within
+	// fc00::/8.  When it is nil, such addresses are dialed using the
+	// default dial function.
+	CJDNS DialFunc
+
+	// Timeout is the timeout passed to the dial functions.
+	Timeout time.Duration
+}
+
+// Dial connects to the passed address using the dial function of the network
+// it belongs to.  This is suitable for use as the Dial function of the
+// connection manager Config.
+func (d *NetworkDialer) Dial(addr net.Addr) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var dial DialFunc
+	var network string
+	switch {
+	case strings.HasSuffix(host, ".onion"):
+		dial, network = d.Onion, "tor"
+	case strings.HasSuffix(host, ".i2p"):
+		dial, network = d.I2P, "i2p"
+	default:
+		dial, network = d.Default, "ip"
+		if ip := net.ParseIP(host); ip != nil && d.CJDNS != nil &&
+			cjdnsNet.Contains(ip) {
+
+			dial, network = d.CJDNS, "cjdns"
+		}
+	}
+	if dial == nil {
+		return nil, fmt.Errorf("unable to dial %s: %s network is "+
+			"unreachable", addr, network)
+	}
+
+	return dial(addr.Network(), addr.String(), d.Timeout)
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package connmgr
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestNetworkDialer ensures addresses are dialed through the dial function of
+// the network they belong to and that unreachable networks are rejected.
+func TestNetworkDialer(t *testing.T) {
+	var dialed string
+	dialFunc := func(name string) DialFunc {
+		return func(network, addr string, timeout time.Duration) (net.Conn, error) {
+			dialed = name
+			return mockDialer(&mockAddr{network, addr})
+		}
+	}
+
+	dialer := &NetworkDialer{
+		Default: dialFunc("default"),
+		Onion:   dialFunc("onion"),
+		I2P:     dialFunc("i2p"),
+		Timeout: time.Second,
+	}
+	tests := []struct {
+		addr  string
+		want  string
+		cjdns bool
+	}{
+		{addr: "127.0.0.1:8333", want: "default"},
+		{addr: "[2001:db8::1]:8333", want: "default"},
+		{addr: "ryugyn6yrno5f3rb.onion:8333", want: "onion"},
+		{addr: "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion:8333",
+			want: "onion"},
+		{addr: "ukeu3k5oycgaauneqgtnvselmt4yemvoilkln7jpvamvfx7dnkdq.b32.i2p:0",
+			want: "i2p"},
+		{addr: "[fc00::1]:8333", want: "default"},
+		{addr: "[fc00::1]:8333", want: "cjdns", cjdns: true},
+		{addr: "[fd00::1]:8333", want: "default", cjdns: true},
+	}
+	for _, test := range tests {
+		dialer.CJDNS = nil
+		if test.cjdns {
+			dialer.CJDNS = dialFunc("cjdns")
+		}
+		dialed = ""
+		conn, err := dialer.Dial(&mockAddr{"tcp", test.addr})
+		if err != nil {
+			t.Fatalf("Dial %s: unexpected error: %v", test.addr, err)
+		}
+		if conn.RemoteAddr().String() != test.addr {
+			t.Fatalf("Dial %s: connected to %s", test.addr,
+				conn.RemoteAddr())
+		}
+		if dialed != test.want {
+			t.Fatalf("Dial %s: dialed through %q, want %q",
+				test.addr, dialed, test.want)
+		}
+	}
+
+	// Networks without a dial function are unreachable.
+	dialer.I2P = nil
+	addr := &mockAddr{"tcp", "ukeu3k5oycgaauneqgtnvselmt4yemvoilkln7jpvamvfx7dnkdq.b32.i2p:0"}
+	if _, err := dialer.Dial(addr); err == nil {
+		t.Fatal("Dial: expected error for unreachable network")
+	}
+}
@@ -39,8 +39,15 @@ Application Options:
                             are {s, m, h}.  Minimum 1 second (24h0m0s)
       --banthreshold=       Maximum allowed ban score before disconnecting and
                             banning misbehaving peers.
-      --whitelist=          Add an IP network or IP that will not be banned.
-                            (eg. 192.168.1.0/24 or ::1)
+      --whitelist=          Grant permissions to peers connecting from an IP
+                            network or IP using [flags@]network where flags
+                            is a comma separated list of noban, relay,
+                            mempool, download, addr or all.  Peers are only
+                            exempt from banning when no flags are given.
+                            (eg. 192.168.1.0/24 or relay,noban@::1)
+      --asmap=              File mapping IP addresses to autonomous systems
+                            which is used to diversify outbound peers by
+                            network (asmap format of Bitcoin Core)
   -u, --rpcuser=            Username for RPC connections
   -P, --rpcpass=            Password for RPC connections
       --rpclimituser=       Username for limited RPC connections
@@ -71,14 +78,32 @@ Application Options:
       --onionuser=          Username for onion proxy server
       --onionpass=          Password for onion proxy server
       --noonion             Disable connecting to tor hidden services
+      --i2pproxy=           Connect to I2P addresses via SOCKS5 proxy
+                            (eg. 127.0.0.1:4447)
+      --cjdnsreachable      Connect to CJDNS addresses (fc00::/8) through the
+                            local CJDNS interface
+      --v2transport         Use the encrypted v2 transport (BIP0324) with peers
+                            and advertise support for it
       --torisolation        Enable Tor stream isolation by randomizing user
                             credentials for each connection.
       --testnet             Use the test network
       --regtest             Use the regression test network
       --simnet              Use the simulation test network
+      --signet              Use the signet test network defined by the
+                            signetchallenge option
+      --signetchallenge=    Hex encoded challenge script every block on the
+                            signet test network must provide a solution for
+                            (BIP0325)
+      --chainparams=        Use the custom network defined by the specified
+                            TOML (.toml) or JSON (.json) chain parameter file
       --addcheckpoint=      Add a custom checkpoint.  Format: '<height>:<hash>'
       --nocheckpoints       Disable built-in checkpoints.  Don't do this unless
                             you know what you're doing.
+      --assumevalid=        Skip script validation for the specified block and
+                            its ancestors once it is buried in the best header
+                            chain, which must have the minimum chain work of
+                            the network -- Defaults to the block of the active
+                            network if any, use 0 to validate all scripts
       --uacomment=          Comment to add to the user agent --
                             See BIP 14 for more information.
       --dbtype=             Database backend to use for the Block Chain (ffldb)
@@ -105,6 +130,11 @@ Application Options:
                             addresses to use for generated blocks -- At least
                             one address is required if the generate option is
                             set
+      --signetminingkey=    Add the specified WIF encoded private key to the
+                            list of keys used to sign generated blocks on the
+                            signet test network -- The keys must be able to
+                            solve the signet challenge if the generate option
+                            is set
       --blockminsize=       Mininum block size in bytes to be used when creating
                             a block
       --blockmaxsize=       Maximum block size in bytes to be used when creating
@@ -115,7 +145,20 @@ Application Options:
       --nocfilters          Disable committed filtering (CF) support.
       --sigcachemaxsize=    The maximum number of entries in the signature
                             verification cache.
+      --utxocachemaxsize=   The maximum size in MiB of the unspent transaction
+                            outputs kept in memory before they are flushed to
+                            the database (0 = flush after every block) (250)
+      --loadtxoutset=       Bootstrap the chain state from the utxo snapshot at
+                            the given path, which was written by the
+                            dumptxoutset RPC and is pinned in the chain
+                            parameters, and validate the history up to it in
+                            the background
       --blocksonly          Do not accept transactions from remote peers.
+      --maxuploadtarget=    Try to keep the bytes sent to peers under the
+                            given target in MiB per 24h.  Blocks older than a
+                            week are no longer served to peers without the
+                            download permission once the target is about to
+                            be reached (0 = no limit)
       --relaynonstd         Relay non-standard transactions regardless of the
                             default settings for the active network.
       --rejectnonstd        Reject non-standard transactions regardless of the
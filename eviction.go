@@ -0,0 +1,182 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// The number of inbound peers protected from eviction by each of the
+// characteristics that are hard for an attacker to fake.
+const (
+	// evictProtectNetGroup is the number of peers protected based on a
+	// keyed hash of their network group.  Since the key is unknown to
+	// others, an attacker can't predict which network groups to connect
+	// from in order to be protected.
+	evictProtectNetGroup = 4
+
+	// evictProtectPing is the number of peers with the lowest ping times
+	// which are protected.
+	evictProtectPing = 8
+
+	// evictProtectTx is the number of peers which most recently relayed a
+	// transaction that was accepted to the mempool which are protected.
+	evictProtectTx = 4
+
+	// evictProtectBlockRelayOnly is the number of peers which don't relay
+	// transactions and most recently relayed a new block which are
+	// protected.
+	evictProtectBlockRelayOnly = 8
+
+	// evictProtectBlock is the number of peers which most recently relayed
+	// a new block which are protected.
+	evictProtectBlock = 4
+)
+
+// evictionCandidate houses the characteristics of an inbound peer which are
+// used to decide whether it is evicted to make room for a new inbound peer.
+type evictionCandidate struct {
+	id            int32
+	netGroup      string
+	keyedNetGroup uint64
+	pingTime      time.Duration
+	connected     time.Time
+	lastTx        time.Time
+	lastBlock     time.Time
+	relayTxs      bool
+}
+
+// protectCandidates removes up to k candidates which match the passed filter
+// from the end of the candidates after sorting them with less.  The filter may
+// be nil to match all candidates.
+func protectCandidates(candidates []*evictionCandidate,
+	less func(a, b *evictionCandidate) bool, k int,
+	filter func(c *evictionCandidate) bool) []*evictionCandidate {
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return less(candidates[i], candidates[j])
+	})
+
+	protect := make(map[*evictionCandidate]struct{}, k)
+	for i := len(candidates) - 1; i >= 0 && len(protect) < k; i-- {
+		if filter == nil || filter(candidates[i]) {
+			protect[candidates[i]] = struct{}{}
+		}
+	}
+
+	remaining := make([]*evictionCandidate, 0, len(candidates)-len(protect))
+	for _, c := range candidates {
+		if _, ok := protect[c]; !ok {
+			remaining = append(remaining, c)
+		}
+	}
+	return remaining
+}
+
+// selectPeerToEvict returns the id of the inbound peer which should be evicted
+// to make room for a new inbound peer from the passed candidates.  It returns
+// false when all of the candidates are protected.
+//
+// Peers are protected from eviction when they are hard to replace, such as
+// peers in network groups selected by a keyed hash, peers with the lowest
+// ping times, peers which recently relayed new transactions or blocks and
+// peers which have been connected the longest.  The youngest peer of the
+// network group with the most remaining peers is then evicted.  This makes
+// it hard for an attacker to take over all of the inbound slots since it must
+// do better than the honest peers in all of the characteristics at once.
+func selectPeerToEvict(candidates []*evictionCandidate) (int32, bool) {
+	candidates = append([]*evictionCandidate(nil), candidates...)
+
+	candidates = protectCandidates(candidates, func(a, b *evictionCandidate) bool {
+		return a.keyedNetGroup < b.keyedNetGroup
+	}, evictProtectNetGroup, nil)
+
+	candidates = protectCandidates(candidates, func(a, b *evictionCandidate) bool {
+		return pingTime(a) > pingTime(b)
+	}, evictProtectPing, nil)
+
+	candidates = protectCandidates(candidates, func(a, b *evictionCandidate) bool {
+		if !a.lastTx.Equal(b.lastTx) {
+			return a.lastTx.Before(b.lastTx)
+		}
+		if a.relayTxs != b.relayTxs {
+			return !a.relayTxs
+		}
+		return a.connected.After(b.connected)
+	}, evictProtectTx, nil)
+
+	candidates = protectCandidates(candidates, func(a, b *evictionCandidate) bool {
+		if a.relayTxs != b.relayTxs {
+			return a.relayTxs
+		}
+		if !a.lastBlock.Equal(b.lastBlock) {
+			return a.lastBlock.Before(b.lastBlock)
+		}
+		return a.connected.After(b.connected)
+	}, evictProtectBlockRelayOnly, func(c *evictionCandidate) bool {
+		return !c.relayTxs
+	})
+
+	candidates = protectCandidates(candidates, func(a, b *evictionCandidate) bool {
+		if !a.lastBlock.Equal(b.lastBlock) {
+			return a.lastBlock.Before(b.lastBlock)
+		}
+		return a.connected.After(b.connected)
+	}, evictProtectBlock, nil)
+
+	// Protect half of the remaining peers which have been connected the
+	// longest.
+	candidates = protectCandidates(candidates, func(a, b *evictionCandidate) bool {
+		return a.connected.After(b.connected)
+	}, len(candidates)/2, nil)
+
+	if len(candidates) == 0 {
+		return 0, false
+	}
+
+	// Find the network group with the most peers.  Ties are broken in
+	// favor of the group with the most recently connected peer.
+	groups := make(map[string][]*evictionCandidate)
+	for _, c := range candidates {
+		groups[c.netGroup] = append(groups[c.netGroup], c)
+	}
+	var evictGroup []*evictionCandidate
+	var evictGroupNewest time.Time
+	for _, group := range groups {
+		newest := group[0].connected
+		for _, c := range group[1:] {
+			if c.connected.After(newest) {
+				newest = c.connected
+			}
+		}
+		if len(group) > len(evictGroup) ||
+			(len(group) == len(evictGroup) &&
+				newest.After(evictGroupNewest)) {
+
+			evictGroup = group
+			evictGroupNewest = newest
+		}
+	}
+
+	// Evict the most recently connected peer of the group.
+	evict := evictGroup[0]
+	for _, c := range evictGroup[1:] {
+		if c.connected.After(evict.connected) {
+			evict = c
+		}
+	}
+	return evict.id, true
+}
+
+// pingTime returns the ping time of the candidate where peers which have not
+// responded to a ping yet are treated as having the worst ping time.
+func pingTime(c *evictionCandidate) time.Duration {
+	if c.pingTime <= 0 {
+		return math.MaxInt64
+	}
+	return c.pingTime
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newTestCandidates returns n relaying eviction candidates with distinct
+// network groups which were connected one minute apart, newest last.
+func newTestCandidates(n int, start time.Time) []*evictionCandidate {
+	candidates := make([]*evictionCandidate, 0, n)
+	for i := 0; i < n; i++ {
+		group := fmt.Sprintf("10.%d.0.0", i)
+		candidates = append(candidates, &evictionCandidate{
+			id:            int32(i),
+			netGroup:      group,
+			keyedNetGroup: uint64(i),
+			pingTime:      time.Duration(i+1) * time.Millisecond,
+			connected:     start.Add(time.Duration(i) * time.Minute),
+			relayTxs:      true,
+		})
+	}
+	return candidates
+}
+
+// TestSelectPeerToEvictProtected ensures no peer is evicted when all of the
+// candidates are protected.
+func TestSelectPeerToEvictProtected(t *testing.T) {
+	protected := evictProtectNetGroup + evictProtectPing + evictProtectTx +
+		evictProtectBlock
+	for n := 0; n <= protected; n++ {
+		candidates := newTestCandidates(n, time.Now())
+		if id, ok := selectPeerToEvict(candidates); ok {
+			t.Fatalf("%d candidates: evicted peer %d", n, id)
+		}
+	}
+
+	// Peers which don't relay transactions are protected when they relay
+	// blocks.
+	candidates := newTestCandidates(protected+evictProtectBlockRelayOnly,
+		time.Now())
+	for _, c := range candidates[protected:] {
+		c.keyedNetGroup = 0
+		c.relayTxs = false
+		c.lastBlock = time.Now()
+	}
+	if id, ok := selectPeerToEvict(candidates); ok {
+		t.Fatalf("evicted peer %d with block relay only peers protected",
+			id)
+	}
+}
+
+// TestSelectPeerToEvict ensures the youngest peer of the largest network group
+// is evicted and peers which recently relayed transactions or blocks are
+// protected.
+func TestSelectPeerToEvict(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	candidates := newTestCandidates(20, start)
+
+	// An attacker fills the remaining slots with peers from one network
+	// group.
+	for i := 0; i < 30; i++ {
+		candidates = append(candidates, &evictionCandidate{
+			id:            int32(100 + i),
+			netGroup:      "200.1.0.0",
+			keyedNetGroup: 0,
+			pingTime:      50 * time.Millisecond,
+			connected:     start.Add(time.Duration(30+i) * time.Minute),
+			relayTxs:      true,
+		})
+	}
+
+	id, ok := selectPeerToEvict(candidates)
+	if !ok {
+		t.Fatal("no peer evicted")
+	}
+	if id != 129 {
+		t.Fatalf("unexpected evicted peer - got %d, want %d", id, 129)
+	}
+
+	// The youngest attacker peer is protected once it relays a new
+	// transaction, so the next youngest one is evicted instead.
+	candidates[len(candidates)-1].lastTx = time.Now()
+	id, ok = selectPeerToEvict(candidates)
+	if !ok {
+		t.Fatal("no peer evicted")
+	}
+	if id != 128 {
+		t.Fatalf("unexpected evicted peer - got %d, want %d", id, 128)
+	}
+
+	// Ties between the largest network groups are broken in favor of the
+	// group with the most recently connected peer.
+	candidates = newTestCandidates(40, start)
+	for i, c := range candidates {
+		c.netGroup = fmt.Sprintf("10.%d.0.0", i%2)
+	}
+	candidates[39].keyedNetGroup = 0
+	candidates[39].connected = time.Now()
+	id, ok = selectPeerToEvict(candidates)
+	if !ok {
+		t.Fatal("no peer evicted")
+	}
+	if id != 39 {
+		t.Fatalf("unexpected evicted peer - got %d, want %d", id, 39)
+	}
+}
@@ -1,7 +1,8 @@
 module github.com/organicbitcoin/obtcd
 
 require (
-	github.com/aead/siphash v1.0.1 // indirect
+	github.com/BurntSushi/toml v0.3.0
+	github.com/aead/siphash v1.0.1
 	github.com/btcsuite/btclog v0.0.0-20170628155309-84c8d2346e9f
 	github.com/organicbitcoin/btcutil v0.0.0-20190207003914-4c204d697803
 	github.com/btcsuite/go-socks v0.0.0-20170105172521-4720035b7bfd
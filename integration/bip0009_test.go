@@ -300,6 +300,7 @@ func TestBIP0009(t *testing.T) {
 
 	testBIP0009(t, "dummy", chaincfg.DeploymentTestDummy)
 	testBIP0009(t, "segwit", chaincfg.DeploymentSegwit)
+	testBIP0009(t, "taxation", chaincfg.DeploymentTaxation)
 }
 
 // TestBIP0009Mining ensures blocks built via btcd's CPU miner follow the rules
@@ -164,7 +164,9 @@ type TxPool struct {
 	mtx           sync.RWMutex
 	cfg           Config
 	pool          map[chainhash.Hash]*TxDesc
+	poolByWTxID   map[chainhash.Hash]*TxDesc
 	orphans       map[chainhash.Hash]*orphanTx
+	orphanWTxIDs  map[chainhash.Hash]struct{}
 	orphansByPrev map[wire.OutPoint]map[chainhash.Hash]*btcutil.Tx
 	outpoints     map[wire.OutPoint]*btcutil.Tx
 	pennyTotal    float64 // exponentially decaying total for penny spends.
@@ -219,6 +221,7 @@ func (mp *TxPool) removeOrphan(tx *btcutil.Tx, removeRedeemers bool) {
 
 	// Remove the transaction from the orphan pool.
 	delete(mp.orphans, *txHash)
+	delete(mp.orphanWTxIDs, *otx.tx.WitnessHash())
 }
 
 // RemoveOrphan removes the passed orphan transaction from the orphan pool and
@@ -320,6 +323,7 @@ func (mp *TxPool) addOrphan(tx *btcutil.Tx, tag Tag) {
 		tag:        tag,
 		expiration: time.Now().Add(orphanTTL),
 	}
+	mp.orphanWTxIDs[*tx.WitnessHash()] = struct{}{}
 	for _, txIn := range tx.MsgTx().TxIn {
 		if _, exists := mp.orphansByPrev[txIn.PreviousOutPoint]; !exists {
 			mp.orphansByPrev[txIn.PreviousOutPoint] =
@@ -447,6 +451,21 @@ func (mp *TxPool) HaveTransaction(hash *chainhash.Hash) bool {
 	return haveTx
 }
 
+// HaveTransactionByWTxID returns whether or not a transaction with the passed
+// witness transaction hash already exists in the main pool or in the orphan
+// pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) HaveTransactionByWTxID(wtxid *chainhash.Hash) bool {
+	// Protect concurrent access.
+	mp.mtx.RLock()
+	_, inPool := mp.poolByWTxID[*wtxid]
+	_, isOrphan := mp.orphanWTxIDs[*wtxid]
+	mp.mtx.RUnlock()
+
+	return inPool || isOrphan
+}
+
 // removeTransaction is the internal function which implements the public
 // RemoveTransaction.  See the comment for RemoveTransaction for more details.
 //
@@ -476,6 +495,7 @@ func (mp *TxPool) removeTransaction(tx *btcutil.Tx, removeRedeemers bool) {
 			delete(mp.outpoints, txIn.PreviousOutPoint)
 		}
 		delete(mp.pool, *txHash)
+		delete(mp.poolByWTxID, *txDesc.Tx.WitnessHash())
 		atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
 	}
 }
@@ -533,6 +553,7 @@ func (mp *TxPool) addTransaction(utxoView *blockchain.UtxoViewpoint, tx *btcutil
 	}
 
 	mp.pool[*tx.Hash()] = txD
+	mp.poolByWTxID[*tx.WitnessHash()] = txD
 	for _, txIn := range tx.MsgTx().TxIn {
 		mp.outpoints[txIn.PreviousOutPoint] = tx
 	}
@@ -606,7 +627,7 @@ func (mp *TxPool) fetchInputUtxos(tx *btcutil.Tx) (*blockchain.UtxoViewpoint, er
 			// AddTxOut ignores out of range index values, so it is
 			// safe to call without bounds checking here.
 			utxoView.AddTxOut(poolTxDesc.Tx, prevOut.Index,
-				mining.UnminedHeight)
+				mining.UnminedHeight, mp.cfg.ChainParams)
 		}
 	}
 
@@ -631,6 +652,123 @@ func (mp *TxPool) FetchTransaction(txHash *chainhash.Hash) (*btcutil.Tx, error)
 	return nil, fmt.Errorf("transaction is not in the pool")
 }
 
+// FetchTransactionByWTxID returns the transaction with the passed witness
+// transaction hash from the transaction pool.  This only fetches from the main
+// transaction pool and does not include orphans.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) FetchTransactionByWTxID(wtxid *chainhash.Hash) (*btcutil.Tx, error) {
+	// Protect concurrent access.
+	mp.mtx.RLock()
+	txDesc, exists := mp.poolByWTxID[*wtxid]
+	mp.mtx.RUnlock()
+
+	if exists {
+		return txDesc.Tx, nil
+	}
+
+	return nil, fmt.Errorf("transaction is not in the pool")
+}
+
+// checkStandaloneTransaction performs the context free consensus checks on the
+// passed standalone transaction.  The transaction must be sane and neither a
+// coinbase nor a tax transaction.
+func checkStandaloneTransaction(tx *btcutil.Tx) error {
+	// Perform preliminary sanity checks on the transaction.  This makes
+	// use of blockchain which contains the invariant rules for what
+	// transactions are allowed into blocks.
+	err := blockchain.CheckTransactionSanity(tx)
+	if err != nil {
+		if cerr, ok := err.(blockchain.RuleError); ok {
+			return chainRuleError(cerr)
+		}
+		return err
+	}
+
+	// A standalone transaction must not be a coinbase transaction.
+	txHash := tx.Hash()
+	if blockchain.IsCoinBase(tx) {
+		str := fmt.Sprintf("transaction %v is an individual coinbase",
+			txHash)
+		return txRuleError(wire.RejectInvalid, str)
+	}
+
+	// A transaction must not be a tax transaction.
+	if blockchain.IsTaxTransaction(tx) {
+		str := fmt.Sprintf("transaction %v is a tax transaction",
+			txHash)
+		return txRuleError(wire.RejectInvalid, str)
+	}
+
+	return nil
+}
+
+// checkTransactionInputs performs the consensus checks on the inputs of the
+// passed transaction, all of which must be available in the provided view,
+// for its inclusion in the next block, apart from validating its scripts.
+// It returns the fee and the signature operation cost of the transaction.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) checkTransactionInputs(tx *btcutil.Tx, utxoView *blockchain.UtxoViewpoint, nextBlockHeight int32, medianTimePast time.Time) (int64, int, error) {
+	// The taxation rules apply to the transaction once the taxation
+	// deployment is active for the next block.
+	taxationActive, err := mp.cfg.IsDeploymentActive(chaincfg.DeploymentTaxation)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Don't allow the transaction unless its sequence lock is active,
+	// meaning that it'll be allowed into the next block with respect to
+	// its defined relative lock times.
+	sequenceLock, err := mp.cfg.CalcSequenceLock(tx, utxoView)
+	if err != nil {
+		if cerr, ok := err.(blockchain.RuleError); ok {
+			return 0, 0, chainRuleError(cerr)
+		}
+		return 0, 0, err
+	}
+
+	if !blockchain.SequenceLockActive(sequenceLock, nextBlockHeight,
+		medianTimePast) {
+		return 0, 0, txRuleError(wire.RejectNonstandard,
+			"transaction's sequence locks on inputs not met")
+	}
+
+	// Perform several checks on the transaction inputs using the invariant
+	// rules in blockchain for what transactions are allowed into blocks.
+	txFee, err := blockchain.CheckTransactionInputs(tx, nextBlockHeight,
+		utxoView, mp.cfg.ChainParams, taxationActive)
+	if err != nil {
+		if cerr, ok := err.(blockchain.RuleError); ok {
+			return 0, 0, chainRuleError(cerr)
+		}
+		return 0, 0, err
+	}
+
+	// Don't allow transactions with more signature operations than fit
+	// into a block.
+	segwitActive, err := mp.cfg.IsDeploymentActive(chaincfg.DeploymentSegwit)
+	if err != nil {
+		return 0, 0, err
+	}
+	sigOpCost, err := blockchain.GetSigOpCost(tx, false, utxoView, true,
+		segwitActive)
+	if err != nil {
+		if cerr, ok := err.(blockchain.RuleError); ok {
+			return 0, 0, chainRuleError(cerr)
+		}
+		return 0, 0, err
+	}
+	if sigOpCost > blockchain.MaxBlockSigOpsCost {
+		str := fmt.Sprintf("transaction %v sigop cost is too high: "+
+			"%d > %d", tx.Hash(), sigOpCost,
+			blockchain.MaxBlockSigOpsCost)
+		return 0, 0, txRuleError(wire.RejectInvalid, str)
+	}
+
+	return txFee, sigOpCost, nil
+}
+
 // maybeAcceptTransaction is the internal function which implements the public
 // MaybeAcceptTransaction.  See the comment for MaybeAcceptTransaction for
 // more details.
@@ -666,31 +804,12 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 		return nil, nil, txRuleError(wire.RejectDuplicate, str)
 	}
 
-	// Perform preliminary sanity checks on the transaction.  This makes
-	// use of blockchain which contains the invariant rules for what
-	// transactions are allowed into blocks.
-	err := blockchain.CheckTransactionSanity(tx)
+	// Perform preliminary sanity checks on the transaction.
+	err := checkStandaloneTransaction(tx)
 	if err != nil {
-		if cerr, ok := err.(blockchain.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
-		}
 		return nil, nil, err
 	}
 
-	// A standalone transaction must not be a coinbase transaction.
-	if blockchain.IsCoinBase(tx) {
-		str := fmt.Sprintf("transaction %v is an individual coinbase",
-			txHash)
-		return nil, nil, txRuleError(wire.RejectInvalid, str)
-	}
-
-	// A transaction must not be a tax transaction.
-	if blockchain.IsTaxTransaction(tx) {
-		str := fmt.Sprintf("transaction %v is a tax transaction",
-			txHash)
-		return nil, nil, txRuleError(wire.RejectInvalid, str)
-	}
-
 	// Get the current height of the main chain.  A standalone transaction
 	// will be mined into the next block at best, so its height is at least
 	// one more than the current height.
@@ -744,12 +863,19 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 		return nil, nil, err
 	}
 
+	// The taxation rules apply to the transaction once the taxation
+	// deployment is active for the next block.
+	taxationActive, err := mp.cfg.IsDeploymentActive(chaincfg.DeploymentTaxation)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// All input utxos must be active
 	for _, input := range tx.MsgTx().TxIn {
 		entry := utxoView.LookupEntry(input.PreviousOutPoint)
 		// it's orphan if entry is nil
-		if entry != nil && mp.cfg.ChainParams.TaxationBeginHeight <= mp.cfg.BestHeight() &&
-			(mp.cfg.BestHeight()-entry.BlockHeight) > mp.cfg.ChainParams.ValidChainLength {
+		if entry != nil && taxationActive &&
+			entry.IsExpiredAt(nextBlockHeight) {
 			// Taxation needs to be activated for this check
 			return nil, nil, txRuleError(wire.RejectExpiredUtxo, "input utxo has expired")
 		}
@@ -787,32 +913,12 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 		return missingParents, nil, nil
 	}
 
-	// Don't allow the transaction into the mempool unless its sequence
-	// lock is active, meaning that it'll be allowed into the next block
-	// with respect to its defined relative lock times.
-	sequenceLock, err := mp.cfg.CalcSequenceLock(tx, utxoView)
-	if err != nil {
-		if cerr, ok := err.(blockchain.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
-		}
-		return nil, nil, err
-	}
-	if !blockchain.SequenceLockActive(sequenceLock, nextBlockHeight,
-		medianTimePast) {
-		return nil, nil, txRuleError(wire.RejectNonstandard,
-			"transaction's sequence locks on inputs not met")
-	}
-
-	// Perform several checks on the transaction inputs using the invariant
-	// rules in blockchain for what transactions are allowed into blocks.
-	// Also returns the fees associated with the transaction which will be
-	// used later.
-	txFee, err := blockchain.CheckTransactionInputs(tx, nextBlockHeight,
-		utxoView, mp.cfg.ChainParams)
+	// Perform the consensus checks on the transaction inputs.  Also
+	// returns the fees and signature operation cost associated with the
+	// transaction which will be used later.
+	txFee, sigOpCost, err := mp.checkTransactionInputs(tx, utxoView,
+		nextBlockHeight, medianTimePast)
 	if err != nil {
-		if cerr, ok := err.(blockchain.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
-		}
 		return nil, nil, err
 	}
 
@@ -843,14 +949,6 @@ func (mp *TxPool) maybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit, rejec
 	// the coinbase address itself can contain signature operations, the
 	// maximum allowed signature operations per transaction is less than
 	// the maximum allowed signature operations per block.
-	// TODO(roasbeef): last bool should be conditional on segwit activation
-	sigOpCost, err := blockchain.GetSigOpCost(tx, false, utxoView, true, true)
-	if err != nil {
-		if cerr, ok := err.(blockchain.RuleError); ok {
-			return nil, nil, chainRuleError(cerr)
-		}
-		return nil, nil, err
-	}
 	if sigOpCost > mp.cfg.Policy.MaxSigOpCostPerTx {
 		str := fmt.Sprintf("transaction %v sigop cost is too high: %d > %d",
 			txHash, sigOpCost, mp.cfg.Policy.MaxSigOpCostPerTx)
@@ -958,6 +1056,102 @@ func (mp *TxPool) MaybeAcceptTransaction(tx *btcutil.Tx, isNew, rateLimit bool)
 	return hashes, txD, err
 }
 
+// checkTransactionConsensus is the internal function which implements the
+// public CheckTransactionConsensus.  See the comment for
+// CheckTransactionConsensus for more details.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) checkTransactionConsensus(tx *btcutil.Tx) error {
+	// Transactions in the pool have already passed all of the checks.
+	txHash := tx.Hash()
+	if mp.isTransactionInPool(txHash) {
+		return nil
+	}
+
+	err := checkStandaloneTransaction(tx)
+	if err != nil {
+		return err
+	}
+
+	nextBlockHeight := mp.cfg.BestHeight() + 1
+	medianTimePast := mp.cfg.MedianTimePast()
+	if !blockchain.IsFinalizedTransaction(tx, nextBlockHeight,
+		medianTimePast) {
+
+		str := fmt.Sprintf("transaction %v is not finalized", txHash)
+		return txRuleError(wire.RejectInvalid, str)
+	}
+
+	utxoView, err := mp.fetchInputUtxos(tx)
+	if err != nil {
+		if cerr, ok := err.(blockchain.RuleError); ok {
+			return chainRuleError(cerr)
+		}
+		return err
+	}
+	for _, txIn := range tx.MsgTx().TxIn {
+		entry := utxoView.LookupEntry(txIn.PreviousOutPoint)
+		if entry == nil || entry.IsSpent() {
+			str := fmt.Sprintf("transaction %v spends unknown or "+
+				"spent output %v", txHash, txIn.PreviousOutPoint)
+			return txRuleError(wire.RejectInvalid, str)
+		}
+	}
+
+	_, _, err = mp.checkTransactionInputs(tx, utxoView, nextBlockHeight,
+		medianTimePast)
+	if err != nil {
+		return err
+	}
+
+	// Only enforce the script rules the consensus rules require for the
+	// next block as opposed to the standard verification flags.
+	scriptFlags := txscript.ScriptBip16 |
+		txscript.ScriptVerifyDERSignatures |
+		txscript.ScriptVerifyCheckLockTimeVerify
+	csvActive, err := mp.cfg.IsDeploymentActive(chaincfg.DeploymentCSV)
+	if err != nil {
+		return err
+	}
+	if csvActive {
+		scriptFlags |= txscript.ScriptVerifyCheckSequenceVerify
+	}
+	segwitActive, err := mp.cfg.IsDeploymentActive(chaincfg.DeploymentSegwit)
+	if err != nil {
+		return err
+	}
+	if segwitActive {
+		scriptFlags |= txscript.ScriptVerifyWitness |
+			txscript.ScriptStrictMultiSig
+	}
+	err = blockchain.ValidateTransactionScripts(tx, utxoView, scriptFlags,
+		mp.cfg.SigCache, mp.cfg.HashCache)
+	if err != nil {
+		if cerr, ok := err.(blockchain.RuleError); ok {
+			return chainRuleError(cerr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// CheckTransactionConsensus checks the passed transaction against the
+// consensus rules, including its input scripts, as they apply to the next
+// block without applying any of the local relay policy.  A transaction with
+// inputs which are not known to the main chain or the pool is considered
+// invalid.  This allows callers to determine whether a transaction which was
+// rejected by the pool was only rejected due to policy.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) CheckTransactionConsensus(tx *btcutil.Tx) error {
+	mp.mtx.RLock()
+	err := mp.checkTransactionConsensus(tx)
+	mp.mtx.RUnlock()
+
+	return err
+}
+
 // processOrphans is the internal function which implements the public
 // ProcessOrphans.  See the comment for ProcessOrphans for more details.
 //
@@ -1250,7 +1444,9 @@ func New(cfg *Config) *TxPool {
 	return &TxPool{
 		cfg:            *cfg,
 		pool:           make(map[chainhash.Hash]*TxDesc),
+		poolByWTxID:    make(map[chainhash.Hash]*TxDesc),
 		orphans:        make(map[chainhash.Hash]*orphanTx),
+		orphanWTxIDs:   make(map[chainhash.Hash]struct{}),
 		orphansByPrev:  make(map[wire.OutPoint]map[chainhash.Hash]*btcutil.Tx),
 		nextExpireScan: time.Now().Add(orphanExpireScanInterval),
 		outpoints:      make(map[wire.OutPoint]*btcutil.Tx),
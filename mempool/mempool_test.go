@@ -29,6 +29,7 @@ type fakeChain struct {
 	utxos          *blockchain.UtxoViewpoint
 	currentHeight  int32
 	medianTimePast time.Time
+	deployments    map[uint32]bool
 }
 
 // FetchUtxoView loads utxo details about the inputs referenced by the passed
@@ -95,6 +96,23 @@ func (s *fakeChain) SetMedianTimePast(mtp time.Time) {
 	s.Unlock()
 }
 
+// IsDeploymentActive returns whether the passed rule change deployment is
+// active for the next block of the fake chain instance.
+func (s *fakeChain) IsDeploymentActive(deploymentID uint32) (bool, error) {
+	s.RLock()
+	active := s.deployments[deploymentID]
+	s.RUnlock()
+	return active, nil
+}
+
+// SetDeploymentActive sets whether the passed rule change deployment is active
+// for the next block of the fake chain instance.
+func (s *fakeChain) SetDeploymentActive(deploymentID uint32, active bool) {
+	s.Lock()
+	s.deployments[deploymentID] = active
+	s.Unlock()
+}
+
 // CalcSequenceLock returns the current sequence lock for the passed
 // transaction associated with the fake chain instance.
 func (s *fakeChain) CalcSequenceLock(tx *btcutil.Tx,
@@ -299,7 +317,10 @@ func newPoolHarness(chainParams *chaincfg.Params) (*poolHarness, []spendableOutp
 	}
 
 	// Create a new fake chain and harness bound to it.
-	chain := &fakeChain{utxos: blockchain.NewUtxoViewpoint()}
+	chain := &fakeChain{
+		utxos:       blockchain.NewUtxoViewpoint(),
+		deployments: make(map[uint32]bool),
+	}
 	harness := poolHarness{
 		signKey:     signKey,
 		payAddr:     payAddr,
@@ -317,13 +338,14 @@ func newPoolHarness(chainParams *chaincfg.Params) (*poolHarness, []spendableOutp
 				MinRelayTxFee:        1000, // 1 Satoshi per byte
 				MaxTxVersion:         1,
 			},
-			ChainParams:      chainParams,
-			FetchUtxoView:    chain.FetchUtxoView,
-			BestHeight:       chain.BestHeight,
-			MedianTimePast:   chain.MedianTimePast,
-			CalcSequenceLock: chain.CalcSequenceLock,
-			SigCache:         nil,
-			AddrIndex:        nil,
+			ChainParams:        chainParams,
+			FetchUtxoView:      chain.FetchUtxoView,
+			BestHeight:         chain.BestHeight,
+			MedianTimePast:     chain.MedianTimePast,
+			CalcSequenceLock:   chain.CalcSequenceLock,
+			IsDeploymentActive: chain.IsDeploymentActive,
+			SigCache:           nil,
+			AddrIndex:          nil,
 		}),
 	}
 
@@ -339,7 +361,7 @@ func newPoolHarness(chainParams *chaincfg.Params) (*poolHarness, []spendableOutp
 	if err != nil {
 		return nil, nil, err
 	}
-	harness.chain.utxos.AddTxOuts(coinbase, curHeight+1)
+	harness.chain.utxos.AddTxOuts(coinbase, curHeight+1, chainParams)
 	for i := uint32(0); i < numOutputs; i++ {
 		outputs = append(outputs, txOutToSpendableOut(coinbase, i))
 	}
@@ -359,8 +381,9 @@ type testContext struct {
 // testPoolMembership tests the transaction pool associated with the provided
 // test context to determine if the passed transaction matches the provided
 // orphan pool and transaction pool status.  It also further determines if it
-// should be reported as available by the HaveTransaction function based upon
-// the two flags and tests that condition as well.
+// should be reported as available by the HaveTransaction and
+// HaveTransactionByWTxID functions based upon the two flags and tests that
+// condition as well.
 func testPoolMembership(tc *testContext, tx *btcutil.Tx, inOrphanPool, inTxPool bool) {
 	txHash := tx.Hash()
 	gotOrphanPool := tc.harness.txPool.IsOrphanInPool(txHash)
@@ -384,6 +407,20 @@ func testPoolMembership(tc *testContext, tx *btcutil.Tx, inOrphanPool, inTxPool
 		tc.t.Fatalf("%s:%d -- HaveTransaction: want %v, got %v", file,
 			line, wantHaveTx, gotHaveTx)
 	}
+
+	gotHaveWTx := tc.harness.txPool.HaveTransactionByWTxID(tx.WitnessHash())
+	if wantHaveTx != gotHaveWTx {
+		_, file, line, _ := runtime.Caller(1)
+		tc.t.Fatalf("%s:%d -- HaveTransactionByWTxID: want %v, got %v",
+			file, line, wantHaveTx, gotHaveWTx)
+	}
+
+	_, err := tc.harness.txPool.FetchTransactionByWTxID(tx.WitnessHash())
+	if inTxPool != (err == nil) {
+		_, file, line, _ := runtime.Caller(1)
+		tc.t.Fatalf("%s:%d -- FetchTransactionByWTxID: want found %v, "+
+			"got error %v", file, line, inTxPool, err)
+	}
 }
 
 // TestSimpleOrphanChain ensures that a simple chain of orphans is handled
@@ -866,3 +903,78 @@ func TestCheckSpend(t *testing.T) {
 		t.Fatalf("Unexpeced spend found in pool: %v", spend)
 	}
 }
+
+// TestCheckTransactionConsensus ensures transactions which are rejected by the
+// pool due to policy before their scripts are checked are only considered
+// valid by the consensus check when their scripts are valid.
+func TestCheckTransactionConsensus(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tc := &testContext{t, harness}
+
+	// Create a transaction with a version above the maximum allowed by the
+	// policy along with a copy of it with an invalid signature.
+	createTx := func(invalidSig bool) *btcutil.Tx {
+		tx := wire.NewMsgTx(2)
+		tx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: outputs[0].outPoint,
+			Sequence:         wire.MaxTxInSequenceNum,
+		})
+		tx.AddTxOut(&wire.TxOut{
+			PkScript: harness.payScript,
+			Value:    int64(outputs[0].amount) - 10000,
+		})
+		sigScript, err := txscript.SignatureScript(tx, 0,
+			harness.payScript, txscript.SigHashAll,
+			harness.signKey, true)
+		if err != nil {
+			t.Fatalf("unable to sign transaction: %v", err)
+		}
+		if invalidSig {
+			sigScript[10] ^= 0x01
+		}
+		tx.TxIn[0].SignatureScript = sigScript
+		return btcutil.NewTx(tx)
+	}
+
+	tests := []struct {
+		name  string
+		tx    *btcutil.Tx
+		valid bool
+	}{
+		{
+			name:  "valid non-standard version",
+			tx:    createTx(false),
+			valid: true,
+		},
+		{
+			name:  "non-standard version with invalid signature",
+			tx:    createTx(true),
+			valid: false,
+		},
+	}
+
+	for _, test := range tests {
+		// Both transactions are rejected due to the policy.
+		_, err := harness.txPool.ProcessTransaction(test.tx, false,
+			false, 0)
+		code, extracted := extractRejectCode(err)
+		if !extracted || code != wire.RejectNonstandard {
+			t.Fatalf("%s: unexpected rejection: %v", test.name, err)
+		}
+		testPoolMembership(tc, test.tx, false, false)
+
+		err = harness.txPool.CheckTransactionConsensus(test.tx)
+		if test.valid && err != nil {
+			t.Fatalf("%s: unexpected consensus error: %v",
+				test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Fatalf("%s: did not fail consensus check", test.name)
+		}
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/organicbitcoin/obtcd/blockchain"
+	"github.com/organicbitcoin/obtcd/btcec"
 	"github.com/organicbitcoin/obtcd/chaincfg"
 	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
 	"github.com/organicbitcoin/obtcd/mining"
@@ -61,6 +62,11 @@ type Config struct {
 	// blocks.  Each generated block will randomly choose one of them.
 	MiningAddrs []btcutil.Address
 
+	// SignetKeys are the keys used to sign the generated blocks so they
+	// provide a solution to the challenge of the network when mining on a
+	// signet.
+	SignetKeys []*btcec.PrivateKey
+
 	// ProcessBlock defines the function to call with any solved blocks.
 	// It typically must run the provided block through the same set of
 	// rules and handling as any other block coming from the network.
@@ -195,6 +201,23 @@ func (m *CPUMiner) submitBlock(block *btcutil.Block) bool {
 	return true
 }
 
+// signBlock signs the passed block with the signet keys when mining on a signet
+// since the solution to the challenge of the network commits to the block.  It
+// returns false when the block can't be signed.
+func (m *CPUMiner) signBlock(msgBlock *wire.MsgBlock) bool {
+	if m.cfg.ChainParams.SignetChallenge == nil {
+		return true
+	}
+
+	err := mining.SignSignetBlock(msgBlock, m.cfg.ChainParams,
+		m.cfg.SignetKeys)
+	if err != nil {
+		log.Errorf("Failed to sign signet block: %v", err)
+		return false
+	}
+	return true
+}
+
 // solveBlock attempts to find some combination of a nonce, extra nonce, and
 // current timestamp which makes the passed block hash to a value less than the
 // target difficulty.  The timestamp is updated periodically and the passed
@@ -233,6 +256,9 @@ func (m *CPUMiner) solveBlock(msgBlock *wire.MsgBlock, blockHeight int32,
 		// new value by regenerating the coinbase script and
 		// setting the merkle root to the new value.
 		m.g.UpdateExtraNonce(msgBlock, blockHeight, extraNonce+enOffset)
+		if !m.signBlock(msgBlock) {
+			return false
+		}
 
 		// Search through the entire nonce range for a solution while
 		// periodically checking for early quit and stale block
@@ -264,6 +290,9 @@ func (m *CPUMiner) solveBlock(msgBlock *wire.MsgBlock, blockHeight int32,
 				}
 
 				m.g.UpdateBlockTime(msgBlock)
+				if !m.signBlock(msgBlock) {
+					return false
+				}
 
 			default:
 				// Non-blocking select to fall through
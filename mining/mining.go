@@ -289,7 +289,7 @@ func createCoinbaseTx(params *chaincfg.Params, coinbaseScript []byte, nextBlockH
 // spendTransaction updates the passed view by marking the inputs to the passed
 // transaction as spent.  It also adds all outputs in the passed transaction
 // which are not provably unspendable as available unspent transaction outputs.
-func spendTransaction(utxoView *blockchain.UtxoViewpoint, tx *btcutil.Tx, height int32) error {
+func spendTransaction(utxoView *blockchain.UtxoViewpoint, tx *btcutil.Tx, height int32, chainParams *chaincfg.Params) error {
 	for _, txIn := range tx.MsgTx().TxIn {
 		entry := utxoView.LookupEntry(txIn.PreviousOutPoint)
 		if entry != nil {
@@ -297,7 +297,7 @@ func spendTransaction(utxoView *blockchain.UtxoViewpoint, tx *btcutil.Tx, height
 		}
 	}
 
-	utxoView.AddTxOuts(tx, height)
+	utxoView.AddTxOuts(tx, height, chainParams)
 	return nil
 }
 
@@ -612,8 +612,26 @@ mempoolLoop:
 	}
 	segwitActive := segwitState == blockchain.ThresholdActive
 
+	// Query the version bits state to see if taxation has been activated,
+	// in which case the transactions must not spend expired outputs and
+	// tax transactions must pay the expected tax.
+	taxationState, err := g.chain.ThresholdState(chaincfg.DeploymentTaxation)
+	if err != nil {
+		return nil, err
+	}
+	taxationActive := taxationState == blockchain.ThresholdActive
+
 	witnessIncluded := false
 
+	// Signet blocks always include a witness commitment in the coinbase
+	// transaction since it holds the solution of the block, so account
+	// for it up front along with the room needed by the solution.
+	if g.chainParams.SignetChallenge != nil {
+		blockWeight += uint32(witnessCommitmentWeight(coinbaseTx)) +
+			maxSignetSolutionSize*blockchain.WitnessScaleFactor
+		witnessIncluded = true
+	}
+
 	// Choose which transactions make it into the block.
 	for priorityQueue.Len() > 0 {
 		// Grab the highest priority (or highest fee per kilobyte
@@ -636,26 +654,8 @@ mempoolLoop:
 			// witness data, then we'll also need to include a
 			// witness commitment in the coinbase transaction.
 			// Therefore, we account for the additional weight
-			// within the block with a model coinbase tx with a
-			// witness commitment.
-			coinbaseCopy := btcutil.NewTx(coinbaseTx.MsgTx().Copy())
-			coinbaseCopy.MsgTx().TxIn[0].Witness = [][]byte{
-				bytes.Repeat([]byte("a"),
-					blockchain.CoinbaseWitnessDataLen),
-			}
-			coinbaseCopy.MsgTx().AddTxOut(&wire.TxOut{
-				PkScript: bytes.Repeat([]byte("a"),
-					blockchain.CoinbaseWitnessPkScriptLength),
-			})
-
-			// In order to accurately account for the weight
-			// addition due to this coinbase transaction, we'll add
-			// the difference of the transaction before and after
-			// the addition of the commitment to the block weight.
-			weightDiff := blockchain.GetTransactionWeight(coinbaseCopy) -
-				blockchain.GetTransactionWeight(coinbaseTx)
-
-			blockWeight += uint32(weightDiff)
+			// within the block.
+			blockWeight += uint32(witnessCommitmentWeight(coinbaseTx))
 
 			witnessIncluded = true
 		}
@@ -740,7 +740,7 @@ mempoolLoop:
 		// Ensure the transaction inputs pass all of the necessary
 		// preconditions before allowing it to be added to the block.
 		_, err = blockchain.CheckTransactionInputs(tx, nextBlockHeight,
-			blockUtxos, g.chainParams)
+			blockUtxos, g.chainParams, taxationActive)
 		if err != nil {
 			log.Tracef("Skipping tx %s due to error in "+
 				"CheckTransactionInputs: %v", tx.Hash(), err)
@@ -761,7 +761,8 @@ mempoolLoop:
 		// an entry for it to ensure any transactions which reference
 		// this one have it available as an input and can ensure they
 		// aren't double spending.
-		spendTransaction(blockUtxos, tx, nextBlockHeight)
+		spendTransaction(blockUtxos, tx, nextBlockHeight,
+			g.chainParams)
 
 		// Add the transaction to the block, increment counters, and
 		// save the fees and signature operation counts to the block
@@ -799,14 +800,20 @@ mempoolLoop:
 	txFees[0] = -totalFees
 
 	// If segwit is active and we included transactions with witness data,
-	// then we'll need to include a commitment to the witness data in an
-	// OP_RETURN output within the coinbase transaction.
+	// or the block is a signet block, then we'll need to include a
+	// commitment to the witness data in an OP_RETURN output within the
+	// coinbase transaction.
 	var witnessCommitment []byte
 	if witnessIncluded {
 		// The witness of the coinbase transaction MUST be exactly 32-bytes
-		// of all zeroes.
+		// of all zeroes.  It is only included once segwit is active
+		// since a signet block includes the commitment before that.
 		var witnessNonce [blockchain.CoinbaseWitnessDataLen]byte
-		coinbaseTx.MsgTx().TxIn[0].Witness = wire.TxWitness{witnessNonce[:]}
+		if segwitActive {
+			coinbaseTx.MsgTx().TxIn[0].Witness = wire.TxWitness{
+				witnessNonce[:],
+			}
+		}
 
 		// Next, obtain the merkle root of a tree which consists of the
 		// wtxid of all transactions in the block. The coinbase
@@ -895,6 +902,26 @@ mempoolLoop:
 	}, nil
 }
 
+// witnessCommitmentWeight returns the weight a witness commitment adds to the
+// passed coinbase transaction along with the witness nonce it requires.  It is
+// calculated with a model coinbase transaction which includes them.
+func witnessCommitmentWeight(coinbaseTx *btcutil.Tx) int64 {
+	coinbaseCopy := btcutil.NewTx(coinbaseTx.MsgTx().Copy())
+	coinbaseCopy.MsgTx().TxIn[0].Witness = [][]byte{
+		bytes.Repeat([]byte("a"), blockchain.CoinbaseWitnessDataLen),
+	}
+	coinbaseCopy.MsgTx().AddTxOut(&wire.TxOut{
+		PkScript: bytes.Repeat([]byte("a"),
+			blockchain.CoinbaseWitnessPkScriptLength),
+	})
+
+	// In order to accurately account for the weight addition due to this
+	// coinbase transaction, the difference of the transaction before and
+	// after the addition of the commitment is returned.
+	return blockchain.GetTransactionWeight(coinbaseCopy) -
+		blockchain.GetTransactionWeight(coinbaseTx)
+}
+
 // UpdateBlockTime updates the timestamp in the header of the passed block to
 // the current time while taking into account the median time of the last
 // several blocks to ensure the new time is after that time per the chain
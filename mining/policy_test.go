@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/organicbitcoin/obtcd/blockchain"
+	"github.com/organicbitcoin/obtcd/chaincfg"
 	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
 	"github.com/organicbitcoin/obtcd/wire"
 	"github.com/organicbitcoin/btcutil"
@@ -49,7 +50,8 @@ func newUtxoViewpoint(sourceTxns []*wire.MsgTx, sourceTxHeights []int32) *blockc
 
 	view := blockchain.NewUtxoViewpoint()
 	for i, tx := range sourceTxns {
-		view.AddTxOuts(btcutil.NewTx(tx), sourceTxHeights[i])
+		view.AddTxOuts(btcutil.NewTx(tx), sourceTxHeights[i],
+			&chaincfg.MainNetParams)
 	}
 	return view
 }
@@ -0,0 +1,133 @@
+// Copyright (c) 2014-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mining
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/organicbitcoin/obtcd/blockchain"
+	"github.com/organicbitcoin/obtcd/btcec"
+	"github.com/organicbitcoin/obtcd/chaincfg"
+	"github.com/organicbitcoin/obtcd/txscript"
+	"github.com/organicbitcoin/obtcd/wire"
+	"github.com/organicbitcoin/btcutil"
+)
+
+// maxSignetSolutionSize is the number of bytes reserved in signet block
+// templates for the solution which is added to the coinbase transaction once
+// the block is signed.  It is enough for the solutions to the challenges
+// SignSignetBlock supports.
+const maxSignetSolutionSize = 512
+
+// signetKey returns the key out of the passed ones which the passed address
+// pays to along with whether the address uses its compressed public key.
+func signetKey(addr btcutil.Address, keys []*btcec.PrivateKey) (*btcec.PrivateKey, bool, error) {
+	for _, key := range keys {
+		pubKey := key.PubKey()
+		for _, compressed := range []bool{true, false} {
+			var serialized []byte
+			if compressed {
+				serialized = pubKey.SerializeCompressed()
+			} else {
+				serialized = pubKey.SerializeUncompressed()
+			}
+
+			var match bool
+			switch addr := addr.(type) {
+			case *btcutil.AddressPubKey:
+				match = bytes.Equal(addr.ScriptAddress(), serialized)
+			case *btcutil.AddressPubKeyHash,
+				*btcutil.AddressWitnessPubKeyHash:
+
+				match = bytes.Equal(addr.ScriptAddress(),
+					btcutil.Hash160(serialized))
+			}
+			if match {
+				return key, compressed, nil
+			}
+		}
+	}
+
+	return nil, false, fmt.Errorf("no signet key for address %v", addr)
+}
+
+// SignSignetBlock signs the passed block with the passed keys so it provides a
+// valid solution to the challenge of the signet defined by the passed network
+// parameters as defined by BIP0325.  The block must include a witness
+// commitment, which block templates generated for a signet always do.
+//
+// The block has to be signed again whenever its header or the transactions it
+// includes change, except for its nonce and bits, since the solution commits
+// to them.  Pay-to-pubkey, pay-to-pubkey-hash, bare multisig and
+// pay-to-witness-pubkey-hash challenges are supported.
+func SignSignetBlock(msgBlock *wire.MsgBlock, params *chaincfg.Params, keys []*btcec.PrivateKey) error {
+	challenge := params.SignetChallenge
+	if challenge == nil {
+		return errors.New("not a signet network")
+	}
+
+	// The solution commits to the block with the signet header left in
+	// place of the solution, so store an empty solution before signing in
+	// case the block does not hold one yet.
+	if err := blockchain.SetSignetSolution(msgBlock, nil, nil); err != nil {
+		return err
+	}
+	_, toSign, err := blockchain.SignetTxs(msgBlock, challenge)
+	if err != nil {
+		return err
+	}
+	class, addrs, _, err := txscript.ExtractPkScriptAddrs(challenge, params)
+	if err != nil {
+		return err
+	}
+
+	var sigScript []byte
+	var witness wire.TxWitness
+	switch class {
+	case txscript.PubKeyTy, txscript.PubKeyHashTy, txscript.MultiSigTy:
+		getKey := txscript.KeyClosure(func(addr btcutil.Address) (*btcec.PrivateKey, bool, error) {
+			return signetKey(addr, keys)
+		})
+		sigScript, err = txscript.SignTxOutput(params, toSign, 0,
+			challenge, txscript.SigHashAll, getKey, nil, nil)
+		if err != nil {
+			return err
+		}
+
+	case txscript.WitnessV0PubKeyHashTy:
+		key, compressed, err := signetKey(addrs[0], keys)
+		if err != nil {
+			return err
+		}
+		witness, err = txscript.WitnessSignature(toSign,
+			txscript.NewTxSigHashes(toSign), 0, 0, challenge,
+			txscript.SigHashAll, key, compressed)
+		if err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("unsupported signet challenge of type %v",
+			class)
+	}
+
+	// Ensure the solution is valid since a multisig challenge is only
+	// partially signed when some of the keys are missing.
+	toSign.TxIn[0].SignatureScript = sigScript
+	toSign.TxIn[0].Witness = witness
+	vm, err := txscript.NewEngine(challenge, toSign, 0,
+		txscript.StandardVerifyFlags, nil, txscript.NewTxSigHashes(toSign),
+		0)
+	if err == nil {
+		err = vm.Execute()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to solve the signet challenge: %v", err)
+	}
+
+	return blockchain.SetSignetSolution(msgBlock, sigScript, witness)
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mining
+
+import (
+	"testing"
+	"time"
+
+	"github.com/organicbitcoin/obtcd/blockchain"
+	"github.com/organicbitcoin/obtcd/btcec"
+	"github.com/organicbitcoin/obtcd/chaincfg"
+	"github.com/organicbitcoin/obtcd/txscript"
+	"github.com/organicbitcoin/obtcd/wire"
+	"github.com/organicbitcoin/btcutil"
+)
+
+// TestSignSignetBlock ensures blocks signed with SignSignetBlock provide a
+// valid solution to the supported kinds of signet challenges.
+func TestSignSignetBlock(t *testing.T) {
+	t.Parallel()
+
+	keys := make([]*btcec.PrivateKey, 3)
+	for i := range keys {
+		var err error
+		keys[i], err = btcec.NewPrivateKey(btcec.S256())
+		if err != nil {
+			t.Fatalf("NewPrivateKey: unexpected error: %v", err)
+		}
+	}
+	params := chaincfg.SigNetParams(nil)
+	pubKey := keys[0].PubKey().SerializeCompressed()
+	otherPubKey := keys[1].PubKey().SerializeCompressed()
+
+	// newChallenge returns the script paying to the passed address.
+	newChallenge := func(addr btcutil.Address, err error) []byte {
+		if err != nil {
+			t.Fatalf("unexpected address error: %v", err)
+		}
+		script, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			t.Fatalf("PayToAddrScript: unexpected error: %v", err)
+		}
+		return script
+	}
+	pubKeyAddr, err := btcutil.NewAddressPubKey(pubKey, &params)
+	if err != nil {
+		t.Fatalf("NewAddressPubKey: unexpected error: %v", err)
+	}
+	otherPubKeyAddr, err := btcutil.NewAddressPubKey(otherPubKey, &params)
+	if err != nil {
+		t.Fatalf("NewAddressPubKey: unexpected error: %v", err)
+	}
+	multiSig, err := txscript.MultiSigScript([]*btcutil.AddressPubKey{
+		otherPubKeyAddr, pubKeyAddr}, 1)
+	if err != nil {
+		t.Fatalf("MultiSigScript: unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		challenge []byte
+	}{{
+		name:      "pay to pubkey",
+		challenge: newChallenge(pubKeyAddr, nil),
+	}, {
+		name: "pay to pubkey hash",
+		challenge: newChallenge(btcutil.NewAddressPubKeyHash(
+			btcutil.Hash160(pubKey), &params)),
+	}, {
+		name:      "multisig",
+		challenge: multiSig,
+	}, {
+		name: "pay to witness pubkey hash",
+		challenge: newChallenge(btcutil.NewAddressWitnessPubKeyHash(
+			btcutil.Hash160(pubKey), &params)),
+	}}
+
+	for _, test := range tests {
+		params := chaincfg.SigNetParams(test.challenge)
+
+		// Create a block with a witness commitment to hold the
+		// solution.
+		coinbase := wire.NewMsgTx(wire.TxVersion)
+		coinbase.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+			SignatureScript:  []byte{txscript.OP_1, txscript.OP_1},
+		})
+		coinbase.AddTxOut(wire.NewTxOut(0, append(append([]byte(nil),
+			blockchain.WitnessMagicBytes...), make([]byte, 32)...)))
+		block := &wire.MsgBlock{
+			Header: wire.BlockHeader{
+				Version:    1,
+				PrevBlock:  *params.GenesisHash,
+				MerkleRoot: coinbase.TxHash(),
+				Timestamp:  time.Unix(1600000000, 0),
+				Bits:       params.PowLimitBits,
+			},
+			Transactions: []*wire.MsgTx{coinbase},
+		}
+
+		// Ensure the block can't be signed by an unrelated key.
+		err := SignSignetBlock(block, &params, keys[2:])
+		if err == nil {
+			t.Errorf("%s: block signed by unrelated key", test.name)
+			continue
+		}
+
+		// Sign the block and ensure the solution is valid.
+		if err := SignSignetBlock(block, &params, keys[:2]); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		toSpend, toSign, err := blockchain.SignetTxs(block,
+			test.challenge)
+		if err != nil {
+			t.Errorf("%s: SignetTxs: unexpected error: %v",
+				test.name, err)
+			continue
+		}
+		vm, err := txscript.NewEngine(toSpend.TxOut[0].PkScript, toSign,
+			0, txscript.StandardVerifyFlags, nil,
+			txscript.NewTxSigHashes(toSign), 0)
+		if err == nil {
+			err = vm.Execute()
+		}
+		if err != nil {
+			t.Errorf("%s: invalid solution: %v", test.name, err)
+		}
+	}
+}
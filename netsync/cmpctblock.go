@@ -0,0 +1,171 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/organicbitcoin/obtcd/blockchain"
+	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
+	"github.com/organicbitcoin/obtcd/wire"
+	"github.com/organicbitcoin/btcutil"
+)
+
+const (
+	// maxHighBandwidthPeers is the maximum number of peers which are asked
+	// to announce new blocks by sending compact blocks directly.
+	maxHighBandwidthPeers = 3
+)
+
+var (
+	// errShortIDCollision is returned when a compact block contains the
+	// same short transaction id more than once, in which case the block
+	// can't be reconstructed from it.
+	errShortIDCollision = errors.New("compact block contains duplicate " +
+		"short transaction ids")
+
+	// errReconstructedMerkleRoot is returned when the merkle root of a
+	// reconstructed block doesn't match the one committed to in its
+	// header, which happens when a short transaction id matched the
+	// wrong transaction.
+	errReconstructedMerkleRoot = errors.New("reconstructed block does " +
+		"not match merkle root")
+)
+
+// partialBlock houses a block which is being reconstructed from a compact
+// block.  Transactions which could not be found in the memory pool are
+// requested from the peer which sent the compact block via a getblocktxn
+// message.
+type partialBlock struct {
+	header  wire.BlockHeader
+	txns    []*wire.MsgTx
+	missing []uint32
+}
+
+// newPartialBlock fills as many transactions of the passed compact block as
+// possible from the prefilled transactions and the passed candidate
+// transactions, which are typically the contents of the memory pool.  Short
+// transaction ids are computed from witness transaction hashes when useWTxID
+// is set.
+//
+// Candidate transactions whose short ids match more than one candidate are
+// treated as missing so they are requested from the peer instead of risking
+// reconstruction of an invalid block.
+func newPartialBlock(msg *wire.MsgCmpctBlock, candidates []*btcutil.Tx,
+	useWTxID bool) (*partialBlock, error) {
+
+	numTxns := msg.TxCount()
+	if numTxns == 0 {
+		return nil, errors.New("compact block contains no transactions")
+	}
+	pb := &partialBlock{
+		header: msg.Header,
+		txns:   make([]*wire.MsgTx, numTxns),
+	}
+
+	// Place the prefilled transactions at their absolute indexes.
+	for _, ptx := range msg.PrefilledTxns {
+		if int(ptx.Index) >= numTxns {
+			str := fmt.Sprintf("prefilled transaction index %d is "+
+				"out of range for %d transactions", ptx.Index,
+				numTxns)
+			return nil, errors.New(str)
+		}
+		if pb.txns[ptx.Index] != nil {
+			str := fmt.Sprintf("duplicate prefilled transaction "+
+				"index %d", ptx.Index)
+			return nil, errors.New(str)
+		}
+		pb.txns[ptx.Index] = ptx.Tx
+	}
+
+	// Map the short ids to the remaining indexes in order.
+	slots := make(map[uint64]int, len(msg.ShortIDs))
+	next := 0
+	for _, id := range msg.ShortIDs {
+		for pb.txns[next] != nil {
+			next++
+		}
+		if _, ok := slots[id]; ok {
+			return nil, errShortIDCollision
+		}
+		slots[id] = next
+		next++
+	}
+
+	// Match the candidate transactions against the short ids.
+	key := msg.ShortTxIDKey()
+	ambiguous := make(map[int]struct{})
+	for _, tx := range candidates {
+		var hash *chainhash.Hash
+		if useWTxID {
+			hash = tx.WitnessHash()
+		} else {
+			hash = tx.Hash()
+		}
+		idx, ok := slots[key.ShortTxID(hash)]
+		if !ok {
+			continue
+		}
+		if pb.txns[idx] != nil {
+			ambiguous[idx] = struct{}{}
+			continue
+		}
+		pb.txns[idx] = tx.MsgTx()
+	}
+	for idx := range ambiguous {
+		pb.txns[idx] = nil
+	}
+
+	for i, tx := range pb.txns {
+		if tx == nil {
+			pb.missing = append(pb.missing, uint32(i))
+		}
+	}
+	return pb, nil
+}
+
+// fillMissing completes the partial block with the passed transactions, which
+// must be the missing transactions in index order as returned in a blocktxn
+// message.
+func (pb *partialBlock) fillMissing(txns []*wire.MsgTx) error {
+	if len(txns) != len(pb.missing) {
+		str := fmt.Sprintf("got %d transactions, want %d", len(txns),
+			len(pb.missing))
+		return errors.New(str)
+	}
+	for i, idx := range pb.missing {
+		pb.txns[idx] = txns[i]
+	}
+	pb.missing = nil
+	return nil
+}
+
+// block returns the reconstructed block once all of its transactions are
+// known.  An error is returned when the transactions don't match the merkle
+// root or witness commitment of the block, which indicates a short id matched
+// the wrong transaction and the full block must be downloaded instead.
+func (pb *partialBlock) block() (*btcutil.Block, error) {
+	if len(pb.missing) != 0 {
+		str := fmt.Sprintf("block is missing %d transactions",
+			len(pb.missing))
+		return nil, errors.New(str)
+	}
+
+	msgBlock := &wire.MsgBlock{
+		Header:       pb.header,
+		Transactions: pb.txns,
+	}
+	block := btcutil.NewBlock(msgBlock)
+	merkles := blockchain.BuildMerkleTreeStore(block.Transactions(), false)
+	if !merkles[len(merkles)-1].IsEqual(&pb.header.MerkleRoot) {
+		return nil, errReconstructedMerkleRoot
+	}
+	if err := blockchain.ValidateWitnessCommitment(block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"testing"
+
+	"github.com/organicbitcoin/obtcd/blockchain"
+	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
+	"github.com/organicbitcoin/obtcd/wire"
+	"github.com/organicbitcoin/btcutil"
+)
+
+// testCmpctBlock returns a block with a coinbase and numTxns additional
+// distinct transactions along with a valid merkle root.
+func testCmpctBlock(numTxns int) *wire.MsgBlock {
+	msgBlock := &wire.MsgBlock{}
+	for i := 0; i <= numTxns; i++ {
+		tx := wire.NewMsgTx(wire.TxVersion)
+		prevOut := wire.NewOutPoint(&chainhash.Hash{byte(i + 1)}, 0)
+		if i == 0 {
+			prevOut = wire.NewOutPoint(&chainhash.Hash{},
+				wire.MaxPrevOutIndex)
+		}
+		tx.AddTxIn(wire.NewTxIn(prevOut, []byte{0x51}, nil))
+		tx.AddTxOut(wire.NewTxOut(int64(i+1)*1000, []byte{0x51}))
+		msgBlock.AddTransaction(tx)
+	}
+
+	merkles := blockchain.BuildMerkleTreeStore(
+		btcutil.NewBlock(msgBlock).Transactions(), false)
+	msgBlock.Header.MerkleRoot = *merkles[len(merkles)-1]
+	return msgBlock
+}
+
+// TestPartialBlockReconstruction ensures blocks are reconstructed from compact
+// blocks using known transactions and that the missing ones are requested.
+func TestPartialBlockReconstruction(t *testing.T) {
+	msgBlock := testCmpctBlock(5)
+	block := btcutil.NewBlock(msgBlock)
+	txns := block.Transactions()
+	cmsg := wire.NewMsgCmpctBlock(msgBlock, 42, []int{3}, true)
+
+	// The memory pool holds transactions 1 and 4 along with an unrelated
+	// transaction.  Transaction 3 is prefilled.
+	unrelated := btcutil.NewTx(testCmpctBlock(8).Transactions[8])
+	candidates := []*btcutil.Tx{txns[4], unrelated, txns[1]}
+	pb, err := newPartialBlock(cmsg, candidates, true)
+	if err != nil {
+		t.Fatalf("newPartialBlock: unexpected error: %v", err)
+	}
+	wantMissing := []uint32{2, 5}
+	if len(pb.missing) != len(wantMissing) {
+		t.Fatalf("missing: got %v, want %v", pb.missing, wantMissing)
+	}
+	for i, idx := range wantMissing {
+		if pb.missing[i] != idx {
+			t.Fatalf("missing: got %v, want %v", pb.missing,
+				wantMissing)
+		}
+	}
+	if _, err := pb.block(); err == nil {
+		t.Fatal("block: expected error for incomplete block")
+	}
+
+	// The wrong number of transactions is rejected.
+	if err := pb.fillMissing(msgBlock.Transactions[2:3]); err == nil {
+		t.Fatal("fillMissing: expected error for wrong count")
+	}
+
+	err = pb.fillMissing([]*wire.MsgTx{msgBlock.Transactions[2],
+		msgBlock.Transactions[5]})
+	if err != nil {
+		t.Fatalf("fillMissing: unexpected error: %v", err)
+	}
+	got, err := pb.block()
+	if err != nil {
+		t.Fatalf("block: unexpected error: %v", err)
+	}
+	if *got.Hash() != *block.Hash() {
+		t.Fatalf("block: got hash %v, want %v", got.Hash(), block.Hash())
+	}
+
+	// A block which is fully known is complete immediately.  Version 1
+	// compact blocks use transaction hashes.
+	cmsg = wire.NewMsgCmpctBlock(msgBlock, 7, nil, false)
+	pb, err = newPartialBlock(cmsg, txns, false)
+	if err != nil {
+		t.Fatalf("newPartialBlock: unexpected error: %v", err)
+	}
+	if len(pb.missing) != 0 {
+		t.Fatalf("missing: got %v, want none", pb.missing)
+	}
+	if _, err := pb.block(); err != nil {
+		t.Fatalf("block: unexpected error: %v", err)
+	}
+}
+
+// TestPartialBlockFallback ensures compact blocks which can't be reconstructed
+// are detected so the full block can be requested instead.
+func TestPartialBlockFallback(t *testing.T) {
+	msgBlock := testCmpctBlock(3)
+	txns := btcutil.NewBlock(msgBlock).Transactions()
+
+	// Duplicate short ids can't be resolved.
+	cmsg := wire.NewMsgCmpctBlock(msgBlock, 1, nil, true)
+	cmsg.ShortIDs[1] = cmsg.ShortIDs[0]
+	if _, err := newPartialBlock(cmsg, txns, true); err != errShortIDCollision {
+		t.Fatalf("newPartialBlock: got error %v, want %v", err,
+			errShortIDCollision)
+	}
+
+	// Prefilled transactions must be within the block.
+	cmsg = wire.NewMsgCmpctBlock(msgBlock, 1, nil, true)
+	cmsg.PrefilledTxns[0].Index = 10
+	if _, err := newPartialBlock(cmsg, txns, true); err == nil {
+		t.Fatal("newPartialBlock: expected error for out of range " +
+			"prefilled transaction")
+	}
+
+	// Two candidates with the same short id leave the transaction missing.
+	cmsg = wire.NewMsgCmpctBlock(msgBlock, 1, nil, true)
+	pb, err := newPartialBlock(cmsg, []*btcutil.Tx{txns[2], txns[2]}, true)
+	if err != nil {
+		t.Fatalf("newPartialBlock: unexpected error: %v", err)
+	}
+	if len(pb.missing) != 3 {
+		t.Fatalf("missing: got %v, want 3 transactions", pb.missing)
+	}
+
+	// Transactions which don't match the merkle root are detected.
+	err = pb.fillMissing([]*wire.MsgTx{msgBlock.Transactions[1],
+		msgBlock.Transactions[3], msgBlock.Transactions[2]})
+	if err != nil {
+		t.Fatalf("fillMissing: unexpected error: %v", err)
+	}
+	if _, err := pb.block(); err != errReconstructedMerkleRoot {
+		t.Fatalf("block: got error %v, want %v", err,
+			errReconstructedMerkleRoot)
+	}
+}
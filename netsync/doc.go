@@ -6,8 +6,18 @@
 Package netsync implements a concurrency safe block syncing protocol. The
 SyncManager communicates with connected peers to perform an initial block
 download, keep the chain and unconfirmed transaction pool in sync, and announce
-new blocks connected to the chain. Currently the sync manager selects a single
-sync peer that it downloads all blocks from until it is up to date with the
-longest chain the sync peer is aware of.
+new blocks connected to the chain. The sync manager selects a single sync peer
+that it downloads block headers from until it is up to date with the longest
+chain the sync peer is aware of. The blocks those headers describe are
+downloaded in parallel from all suitable peers within a sliding window, with
+stalled requests reassigned to other peers, and are processed in order as they
+become available.
+
+Once the chain is current, new blocks are requested as compact blocks (BIP0152)
+from peers which support them and are reconstructed from the transactions in
+the memory pool.  Only the transactions which are not already known are
+requested from the peer, and the full block is requested whenever a compact
+block can't be reconstructed.  The peers which most recently delivered new
+blocks first are asked to send compact blocks without announcing them first.
 */
 package netsync
@@ -0,0 +1,381 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"sort"
+	"time"
+
+	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
+	"github.com/organicbitcoin/obtcd/wire"
+	"github.com/organicbitcoin/btcutil"
+)
+
+const (
+	// blockDownloadWindow is the maximum number of blocks, starting with
+	// the next block to be processed, that may be requested or buffered
+	// at once.  Blocks which arrive out of order are held in memory until
+	// all of their ancestors have been processed, so this also bounds the
+	// memory used by the download queue.
+	blockDownloadWindow = 512
+
+	// maxBlocksInFlightPerPeer is the maximum number of blocks that may be
+	// requested from a single peer at once.
+	maxBlocksInFlightPerPeer = 16
+
+	// blockStallTimeout is the duration a block request may remain
+	// outstanding before it is considered stalled and reassigned to
+	// another peer.
+	blockStallTimeout = 30 * time.Second
+
+	// stallSampleInterval is the interval at which outstanding block
+	// requests are checked for stalls.
+	stallSampleInterval = 5 * time.Second
+
+	// stalledPeerTimeout is the duration a peer may remain stalled without
+	// delivering any of the blocks requested from it before it is
+	// disconnected so a single unresponsive peer can't halt the download.
+	stalledPeerTimeout = 2 * time.Minute
+
+	// invalidBlockBanScore is the ban score given to a peer which delivers
+	// a block that violates the consensus rules during the block download.
+	invalidBlockBanScore = 100
+)
+
+// downloadPeer is the subset of peer functionality the block downloader
+// requires.  It is implemented by *peer.Peer and allows the download
+// scheduling to be exercised independently of real network connections.
+type downloadPeer interface {
+	ID() int32
+	LastBlock() int32
+	IsWitnessEnabled() bool
+	QueueMessage(msg wire.Message, doneChan chan<- struct{})
+}
+
+// downloadResult describes how the block downloader handled a block received
+// from a peer.
+type downloadResult int
+
+const (
+	// downloadUnrequested indicates the block was never requested from
+	// the peer that sent it.
+	downloadUnrequested downloadResult = iota
+
+	// downloadIgnored indicates the block was requested from the peer, but
+	// the request had already been abandoned due to a stall or a reset of
+	// the download queue, and the block is no longer needed.
+	downloadIgnored
+
+	// downloadAccepted indicates the block was added to the download
+	// queue and will be returned from popReady once all of its ancestors
+	// have been processed.
+	downloadAccepted
+)
+
+// blockRequest tracks the download state of a single block in the download
+// queue.
+type blockRequest struct {
+	height    int32
+	hash      chainhash.Hash
+	peer      downloadPeer
+	requested time.Time
+	block     *btcutil.Block
+	from      downloadPeer
+}
+
+// downloadPeerState houses the per-peer state tracked by the block
+// downloader.
+type downloadPeerState struct {
+	inFlight     map[chainhash.Hash]*blockRequest
+	abandoned    map[chainhash.Hash]struct{}
+	stalled      bool
+	stalledSince time.Time
+}
+
+// blockDownloader schedules the download of a contiguous sequence of blocks
+// learned from headers across all available peers.  Blocks within a sliding
+// window beginning at the next block to be processed are spread across the
+// peers, requests that are not answered in time are reassigned, and blocks
+// are handed back strictly in height order regardless of the order in which
+// they arrive.
+//
+// The block downloader is not safe for concurrent access.  The sync manager
+// only accesses it from the block handler goroutine.
+type blockDownloader struct {
+	window             int
+	maxPerPeer         int
+	stallTimeout       time.Duration
+	stalledPeerTimeout time.Duration
+
+	// requests holds the blocks which have not yet been processed in
+	// height order.  byHash indexes the same entries by block hash.
+	requests []*blockRequest
+	byHash   map[chainhash.Hash]*blockRequest
+	peers    map[downloadPeer]*downloadPeerState
+}
+
+// newBlockDownloader returns a block downloader which keeps at most window
+// blocks requested or buffered, requests at most maxPerPeer blocks from each
+// peer at once, reassigns requests that remain outstanding for longer than
+// stallTimeout, and gives up on peers which remain stalled for longer than
+// stalledPeerTimeout.
+func newBlockDownloader(window, maxPerPeer int, stallTimeout,
+	stalledPeerTimeout time.Duration) *blockDownloader {
+
+	return &blockDownloader{
+		window:             window,
+		maxPerPeer:         maxPerPeer,
+		stallTimeout:       stallTimeout,
+		stalledPeerTimeout: stalledPeerTimeout,
+		byHash:             make(map[chainhash.Hash]*blockRequest),
+		peers:              make(map[downloadPeer]*downloadPeerState),
+	}
+}
+
+// addPeer makes the passed peer available for block requests.
+func (d *blockDownloader) addPeer(p downloadPeer) {
+	if _, ok := d.peers[p]; ok {
+		return
+	}
+	d.peers[p] = &downloadPeerState{
+		inFlight:  make(map[chainhash.Hash]*blockRequest),
+		abandoned: make(map[chainhash.Hash]struct{}),
+	}
+}
+
+// removePeer removes the passed peer from the downloader.  Any blocks that
+// were in flight from the peer become eligible to be requested from other
+// peers.
+func (d *blockDownloader) removePeer(p downloadPeer) {
+	state, ok := d.peers[p]
+	if !ok {
+		return
+	}
+	for _, req := range state.inFlight {
+		req.peer = nil
+	}
+	delete(d.peers, p)
+}
+
+// addBlock appends the block with the passed hash and height to the download
+// queue.  Blocks must be added in height order.
+func (d *blockDownloader) addBlock(hash *chainhash.Hash, height int32) {
+	if _, ok := d.byHash[*hash]; ok {
+		return
+	}
+	req := &blockRequest{height: height, hash: *hash}
+	d.requests = append(d.requests, req)
+	d.byHash[*hash] = req
+}
+
+// queued returns the number of blocks in the download queue which have not
+// yet been returned by popReady.
+func (d *blockDownloader) queued() int {
+	return len(d.requests)
+}
+
+// inFlight returns the number of blocks currently requested from the passed
+// peer.
+func (d *blockDownloader) inFlight(p downloadPeer) int {
+	state, ok := d.peers[p]
+	if !ok {
+		return 0
+	}
+	return len(state.inFlight)
+}
+
+// pickPeer returns the peer to request the block at the passed height from, or
+// nil when no peer is able to serve it.  The least loaded peer which is not
+// stalled, has not reached its request limit, and claims to have the block is
+// chosen, with ties broken by peer id for determinism.
+func (d *blockDownloader) pickPeer(height int32) downloadPeer {
+	var best downloadPeer
+	var bestState *downloadPeerState
+	for p, state := range d.peers {
+		if state.stalled || len(state.inFlight) >= d.maxPerPeer ||
+			p.LastBlock() < height {
+
+			continue
+		}
+		if best == nil || len(state.inFlight) < len(bestState.inFlight) ||
+			(len(state.inFlight) == len(bestState.inFlight) &&
+				p.ID() < best.ID()) {
+
+			best, bestState = p, state
+		}
+	}
+	return best
+}
+
+// requestBlocks assigns every block within the download window that has not
+// been requested or received to a peer and sends the resulting getdata
+// messages.  It returns the number of blocks requested.
+func (d *blockDownloader) requestBlocks(now time.Time) int {
+	limit := len(d.requests)
+	if limit > d.window {
+		limit = d.window
+	}
+
+	var peers []downloadPeer
+	msgs := make(map[downloadPeer]*wire.MsgGetData)
+	numRequested := 0
+	for _, req := range d.requests[:limit] {
+		if req.peer != nil || req.block != nil {
+			continue
+		}
+		p := d.pickPeer(req.height)
+		if p == nil {
+			continue
+		}
+
+		req.peer = p
+		req.requested = now
+		d.peers[p].inFlight[req.hash] = req
+
+		gdmsg, ok := msgs[p]
+		if !ok {
+			gdmsg = wire.NewMsgGetData()
+			msgs[p] = gdmsg
+			peers = append(peers, p)
+		}
+		iv := wire.NewInvVect(wire.InvTypeBlock, &req.hash)
+		if p.IsWitnessEnabled() {
+			iv.Type = wire.InvTypeWitnessBlock
+		}
+		gdmsg.AddInvVect(iv)
+		numRequested++
+	}
+
+	// Send the requests in peer id order so the resulting traffic is
+	// deterministic.
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].ID() < peers[j].ID()
+	})
+	for _, p := range peers {
+		p.QueueMessage(msgs[p], nil)
+	}
+	return numRequested
+}
+
+// release removes the outstanding request for the passed block from the peer
+// it was assigned to.  The request is remembered as abandoned for that peer so
+// a late reply is not mistaken for an unrequested block.
+func (d *blockDownloader) release(req *blockRequest) {
+	if state, ok := d.peers[req.peer]; ok {
+		delete(state.inFlight, req.hash)
+		state.abandoned[req.hash] = struct{}{}
+	}
+	req.peer = nil
+}
+
+// blockReceived records a block received from the passed peer and returns how
+// the block was handled.
+func (d *blockDownloader) blockReceived(p downloadPeer, block *btcutil.Block) downloadResult {
+	hash := *block.Hash()
+	state, known := d.peers[p]
+	if !known {
+		return downloadUnrequested
+	}
+
+	_, abandoned := state.abandoned[hash]
+	req, ok := d.byHash[hash]
+	if ok && req.peer == p {
+		delete(state.inFlight, hash)
+		req.peer = nil
+	} else if !abandoned {
+		return downloadUnrequested
+	}
+
+	// The peer answered a request, so it is no longer considered to be
+	// stalling.
+	delete(state.abandoned, hash)
+	state.stalled = false
+
+	// A reply to an abandoned request is only useful if the block is still
+	// needed.  In that case, the request which replaced it is released.
+	if !ok || req.block != nil {
+		return downloadIgnored
+	}
+	if req.peer != nil {
+		d.release(req)
+	}
+	req.block = block
+	req.from = p
+	return downloadAccepted
+}
+
+// popReady removes and returns the request for the next block to be
+// processed, which includes the block and the peer that delivered it.  It
+// returns nil when the next block has not been received yet.
+func (d *blockDownloader) popReady() *blockRequest {
+	if len(d.requests) == 0 || d.requests[0].block == nil {
+		return nil
+	}
+	req := d.requests[0]
+	d.requests[0] = nil
+	d.requests = d.requests[1:]
+	delete(d.byHash, req.hash)
+	return req
+}
+
+// checkStalls releases every request which has been outstanding for longer
+// than the stall timeout so it can be reassigned to another peer.  The peers
+// the requests were released from are marked as stalled, which prevents any
+// further requests from being assigned to them until they deliver a block,
+// and are returned.
+func (d *blockDownloader) checkStalls(now time.Time) []downloadPeer {
+	var stalled []downloadPeer
+	for p, state := range d.peers {
+		for _, req := range state.inFlight {
+			if now.Sub(req.requested) <= d.stallTimeout {
+				continue
+			}
+			d.release(req)
+			if !state.stalled {
+				state.stalled = true
+				state.stalledSince = now
+				stalled = append(stalled, p)
+			}
+		}
+	}
+	sort.Slice(stalled, func(i, j int) bool {
+		return stalled[i].ID() < stalled[j].ID()
+	})
+	return stalled
+}
+
+// removeStalledPeers removes and returns the peers which have remained stalled
+// for longer than the stalled peer timeout without delivering a block.  The
+// caller is expected to disconnect them.
+func (d *blockDownloader) removeStalledPeers(now time.Time) []downloadPeer {
+	var removed []downloadPeer
+	for p, state := range d.peers {
+		if state.stalled &&
+			now.Sub(state.stalledSince) > d.stalledPeerTimeout {
+
+			removed = append(removed, p)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool {
+		return removed[i].ID() < removed[j].ID()
+	})
+	for _, p := range removed {
+		d.removePeer(p)
+	}
+	return removed
+}
+
+// reset discards the download queue.  Outstanding requests are remembered as
+// abandoned so late replies are not treated as unrequested blocks.
+func (d *blockDownloader) reset() {
+	for _, state := range d.peers {
+		for hash := range state.inFlight {
+			state.abandoned[hash] = struct{}{}
+		}
+		state.inFlight = make(map[chainhash.Hash]*blockRequest)
+	}
+	d.requests = nil
+	d.byHash = make(map[chainhash.Hash]*blockRequest)
+}
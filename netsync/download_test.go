@@ -0,0 +1,392 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
+	"github.com/organicbitcoin/obtcd/wire"
+	"github.com/organicbitcoin/btcutil"
+)
+
+// mockPeer implements the downloadPeer interface and records the messages
+// queued to it.
+type mockPeer struct {
+	id        int32
+	lastBlock int32
+	witness   bool
+	msgs      []wire.Message
+}
+
+func (p *mockPeer) ID() int32              { return p.id }
+func (p *mockPeer) LastBlock() int32       { return p.lastBlock }
+func (p *mockPeer) IsWitnessEnabled() bool { return p.witness }
+
+func (p *mockPeer) QueueMessage(msg wire.Message, doneChan chan<- struct{}) {
+	p.msgs = append(p.msgs, msg)
+}
+
+// requested returns the hashes of all blocks requested from the peer via
+// getdata messages and clears the recorded messages.
+func (p *mockPeer) requested(t *testing.T) []chainhash.Hash {
+	var hashes []chainhash.Hash
+	for _, msg := range p.msgs {
+		gdmsg, ok := msg.(*wire.MsgGetData)
+		if !ok {
+			t.Fatalf("unexpected message %T queued to peer %d",
+				msg, p.id)
+		}
+		for _, iv := range gdmsg.InvList {
+			want := wire.InvTypeBlock
+			if p.witness {
+				want = wire.InvTypeWitnessBlock
+			}
+			if iv.Type != want {
+				t.Fatalf("peer %d: unexpected inventory type %v, "+
+					"want %v", p.id, iv.Type, want)
+			}
+			hashes = append(hashes, iv.Hash)
+		}
+	}
+	p.msgs = nil
+	return hashes
+}
+
+// testBlocks returns a set of distinct blocks to be downloaded.  Block i is
+// considered to be at height i+1.
+func testBlocks(n int) []*btcutil.Block {
+	blocks := make([]*btcutil.Block, 0, n)
+	for i := 0; i < n; i++ {
+		msgBlock := &wire.MsgBlock{
+			Header: wire.BlockHeader{Nonce: uint32(i)},
+		}
+		blocks = append(blocks, btcutil.NewBlock(msgBlock))
+	}
+	return blocks
+}
+
+// newTestDownloader returns a block downloader with all of the passed blocks
+// queued and all of the passed peers added.
+func newTestDownloader(window, maxPerPeer int, blocks []*btcutil.Block,
+	peers ...*mockPeer) *blockDownloader {
+
+	d := newBlockDownloader(window, maxPerPeer, blockStallTimeout,
+		stalledPeerTimeout)
+	for i, block := range blocks {
+		d.addBlock(block.Hash(), int32(i+1))
+	}
+	for _, p := range peers {
+		d.addPeer(p)
+	}
+	return d
+}
+
+// blockIndex returns the index of the block with the passed hash.
+func blockIndex(t *testing.T, blocks []*btcutil.Block, hash chainhash.Hash) int {
+	for i, block := range blocks {
+		if *block.Hash() == hash {
+			return i
+		}
+	}
+	t.Fatalf("requested unknown block %v", hash)
+	return -1
+}
+
+// TestBlockDownloaderRequests ensures blocks within the download window are
+// spread across peers according to their limits and advertised heights.
+func TestBlockDownloaderRequests(t *testing.T) {
+	blocks := testBlocks(40)
+	p1 := &mockPeer{id: 1, lastBlock: 100, witness: true}
+	p2 := &mockPeer{id: 2, lastBlock: 100}
+	p3 := &mockPeer{id: 3, lastBlock: 4}
+	d := newTestDownloader(20, 8, blocks, p1, p2, p3)
+
+	// Requests are spread round robin, so the peer which only has the
+	// first four blocks is asked for the third one and the other two peers
+	// fill up to their limits.
+	now := time.Now()
+	if n := d.requestBlocks(now); n != 17 {
+		t.Fatalf("requestBlocks: got %d requests, want 17", n)
+	}
+
+	// No peer may be asked for more than its limit or for blocks beyond
+	// its last block, and no block may be requested twice.
+	seen := make(map[chainhash.Hash]int32)
+	for _, p := range []*mockPeer{p1, p2, p3} {
+		hashes := p.requested(t)
+		if len(hashes) > 8 {
+			t.Fatalf("peer %d: got %d requests, want at most 8",
+				p.id, len(hashes))
+		}
+		if got := d.inFlight(p); got != len(hashes) {
+			t.Fatalf("peer %d: in flight %d, want %d", p.id, got,
+				len(hashes))
+		}
+		for _, hash := range hashes {
+			height := int32(blockIndex(t, blocks, hash) + 1)
+			if height > p.lastBlock {
+				t.Fatalf("peer %d: requested block at height "+
+					"%d beyond its last block %d", p.id,
+					height, p.lastBlock)
+			}
+			if prev, ok := seen[hash]; ok {
+				t.Fatalf("block %v requested from both peer %d "+
+					"and peer %d", hash, prev, p.id)
+			}
+			seen[hash] = p.id
+		}
+	}
+
+	// Only blocks inside the window may have been requested.
+	for hash := range seen {
+		if idx := blockIndex(t, blocks, hash); idx >= 20 {
+			t.Fatalf("requested block %d outside of the window", idx)
+		}
+	}
+
+	// No further requests are possible until blocks are received.
+	if n := d.requestBlocks(now); n != 0 {
+		t.Fatalf("requestBlocks: got %d requests, want 0", n)
+	}
+}
+
+// TestBlockDownloaderInOrder ensures blocks received out of order are only
+// released in height order and that the window slides as blocks are
+// processed.
+func TestBlockDownloaderInOrder(t *testing.T) {
+	blocks := testBlocks(6)
+	p1 := &mockPeer{id: 1, lastBlock: 100}
+	p2 := &mockPeer{id: 2, lastBlock: 100}
+	d := newTestDownloader(4, 2, blocks, p1, p2)
+	d.requestBlocks(time.Now())
+
+	owner := make(map[chainhash.Hash]*mockPeer)
+	for _, p := range []*mockPeer{p1, p2} {
+		for _, hash := range p.requested(t) {
+			owner[hash] = p
+		}
+	}
+	if len(owner) != 4 {
+		t.Fatalf("got %d requests, want 4", len(owner))
+	}
+
+	// Deliver the blocks in reverse order.  Nothing may be released until
+	// the first block arrives.
+	for i := 3; i > 0; i-- {
+		hash := *blocks[i].Hash()
+		if got := d.blockReceived(owner[hash], blocks[i]); got != downloadAccepted {
+			t.Fatalf("block %d: got result %v, want accepted", i, got)
+		}
+		if req := d.popReady(); req != nil {
+			t.Fatalf("block %d: popReady returned block at height "+
+				"%d early", i, req.height)
+		}
+	}
+	hash := *blocks[0].Hash()
+	if got := d.blockReceived(owner[hash], blocks[0]); got != downloadAccepted {
+		t.Fatalf("block 0: got result %v, want accepted", got)
+	}
+	for i := 0; i < 4; i++ {
+		req := d.popReady()
+		if req == nil {
+			t.Fatalf("popReady: no block at height %d", i+1)
+		}
+		if req.height != int32(i+1) || req.block != blocks[i] {
+			t.Fatalf("popReady: got height %d, want %d", req.height,
+				i+1)
+		}
+		if req.from != owner[*blocks[i].Hash()] {
+			t.Fatalf("popReady: block %d attributed to the wrong "+
+				"peer", i)
+		}
+	}
+	if req := d.popReady(); req != nil {
+		t.Fatalf("popReady: unexpected block at height %d", req.height)
+	}
+
+	// The window has moved past the processed blocks, so the remaining
+	// blocks are requested now.
+	if n := d.requestBlocks(time.Now()); n != 2 {
+		t.Fatalf("requestBlocks: got %d requests, want 2", n)
+	}
+	if d.queued() != 2 {
+		t.Fatalf("queued: got %d, want 2", d.queued())
+	}
+}
+
+// TestBlockDownloaderStalls ensures requests which time out are reassigned to
+// other peers and that late replies from the stalling peer are tolerated.
+func TestBlockDownloaderStalls(t *testing.T) {
+	blocks := testBlocks(2)
+	slow := &mockPeer{id: 1, lastBlock: 100}
+	d := newTestDownloader(16, 16, blocks, slow)
+
+	start := time.Now()
+	d.requestBlocks(start)
+	if got := len(slow.requested(t)); got != 2 {
+		t.Fatalf("got %d requests, want 2", got)
+	}
+
+	// Nothing is stalled before the timeout expires.
+	if stalled := d.checkStalls(start.Add(blockStallTimeout)); len(stalled) != 0 {
+		t.Fatalf("checkStalls: got %d stalled peers, want 0",
+			len(stalled))
+	}
+
+	// A new peer joins and the slow peer stalls.  Its requests must be
+	// reassigned to the new peer.
+	fast := &mockPeer{id: 2, lastBlock: 100}
+	d.addPeer(fast)
+	later := start.Add(blockStallTimeout + time.Second)
+	stalled := d.checkStalls(later)
+	if len(stalled) != 1 || stalled[0] != slow {
+		t.Fatalf("checkStalls: got %v, want the slow peer", stalled)
+	}
+	if d.inFlight(slow) != 0 {
+		t.Fatalf("stalled peer still has %d blocks in flight",
+			d.inFlight(slow))
+	}
+	d.requestBlocks(later)
+	if got := len(fast.requested(t)); got != 2 {
+		t.Fatalf("got %d reassigned requests, want 2", got)
+	}
+	if got := len(slow.requested(t)); got != 0 {
+		t.Fatalf("stalled peer was sent %d requests", got)
+	}
+
+	// The fast peer delivers the first block.  A late reply from the
+	// slow peer for the same block is no longer needed.
+	if got := d.blockReceived(fast, blocks[0]); got != downloadAccepted {
+		t.Fatalf("got result %v, want accepted", got)
+	}
+	if got := d.blockReceived(slow, blocks[0]); got != downloadIgnored {
+		t.Fatalf("got result %v, want ignored", got)
+	}
+
+	// The slow peer's late reply for the second block arrives first, so it
+	// is used and the fast peer's outstanding request is released.
+	if got := d.blockReceived(slow, blocks[1]); got != downloadAccepted {
+		t.Fatalf("got result %v, want accepted", got)
+	}
+	if d.inFlight(fast) != 0 {
+		t.Fatalf("fast peer still has %d blocks in flight",
+			d.inFlight(fast))
+	}
+	if got := d.blockReceived(fast, blocks[1]); got != downloadIgnored {
+		t.Fatalf("got result %v, want ignored", got)
+	}
+	if req := d.popReady(); req == nil || req.from != fast {
+		t.Fatal("popReady: first block not attributed to fast peer")
+	}
+	if req := d.popReady(); req == nil || req.from != slow {
+		t.Fatal("popReady: second block not attributed to slow peer")
+	}
+}
+
+// TestBlockDownloaderStalledPeers ensures peers which remain stalled for longer
+// than the stalled peer timeout are removed so they can be disconnected, while
+// stalled peers which deliver a block in time are kept.
+func TestBlockDownloaderStalledPeers(t *testing.T) {
+	blocks := testBlocks(2)
+	slow := &mockPeer{id: 1, lastBlock: 100}
+	recovering := &mockPeer{id: 2, lastBlock: 100}
+	d := newTestDownloader(16, 1, blocks, slow, recovering)
+
+	start := time.Now()
+	d.requestBlocks(start)
+	slowHashes := slow.requested(t)
+	recoveringHashes := recovering.requested(t)
+	if len(slowHashes) != 1 || len(recoveringHashes) != 1 {
+		t.Fatalf("got %d and %d requests, want 1 each",
+			len(slowHashes), len(recoveringHashes))
+	}
+
+	// Both peers stall, but neither is removed before the stalled peer
+	// timeout expires.
+	stalledAt := start.Add(blockStallTimeout + time.Second)
+	if stalled := d.checkStalls(stalledAt); len(stalled) != 2 {
+		t.Fatalf("checkStalls: got %d stalled peers, want 2",
+			len(stalled))
+	}
+	removed := d.removeStalledPeers(stalledAt.Add(stalledPeerTimeout))
+	if len(removed) != 0 {
+		t.Fatalf("removeStalledPeers: got %v, want none", removed)
+	}
+
+	// The recovering peer delivers its late reply, so only the slow peer
+	// is removed once the timeout expires.
+	block := blocks[0]
+	if *block.Hash() != recoveringHashes[0] {
+		block = blocks[1]
+	}
+	if got := d.blockReceived(recovering, block); got != downloadAccepted {
+		t.Fatalf("got result %v, want accepted", got)
+	}
+	later := stalledAt.Add(stalledPeerTimeout + time.Second)
+	removed = d.removeStalledPeers(later)
+	if len(removed) != 1 || removed[0] != slow {
+		t.Fatalf("removeStalledPeers: got %v, want the slow peer",
+			removed)
+	}
+	if removed := d.removeStalledPeers(later); len(removed) != 0 {
+		t.Fatalf("removeStalledPeers: slow peer removed twice")
+	}
+
+	// The block the slow peer stalled on is requested from the remaining
+	// peer.
+	d.requestBlocks(later)
+	got := recovering.requested(t)
+	if len(got) != 1 || got[0] != slowHashes[0] {
+		t.Fatalf("got requests %v, want %v", got, slowHashes)
+	}
+}
+
+// TestBlockDownloaderPeerChanges ensures blocks in flight from a removed peer
+// are reassigned, unrequested blocks are reported, and replies to requests
+// discarded by a reset are ignored.
+func TestBlockDownloaderPeerChanges(t *testing.T) {
+	blocks := testBlocks(4)
+	p1 := &mockPeer{id: 1, lastBlock: 100}
+	p2 := &mockPeer{id: 2, lastBlock: 100}
+	d := newTestDownloader(16, 2, blocks, p1, p2)
+	d.requestBlocks(time.Now())
+	p1Hashes := p1.requested(t)
+	p2.requested(t)
+
+	// A block which was requested from another peer is unrequested.
+	idx := blockIndex(t, blocks, p1Hashes[0])
+	if got := d.blockReceived(p2, blocks[idx]); got != downloadUnrequested {
+		t.Fatalf("got result %v, want unrequested", got)
+	}
+	other := &mockPeer{id: 3, lastBlock: 100}
+	if got := d.blockReceived(other, blocks[idx]); got != downloadUnrequested {
+		t.Fatalf("got result %v, want unrequested", got)
+	}
+
+	// Removing the peer makes its blocks available to other peers.
+	d.removePeer(p1)
+	d.addPeer(other)
+	d.requestBlocks(time.Now())
+	got := other.requested(t)
+	if len(got) != len(p1Hashes) {
+		t.Fatalf("got %d reassigned requests, want %d", len(got),
+			len(p1Hashes))
+	}
+
+	// Replies to requests discarded by a reset are ignored.
+	d.reset()
+	if d.queued() != 0 {
+		t.Fatalf("queued: got %d after reset, want 0", d.queued())
+	}
+	idx = blockIndex(t, blocks, got[0])
+	if res := d.blockReceived(other, blocks[idx]); res != downloadIgnored {
+		t.Fatalf("got result %v, want ignored", res)
+	}
+	if res := d.blockReceived(other, blocks[idx]); res != downloadUnrequested {
+		t.Fatalf("got result %v for duplicate, want unrequested", res)
+	}
+}
@@ -0,0 +1,381 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/aead/siphash"
+	"github.com/organicbitcoin/obtcd/blockchain"
+	"github.com/organicbitcoin/obtcd/chaincfg"
+	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
+	"github.com/organicbitcoin/obtcd/wire"
+)
+
+const (
+	// headerCommitmentPeriod is the interval, in headers, at which a
+	// single bit commitment to a header is stored while pre-syncing a
+	// header chain.  Each commitment is checked when the headers are
+	// downloaded again, so a peer which serves a different chain the
+	// second time is detected with high probability well before the
+	// redownload buffer is released.
+	headerCommitmentPeriod = 624
+
+	// redownloadBufferSize is the number of headers held in memory while
+	// downloading a pre-synced header chain for the second time before the
+	// oldest ones are released to be stored in the block index.  It is
+	// large enough that a peer would need to correctly guess many
+	// commitments to get headers of a different chain stored.
+	redownloadBufferSize = 14304
+
+	// maxFutureBlockTime is the maximum number of seconds a block timestamp
+	// is allowed to be ahead of the current time.  It mirrors the limit
+	// enforced by the block chain and bounds the height a header chain can
+	// reach in the time since the presync started.
+	maxFutureBlockTime = 2 * 60 * 60
+
+	// Ban scores given to the sync peer when it serves headers which can't
+	// be part of a valid chain and when it serves a chain which ends before
+	// reaching the minimum chain work, respectively.
+	invalidHeadersBanScore = 100
+	lowWorkChainBanScore   = 50
+)
+
+// headersSyncPhase identifies the phase a headers presync is in.
+type headersSyncPhase int
+
+const (
+	// headersPresync is the phase where the headers of the sync peer are
+	// only validated and their cumulative work is tracked.
+	headersPresync headersSyncPhase = iota
+
+	// headersRedownload is the phase where the headers, now known to lead
+	// to a chain with enough work, are downloaded again and released to be
+	// stored once they match the commitments made during the presync.
+	headersRedownload
+
+	// headersSyncDone is the phase after all of the redownloaded headers
+	// have been released.
+	headersSyncDone
+)
+
+// headersSyncError describes headers which violate the rules of a headers
+// presync along with the ban score the peer that sent them deserves.
+type headersSyncError struct {
+	banScore    uint32
+	description string
+}
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e headersSyncError) Error() string {
+	return e.description
+}
+
+// syncError creates a headersSyncError given a set of arguments.
+func syncError(banScore uint32, format string, args ...interface{}) headersSyncError {
+	return headersSyncError{
+		banScore:    banScore,
+		description: fmt.Sprintf(format, args...),
+	}
+}
+
+// headerCursor tracks the latest header of a header chain being downloaded.
+type headerCursor struct {
+	hash   chainhash.Hash
+	height int32
+	bits   uint32
+	work   *big.Int
+}
+
+// headersSyncState houses the state of a headers presync with the sync peer.
+//
+// Storing every header a peer sends before knowing the chain they build has
+// enough work allows peers to consume memory with cheap low-difficulty header
+// chains.  Instead, the headers are downloaded twice.  The first pass only
+// validates the headers and tracks the cumulative work along with a salted
+// one bit commitment to a header every headerCommitmentPeriod headers.  Once
+// the chain is shown to reach the minimum chain work, the headers are
+// downloaded again from the start and checked against the commitments.  The
+// redownloaded headers are released in order once redownloadBufferSize
+// headers are buffered after them or the chain reaches the minimum chain work.
+type headersSyncState struct {
+	chainParams *chaincfg.Params
+	minimumWork *big.Int
+	phase       headersSyncPhase
+
+	// chainStart is the known header the synced chain builds on.
+	chainStart headerCursor
+
+	// The commitments made during the presync.  The offset is chosen at
+	// random so peers can't predict which headers are committed to and
+	// the hash key keeps them from predicting the committed bits.
+	commitPeriod   int32
+	commitOffset   int32
+	hashKey        [siphash.KeySize]byte
+	commitments    []uint64
+	numCommitments int
+	maxCommitments int
+	nextCommitment int
+
+	// presync is the latest header of the presync phase.
+	presync headerCursor
+
+	// redownload is the latest header of the redownload phase and buffer
+	// holds the redownloaded headers which have not been released yet.
+	redownload headerCursor
+	buffer     []wire.BlockHeader
+	bufferSize int
+}
+
+// newHeadersSyncState returns a new headers presync state for a header chain
+// which builds on the passed known header with the given height and total
+// chain work.
+func newHeadersSyncState(chainParams *chaincfg.Params, start *wire.BlockHeader,
+	startHeight int32, startWork *big.Int, now time.Time) *headersSyncState {
+
+	s := &headersSyncState{
+		chainParams: chainParams,
+		minimumWork: chainParams.MinimumChainWork,
+		chainStart: headerCursor{
+			hash:   start.BlockHash(),
+			height: startHeight,
+			bits:   start.Bits,
+			work:   new(big.Int).Set(startWork),
+		},
+		commitPeriod: headerCommitmentPeriod,
+		bufferSize:   redownloadBufferSize,
+	}
+
+	var offset [4]byte
+	rand.Read(offset[:])
+	rand.Read(s.hashKey[:])
+	s.commitOffset = int32(binary.LittleEndian.Uint32(offset[:]) %
+		uint32(s.commitPeriod))
+
+	// The median time rule only allows the timestamps of six consecutive
+	// blocks to be equal, so a valid chain can't grow faster than six
+	// blocks per second.  This bounds the number of commitments a peer can
+	// make us store.
+	maxSeconds := now.Unix() - start.Timestamp.Unix() + maxFutureBlockTime
+	if maxSeconds < 0 {
+		maxSeconds = 0
+	}
+	s.maxCommitments = int(6 * maxSeconds / int64(s.commitPeriod))
+
+	s.presync = s.chainStart
+	s.presync.work = new(big.Int).Set(startWork)
+	return s
+}
+
+// done returns whether all of the redownloaded headers have been released.
+func (s *headersSyncState) done() bool {
+	return s.phase == headersSyncDone
+}
+
+// locatorHash returns the hash of the latest header downloaded in the current
+// phase, which is where the next request for headers continues from.
+func (s *headersSyncState) locatorHash() *chainhash.Hash {
+	if s.phase == headersPresync {
+		return &s.presync.hash
+	}
+	return &s.redownload.hash
+}
+
+// commitmentBit returns the commitment bit for the header with the passed
+// hash.
+func (s *headersSyncState) commitmentBit(hash *chainhash.Hash) bool {
+	return siphash.Sum64(hash[:], &s.hashKey)&1 == 1
+}
+
+// isCommitmentHeight returns whether a commitment is made to the header at the
+// passed height.
+func (s *headersSyncState) isCommitmentHeight(height int32) bool {
+	return height%s.commitPeriod == s.commitOffset
+}
+
+// connectHeader ensures the passed header is a valid successor of the header
+// the cursor points to and advances the cursor to it.  Only the rules which
+// can be checked without the full chain of headers are enforced: the header
+// must link to the previous one, have valid proof of work, and only change
+// difficulty as the retarget rules permit.
+func (s *headersSyncState) connectHeader(cursor *headerCursor,
+	header *wire.BlockHeader) error {
+
+	hash := header.BlockHash()
+	if header.PrevBlock != cursor.hash {
+		return syncError(invalidHeadersBanScore, "header %v does not "+
+			"connect to the previous header %v", hash, cursor.hash)
+	}
+
+	height := cursor.height + 1
+	if !blockchain.PermittedDifficultyTransition(s.chainParams, height,
+		cursor.bits, header.Bits) {
+
+		return syncError(invalidHeadersBanScore, "header %v at height "+
+			"%d has an invalid difficulty transition from %08x to "+
+			"%08x", hash, height, cursor.bits, header.Bits)
+	}
+
+	target := blockchain.CompactToBig(header.Bits)
+	if target.Sign() <= 0 || target.Cmp(s.chainParams.PowLimit) > 0 {
+		return syncError(invalidHeadersBanScore, "header %v has "+
+			"target difficulty %064x outside of the allowed range",
+			hash, target)
+	}
+	if blockchain.HashToBig(&hash).Cmp(target) > 0 {
+		return syncError(invalidHeadersBanScore, "header %v has proof "+
+			"of work higher than its target difficulty", hash)
+	}
+
+	cursor.hash = hash
+	cursor.height = height
+	cursor.bits = header.Bits
+	cursor.work.Add(cursor.work, blockchain.CalcWork(header.Bits))
+	return nil
+}
+
+// processHeaders validates the passed headers, which must continue from the
+// latest header of the current phase, and returns any headers which are ready
+// to be stored in the block index.  The fullMessage flag indicates whether
+// the headers filled an entire headers message, meaning the peer likely has
+// more headers to send.  A headersSyncError is returned when the peer sent
+// headers which must not be accepted.
+func (s *headersSyncState) processHeaders(headers []*wire.BlockHeader,
+	fullMessage bool) ([]*wire.BlockHeader, error) {
+
+	switch s.phase {
+	case headersPresync:
+		return nil, s.processPresyncHeaders(headers, fullMessage)
+
+	case headersRedownload:
+		return s.processRedownloadHeaders(headers, fullMessage)
+	}
+
+	return nil, nil
+}
+
+// processPresyncHeaders validates the passed headers during the presync phase
+// and records the commitments to them.  It switches to the redownload phase
+// once the chain reaches the minimum chain work.
+func (s *headersSyncState) processPresyncHeaders(headers []*wire.BlockHeader,
+	fullMessage bool) error {
+
+	for _, header := range headers {
+		if err := s.connectHeader(&s.presync, header); err != nil {
+			return err
+		}
+		if !s.isCommitmentHeight(s.presync.height) {
+			continue
+		}
+
+		if s.numCommitments >= s.maxCommitments {
+			return syncError(invalidHeadersBanScore, "header chain "+
+				"exceeds the maximum possible height of a "+
+				"valid chain")
+		}
+		if s.numCommitments%64 == 0 {
+			s.commitments = append(s.commitments, 0)
+		}
+		if s.commitmentBit(&s.presync.hash) {
+			s.commitments[s.numCommitments/64] |= 1 << uint(s.numCommitments%64)
+		}
+		s.numCommitments++
+	}
+
+	if s.presync.work.Cmp(s.minimumWork) >= 0 {
+		log.Infof("Pre-synchronized block headers up to height %d "+
+			"reaching the minimum chain work -- downloading them "+
+			"again to store", s.presync.height)
+		s.phase = headersRedownload
+		s.redownload = s.chainStart
+		s.redownload.work = new(big.Int).Set(s.chainStart.work)
+		return nil
+	}
+
+	if !fullMessage {
+		return syncError(lowWorkChainBanScore, "header chain ending at "+
+			"height %d with work %v does not reach the minimum chain "+
+			"work %v", s.presync.height, s.presync.work, s.minimumWork)
+	}
+
+	log.Debugf("Pre-synchronizing block headers, height %d",
+		s.presync.height)
+	return nil
+}
+
+// processRedownloadHeaders validates the passed headers during the redownload
+// phase against the commitments made during the presync and returns the
+// buffered headers which are ready to be stored.
+func (s *headersSyncState) processRedownloadHeaders(headers []*wire.BlockHeader,
+	fullMessage bool) ([]*wire.BlockHeader, error) {
+
+	releaseAll := false
+	for _, header := range headers {
+		if err := s.connectHeader(&s.redownload, header); err != nil {
+			return nil, err
+		}
+
+		// The commitments only cover the chain up to the header the
+		// presync ended with, which already has enough work.
+		if s.isCommitmentHeight(s.redownload.height) {
+			if s.nextCommitment >= s.numCommitments {
+				return nil, syncError(invalidHeadersBanScore,
+					"redownloaded header chain is longer "+
+						"than the pre-synced chain")
+			}
+			word := s.commitments[s.nextCommitment/64]
+			bit := word>>uint(s.nextCommitment%64)&1 == 1
+			if bit != s.commitmentBit(&s.redownload.hash) {
+				return nil, syncError(invalidHeadersBanScore,
+					"redownloaded header %v at height %d "+
+						"does not match the pre-synced "+
+						"chain", s.redownload.hash,
+					s.redownload.height)
+			}
+			s.nextCommitment++
+		}
+
+		s.buffer = append(s.buffer, *header)
+		if s.redownload.work.Cmp(s.minimumWork) >= 0 {
+			releaseAll = true
+		}
+	}
+
+	if releaseAll {
+		log.Infof("Redownloaded block headers up to height %d reaching "+
+			"the minimum chain work", s.redownload.height)
+		released := s.release(len(s.buffer))
+		s.phase = headersSyncDone
+		s.commitments = nil
+		return released, nil
+	}
+
+	if !fullMessage {
+		return nil, syncError(lowWorkChainBanScore, "redownloaded "+
+			"header chain ending at height %d does not reach the "+
+			"minimum chain work", s.redownload.height)
+	}
+
+	if len(s.buffer) <= s.bufferSize {
+		return nil, nil
+	}
+	return s.release(len(s.buffer) - s.bufferSize), nil
+}
+
+// release removes the passed number of the oldest headers from the redownload
+// buffer and returns them.
+func (s *headersSyncState) release(n int) []*wire.BlockHeader {
+	released := make([]*wire.BlockHeader, n)
+	for i := range released {
+		header := s.buffer[i]
+		released[i] = &header
+	}
+	s.buffer = append(s.buffer[:0:0], s.buffer[n:]...)
+	return released
+}
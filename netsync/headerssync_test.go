@@ -0,0 +1,205 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/organicbitcoin/obtcd/blockchain"
+	"github.com/organicbitcoin/obtcd/chaincfg"
+	"github.com/organicbitcoin/obtcd/wire"
+)
+
+// solveHeader increments the nonce of the passed header until its hash meets
+// the target difficulty.
+func solveHeader(header *wire.BlockHeader) {
+	target := blockchain.CompactToBig(header.Bits)
+	for {
+		hash := header.BlockHash()
+		if blockchain.HashToBig(&hash).Cmp(target) <= 0 {
+			return
+		}
+		header.Nonce++
+	}
+}
+
+// makeHeaders returns a chain of n solved headers with the passed difficulty
+// bits which builds on prev.  The salt is included in the timestamps so chains
+// built with different salts differ.
+func makeHeaders(prev *wire.BlockHeader, n int, bits uint32, salt int) []*wire.BlockHeader {
+	headers := make([]*wire.BlockHeader, 0, n)
+	for i := 0; i < n; i++ {
+		header := &wire.BlockHeader{
+			Version:   1,
+			PrevBlock: prev.BlockHash(),
+			Timestamp: prev.Timestamp.Add(time.Second *
+				time.Duration(600+salt)),
+			Bits: bits,
+		}
+		solveHeader(header)
+		headers = append(headers, header)
+		prev = header
+	}
+	return headers
+}
+
+// chainWork returns the work of the passed headers on top of the start work.
+func chainWork(start *big.Int, headers []*wire.BlockHeader) *big.Int {
+	work := new(big.Int).Set(start)
+	for _, header := range headers {
+		work.Add(work, blockchain.CalcWork(header.Bits))
+	}
+	return work
+}
+
+// newTestSyncState returns a headers presync state with small commitment and
+// buffer sizes for a chain which builds on the regression test genesis block
+// and requires the work of minWorkHeaders headers.
+func newTestSyncState(headers []*wire.BlockHeader, minWorkHeaders int) *headersSyncState {
+	params := chaincfg.RegressionNetParams
+	params.ReduceMinDifficulty = false
+	genesis := &params.GenesisBlock.Header
+	startWork := blockchain.CalcWork(genesis.Bits)
+	params.MinimumChainWork = chainWork(startWork, headers[:minWorkHeaders])
+
+	s := newHeadersSyncState(&params, genesis, 0, startWork, time.Now())
+	s.commitPeriod = 4
+	s.commitOffset = 1
+	s.bufferSize = 10
+	return s
+}
+
+// TestHeadersSync ensures a header chain with enough work is downloaded twice
+// and released in order once it reaches the minimum chain work.
+func TestHeadersSync(t *testing.T) {
+	genesis := &chaincfg.RegressionNetParams.GenesisBlock.Header
+	headers := makeHeaders(genesis, 60, genesis.Bits, 0)
+	s := newTestSyncState(headers, 50)
+
+	// The presync ends once the chain has enough work without releasing
+	// any headers.
+	for i := 0; i < 60; i += 20 {
+		released, err := s.processHeaders(headers[i:i+20], true)
+		if err != nil {
+			t.Fatalf("presync headers %d: %v", i, err)
+		}
+		if len(released) != 0 {
+			t.Fatalf("presync released %d headers", len(released))
+		}
+	}
+	if s.phase != headersRedownload {
+		t.Fatalf("unexpected phase %d after presync", s.phase)
+	}
+	if *s.locatorHash() != genesis.BlockHash() {
+		t.Fatalf("redownload does not start from the chain start")
+	}
+
+	// The redownloaded headers are released once the buffer is full and
+	// all at once when the chain reaches the minimum chain work.
+	wantReleased := []int{10, 20, 30}
+	var released []*wire.BlockHeader
+	for i := 0; i < 60; i += 20 {
+		r, err := s.processHeaders(headers[i:i+20], true)
+		if err != nil {
+			t.Fatalf("redownload headers %d: %v", i, err)
+		}
+		if len(r) != wantReleased[i/20] {
+			t.Fatalf("redownload headers %d: released %d headers, "+
+				"want %d", i, len(r), wantReleased[i/20])
+		}
+		released = append(released, r...)
+	}
+	if !s.done() {
+		t.Fatalf("headers sync not done after redownload")
+	}
+	for i, header := range released {
+		if header.BlockHash() != headers[i].BlockHash() {
+			t.Fatalf("released header %d mismatch", i)
+		}
+	}
+}
+
+// TestHeadersSyncErrors ensures peers which serve low-work or invalid header
+// chains are detected.
+func TestHeadersSyncErrors(t *testing.T) {
+	genesis := &chaincfg.RegressionNetParams.GenesisBlock.Header
+	headers := makeHeaders(genesis, 60, genesis.Bits, 0)
+	other := makeHeaders(genesis, 60, genesis.Bits, 1)
+
+	// Harder difficulty in the middle of a retarget interval.
+	harder := makeHeaders(headers[9], 1, 0x207ffffe, 0)
+
+	// Proof of work which does not meet the target.
+	badPoW := *headers[0]
+	target := blockchain.CompactToBig(badPoW.Bits)
+	for {
+		hash := badPoW.BlockHash()
+		if blockchain.HashToBig(&hash).Cmp(target) > 0 {
+			break
+		}
+		badPoW.Nonce++
+	}
+
+	tests := []struct {
+		name       string
+		presync    []*wire.BlockHeader
+		redownload []*wire.BlockHeader
+		banScore   uint32
+	}{{
+		name:     "low work chain",
+		presync:  headers[:40],
+		banScore: lowWorkChainBanScore,
+	}, {
+		name:     "does not connect",
+		presync:  headers[1:],
+		banScore: invalidHeadersBanScore,
+	}, {
+		name:     "insufficient proof of work",
+		presync:  []*wire.BlockHeader{&badPoW},
+		banScore: invalidHeadersBanScore,
+	}, {
+		name: "difficulty transition",
+		presync: append(append([]*wire.BlockHeader(nil),
+			headers[:10]...), harder...),
+		banScore: invalidHeadersBanScore,
+	}, {
+		name:       "different chain redownloaded",
+		presync:    headers,
+		redownload: other,
+		banScore:   invalidHeadersBanScore,
+	}}
+
+	for _, test := range tests {
+		s := newTestSyncState(headers, 50)
+		s.commitPeriod = 1
+		s.commitOffset = 0
+
+		_, err := s.processHeaders(test.presync, false)
+		if test.redownload != nil {
+			if err != nil {
+				t.Fatalf("%s: presync: %v", test.name, err)
+			}
+			_, err = s.processHeaders(test.redownload, false)
+		}
+		serr, ok := err.(headersSyncError)
+		if !ok {
+			t.Fatalf("%s: unexpected error %v", test.name, err)
+		}
+		if serr.banScore != test.banScore {
+			t.Fatalf("%s: unexpected ban score - got %d, want %d",
+				test.name, serr.banScore, test.banScore)
+		}
+	}
+
+	// A chain which is too long to be valid is rejected before it is able
+	// to consume too much memory.
+	s := newTestSyncState(headers, 50)
+	s.maxCommitments = 2
+	if _, err := s.processHeaders(headers, true); err == nil {
+		t.Fatal("header chain exceeding the maximum commitments accepted")
+	}
+}
@@ -20,11 +20,15 @@ import (
 type PeerNotifier interface {
 	AnnounceNewTransactions(newTxs []*mempool.TxDesc)
 
+	RelayRejectedTransaction(tx *btcutil.Tx)
+
 	UpdatePeerHeights(latestBlkHash *chainhash.Hash, latestHeight int32, updateSource *peer.Peer)
 
 	RelayInventory(invVect *wire.InvVect, data interface{})
 
 	TransactionConfirmed(tx *btcutil.Tx)
+
+	AddBanScore(p *peer.Peer, persistent, transient uint32, reason string)
 }
 
 // Config is a configuration struct used to initialize a new SyncManager.
@@ -38,4 +42,16 @@ type Config struct {
 	MaxPeers           int
 
 	FeeEstimator *mempool.FeeEstimator
+
+	// BackgroundChain is an optional chain instance which validates the
+	// history up to the base block of the utxo snapshot the chain state of
+	// Chain was loaded from.  The blocks it needs are downloaded once Chain
+	// is current.
+	BackgroundChain *blockchain.BlockChain
+
+	// RequestShutdown is invoked when the utxo snapshot the chain state of
+	// Chain was loaded from does not match the history validated by
+	// BackgroundChain.  The snapshot is marked invalid by then, so the node
+	// continues from the validated history once it is restarted.
+	RequestShutdown func()
 }
@@ -5,7 +5,6 @@
 package netsync
 
 import (
-	"container/list"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -22,10 +21,10 @@ import (
 )
 
 const (
-	// minInFlightBlocks is the minimum number of blocks that should be
-	// in the request queue for headers-first mode before requesting
-	// more.
-	minInFlightBlocks = 10
+	// minQueuedBlocks is the number of blocks learned from headers, but not
+	// yet processed, below which more headers are requested from the sync
+	// peer in headers-first mode.
+	minQueuedBlocks = 2 * blockDownloadWindow
 
 	// maxRejectedTxns is the maximum number of rejected transactions
 	// hashes to store in memory.
@@ -56,6 +55,22 @@ type blockMsg struct {
 	reply chan struct{}
 }
 
+// cmpctBlockMsg packages a bitcoin cmpctblock message and the peer it came
+// from together so the block handler has access to that information.
+type cmpctBlockMsg struct {
+	msg   *wire.MsgCmpctBlock
+	peer  *peerpkg.Peer
+	reply chan struct{}
+}
+
+// blockTxnMsg packages a bitcoin blocktxn message and the peer it came from
+// together so the block handler has access to that information.
+type blockTxnMsg struct {
+	msg   *wire.MsgBlockTxn
+	peer  *peerpkg.Peer
+	reply chan struct{}
+}
+
 // invMsg packages a bitcoin inv message and the peer it came from together
 // so the block handler has access to that information.
 type invMsg struct {
@@ -78,9 +93,10 @@ type donePeerMsg struct {
 // txMsg packages a bitcoin tx message and the peer it came from together
 // so the block handler has access to that information.
 type txMsg struct {
-	tx    *btcutil.Tx
-	peer  *peerpkg.Peer
-	reply chan struct{}
+	tx         *btcutil.Tx
+	peer       *peerpkg.Peer
+	forceRelay bool
+	reply      chan struct{}
 }
 
 // getSyncPeerMsg is a message type to be sent across the message channel for
@@ -122,8 +138,7 @@ type pauseMsg struct {
 	unpause <-chan struct{}
 }
 
-// headerNode is used as a node in a list of headers that are linked together
-// between checkpoints.
+// headerNode identifies a block header by its height and hash.
 type headerNode struct {
 	height int32
 	hash   *chainhash.Hash
@@ -136,6 +151,7 @@ type peerSyncState struct {
 	requestQueue    []*wire.InvVect
 	requestedTxns   map[chainhash.Hash]struct{}
 	requestedBlocks map[chainhash.Hash]struct{}
+	partialBlocks   map[chainhash.Hash]*partialBlock
 }
 
 // SyncManager is used to communicate block related messages with peers. The
@@ -162,29 +178,59 @@ type SyncManager struct {
 	syncPeer        *peerpkg.Peer
 	peerStates      map[*peerpkg.Peer]*peerSyncState
 
-	// The following fields are used for headers-first mode.
-	headersFirstMode bool
-	headerList       *list.List
-	startHeader      *list.Element
-	nextCheckpoint   *chaincfg.Checkpoint
+	// The following fields are used for headers-first mode.  Headers are
+	// downloaded from the sync peer and the blocks they describe are
+	// downloaded from all sync candidates by the block downloader.
+	headersFirstMode   bool
+	headersSynced      bool
+	headersRequested   bool
+	headerTip          headerNode
+	fastAddHeight      int32
+	disableCheckpoints bool
+	nextCheckpoint     *chaincfg.Checkpoint
+	downloader         *blockDownloader
+
+	// headersPresync is the state of the headers presync with the sync
+	// peer while the latest known header has less than the minimum chain
+	// work.  It is nil when headers are stored as soon as they arrive.
+	headersPresync *headersSyncState
+
+	// highBandwidthPeers holds the peers which were most recently the
+	// first to deliver a new block and have been asked to announce new
+	// blocks by sending compact blocks directly, oldest first.
+	highBandwidthPeers []*peerpkg.Peer
 
 	// An optional fee estimator.
 	feeEstimator *mempool.FeeEstimator
+
+	// The following fields are used to validate the history up to the base
+	// block of the utxo snapshot the chain state was loaded from in the
+	// background.  The blocks are downloaded from all sync candidates by a
+	// separate block downloader and bgQueuedHeight is the height of the
+	// latest block queued for download.  They are nil once the snapshot
+	// has been validated.  requestShutdown is invoked when the snapshot
+	// turns out to be invalid.
+	bgChain         *blockchain.BlockChain
+	bgDownloader    *blockDownloader
+	bgQueuedHeight  int32
+	requestShutdown func()
 }
 
 // resetHeaderState sets the headers-first mode state to values appropriate for
-// syncing from a new peer.
+// syncing from a new peer.  Any blocks queued for download are discarded.
 func (sm *SyncManager) resetHeaderState(newestHash *chainhash.Hash, newestHeight int32) {
 	sm.headersFirstMode = false
-	sm.headerList.Init()
-	sm.startHeader = nil
-
-	// When there is a next checkpoint, add an entry for the latest known
-	// block into the header pool.  This allows the next downloaded header
-	// to prove it links to the chain properly.
-	if sm.nextCheckpoint != nil {
-		node := headerNode{height: newestHeight, hash: newestHash}
-		sm.headerList.PushBack(&node)
+	sm.headersSynced = false
+	sm.headersRequested = false
+	sm.headersPresync = nil
+	sm.downloader.reset()
+
+	// The latest known block is the header the next downloaded header must
+	// link to in order to prove it connects to the chain properly.
+	sm.headerTip = headerNode{height: newestHeight, hash: newestHash}
+	sm.fastAddHeight = newestHeight
+	if !sm.disableCheckpoints {
+		sm.nextCheckpoint = sm.findNextHeaderCheckpoint(newestHeight)
 	}
 }
 
@@ -258,61 +304,95 @@ func (sm *SyncManager) startSync() {
 			continue
 		}
 
-		// TODO(davec): Use a better algorithm to choose the best peer.
-		// For now, just pick the first available candidate.
-		bestPeer = peer
+		// Prefer the peer that claims the longest chain since the
+		// headers are downloaded from it.
+		if bestPeer == nil || peer.LastBlock() > bestPeer.LastBlock() {
+			bestPeer = peer
+		}
+	}
+
+	if bestPeer == nil {
+		log.Warnf("No sync peer candidates available")
+		return
 	}
 
-	// Start syncing from the best peer if one was selected.
-	if bestPeer != nil {
-		// Clear the requestedBlocks if the sync peer changes, otherwise
-		// we may ignore blocks we need that the last sync peer failed
-		// to send.
-		sm.requestedBlocks = make(map[chainhash.Hash]struct{})
+	// Clear the requestedBlocks if the sync peer changes, otherwise we may
+	// ignore blocks we need that the last sync peer failed to send.
+	sm.requestedBlocks = make(map[chainhash.Hash]struct{})
+	sm.syncPeer = bestPeer
+
+	log.Infof("Syncing to block height %d from peer %v",
+		bestPeer.LastBlock(), bestPeer.Addr())
 
+	// Regression test mode does not support the headers-first approach
+	// since the regression tool does not serve headers, so do normal block
+	// downloads when in regression test mode.
+	if sm.chainParams == &chaincfg.RegressionNetParams {
 		locator, err := sm.chain.LatestBlockLocator()
 		if err != nil {
 			log.Errorf("Failed to get block locator for the "+
 				"latest block: %v", err)
 			return
 		}
+		bestPeer.PushGetBlocksMsg(locator, &zeroHash)
+		return
+	}
 
-		log.Infof("Syncing to block height %d from peer %v",
-			bestPeer.LastBlock(), bestPeer.Addr())
-
-		// When the current height is less than a known checkpoint we
-		// can use block headers to learn about which blocks comprise
-		// the chain up to the checkpoint and perform less validation
-		// for them.  This is possible since each header contains the
-		// hash of the previous header and a merkle root.  Therefore if
-		// we validate all of the received headers link together
-		// properly and the checkpoint hashes match, we can be sure the
-		// hashes for the blocks in between are accurate.  Further, once
-		// the full blocks are downloaded, the merkle root is computed
-		// and compared against the value in the header which proves the
-		// full block hasn't been tampered with.
-		//
-		// Once we have passed the final checkpoint, or checkpoints are
-		// disabled, use standard inv messages learn about the blocks
-		// and fully validate them.  Finally, regression test mode does
-		// not support the headers-first approach so do normal block
-		// downloads when in regression test mode.
-		if sm.nextCheckpoint != nil &&
-			best.Height < sm.nextCheckpoint.Height &&
-			sm.chainParams != &chaincfg.RegressionNetParams {
-
-			bestPeer.PushGetHeadersMsg(locator, sm.nextCheckpoint.Hash)
-			sm.headersFirstMode = true
-			log.Infof("Downloading headers for blocks %d to "+
-				"%d from peer %s", best.Height+1,
-				sm.nextCheckpoint.Height, bestPeer.Addr())
-		} else {
-			bestPeer.PushGetBlocksMsg(locator, &zeroHash)
-		}
-		sm.syncPeer = bestPeer
-	} else {
-		log.Warnf("No sync peer candidates available")
+	// Use block headers to learn which blocks comprise the chain and then
+	// download the blocks they describe from all sync candidates at once.
+	// This is possible since each header contains the hash of the previous
+	// header and a merkle root.  Therefore if we validate all of the
+	// received headers link together properly, we can be sure the hashes
+	// for the blocks are accurate.  Further, once the full blocks are
+	// downloaded, the merkle root is computed and compared against the
+	// value in the header which proves the full block hasn't been tampered
+	// with.
+	//
+	// When the headers match a known checkpoint, the blocks up to it are
+	// eligible for less validation as well.
+	//
+	// A new sync peer continues from the latest header downloaded from the
+	// previous one, so blocks already being downloaded are not discarded.
+	if !sm.headersFirstMode {
+		sm.resetHeaderState(&best.Hash, best.Height)
+		sm.headersFirstMode = true
+		sm.progressLogger.SetLastLogTime(time.Now())
+	}
+	sm.headersSynced = false
+	sm.headersRequested = false
+	sm.startHeadersPresync()
+	sm.fetchBlocks()
+}
+
+// startHeadersPresync starts a headers presync with the sync peer from the
+// latest known header when the chain it ends has less than the minimum chain
+// work.  Otherwise, headers from the sync peer are stored as they arrive.
+func (sm *SyncManager) startHeadersPresync() {
+	sm.headersPresync = nil
+	minWork := sm.chainParams.MinimumChainWork
+	if minWork == nil {
+		return
+	}
+
+	work, err := sm.chain.ChainWork(sm.headerTip.hash)
+	if err != nil {
+		log.Errorf("Failed to get chain work of header %v: %v",
+			sm.headerTip.hash, err)
+		return
+	}
+	if work.Cmp(minWork) >= 0 {
+		return
+	}
+	header, err := sm.chain.HeaderByHash(sm.headerTip.hash)
+	if err != nil {
+		log.Errorf("Failed to get header %v: %v", sm.headerTip.hash, err)
+		return
 	}
+
+	log.Infof("Pre-synchronizing block headers from height %d until the "+
+		"minimum chain work is reached", sm.headerTip.height)
+	sm.headersPresync = newHeadersSyncState(sm.chainParams, &header,
+		sm.headerTip.height, work, time.Now())
 }
 
 // isSyncCandidate returns whether or not the peer is a candidate to consider
@@ -369,11 +449,22 @@ func (sm *SyncManager) handleNewPeerMsg(peer *peerpkg.Peer) {
 		syncCandidate:   isSyncCandidate,
 		requestedTxns:   make(map[chainhash.Hash]struct{}),
 		requestedBlocks: make(map[chainhash.Hash]struct{}),
+		partialBlocks:   make(map[chainhash.Hash]*partialBlock),
+	}
+	if !isSyncCandidate {
+		return
 	}
 
-	// Start syncing by choosing the best candidate if needed.
-	if isSyncCandidate && sm.syncPeer == nil {
+	// Make the peer available for block downloads and start syncing by
+	// choosing the best candidate if needed.
+	sm.downloader.addPeer(peer)
+	if sm.bgDownloader != nil {
+		sm.bgDownloader.addPeer(peer)
+	}
+	if sm.syncPeer == nil {
 		sm.startSync()
+	} else if sm.headersFirstMode {
+		sm.fetchBlocks()
 	}
 }
 
@@ -407,16 +498,31 @@ func (sm *SyncManager) handleDonePeerMsg(peer *peerpkg.Peer) {
 		delete(sm.requestedBlocks, blockHash)
 	}
 
+	// Stop considering the peer for high-bandwidth compact block relay.
+	for i, p := range sm.highBandwidthPeers {
+		if p == peer {
+			sm.highBandwidthPeers = append(sm.highBandwidthPeers[:i],
+				sm.highBandwidthPeers[i+1:]...)
+			break
+		}
+	}
+
+	// Remove the peer from the block downloader so any blocks in flight
+	// from it are requested from other peers.
+	sm.downloader.removePeer(peer)
+	if sm.bgDownloader != nil {
+		sm.bgDownloader.removePeer(peer)
+	}
+
 	// Attempt to find a new peer to sync from if the quitting peer is the
-	// sync peer.  Also, reset the headers-first state if in headers-first
-	// mode so
+	// sync peer.
 	if sm.syncPeer == peer {
 		sm.syncPeer = nil
-		if sm.headersFirstMode {
-			best := sm.chain.BestSnapshot()
-			sm.resetHeaderState(&best.Hash, best.Height)
-		}
 		sm.startSync()
+		return
+	}
+	if sm.headersFirstMode {
+		sm.fetchBlocks()
 	}
 }
 
@@ -439,10 +545,20 @@ func (sm *SyncManager) handleTxMsg(tmsg *txMsg) {
 	// interoperability.
 	txHash := tmsg.tx.Hash()
 
+	// Transactions are requested from peers which negotiated wtxid relay
+	// by witness transaction hash, so the request and rejection
+	// bookkeeping for them is keyed by it as well.  This ensures a copy
+	// with malleated witness data doesn't prevent the valid one from being
+	// downloaded.
+	invHash := txHash
+	if peer.WTxIDRelay() {
+		invHash = tmsg.tx.WitnessHash()
+	}
+
 	// Ignore transactions that we have already rejected.  Do not
 	// send a reject message here because if the transaction was already
 	// rejected, the transaction was unsolicited.
-	if _, exists = sm.rejectedTxns[*txHash]; exists {
+	if _, exists = sm.rejectedTxns[*invHash]; exists {
 		log.Debugf("Ignoring unsolicited previously rejected "+
 			"transaction %v from %s", txHash, peer)
 		return
@@ -457,14 +573,18 @@ func (sm *SyncManager) handleTxMsg(tmsg *txMsg) {
 	// already knows about it and as such we shouldn't have any more
 	// instances of trying to fetch it, or we failed to insert and thus
 	// we'll retry next time we get an inv.
-	delete(state.requestedTxns, *txHash)
-	delete(sm.requestedTxns, *txHash)
+	delete(state.requestedTxns, *invHash)
+	delete(sm.requestedTxns, *invHash)
 
 	if err != nil {
 		// Do not request this transaction again until a new block
-		// has been processed.
+		// has been processed.  Peers which don't relay by witness
+		// transaction hash can't distinguish copies with different
+		// witness data, so the transaction hash is rejected for them.
 		sm.rejectedTxns[*txHash] = struct{}{}
 		sm.limitMap(sm.rejectedTxns, maxRejectedTxns)
+		sm.rejectedTxns[*tmsg.tx.WitnessHash()] = struct{}{}
+		sm.limitMap(sm.rejectedTxns, maxRejectedTxns)
 
 		// When the error is a rule error, it means the transaction was
 		// simply rejected as opposed to something actually going wrong,
@@ -478,6 +598,24 @@ func (sm *SyncManager) handleTxMsg(tmsg *txMsg) {
 				txHash, err)
 		}
 
+		// Relay transactions the peer is permitted to force relay
+		// anyway when they were only rejected due to the local relay
+		// policy.  Since the pool may reject a transaction due to
+		// policy before its inputs and scripts are checked, it must
+		// also pass the consensus rules to be relayed.
+		if tmsg.forceRelay && isPolicyRejection(err) {
+			cerr := sm.txMemPool.CheckTransactionConsensus(tmsg.tx)
+			if cerr == nil {
+				log.Debugf("Force relaying transaction %v "+
+					"from %s", txHash, peer)
+				sm.peerNotifier.RelayRejectedTransaction(tmsg.tx)
+			} else {
+				log.Debugf("Not force relaying invalid "+
+					"transaction %v from %s: %v", txHash,
+					peer, cerr)
+			}
+		}
+
 		// Convert the error into an appropriate reject message and
 		// send it.
 		code, reason := mempool.ErrToRejectErr(err)
@@ -488,6 +626,26 @@ func (sm *SyncManager) handleTxMsg(tmsg *txMsg) {
 	sm.peerNotifier.AnnounceNewTransactions(acceptedTxs)
 }
 
+// isPolicyRejection returns whether the passed error returned by the mempool
+// rejects a transaction due to the local relay policy as opposed to the
+// transaction being invalid.
+func isPolicyRejection(err error) bool {
+	rerr, ok := err.(mempool.RuleError)
+	if !ok {
+		return false
+	}
+	txErr, ok := rerr.Err.(mempool.TxRuleError)
+	if !ok {
+		return false
+	}
+	switch txErr.RejectCode {
+	case wire.RejectDuplicate, wire.RejectNonstandard, wire.RejectDust,
+		wire.RejectInsufficientFee:
+		return true
+	}
+	return false
+}
+
 // current returns true if we believe we are synced with our peers, false if we
 // still have blocks to check
 func (sm *SyncManager) current() bool {
@@ -518,8 +676,35 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 		return
 	}
 
-	// If we didn't ask for this block then the peer is misbehaving.
+	// Blocks downloaded in headers-first mode are tracked by the block
+	// downloader and processed in height order once all of their
+	// ancestors have arrived.  Replies to requests that were abandoned
+	// are still recognized after leaving headers-first mode.
 	blockHash := bmsg.block.Hash()
+	if sm.bgDownloader != nil {
+		switch sm.bgDownloader.blockReceived(peer, bmsg.block) {
+		case downloadAccepted:
+			sm.processBackgroundBlocks()
+			return
+
+		case downloadIgnored:
+			log.Debugf("Ignoring historical block %v from %s which "+
+				"is no longer needed", blockHash, peer)
+			return
+		}
+	}
+	switch sm.downloader.blockReceived(peer, bmsg.block) {
+	case downloadAccepted:
+		sm.processDownloadedBlocks()
+		return
+
+	case downloadIgnored:
+		log.Debugf("Ignoring block %v from %s which is no longer "+
+			"needed", blockHash, peer)
+		return
+	}
+
+	// If we didn't ask for this block then the peer is misbehaving.
 	if _, exists = state.requestedBlocks[*blockHash]; !exists {
 		// The regression test intentionally sends some blocks twice
 		// to test duplicate block insertion fails.  Don't disconnect
@@ -534,39 +719,209 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 		}
 	}
 
-	// When in headers-first mode, if the block matches the hash of the
-	// first header in the list of headers that are being fetched, it's
-	// eligible for less validation since the headers have already been
-	// verified to link together and are valid up to the next checkpoint.
-	// Also, remove the list entry for all blocks except the checkpoint
-	// since it is needed to verify the next round of headers links
-	// properly.
-	isCheckpointBlock := false
-	behaviorFlags := blockchain.BFNone
-	if sm.headersFirstMode {
-		firstNodeEl := sm.headerList.Front()
-		if firstNodeEl != nil {
-			firstNode := firstNodeEl.Value.(*headerNode)
-			if blockHash.IsEqual(firstNode.hash) {
-				behaviorFlags |= blockchain.BFFastAdd
-				if firstNode.hash.IsEqual(sm.nextCheckpoint.Hash) {
-					isCheckpointBlock = true
-				} else {
-					sm.headerList.Remove(firstNodeEl)
-				}
-			}
-		}
-	}
-
 	// Remove block from request maps. Either chain will know about it and
 	// so we shouldn't have any more instances of trying to fetch it, or we
 	// will fail the insert and thus we'll retry next time we get an inv.
 	delete(state.requestedBlocks, *blockHash)
 	delete(sm.requestedBlocks, *blockHash)
 
+	if sm.processBlock(bmsg.block, peer, blockchain.BFNone) == nil {
+		sm.updateHighBandwidthPeers(peer)
+	}
+}
+
+// handleCmpctBlockMsg handles cmpctblock messages from all peers.  The block
+// is reconstructed from the transactions in the memory pool and any missing
+// transactions are requested from the peer.  When the block can't be
+// reconstructed, the full block is requested instead.
+func (sm *SyncManager) handleCmpctBlockMsg(cmsg *cmpctBlockMsg) {
+	peer := cmsg.peer
+	state, exists := sm.peerStates[peer]
+	if !exists {
+		log.Warnf("Received cmpctblock message from unknown peer %s",
+			peer)
+		return
+	}
+
+	// Compact blocks are only useful once the chain is close to the tip,
+	// so ignore them while blocks are being downloaded from headers.
+	msg := cmsg.msg
+	blockHash := msg.BlockHash()
+	if sm.headersFirstMode {
+		log.Debugf("Ignoring compact block %v from %s during initial "+
+			"block download", blockHash, peer)
+		return
+	}
+	if peer.CmpctBlockVersion() == 0 {
+		log.Debugf("Ignoring compact block %v from %s which did not "+
+			"negotiate compact blocks", blockHash, peer)
+		return
+	}
+	peer.AddKnownInventory(wire.NewInvVect(wire.InvTypeBlock, &blockHash))
+
+	// Nothing to do when the block is already known.
+	haveBlock, err := sm.chain.HaveBlock(&blockHash)
+	if err != nil {
+		log.Warnf("Unexpected failure when checking for existing "+
+			"block %v: %v", blockHash, err)
+		return
+	}
+	if haveBlock {
+		delete(state.requestedBlocks, blockHash)
+		delete(sm.requestedBlocks, blockHash)
+		return
+	}
+	if _, ok := state.partialBlocks[blockHash]; ok {
+		return
+	}
+
+	// Blocks which don't connect to a known block can't be validated yet,
+	// so download them in full which leads to the usual orphan handling.
+	prevHash := &msg.Header.PrevBlock
+	havePrev, err := sm.chain.HaveBlock(prevHash)
+	if err != nil || !havePrev || sm.chain.IsKnownOrphan(prevHash) {
+		sm.requestFullBlock(peer, &blockHash)
+		return
+	}
+
+	// Fill the block from the memory pool.
+	txDescs := sm.txMemPool.TxDescs()
+	candidates := make([]*btcutil.Tx, 0, len(txDescs))
+	for _, txD := range txDescs {
+		candidates = append(candidates, txD.Tx)
+	}
+	useWTxID := peer.CmpctBlockVersion() == wire.CmpctBlockVersionWTxID
+	pb, err := newPartialBlock(msg, candidates, useWTxID)
+	if err != nil {
+		log.Debugf("Unable to reconstruct compact block %v from %s: "+
+			"%v", blockHash, peer, err)
+		sm.requestFullBlock(peer, &blockHash)
+		return
+	}
+
+	// Request the transactions which are not in the memory pool.
+	if len(pb.missing) != 0 {
+		log.Debugf("Requesting %d of %d transactions in compact block "+
+			"%v from %s", len(pb.missing), len(pb.txns), blockHash,
+			peer)
+		state.partialBlocks[blockHash] = pb
+		peer.QueueMessage(wire.NewMsgGetBlockTxn(&blockHash,
+			pb.missing), nil)
+		return
+	}
+
+	sm.processReconstructedBlock(pb, peer, state)
+}
+
+// handleBlockTxnMsg handles blocktxn messages from all peers.  The
+// transactions complete a block previously received as a compact block.
+func (sm *SyncManager) handleBlockTxnMsg(bmsg *blockTxnMsg) {
+	peer := bmsg.peer
+	state, exists := sm.peerStates[peer]
+	if !exists {
+		log.Warnf("Received blocktxn message from unknown peer %s", peer)
+		return
+	}
+
+	blockHash := bmsg.msg.BlockHash
+	pb, ok := state.partialBlocks[blockHash]
+	if !ok {
+		log.Debugf("Ignoring unrequested blocktxn for block %v from %s",
+			blockHash, peer)
+		return
+	}
+	delete(state.partialBlocks, blockHash)
+
+	if err := pb.fillMissing(bmsg.msg.Transactions); err != nil {
+		log.Debugf("Invalid blocktxn for block %v from %s: %v",
+			blockHash, peer, err)
+		sm.requestFullBlock(peer, &blockHash)
+		return
+	}
+	sm.processReconstructedBlock(pb, peer, state)
+}
+
+// processReconstructedBlock processes a block reconstructed from a compact
+// block.  The full block is requested from the peer when the reconstructed
+// block doesn't match its header.
+func (sm *SyncManager) processReconstructedBlock(pb *partialBlock,
+	peer *peerpkg.Peer, state *peerSyncState) {
+
+	blockHash := pb.header.BlockHash()
+	block, err := pb.block()
+	if err != nil {
+		log.Debugf("Failed to reconstruct block %v from %s: %v",
+			blockHash, peer, err)
+		sm.requestFullBlock(peer, &blockHash)
+		return
+	}
+
+	delete(state.requestedBlocks, blockHash)
+	delete(sm.requestedBlocks, blockHash)
+
+	if sm.processBlock(block, peer, blockchain.BFNone) == nil {
+		sm.updateHighBandwidthPeers(peer)
+	}
+}
+
+// requestFullBlock requests the full block with the passed hash from the peer.
+// It is used when a block can't be reconstructed from a compact block.
+func (sm *SyncManager) requestFullBlock(peer *peerpkg.Peer, hash *chainhash.Hash) {
+	state, exists := sm.peerStates[peer]
+	if !exists {
+		return
+	}
+	sm.requestedBlocks[*hash] = struct{}{}
+	sm.limitMap(sm.requestedBlocks, maxRequestedBlocks)
+	state.requestedBlocks[*hash] = struct{}{}
+
+	iv := wire.NewInvVect(wire.InvTypeBlock, hash)
+	if peer.IsWitnessEnabled() {
+		iv.Type = wire.InvTypeWitnessBlock
+	}
+	gdmsg := wire.NewMsgGetData()
+	gdmsg.AddInvVect(iv)
+	peer.QueueMessage(gdmsg, nil)
+}
+
+// updateHighBandwidthPeers records that the passed peer delivered a new block.
+// The peers which most recently did so are asked to announce new blocks by
+// sending compact blocks directly, which saves a round trip, while the peer
+// which delivered a block least recently is moved back to low-bandwidth mode
+// when the limit is exceeded.
+func (sm *SyncManager) updateHighBandwidthPeers(peer *peerpkg.Peer) {
+	if peer.CmpctBlockVersion() == 0 || !sm.current() {
+		return
+	}
+
+	for i, p := range sm.highBandwidthPeers {
+		if p == peer {
+			// Move the peer to the most recent position.
+			copy(sm.highBandwidthPeers[i:], sm.highBandwidthPeers[i+1:])
+			sm.highBandwidthPeers[len(sm.highBandwidthPeers)-1] = peer
+			return
+		}
+	}
+
+	if len(sm.highBandwidthPeers) >= maxHighBandwidthPeers {
+		oldest := sm.highBandwidthPeers[0]
+		sm.highBandwidthPeers = sm.highBandwidthPeers[1:]
+		oldest.PushSendCmpctMsg(false)
+	}
+	sm.highBandwidthPeers = append(sm.highBandwidthPeers, peer)
+	peer.PushSendCmpctMsg(true)
+}
+
+// processBlock passes a block received from the passed peer to the chain and
+// updates the peer and sync state accordingly.  It returns the error which
+// caused the block to be rejected, if any.  Orphan blocks are accepted.
+func (sm *SyncManager) processBlock(block *btcutil.Block, peer *peerpkg.Peer,
+	behaviorFlags blockchain.BehaviorFlags) error {
+
 	// Process the block to include validation, best chain selection, orphan
 	// handling, etc.
-	_, isOrphan, err := sm.chain.ProcessBlock(bmsg.block, behaviorFlags)
+	blockHash := block.Hash()
+	_, isOrphan, err := sm.chain.ProcessBlock(block, behaviorFlags)
 	if err != nil {
 		// When the error is a rule error, it means the block was simply
 		// rejected as opposed to something actually going wrong, so log
@@ -588,7 +943,7 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 		// send it.
 		code, reason := mempool.ErrToRejectErr(err)
 		peer.PushRejectMsg(wire.CmdBlock, code, reason, blockHash, false)
-		return
+		return err
 	}
 
 	// Meta-data about the new block this peer is reporting. We use this
@@ -610,9 +965,9 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 		// block height from the scriptSig of the coinbase transaction.
 		// Extraction is only attempted if the block's version is
 		// high enough (ver 2+).
-		header := &bmsg.block.MsgBlock().Header
+		header := &block.MsgBlock().Header
 		if blockchain.ShouldHaveSerializedBlockHeight(header) {
-			coinbaseTx := bmsg.block.Transactions()[0]
+			coinbaseTx := block.Transactions()[0]
 			cbHeight, err := blockchain.ExtractCoinbaseHeight(coinbaseTx)
 			if err != nil {
 				log.Warnf("Unable to extract height from "+
@@ -636,7 +991,7 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 	} else {
 		// When the block is not an orphan, log information about it and
 		// update the chain state.
-		sm.progressLogger.LogBlockHeight(bmsg.block)
+		sm.progressLogger.LogBlockHeight(block)
 
 		// Update this peer's latest block height, for future
 		// potential sync node candidacy.
@@ -660,114 +1015,232 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 		}
 	}
 
-	// Nothing more to do if we aren't in headers-first mode.
-	if !sm.headersFirstMode {
-		return
-	}
+	return nil
+}
 
-	// This is headers-first mode, so if the block is not a checkpoint
-	// request more blocks using the header list when the request queue is
-	// getting short.
-	if !isCheckpointBlock {
-		if sm.startHeader != nil &&
-			len(state.requestedBlocks) < minInFlightBlocks {
-			sm.fetchHeaderBlocks()
+// processDownloadedBlocks processes the blocks downloaded in headers-first
+// mode that are ready in height order and then requests more blocks.
+func (sm *SyncManager) processDownloadedBlocks() {
+	for req := sm.downloader.popReady(); req != nil; req = sm.downloader.popReady() {
+		// Blocks up to the most recently verified checkpoint are
+		// eligible for less validation since their headers have been
+		// verified to link together up to the checkpoint.
+		behaviorFlags := blockchain.BFNone
+		if req.height <= sm.fastAddHeight {
+			behaviorFlags |= blockchain.BFFastAdd
+		}
+
+		// The block downloader is only ever handed sync candidates, so
+		// the peer is always a *peerpkg.Peer.
+		peer := req.from.(*peerpkg.Peer)
+		err := sm.processBlock(req.block, peer, behaviorFlags)
+		if err != nil {
+			// The peer delivered a block which violates the
+			// consensus rules, unless it merely duplicates a block
+			// which was received elsewhere in the meantime.
+			rerr, ok := err.(blockchain.RuleError)
+			if ok && rerr.ErrorCode != blockchain.ErrDuplicateBlock {
+				sm.peerNotifier.AddBanScore(peer,
+					invalidBlockBanScore, 0, "block")
+			}
+
+			// The headers which led to the rejected block can no
+			// longer be trusted, so discard the download queue and
+			// start over from the current best chain.
+			best := sm.chain.BestSnapshot()
+			sm.resetHeaderState(&best.Hash, best.Height)
+			sm.syncPeer = nil
+			sm.startSync()
+			return
 		}
-		return
 	}
 
-	// This is headers-first mode and the block is a checkpoint.  When
-	// there is a next checkpoint, get the next round of headers by asking
-	// for headers starting from the block after this one up to the next
-	// checkpoint.
-	prevHeight := sm.nextCheckpoint.Height
-	prevHash := sm.nextCheckpoint.Hash
-	sm.nextCheckpoint = sm.findNextHeaderCheckpoint(prevHeight)
-	if sm.nextCheckpoint != nil {
-		locator := blockchain.BlockLocator([]*chainhash.Hash{prevHash})
-		err := peer.PushGetHeadersMsg(locator, sm.nextCheckpoint.Hash)
+	sm.fetchBlocks()
+}
+
+// processBackgroundBlocks processes the historical blocks downloaded for the
+// background chain that are ready in height order and then requests more
+// blocks.
+func (sm *SyncManager) processBackgroundBlocks() {
+	for req := sm.bgDownloader.popReady(); req != nil; req = sm.bgDownloader.popReady() {
+		_, _, err := sm.bgChain.ProcessBlock(req.block, blockchain.BFNone)
 		if err != nil {
-			log.Warnf("Failed to send getheaders message to "+
-				"peer %s: %v", peer.Addr(), err)
+			// Start over from the current background chain since
+			// the block is requested again from another peer.
+			log.Errorf("Failed to process historical block %v: %v",
+				req.hash, err)
+			sm.bgDownloader.reset()
+			sm.bgQueuedHeight = sm.bgChain.BestSnapshot().Height
 			return
 		}
-		log.Infof("Downloading headers for blocks %d to %d from "+
-			"peer %s", prevHeight+1, sm.nextCheckpoint.Height,
-			sm.syncPeer.Addr())
+	}
+
+	sm.fetchBackgroundBlocks()
+}
+
+// validateSnapshot validates the utxo snapshot the chain state was loaded from
+// against the background chain once it has reached the base block.  The
+// background chain is no longer needed afterwards.  A shutdown is requested
+// when the snapshot does not match the validated history since the chain state
+// loaded from it can't be trusted.
+func (sm *SyncManager) validateSnapshot() {
+	err := sm.chain.ValidateSnapshot(sm.bgChain)
+	sm.bgChain = nil
+	sm.bgDownloader = nil
+	if err == nil {
 		return
 	}
 
-	// This is headers-first mode, the block is a checkpoint, and there are
-	// no more checkpoints, so switch to normal mode by requesting blocks
-	// from the block after this one up to the end of the chain (zero hash).
-	sm.headersFirstMode = false
-	sm.headerList.Init()
-	log.Infof("Reached the final checkpoint -- switching to normal mode")
-	locator := blockchain.BlockLocator([]*chainhash.Hash{blockHash})
-	err = peer.PushGetBlocksMsg(locator, &zeroHash)
-	if err != nil {
-		log.Warnf("Failed to send getblocks message to peer %s: %v",
-			peer.Addr(), err)
+	rerr, ok := err.(blockchain.RuleError)
+	if !ok || rerr.ErrorCode != blockchain.ErrBadUtxoSnapshot {
+		log.Errorf("Unable to validate the utxo snapshot the chain "+
+			"state was loaded from: %v", err)
 		return
 	}
+	log.Errorf("The utxo snapshot the chain state was loaded from does "+
+		"not match the validated history: %v -- shutting down to "+
+		"continue from the validated history", err)
+	if sm.requestShutdown != nil {
+		sm.requestShutdown()
+	}
 }
 
-// fetchHeaderBlocks creates and sends a request to the syncPeer for the next
-// list of blocks to be downloaded based on the current list of headers.
-func (sm *SyncManager) fetchHeaderBlocks() {
-	// Nothing to do if there is no start header.
-	if sm.startHeader == nil {
-		log.Warnf("fetchHeaderBlocks called with no start header")
+// fetchBackgroundBlocks queues the historical blocks the background chain
+// needs up to the base block of the utxo snapshot for download and requests
+// them.  The download only starts once the chain is current so it does not
+// compete with catching up to the tip.
+func (sm *SyncManager) fetchBackgroundBlocks() {
+	if sm.bgDownloader == nil || !sm.current() {
 		return
 	}
 
-	// Build up a getdata request for the list of blocks the headers
-	// describe.  The size hint will be limited to wire.MaxInvPerMsg by
-	// the function, so no need to double check it here.
-	gdmsg := wire.NewMsgGetDataSizeHint(uint(sm.headerList.Len()))
-	numRequested := 0
-	for e := sm.startHeader; e != nil; e = e.Next() {
-		node, ok := e.Value.(*headerNode)
-		if !ok {
-			log.Warn("Header list node type is not a headerNode")
-			continue
-		}
+	baseHash, baseHeight := sm.chain.SnapshotBase()
+	if sm.bgChain.BestSnapshot().Hash == *baseHash {
+		sm.validateSnapshot()
+		return
+	}
+	for sm.bgQueuedHeight < baseHeight &&
+		sm.bgDownloader.queued() < minQueuedBlocks {
 
-		iv := wire.NewInvVect(wire.InvTypeBlock, node.hash)
-		haveInv, err := sm.haveInventory(iv)
+		height := sm.bgQueuedHeight + 1
+		hash, err := sm.chain.BlockHashByHeight(height)
 		if err != nil {
-			log.Warnf("Unexpected failure when checking for "+
-				"existing inventory during header block "+
-				"fetch: %v", err)
+			log.Errorf("Failed to look up historical block at "+
+				"height %d: %v", height, err)
+			return
 		}
-		if !haveInv {
-			syncPeerState := sm.peerStates[sm.syncPeer]
+		sm.bgDownloader.addBlock(hash, height)
+		sm.bgQueuedHeight = height
+	}
+	sm.bgDownloader.requestBlocks(time.Now())
+}
 
-			sm.requestedBlocks[*node.hash] = struct{}{}
-			syncPeerState.requestedBlocks[*node.hash] = struct{}{}
+// fetchHeaders requests the headers following the latest known header from the
+// sync peer unless a request is already outstanding.
+func (sm *SyncManager) fetchHeaders() {
+	if sm.syncPeer == nil || sm.headersRequested {
+		return
+	}
 
-			// If we're fetching from a witness enabled peer
-			// post-fork, then ensure that we receive all the
-			// witness data in the blocks.
-			if sm.syncPeer.IsWitnessEnabled() {
-				iv.Type = wire.InvTypeWitnessBlock
-			}
+	// Prefer the latest header of a presync in progress followed by the
+	// latest known header, but fall back to the main chain so the sync
+	// peer is able to locate the fork point when it does not know about
+	// them.
+	locator, err := sm.chain.LatestBlockLocator()
+	if err != nil {
+		log.Errorf("Failed to get block locator for the latest "+
+			"block: %v", err)
+		return
+	}
+	var prefix blockchain.BlockLocator
+	if sm.headersPresync != nil {
+		prefix = append(prefix, sm.headersPresync.locatorHash())
+	}
+	if !locator[0].IsEqual(sm.headerTip.hash) {
+		prefix = append(prefix, sm.headerTip.hash)
+	}
+	locator = append(prefix, locator...)
+	err = sm.syncPeer.PushGetHeadersMsg(locator, &zeroHash)
+	if err != nil {
+		log.Warnf("Failed to send getheaders message to peer %s: %v",
+			sm.syncPeer.Addr(), err)
+		return
+	}
+	sm.headersRequested = true
+}
+
+// fetchBlocks requests the blocks in the download window from the sync
+// candidates and requests more headers from the sync peer once the download
+// queue runs low.  Once every header the sync peer knows about has been
+// downloaded and all of the blocks they describe have been processed,
+// headers-first mode ends and new blocks are learned about through inventory
+// announcements.
+func (sm *SyncManager) fetchBlocks() {
+	if !sm.headersSynced && sm.downloader.queued() < minQueuedBlocks {
+		sm.fetchHeaders()
+	}
+	sm.downloader.requestBlocks(time.Now())
+
+	if !sm.headersSynced || sm.downloader.queued() != 0 {
+		return
+	}
 
-			gdmsg.AddInvVect(iv)
-			numRequested++
+	// Switch to normal mode by requesting blocks from the end of the
+	// chain up to the end of the sync peer's chain (zero hash) in case any
+	// were announced while the download was in progress.
+	sm.headersFirstMode = false
+	log.Infof("Downloaded all known headers and blocks -- switching to " +
+		"normal mode")
+	if sm.syncPeer == nil {
+		return
+	}
+	locator, err := sm.chain.LatestBlockLocator()
+	if err != nil {
+		log.Errorf("Failed to get block locator for the latest "+
+			"block: %v", err)
+		return
+	}
+	err = sm.syncPeer.PushGetBlocksMsg(locator, &zeroHash)
+	if err != nil {
+		log.Warnf("Failed to send getblocks message to peer %s: %v",
+			sm.syncPeer.Addr(), err)
+	}
+}
+
+// handleStallSample reassigns block requests that have been outstanding for
+// too long in headers-first mode to other peers.
+func (sm *SyncManager) handleStallSample() {
+	if sm.bgDownloader != nil {
+		for _, peer := range sm.bgDownloader.checkStalls(time.Now()) {
+			log.Infof("Peer %v stalled the historical block "+
+				"download -- reassigning its requests", peer)
 		}
-		sm.startHeader = e.Next()
-		if numRequested >= wire.MaxInvPerMsg {
-			break
+		for _, peer := range sm.bgDownloader.removeStalledPeers(time.Now()) {
+			log.Infof("Peer %v remained stalled during the "+
+				"historical block download -- disconnecting", peer)
+			peer.(*peerpkg.Peer).Disconnect()
 		}
+		sm.fetchBackgroundBlocks()
 	}
-	if len(gdmsg.InvList) > 0 {
-		sm.syncPeer.QueueMessage(gdmsg, nil)
+
+	if !sm.headersFirstMode {
+		return
+	}
+
+	for _, peer := range sm.downloader.checkStalls(time.Now()) {
+		log.Infof("Peer %v stalled the block download -- reassigning "+
+			"its requests", peer)
 	}
+	for _, peer := range sm.downloader.removeStalledPeers(time.Now()) {
+		log.Infof("Peer %v remained stalled during the block download "+
+			"-- disconnecting", peer)
+		peer.(*peerpkg.Peer).Disconnect()
+	}
+	sm.fetchBlocks()
 }
 
 // handleHeadersMsg handles block header messages from all peers.  Headers are
-// requested when performing a headers-first sync.
+// requested from the sync peer when performing a headers-first sync.
 func (sm *SyncManager) handleHeadersMsg(hmsg *headersMsg) {
 	peer := hmsg.peer
 	_, exists := sm.peerStates[peer]
@@ -786,92 +1259,109 @@ func (sm *SyncManager) handleHeadersMsg(hmsg *headersMsg) {
 		return
 	}
 
-	// Nothing to do for an empty headers message.
-	if numHeaders == 0 {
+	// Headers are only requested from the sync peer, so ignore any that
+	// arrive from elsewhere or after a change of sync peer.
+	if peer != sm.syncPeer || !sm.headersRequested {
+		log.Debugf("Ignoring %d unrequested headers from %s",
+			numHeaders, peer)
 		return
 	}
+	sm.headersRequested = false
+
+	// Headers which build a chain with less than the minimum chain work
+	// are only stored once the presync shows the chain reaches it.
+	headers := msg.Headers
+	if sm.headersPresync != nil {
+		var err error
+		headers, err = sm.headersPresync.processHeaders(headers,
+			numHeaders == wire.MaxBlockHeadersPerMsg)
+		if err != nil {
+			log.Warnf("Headers presync with peer %s failed: %v -- "+
+				"disconnecting", peer.Addr(), err)
+			if serr, ok := err.(headersSyncError); ok {
+				sm.peerNotifier.AddBanScore(peer, serr.banScore, 0,
+					"headers")
+			}
+			sm.abortHeaderSync(peer)
+			return
+		}
+		if sm.headersPresync.done() {
+			sm.headersPresync = nil
+		}
+	}
 
 	// Process all of the received headers ensuring each one connects to the
-	// previous and that checkpoints match.
-	receivedCheckpoint := false
-	var finalHash *chainhash.Hash
-	for _, blockHeader := range msg.Headers {
+	// previous and that checkpoints match.  The headers are stored in the
+	// block index and the blocks they describe are queued for download.
+	for _, blockHeader := range headers {
 		blockHash := blockHeader.BlockHash()
-		finalHash = &blockHash
-
-		// Ensure there is a previous header to compare against.
-		prevNodeEl := sm.headerList.Back()
-		if prevNodeEl == nil {
-			log.Warnf("Header list does not contain a previous" +
-				"element as expected -- disconnecting peer")
-			peer.Disconnect()
-			return
-		}
 
-		// Ensure the header properly connects to the previous one and
-		// add it to the list of headers.
-		node := headerNode{hash: &blockHash}
-		prevNode := prevNodeEl.Value.(*headerNode)
-		if prevNode.hash.IsEqual(&blockHeader.PrevBlock) {
-			node.height = prevNode.height + 1
-			e := sm.headerList.PushBack(&node)
-			if sm.startHeader == nil {
-				sm.startHeader = e
-			}
-		} else {
+		// Ensure the header properly connects to the previous one.
+		if !sm.headerTip.hash.IsEqual(&blockHeader.PrevBlock) {
 			log.Warnf("Received block header that does not "+
 				"properly connect to the chain from peer %s "+
 				"-- disconnecting", peer.Addr())
-			peer.Disconnect()
+			sm.abortHeaderSync(peer)
 			return
 		}
+		node := headerNode{height: sm.headerTip.height + 1, hash: &blockHash}
 
 		// Verify the header at the next checkpoint height matches.
-		if node.height == sm.nextCheckpoint.Height {
-			if node.hash.IsEqual(sm.nextCheckpoint.Hash) {
-				receivedCheckpoint = true
-				log.Infof("Verified downloaded block "+
-					"header against checkpoint at height "+
-					"%d/hash %s", node.height, node.hash)
-			} else {
+		if sm.nextCheckpoint != nil &&
+			node.height == sm.nextCheckpoint.Height {
+
+			if !node.hash.IsEqual(sm.nextCheckpoint.Hash) {
 				log.Warnf("Block header at height %d/hash "+
 					"%s from peer %s does NOT match "+
 					"expected checkpoint hash of %s -- "+
 					"disconnecting", node.height,
 					node.hash, peer.Addr(),
 					sm.nextCheckpoint.Hash)
-				peer.Disconnect()
+				sm.abortHeaderSync(peer)
 				return
 			}
-			break
+
+			log.Infof("Verified downloaded block header against "+
+				"checkpoint at height %d/hash %s", node.height,
+				node.hash)
+			sm.fastAddHeight = node.height
+			sm.nextCheckpoint = sm.findNextHeaderCheckpoint(node.height)
 		}
-	}
 
-	// When this header is a checkpoint, switch to fetching the blocks for
-	// all of the headers since the last checkpoint.
-	if receivedCheckpoint {
-		// Since the first entry of the list is always the final block
-		// that is already in the database and is only used to ensure
-		// the next header links properly, it must be removed before
-		// fetching the blocks.
-		sm.headerList.Remove(sm.headerList.Front())
-		log.Infof("Received %v block headers: Fetching blocks",
-			sm.headerList.Len())
-		sm.progressLogger.SetLastLogTime(time.Now())
-		sm.fetchHeaderBlocks()
-		return
+		err := sm.chain.ProcessBlockHeader(blockHeader, blockchain.BFNone)
+		if err != nil {
+			log.Warnf("Rejected block header %v from peer %s: %v -- "+
+				"disconnecting", node.hash, peer.Addr(), err)
+			if _, ok := err.(blockchain.RuleError); ok {
+				sm.peerNotifier.AddBanScore(peer,
+					invalidHeadersBanScore, 0, "headers")
+			}
+			sm.abortHeaderSync(peer)
+			return
+		}
+
+		sm.headerTip = node
+		sm.downloader.addBlock(node.hash, node.height)
 	}
 
-	// This header is not a checkpoint, so request the next batch of
-	// headers starting from the latest known header and ending with the
-	// next checkpoint.
-	locator := blockchain.BlockLocator([]*chainhash.Hash{finalHash})
-	err := peer.PushGetHeadersMsg(locator, sm.nextCheckpoint.Hash)
-	if err != nil {
-		log.Warnf("Failed to send getheaders message to "+
-			"peer %s: %v", peer.Addr(), err)
-		return
+	// A full headers message means the sync peer likely has more headers.
+	// Otherwise, all of the headers it knows about have been downloaded.
+	// The presync ensures the chain doesn't end while it is in progress.
+	if sm.headersPresync == nil && numHeaders < wire.MaxBlockHeadersPerMsg {
+		sm.headersSynced = true
+		log.Infof("Received all block headers up to height %d from "+
+			"peer %s", sm.headerTip.height, peer.Addr())
 	}
+	sm.fetchBlocks()
+}
+
+// abortHeaderSync discards the headers-first state after the passed sync peer
+// sent invalid headers and disconnects it.  A new sync peer is selected once
+// the disconnect is processed.
+func (sm *SyncManager) abortHeaderSync(peer *peerpkg.Peer) {
+	best := sm.chain.BestSnapshot()
+	sm.resetHeaderState(&best.Hash, best.Height)
+	peer.Disconnect()
 }
 
 // haveInventory returns whether or not the inventory represented by the passed
@@ -918,6 +1408,13 @@ func (sm *SyncManager) haveInventory(invVect *wire.InvVect) (bool, error) {
 		}
 
 		return false, nil
+
+	case wire.InvTypeWTx:
+		// Ask the transaction memory pool if the transaction is known
+		// to it in any form (main pool or orphan).  Confirmed
+		// transactions can't be looked up by witness transaction hash,
+		// so they are not checked.
+		return sm.txMemPool.HaveTransactionByWTxID(&invVect.Hash), nil
 	}
 
 	// The requested inventory is is an unsupported type, so just claim
@@ -978,9 +1475,17 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 		// Ignore unsupported inventory types.
 		switch iv.Type {
 		case wire.InvTypeBlock:
-		case wire.InvTypeTx:
 		case wire.InvTypeWitnessBlock:
-		case wire.InvTypeWitnessTx:
+		case wire.InvTypeTx, wire.InvTypeWitnessTx:
+			// Peers which negotiated wtxid relay must announce
+			// transactions by witness transaction hash.
+			if peer.WTxIDRelay() {
+				continue
+			}
+		case wire.InvTypeWTx:
+			if !peer.WTxIDRelay() {
+				continue
+			}
 		default:
 			continue
 		}
@@ -1003,7 +1508,7 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 			continue
 		}
 		if !haveInv {
-			if iv.Type == wire.InvTypeTx {
+			if iv.Type == wire.InvTypeTx || iv.Type == wire.InvTypeWTx {
 				// Skip the transaction if it has already been
 				// rejected.
 				if _, exists := sm.rejectedTxns[iv.Hash]; exists {
@@ -1090,6 +1595,17 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 					iv.Type = wire.InvTypeWitnessBlock
 				}
 
+				// Request a compact block instead when the
+				// chain is current since the peer most likely
+				// already sent us most of its transactions.
+				// Only compact blocks which include witness
+				// data are used.
+				if sm.current() && peer.CmpctBlockVersion() ==
+					wire.CmpctBlockVersionWTxID {
+
+					iv.Type = wire.InvTypeCmpctBlock
+				}
+
 				gdmsg.AddInvVect(iv)
 				numRequested++
 			}
@@ -1110,6 +1626,18 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 					iv.Type = wire.InvTypeWitnessTx
 				}
 
+				gdmsg.AddInvVect(iv)
+				numRequested++
+			}
+
+		case wire.InvTypeWTx:
+			// Request the transaction by witness transaction hash
+			// if there is not already a pending request.
+			if _, exists := sm.requestedTxns[iv.Hash]; !exists {
+				sm.requestedTxns[iv.Hash] = struct{}{}
+				sm.limitMap(sm.requestedTxns, maxRequestedTxns)
+				state.requestedTxns[iv.Hash] = struct{}{}
+
 				gdmsg.AddInvVect(iv)
 				numRequested++
 			}
@@ -1150,6 +1678,9 @@ func (sm *SyncManager) limitMap(m map[chainhash.Hash]struct{}, limit int) {
 // important because the sync manager controls which blocks are needed and how
 // the fetching should proceed.
 func (sm *SyncManager) blockHandler() {
+	stallTicker := time.NewTicker(stallSampleInterval)
+	defer stallTicker.Stop()
+
 out:
 	for {
 		select {
@@ -1166,6 +1697,14 @@ out:
 				sm.handleBlockMsg(msg)
 				msg.reply <- struct{}{}
 
+			case *cmpctBlockMsg:
+				sm.handleCmpctBlockMsg(msg)
+				msg.reply <- struct{}{}
+
+			case *blockTxnMsg:
+				sm.handleBlockTxnMsg(msg)
+				msg.reply <- struct{}{}
+
 			case *invMsg:
 				sm.handleInvMsg(msg)
 
@@ -1209,6 +1748,9 @@ out:
 					"handler: %T", msg)
 			}
 
+		case <-stallTicker.C:
+			sm.handleStallSample()
+
 		case <-sm.quit:
 			break out
 		}
@@ -1319,15 +1861,17 @@ func (sm *SyncManager) NewPeer(peer *peerpkg.Peer) {
 
 // QueueTx adds the passed transaction message and peer to the block handling
 // queue. Responds to the done channel argument after the tx message is
-// processed.
-func (sm *SyncManager) QueueTx(tx *btcutil.Tx, peer *peerpkg.Peer, done chan struct{}) {
+// processed.  When forceRelay is set, the transaction is relayed even when it
+// is rejected due to the local relay policy.
+func (sm *SyncManager) QueueTx(tx *btcutil.Tx, peer *peerpkg.Peer, forceRelay bool, done chan struct{}) {
 	// Don't accept more transactions if we're shutting down.
 	if atomic.LoadInt32(&sm.shutdown) != 0 {
 		done <- struct{}{}
 		return
 	}
 
-	sm.msgChan <- &txMsg{tx: tx, peer: peer, reply: done}
+	sm.msgChan <- &txMsg{tx: tx, peer: peer, forceRelay: forceRelay,
+		reply: done}
 }
 
 // QueueBlock adds the passed block message and peer to the block handling
@@ -1343,6 +1887,32 @@ func (sm *SyncManager) QueueBlock(block *btcutil.Block, peer *peerpkg.Peer, done
 	sm.msgChan <- &blockMsg{block: block, peer: peer, reply: done}
 }
 
+// QueueCmpctBlock adds the passed cmpctblock message and peer to the block
+// handling queue.  Responds to the done channel argument after the message is
+// processed.
+func (sm *SyncManager) QueueCmpctBlock(msg *wire.MsgCmpctBlock, peer *peerpkg.Peer, done chan struct{}) {
+	// Don't accept more blocks if we're shutting down.
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		done <- struct{}{}
+		return
+	}
+
+	sm.msgChan <- &cmpctBlockMsg{msg: msg, peer: peer, reply: done}
+}
+
+// QueueBlockTxn adds the passed blocktxn message and peer to the block
+// handling queue.  Responds to the done channel argument after the message is
+// processed.
+func (sm *SyncManager) QueueBlockTxn(msg *wire.MsgBlockTxn, peer *peerpkg.Peer, done chan struct{}) {
+	// Don't accept more blocks if we're shutting down.
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		done <- struct{}{}
+		return
+	}
+
+	sm.msgChan <- &blockTxnMsg{msg: msg, peer: peer, reply: done}
+}
+
 // QueueInv adds the passed inv message and peer to the block handling queue.
 func (sm *SyncManager) QueueInv(inv *wire.MsgInv, peer *peerpkg.Peer) {
 	// No channel handling here because peers do not need to block on inv
@@ -1451,22 +2021,34 @@ func New(config *Config) (*SyncManager, error) {
 		peerStates:      make(map[*peerpkg.Peer]*peerSyncState),
 		progressLogger:  newBlockProgressLogger("Processed", log),
 		msgChan:         make(chan interface{}, config.MaxPeers*3),
-		headerList:      list.New(),
-		quit:            make(chan struct{}),
-		feeEstimator:    config.FeeEstimator,
+		downloader: newBlockDownloader(blockDownloadWindow,
+			maxBlocksInFlightPerPeer, blockStallTimeout,
+			stalledPeerTimeout),
+		disableCheckpoints: config.DisableCheckpoints,
+		quit:               make(chan struct{}),
+		feeEstimator:       config.FeeEstimator,
 	}
 
+	// Initialize the header state, including the next checkpoint, based on
+	// the current best chain.
 	best := sm.chain.BestSnapshot()
-	if !config.DisableCheckpoints {
-		// Initialize the next checkpoint based on the current height.
-		sm.nextCheckpoint = sm.findNextHeaderCheckpoint(best.Height)
-		if sm.nextCheckpoint != nil {
-			sm.resetHeaderState(&best.Hash, best.Height)
-		}
-	} else {
+	sm.resetHeaderState(&best.Hash, best.Height)
+	if config.DisableCheckpoints {
 		log.Info("Checkpoints are disabled")
 	}
 
+	// Validate the history up to the base block of the utxo snapshot the
+	// chain state was loaded from in the background unless it already has
+	// been.
+	if config.BackgroundChain != nil && !sm.chain.IsSnapshotValidated() {
+		sm.bgChain = config.BackgroundChain
+		sm.bgDownloader = newBlockDownloader(blockDownloadWindow,
+			maxBlocksInFlightPerPeer, blockStallTimeout,
+			stalledPeerTimeout)
+		sm.bgQueuedHeight = sm.bgChain.BestSnapshot().Height
+		sm.requestShutdown = config.RequestShutdown
+	}
+
 	sm.chain.Subscribe(sm.handleBlockchainNotification)
 
 	return &sm, nil
@@ -55,6 +55,13 @@ var simNetParams = params{
 	rpcPort: "18556",
 }
 
+// sigNetParams contains parameters specific to the signet test network defined
+// by the signetchallenge option.  The chain parameters are only set once the
+// options are loaded since they depend on the challenge.
+var sigNetParams = params{
+	rpcPort: "38332",
+}
+
 // netName returns the name used when referring to a bitcoin network.  At the
 // time of writing, btcd currently places blocks for testnet version 3 in the
 // data and log directory "testnet", which does not match the Name field of the
@@ -21,6 +21,7 @@ import (
 	"github.com/organicbitcoin/obtcd/blockchain"
 	"github.com/organicbitcoin/obtcd/chaincfg"
 	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
+	"github.com/organicbitcoin/obtcd/v2transport"
 	"github.com/organicbitcoin/obtcd/wire"
 	"github.com/btcsuite/go-socks/socks"
 	"github.com/davecgh/go-spew/spew"
@@ -28,7 +29,7 @@ import (
 
 const (
 	// MaxProtocolVersion is the max protocol version the peer supports.
-	MaxProtocolVersion = wire.FeeFilterVersion
+	MaxProtocolVersion = wire.WTxIDRelayVersion
 
 	// DefaultTrickleInterval is the min time between attempts to send an
 	// inv message to a peer.
@@ -201,6 +202,33 @@ type MessageListeners struct {
 	// message.
 	OnSendHeaders func(p *Peer, msg *wire.MsgSendHeaders)
 
+	// OnSendCmpct is invoked when a peer receives a sendcmpct bitcoin
+	// message.
+	OnSendCmpct func(p *Peer, msg *wire.MsgSendCmpct)
+
+	// OnCmpctBlock is invoked when a peer receives a cmpctblock bitcoin
+	// message.
+	OnCmpctBlock func(p *Peer, msg *wire.MsgCmpctBlock)
+
+	// OnGetBlockTxn is invoked when a peer receives a getblocktxn bitcoin
+	// message.
+	OnGetBlockTxn func(p *Peer, msg *wire.MsgGetBlockTxn)
+
+	// OnBlockTxn is invoked when a peer receives a blocktxn bitcoin
+	// message.
+	OnBlockTxn func(p *Peer, msg *wire.MsgBlockTxn)
+
+	// OnWTxIDRelay is invoked when a peer receives a wtxidrelay bitcoin
+	// message.
+	OnWTxIDRelay func(p *Peer, msg *wire.MsgWTxIDRelay)
+
+	// OnAddrV2 is invoked when a peer receives an addrv2 bitcoin message.
+	OnAddrV2 func(p *Peer, msg *wire.MsgAddrV2)
+
+	// OnSendAddrV2 is invoked when a peer receives a sendaddrv2 bitcoin
+	// message.
+	OnSendAddrV2 func(p *Peer, msg *wire.MsgSendAddrV2)
+
 	// OnRead is invoked when a peer receives a bitcoin message.  It
 	// consists of the number of bytes read, the message, and whether or not
 	// an error in the read occurred.  Typically, callers will opt to use
@@ -275,6 +303,12 @@ type Config struct {
 	// TrickleInterval is the duration of the ticker which trickles down the
 	// inventory to a peer.
 	TrickleInterval time.Duration
+
+	// V2Transport specifies whether the encrypted v2 transport (BIP0324)
+	// is used.  Outbound peers initiate a v2 handshake while inbound peers
+	// accept both v2 handshakes and plaintext v1 connections.  When it is
+	// not set, only the plaintext v1 transport is used.
+	V2Transport bool
 }
 
 // minUint32 is a helper function to return the minimum of two uint32s.
@@ -287,14 +321,14 @@ func minUint32(a, b uint32) uint32 {
 }
 
 // newNetAddress attempts to extract the IP address and port from the passed
-// net.Addr interface and create a bitcoin NetAddress structure using that
+// net.Addr interface and create a bitcoin NetAddressV2 structure using that
 // information.
-func newNetAddress(addr net.Addr, services wire.ServiceFlag) (*wire.NetAddress, error) {
+func newNetAddress(addr net.Addr, services wire.ServiceFlag) (*wire.NetAddressV2, error) {
 	// addr will be a net.TCPAddr when not using a proxy.
 	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
 		ip := tcpAddr.IP
 		port := uint16(tcpAddr.Port)
-		na := wire.NewNetAddressIPPort(ip, port, services)
+		na := wire.NewNetAddressV2IPPort(ip, port, services)
 		return na, nil
 	}
 
@@ -305,7 +339,7 @@ func newNetAddress(addr net.Addr, services wire.ServiceFlag) (*wire.NetAddress,
 			ip = net.ParseIP("0.0.0.0")
 		}
 		port := uint16(proxiedAddr.Port)
-		na := wire.NewNetAddressIPPort(ip, port, services)
+		na := wire.NewNetAddressV2IPPort(ip, port, services)
 		return na, nil
 	}
 
@@ -321,7 +355,7 @@ func newNetAddress(addr net.Addr, services wire.ServiceFlag) (*wire.NetAddress,
 	if err != nil {
 		return nil, err
 	}
-	na := wire.NewNetAddressIPPort(ip, uint16(port), services)
+	na := wire.NewNetAddressV2IPPort(ip, uint16(port), services)
 	return na, nil
 }
 
@@ -386,12 +420,12 @@ type StatsSnap struct {
 type HashFunc func() (hash *chainhash.Hash, height int32, err error)
 
 // AddrFunc is a func which takes an address and returns a related address.
-type AddrFunc func(remoteAddr *wire.NetAddress) *wire.NetAddress
+type AddrFunc func(remoteAddr *wire.NetAddressV2) *wire.NetAddressV2
 
 // HostToNetAddrFunc is a func which takes a host, port, services and returns
 // the netaddress.
 type HostToNetAddrFunc func(host string, port uint16,
-	services wire.ServiceFlag) (*wire.NetAddress, error)
+	services wire.ServiceFlag) (*wire.NetAddressV2, error)
 
 // NOTE: The overall data flow of a peer is split into 3 goroutines.  Inbound
 // messages are read via the inHandler goroutine and generally dispatched to
@@ -430,6 +464,14 @@ type Peer struct {
 
 	conn net.Conn
 
+	// connReader is the reader messages are read from when the plaintext
+	// v1 transport is used.  It is normally the connection itself, but
+	// replays the bytes inspected while detecting the transport of an
+	// inbound peer first.  v2 is the encrypted transport session when the
+	// v2 transport is used.  Both are only set during the handshake.
+	connReader io.Reader
+	v2         *v2transport.Transport
+
 	// These fields are set at creation time and never modified, so they are
 	// safe to read from concurrently without a mutex.
 	addr    string
@@ -437,7 +479,7 @@ type Peer struct {
 	inbound bool
 
 	flagsMtx             sync.Mutex // protects the peer flags below
-	na                   *wire.NetAddress
+	na                   *wire.NetAddressV2
 	id                   int32
 	userAgent            string
 	services             wire.ServiceFlag
@@ -448,6 +490,25 @@ type Peer struct {
 	verAckReceived       bool
 	witnessEnabled       bool
 
+	// The following fields track compact block relay (BIP0152) as
+	// negotiated via sendcmpct messages.  cmpctBlockVersion is the highest
+	// compact block version the remote peer announced that is also
+	// supported locally, or zero when compact blocks are not supported.
+	// sendCmpctPreferred is set when the remote peer requested new blocks
+	// be announced to it with cmpctblock messages (high-bandwidth mode).
+	cmpctBlockVersion  uint64
+	sendCmpctPreferred bool
+
+	// wtxidRelay is set when the remote peer sent a wtxidrelay message
+	// during the handshake, in which case transactions are announced and
+	// requested by witness transaction hash (BIP0339).
+	wtxidRelay bool
+
+	// sendAddrV2 is set when the remote peer sent a sendaddrv2 message
+	// during the handshake, in which case addresses are relayed to it with
+	// addrv2 messages (BIP0155).
+	sendAddrV2 bool
+
 	wireEncoding wire.MessageEncoding
 
 	knownInventory     *mruInventoryMap
@@ -520,6 +581,14 @@ func (p *Peer) AddKnownInventory(invVect *wire.InvVect) {
 	p.knownInventory.Add(invVect)
 }
 
+// IsKnownInventory returns whether the passed inventory is in the cache of
+// known inventory for the peer.
+//
+// This function is safe for concurrent access.
+func (p *Peer) IsKnownInventory(invVect *wire.InvVect) bool {
+	return p.knownInventory.Exists(invVect)
+}
+
 // StatsSnapshot returns a snapshot of the current peer flags and statistics.
 //
 // This function is safe for concurrent access.
@@ -573,7 +642,7 @@ func (p *Peer) ID() int32 {
 // NA returns the peer network address.
 //
 // This function is safe for concurrent access.
-func (p *Peer) NA() *wire.NetAddress {
+func (p *Peer) NA() *wire.NetAddressV2 {
 	p.flagsMtx.Lock()
 	na := p.na
 	p.flagsMtx.Unlock()
@@ -796,6 +865,148 @@ func (p *Peer) WantsHeaders() bool {
 	return sendHeadersPreferred
 }
 
+// CmpctBlockVersion returns the compact block version negotiated with the peer,
+// or zero when the peer does not support compact block relay.
+//
+// This function is safe for concurrent access.
+func (p *Peer) CmpctBlockVersion() uint64 {
+	p.flagsMtx.Lock()
+	version := p.cmpctBlockVersion
+	p.flagsMtx.Unlock()
+
+	return version
+}
+
+// WantsCmpctBlocks returns if the peer wants new blocks to be announced with
+// cmpctblock messages (high-bandwidth mode) instead of inventory vectors or
+// headers.
+//
+// This function is safe for concurrent access.
+func (p *Peer) WantsCmpctBlocks() bool {
+	p.flagsMtx.Lock()
+	sendCmpctPreferred := p.sendCmpctPreferred && p.cmpctBlockVersion != 0
+	p.flagsMtx.Unlock()
+
+	return sendCmpctPreferred
+}
+
+// localCmpctBlockVersion returns the compact block version to use with the
+// peer.  Version 2, which relies on witness transaction hashes, is only used
+// when both sides support segregated witness.
+func (p *Peer) localCmpctBlockVersion() uint64 {
+	if p.cfg.Services&wire.SFNodeWitness == wire.SFNodeWitness &&
+		p.IsWitnessEnabled() {
+
+		return wire.CmpctBlockVersionWTxID
+	}
+	return wire.CmpctBlockVersionTxID
+}
+
+// PushSendCmpctMsg sends a sendcmpct message to the connected peer which
+// requests that new blocks are announced with cmpctblock messages when
+// highBandwidth is set (high-bandwidth mode), or with inventory vectors or
+// headers otherwise (low-bandwidth mode).  No message is sent when the
+// negotiated protocol version does not support compact blocks.
+//
+// This function is safe for concurrent access.
+func (p *Peer) PushSendCmpctMsg(highBandwidth bool) {
+	if p.ProtocolVersion() < wire.CompactBlocksVersion {
+		return
+	}
+
+	msg := wire.NewMsgSendCmpct(highBandwidth, p.localCmpctBlockVersion())
+	p.QueueMessage(msg, nil)
+}
+
+// handleSendCmpctMsg is invoked when a peer receives a sendcmpct bitcoin
+// message.  Announcements for unsupported versions are ignored as required by
+// BIP0152.  Otherwise, the highest supported version announced so far is
+// recorded along with whether the peer requested high-bandwidth mode.
+func (p *Peer) handleSendCmpctMsg(msg *wire.MsgSendCmpct) {
+	localVersion := p.localCmpctBlockVersion()
+
+	p.flagsMtx.Lock()
+	defer p.flagsMtx.Unlock()
+
+	version := msg.CmpctBlockVersion
+	if version == 0 || version > localVersion ||
+		version < p.cmpctBlockVersion {
+
+		return
+	}
+	p.cmpctBlockVersion = version
+	p.sendCmpctPreferred = msg.AnnounceUsingCmpctBlock
+}
+
+// WTxIDRelay returns whether transactions are announced to and requested from
+// the peer by witness transaction hash using the MSG_WTX inventory type
+// (BIP0339).
+//
+// This function is safe for concurrent access.
+func (p *Peer) WTxIDRelay() bool {
+	p.flagsMtx.Lock()
+	wtxidRelay := p.wtxidRelay
+	p.flagsMtx.Unlock()
+
+	return wtxidRelay
+}
+
+// handleWTxIDRelayMsg is invoked when a peer receives a wtxidrelay bitcoin
+// message.  It returns an error when the message arrives after the verack
+// message since BIP0339 requires it to be sent during the handshake.
+func (p *Peer) handleWTxIDRelayMsg(msg *wire.MsgWTxIDRelay) error {
+	p.flagsMtx.Lock()
+	defer p.flagsMtx.Unlock()
+
+	if p.verAckReceived {
+		return errors.New("wtxidrelay message received after verack")
+	}
+	if p.protocolVersion >= wire.WTxIDRelayVersion {
+		p.wtxidRelay = true
+	}
+	return nil
+}
+
+// WantsAddrV2 returns whether addresses are relayed to the peer with addrv2
+// messages (BIP0155).
+//
+// This function is safe for concurrent access.
+func (p *Peer) WantsAddrV2() bool {
+	p.flagsMtx.Lock()
+	sendAddrV2 := p.sendAddrV2
+	p.flagsMtx.Unlock()
+
+	return sendAddrV2
+}
+
+// V2Transport returns whether the connection to the peer uses the encrypted
+// v2 transport (BIP0324).
+//
+// This function is safe for concurrent access.
+func (p *Peer) V2Transport() bool {
+	p.flagsMtx.Lock()
+	v2 := p.v2 != nil
+	p.flagsMtx.Unlock()
+
+	return v2
+}
+
+// handleSendAddrV2Msg is invoked when a peer receives a sendaddrv2 bitcoin
+// message.  It returns an error when the message arrives after the verack
+// message since BIP0155 requires it to be sent during the handshake.
+func (p *Peer) handleSendAddrV2Msg(msg *wire.MsgSendAddrV2) error {
+	p.flagsMtx.Lock()
+	defer p.flagsMtx.Unlock()
+
+	if p.verAckReceived {
+		return errors.New("sendaddrv2 message received after verack")
+	}
+	if p.protocolVersion >= wire.AddrV2Version {
+		p.sendAddrV2 = true
+	}
+	return nil
+}
+
 // IsWitnessEnabled returns true if the peer has signalled that it supports
 // segregated witness.
 //
@@ -844,6 +1055,40 @@ func (p *Peer) PushAddrMsg(addresses []*wire.NetAddress) ([]*wire.NetAddress, er
 	return msg.AddrList, nil
 }
 
+// PushAddrV2Msg sends an addrv2 message to the connected peer using the
+// provided addresses.  It behaves like PushAddrMsg, however the addresses may
+// belong to any network defined by BIP0155, so it must only be used with peers
+// for which WantsAddrV2 returns true.
+//
+// This function is safe for concurrent access.
+func (p *Peer) PushAddrV2Msg(addresses []*wire.NetAddressV2) ([]*wire.NetAddressV2, error) {
+	addressCount := len(addresses)
+
+	// Nothing to send.
+	if addressCount == 0 {
+		return nil, nil
+	}
+
+	msg := wire.NewMsgAddrV2()
+	msg.AddrList = make([]*wire.NetAddressV2, addressCount)
+	copy(msg.AddrList, addresses)
+
+	// Randomize the addresses sent if there are more than the maximum allowed.
+	if addressCount > wire.MaxAddrPerMsg {
+		// Shuffle the address list.
+		for i := 0; i < wire.MaxAddrPerMsg; i++ {
+			j := i + rand.Intn(addressCount-i)
+			msg.AddrList[i], msg.AddrList[j] = msg.AddrList[j], msg.AddrList[i]
+		}
+
+		// Truncate it to the maximum size.
+		msg.AddrList = msg.AddrList[:wire.MaxAddrPerMsg]
+	}
+
+	p.QueueMessage(msg, nil)
+	return msg.AddrList, nil
+}
+
 // PushGetBlocksMsg sends a getblocks message for the provided block locator
 // and stop hash.  It will ignore back-to-back duplicate requests.
 //
@@ -1006,8 +1251,16 @@ func (p *Peer) handlePongMsg(msg *wire.MsgPong) {
 
 // readMessage reads the next bitcoin message from the peer with logging.
 func (p *Peer) readMessage(encoding wire.MessageEncoding) (wire.Message, []byte, error) {
-	n, msg, buf, err := wire.ReadMessageWithEncodingN(p.conn,
-		p.ProtocolVersion(), p.cfg.ChainParams.Net, encoding)
+	var n int
+	var msg wire.Message
+	var buf []byte
+	var err error
+	if p.v2 != nil {
+		n, msg, buf, err = p.v2.ReadMessage(p.ProtocolVersion(), encoding)
+	} else {
+		n, msg, buf, err = wire.ReadMessageWithEncodingN(p.connReader,
+			p.ProtocolVersion(), p.cfg.ChainParams.Net, encoding)
+	}
 	atomic.AddUint64(&p.bytesReceived, uint64(n))
 	if p.cfg.Listeners.OnRead != nil {
 		p.cfg.Listeners.OnRead(p, n, msg, err)
@@ -1069,8 +1322,14 @@ func (p *Peer) writeMessage(msg wire.Message, enc wire.MessageEncoding) error {
 	}))
 
 	// Write the message to the peer.
-	n, err := wire.WriteMessageWithEncodingN(p.conn, msg,
-		p.ProtocolVersion(), p.cfg.ChainParams.Net, enc)
+	var n int
+	var err error
+	if p.v2 != nil {
+		n, err = p.v2.WriteMessage(msg, p.ProtocolVersion(), enc)
+	} else {
+		n, err = wire.WriteMessageWithEncodingN(p.conn, msg,
+			p.ProtocolVersion(), p.cfg.ChainParams.Net, enc)
+	}
 	atomic.AddUint64(&p.bytesSent, uint64(n))
 	if p.cfg.Listeners.OnWrite != nil {
 		p.cfg.Listeners.OnWrite(p, n, msg, err)
@@ -1153,12 +1412,18 @@ func (p *Peer) maybeAddDeadline(pendingResponses map[string]time.Time, msgCmd st
 		pendingResponses[wire.CmdInv] = deadline
 
 	case wire.CmdGetData:
-		// Expects a block, merkleblock, tx, or notfound message.
+		// Expects a block, cmpctblock, merkleblock, tx, or notfound
+		// message.
 		pendingResponses[wire.CmdBlock] = deadline
+		pendingResponses[wire.CmdCmpctBlock] = deadline
 		pendingResponses[wire.CmdMerkleBlock] = deadline
 		pendingResponses[wire.CmdTx] = deadline
 		pendingResponses[wire.CmdNotFound] = deadline
 
+	case wire.CmdGetBlockTxn:
+		// Expects a blocktxn message.
+		pendingResponses[wire.CmdBlockTxn] = deadline
+
 	case wire.CmdGetHeaders:
 		// Expects a headers message.  Use a longer deadline since it
 		// can take a while for the remote peer to load all of the
@@ -1212,12 +1477,15 @@ out:
 				switch msgCmd := msg.message.Command(); msgCmd {
 				case wire.CmdBlock:
 					fallthrough
+				case wire.CmdCmpctBlock:
+					fallthrough
 				case wire.CmdMerkleBlock:
 					fallthrough
 				case wire.CmdTx:
 					fallthrough
 				case wire.CmdNotFound:
 					delete(pendingResponses, wire.CmdBlock)
+					delete(pendingResponses, wire.CmdCmpctBlock)
 					delete(pendingResponses, wire.CmdMerkleBlock)
 					delete(pendingResponses, wire.CmdTx)
 					delete(pendingResponses, wire.CmdNotFound)
@@ -1528,6 +1796,52 @@ out:
 				p.cfg.Listeners.OnSendHeaders(p, msg)
 			}
 
+		case *wire.MsgSendCmpct:
+			p.handleSendCmpctMsg(msg)
+			if p.cfg.Listeners.OnSendCmpct != nil {
+				p.cfg.Listeners.OnSendCmpct(p, msg)
+			}
+
+		case *wire.MsgCmpctBlock:
+			if p.cfg.Listeners.OnCmpctBlock != nil {
+				p.cfg.Listeners.OnCmpctBlock(p, msg)
+			}
+
+		case *wire.MsgGetBlockTxn:
+			if p.cfg.Listeners.OnGetBlockTxn != nil {
+				p.cfg.Listeners.OnGetBlockTxn(p, msg)
+			}
+
+		case *wire.MsgBlockTxn:
+			if p.cfg.Listeners.OnBlockTxn != nil {
+				p.cfg.Listeners.OnBlockTxn(p, msg)
+			}
+
+		case *wire.MsgWTxIDRelay:
+			if err := p.handleWTxIDRelayMsg(msg); err != nil {
+				log.Infof("Peer %v sent an invalid wtxidrelay "+
+					"message: %v -- disconnecting", p, err)
+				break out
+			}
+			if p.cfg.Listeners.OnWTxIDRelay != nil {
+				p.cfg.Listeners.OnWTxIDRelay(p, msg)
+			}
+
+		case *wire.MsgAddrV2:
+			if p.cfg.Listeners.OnAddrV2 != nil {
+				p.cfg.Listeners.OnAddrV2(p, msg)
+			}
+
+		case *wire.MsgSendAddrV2:
+			if err := p.handleSendAddrV2Msg(msg); err != nil {
+				log.Infof("Peer %v sent an invalid sendaddrv2 "+
+					"message: %v -- disconnecting", p, err)
+				break out
+			}
+			if p.cfg.Listeners.OnSendAddrV2 != nil {
+				p.cfg.Listeners.OnSendAddrV2(p, msg)
+			}
+
 		default:
 			log.Debugf("Received unhandled message of type %v "+
 				"from %v", rmsg.Command(), p)
@@ -1986,7 +2300,13 @@ func (p *Peer) localVersionMsg() (*wire.MsgVersion, error) {
 		}
 	}
 
-	theirNA := p.na
+	// Addresses which don't fit into the version message, such as Tor v3
+	// and I2P addresses, are sent as an unroutable address.
+	theirNA := p.na.ToLegacy()
+	if theirNA == nil {
+		theirNA = wire.NewNetAddressIPPort(net.IP([]byte{0, 0, 0, 0}), 0,
+			p.na.Services)
+	}
 
 	// If we are behind a proxy and the connection comes from the proxy then
 	// we return an unroutable address as their address. This is to prevent
@@ -1994,7 +2314,7 @@ func (p *Peer) localVersionMsg() (*wire.MsgVersion, error) {
 	if p.cfg.Proxy != "" {
 		proxyaddress, _, err := net.SplitHostPort(p.cfg.Proxy)
 		// invalid proxy means poorly configured, be on the safe side.
-		if err != nil || p.na.IP.String() == proxyaddress {
+		if err != nil || p.na.Host() == proxyaddress {
 			theirNA = wire.NewNetAddressIPPort(net.IP([]byte{0, 0, 0, 0}), 0,
 				theirNA.Services)
 		}
@@ -2068,12 +2388,55 @@ func (p *Peer) negotiateOutboundProtocol() error {
 	return p.readRemoteVersionMsg()
 }
 
+// negotiateTransport establishes the encrypted v2 transport when it is
+// enabled.  Outbound peers initiate the v2 handshake.  Inbound peers inspect
+// the first bytes sent by the remote peer and fall back to the plaintext v1
+// transport when they are the start of a v1 version message.
+func (p *Peer) negotiateTransport() error {
+	if !p.cfg.V2Transport {
+		return nil
+	}
+
+	var rw io.ReadWriter = p.conn
+	if p.inbound {
+		prefix := make([]byte, v2transport.V1PrefixSize)
+		if _, err := io.ReadFull(p.conn, prefix); err != nil {
+			return err
+		}
+		p.connReader = io.MultiReader(bytes.NewReader(prefix), p.conn)
+		if v2transport.IsV1Prefix(prefix, p.cfg.ChainParams.Net) {
+			log.Debugf("Peer %s does not use the v2 transport", p)
+			return nil
+		}
+		rw = struct {
+			io.Reader
+			io.Writer
+		}{p.connReader, p.conn}
+	}
+
+	t, err := v2transport.Handshake(rw, p.cfg.ChainParams.Net, !p.inbound)
+	if err != nil {
+		return fmt.Errorf("v2 transport handshake failed: %v", err)
+	}
+	p.flagsMtx.Lock()
+	p.v2 = t
+	p.flagsMtx.Unlock()
+
+	log.Debugf("Established v2 transport with peer %s (session id %x)", p,
+		t.SessionID())
+	return nil
+}
+
 // start begins processing input and output messages.
 func (p *Peer) start() error {
 	log.Tracef("Starting peer %s", p)
 
 	negotiateErr := make(chan error, 1)
 	go func() {
+		if err := p.negotiateTransport(); err != nil {
+			negotiateErr <- err
+			return
+		}
 		if p.inbound {
 			negotiateErr <- p.negotiateInboundProtocol()
 		} else {
@@ -2102,8 +2465,24 @@ func (p *Peer) start() error {
 	go p.outHandler()
 	go p.pingHandler()
 
+	// Signal support for relaying transactions by witness transaction hash.
+	// This must happen before the verack message is sent.
+	if p.ProtocolVersion() >= wire.WTxIDRelayVersion {
+		p.QueueMessage(wire.NewMsgWTxIDRelay(), nil)
+	}
+
+	// Signal support for relaying addresses with addrv2 messages.  This must
+	// also happen before the verack message is sent.
+	if p.ProtocolVersion() >= wire.AddrV2Version {
+		p.QueueMessage(wire.NewMsgSendAddrV2(), nil)
+	}
+
 	// Send our verack message now that the IO processing machinery has started.
 	p.QueueMessage(wire.NewMsgVerAck(), nil)
+
+	// Signal support for compact block relay in low-bandwidth mode.  The
+	// sync manager may later switch the peer to high-bandwidth mode.
+	p.PushSendCmpctMsg(false)
 	return nil
 }
 
@@ -2116,6 +2495,7 @@ func (p *Peer) AssociateConnection(conn net.Conn) {
 	}
 
 	p.conn = conn
+	p.connReader = conn
 	p.timeConnected = time.Now()
 
 	if p.inbound {
@@ -2218,7 +2598,7 @@ func NewOutboundPeer(cfg *Config, addr string) (*Peer, error) {
 		}
 		p.na = na
 	} else {
-		p.na = wire.NewNetAddressIPPort(net.ParseIP(host), uint16(port), 0)
+		p.na = wire.NewNetAddressV2IPPort(net.ParseIP(host), uint16(port), 0)
 	}
 
 	return p, nil
@@ -445,6 +445,18 @@ func TestPeerListeners(t *testing.T) {
 			OnSendHeaders: func(p *peer.Peer, msg *wire.MsgSendHeaders) {
 				ok <- msg
 			},
+			OnCmpctBlock: func(p *peer.Peer, msg *wire.MsgCmpctBlock) {
+				ok <- msg
+			},
+			OnGetBlockTxn: func(p *peer.Peer, msg *wire.MsgGetBlockTxn) {
+				ok <- msg
+			},
+			OnBlockTxn: func(p *peer.Peer, msg *wire.MsgBlockTxn) {
+				ok <- msg
+			},
+			OnAddrV2: func(p *peer.Peer, msg *wire.MsgAddrV2) {
+				ok <- msg
+			},
 		},
 		UserAgentName:     "peer",
 		UserAgentVersion:  "1.0",
@@ -594,6 +606,23 @@ func TestPeerListeners(t *testing.T) {
 			"OnSendHeaders",
 			wire.NewMsgSendHeaders(),
 		},
+		{
+			"OnCmpctBlock",
+			&wire.MsgCmpctBlock{Header: *wire.NewBlockHeader(1,
+				&chainhash.Hash{}, &chainhash.Hash{}, 1, 1)},
+		},
+		{
+			"OnGetBlockTxn",
+			wire.NewMsgGetBlockTxn(&chainhash.Hash{}, []uint32{1}),
+		},
+		{
+			"OnBlockTxn",
+			wire.NewMsgBlockTxn(&chainhash.Hash{}, nil),
+		},
+		{
+			"OnAddrV2",
+			wire.NewMsgAddrV2(),
+		},
 	}
 	t.Logf("Running %d tests", len(tests))
 	for _, test := range tests {
@@ -610,6 +639,349 @@ func TestPeerListeners(t *testing.T) {
 	outPeer.Disconnect()
 }
 
+// TestCmpctBlockNegotiation tests that compact block relay is negotiated when
+// peers connect and that high-bandwidth mode can be requested.
+func TestCmpctBlockNegotiation(t *testing.T) {
+	sendCmpct := make(chan *wire.MsgSendCmpct, 2)
+	verack := make(chan struct{}, 2)
+	peerCfg := &peer.Config{
+		Listeners: peer.MessageListeners{
+			OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+				verack <- struct{}{}
+			},
+			OnSendCmpct: func(p *peer.Peer, msg *wire.MsgSendCmpct) {
+				sendCmpct <- msg
+			},
+		},
+		UserAgentName:    "peer",
+		UserAgentVersion: "1.0",
+		ChainParams:      &chaincfg.MainNetParams,
+		Services:         wire.SFNodeNetwork | wire.SFNodeWitness,
+		TrickleInterval:  time.Second * 10,
+	}
+
+	inConn, outConn := pipe(
+		&conn{raddr: "10.0.0.1:8333"},
+		&conn{raddr: "10.0.0.2:8333"},
+	)
+	inPeer := peer.NewInboundPeer(peerCfg)
+	inPeer.AssociateConnection(inConn)
+	outPeer, err := peer.NewOutboundPeer(peerCfg, "10.0.0.2:8333")
+	if err != nil {
+		t.Fatalf("NewOutboundPeer: unexpected err %v", err)
+	}
+	outPeer.AssociateConnection(outConn)
+	defer inPeer.Disconnect()
+	defer outPeer.Disconnect()
+
+	// Both peers announce support for version 2 in low-bandwidth mode
+	// once the handshake is complete.
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-sendCmpct:
+			if msg.AnnounceUsingCmpctBlock ||
+				msg.CmpctBlockVersion != wire.CmpctBlockVersionWTxID {
+
+				t.Fatalf("unexpected sendcmpct %v", msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("sendcmpct timeout")
+		}
+	}
+	for _, p := range []*peer.Peer{inPeer, outPeer} {
+		if p.CmpctBlockVersion() != wire.CmpctBlockVersionWTxID {
+			t.Fatalf("CmpctBlockVersion: got %d, want %d",
+				p.CmpctBlockVersion(),
+				wire.CmpctBlockVersionWTxID)
+		}
+		if p.WantsCmpctBlocks() {
+			t.Fatal("WantsCmpctBlocks: unexpected high-bandwidth mode")
+		}
+	}
+
+	// Request high-bandwidth mode from the inbound side.
+	outPeer.PushSendCmpctMsg(true)
+	select {
+	case <-sendCmpct:
+	case <-time.After(time.Second):
+		t.Fatal("sendcmpct timeout")
+	}
+	if !inPeer.WantsCmpctBlocks() {
+		t.Fatal("WantsCmpctBlocks: high-bandwidth mode not recorded")
+	}
+}
+
+// TestWTxIDRelayNegotiation tests that relaying transactions by witness
+// transaction hash is only enabled when both peers support it.
+func TestWTxIDRelayNegotiation(t *testing.T) {
+	tests := []struct {
+		name    string
+		pver    uint32
+		enabled bool
+	}{
+		{"latest protocol version", 0, true},
+		{"before wtxidrelay", wire.CompactBlocksVersion, false},
+	}
+
+	for _, test := range tests {
+		verack := make(chan struct{}, 2)
+		wtxidRelay := make(chan struct{}, 2)
+		inCfg := &peer.Config{
+			Listeners: peer.MessageListeners{
+				OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+					verack <- struct{}{}
+				},
+				OnWTxIDRelay: func(p *peer.Peer, msg *wire.MsgWTxIDRelay) {
+					wtxidRelay <- struct{}{}
+				},
+			},
+			UserAgentName:    "peer",
+			UserAgentVersion: "1.0",
+			ChainParams:      &chaincfg.MainNetParams,
+			Services:         wire.SFNodeNetwork | wire.SFNodeWitness,
+			TrickleInterval:  time.Second * 10,
+		}
+		outCfg := *inCfg
+		outCfg.ProtocolVersion = test.pver
+
+		inConn, outConn := pipe(
+			&conn{raddr: "10.0.0.1:8333"},
+			&conn{raddr: "10.0.0.2:8333"},
+		)
+		inPeer := peer.NewInboundPeer(inCfg)
+		inPeer.AssociateConnection(inConn)
+		outPeer, err := peer.NewOutboundPeer(&outCfg, "10.0.0.2:8333")
+		if err != nil {
+			t.Fatalf("%s: NewOutboundPeer: unexpected err %v",
+				test.name, err)
+		}
+		outPeer.AssociateConnection(outConn)
+
+		for i := 0; i < 2; i++ {
+			select {
+			case <-verack:
+			case <-time.After(time.Second):
+				t.Fatalf("%s: verack timeout", test.name)
+			}
+		}
+		if got := len(wtxidRelay); (got == 2) != test.enabled {
+			t.Fatalf("%s: got %d wtxidrelay messages", test.name, got)
+		}
+		for _, p := range []*peer.Peer{inPeer, outPeer} {
+			if p.WTxIDRelay() != test.enabled {
+				t.Fatalf("%s: WTxIDRelay: got %v, want %v",
+					test.name, p.WTxIDRelay(), test.enabled)
+			}
+		}
+
+		inPeer.Disconnect()
+		outPeer.Disconnect()
+	}
+}
+
+// TestAddrV2Negotiation tests that relaying addresses with addrv2 messages is
+// only enabled when both peers support it and that addresses of networks
+// which don't fit into legacy addresses are exchanged.
+func TestAddrV2Negotiation(t *testing.T) {
+	tests := []struct {
+		name    string
+		pver    uint32
+		enabled bool
+	}{
+		{"latest protocol version", 0, true},
+		{"before addrv2", wire.CompactBlocksVersion, false},
+	}
+
+	torV3 := wire.NewNetAddressV2(time.Now(), wire.SFNodeNetwork,
+		wire.NetIDTorV3, make([]byte, 32), 8333)
+	for _, test := range tests {
+		verack := make(chan struct{}, 2)
+		addrV2 := make(chan *wire.MsgAddrV2, 1)
+		inCfg := &peer.Config{
+			Listeners: peer.MessageListeners{
+				OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+					verack <- struct{}{}
+				},
+				OnAddrV2: func(p *peer.Peer, msg *wire.MsgAddrV2) {
+					addrV2 <- msg
+				},
+			},
+			UserAgentName:    "peer",
+			UserAgentVersion: "1.0",
+			ChainParams:      &chaincfg.MainNetParams,
+			Services:         wire.SFNodeNetwork | wire.SFNodeWitness,
+			TrickleInterval:  time.Second * 10,
+		}
+		outCfg := *inCfg
+		outCfg.ProtocolVersion = test.pver
+
+		inConn, outConn := pipe(
+			&conn{raddr: "10.0.0.1:8333"},
+			&conn{raddr: "10.0.0.2:8333"},
+		)
+		inPeer := peer.NewInboundPeer(inCfg)
+		inPeer.AssociateConnection(inConn)
+		outPeer, err := peer.NewOutboundPeer(&outCfg, "10.0.0.2:8333")
+		if err != nil {
+			t.Fatalf("%s: NewOutboundPeer: unexpected err %v",
+				test.name, err)
+		}
+		outPeer.AssociateConnection(outConn)
+
+		for i := 0; i < 2; i++ {
+			select {
+			case <-verack:
+			case <-time.After(time.Second):
+				t.Fatalf("%s: verack timeout", test.name)
+			}
+		}
+		for _, p := range []*peer.Peer{inPeer, outPeer} {
+			if p.WantsAddrV2() != test.enabled {
+				t.Fatalf("%s: WantsAddrV2: got %v, want %v",
+					test.name, p.WantsAddrV2(), test.enabled)
+			}
+		}
+
+		if test.enabled {
+			_, err := outPeer.PushAddrV2Msg([]*wire.NetAddressV2{torV3})
+			if err != nil {
+				t.Fatalf("%s: PushAddrV2Msg: unexpected err %v",
+					test.name, err)
+			}
+			select {
+			case msg := <-addrV2:
+				if len(msg.AddrList) != 1 ||
+					msg.AddrList[0].Host() != torV3.Host() {
+
+					t.Fatalf("%s: got addresses %v, want %v",
+						test.name, msg.AddrList, torV3)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%s: addrv2 timeout", test.name)
+			}
+		}
+
+		inPeer.Disconnect()
+		outPeer.Disconnect()
+	}
+}
+
+// pipeConn wraps one end of an in-memory net.Pipe connection to report a
+// routable remote address, which inbound peers require.
+type pipeConn struct {
+	net.Conn
+	raddr string
+}
+
+// RemoteAddr returns the remote address for the connection.
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return &addr{"tcp", c.raddr}
+}
+
+// TestV2Transport tests negotiation of the encrypted v2 transport, including
+// the fallback of inbound peers to the v1 transport.
+func TestV2Transport(t *testing.T) {
+	tests := []struct {
+		name       string
+		inboundV2  bool
+		outboundV2 bool
+		connected  bool
+		wantV2     bool
+	}{
+		{"both v2", true, true, true, true},
+		{"v1 initiator", true, false, true, false},
+		{"both v1", false, false, true, false},
+		{"v1 responder", false, true, false, false},
+	}
+
+	for _, test := range tests {
+		verack := make(chan struct{}, 2)
+		pong := make(chan *wire.MsgPong, 1)
+		inCfg := &peer.Config{
+			Listeners: peer.MessageListeners{
+				OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+					verack <- struct{}{}
+				},
+				OnPong: func(p *peer.Peer, msg *wire.MsgPong) {
+					pong <- msg
+				},
+			},
+			UserAgentName:    "peer",
+			UserAgentVersion: "1.0",
+			ChainParams:      &chaincfg.MainNetParams,
+			Services:         wire.SFNodeNetwork,
+			TrickleInterval:  time.Second * 10,
+			V2Transport:      test.inboundV2,
+		}
+		outCfg := *inCfg
+		outCfg.V2Transport = test.outboundV2
+
+		c1, c2 := net.Pipe()
+		inPeer := peer.NewInboundPeer(inCfg)
+		inPeer.AssociateConnection(&pipeConn{c1, "10.0.0.1:8333"})
+		outPeer, err := peer.NewOutboundPeer(&outCfg, "10.0.0.2:8333")
+		if err != nil {
+			t.Fatalf("%s: NewOutboundPeer: unexpected err %v",
+				test.name, err)
+		}
+		outPeer.AssociateConnection(&pipeConn{c2, "10.0.0.2:8333"})
+
+		if !test.connected {
+			// The v1 responder disconnects when it fails to parse
+			// the public key of the initiator as a message header.
+			select {
+			case <-verack:
+				t.Fatalf("%s: unexpected verack", test.name)
+			case <-waitForDisconnect(outPeer):
+			case <-time.After(time.Second * 5):
+				t.Fatalf("%s: disconnect timeout", test.name)
+			}
+			inPeer.Disconnect()
+			continue
+		}
+
+		for i := 0; i < 2; i++ {
+			select {
+			case <-verack:
+			case <-time.After(time.Second * 5):
+				t.Fatalf("%s: verack timeout", test.name)
+			}
+		}
+		for _, p := range []*peer.Peer{inPeer, outPeer} {
+			if p.V2Transport() != test.wantV2 {
+				t.Fatalf("%s: V2Transport: got %v, want %v",
+					test.name, p.V2Transport(), test.wantV2)
+			}
+		}
+
+		// Ensure messages flow in both directions after the handshake.
+		outPeer.QueueMessage(wire.NewMsgPing(42), nil)
+		select {
+		case msg := <-pong:
+			if msg.Nonce != 42 {
+				t.Fatalf("%s: got pong nonce %d, want 42", test.name,
+					msg.Nonce)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("%s: pong timeout", test.name)
+		}
+
+		inPeer.Disconnect()
+		outPeer.Disconnect()
+	}
+}
+
+// waitForDisconnect returns a channel which is closed once the passed peer
+// has disconnected.
+func waitForDisconnect(p *peer.Peer) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		p.WaitForDisconnect()
+		close(done)
+	}()
+	return done
+}
+
 // TestOutboundPeer tests that the outbound peer works as expected.
 func TestOutboundPeer(t *testing.T) {
 
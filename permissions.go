@@ -0,0 +1,154 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// peerPermissions houses the permission flags granted to peers which connect
+// from a whitelisted IP address or network.
+type peerPermissions uint8
+
+const (
+	// permNoBan prevents the peer from being banned, disconnected or
+	// evicted for misbehavior.
+	permNoBan peerPermissions = 1 << iota
+
+	// permRelay accepts transactions from the peer even when running in
+	// blocks only mode and relays transactions it sends which are rejected
+	// by the local relay policy to the other peers anyway.  This allows the
+	// server to act as a gateway for nodes behind it.
+	permRelay
+
+	// permMempool allows the peer to request the contents of the mempool
+	// with the mempool message even when bloom filtering is disabled.
+	permMempool
+
+	// permDownload exempts the peer from being disconnected once the
+	// upload target is reached.
+	permDownload
+
+	// permAddr allows the peer to request addresses more than once per
+	// connection.
+	permAddr
+
+	// permAll is all of the permissions.
+	permAll = permNoBan | permRelay | permMempool | permDownload | permAddr
+)
+
+// defaultWhitelistPermissions are the permissions granted by whitelist entries
+// which don't specify any.  It matches the behavior of whitelists before
+// permission flags were supported.
+const defaultWhitelistPermissions = permNoBan
+
+// permissionNames maps the permission flags to the names used to configure
+// them and to report them in the getpeerinfo RPC.  The order is the order
+// they are reported in.
+var permissionNames = []struct {
+	flag peerPermissions
+	name string
+}{
+	{permNoBan, "noban"},
+	{permRelay, "relay"},
+	{permMempool, "mempool"},
+	{permDownload, "download"},
+	{permAddr, "addr"},
+}
+
+// has returns whether all of the passed permission flags are set.
+func (p peerPermissions) has(flags peerPermissions) bool {
+	return p&flags == flags
+}
+
+// names returns the names of the set permission flags.
+func (p peerPermissions) names() []string {
+	names := make([]string, 0, len(permissionNames))
+	for _, perm := range permissionNames {
+		if p.has(perm.flag) {
+			names = append(names, perm.name)
+		}
+	}
+	return names
+}
+
+// String returns the permission flags in the comma separated form they are
+// configured with.
+func (p peerPermissions) String() string {
+	return strings.Join(p.names(), ",")
+}
+
+// parsePermissions parses a comma separated list of permission names.  The
+// name all grants all of the permissions.
+func parsePermissions(s string) (peerPermissions, error) {
+	var perms peerPermissions
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "all" {
+			perms |= permAll
+			continue
+		}
+
+		found := false
+		for _, perm := range permissionNames {
+			if perm.name == name {
+				perms |= perm.flag
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown permission %q", name)
+		}
+	}
+	return perms, nil
+}
+
+// whitelist is an IP network and the permissions granted to peers which
+// connect from it.
+type whitelist struct {
+	ipnet *net.IPNet
+	perms peerPermissions
+}
+
+// parseWhitelist parses a whitelist entry of the form [flags@]network where
+// flags is a comma separated list of permission names and network is either
+// an IP address or a network in CIDR notation.  Entries without flags grant
+// the default whitelist permissions.
+func parseWhitelist(s string) (*whitelist, error) {
+	perms := defaultWhitelistPermissions
+	addr := s
+	if i := strings.LastIndex(s, "@"); i != -1 {
+		var err error
+		perms, err = parsePermissions(s[:i])
+		if err != nil {
+			return nil, err
+		}
+		addr = s[i+1:]
+	}
+
+	_, ipnet, err := net.ParseCIDR(addr)
+	if err != nil {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address or network "+
+				"%q", addr)
+		}
+		var bits int
+		if ip.To4() == nil {
+			// IPv6
+			bits = 128
+		} else {
+			bits = 32
+		}
+		ipnet = &net.IPNet{
+			IP:   ip,
+			Mask: net.CIDRMask(bits, bits),
+		}
+	}
+	return &whitelist{ipnet: ipnet, perms: perms}, nil
+}
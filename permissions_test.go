@@ -0,0 +1,100 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+// TestParseWhitelist ensures whitelist entries with and without permission
+// flags are parsed as expected.
+func TestParseWhitelist(t *testing.T) {
+	tests := []struct {
+		entry   string
+		network string
+		perms   peerPermissions
+	}{
+		{"192.168.1.0/24", "192.168.1.0/24", permNoBan},
+		{"::1", "::1/128", permNoBan},
+		{"10.0.0.1", "10.0.0.1/32", permNoBan},
+		{"relay@10.0.0.0/8", "10.0.0.0/8", permRelay},
+		{"noban,mempool@::1", "::1/128", permNoBan | permMempool},
+		{"download, addr@10.0.0.1", "10.0.0.1/32",
+			permDownload | permAddr},
+		{"all@fd00::/16", "fd00::/16", permAll},
+	}
+	for _, test := range tests {
+		wl, err := parseWhitelist(test.entry)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.entry, err)
+			continue
+		}
+		if wl.ipnet.String() != test.network {
+			t.Errorf("%s: unexpected network - got %v, want %v",
+				test.entry, wl.ipnet, test.network)
+		}
+		if wl.perms != test.perms {
+			t.Errorf("%s: unexpected permissions - got %v, want %v",
+				test.entry, wl.perms, test.perms)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"10.0.0",
+		"bogus@10.0.0.1",
+		"@10.0.0.1",
+		"noban@",
+	}
+	for _, entry := range invalid {
+		if _, err := parseWhitelist(entry); err == nil {
+			t.Errorf("%q: parsed invalid whitelist entry", entry)
+		}
+	}
+}
+
+// TestWhitelistPermissions ensures peers are granted the permissions of all
+// of the whitelists which include their address.
+func TestWhitelistPermissions(t *testing.T) {
+	if cfg == nil {
+		cfg = &config{}
+		defer func() { cfg = nil }()
+	}
+	defer func(whitelists []*whitelist) {
+		cfg.whitelists = whitelists
+	}(cfg.whitelists)
+
+	cfg.whitelists = nil
+	for _, entry := range []string{"10.0.0.0/8", "relay@10.1.0.0/16"} {
+		wl, err := parseWhitelist(entry)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", entry, err)
+		}
+		cfg.whitelists = append(cfg.whitelists, wl)
+	}
+
+	tests := []struct {
+		addr  string
+		perms peerPermissions
+	}{
+		{"10.2.0.1:8333", permNoBan},
+		{"10.1.0.1:8333", permNoBan | permRelay},
+		{"192.168.0.1:8333", 0},
+	}
+	for _, test := range tests {
+		addr, err := net.ResolveTCPAddr("tcp", test.addr)
+		if err != nil {
+			t.Fatalf("%s: %v", test.addr, err)
+		}
+		if got := whitelistPermissions(addr); got != test.perms {
+			t.Errorf("%s: unexpected permissions - got %v, want %v",
+				test.addr, got, test.perms)
+		}
+	}
+	if got := (permNoBan | permAddr).String(); got != "noban,addr" {
+		t.Errorf("unexpected permission string %q", got)
+	}
+}
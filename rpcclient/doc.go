@@ -40,6 +40,12 @@ can be invoked without having to go through a connect/disconnect cycle for every
 call.  In addition, the websocket interface provides other nice features such as
 the ability to register for asynchronous notifications of various events.
 
+When HTTP POST is required, the overhead of a connection per call can be
+amortized by creating the client with NewBatch.  The Async methods of a batch
+client queue their commands instead of issuing them, and Send issues all of the
+queued commands to the server as a single JSON-RPC batch request.  The returned
+futures are resolved once the batch reply arrives.
+
 Synchronous vs Asynchronous API
 
 The client provides both a synchronous (blocking) and asynchronous API.
@@ -69,6 +69,21 @@ var (
 	// client having already connected to the RPC server.
 	ErrClientAlreadyConnected = errors.New("websocket client has already " +
 		"connected")
+
+	// ErrNotBatchClient is an error to describe the condition of calling
+	// Send on a client that was not created in batch mode with NewBatch.
+	ErrNotBatchClient = errors.New("client is not configured for batch " +
+		"requests")
+
+	// ErrBatchRequiresHTTPPostMode is an error to describe the condition
+	// where a batch client is created without HTTP POST mode since batches
+	// are only supported over HTTP POST.
+	ErrBatchRequiresHTTPPostMode = errors.New("batch mode requires HTTP " +
+		"POST mode")
+
+	// ErrMissingBatchResponse is an error to describe the condition where
+	// the server did not include a response for a queued batch request.
+	ErrMissingBatchResponse = errors.New("no response for batch request")
 )
 
 const (
@@ -144,6 +159,13 @@ type Client struct {
 	requestMap  map[uint64]*list.Element
 	requestList *list.List
 
+	// batch indicates whether or not the client queues requests until
+	// Send is called instead of issuing them immediately.  The queued
+	// requests are tracked by batchList which is protected by batchLock.
+	batch     bool
+	batchLock sync.Mutex
+	batchList []*jsonRequest
+
 	// Notifications.
 	ntfnHandlers  *NotificationHandlers
 	ntfnStateLock sync.Mutex
@@ -285,6 +307,14 @@ type (
 		Result json.RawMessage   `json:"result"`
 		Error  *btcjson.RPCError `json:"error"`
 	}
+
+	// batchResponse is a partially-unmarshaled JSON-RPC response which is
+	// part of the reply to a batch request.  The ID is used to associate
+	// it with the queued request it is a reply to.
+	batchResponse struct {
+		ID *float64 `json:"id"`
+		rawResponse
+	}
 )
 
 // response is the raw bytes of a JSON-RPC result, or the error if the response
@@ -826,6 +856,129 @@ func (c *Client) sendPost(jReq *jsonRequest) {
 	c.sendPostRequest(httpReq, jReq)
 }
 
+// Send issues all requests that have been queued on a client created with
+// NewBatch to the server as a single JSON-RPC batch in one HTTP POST request.
+// The futures returned for the queued requests are resolved once the reply
+// arrives.  A non-nil error is returned when the batch itself could not be
+// sent or its reply could not be interpreted, in which case the same error is
+// also delivered to every queued future.
+func (c *Client) Send() error {
+	if !c.batch {
+		return ErrNotBatchClient
+	}
+
+	c.batchLock.Lock()
+	jReqs := c.batchList
+	c.batchList = nil
+	c.batchLock.Unlock()
+	if len(jReqs) == 0 {
+		return nil
+	}
+
+	// failAll delivers the passed error to all of the queued requests.
+	failAll := func(err error) error {
+		for _, jReq := range jReqs {
+			jReq.responseChan <- &response{err: err}
+		}
+		return err
+	}
+
+	// Don't send the batch if shutting down.
+	select {
+	case <-c.shutdown:
+		return failAll(ErrClientShutdown)
+	default:
+	}
+
+	// Combine the individually marshalled requests into a JSON array.
+	var body bytes.Buffer
+	body.WriteByte('[')
+	for i, jReq := range jReqs {
+		if i > 0 {
+			body.WriteByte(',')
+		}
+		body.Write(jReq.marshalledJSON)
+	}
+	body.WriteByte(']')
+
+	// Generate a request to the configured RPC server.
+	protocol := "http"
+	if !c.config.DisableTLS {
+		protocol = "https"
+	}
+	url := protocol + "://" + c.config.Host
+	httpReq, err := http.NewRequest("POST", url, &body)
+	if err != nil {
+		return failAll(err)
+	}
+	httpReq.Close = true
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	// Configure basic access authorization.
+	httpReq.SetBasicAuth(c.config.User, c.config.Pass)
+
+	log.Tracef("Sending batch of %d commands", len(jReqs))
+	httpResponse, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return failAll(err)
+	}
+
+	// Read the raw bytes and close the response.
+	respBytes, err := ioutil.ReadAll(httpResponse.Body)
+	httpResponse.Body.Close()
+	if err != nil {
+		return failAll(fmt.Errorf("error reading json reply: %v", err))
+	}
+
+	// Try to unmarshal the response as an array of JSON-RPC responses.
+	// A server that rejects the batch as a whole replies with a single
+	// response object instead, so deliver its error to every request.
+	var resps []batchResponse
+	if err := json.Unmarshal(respBytes, &resps); err != nil {
+		var resp rawResponse
+		if json.Unmarshal(respBytes, &resp) == nil && resp.Error != nil {
+			return failAll(resp.Error)
+		}
+		return failAll(fmt.Errorf("status code: %d, response: %q",
+			httpResponse.StatusCode, string(respBytes)))
+	}
+
+	// Deliver each response to the request with the matching id.
+	pending := make(map[uint64]*jsonRequest, len(jReqs))
+	for _, jReq := range jReqs {
+		pending[jReq.id] = jReq
+	}
+	for i := range resps {
+		resp := &resps[i]
+		if resp.ID == nil || *resp.ID < 0 ||
+			*resp.ID != math.Trunc(*resp.ID) {
+
+			log.Warn("Malformed batch response: invalid identifier")
+			continue
+		}
+		id := uint64(*resp.ID)
+		jReq, ok := pending[id]
+		if !ok {
+			log.Warnf("Received unexpected batch reply (id %d)", id)
+			continue
+		}
+		delete(pending, id)
+
+		res, err := resp.result()
+		jReq.responseChan <- &response{result: res, err: err}
+	}
+
+	// Any requests the server did not reply to will never be resolved, so
+	// fail them.
+	for _, jReq := range jReqs {
+		if _, ok := pending[jReq.id]; ok {
+			jReq.responseChan <- &response{err: ErrMissingBatchResponse}
+		}
+	}
+
+	return nil
+}
+
 // sendRequest sends the passed json request to the associated server using the
 // provided response channel for the reply.  It handles both websocket and HTTP
 // POST mode depending on the configuration of the client.
@@ -834,6 +987,12 @@ func (c *Client) sendRequest(jReq *jsonRequest) {
 	// the client running in HTTP POST mode or not.  When running in HTTP
 	// POST mode, the command is issued via an HTTP client.  Otherwise,
 	// the command is issued via the asynchronous websocket channels.
+	if c.batch {
+		c.batchLock.Lock()
+		c.batchList = append(c.batchList, jReq)
+		c.batchLock.Unlock()
+		return
+	}
 	if c.config.HTTPPostMode {
 		c.sendPost(jReq)
 		return
@@ -1276,6 +1435,24 @@ func New(config *ConnConfig, ntfnHandlers *NotificationHandlers) (*Client, error
 	return client, nil
 }
 
+// NewBatch creates a new RPC client in batch mode based on the provided
+// connection configuration details.  Batch mode requires HTTP POST mode.
+// Instead of issuing each command immediately, the Async methods of a batch
+// client queue the commands and return futures which are only resolved after
+// Send issues all queued commands to the server in a single HTTP POST request.
+func NewBatch(config *ConnConfig) (*Client, error) {
+	if !config.HTTPPostMode {
+		return nil, ErrBatchRequiresHTTPPostMode
+	}
+
+	client, err := New(config, nil)
+	if err != nil {
+		return nil, err
+	}
+	client.batch = true
+	return client, nil
+}
+
 // Connect establishes the initial websocket connection.  This is necessary when
 // a client was created after setting the DisableConnectOnNew field of the
 // Config struct.
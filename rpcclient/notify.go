@@ -681,7 +681,9 @@ func parseChainTxNtfnParams(params []json.RawMessage) (*btcutil.Tx,
 // parseRescanProgressParams parses out the height of the last rescanned block
 // from the parameters of rescanfinished and rescanprogress notifications.
 func parseRescanProgressParams(params []json.RawMessage) (*chainhash.Hash, int32, time.Time, error) {
-	if len(params) != 3 {
+	// The filter hits and blocks fetched are optionally appended by servers
+	// that use committed filters for the rescan and are ignored here.
+	if len(params) != 3 && len(params) != 5 {
 		return nil, 0, time.Time{}, wrongNumParams(len(params))
 	}
 
@@ -6,6 +6,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/sha256"
 	"crypto/subtle"
@@ -16,11 +17,14 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"math/big"
 	"math/rand"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -41,6 +45,7 @@ import (
 	"github.com/organicbitcoin/obtcd/mining/cpuminer"
 	"github.com/organicbitcoin/obtcd/peer"
 	"github.com/organicbitcoin/obtcd/txscript"
+	"github.com/organicbitcoin/obtcd/utxo"
 	"github.com/organicbitcoin/obtcd/wire"
 )
 
@@ -132,6 +137,7 @@ var rpcHandlersBeforeInit = map[string]commandHandler{
 	"debuglevel":            handleDebugLevel,
 	"decoderawtransaction":  handleDecodeRawTransaction,
 	"decodescript":          handleDecodeScript,
+	"dumptxoutset":          handleDumpTxOutSet,
 	"estimatefee":           handleEstimateFee,
 	"generate":              handleGenerate,
 	"getaddednodeinfo":      handleGetAddedNodeInfo,
@@ -142,6 +148,7 @@ var rpcHandlersBeforeInit = map[string]commandHandler{
 	"getblockcount":         handleGetBlockCount,
 	"getblockhash":          handleGetBlockHash,
 	"getblockheader":        handleGetBlockHeader,
+	"getblockstats":         handleGetBlockStats,
 	"getblocktemplate":      handleGetBlockTemplate,
 	"getcfilter":            handleGetCFilter,
 	"getcfilterheader":      handleGetCFilterHeader,
@@ -163,6 +170,7 @@ var rpcHandlersBeforeInit = map[string]commandHandler{
 	"help":                  handleHelp,
 	"node":                  handleNode,
 	"ping":                  handlePing,
+	"scantxoutset":          handleScanTxOutSet,
 	"searchrawtransactions": handleSearchRawTransactions,
 	"sendrawtransaction":    handleSendRawTransaction,
 	"setgenerate":           handleSetGenerate,
@@ -261,6 +269,7 @@ var rpcLimited = map[string]struct{}{
 	"getblockcount":         {},
 	"getblockhash":          {},
 	"getblockheader":        {},
+	"getblockstats":         {},
 	"getcfilter":            {},
 	"getcfilterheader":      {},
 	"getcurrentnet":         {},
@@ -335,14 +344,25 @@ type gbtWorkState struct {
 	template      *mining.BlockTemplate
 	notifyMap     map[chainhash.Hash]map[int64]chan struct{}
 	timeSource    blockchain.MedianTimeSource
+
+	// signetChallenge is the challenge script the blocks have to provide
+	// a solution for when running on a signet.
+	signetChallenge []byte
+
+	// rules and vbAvailable are the names of the rule change deployments
+	// which are active for the template and the bits of the ones it
+	// signals for as defined by BIP0009.
+	rules       []string
+	vbAvailable map[string]uint8
 }
 
 // newGbtWorkState returns a new instance of a gbtWorkState with all internal
 // fields initialized and ready to use.
-func newGbtWorkState(timeSource blockchain.MedianTimeSource) *gbtWorkState {
+func newGbtWorkState(timeSource blockchain.MedianTimeSource, signetChallenge []byte) *gbtWorkState {
 	return &gbtWorkState{
-		notifyMap:  make(map[chainhash.Hash]map[int64]chan struct{}),
-		timeSource: timeSource,
+		notifyMap:       make(map[chainhash.Hash]map[int64]chan struct{}),
+		timeSource:      timeSource,
+		signetChallenge: signetChallenge,
 	}
 }
 
@@ -739,6 +759,82 @@ func createVoutList(mtx *wire.MsgTx, chainParams *chaincfg.Params, filterAddrMap
 	return voutList
 }
 
+// createScriptPubKeyResult returns a JSON object describing the passed public
+// key script.
+func createScriptPubKeyResult(pkScript []byte, chainParams *chaincfg.Params) btcjson.ScriptPubKeyResult {
+	// The disassembled string will contain [error] inline if the script
+	// doesn't fully parse, so ignore the error here.
+	disbuf, _ := txscript.DisasmString(pkScript)
+
+	// Ignore the error here since an error means the script couldn't parse
+	// and there is no additional information about it anyways.
+	scriptClass, addrs, reqSigs, _ := txscript.ExtractPkScriptAddrs(
+		pkScript, chainParams)
+	encodedAddrs := make([]string, len(addrs))
+	for i, addr := range addrs {
+		encodedAddrs[i] = addr.EncodeAddress()
+	}
+
+	return btcjson.ScriptPubKeyResult{
+		Asm:       disbuf,
+		Hex:       hex.EncodeToString(pkScript),
+		ReqSigs:   int32(reqSigs),
+		Type:      scriptClass.String(),
+		Addresses: encodedAddrs,
+	}
+}
+
+// setTxRawPrevOuts populates the previous output spent by each input of the
+// passed raw transaction result along with the fee paid by the transaction.
+// The amount taxed from each input is also set for tax transactions.  The
+// spent outputs must be in the same order as the transaction inputs.
+func setTxRawPrevOuts(txReply *btcjson.TxRawResult, tx *btcutil.Tx,
+	stxos []blockchain.SpentTxOut, chainParams *chaincfg.Params) error {
+
+	var taxed []int64
+	if txReply.TaxTx {
+		var err error
+		taxed, err = blockchain.TaxedInputAmounts(tx, stxos, chainParams)
+		if err != nil {
+			context := "Failed to calculate taxed amounts"
+			return internalRPCError(err.Error(), context)
+		}
+	}
+
+	var totalIn int64
+	for i := range stxos {
+		stxo := &stxos[i]
+		totalIn += stxo.Amount
+
+		scriptPubKey := createScriptPubKeyResult(stxo.PkScript, chainParams)
+		prevOut := &btcjson.PrevOut{
+			Addresses:    scriptPubKey.Addresses,
+			Value:        btcutil.Amount(stxo.Amount).ToBTC(),
+			ScriptPubKey: &scriptPubKey,
+		}
+		if taxed != nil {
+			taxedAmount := btcutil.Amount(taxed[i]).ToBTC()
+			prevOut.Taxed = &taxedAmount
+		}
+		txReply.Vin[i].PrevOut = prevOut
+	}
+
+	// Tax transactions pay no fee, so what they take from the inputs is
+	// the swept tax instead.
+	var totalOut int64
+	for _, txOut := range tx.MsgTx().TxOut {
+		totalOut += txOut.Value
+	}
+	amount := btcutil.Amount(totalIn - totalOut).ToBTC()
+	if txReply.TaxTx {
+		txReply.Tax = &amount
+	} else {
+		txReply.Fee = &amount
+	}
+
+	return nil
+}
+
 // createTxRawResult converts the passed transaction and associated parameters
 // to a raw transaction JSON object.
 func createTxRawResult(chainParams *chaincfg.Params, mtx *wire.MsgTx,
@@ -760,6 +856,7 @@ func createTxRawResult(chainParams *chaincfg.Params, mtx *wire.MsgTx,
 		Vout:     createVoutList(mtx, chainParams, nil),
 		Version:  mtx.Version,
 		LockTime: mtx.LockTime,
+		TaxTx:    blockchain.IsTaxTx(mtx),
 	}
 
 	if blkHeader != nil {
@@ -854,6 +951,55 @@ func handleDecodeScript(s *rpcServer, cmd interface{}, closeChan <-chan struct{}
 	return reply, nil
 }
 
+// handleDumpTxOutSet handles dumptxoutset commands.
+func handleDumpTxOutSet(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.DumpTxOutSetCmd)
+
+	// Relative paths are relative to the data directory.  The snapshot is
+	// written to a temporary file first so an incomplete snapshot is never
+	// mistaken for a complete one.
+	path := c.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cfg.DataDir, path)
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: fmt.Sprintf("%s already exists", path),
+		}
+	}
+	tmpPath := path + ".incomplete"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Unable to create file")
+	}
+	w := bufio.NewWriter(f)
+	info, err := s.cfg.Chain.DumpUtxoSnapshot(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		context := "Unable to write utxo snapshot"
+		return nil, internalRPCError(err.Error(), context)
+	}
+
+	return &btcjson.DumpTxOutSetResult{
+		CoinsWritten:   info.NumTxOuts,
+		BaseHash:       info.BlockHash.String(),
+		BaseHeight:     info.Height,
+		Path:           path,
+		TxOutSetHash:   info.TxOutSetHash.String(),
+		TaxSweepHeight: info.TaxSweepHeight,
+	}, nil
+}
+
 // handleEstimateFee handles estimatefee commands.
 func handleEstimateFee(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	c := cmd.(*btcjson.EstimateFeeCmd)
@@ -1081,13 +1227,34 @@ func handleGetBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 		}
 	}
 
-	// When the verbose flag isn't set, simply return the serialized block
-	// as a hex-encoded string.
-	if c.Verbose != nil && !*c.Verbose {
+	// The verbose tx flag raises the default verbosity level to include
+	// the transactions as JSON objects.
+	verbosity := btcjson.BlockVerbosityTxIDs
+	if c.Verbosity != nil {
+		verbosity = *c.Verbosity
+	}
+	if verbosity == btcjson.BlockVerbosityTxIDs && c.VerboseTx != nil &&
+		*c.VerboseTx {
+
+		verbosity = btcjson.BlockVerbosityTx
+	}
+	if verbosity < btcjson.BlockVerbosityHex ||
+		verbosity > btcjson.BlockVerbosityPrevOut {
+
+		return nil, &btcjson.RPCError{
+			Code: btcjson.ErrRPCInvalidParameter,
+			Message: fmt.Sprintf("Invalid verbosity level %d",
+				verbosity),
+		}
+	}
+
+	// When the verbosity level is zero, simply return the serialized
+	// block as a hex-encoded string.
+	if verbosity == btcjson.BlockVerbosityHex {
 		return hex.EncodeToString(blkBytes), nil
 	}
 
-	// The verbose flag is set, so generate the JSON object and return it.
+	// Otherwise, generate the JSON object and return it.
 
 	// Deserialize the block.
 	blk, err := btcutil.NewBlockFromBytes(blkBytes)
@@ -1136,7 +1303,7 @@ func handleGetBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 		NextHash:      nextHashString,
 	}
 
-	if c.VerboseTx == nil || !*c.VerboseTx {
+	if verbosity == btcjson.BlockVerbosityTxIDs {
 		transactions := blk.Transactions()
 		txNames := make([]string, len(transactions))
 		for i, tx := range transactions {
@@ -1145,8 +1312,22 @@ func handleGetBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 
 		blockReply.Tx = txNames
 	} else {
+		// Load the outputs spent by the block from the spend journal when
+		// the previous outputs were requested.  They are ordered the same
+		// as the inputs of all non-coinbase transactions in the block.
+		var stxos []blockchain.SpentTxOut
+		verbosePrevOut := verbosity == btcjson.BlockVerbosityPrevOut
+		if verbosePrevOut {
+			stxos, err = s.cfg.Chain.FetchSpendJournal(blk)
+			if err != nil {
+				context := "Failed to load spend journal"
+				return nil, internalRPCError(err.Error(), context)
+			}
+		}
+
 		txns := blk.Transactions()
 		rawTxns := make([]btcjson.TxRawResult, len(txns))
+		var stxoIdx int
 		for i, tx := range txns {
 			rawTxn, err := createTxRawResult(params, tx.MsgTx(),
 				tx.Hash().String(), blockHeader, hash.String(),
@@ -1154,6 +1335,21 @@ func handleGetBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 			if err != nil {
 				return nil, err
 			}
+
+			if verbosePrevOut && i > 0 {
+				numTxIns := len(tx.MsgTx().TxIn)
+				if stxoIdx+numTxIns > len(stxos) {
+					context := "Spend journal does not match block"
+					return nil, internalRPCError("missing spent "+
+						"outputs for "+tx.Hash().String(), context)
+				}
+				err := setTxRawPrevOuts(rawTxn, tx,
+					stxos[stxoIdx:stxoIdx+numTxIns], params)
+				if err != nil {
+					return nil, err
+				}
+				stxoIdx += numTxIns
+			}
 			rawTxns[i] = *rawTxn
 		}
 		blockReply.RawTx = rawTxns
@@ -1181,6 +1377,50 @@ func softForkStatus(state blockchain.ThresholdState) (string, error) {
 	}
 }
 
+// deploymentName returns the human readable name of the passed BIP0009 rule
+// change deployment.
+func deploymentName(deployment int) (string, error) {
+	switch deployment {
+	case chaincfg.DeploymentTestDummy:
+		return "dummy", nil
+	case chaincfg.DeploymentCSV:
+		return "csv", nil
+	case chaincfg.DeploymentSegwit:
+		return "segwit", nil
+	case chaincfg.DeploymentTaxation:
+		return "taxation", nil
+	case chaincfg.DeploymentFullExpiry:
+		return "fullexpiry", nil
+	default:
+		return "", fmt.Errorf("unknown deployment %v", deployment)
+	}
+}
+
+// gbtDeployments returns the names of the rule change deployments which are
+// active for the block after the end of the current best chain along with the
+// bits of the deployments the block signals for by name as defined by BIP0009.
+func gbtDeployments(chain *blockchain.BlockChain, params *chaincfg.Params) ([]string, map[string]uint8, error) {
+	var rules []string
+	vbAvailable := make(map[string]uint8)
+	for deployment, deploymentDetails := range params.Deployments {
+		name, err := deploymentName(deployment)
+		if err != nil {
+			return nil, nil, err
+		}
+		state, err := chain.ThresholdState(uint32(deployment))
+		if err != nil {
+			return nil, nil, err
+		}
+		switch state {
+		case blockchain.ThresholdActive:
+			rules = append(rules, name)
+		case blockchain.ThresholdStarted, blockchain.ThresholdLockedIn:
+			vbAvailable[name] = deploymentDetails.BitNumber
+		}
+	}
+	return rules, vbAvailable, nil
+}
+
 // handleGetBlockChainInfo implements the getblockchaininfo command.
 func handleGetBlockChainInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	// Obtain a snapshot of the current best known blockchain state. We'll
@@ -1198,6 +1438,7 @@ func handleGetBlockChainInfo(s *rpcServer, cmd interface{}, closeChan <-chan str
 		MedianTime:    chainSnapshot.MedianTime.Unix(),
 		Pruned:        false,
 		Bip9SoftForks: make(map[string]*btcjson.Bip9SoftForkDescription),
+		Warnings:      s.cfg.SyncMgr.Warnings(),
 	}
 
 	// Next, populate the response with information describing the current
@@ -1239,18 +1480,8 @@ func handleGetBlockChainInfo(s *rpcServer, cmd interface{}, closeChan <-chan str
 	for deployment, deploymentDetails := range params.Deployments {
 		// Map the integer deployment ID into a human readable
 		// fork-name.
-		var forkName string
-		switch deployment {
-		case chaincfg.DeploymentTestDummy:
-			forkName = "dummy"
-
-		case chaincfg.DeploymentCSV:
-			forkName = "csv"
-
-		case chaincfg.DeploymentSegwit:
-			forkName = "segwit"
-
-		default:
+		forkName, err := deploymentName(deployment)
+		if err != nil {
 			return nil, &btcjson.RPCError{
 				Code: btcjson.ErrRPCInternal.Code,
 				Message: fmt.Sprintf("Unknown deployment %v "+
@@ -1379,6 +1610,373 @@ func handleGetBlockHeader(s *rpcServer, cmd interface{}, closeChan <-chan struct
 	return blockHeaderReply, nil
 }
 
+// blockStatsPerUTXOOverhead is the approximate number of bytes each entry in
+// the utxo set requires in addition to its serialized transaction output.  It
+// accounts for the outpoint along with the height and flags of the entry.
+const blockStatsPerUTXOOverhead = 41
+
+// blockStatsNeedSpendJournal houses the getblockstats statistics that can only
+// be calculated with the previous outputs spent by the block, which requires
+// loading the spend journal entry for the block.
+var blockStatsNeedSpendJournal = map[string]struct{}{
+	"avgfee":              {},
+	"avgfeerate":          {},
+	"feerate_percentiles": {},
+	"maxfee":              {},
+	"maxfeerate":          {},
+	"medianfee":           {},
+	"minfee":              {},
+	"minfeerate":          {},
+	"totalfee":            {},
+	"utxo_size_inc":       {},
+	"taxswept":            {},
+	"taxdustswept":        {},
+}
+
+// blockStatsFeeRate pairs the fee rate of a transaction with its virtual size
+// so fee rate percentiles can be weighted by size.
+type blockStatsFeeRate struct {
+	feeRate int64
+	vsize   int64
+}
+
+// calcTruncatedMedian returns the median of the passed values, truncating the
+// average of the two middle values when there is an even number of them.  The
+// passed slice is sorted in place.
+func calcTruncatedMedian(values []int64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+	return values[mid]
+}
+
+// calcFeeRatePercentiles returns the 10th, 25th, 50th, 75th and 90th fee rate
+// percentiles of the passed fee rates weighted by virtual size.  The passed
+// slice is sorted in place.
+func calcFeeRatePercentiles(feeRates []blockStatsFeeRate, totalVSize int64) []int64 {
+	percentiles := make([]int64, 5)
+	if len(feeRates) == 0 {
+		return percentiles
+	}
+
+	sort.Slice(feeRates, func(i, j int) bool {
+		return feeRates[i].feeRate < feeRates[j].feeRate
+	})
+	weights := [5]float64{
+		float64(totalVSize) / 10,
+		float64(totalVSize) / 4,
+		float64(totalVSize) / 2,
+		float64(totalVSize) * 3 / 4,
+		float64(totalVSize) * 9 / 10,
+	}
+
+	var next int
+	var cumulative int64
+	for _, rate := range feeRates {
+		cumulative += rate.vsize
+		for next < len(weights) && float64(cumulative) >= weights[next] {
+			percentiles[next] = rate.feeRate
+			next++
+		}
+	}
+
+	// Fill any remaining percentiles with the highest fee rate.
+	for ; next < len(percentiles); next++ {
+		percentiles[next] = feeRates[len(feeRates)-1].feeRate
+	}
+
+	return percentiles
+}
+
+// blockStatsNeedPrevOuts returns whether any of the passed getblockstats
+// statistics need the previous outputs spent by the block.  All statistics are
+// calculated when none are specified.
+func blockStatsNeedPrevOuts(selected []string) bool {
+	if len(selected) == 0 {
+		return true
+	}
+	for _, stat := range selected {
+		if _, ok := blockStatsNeedSpendJournal[stat]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// handleGetBlockStats implements the getblockstats command.
+func handleGetBlockStats(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.GetBlockStatsCmd)
+
+	var selected []string
+	if c.Stats != nil {
+		selected = *c.Stats
+	}
+	loadPrevOuts := blockStatsNeedPrevOuts(selected)
+
+	// Load the block from either its hash or height in the main chain.
+	var block *btcutil.Block
+	var err error
+	switch {
+	case c.HashOrHeight.Hash != nil:
+		hash, err := chainhash.NewHashFromStr(*c.HashOrHeight.Hash)
+		if err != nil {
+			return nil, rpcDecodeHexError(*c.HashOrHeight.Hash)
+		}
+		block, err = s.cfg.Chain.BlockByHash(hash)
+		if err != nil {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCBlockNotFound,
+				Message: "Block not found",
+			}
+		}
+
+	case c.HashOrHeight.Height != nil:
+		block, err = s.cfg.Chain.BlockByHeight(*c.HashOrHeight.Height)
+		if err != nil {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCOutOfRange,
+				Message: "Block number out of range",
+			}
+		}
+
+	default:
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: "A block hash or height must be provided",
+		}
+	}
+
+	// Load the outputs spent by the block from the spend journal.  They
+	// are in the same order as the inputs of the non-coinbase transactions.
+	var stxos []blockchain.SpentTxOut
+	if loadPrevOuts {
+		stxos, err = s.cfg.Chain.FetchSpendJournal(block)
+		if err != nil {
+			context := "Failed to load spend journal"
+			return nil, internalRPCError(err.Error(), context)
+		}
+	}
+
+	stats, err := calcBlockStats(block, stxos, loadPrevOuts,
+		s.cfg.ChainParams)
+	if err != nil {
+		return nil, err
+	}
+	return selectBlockStats(stats, selected)
+}
+
+// calcBlockStats returns the getblockstats statistics of the passed block.  The
+// statistics which need the previous outputs spent by the block are only
+// calculated when the load previous outputs flag is set, in which case the
+// passed spent outputs must be in the same order as the inputs of the
+// non-coinbase transactions of the block.
+func calcBlockStats(block *btcutil.Block, stxos []blockchain.SpentTxOut,
+	loadPrevOuts bool, params *chaincfg.Params) (*btcjson.GetBlockStatsResult, error) {
+
+	header := &block.MsgBlock().Header
+	txns := block.Transactions()
+	stats := &btcjson.GetBlockStatsResult{
+		Hash:       block.Hash().String(),
+		Height:     int64(block.Height()),
+		Time:       header.Timestamp.Unix(),
+		Subsidy:    blockchain.CalcBlockSubsidy(block.Height(), params),
+		Txs:        int64(len(txns)),
+		MinFee:     math.MaxInt64,
+		MinFeeRate: math.MaxInt64,
+		MinTxSize:  math.MaxInt64,
+	}
+
+	var stxoIdx int
+	var totalVSize int64
+	fees := make([]int64, 0, len(txns))
+	txSizes := make([]int64, 0, len(txns))
+	feeRates := make([]blockStatsFeeRate, 0, len(txns))
+	for i, tx := range txns {
+		msgTx := tx.MsgTx()
+		isCoinBase := i == 0
+
+		// Account for the outputs which are added to the utxo set.
+		// Provably unspendable outputs never enter the set.
+		var totalOut int64
+		stats.Outs += int64(len(msgTx.TxOut))
+		for _, txOut := range msgTx.TxOut {
+			totalOut += txOut.Value
+			if txscript.IsUnspendable(txOut.PkScript) {
+				continue
+			}
+			stats.UTXOIncrease++
+			stats.UTXOSizeIncrease += int64(txOut.SerializeSize()) +
+				blockStatsPerUTXOOverhead
+		}
+
+		// The remaining statistics only apply to transactions which
+		// spend previous outputs.
+		if isCoinBase {
+			continue
+		}
+		stats.TotalOut += totalOut
+		stats.Ins += int64(len(msgTx.TxIn))
+		stats.UTXOIncrease -= int64(len(msgTx.TxIn))
+
+		txSize := int64(msgTx.SerializeSize())
+		txWeight := blockchain.GetTransactionWeight(tx)
+		stats.TotalSize += txSize
+		stats.TotalWeight += txWeight
+		txSizes = append(txSizes, txSize)
+		if txSize < stats.MinTxSize {
+			stats.MinTxSize = txSize
+		}
+		if txSize > stats.MaxTxSize {
+			stats.MaxTxSize = txSize
+		}
+		if msgTx.HasWitness() {
+			stats.SegWitTxs++
+			stats.SegWitTotalSize += txSize
+			stats.SegWitTotalWeight += txWeight
+		}
+		isTaxTx := blockchain.IsTaxTransaction(tx)
+		if isTaxTx {
+			stats.TaxTxs++
+		}
+
+		if !loadPrevOuts {
+			continue
+		}
+
+		// Sum the previous outputs spent by the transaction.
+		numIns := len(msgTx.TxIn)
+		if stxoIdx+numIns > len(stxos) {
+			str := fmt.Sprintf("spend journal has %d entries for "+
+				"more inputs in block %v", len(stxos),
+				block.Hash())
+			return nil, internalRPCError(str,
+				"Failed to load spend journal")
+		}
+		txStxos := stxos[stxoIdx : stxoIdx+numIns]
+		stxoIdx += numIns
+		var totalIn int64
+		for i := range txStxos {
+			stxo := &txStxos[i]
+			totalIn += stxo.Amount
+			stats.UTXOSizeIncrease -= int64(wire.NewTxOut(stxo.Amount,
+				stxo.PkScript).SerializeSize()) +
+				blockStatsPerUTXOOverhead
+		}
+
+		// The difference between the inputs and outputs of a tax
+		// transaction is the tax swept by the miner rather than a fee,
+		// so it is excluded from the fee statistics.  Expired outputs
+		// at or below the dust limit which are not paid back to their
+		// owners are swept in their entirety.
+		fee := totalIn - totalOut
+		if isTaxTx {
+			taxed, err := blockchain.TaxedInputAmounts(tx, txStxos,
+				params)
+			if err != nil {
+				context := "Failed to calculate taxed amounts"
+				return nil, internalRPCError(err.Error(), context)
+			}
+			for i := range txStxos {
+				amount := txStxos[i].Amount
+				if taxed[i] == amount &&
+					amount <= int64(params.DustSatoshiAmount) {
+
+					stats.TaxDustSwept += amount
+				}
+			}
+			stats.TaxSwept += fee
+			continue
+		}
+
+		vsize := (txWeight + (blockchain.WitnessScaleFactor - 1)) /
+			blockchain.WitnessScaleFactor
+		feeRate := fee / vsize
+		stats.TotalFee += fee
+		totalVSize += vsize
+		fees = append(fees, fee)
+		feeRates = append(feeRates, blockStatsFeeRate{feeRate, vsize})
+		if fee < stats.MinFee {
+			stats.MinFee = fee
+		}
+		if fee > stats.MaxFee {
+			stats.MaxFee = fee
+		}
+		if feeRate < stats.MinFeeRate {
+			stats.MinFeeRate = feeRate
+		}
+		if feeRate > stats.MaxFeeRate {
+			stats.MaxFeeRate = feeRate
+		}
+	}
+
+	// Finalize the aggregate statistics and reset any minimums which were
+	// never updated due to a lack of transactions.
+	if len(txSizes) > 0 {
+		stats.AverageTxSize = stats.TotalSize / int64(len(txSizes))
+	}
+	if len(fees) > 0 {
+		stats.AverageFee = stats.TotalFee / int64(len(fees))
+	}
+	if totalVSize > 0 {
+		stats.AverageFeeRate = stats.TotalFee / totalVSize
+	}
+	if stats.MinFee == math.MaxInt64 {
+		stats.MinFee = 0
+	}
+	if stats.MinFeeRate == math.MaxInt64 {
+		stats.MinFeeRate = 0
+	}
+	if stats.MinTxSize == math.MaxInt64 {
+		stats.MinTxSize = 0
+	}
+	stats.MedianFee = calcTruncatedMedian(fees)
+	stats.MedianTxSize = calcTruncatedMedian(txSizes)
+	stats.FeeRatePercentiles = calcFeeRatePercentiles(feeRates, totalVSize)
+
+	return stats, nil
+}
+
+// selectBlockStats returns the passed getblockstats statistics limited to the
+// selected ones.  All statistics are returned when none are selected.
+func selectBlockStats(stats *btcjson.GetBlockStatsResult, selected []string) (interface{}, error) {
+	if len(selected) == 0 {
+		return stats, nil
+	}
+
+	// Only return the selected statistics.  The JSON representation of
+	// the full result is used to look up the statistics by name so the
+	// names remain consistent with the full result.
+	statsJSON, err := json.Marshal(stats)
+	if err != nil {
+		context := "Failed to marshal block stats"
+		return nil, internalRPCError(err.Error(), context)
+	}
+	var allStats map[string]json.RawMessage
+	if err := json.Unmarshal(statsJSON, &allStats); err != nil {
+		context := "Failed to unmarshal block stats"
+		return nil, internalRPCError(err.Error(), context)
+	}
+	filtered := make(map[string]json.RawMessage, len(selected))
+	for _, stat := range selected {
+		value, ok := allStats[stat]
+		if !ok {
+			return nil, &btcjson.RPCError{
+				Code: btcjson.ErrRPCInvalidParameter,
+				Message: fmt.Sprintf("Invalid selected "+
+					"statistic %s", stat),
+			}
+		}
+		filtered[stat] = value
+	}
+	return filtered, nil
+}
+
 // encodeTemplateID encodes the passed details into an ID that can be used to
 // uniquely identify a block template.
 func encodeTemplateID(prevHash *chainhash.Hash, lastGenerated time.Time) string {
@@ -1586,6 +2184,15 @@ func (state *gbtWorkState) updateBlockTemplate(s *rpcServer, useCoinbaseValue bo
 		best := s.cfg.Chain.BestSnapshot()
 		minTimestamp := mining.MinimumMedianTime(best)
 
+		// Determine the rule change deployments which are active for
+		// the block and the ones it signals for.
+		rules, vbAvailable, err := gbtDeployments(s.cfg.Chain,
+			s.cfg.ChainParams)
+		if err != nil {
+			context := "Failed to obtain deployment status"
+			return internalRPCError(err.Error(), context)
+		}
+
 		// Update work state to ensure another block template isn't
 		// generated until needed.
 		state.template = template
@@ -1593,6 +2200,8 @@ func (state *gbtWorkState) updateBlockTemplate(s *rpcServer, useCoinbaseValue bo
 		state.lastTxUpdate = lastTxUpdate
 		state.prevHash = latestHash
 		state.minTimestamp = minTimestamp
+		state.rules = rules
+		state.vbAvailable = vbAvailable
 
 		rpcsLog.Debugf("Generated block template (timestamp %v, "+
 			"target %s, merkle root %s)",
@@ -1754,6 +2363,8 @@ func (state *gbtWorkState) blockTemplateResult(useCoinbaseValue bool, submitOld
 		Mutable:      gbtMutableFields,
 		NonceRange:   gbtNonceRange,
 		Capabilities: gbtCapabilities,
+		Rules:        state.rules,
+		VbAvailable:  state.vbAvailable,
 	}
 	// If the generated block template includes transactions with witness
 	// data, then include the witness commitment in the GBT result.
@@ -1761,6 +2372,12 @@ func (state *gbtWorkState) blockTemplateResult(useCoinbaseValue bool, submitOld
 		reply.DefaultWitnessCommitment = hex.EncodeToString(template.WitnessCommitment)
 	}
 
+	// Include the challenge the block has to provide a solution for when
+	// running on a signet.
+	if state.signetChallenge != nil {
+		reply.SignetChallenge = hex.EncodeToString(state.signetChallenge)
+	}
+
 	if useCoinbaseValue {
 		reply.CoinbaseAux = gbtCoinbaseAux
 		reply.CoinbaseValue = &msgBlock.Transactions[0].TxOut[0].Value
@@ -2312,6 +2929,7 @@ func handleGetInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (in
 		Difficulty:      getDifficultyRatio(best.Bits, s.cfg.ChainParams),
 		TestNet:         cfg.TestNet3,
 		RelayFee:        cfg.minRelayTxFee.ToBTC(),
+		Errors:          s.cfg.SyncMgr.Warnings(),
 	}
 
 	return ret, nil
@@ -2373,10 +2991,19 @@ func handleGetMiningInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{
 // handleGetNetTotals implements the getnettotals command.
 func handleGetNetTotals(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	totalBytesRecv, totalBytesSent := s.cfg.ConnMgr.NetTotals()
+	uploadTarget := s.cfg.ConnMgr.UploadTarget()
 	reply := &btcjson.GetNetTotalsResult{
 		TotalBytesRecv: totalBytesRecv,
 		TotalBytesSent: totalBytesSent,
 		TimeMillis:     time.Now().UTC().UnixNano() / int64(time.Millisecond),
+		UploadTarget: btcjson.GetNetTotalsUploadTargetResult{
+			TimeFrame:             int64(uploadTarget.Timeframe / time.Second),
+			Target:                uploadTarget.Target,
+			TargetReached:         uploadTarget.TargetReached,
+			ServeHistoricalBlocks: uploadTarget.ServeHistoricalBlocks,
+			BytesLeftInCycle:      uploadTarget.BytesLeftInCycle,
+			TimeLeftInCycle:       int64(uploadTarget.TimeLeftInCycle / time.Second),
+		},
 	}
 	return reply, nil
 }
@@ -2498,6 +3125,8 @@ func handleGetPeerInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{})
 			Version:        statsSnap.Version,
 			SubVer:         statsSnap.UserAgent,
 			Inbound:        statsSnap.Inbound,
+			ConnectionType: p.ConnectionType(),
+			Permissions:    p.Permissions(),
 			StartingHeight: statsSnap.StartingHeight,
 			CurrentHeight:  statsSnap.LastBlock,
 			BanScore:       int32(p.BanScore()),
@@ -3032,6 +3661,317 @@ func fetchMempoolTxnsForAddress(s *rpcServer, addr btcutil.Address, numToSkip, n
 	return mpTxns[numToSkip:rangeEnd], numToSkip
 }
 
+// scanTxOutSetCheckInterval is the number of utxos scanned by scantxoutset
+// between checks for whether the scan has been aborted.
+const scanTxOutSetCheckInterval = 10000
+
+// errScanTxOutSetAborted is used to stop iterating the utxo set when a
+// scantxoutset scan is aborted.
+var errScanTxOutSetAborted = errors.New("scan aborted")
+
+// splitScanDescriptor splits the passed output descriptor of the form
+// name(arg) into its name and argument.
+func splitScanDescriptor(desc string) (string, string, error) {
+	open := strings.IndexByte(desc, '(')
+	if open <= 0 || !strings.HasSuffix(desc, ")") {
+		return "", "", fmt.Errorf("malformed descriptor %q", desc)
+	}
+	return desc[:open], desc[open+1 : len(desc)-1], nil
+}
+
+// scanDescriptorScripts parses the passed output descriptor and returns the
+// public key scripts it describes.  Only descriptors that describe a fixed set
+// of scripts are supported, namely addr(ADDRESS), raw(HEX), pk(PUBKEY),
+// pkh(PUBKEY), wpkh(PUBKEY), sh(wpkh(PUBKEY)) and combo(PUBKEY).  Any
+// descriptor checksum is ignored.
+func scanDescriptorScripts(desc string, params *chaincfg.Params) ([][]byte, error) {
+	if i := strings.IndexByte(desc, '#'); i >= 0 {
+		desc = desc[:i]
+	}
+	name, arg, err := splitScanDescriptor(strings.TrimSpace(desc))
+	if err != nil {
+		return nil, err
+	}
+
+	// parsePubKey decodes the hex-encoded public key argument into a
+	// pay-to-pubkey address.
+	parsePubKey := func(arg string) (*btcutil.AddressPubKey, error) {
+		serializedPubKey, err := hex.DecodeString(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %q: %v",
+				arg, err)
+		}
+		return btcutil.NewAddressPubKey(serializedPubKey, params)
+	}
+
+	// witnessScripts returns the native and nested pay-to-witness-pubkey-
+	// hash scripts for the passed public key, which must be compressed.
+	witnessScripts := func(pubKey *btcutil.AddressPubKey) ([]byte, []byte, error) {
+		if pubKey.Format() != btcutil.PKFCompressed {
+			return nil, nil, errors.New("witness outputs require " +
+				"a compressed public key")
+		}
+		pkHash := btcutil.Hash160(pubKey.ScriptAddress())
+		addr, err := btcutil.NewAddressWitnessPubKeyHash(pkHash, params)
+		if err != nil {
+			return nil, nil, err
+		}
+		witnessScript, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			return nil, nil, err
+		}
+		shAddr, err := btcutil.NewAddressScriptHash(witnessScript, params)
+		if err != nil {
+			return nil, nil, err
+		}
+		nestedScript, err := txscript.PayToAddrScript(shAddr)
+		if err != nil {
+			return nil, nil, err
+		}
+		return witnessScript, nestedScript, nil
+	}
+
+	switch name {
+	case "addr":
+		addr, err := btcutil.DecodeAddress(arg, params)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %v", arg,
+				err)
+		}
+		if !addr.IsForNet(params) {
+			return nil, fmt.Errorf("address %q is not for %s", arg,
+				params.Name)
+		}
+		script, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{script}, nil
+
+	case "raw":
+		script, err := hex.DecodeString(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid script %q: %v", arg, err)
+		}
+		return [][]byte{script}, nil
+
+	case "pk", "pkh", "wpkh", "combo":
+		pubKey, err := parsePubKey(arg)
+		if err != nil {
+			return nil, err
+		}
+		pkScript, err := txscript.PayToAddrScript(pubKey)
+		if err != nil {
+			return nil, err
+		}
+		pkhScript, err := txscript.PayToAddrScript(
+			pubKey.AddressPubKeyHash())
+		if err != nil {
+			return nil, err
+		}
+
+		switch name {
+		case "pk":
+			return [][]byte{pkScript}, nil
+		case "pkh":
+			return [][]byte{pkhScript}, nil
+		case "wpkh":
+			witnessScript, _, err := witnessScripts(pubKey)
+			if err != nil {
+				return nil, err
+			}
+			return [][]byte{witnessScript}, nil
+		}
+
+		// The combo descriptor only includes the witness forms when
+		// the public key is compressed.
+		scripts := [][]byte{pkScript, pkhScript}
+		if pubKey.Format() == btcutil.PKFCompressed {
+			witnessScript, nestedScript, err := witnessScripts(pubKey)
+			if err != nil {
+				return nil, err
+			}
+			scripts = append(scripts, witnessScript, nestedScript)
+		}
+		return scripts, nil
+
+	case "sh":
+		innerName, innerArg, err := splitScanDescriptor(arg)
+		if err != nil {
+			return nil, err
+		}
+		if innerName != "wpkh" {
+			return nil, fmt.Errorf("unsupported nested descriptor "+
+				"%q", arg)
+		}
+		pubKey, err := parsePubKey(innerArg)
+		if err != nil {
+			return nil, err
+		}
+		_, nestedScript, err := witnessScripts(pubKey)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{nestedScript}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported descriptor %q", desc)
+}
+
+// handleScanTxOutSet implements the scantxoutset command.
+func handleScanTxOutSet(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.ScanTxOutSetCmd)
+
+	switch c.Action {
+	case "status":
+		s.utxoScanLock.Lock()
+		defer s.utxoScanLock.Unlock()
+		if s.utxoScanAbort == nil {
+			return nil, nil
+		}
+		progress := atomic.LoadUint32(&s.utxoScanProgress)
+		return &btcjson.ScanTxOutSetStatusResult{
+			Progress: float64(progress) / 100,
+		}, nil
+
+	case "abort":
+		s.utxoScanLock.Lock()
+		defer s.utxoScanLock.Unlock()
+		if s.utxoScanAbort == nil {
+			return false, nil
+		}
+		select {
+		case <-s.utxoScanAbort:
+			// Already aborted.
+			return false, nil
+		default:
+			close(s.utxoScanAbort)
+		}
+		return true, nil
+
+	case "start":
+	default:
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: fmt.Sprintf("Invalid action %q", c.Action),
+		}
+	}
+
+	// Parse the descriptors into the set of scripts to match, keeping
+	// track of which descriptor each script came from.
+	if c.ScanObjects == nil || len(*c.ScanObjects) == 0 {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: "scanobjects argument is required for the start action",
+		}
+	}
+	params := s.cfg.ChainParams
+	scriptDescs := make(map[string]string)
+	for _, desc := range *c.ScanObjects {
+		scripts, err := scanDescriptorScripts(desc, params)
+		if err != nil {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInvalidAddressOrKey,
+				Message: "Invalid scan object: " + err.Error(),
+			}
+		}
+		for _, script := range scripts {
+			scriptDescs[string(script)] = desc
+		}
+	}
+
+	// Only allow a single scan at a time.
+	s.utxoScanLock.Lock()
+	if s.utxoScanAbort != nil {
+		s.utxoScanLock.Unlock()
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: "Scan already in progress, use action \"abort\" or \"status\"",
+		}
+	}
+	abort := make(chan struct{})
+	s.utxoScanAbort = abort
+	atomic.StoreUint32(&s.utxoScanProgress, 0)
+	s.utxoScanLock.Unlock()
+	defer func() {
+		s.utxoScanLock.Lock()
+		s.utxoScanAbort = nil
+		s.utxoScanLock.Unlock()
+	}()
+
+	var txOuts int64
+	var totalAmount int64
+	unspents := make([]btcjson.ScanTxOutSetUnspent, 0)
+	var matched []wire.OutPoint
+	var matchedEntries []utxo.UtxoEntry
+	bestHash, bestHeight, err := s.cfg.Chain.ForEachUtxo(func(outpoint wire.OutPoint,
+		entry *utxo.UtxoEntry) error {
+
+		txOuts++
+		if txOuts%scanTxOutSetCheckInterval == 0 {
+			select {
+			case <-abort:
+				return errScanTxOutSetAborted
+			case <-closeChan:
+				return errScanTxOutSetAborted
+			case <-s.quit:
+				return errScanTxOutSetAborted
+			default:
+			}
+
+			// The utxos are iterated in order of their hash, so
+			// the leading bytes of the hash approximate the
+			// progress of the scan.
+			progress := (uint32(outpoint.Hash[0])<<8 |
+				uint32(outpoint.Hash[1])) * 10000 / 65536
+			atomic.StoreUint32(&s.utxoScanProgress, progress)
+		}
+
+		if _, ok := scriptDescs[string(entry.PkScript)]; !ok {
+			return nil
+		}
+		matched = append(matched, outpoint)
+		matchedEntries = append(matchedEntries, *entry.Clone())
+		return nil
+	})
+	if err == errScanTxOutSetAborted {
+		return &btcjson.ScanTxOutSetResult{
+			Success:  false,
+			TxOuts:   txOuts,
+			Unspents: unspents,
+		}, nil
+	}
+	if err != nil {
+		context := "Failed to scan utxo set"
+		return nil, internalRPCError(err.Error(), context)
+	}
+
+	for i, outpoint := range matched {
+		entry := &matchedEntries[i]
+		expired := entry.IsExpiredAt(bestHeight)
+		totalAmount += entry.Amount
+		unspents = append(unspents, btcjson.ScanTxOutSetUnspent{
+			Txid:         outpoint.Hash.String(),
+			Vout:         outpoint.Index,
+			ScriptPubKey: hex.EncodeToString(entry.PkScript),
+			Desc:         scriptDescs[string(entry.PkScript)],
+			Amount:       btcutil.Amount(entry.Amount).ToBTC(),
+			Height:       entry.BlockHeight,
+			Coinbase:     entry.IsCoinBase(),
+			Expired:      expired,
+		})
+	}
+
+	return &btcjson.ScanTxOutSetResult{
+		Success:     true,
+		TxOuts:      txOuts,
+		Height:      bestHeight,
+		BestBlock:   bestHash.String(),
+		Unspents:    unspents,
+		TotalAmount: btcutil.Amount(totalAmount).ToBTC(),
+	}, nil
+}
+
 // handleSearchRawTransactions implements the searchrawtransactions command.
 func handleSearchRawTransactions(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	// Respond with an error if the address index is not enabled.
@@ -3618,6 +4558,13 @@ type rpcServer struct {
 	helpCacher             *helpCacher
 	requestProcessShutdown chan struct{}
 	quit                   chan int
+
+	// utxoScanAbort is closed to abort the scantxoutset scan in progress
+	// and is nil when there is no scan in progress.  utxoScanProgress is
+	// the progress of that scan in hundredths of a percent.
+	utxoScanLock     sync.Mutex
+	utxoScanAbort    chan struct{}
+	utxoScanProgress uint32 // atomic
 }
 
 // httpStatusLine returns a response Status-Line (RFC 2616 Section 6.1)
@@ -3877,53 +4824,17 @@ func createMarshalledReply(id, result interface{}, replyErr error) ([]byte, erro
 	return btcjson.MarshalResponse(id, result, jsonErr)
 }
 
-// jsonRPCRead handles reading and responding to RPC messages.
-func (s *rpcServer) jsonRPCRead(w http.ResponseWriter, r *http.Request, isAdmin bool) {
-	if atomic.LoadInt32(&s.shutdown) != 0 {
-		return
-	}
-
-	// Read and close the JSON-RPC request body from the caller.
-	body, err := ioutil.ReadAll(r.Body)
-	r.Body.Close()
-	if err != nil {
-		errCode := http.StatusBadRequest
-		http.Error(w, fmt.Sprintf("%d error reading JSON message: %v",
-			errCode, err), errCode)
-		return
-	}
-
-	// Unfortunately, the http server doesn't provide the ability to
-	// change the read deadline for the new connection and having one breaks
-	// long polling.  However, not having a read deadline on the initial
-	// connection would mean clients can connect and idle forever.  Thus,
-	// hijack the connecton from the HTTP server, clear the read deadline,
-	// and handle writing the response manually.
-	hj, ok := w.(http.Hijacker)
-	if !ok {
-		errMsg := "webserver doesn't support hijacking"
-		rpcsLog.Warnf(errMsg)
-		errCode := http.StatusInternalServerError
-		http.Error(w, strconv.Itoa(errCode)+" "+errMsg, errCode)
-		return
-	}
-	conn, buf, err := hj.Hijack()
-	if err != nil {
-		rpcsLog.Warnf("Failed to hijack HTTP connection: %v", err)
-		errCode := http.StatusInternalServerError
-		http.Error(w, strconv.Itoa(errCode)+" "+err.Error(), errCode)
-		return
-	}
-	defer conn.Close()
-	defer buf.Flush()
-	conn.SetReadDeadline(timeZeroVal)
-
+// processRequest parses the passed raw JSON-RPC request object, runs the
+// requested command and returns the marshalled reply.  A nil reply is
+// returned when the request is a notification that must not be responded to
+// or the reply could not be marshalled.
+func (s *rpcServer) processRequest(rawRequest []byte, isAdmin bool, closeChan <-chan struct{}) []byte {
 	// Attempt to parse the raw body into a JSON-RPC request.
 	var responseID interface{}
 	var jsonErr error
 	var result interface{}
 	var request btcjson.Request
-	if err := json.Unmarshal(body, &request); err != nil {
+	if err := json.Unmarshal(rawRequest, &request); err != nil {
 		jsonErr = &btcjson.RPCError{
 			Code:    btcjson.ErrRPCParse.Code,
 			Message: "Failed to parse request: " + err.Error(),
@@ -3949,23 +4860,13 @@ func (s *rpcServer) jsonRPCRead(w http.ResponseWriter, r *http.Request, isAdmin
 		// RPC quirks can be enabled by the user to avoid compatibility issues
 		// with software relying on Core's behavior.
 		if request.ID == nil && !(cfg.RPCQuirks && request.Jsonrpc == "") {
-			return
+			return nil
 		}
 
 		// The parse was at least successful enough to have an ID so
 		// set it for the response.
 		responseID = request.ID
 
-		// Setup a close notifier.  Since the connection is hijacked,
-		// the CloseNotifer on the ResponseWriter is not available.
-		closeChan := make(chan struct{}, 1)
-		go func() {
-			_, err := conn.Read(make([]byte, 1))
-			if err != nil {
-				close(closeChan)
-			}
-		}()
-
 		// Check if the user is limited and set error if method unauthorized
 		if !isAdmin {
 			if _, ok := rpcLimited[request.Method]; !ok {
@@ -3992,6 +4893,135 @@ func (s *rpcServer) jsonRPCRead(w http.ResponseWriter, r *http.Request, isAdmin
 	msg, err := createMarshalledReply(responseID, result, jsonErr)
 	if err != nil {
 		rpcsLog.Errorf("Failed to marshal reply: %v", err)
+		return nil
+	}
+	return msg
+}
+
+// processBatchRequest parses the passed raw JSON-RPC batch, which is an array
+// of request objects, processes each request in order and returns the
+// marshalled array of replies.  Notifications within the batch are not
+// replied to, so a nil reply is returned when the batch only consists of
+// notifications.
+func (s *rpcServer) processBatchRequest(rawBatch []byte, isAdmin bool, closeChan <-chan struct{}) []byte {
+	var rawRequests []json.RawMessage
+	if err := json.Unmarshal(rawBatch, &rawRequests); err != nil {
+		jsonErr := &btcjson.RPCError{
+			Code:    btcjson.ErrRPCParse.Code,
+			Message: "Failed to parse request: " + err.Error(),
+		}
+		msg, err := createMarshalledReply(nil, nil, jsonErr)
+		if err != nil {
+			rpcsLog.Errorf("Failed to marshal reply: %v", err)
+			return nil
+		}
+		return msg
+	}
+
+	// An empty batch is an invalid request as defined by the JSON-RPC 2.0
+	// spec, so reply with a single error rather than an empty array.
+	if len(rawRequests) == 0 {
+		jsonErr := &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidRequest.Code,
+			Message: "Invalid request: empty batch",
+		}
+		msg, err := createMarshalledReply(nil, nil, jsonErr)
+		if err != nil {
+			rpcsLog.Errorf("Failed to marshal reply: %v", err)
+			return nil
+		}
+		return msg
+	}
+
+	replies := make([]json.RawMessage, 0, len(rawRequests))
+	for _, rawRequest := range rawRequests {
+		// Stop processing the remaining requests when the client
+		// disconnects since there is nobody to reply to.
+		select {
+		case <-closeChan:
+			return nil
+		default:
+		}
+
+		msg := s.processRequest(rawRequest, isAdmin, closeChan)
+		if msg != nil {
+			replies = append(replies, msg)
+		}
+	}
+	if len(replies) == 0 {
+		return nil
+	}
+
+	msg, err := json.Marshal(replies)
+	if err != nil {
+		rpcsLog.Errorf("Failed to marshal batch reply: %v", err)
+		return nil
+	}
+	return msg
+}
+
+// jsonRPCRead handles reading and responding to RPC messages.
+func (s *rpcServer) jsonRPCRead(w http.ResponseWriter, r *http.Request, isAdmin bool) {
+	if atomic.LoadInt32(&s.shutdown) != 0 {
+		return
+	}
+
+	// Read and close the JSON-RPC request body from the caller.
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		errCode := http.StatusBadRequest
+		http.Error(w, fmt.Sprintf("%d error reading JSON message: %v",
+			errCode, err), errCode)
+		return
+	}
+
+	// Unfortunately, the http server doesn't provide the ability to
+	// change the read deadline for the new connection and having one breaks
+	// long polling.  However, not having a read deadline on the initial
+	// connection would mean clients can connect and idle forever.  Thus,
+	// hijack the connecton from the HTTP server, clear the read deadline,
+	// and handle writing the response manually.
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		errMsg := "webserver doesn't support hijacking"
+		rpcsLog.Warnf(errMsg)
+		errCode := http.StatusInternalServerError
+		http.Error(w, strconv.Itoa(errCode)+" "+errMsg, errCode)
+		return
+	}
+	conn, buf, err := hj.Hijack()
+	if err != nil {
+		rpcsLog.Warnf("Failed to hijack HTTP connection: %v", err)
+		errCode := http.StatusInternalServerError
+		http.Error(w, strconv.Itoa(errCode)+" "+err.Error(), errCode)
+		return
+	}
+	defer conn.Close()
+	defer buf.Flush()
+	conn.SetReadDeadline(timeZeroVal)
+
+	// Setup a close notifier.  Since the connection is hijacked,
+	// the CloseNotifer on the ResponseWriter is not available.
+	closeChan := make(chan struct{}, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		if err != nil {
+			close(closeChan)
+		}
+	}()
+
+	// A request body that is a JSON array is a batch of requests which are
+	// each processed in turn and replied to with an array of responses.
+	// Otherwise, the body is a single request object.
+	var msg []byte
+	trimmedBody := bytes.TrimLeft(body, " \t\r\n")
+	if len(trimmedBody) > 0 && trimmedBody[0] == '[' {
+		msg = s.processBatchRequest(trimmedBody, isAdmin, closeChan)
+	} else {
+		msg = s.processRequest(body, isAdmin, closeChan)
+	}
+	if msg == nil {
 		return
 	}
 
@@ -4133,6 +5163,13 @@ type rpcserverPeer interface {
 	// FeeFilter returns the requested current minimum fee rate for which
 	// transactions should be announced.
 	FeeFilter() int64
+
+	// ConnectionType returns the type of the connection to the peer.
+	ConnectionType() string
+
+	// Permissions returns the names of the permissions granted to the
+	// peer.
+	Permissions() []string
 }
 
 // rpcserverConnManager represents a connection manager for use with the RPC
@@ -4175,6 +5212,9 @@ type rpcserverConnManager interface {
 	// network for all peers.
 	NetTotals() (uint64, uint64)
 
+	// UploadTarget returns the current state of the upload target.
+	UploadTarget() *uploadTargetStatus
+
 	// ConnectedPeers returns an array consisting of all connected peers.
 	ConnectedPeers() []rpcserverPeer
 
@@ -4221,6 +5261,11 @@ type rpcserverSyncManager interface {
 	// current tip is reached, up to a max of wire.MaxBlockHeadersPerMsg
 	// hashes.
 	LocateHeaders(locators []*chainhash.Hash, hashStop *chainhash.Hash) []wire.BlockHeader
+
+	// Warnings returns any warnings about the synchronization of the chain
+	// with the network, such as the best chain tip being stale, or an empty
+	// string when there are none.
+	Warnings() string
 }
 
 // rpcserverConfig is a descriptor containing the RPC server configuration.
@@ -4278,7 +5323,7 @@ func newRPCServer(config *rpcserverConfig) (*rpcServer, error) {
 	rpc := rpcServer{
 		cfg:                    *config,
 		statusLines:            make(map[int]string),
-		gbtWorkState:           newGbtWorkState(config.TimeSource),
+		gbtWorkState:           newGbtWorkState(config.TimeSource, config.ChainParams.SignetChallenge),
 		helpCacher:             newHelpCacher(),
 		requestProcessShutdown: make(chan struct{}),
 		quit:                   make(chan int),
@@ -0,0 +1,425 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/organicbitcoin/btcutil"
+	"github.com/organicbitcoin/obtcd/blockchain"
+	"github.com/organicbitcoin/obtcd/btcjson"
+	"github.com/organicbitcoin/obtcd/chaincfg"
+	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
+	"github.com/organicbitcoin/obtcd/wire"
+)
+
+// TestProcessBatchRequest ensures batch requests are replied to with an array
+// of responses in order and that the limited user restrictions are applied to
+// each request in the batch.
+func TestProcessBatchRequest(t *testing.T) {
+	t.Parallel()
+
+	type reply struct {
+		ID    interface{}       `json:"id"`
+		Error *btcjson.RPCError `json:"error"`
+	}
+
+	tests := []struct {
+		name     string
+		batch    string
+		isAdmin  bool
+		wantIDs  []float64
+		wantCode []btcjson.RPCErrorCode
+	}{
+		{
+			name: "limited user unauthorized methods",
+			batch: `[{"jsonrpc":"1.0","id":1,"method":"stop","params":[]},` +
+				`{"jsonrpc":"1.0","id":2,"method":"generate","params":[1]}]`,
+			isAdmin: false,
+			wantIDs: []float64{1, 2},
+			wantCode: []btcjson.RPCErrorCode{
+				btcjson.ErrRPCInvalidParams.Code,
+				btcjson.ErrRPCInvalidParams.Code,
+			},
+		},
+		{
+			name: "unknown method and invalid request",
+			batch: `[{"jsonrpc":"1.0","id":3,"method":"nosuchmethod","params":[]},` +
+				`"notarequest"]`,
+			isAdmin: true,
+			wantIDs: []float64{3, -1},
+			wantCode: []btcjson.RPCErrorCode{
+				btcjson.ErrRPCMethodNotFound.Code,
+				btcjson.ErrRPCParse.Code,
+			},
+		},
+	}
+
+	s := &rpcServer{}
+	closeChan := make(chan struct{})
+	for _, test := range tests {
+		msg := s.processBatchRequest([]byte(test.batch), test.isAdmin,
+			closeChan)
+
+		var replies []reply
+		if err := json.Unmarshal(msg, &replies); err != nil {
+			t.Errorf("%s: unexpected reply %q: %v", test.name, msg, err)
+			continue
+		}
+		if len(replies) != len(test.wantIDs) {
+			t.Errorf("%s: unexpected number of replies - got %d, "+
+				"want %d", test.name, len(replies),
+				len(test.wantIDs))
+			continue
+		}
+		for i, r := range replies {
+			// An id of -1 denotes a null id is expected.
+			if test.wantIDs[i] == -1 {
+				if r.ID != nil {
+					t.Errorf("%s #%d: unexpected id %v",
+						test.name, i, r.ID)
+				}
+			} else if id, ok := r.ID.(float64); !ok ||
+				id != test.wantIDs[i] {
+
+				t.Errorf("%s #%d: unexpected id - got %v, "+
+					"want %v", test.name, i, r.ID,
+					test.wantIDs[i])
+			}
+			if r.Error == nil || r.Error.Code != test.wantCode[i] {
+				t.Errorf("%s #%d: unexpected error - got %v, "+
+					"want code %v", test.name, i, r.Error,
+					test.wantCode[i])
+			}
+		}
+	}
+
+	// An empty batch is an invalid request and is replied to with a single
+	// error object rather than an array.
+	msg := s.processBatchRequest([]byte("[]"), true, closeChan)
+	var r reply
+	if err := json.Unmarshal(msg, &r); err != nil {
+		t.Fatalf("empty batch: unexpected reply %q: %v", msg, err)
+	}
+	if r.Error == nil || r.Error.Code != btcjson.ErrRPCInvalidRequest.Code {
+		t.Fatalf("empty batch: unexpected error %v", r.Error)
+	}
+}
+
+// TestScanDescriptorScripts ensures the output descriptors supported by
+// scantxoutset are parsed into the expected public key scripts.
+func TestScanDescriptorScripts(t *testing.T) {
+	t.Parallel()
+
+	// The secp256k1 generator point is used as the public key along with
+	// the scripts paying to it.
+	const (
+		compressedPubKey   = "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+		uncompressedPubKey = "0479be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798" +
+			"483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"
+		pkScript   = "21" + compressedPubKey + "ac"
+		pkhScript  = "76a914751e76e8199196d454941c45d1b3a323f1433bd688ac"
+		wpkhScript = "0014751e76e8199196d454941c45d1b3a323f1433bd6"
+		shScript   = "a914bcfeb728b584253d5f3f70bcb780e9ef218a68f487"
+	)
+
+	params := &chaincfg.MainNetParams
+	pkHash, _ := hex.DecodeString("751e76e8199196d454941c45d1b3a323f1433bd6")
+	addr, err := btcutil.NewAddressPubKeyHash(pkHash, params)
+	if err != nil {
+		t.Fatalf("unable to create address: %v", err)
+	}
+
+	tests := []struct {
+		desc    string
+		scripts []string
+		wantErr bool
+	}{
+		{desc: "addr(" + addr.EncodeAddress() + ")", scripts: []string{pkhScript}},
+		{desc: "raw(51)", scripts: []string{"51"}},
+		{desc: "pk(" + compressedPubKey + ")", scripts: []string{pkScript}},
+		{desc: "pkh(" + compressedPubKey + ")#checksum", scripts: []string{pkhScript}},
+		{desc: "wpkh(" + compressedPubKey + ")", scripts: []string{wpkhScript}},
+		{desc: "sh(wpkh(" + compressedPubKey + "))", scripts: []string{shScript}},
+		{
+			desc: "combo(" + compressedPubKey + ")",
+			scripts: []string{pkScript, pkhScript, wpkhScript,
+				shScript},
+		},
+		{desc: "combo(" + uncompressedPubKey + ")", scripts: []string{
+			"41" + uncompressedPubKey + "ac",
+			"76a91491b24bf9f5288532960ac687abb035127b1d28a588ac",
+		}},
+		{desc: "wpkh(" + uncompressedPubKey + ")", wantErr: true},
+		{desc: "sh(pkh(" + compressedPubKey + "))", wantErr: true},
+		{desc: "pkh(zz)", wantErr: true},
+		{desc: "addr(notanaddress)", wantErr: true},
+		{desc: "multi(1," + compressedPubKey + ")", wantErr: true},
+		{desc: "raw(51", wantErr: true},
+	}
+
+	for _, test := range tests {
+		scripts, err := scanDescriptorScripts(test.desc, params)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error", test.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.desc, err)
+			continue
+		}
+		if len(scripts) != len(test.scripts) {
+			t.Errorf("%s: unexpected number of scripts - got %d, "+
+				"want %d", test.desc, len(scripts),
+				len(test.scripts))
+			continue
+		}
+		for i, script := range scripts {
+			want, _ := hex.DecodeString(test.scripts[i])
+			if !bytes.Equal(script, want) {
+				t.Errorf("%s #%d: unexpected script - got %x, "+
+					"want %x", test.desc, i, script, want)
+			}
+		}
+	}
+}
+
+// TestCalcBlockStats ensures the getblockstats statistics of a block holding a
+// regular and a tax transaction are calculated as expected, both with and
+// without the previous outputs spent by the block.
+func TestCalcBlockStats(t *testing.T) {
+	t.Parallel()
+
+	params := &chaincfg.MainNetParams
+	dust := int64(params.DustSatoshiAmount)
+
+	// PK1 contains addr: 12hnbu1xVuYF4VhaXYFyw6Pq2eudj3CG4g
+	pk1, _ := hex.DecodeString("76a91412aed4a2fed565f0f473b3688e60246576710f2a88ac")
+	// PK2 contains addr: 1ECRPUBJECFWcB73R6ydUQNJ6Ane8qYPr2
+	pk2, _ := hex.DecodeString("76a91490c2917e7a89f3ec8a1bb82db92661dcab14fcc488ac")
+
+	// Create a block with a coinbase, a regular transaction paying a fee
+	// and a tax transaction which taxes an expired output and sweeps an
+	// expired dust output in its entirety.
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+		SignatureScript:  []byte{0x51, 0x51},
+	})
+	coinbase.AddTxOut(wire.NewTxOut(5000000000, pk1))
+
+	const regularFee = 1000
+	regularTx := wire.NewMsgTx(wire.TxVersion)
+	regularTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{0x01}},
+	})
+	regularTx.AddTxOut(wire.NewTxOut(100000-regularFee, pk2))
+
+	expiredAmount := dust * 10
+	tax := expiredAmount * int64(params.TaxRate) / 100
+	taxTx := wire.NewMsgTx(wire.TxVersion)
+	taxTx.Type = 0x11
+	taxTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{0x02}},
+	})
+	taxTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{0x03}},
+	})
+	taxTx.AddTxOut(wire.NewTxOut(expiredAmount-tax, pk1))
+
+	block := btcutil.NewBlock(&wire.MsgBlock{
+		Transactions: []*wire.MsgTx{coinbase, regularTx, taxTx},
+	})
+	block.SetHeight(1000)
+	stxos := []blockchain.SpentTxOut{
+		{Amount: 100000, PkScript: pk1},
+		{Amount: expiredAmount, PkScript: pk1},
+		{Amount: dust, PkScript: pk2},
+	}
+
+	// Only the tax transactions are requested, so the previous outputs are
+	// not loaded, yet they are still counted.
+	selected := []string{"taxtxs"}
+	if blockStatsNeedPrevOuts(selected) {
+		t.Fatal("taxtxs unexpectedly needs the previous outputs")
+	}
+	stats, err := calcBlockStats(block, nil, false, params)
+	if err != nil {
+		t.Fatalf("calcBlockStats: unexpected error: %v", err)
+	}
+	result, err := selectBlockStats(stats, selected)
+	if err != nil {
+		t.Fatalf("selectBlockStats: unexpected error: %v", err)
+	}
+	got, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("Marshal: unexpected error: %v", err)
+	}
+	if string(got) != `{"taxtxs":1}` {
+		t.Fatalf("unexpected selected statistics %s", got)
+	}
+
+	// Ensure the statistics which need the previous outputs are calculated
+	// when they are loaded.
+	if !blockStatsNeedPrevOuts(nil) {
+		t.Fatal("all statistics unexpectedly don't need the previous " +
+			"outputs")
+	}
+	stats, err = calcBlockStats(block, stxos, true, params)
+	if err != nil {
+		t.Fatalf("calcBlockStats: unexpected error: %v", err)
+	}
+	tests := []struct {
+		name string
+		got  int64
+		want int64
+	}{
+		{"txs", stats.Txs, 3},
+		{"ins", stats.Ins, 3},
+		{"taxtxs", stats.TaxTxs, 1},
+		{"taxswept", stats.TaxSwept, tax + dust},
+		{"taxdustswept", stats.TaxDustSwept, dust},
+		{"totalfee", stats.TotalFee, regularFee},
+		{"maxfee", stats.MaxFee, regularFee},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%s: unexpected value - got %d, want %d",
+				test.name, test.got, test.want)
+		}
+	}
+
+	// Ensure a spend journal with fewer entries than inputs results in an
+	// internal error rather than a panic.  The error is logged, so logging
+	// is disabled since the log rotator isn't initialized by tests.
+	setLogLevel("RPCS", "off")
+	_, err = calcBlockStats(block, stxos[:2], true, params)
+	rpcErr, ok := err.(*btcjson.RPCError)
+	if !ok || rpcErr.Code != btcjson.ErrRPCInternal.Code {
+		t.Fatalf("unexpected error for short spend journal: %v", err)
+	}
+}
+
+// TestSetTxRawPrevOuts ensures the previous outputs are attached to the inputs
+// of a transaction and that regular transactions report their fee while tax
+// transactions report the swept tax along with the taxed amount of each input.
+func TestSetTxRawPrevOuts(t *testing.T) {
+	t.Parallel()
+
+	params := &chaincfg.MainNetParams
+	dust := int64(params.DustSatoshiAmount)
+
+	// PK1 contains addr: 12hnbu1xVuYF4VhaXYFyw6Pq2eudj3CG4g
+	pk1, _ := hex.DecodeString("76a91412aed4a2fed565f0f473b3688e60246576710f2a88ac")
+	// PK2 contains addr: 1ECRPUBJECFWcB73R6ydUQNJ6Ane8qYPr2
+	pk2, _ := hex.DecodeString("76a91490c2917e7a89f3ec8a1bb82db92661dcab14fcc488ac")
+
+	const regularFee = 1000
+	regularTx := wire.NewMsgTx(wire.TxVersion)
+	regularTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{0x01}},
+	})
+	regularTx.AddTxOut(wire.NewTxOut(100000-regularFee, pk2))
+
+	expiredAmount := dust * 10
+	tax := expiredAmount * int64(params.TaxRate) / 100
+	taxTx := wire.NewMsgTx(wire.TxVersion)
+	taxTx.Type = 0x11
+	taxTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{0x02}},
+	})
+	taxTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{0x03}},
+	})
+	taxTx.AddTxOut(wire.NewTxOut(expiredAmount-tax, pk1))
+
+	tests := []struct {
+		name   string
+		tx     *wire.MsgTx
+		stxos  []blockchain.SpentTxOut
+		fee    *float64
+		tax    *float64
+		taxed  []float64
+		values []float64
+	}{{
+		name:   "regular transaction",
+		tx:     regularTx,
+		stxos:  []blockchain.SpentTxOut{{Amount: 100000, PkScript: pk1}},
+		fee:    btcjson.Float64(btcutil.Amount(regularFee).ToBTC()),
+		values: []float64{btcutil.Amount(100000).ToBTC()},
+	}, {
+		name: "tax transaction",
+		tx:   taxTx,
+		stxos: []blockchain.SpentTxOut{
+			{Amount: expiredAmount, PkScript: pk1},
+			{Amount: dust, PkScript: pk2},
+		},
+		tax: btcjson.Float64(btcutil.Amount(tax + dust).ToBTC()),
+		taxed: []float64{
+			btcutil.Amount(tax).ToBTC(),
+			btcutil.Amount(dust).ToBTC(),
+		},
+		values: []float64{
+			btcutil.Amount(expiredAmount).ToBTC(),
+			btcutil.Amount(dust).ToBTC(),
+		},
+	}}
+
+	equalAmount := func(got, want *float64) bool {
+		if got == nil || want == nil {
+			return got == want
+		}
+		return *got == *want
+	}
+	for _, test := range tests {
+		txReply, err := createTxRawResult(params, test.tx,
+			test.tx.TxHash().String(), nil, "", 0, 0)
+		if err != nil {
+			t.Fatalf("%s: createTxRawResult: unexpected error: %v",
+				test.name, err)
+		}
+		err = setTxRawPrevOuts(txReply, btcutil.NewTx(test.tx),
+			test.stxos, params)
+		if err != nil {
+			t.Fatalf("%s: setTxRawPrevOuts: unexpected error: %v",
+				test.name, err)
+		}
+
+		if !equalAmount(txReply.Fee, test.fee) {
+			t.Errorf("%s: unexpected fee -- got %v, want %v",
+				test.name, txReply.Fee, test.fee)
+		}
+		if !equalAmount(txReply.Tax, test.tax) {
+			t.Errorf("%s: unexpected tax -- got %v, want %v",
+				test.name, txReply.Tax, test.tax)
+		}
+		for i, vin := range txReply.Vin {
+			if vin.PrevOut == nil {
+				t.Errorf("%s: input %d has no previous output",
+					test.name, i)
+				continue
+			}
+			if vin.PrevOut.Value != test.values[i] {
+				t.Errorf("%s: input %d: unexpected value -- "+
+					"got %v, want %v", test.name, i,
+					vin.PrevOut.Value, test.values[i])
+			}
+			var wantTaxed *float64
+			if test.taxed != nil {
+				wantTaxed = &test.taxed[i]
+			}
+			if !equalAmount(vin.PrevOut.Taxed, wantTaxed) {
+				t.Errorf("%s: input %d: unexpected taxed amount "+
+					"-- got %v, want %v", test.name, i,
+					vin.PrevOut.Taxed, wantTaxed)
+			}
+		}
+	}
+}
@@ -61,8 +61,10 @@ var helpDescsEnUS = map[string]string{
 	"scriptsig-hex": "Hex-encoded bytes of the script",
 
 	// PrevOut help.
-	"prevout-addresses": "previous output addresses",
-	"prevout-value":     "previous output value",
+	"prevout-addresses":    "previous output addresses",
+	"prevout-value":        "previous output value",
+	"prevout-scriptPubKey": "The public key script of the previous output (only with verbosity 3)",
+	"prevout-taxed":        "The amount taxed from the previous output (tax transactions only)",
 
 	// VinPrevOut help.
 	"vinprevout-coinbase":    "The hex-encoded bytes of the signature script (coinbase txns only)",
@@ -80,6 +82,7 @@ var helpDescsEnUS = map[string]string{
 	"vin-scriptSig":   "The signature script used to redeem the origin transaction as a JSON object (non-coinbase txns only)",
 	"vin-txinwitness": "The witness used to redeem the input encoded as a string array of its items",
 	"vin-sequence":    "The script sequence number",
+	"vin-prevOut":     "Data from the origin transaction output with index vout (only with verbosity 3)",
 
 	// ScriptPubKeyResult help.
 	"scriptpubkeyresult-asm":       "Disassembly of the script",
@@ -115,6 +118,20 @@ var helpDescsEnUS = map[string]string{
 	"decodescript--synopsis": "Returns a JSON object with information about the provided hex-encoded script.",
 	"decodescript-hexscript": "Hex-encoded script",
 
+	// DumpTxOutSetCmd help.
+	"dumptxoutset--synopsis": "Writes a utxo snapshot of the unspent transaction output set as of the best block to a file.\n" +
+		"The snapshot can be used to bootstrap a node with the loadtxoutset option once its hash is pinned in the chain parameters.\n" +
+		"Block processing is paused while the snapshot is written.",
+	"dumptxoutset-path": "The path of the file to write, which is relative to the data directory unless it is absolute and must not exist",
+
+	// DumpTxOutSetResult help.
+	"dumptxoutsetresult-coins_written":    "The number of unspent transaction outputs written",
+	"dumptxoutsetresult-base_hash":        "The hash of the block the utxo set corresponds to",
+	"dumptxoutsetresult-base_height":      "The height of the block the utxo set corresponds to",
+	"dumptxoutsetresult-path":             "The absolute path of the written file",
+	"dumptxoutsetresult-txoutset_hash":    "The hash committing to the utxo set which is pinned in the chain parameters",
+	"dumptxoutsetresult-tax_sweep_height": "The highest height of the outputs swept by the latest tax transactions, or -1 when there are none, which is pinned in the chain parameters",
+
 	// EstimateFeeCmd help.
 	"estimatefee--synopsis": "Estimate the fee per kilobyte in satoshis " +
 		"required for a transaction to be mined before a certain number of " +
@@ -162,10 +179,10 @@ var helpDescsEnUS = map[string]string{
 	// GetBlockCmd help.
 	"getblock--synopsis":   "Returns information about a block given its hash.",
 	"getblock-hash":        "The hash of the block",
-	"getblock-verbose":     "Specifies the block is returned as a JSON object instead of hex-encoded string",
-	"getblock-verbosetx":   "Specifies that each transaction is returned as a JSON object and only applies if the verbose flag is true (btcd extension)",
-	"getblock--condition0": "verbose=false",
-	"getblock--condition1": "verbose=true",
+	"getblock-verbosity":   "0 returns the hex-encoded serialized block, 1 a JSON object with the transaction hashes, 2 each transaction as a JSON object as well and 3 the previous output of each input, the transaction fees and the taxed amounts as well (false and true are accepted for 0 and 1)",
+	"getblock-verbosetx":   "Specifies that each transaction is returned as a JSON object and only applies to verbosity 1 (btcd extension)",
+	"getblock--condition0": "verbosity=0",
+	"getblock--condition1": "verbosity>0",
 	"getblock--result0":    "Hex-encoded bytes of the serialized block",
 
 	// GetBlockChainInfoCmd help.
@@ -187,6 +204,7 @@ var helpDescsEnUS = map[string]string{
 	"getblockchaininforesult-bip9_softforks--key":   "bip9_softforks",
 	"getblockchaininforesult-bip9_softforks--value": "An object describing a particular BIP009 deployment",
 	"getblockchaininforesult-bip9_softforks--desc":  "The status of any defined BIP0009 soft-fork deployments",
+	"getblockchaininforesult-warnings":              "Any current warnings about the synchronization of the chain",
 
 	// SoftForkDescription help.
 	"softforkdescription-reject":  "The current activation status of the softfork",
@@ -208,6 +226,9 @@ var helpDescsEnUS = map[string]string{
 	"txrawresult-size":          "The size of the transaction in bytes",
 	"txrawresult-vsize":         "The virtual size of the transaction in bytes",
 	"txrawresult-hash":          "The wtxid of the transaction",
+	"txrawresult-taxtx":         "Whether or not the transaction is a tax transaction",
+	"txrawresult-fee":           "The fee paid by the transaction (only with getblock verbosity 3, not set for tax transactions)",
+	"txrawresult-tax":           "The amount swept as tax by the tax transaction (only with getblock verbosity 3)",
 
 	// SearchRawTransactionsResult help.
 	"searchrawtransactionsresult-hex":           "Hex-encoded transaction",
@@ -274,6 +295,48 @@ var helpDescsEnUS = map[string]string{
 	"getblockheaderverboseresult-previousblockhash": "The hash of the previous block",
 	"getblockheaderverboseresult-nextblockhash":     "The hash of the next block (only if there is one)",
 
+	// GetBlockStatsCmd help.
+	"getblockstats--synopsis":    "Returns per-block statistics such as fees, sizes and taxation totals for a block in the main chain.",
+	"getblockstats-hashorheight": "The hash or height of the block",
+	"getblockstats-stats":        "The names of the statistics to return (all statistics are returned when omitted)",
+
+	// HashOrHeight help.
+	"hashorheight-hash":   "The hash of the block (provided as a string)",
+	"hashorheight-height": "The height of the block (provided as a number)",
+
+	// GetBlockStatsResult help.
+	"getblockstatsresult-avgfee":              "Average fee in satoshi of the fee-paying transactions",
+	"getblockstatsresult-avgfeerate":          "Average fee rate in satoshi per virtual byte of the fee-paying transactions",
+	"getblockstatsresult-avgtxsize":           "Average size of the non-coinbase transactions",
+	"getblockstatsresult-blockhash":           "The hash of the block",
+	"getblockstatsresult-feerate_percentiles": "The 10th, 25th, 50th, 75th and 90th fee rate percentiles weighted by virtual size in satoshi per virtual byte",
+	"getblockstatsresult-height":              "The height of the block",
+	"getblockstatsresult-ins":                 "The number of inputs excluding the coinbase",
+	"getblockstatsresult-maxfee":              "Maximum fee in satoshi of the fee-paying transactions",
+	"getblockstatsresult-maxfeerate":          "Maximum fee rate in satoshi per virtual byte of the fee-paying transactions",
+	"getblockstatsresult-maxtxsize":           "Maximum size of the non-coinbase transactions",
+	"getblockstatsresult-medianfee":           "Truncated median fee in satoshi of the fee-paying transactions",
+	"getblockstatsresult-mediantxsize":        "Truncated median size of the non-coinbase transactions",
+	"getblockstatsresult-minfee":              "Minimum fee in satoshi of the fee-paying transactions",
+	"getblockstatsresult-minfeerate":          "Minimum fee rate in satoshi per virtual byte of the fee-paying transactions",
+	"getblockstatsresult-mintxsize":           "Minimum size of the non-coinbase transactions",
+	"getblockstatsresult-outs":                "The number of outputs",
+	"getblockstatsresult-subsidy":             "The block subsidy in satoshi",
+	"getblockstatsresult-swtotal_size":        "Total size of the segwit transactions",
+	"getblockstatsresult-swtotal_weight":      "Total weight of the segwit transactions",
+	"getblockstatsresult-swtxs":               "The number of segwit transactions",
+	"getblockstatsresult-time":                "The block time in seconds since 1 Jan 1970 GMT",
+	"getblockstatsresult-total_out":           "Total amount in satoshi of the outputs excluding the coinbase",
+	"getblockstatsresult-total_size":          "Total size of the non-coinbase transactions",
+	"getblockstatsresult-total_weight":        "Total weight of the non-coinbase transactions",
+	"getblockstatsresult-totalfee":            "Total fees in satoshi of the fee-paying transactions",
+	"getblockstatsresult-txs":                 "The number of transactions including the coinbase",
+	"getblockstatsresult-utxo_increase":       "The increase or decrease in the number of unspent outputs",
+	"getblockstatsresult-utxo_size_inc":       "The increase or decrease in the approximate size of the unspent output set",
+	"getblockstatsresult-taxtxs":              "The number of tax transactions",
+	"getblockstatsresult-taxswept":            "Total tax in satoshi swept from expired outputs by the tax transactions",
+	"getblockstatsresult-taxdustswept":        "Total value in satoshi of expired dust outputs swept in their entirety by the tax transactions",
+
 	// TemplateRequest help.
 	"templaterequest-mode":         "This is 'template', 'proposal', or omitted",
 	"templaterequest-capabilities": "List of capabilities",
@@ -321,6 +384,12 @@ var helpDescsEnUS = map[string]string{
 	"getblocktemplateresult-capabilities":               "List of server capabilities including 'proposal' to indicate support for block proposals",
 	"getblocktemplateresult-reject-reason":              "Reason the proposal was invalid as-is (only applies to proposal responses)",
 	"getblocktemplateresult-default_witness_commitment": "The witness commitment itself. Will be populated if the block has witness data",
+	"getblocktemplateresult-signet_challenge":           "The hex-encoded challenge script the block must provide a solution for when running on a signet (BIP0325)",
+	"getblocktemplateresult-rules":                      "The names of the rule change deployments which are active for the block (BIP0009)",
+	"getblocktemplateresult-vbavailable":                "The bits of the rule change deployments the block signals for by deployment name (BIP0009)",
+	"getblocktemplateresult-vbavailable--key":           "name",
+	"getblocktemplateresult-vbavailable--value":         "The bit of the deployment in the block version",
+	"getblocktemplateresult-vbavailable--desc":          "The rule change deployments the block signals for (BIP0009)",
 	"getblocktemplateresult-weightlimit":                "The current limit on the max allowed weight of a block",
 
 	// GetBlockTemplateCmd help.
@@ -440,29 +509,40 @@ var helpDescsEnUS = map[string]string{
 	"getnettotalsresult-totalbytesrecv": "Total bytes received",
 	"getnettotalsresult-totalbytessent": "Total bytes sent",
 	"getnettotalsresult-timemillis":     "Number of milliseconds since 1 Jan 1970 GMT",
+	"getnettotalsresult-uploadtarget":   "The state of the upload target",
+
+	// GetNetTotalsUploadTargetResult help.
+	"getnettotalsuploadtargetresult-timeframe":               "The length of the cycles the upload target applies to in seconds",
+	"getnettotalsuploadtargetresult-target":                  "The upload target in bytes per cycle (0 = no limit)",
+	"getnettotalsuploadtargetresult-target_reached":          "Whether the upload target is reached",
+	"getnettotalsuploadtargetresult-serve_historical_blocks": "Whether blocks older than a week are served to peers without the download permission",
+	"getnettotalsuploadtargetresult-bytes_left_in_cycle":     "The number of bytes left in the current cycle",
+	"getnettotalsuploadtargetresult-time_left_in_cycle":      "The number of seconds left in the current cycle",
 
 	// GetPeerInfoResult help.
-	"getpeerinforesult-id":             "A unique node ID",
-	"getpeerinforesult-addr":           "The ip address and port of the peer",
-	"getpeerinforesult-addrlocal":      "Local address",
-	"getpeerinforesult-services":       "Services bitmask which represents the services supported by the peer",
-	"getpeerinforesult-relaytxes":      "Peer has requested transactions be relayed to it",
-	"getpeerinforesult-lastsend":       "Time the last message was received in seconds since 1 Jan 1970 GMT",
-	"getpeerinforesult-lastrecv":       "Time the last message was sent in seconds since 1 Jan 1970 GMT",
-	"getpeerinforesult-bytessent":      "Total bytes sent",
-	"getpeerinforesult-bytesrecv":      "Total bytes received",
-	"getpeerinforesult-conntime":       "Time the connection was made in seconds since 1 Jan 1970 GMT",
-	"getpeerinforesult-timeoffset":     "The time offset of the peer",
-	"getpeerinforesult-pingtime":       "Number of microseconds the last ping took",
-	"getpeerinforesult-pingwait":       "Number of microseconds a queued ping has been waiting for a response",
-	"getpeerinforesult-version":        "The protocol version of the peer",
-	"getpeerinforesult-subver":         "The user agent of the peer",
-	"getpeerinforesult-inbound":        "Whether or not the peer is an inbound connection",
-	"getpeerinforesult-startingheight": "The latest block height the peer knew about when the connection was established",
-	"getpeerinforesult-currentheight":  "The current height of the peer",
-	"getpeerinforesult-banscore":       "The ban score",
-	"getpeerinforesult-feefilter":      "The requested minimum fee a transaction must have to be announced to the peer",
-	"getpeerinforesult-syncnode":       "Whether or not the peer is the sync peer",
+	"getpeerinforesult-id":              "A unique node ID",
+	"getpeerinforesult-addr":            "The ip address and port of the peer",
+	"getpeerinforesult-addrlocal":       "Local address",
+	"getpeerinforesult-services":        "Services bitmask which represents the services supported by the peer",
+	"getpeerinforesult-relaytxes":       "Peer has requested transactions be relayed to it",
+	"getpeerinforesult-lastsend":        "Time the last message was received in seconds since 1 Jan 1970 GMT",
+	"getpeerinforesult-lastrecv":        "Time the last message was sent in seconds since 1 Jan 1970 GMT",
+	"getpeerinforesult-bytessent":       "Total bytes sent",
+	"getpeerinforesult-bytesrecv":       "Total bytes received",
+	"getpeerinforesult-conntime":        "Time the connection was made in seconds since 1 Jan 1970 GMT",
+	"getpeerinforesult-timeoffset":      "The time offset of the peer",
+	"getpeerinforesult-pingtime":        "Number of microseconds the last ping took",
+	"getpeerinforesult-pingwait":        "Number of microseconds a queued ping has been waiting for a response",
+	"getpeerinforesult-version":         "The protocol version of the peer",
+	"getpeerinforesult-subver":          "The user agent of the peer",
+	"getpeerinforesult-inbound":         "Whether or not the peer is an inbound connection",
+	"getpeerinforesult-connection_type": "The type of the connection (inbound, manual, outbound-full-relay, block-relay-only or feeler)",
+	"getpeerinforesult-permissions":     "The permissions granted to the peer by the whitelist (noban, relay, mempool, download or addr)",
+	"getpeerinforesult-startingheight":  "The latest block height the peer knew about when the connection was established",
+	"getpeerinforesult-currentheight":   "The current height of the peer",
+	"getpeerinforesult-banscore":        "The ban score",
+	"getpeerinforesult-feefilter":       "The requested minimum fee a transaction must have to be announced to the peer",
+	"getpeerinforesult-syncnode":        "Whether or not the peer is the sync peer",
 
 	// GetPeerInfoCmd help.
 	"getpeerinfo--synopsis": "Returns data about each connected network peer as an array of json objects.",
@@ -518,6 +598,38 @@ var helpDescsEnUS = map[string]string{
 	"ping--synopsis": "Queues a ping to be sent to each connected peer.\n" +
 		"Ping times are provided by getpeerinfo via the pingtime and pingwait fields.",
 
+	// ScanTxOutSetCmd help.
+	"scantxoutset--synopsis": "Scans the unspent transaction output set for outputs matching the passed output descriptors.\n" +
+		"The start action scans the set and returns the matching outputs, the status action returns the progress of the scan in progress and the abort action aborts it.\n" +
+		"Supported descriptors are addr(ADDRESS), raw(HEX), pk(PUBKEY), pkh(PUBKEY), wpkh(PUBKEY), sh(wpkh(PUBKEY)) and combo(PUBKEY).",
+	"scantxoutset-action":      "The action to perform: start, abort or status",
+	"scantxoutset-scanobjects": "The output descriptors to match (required for the start action)",
+	"scantxoutset--condition0": "action=start",
+	"scantxoutset--condition1": "action=status",
+	"scantxoutset--condition2": "action=abort",
+	"scantxoutset--result2":    "Whether or not a scan in progress was aborted",
+
+	// ScanTxOutSetResult help.
+	"scantxoutsetresult-success":      "Whether or not the scan completed without being aborted",
+	"scantxoutsetresult-txouts":       "The number of unspent transaction outputs scanned",
+	"scantxoutsetresult-height":       "The height of the best block the scanned utxo set corresponds to",
+	"scantxoutsetresult-bestblock":    "The hash of the best block the scanned utxo set corresponds to",
+	"scantxoutsetresult-unspents":     "The matching unspent transaction outputs",
+	"scantxoutsetresult-total_amount": "The total amount of all matching unspent transaction outputs",
+
+	// ScanTxOutSetUnspent help.
+	"scantxoutsetunspent-txid":         "The hash of the transaction containing the output",
+	"scantxoutsetunspent-vout":         "The index of the output",
+	"scantxoutsetunspent-scriptPubKey": "The hex-encoded public key script of the output",
+	"scantxoutsetunspent-desc":         "The scan object the output matched",
+	"scantxoutsetunspent-amount":       "The value of the output",
+	"scantxoutsetunspent-height":       "The height of the block containing the output",
+	"scantxoutsetunspent-coinbase":     "Whether or not the output is from a coinbase transaction",
+	"scantxoutsetunspent-expired":      "Whether or not the output has expired and may be taxed",
+
+	// ScanTxOutSetStatusResult help.
+	"scantxoutsetstatusresult-progress": "The approximate progress of the scan in percent",
+
 	// SearchRawTransactionsCmd help.
 	"searchrawtransactions--synopsis": "Returns raw data for transactions involving the passed address.\n" +
 		"Returned transactions are pulled from both the database, and transactions currently in the mempool.\n" +
@@ -679,6 +791,7 @@ var rpcResultTypes = map[string][]interface{}{
 	"debuglevel":            {(*string)(nil), (*string)(nil)},
 	"decoderawtransaction":  {(*btcjson.TxRawDecodeResult)(nil)},
 	"decodescript":          {(*btcjson.DecodeScriptResult)(nil)},
+	"dumptxoutset":          {(*btcjson.DumpTxOutSetResult)(nil)},
 	"estimatefee":           {(*float64)(nil)},
 	"generate":              {(*[]string)(nil)},
 	"getaddednodeinfo":      {(*[]string)(nil), (*[]btcjson.GetAddedNodeInfoResult)(nil)},
@@ -688,6 +801,7 @@ var rpcResultTypes = map[string][]interface{}{
 	"getblockcount":         {(*int64)(nil)},
 	"getblockhash":          {(*string)(nil)},
 	"getblockheader":        {(*string)(nil), (*btcjson.GetBlockHeaderVerboseResult)(nil)},
+	"getblockstats":         {(*btcjson.GetBlockStatsResult)(nil)},
 	"getblocktemplate":      {(*btcjson.GetBlockTemplateResult)(nil), (*string)(nil), nil},
 	"getblockchaininfo":     {(*btcjson.GetBlockChainInfoResult)(nil)},
 	"getcfilter":            {(*string)(nil)},
@@ -710,6 +824,7 @@ var rpcResultTypes = map[string][]interface{}{
 	"node":                  nil,
 	"help":                  {(*string)(nil), (*string)(nil)},
 	"ping":                  nil,
+	"scantxoutset":          {(*btcjson.ScanTxOutSetResult)(nil), (*btcjson.ScanTxOutSetStatusResult)(nil), (*bool)(nil)},
 	"searchrawtransactions": {(*string)(nil), (*[]btcjson.SearchRawTransactionsResult)(nil)},
 	"sendrawtransaction":    {(*string)(nil)},
 	"setgenerate":           nil,
@@ -22,7 +22,10 @@ import (
 
 	"github.com/btcsuite/websocket"
 	"github.com/organicbitcoin/btcutil"
+	"github.com/organicbitcoin/btcutil/gcs"
+	"github.com/organicbitcoin/btcutil/gcs/builder"
 	"github.com/organicbitcoin/obtcd/blockchain"
+	"github.com/organicbitcoin/obtcd/blockchain/indexers"
 	"github.com/organicbitcoin/obtcd/btcjson"
 	"github.com/organicbitcoin/obtcd/chaincfg"
 	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
@@ -436,6 +439,58 @@ func (f *wsClientFilter) existsUnspentOutPoint(op *wire.OutPoint) bool {
 	return ok
 }
 
+// watched returns the addresses and unspent outputs watched by the
+// wsClientFilter.  False is returned when an address can't be reconstructed.
+//
+// This function MUST be called with the filter lock held.
+func (f *wsClientFilter) watched(params *chaincfg.Params) ([]btcutil.Address, []wire.OutPoint, bool) {
+	numAddrs := len(f.pubKeyHashes) + len(f.scriptHashes) +
+		len(f.compressedPubKeys) + len(f.uncompressedPubKeys) +
+		len(f.otherAddresses)
+	addrs := make([]btcutil.Address, 0, numAddrs)
+	for hash := range f.pubKeyHashes {
+		addr, err := btcutil.NewAddressPubKeyHash(hash[:], params)
+		if err != nil {
+			return nil, nil, false
+		}
+		addrs = append(addrs, addr)
+	}
+	for hash := range f.scriptHashes {
+		addr, err := btcutil.NewAddressScriptHashFromHash(hash[:], params)
+		if err != nil {
+			return nil, nil, false
+		}
+		addrs = append(addrs, addr)
+	}
+	for pubKey := range f.compressedPubKeys {
+		addr, err := btcutil.NewAddressPubKey(pubKey[:], params)
+		if err != nil {
+			return nil, nil, false
+		}
+		addrs = append(addrs, addr)
+	}
+	for pubKey := range f.uncompressedPubKeys {
+		addr, err := btcutil.NewAddressPubKey(pubKey[:], params)
+		if err != nil {
+			return nil, nil, false
+		}
+		addrs = append(addrs, addr)
+	}
+	for encodedAddr := range f.otherAddresses {
+		addr, err := btcutil.DecodeAddress(encodedAddr, params)
+		if err != nil {
+			return nil, nil, false
+		}
+		addrs = append(addrs, addr)
+	}
+
+	unspent := make([]wire.OutPoint, 0, len(f.unspent))
+	for op := range f.unspent {
+		unspent = append(unspent, op)
+	}
+	return addrs, unspent, true
+}
+
 // removeUnspentOutPoint removes the passed outpoint, if it exists, from the
 // wsClientFilter.
 //
@@ -1993,6 +2048,110 @@ func (r *rescanKeys) unspentSlice() []*wire.OutPoint {
 	return ops
 }
 
+// rescanFilterMatcher tests the committed basic filters stored by the CF index
+// against the scripts watched by a rescan in order to determine which blocks
+// may contain relevant transactions, so blocks that cannot are skipped without
+// being loaded.  Every block must be loaded when filters are not in use, which
+// is the case when the CF index is disabled or the watched scripts can't be
+// fully determined.
+type rescanFilterMatcher struct {
+	cfIndex *indexers.CfIndex
+	scripts [][]byte
+
+	// filterHits is the number of blocks whose filter matched the watched
+	// scripts and blocksFetched is the number of blocks loaded, which also
+	// includes blocks loaded because their filter is not available.
+	filterHits    uint32
+	blocksFetched uint32
+}
+
+// newRescanFilterMatcher returns a matcher for the scripts paying to the
+// passed addresses and the scripts of the passed unspent outputs.  Filters are
+// not used when the CF index is nil, when any of the addresses is a public key
+// since outputs such as bare multisig paying to it can't be derived, or when
+// the script of an unspent output can't be found in the utxo set.
+func newRescanFilterMatcher(cfIndex *indexers.CfIndex, chain *blockchain.BlockChain,
+	addrs []btcutil.Address, unspent []wire.OutPoint) *rescanFilterMatcher {
+
+	m := &rescanFilterMatcher{}
+	if cfIndex == nil {
+		return m
+	}
+
+	scripts := make([][]byte, 0, len(addrs)+len(unspent))
+	for _, addr := range addrs {
+		if _, ok := addr.(*btcutil.AddressPubKey); ok {
+			rpcsLog.Debugf("Not using filters for rescan of public " +
+				"key address")
+			return m
+		}
+		script, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			rpcsLog.Debugf("Not using filters for rescan of "+
+				"address %v: %v", addr, err)
+			return m
+		}
+		scripts = append(scripts, script)
+	}
+	for _, outpoint := range unspent {
+		entry, err := chain.FetchUtxoEntry(outpoint)
+		if err != nil || entry == nil {
+			rpcsLog.Debugf("Not using filters for rescan of unknown "+
+				"output %v", outpoint)
+			return m
+		}
+		scripts = append(scripts, entry.PkScript)
+	}
+
+	m.cfIndex = cfIndex
+	m.scripts = scripts
+	return m
+}
+
+// enabled returns whether or not the matcher uses filters to skip blocks.
+func (m *rescanFilterMatcher) enabled() bool {
+	return m.cfIndex != nil
+}
+
+// matchBlock returns whether or not the block with the passed hash may contain
+// transactions relevant to the watched scripts and therefore must be loaded.
+// It returns true when filters are not in use or the filter for the block is
+// not available.
+func (m *rescanFilterMatcher) matchBlock(hash *chainhash.Hash) bool {
+	if !m.enabled() {
+		return true
+	}
+
+	filterBytes, err := m.cfIndex.FilterByBlockHash(hash,
+		wire.GCSFilterRegular)
+	if err != nil || len(filterBytes) == 0 {
+		return true
+	}
+	filter, err := gcs.FromNBytes(builder.DefaultP, builder.DefaultM,
+		filterBytes)
+	if err != nil {
+		return true
+	}
+	matched, err := filter.MatchAny(builder.DeriveKey(hash), m.scripts)
+	if err != nil {
+		return true
+	}
+	if matched {
+		m.filterHits++
+	}
+	return matched
+}
+
+// progressStats returns the filter hits and blocks fetched to report in
+// rescan progress notifications, which are nil when filters are not in use.
+func (m *rescanFilterMatcher) progressStats() (*uint32, *uint32) {
+	if !m.enabled() {
+		return nil, nil
+	}
+	filterHits, blocksFetched := m.filterHits, m.blocksFetched
+	return &filterHits, &blocksFetched
+}
+
 // ErrRescanReorg defines the error that is returned when an unrecoverable
 // reorganize is detected during a rescan.
 var ErrRescanReorg = btcjson.RPCError{
@@ -2239,12 +2398,46 @@ func handleRescanBlocks(wsc *wsClient, icmd interface{}) (interface{}, error) {
 
 	discoveredData := make([]btcjson.RescannedBlock, 0, len(blockHashes))
 
-	// Iterate over each block in the request and rescan.  When a block
-	// contains relevant transactions, add it to the response.
+	// Use the committed filters to skip blocks that can't contain relevant
+	// transactions when possible.  Note that outputs paying to a bare
+	// public key are only found in blocks that are loaded for other
+	// reasons unless the public key itself is watched.
 	bc := wsc.server.cfg.Chain
 	params := wsc.server.cfg.ChainParams
+	filter.mu.Lock()
+	addrs, unspent, ok := filter.watched(params)
+	filter.mu.Unlock()
+	cfIndex := wsc.server.cfg.CfIndex
+	if !ok {
+		cfIndex = nil
+	}
+	cfMatcher := newRescanFilterMatcher(cfIndex, bc, addrs, unspent)
+
+	// Iterate over each block in the request and rescan.  When a block
+	// contains relevant transactions, add it to the response.
 	var lastBlockHash *chainhash.Hash
 	for i := range blockHashes {
+		if bc.MainChainHasBlock(blockHashes[i]) &&
+			!cfMatcher.matchBlock(blockHashes[i]) {
+
+			header, err := bc.HeaderByHash(blockHashes[i])
+			if err != nil {
+				return nil, &btcjson.RPCError{
+					Code:    btcjson.ErrRPCBlockNotFound,
+					Message: "Failed to fetch block: " + err.Error(),
+				}
+			}
+			if lastBlockHash != nil && header.PrevBlock != *lastBlockHash {
+				return nil, &btcjson.RPCError{
+					Code: btcjson.ErrRPCInvalidParameter,
+					Message: fmt.Sprintf("Block %v is not a child of %v",
+						blockHashes[i], lastBlockHash),
+				}
+			}
+			lastBlockHash = blockHashes[i]
+			continue
+		}
+
 		block, err := bc.BlockByHash(blockHashes[i])
 		if err != nil {
 			return nil, &btcjson.RPCError{
@@ -2252,6 +2445,7 @@ func handleRescanBlocks(wsc *wsClient, icmd interface{}) (interface{}, error) {
 				Message: "Failed to fetch block: " + err.Error(),
 			}
 		}
+		cfMatcher.blocksFetched++
 		if lastBlockHash != nil && block.MsgBlock().Header.PrevBlock != *lastBlockHash {
 			return nil, &btcjson.RPCError{
 				Code: btcjson.ErrRPCInvalidParameter,
@@ -2270,6 +2464,12 @@ func handleRescanBlocks(wsc *wsClient, icmd interface{}) (interface{}, error) {
 		}
 	}
 
+	if cfMatcher.enabled() {
+		rpcsLog.Debugf("Rescanned %d blocks using filters (%d filter "+
+			"hits, %d blocks fetched)", len(blockHashes),
+			cfMatcher.filterHits, cfMatcher.blocksFetched)
+	}
+
 	return &discoveredData, nil
 }
 
@@ -2302,7 +2502,7 @@ func recoverFromReorg(chain *blockchain.BlockChain, minBlock, maxBlock int32,
 			Message: "Database error: " + err.Error(),
 		}
 	}
-	jsonErr := descendantBlock(lastBlock, blk)
+	jsonErr := descendantBlock(lastBlock, &blk.MsgBlock().Header)
 	if jsonErr != nil {
 		return nil, jsonErr
 	}
@@ -2311,8 +2511,8 @@ func recoverFromReorg(chain *blockchain.BlockChain, minBlock, maxBlock int32,
 
 // descendantBlock returns the appropriate JSON-RPC error if a current block
 // fetched during a reorganize is not a direct child of the parent block hash.
-func descendantBlock(prevHash *chainhash.Hash, curBlock *btcutil.Block) error {
-	curHash := &curBlock.MsgBlock().Header.PrevBlock
+func descendantBlock(prevHash *chainhash.Hash, curHeader *wire.BlockHeader) error {
+	curHash := &curHeader.PrevBlock
 	if !prevHash.IsEqual(curHash) {
 		rpcsLog.Errorf("Stopping rescan for reorged block %v "+
 			"(replaced by block %v)", prevHash, curHash)
@@ -2369,6 +2569,27 @@ func handleRescan(wsc *wsClient, icmd interface{}) (interface{}, error) {
 
 	chain := wsc.server.cfg.Chain
 
+	// Use the committed filters to skip blocks that can't contain relevant
+	// transactions when possible.  This requires the scripts of all watched
+	// addresses and outputs to be known.
+	cfIndex := wsc.server.cfg.CfIndex
+	addrs := make([]btcutil.Address, 0, len(cmd.Addresses))
+	for _, addrStr := range cmd.Addresses {
+		addr, err := btcutil.DecodeAddress(addrStr,
+			wsc.server.cfg.ChainParams)
+		if err != nil {
+			cfIndex = nil
+			break
+		}
+		addrs = append(addrs, addr)
+	}
+	watchedOutPoints := make([]wire.OutPoint, 0, len(outpoints))
+	for _, outpoint := range outpoints {
+		watchedOutPoints = append(watchedOutPoints, *outpoint)
+	}
+	cfMatcher := newRescanFilterMatcher(cfIndex, chain, addrs,
+		watchedOutPoints)
+
 	minBlockHash, err := chainhash.NewHashFromStr(cmd.BeginBlock)
 	if err != nil {
 		return nil, rpcDecodeHexError(cmd.BeginBlock)
@@ -2396,10 +2617,12 @@ func handleRescan(wsc *wsClient, icmd interface{}) (interface{}, error) {
 		}
 	}
 
-	// lastBlock and lastBlockHash track the previously-rescanned block.
-	// They equal nil when no previous blocks have been rescanned.
-	var lastBlock *btcutil.Block
+	// lastBlockHash, lastBlockHeight and lastBlockTime track the
+	// previously-rescanned block.  The hash equals nil when no previous
+	// blocks have been rescanned.
 	var lastBlockHash *chainhash.Hash
+	var lastBlockHeight int32
+	var lastBlockTime int64
 
 	// A ticker is created to wait at least 10 seconds before notifying the
 	// websocket client of the current progress completed by the rescan.
@@ -2475,72 +2698,118 @@ fetchRange:
 
 	loopHashList:
 		for i := range hashList {
-			blk, err := chain.BlockByHash(&hashList[i])
-			if err != nil {
-				// Only handle reorgs if a block could not be
-				// found for the hash.
-				if dbErr, ok := err.(database.Error); !ok ||
-					dbErr.ErrorCode != database.ErrBlockNotFound {
-
-					rpcsLog.Errorf("Error looking up "+
-						"block: %v", err)
+			// Only the header is needed for blocks whose filter does
+			// not match any of the watched scripts.  Blocks that are
+			// no longer in the main chain are always loaded below so
+			// the reorg is handled.
+			if chain.MainChainHasBlock(&hashList[i]) &&
+				!cfMatcher.matchBlock(&hashList[i]) {
+
+				header, err := chain.HeaderByHash(&hashList[i])
+				if err != nil {
+					rpcsLog.Errorf("Error looking up block "+
+						"header: %v", err)
 					return nil, &btcjson.RPCError{
 						Code: btcjson.ErrRPCDatabase,
 						Message: "Database error: " +
 							err.Error(),
 					}
 				}
-
-				// If an absolute max block was specified, don't
-				// attempt to handle the reorg.
-				if maxBlock != math.MaxInt32 {
-					rpcsLog.Errorf("Stopping rescan for "+
-						"reorged block %v",
-						cmd.EndBlock)
-					return nil, &ErrRescanReorg
+				if i == 0 && lastBlockHash != nil {
+					// Ensure the new hashList is on the same
+					// fork as the last block from the old
+					// hashList.
+					jsonErr := descendantBlock(lastBlockHash,
+						&header)
+					if jsonErr != nil {
+						return nil, jsonErr
+					}
 				}
 
-				// If the lookup for the previously valid block
-				// hash failed, there may have been a reorg.
-				// Fetch a new range of block hashes and verify
-				// that the previously processed block (if there
-				// was any) still exists in the database.  If it
-				// doesn't, we error.
-				//
-				// A goto is used to branch executation back to
-				// before the range was evaluated, as it must be
-				// reevaluated for the new hashList.
-				minBlock += int32(i)
-				hashList, err = recoverFromReorg(chain,
-					minBlock, maxBlock, lastBlockHash)
-				if err != nil {
-					return nil, err
+				// Stop the rescan if the client requesting it
+				// has disconnected.
+				select {
+				case <-wsc.quit:
+					rpcsLog.Debugf("Stopped rescan at height "+
+						"%v for disconnected client",
+						minBlock+int32(i))
+					return nil, nil
+				default:
 				}
-				if len(hashList) == 0 {
-					break fetchRange
+				lastBlockHash = &hashList[i]
+				lastBlockHeight = minBlock + int32(i)
+				lastBlockTime = header.Timestamp.Unix()
+			} else {
+				blk, err := chain.BlockByHash(&hashList[i])
+				if err != nil {
+					// Only handle reorgs if a block could not be
+					// found for the hash.
+					if dbErr, ok := err.(database.Error); !ok ||
+						dbErr.ErrorCode != database.ErrBlockNotFound {
+
+						rpcsLog.Errorf("Error looking up "+
+							"block: %v", err)
+						return nil, &btcjson.RPCError{
+							Code: btcjson.ErrRPCDatabase,
+							Message: "Database error: " +
+								err.Error(),
+						}
+					}
+
+					// If an absolute max block was specified, don't
+					// attempt to handle the reorg.
+					if maxBlock != math.MaxInt32 {
+						rpcsLog.Errorf("Stopping rescan for "+
+							"reorged block %v",
+							cmd.EndBlock)
+						return nil, &ErrRescanReorg
+					}
+
+					// If the lookup for the previously valid block
+					// hash failed, there may have been a reorg.
+					// Fetch a new range of block hashes and verify
+					// that the previously processed block (if there
+					// was any) still exists in the database.  If it
+					// doesn't, we error.
+					//
+					// A goto is used to branch executation back to
+					// before the range was evaluated, as it must be
+					// reevaluated for the new hashList.
+					minBlock += int32(i)
+					hashList, err = recoverFromReorg(chain,
+						minBlock, maxBlock, lastBlockHash)
+					if err != nil {
+						return nil, err
+					}
+					if len(hashList) == 0 {
+						break fetchRange
+					}
+					goto loopHashList
 				}
-				goto loopHashList
-			}
-			if i == 0 && lastBlockHash != nil {
-				// Ensure the new hashList is on the same fork
-				// as the last block from the old hashList.
-				jsonErr := descendantBlock(lastBlockHash, blk)
-				if jsonErr != nil {
-					return nil, jsonErr
+				cfMatcher.blocksFetched++
+				if i == 0 && lastBlockHash != nil {
+					// Ensure the new hashList is on the same fork
+					// as the last block from the old hashList.
+					jsonErr := descendantBlock(lastBlockHash,
+						&blk.MsgBlock().Header)
+					if jsonErr != nil {
+						return nil, jsonErr
+					}
 				}
-			}
 
-			// A select statement is used to stop rescans if the
-			// client requesting the rescan has disconnected.
-			select {
-			case <-wsc.quit:
-				rpcsLog.Debugf("Stopped rescan at height %v "+
-					"for disconnected client", blk.Height())
-				return nil, nil
-			default:
-				rescanBlock(wsc, &lookups, blk)
-				lastBlock = blk
-				lastBlockHash = blk.Hash()
+				// A select statement is used to stop rescans if the
+				// client requesting the rescan has disconnected.
+				select {
+				case <-wsc.quit:
+					rpcsLog.Debugf("Stopped rescan at height %v "+
+						"for disconnected client", blk.Height())
+					return nil, nil
+				default:
+					rescanBlock(wsc, &lookups, blk)
+					lastBlockHash = blk.Hash()
+					lastBlockHeight = blk.Height()
+					lastBlockTime = blk.MsgBlock().Header.Timestamp.Unix()
+				}
 			}
 
 			// Periodically notify the client of the progress
@@ -2552,8 +2821,10 @@ fetchRange:
 				continue
 			}
 
+			filterHits, blocksFetched := cfMatcher.progressStats()
 			n := btcjson.NewRescanProgressNtfn(hashList[i].String(),
-				blk.Height(), blk.MsgBlock().Header.Timestamp.Unix())
+				lastBlockHeight, lastBlockTime, filterHits,
+				blocksFetched)
 			mn, err := btcjson.MarshalCmd(nil, n)
 			if err != nil {
 				rpcsLog.Errorf("Failed to marshal rescan "+
@@ -2564,7 +2835,7 @@ fetchRange:
 			if err = wsc.QueueNotification(mn); err == ErrClientQuit {
 				// Finished if the client disconnected.
 				rpcsLog.Debugf("Stopped rescan at height %v "+
-					"for disconnected client", blk.Height())
+					"for disconnected client", lastBlockHeight)
 				return nil, nil
 			}
 		}
@@ -2580,8 +2851,7 @@ fetchRange:
 	// is needed to safely inform clients that all rescan notifications have
 	// been sent.
 	n := btcjson.NewRescanFinishedNtfn(lastBlockHash.String(),
-		lastBlock.Height(),
-		lastBlock.MsgBlock().Header.Timestamp.Unix())
+		lastBlockHeight, lastBlockTime)
 	if mn, err := btcjson.MarshalCmd(nil, n); err != nil {
 		rpcsLog.Errorf("Failed to marshal rescan finished "+
 			"notification: %v", err)
@@ -2591,7 +2861,13 @@ fetchRange:
 		_ = wsc.QueueNotification(mn)
 	}
 
-	rpcsLog.Info("Finished rescan")
+	if cfMatcher.enabled() {
+		rpcsLog.Infof("Finished rescan using filters (%d filter hits, "+
+			"%d blocks fetched)", cfMatcher.filterHits,
+			cfMatcher.blocksFetched)
+	} else {
+		rpcsLog.Info("Finished rescan")
+	}
 	return nil, nil
 }
 
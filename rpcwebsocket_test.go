@@ -0,0 +1,164 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/organicbitcoin/btcutil"
+	"github.com/organicbitcoin/obtcd/blockchain/indexers"
+	"github.com/organicbitcoin/obtcd/chaincfg"
+	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
+	"github.com/organicbitcoin/obtcd/database"
+	_ "github.com/organicbitcoin/obtcd/database/ffldb"
+	"github.com/organicbitcoin/obtcd/txscript"
+	"github.com/organicbitcoin/obtcd/wire"
+)
+
+// TestRescanFilterMatcher ensures the rescan filter matcher only reports
+// blocks whose committed filter matches the watched scripts and falls back to
+// loading every block when filters can't be used.
+func TestRescanFilterMatcher(t *testing.T) {
+	t.Parallel()
+
+	params := &chaincfg.RegressionNetParams
+	dbPath, err := ioutil.TempDir("", "rescanfiltermatcher")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dbPath)
+	db, err := database.Create("ffldb", dbPath, params.Net)
+	if err != nil {
+		t.Fatalf("unable to create db: %v", err)
+	}
+	defer db.Close()
+
+	// Create a block with an output paying to a watched address and store
+	// its filter in the CF index.
+	watchedAddr, err := btcutil.NewAddressPubKeyHash(make([]byte, 20),
+		params)
+	if err != nil {
+		t.Fatalf("unable to create address: %v", err)
+	}
+	otherAddr, err := btcutil.NewAddressScriptHashFromHash(
+		make([]byte, 20), params)
+	if err != nil {
+		t.Fatalf("unable to create address: %v", err)
+	}
+	watchedScript, err := txscript.PayToAddrScript(watchedAddr)
+	if err != nil {
+		t.Fatalf("unable to create script: %v", err)
+	}
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: wire.MaxPrevOutIndex},
+		nil, nil))
+	tx.AddTxOut(wire.NewTxOut(5000, watchedScript))
+	header := params.GenesisBlock.Header
+	header.PrevBlock = *params.GenesisHash
+	msgBlock := wire.NewMsgBlock(&header)
+	msgBlock.AddTransaction(tx)
+	block := btcutil.NewBlock(msgBlock)
+
+	cfIndex := indexers.NewCfIndex(db, params)
+	err = db.Update(func(dbTx database.Tx) error {
+		if err := cfIndex.Create(dbTx); err != nil {
+			return err
+		}
+		genesis := btcutil.NewBlock(params.GenesisBlock)
+		if err := cfIndex.ConnectBlock(dbTx, genesis, nil); err != nil {
+			return err
+		}
+		return cfIndex.ConnectBlock(dbTx, block, nil)
+	})
+	if err != nil {
+		t.Fatalf("unable to index block: %v", err)
+	}
+
+	blockHash := block.Hash()
+	unknownHash := &chainhash.Hash{0x01}
+
+	tests := []struct {
+		name       string
+		cfIndex    *indexers.CfIndex
+		addrs      []btcutil.Address
+		enabled    bool
+		matchBlock bool
+	}{
+		{
+			name:       "watched address",
+			cfIndex:    cfIndex,
+			addrs:      []btcutil.Address{otherAddr, watchedAddr},
+			enabled:    true,
+			matchBlock: true,
+		},
+		{
+			name:       "unrelated address",
+			cfIndex:    cfIndex,
+			addrs:      []btcutil.Address{otherAddr},
+			enabled:    true,
+			matchBlock: false,
+		},
+		{
+			name:       "no cf index",
+			cfIndex:    nil,
+			addrs:      []btcutil.Address{otherAddr},
+			enabled:    false,
+			matchBlock: true,
+		},
+	}
+
+	for _, test := range tests {
+		m := newRescanFilterMatcher(test.cfIndex, nil, test.addrs, nil)
+		if m.enabled() != test.enabled {
+			t.Errorf("%s: unexpected enabled state - got %v, want %v",
+				test.name, m.enabled(), test.enabled)
+			continue
+		}
+		if got := m.matchBlock(blockHash); got != test.matchBlock {
+			t.Errorf("%s: unexpected match - got %v, want %v",
+				test.name, got, test.matchBlock)
+		}
+
+		// Blocks without a stored filter must always be loaded.
+		if !m.matchBlock(unknownHash) {
+			t.Errorf("%s: block without filter not matched",
+				test.name)
+		}
+
+		filterHits, blocksFetched := m.progressStats()
+		if !test.enabled {
+			if filterHits != nil || blocksFetched != nil {
+				t.Errorf("%s: unexpected progress stats",
+					test.name)
+			}
+			continue
+		}
+		wantHits := uint32(0)
+		if test.matchBlock {
+			wantHits = 1
+		}
+		if filterHits == nil || *filterHits != wantHits {
+			t.Errorf("%s: unexpected filter hits - got %v, want %d",
+				test.name, filterHits, wantHits)
+		}
+	}
+
+	// Filters can't be used when a public key is watched since outputs
+	// such as bare multisig paying to it can't be derived.
+	serializedPubKey, _ := hex.DecodeString("0279be667ef9dcbbac55a06295ce8" +
+		"70b07029bfcdb2dce28d959f2815b16f81798")
+	pubKey, err := btcutil.NewAddressPubKey(serializedPubKey, params)
+	if err != nil {
+		t.Fatalf("unable to create public key address: %v", err)
+	}
+	m := newRescanFilterMatcher(cfIndex, nil, []btcutil.Address{pubKey},
+		nil)
+	if m.enabled() {
+		t.Errorf("filters used for public key address")
+	}
+}
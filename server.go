@@ -22,6 +22,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/aead/siphash"
 	"github.com/organicbitcoin/btcutil"
 	"github.com/organicbitcoin/btcutil/bloom"
 	"github.com/organicbitcoin/obtcd/addrmgr"
@@ -53,10 +54,52 @@ const (
 	// defaultTargetOutbound is the default number of outbound peers to target.
 	defaultTargetOutbound = 8
 
+	// defaultTargetBlockRelay is the default number of block-relay-only
+	// outbound peers to target in addition to defaultTargetOutbound.
+	defaultTargetBlockRelay = 2
+
+	// feelerInterval is the interval at which feeler connections are made
+	// to test whether addresses that have not been connected to yet are
+	// reachable.
+	feelerInterval = time.Minute * 2
+
 	// connectionRetryInterval is the base amount of time to wait in between
 	// retries when connecting to persistent peers.  It is adjusted by the
 	// number of retries such that there is a retry backoff.
 	connectionRetryInterval = time.Second * 5
+
+	// maxCmpctBlockDepth is the maximum depth of a block below the best
+	// chain tip which is served as a compact block when requested.  Older
+	// blocks are served in full since the peer is unlikely to have their
+	// transactions.
+	maxCmpctBlockDepth = 10
+
+	// relayCacheExpiry is how long transactions which were force relayed
+	// without being accepted to the mempool are served to peers which
+	// request them.
+	relayCacheExpiry = time.Minute * 15
+
+	// maxBlockTxnDepth is the maximum depth of a block below the best
+	// chain tip for which transactions are served in response to a
+	// getblocktxn message.  The full block is served for older blocks.
+	maxBlockTxnDepth = 15
+
+	// staleTipCheckInterval is the interval at which the best chain tip is
+	// checked for staleness.
+	staleTipCheckInterval = time.Minute * 10
+
+	// staleTipBlocks is the number of target block intervals without a new
+	// best chain tip after which the tip is considered stale.
+	staleTipBlocks = 3
+
+	// v1OnlyAddrExpiry is how long outbound connections to an address which
+	// failed the v2 transport handshake use the v1 transport before the v2
+	// transport is tried again.
+	v1OnlyAddrExpiry = time.Hour * 24
+
+	// maxV1OnlyAddrs is the maximum number of addresses which are
+	// remembered to fall back to the v1 transport.
+	maxV1OnlyAddrs = 1000
 )
 
 var (
@@ -186,6 +229,74 @@ func (ps *peerState) forAllPeers(closure func(sp *serverPeer)) {
 	ps.forAllOutboundPeers(closure)
 }
 
+// anchorAddrs returns the addresses of the connected block-relay-only peers.
+func (ps *peerState) anchorAddrs() []string {
+	var addrs []string
+	for _, sp := range ps.outboundPeers {
+		if sp.isBlockRelayOnly() && sp.VersionKnown() {
+			addrs = append(addrs, sp.connReq.Addr.String())
+		}
+	}
+	return addrs
+}
+
+// v1OnlyAddrSet is a bounded set of addresses which failed the v2 transport
+// handshake.  Each address expires after v1OnlyAddrExpiry and the address
+// which expires first is evicted when the set is full.  It is safe for
+// concurrent access.
+type v1OnlyAddrSet struct {
+	mtx     sync.Mutex
+	expires map[string]time.Time
+	limit   int
+}
+
+// newV1OnlyAddrSet returns an empty set holding at most limit addresses.
+func newV1OnlyAddrSet(limit int) *v1OnlyAddrSet {
+	return &v1OnlyAddrSet{
+		expires: make(map[string]time.Time),
+		limit:   limit,
+	}
+}
+
+// add inserts the passed address into the set, or refreshes its expiry when
+// it is already present.
+func (s *v1OnlyAddrSet) add(addr string, now time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var oldest string
+	for a, expires := range s.expires {
+		if !now.Before(expires) {
+			delete(s.expires, a)
+			continue
+		}
+		if oldest == "" || expires.Before(s.expires[oldest]) {
+			oldest = a
+		}
+	}
+	if _, ok := s.expires[addr]; !ok && len(s.expires) >= s.limit {
+		delete(s.expires, oldest)
+	}
+	s.expires[addr] = now.Add(v1OnlyAddrExpiry)
+}
+
+// contains returns whether the passed address is in the set and has not
+// expired.
+func (s *v1OnlyAddrSet) contains(addr string, now time.Time) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	expires, ok := s.expires[addr]
+	return ok && now.Before(expires)
+}
+
+// relayCacheEntry is a transaction in the relay cache of the server along with
+// the time it expires.
+type relayCacheEntry struct {
+	tx      *btcutil.Tx
+	expires time.Time
+}
+
 // cfHeaderKV is a tuple of a filter header and its associated block hash. The
 // struct is used to cache cfcheckpt responses.
 type cfHeaderKV struct {
@@ -200,6 +311,7 @@ type server struct {
 	// Putting the uint64s first makes them 64-bit aligned for 32-bit systems.
 	bytesReceived uint64 // Total bytes received from all peers since start.
 	bytesSent     uint64 // Total bytes sent by all peers since start.
+	lastTipTime   int64  // Unix time in nanoseconds of the last new tip.
 	started       int32
 	shutdown      int32
 	shutdownSched int32
@@ -208,11 +320,13 @@ type server struct {
 	chainParams          *chaincfg.Params
 	addrManager          *addrmgr.AddrManager
 	connManager          *connmgr.ConnManager
+	targetOutbound       int
 	sigCache             *txscript.SigCache
 	hashCache            *txscript.HashCache
 	rpcServer            *rpcServer
 	syncManager          *netsync.SyncManager
 	chain                *blockchain.BlockChain
+	bgChain              *blockchain.BlockChain
 	txMemPool            *mempool.TxPool
 	cpuMiner             *cpuminer.CPUMiner
 	modifyRebroadcastInv chan interface{}
@@ -246,6 +360,26 @@ type server struct {
 	// messages for each filter type.
 	cfCheckptCaches    map[wire.FilterType][]cfHeaderKV
 	cfCheckptCachesMtx sync.RWMutex
+
+	// v1OnlyAddrs stores the addresses of outbound peers which failed the
+	// v2 transport handshake so that later connections to them fall back
+	// to the v1 transport.
+	v1OnlyAddrs *v1OnlyAddrSet
+
+	// uploadTarget keeps the number of bytes sent to peers under the
+	// configured upload target.
+	uploadTarget *uploadTarget
+
+	// relayCache holds the transactions which were force relayed on
+	// behalf of peers with the relay permission without being accepted to
+	// the mempool, keyed by both their transaction and witness hashes, so
+	// they can be served to the peers they were announced to.
+	relayCache    map[chainhash.Hash]relayCacheEntry
+	relayCacheMtx sync.Mutex
+
+	// netGroupKey is the secret key used to hash the network groups of
+	// inbound peers when selecting which ones are protected from eviction.
+	netGroupKey [16]byte
 }
 
 // serverPeer extends the peer to maintain state shared by the server and
@@ -254,6 +388,12 @@ type serverPeer struct {
 	// The following variables must only be used atomically
 	feeFilter int64
 
+	// lastTxTime and lastBlockTime are the unix times in nanoseconds at
+	// which the peer last relayed a transaction which was accepted to the
+	// mempool and a block which extended the main chain, respectively.
+	lastTxTime    int64
+	lastBlockTime int64
+
 	*peer.Peer
 
 	connReq        *connmgr.ConnReq
@@ -263,7 +403,8 @@ type serverPeer struct {
 	relayMtx       sync.Mutex
 	disableRelayTx bool
 	sentAddrs      bool
-	isWhitelisted  bool
+	permissions    peerPermissions
+	triedV2        bool
 	filter         *bloom.Filter
 	knownAddresses map[string]struct{}
 	banScore       connmgr.DynamicBanScore
@@ -296,14 +437,14 @@ func (sp *serverPeer) newestBlock() (*chainhash.Hash, int32, error) {
 
 // addKnownAddresses adds the given addresses to the set of known addresses to
 // the peer to prevent sending duplicate addresses.
-func (sp *serverPeer) addKnownAddresses(addresses []*wire.NetAddress) {
+func (sp *serverPeer) addKnownAddresses(addresses []*wire.NetAddressV2) {
 	for _, na := range addresses {
 		sp.knownAddresses[addrmgr.NetAddressKey(na)] = struct{}{}
 	}
 }
 
 // addressKnown true if the given address is already known to the peer.
-func (sp *serverPeer) addressKnown(na *wire.NetAddress) bool {
+func (sp *serverPeer) addressKnown(na *wire.NetAddressV2) bool {
 	_, exists := sp.knownAddresses[addrmgr.NetAddressKey(na)]
 	return exists
 }
@@ -327,23 +468,82 @@ func (sp *serverPeer) relayTxDisabled() bool {
 	return isDisabled
 }
 
+// isBlockRelayOnly returns whether the peer is an outbound block-relay-only
+// peer.  Transactions and addresses are neither relayed to nor accepted from
+// such peers.
+func (sp *serverPeer) isBlockRelayOnly() bool {
+	return sp.connReq != nil && sp.connReq.Type == connmgr.ConnTypeBlockRelay
+}
+
+// connectionType returns the type of the connection to the peer as reported by
+// the getpeerinfo RPC.
+func (sp *serverPeer) connectionType() string {
+	if sp.Inbound() {
+		return "inbound"
+	}
+	if sp.persistent || sp.connReq == nil {
+		return "manual"
+	}
+	switch sp.connReq.Type {
+	case connmgr.ConnTypeBlockRelay:
+		return "block-relay-only"
+	case connmgr.ConnTypeFeeler:
+		return "feeler"
+	case connmgr.ConnTypeManual:
+		return "manual"
+	}
+	return "outbound-full-relay"
+}
+
+// isFeeler returns whether the peer is a feeler which is only connected to in
+// order to test whether its address is reachable.
+func (sp *serverPeer) isFeeler() bool {
+	return sp.connReq != nil && sp.connReq.Type == connmgr.ConnTypeFeeler
+}
+
 // pushAddrMsg sends an addr message to the connected peer using the provided
 // addresses.
-func (sp *serverPeer) pushAddrMsg(addresses []*wire.NetAddress) {
+func (sp *serverPeer) pushAddrMsg(addresses []*wire.NetAddressV2) {
 	// Filter addresses already known to the peer.
-	addrs := make([]*wire.NetAddress, 0, len(addresses))
+	addrs := make([]*wire.NetAddressV2, 0, len(addresses))
 	for _, addr := range addresses {
 		if !sp.addressKnown(addr) {
 			addrs = append(addrs, addr)
 		}
 	}
-	known, err := sp.PushAddrMsg(addrs)
+
+	// Peers which support addrv2 messages are sent addresses of all
+	// networks.
+	if sp.WantsAddrV2() {
+		known, err := sp.PushAddrV2Msg(addrs)
+		if err != nil {
+			peerLog.Errorf("Can't push addrv2 message to %s: %v",
+				sp.Peer, err)
+			sp.Disconnect()
+			return
+		}
+		sp.addKnownAddresses(known)
+		return
+	}
+
+	// Other peers are only sent addresses which fit into legacy addresses.
+	legacyAddrs := make([]*wire.NetAddress, 0, len(addrs))
+	for _, addr := range addrs {
+		if legacyAddr := addr.ToLegacy(); legacyAddr != nil {
+			legacyAddrs = append(legacyAddrs, legacyAddr)
+		}
+	}
+	known, err := sp.PushAddrMsg(legacyAddrs)
 	if err != nil {
 		peerLog.Errorf("Can't push address message to %s: %v", sp.Peer, err)
 		sp.Disconnect()
 		return
 	}
-	sp.addKnownAddresses(known)
+	for _, na := range known {
+		sp.addKnownAddresses([]*wire.NetAddressV2{
+			wire.NetAddressV2FromLegacy(na),
+		})
+	}
 }
 
 // addBanScore increases the persistent and decaying ban score fields by the
@@ -356,7 +556,7 @@ func (sp *serverPeer) addBanScore(persistent, transient uint32, reason string) {
 	if cfg.DisableBanning {
 		return
 	}
-	if sp.isWhitelisted {
+	if sp.permissions.has(permNoBan) {
 		peerLog.Debugf("Misbehaving whitelisted peer %s: %s", sp, reason)
 		return
 	}
@@ -430,6 +630,18 @@ func (sp *serverPeer) OnVersion(_ *peer.Peer, msg *wire.MsgVersion) *wire.MsgRej
 		return wire.NewMsgReject(msg.Command(), wire.RejectNonstandard, reason)
 	}
 
+	// Feeler connections have served their purpose once the peer is known
+	// to be reachable, so mark the address as good and disconnect before
+	// the peer is used for anything else.
+	if sp.isFeeler() {
+		if !cfg.SimNet {
+			addrManager.Good(remoteAddr)
+		}
+		peerLog.Debugf("Disconnecting feeler %v", sp)
+		sp.Disconnect()
+		return nil
+	}
+
 	// Update the address manager and request known addresses from the
 	// remote peer for outbound connections.  This is skipped when running
 	// on the simulation test network since it is only intended to connect
@@ -456,12 +668,16 @@ func (sp *serverPeer) OnVersion(_ *peer.Peer, msg *wire.MsgVersion) *wire.MsgRej
 
 		// Advertise the local address when the server accepts incoming
 		// connections and it believes itself to be close to the best known tip.
-		if !cfg.DisableListen && sp.server.syncManager.IsCurrent() {
+		// Addresses are never exchanged with block-relay-only peers.
+		blockRelayOnly := sp.isBlockRelayOnly()
+		if !cfg.DisableListen && !blockRelayOnly &&
+			sp.server.syncManager.IsCurrent() {
+
 			// Get address that best matches.
 			lna := addrManager.GetBestLocalAddress(remoteAddr)
 			if addrmgr.IsRoutable(lna) {
 				// Filter addresses the peer already knows about.
-				addresses := []*wire.NetAddress{lna}
+				addresses := []*wire.NetAddressV2{lna}
 				sp.pushAddrMsg(addresses)
 			}
 		}
@@ -470,7 +686,9 @@ func (sp *serverPeer) OnVersion(_ *peer.Peer, msg *wire.MsgVersion) *wire.MsgRej
 		// more and the peer has a protocol version new enough to
 		// include a timestamp with addresses.
 		hasTimestamp := sp.ProtocolVersion() >= wire.NetAddressTimeVersion
-		if addrManager.NeedMoreAddresses() && hasTimestamp {
+		if addrManager.NeedMoreAddresses() && hasTimestamp &&
+			!blockRelayOnly {
+
 			sp.QueueMessage(wire.NewMsgGetAddr(), nil)
 		}
 
@@ -487,7 +705,7 @@ func (sp *serverPeer) OnVersion(_ *peer.Peer, msg *wire.MsgVersion) *wire.MsgRej
 
 	// Choose whether or not to relay transactions before a filter command
 	// is received.
-	sp.setDisableRelayTx(msg.DisableRelayTx)
+	sp.setDisableRelayTx(msg.DisableRelayTx || sp.isBlockRelayOnly())
 
 	// Add valid peer to the server.
 	sp.server.AddPeer(sp)
@@ -500,14 +718,27 @@ func (sp *serverPeer) OnVersion(_ *peer.Peer, msg *wire.MsgVersion) *wire.MsgRej
 // bloom filter loaded, the contents are filtered accordingly.
 func (sp *serverPeer) OnMemPool(_ *peer.Peer, msg *wire.MsgMemPool) {
 	// Only allow mempool requests if the server has bloom filtering
-	// enabled.
-	if sp.server.services&wire.SFNodeBloom != wire.SFNodeBloom {
+	// enabled or the peer has the mempool permission.
+	if sp.server.services&wire.SFNodeBloom != wire.SFNodeBloom &&
+		!sp.permissions.has(permMempool) {
+
 		peerLog.Debugf("peer %v sent mempool request with bloom "+
 			"filtering disabled -- disconnecting", sp)
 		sp.Disconnect()
 		return
 	}
 
+	// Serving the mempool is expensive, so it is only done for peers with
+	// the mempool permission once the upload target is reached.
+	if sp.server.uploadTarget.Reached(false) &&
+		!sp.permissions.has(permMempool) {
+
+		peerLog.Debugf("peer %v sent mempool request with upload "+
+			"target reached -- disconnecting", sp)
+		sp.Disconnect()
+		return
+	}
+
 	// A decaying ban score increase is applied to prevent flooding.
 	// The ban score accumulates and passes the ban threshold if a burst of
 	// mempool messages comes from a peer. The score decays each minute to
@@ -528,8 +759,7 @@ func (sp *serverPeer) OnMemPool(_ *peer.Peer, msg *wire.MsgMemPool) {
 		// or only the transactions that match the filter when there is
 		// one.
 		if !sp.filter.IsLoaded() || sp.filter.MatchTxAndUpdate(txDesc.Tx) {
-			iv := wire.NewInvVect(wire.InvTypeTx, txDesc.Tx.Hash())
-			invMsg.AddInvVect(iv)
+			invMsg.AddInvVect(sp.txInvVect(txDesc.Tx))
 			if len(invMsg.InvList)+1 > wire.MaxInvPerMsg {
 				break
 			}
@@ -542,31 +772,74 @@ func (sp *serverPeer) OnMemPool(_ *peer.Peer, msg *wire.MsgMemPool) {
 	}
 }
 
+// txInvVect returns the inventory vector used to announce the passed
+// transaction to the peer.  Peers which negotiated wtxid relay (BIP0339) are
+// sent the witness transaction hash.
+func (sp *serverPeer) txInvVect(tx *btcutil.Tx) *wire.InvVect {
+	if sp.WTxIDRelay() {
+		return wire.NewInvVect(wire.InvTypeWTx, tx.WitnessHash())
+	}
+	return wire.NewInvVect(wire.InvTypeTx, tx.Hash())
+}
+
 // OnTx is invoked when a peer receives a tx bitcoin message.  It blocks
 // until the bitcoin transaction has been fully processed.  Unlock the block
 // handler this does not serialize all transactions through a single thread
 // transactions don't rely on the previous one in a linear fashion like blocks.
 func (sp *serverPeer) OnTx(_ *peer.Peer, msg *wire.MsgTx) {
-	if cfg.BlocksOnly {
+	if cfg.BlocksOnly && !sp.permissions.has(permRelay) {
 		peerLog.Tracef("Ignoring tx %v from %v - blocksonly enabled",
 			msg.TxHash(), sp)
 		return
 	}
 
+	// Block-relay-only peers were asked not to relay transactions.
+	if sp.isBlockRelayOnly() {
+		peerLog.Infof("Block-relay-only peer %v sent tx %v -- "+
+			"disconnecting", sp, msg.TxHash())
+		sp.Disconnect()
+		return
+	}
+
 	// Add the transaction to the known inventory for the peer.
 	// Convert the raw MsgTx to a btcutil.Tx which provides some convenience
 	// methods and things such as hash caching.
 	tx := btcutil.NewTx(msg)
-	iv := wire.NewInvVect(wire.InvTypeTx, tx.Hash())
-	sp.AddKnownInventory(iv)
+	sp.AddKnownInventory(sp.txInvVect(tx))
 
 	// Queue the transaction up to be handled by the sync manager and
 	// intentionally block further receives until the transaction is fully
 	// processed and known good or bad.  This helps prevent a malicious peer
 	// from queuing up a bunch of bad transactions before disconnecting (or
 	// being disconnected) and wasting memory.
-	sp.server.syncManager.QueueTx(tx, sp.Peer, sp.txProcessed)
+	txPool := sp.server.txMemPool
+	novel := !txPool.IsTransactionInPool(tx.Hash())
+	forceRelay := sp.permissions.has(permRelay)
+	sp.server.syncManager.QueueTx(tx, sp.Peer, forceRelay, sp.txProcessed)
 	<-sp.txProcessed
+
+	// Remember when the peer last relayed a new transaction since such
+	// peers are protected from eviction.
+	if novel && txPool.IsTransactionInPool(tx.Hash()) {
+		atomic.StoreInt64(&sp.lastTxTime, time.Now().UnixNano())
+	}
+}
+
+// waitBlockProcessed blocks until the block with the passed hash that was
+// queued to the sync manager has been processed and records whether the peer
+// relayed a new block which extended the main chain.  Such peers are protected
+// from eviction.
+func (sp *serverPeer) waitBlockProcessed(hash *chainhash.Hash, novel bool) {
+	<-sp.blockProcessed
+	if novel && sp.server.chain.MainChainHasBlock(hash) {
+		atomic.StoreInt64(&sp.lastBlockTime, time.Now().UnixNano())
+	}
+}
+
+// haveBlock returns whether the block with the passed hash is already known.
+func (sp *serverPeer) haveBlock(hash *chainhash.Hash) bool {
+	have, err := sp.server.chain.HaveBlock(hash)
+	return err != nil || have
 }
 
 // OnBlock is invoked when a peer receives a block bitcoin message.  It
@@ -591,8 +864,75 @@ func (sp *serverPeer) OnBlock(_ *peer.Peer, msg *wire.MsgBlock, buf []byte) {
 	// reference implementation processes blocks in the same
 	// thread and therefore blocks further messages until
 	// the bitcoin block has been fully processed.
+	novel := !sp.haveBlock(block.Hash())
 	sp.server.syncManager.QueueBlock(block, sp.Peer, sp.blockProcessed)
-	<-sp.blockProcessed
+	sp.waitBlockProcessed(block.Hash(), novel)
+}
+
+// OnCmpctBlock is invoked when a peer receives a cmpctblock bitcoin message.
+// It blocks until the compact block has been processed, which includes
+// processing the reconstructed block when all of its transactions are known.
+func (sp *serverPeer) OnCmpctBlock(_ *peer.Peer, msg *wire.MsgCmpctBlock) {
+	hash := msg.BlockHash()
+	novel := !sp.haveBlock(&hash)
+	sp.server.syncManager.QueueCmpctBlock(msg, sp.Peer, sp.blockProcessed)
+	sp.waitBlockProcessed(&hash, novel)
+}
+
+// OnBlockTxn is invoked when a peer receives a blocktxn bitcoin message.  It
+// blocks until the block the transactions complete has been processed.
+func (sp *serverPeer) OnBlockTxn(_ *peer.Peer, msg *wire.MsgBlockTxn) {
+	novel := !sp.haveBlock(&msg.BlockHash)
+	sp.server.syncManager.QueueBlockTxn(msg, sp.Peer, sp.blockProcessed)
+	sp.waitBlockProcessed(&msg.BlockHash, novel)
+}
+
+// OnGetBlockTxn is invoked when a peer receives a getblocktxn bitcoin message.
+// It responds with the requested transactions of a recent block or the full
+// block when the block is too old.
+func (sp *serverPeer) OnGetBlockTxn(_ *peer.Peer, msg *wire.MsgGetBlockTxn) {
+	encoding := wire.BaseEncoding
+	if sp.CmpctBlockVersion() == wire.CmpctBlockVersionWTxID {
+		encoding = wire.WitnessEncoding
+	}
+
+	chain := sp.server.chain
+	height, err := chain.BlockHeightByHash(&msg.BlockHash)
+	if err != nil {
+		peerLog.Debugf("Unable to fetch block %v requested by %v: %v",
+			msg.BlockHash, sp, err)
+		return
+	}
+	if chain.BestSnapshot().Height-height >= maxBlockTxnDepth {
+		doneChan := make(chan struct{}, 1)
+		err := sp.server.pushBlockMsg(sp, &msg.BlockHash, doneChan,
+			nil, encoding)
+		if err == nil {
+			<-doneChan
+		}
+		return
+	}
+
+	block, err := chain.BlockByHash(&msg.BlockHash)
+	if err != nil {
+		peerLog.Debugf("Unable to fetch block %v requested by %v: %v",
+			msg.BlockHash, sp, err)
+		return
+	}
+	txns := block.MsgBlock().Transactions
+	resp := wire.NewMsgBlockTxn(&msg.BlockHash,
+		make([]*wire.MsgTx, 0, len(msg.Indexes)))
+	for _, idx := range msg.Indexes {
+		if int(idx) >= len(txns) {
+			peerLog.Infof("Peer %v requested out of range "+
+				"transaction %d of block %v -- disconnecting",
+				sp, idx, msg.BlockHash)
+			sp.Disconnect()
+			return
+		}
+		resp.Transactions = append(resp.Transactions, txns[idx])
+	}
+	sp.QueueMessageWithEncoding(resp, nil, encoding)
 }
 
 // OnInv is invoked when a peer receives an inv bitcoin message and is
@@ -600,7 +940,8 @@ func (sp *serverPeer) OnBlock(_ *peer.Peer, msg *wire.MsgBlock, buf []byte) {
 // accordingly.  We pass the message down to blockmanager which will call
 // QueueMessage with any appropriate responses.
 func (sp *serverPeer) OnInv(_ *peer.Peer, msg *wire.MsgInv) {
-	if !cfg.BlocksOnly {
+	acceptTxs := !cfg.BlocksOnly || sp.permissions.has(permRelay)
+	if acceptTxs && !sp.isBlockRelayOnly() {
 		if len(msg.InvList) > 0 {
 			sp.server.syncManager.QueueInv(msg, sp.Peer)
 		}
@@ -609,9 +950,9 @@ func (sp *serverPeer) OnInv(_ *peer.Peer, msg *wire.MsgInv) {
 
 	newInv := wire.NewMsgInvSizeHint(uint(len(msg.InvList)))
 	for _, invVect := range msg.InvList {
-		if invVect.Type == wire.InvTypeTx {
+		if invVect.Type == wire.InvTypeTx || invVect.Type == wire.InvTypeWTx {
 			peerLog.Tracef("Ignoring tx %v in inv from %v -- "+
-				"blocksonly enabled", invVect.Hash, sp)
+				"transactions not relayed", invVect.Hash, sp)
 			if sp.ProtocolVersion() >= wire.BIP0037Version {
 				peerLog.Infof("Peer %v is announcing "+
 					"transactions -- disconnecting", sp)
@@ -670,16 +1011,30 @@ func (sp *serverPeer) OnGetData(_ *peer.Peer, msg *wire.MsgGetData) {
 			// Buffered so as to not make the send goroutine block.
 			c = make(chan struct{}, 1)
 		}
+		// Disconnect peers requesting historical blocks once the
+		// upload target is about to be reached unless they have the
+		// download permission.
+		if sp.server.historicalBlockDenied(sp, iv) {
+			peerLog.Infof("Historical block serving limit reached, "+
+				"disconnecting peer %v", sp)
+			sp.Disconnect()
+			return
+		}
+
 		var err error
 		switch iv.Type {
 		case wire.InvTypeWitnessTx:
-			err = sp.server.pushTxMsg(sp, &iv.Hash, c, waitChan, wire.WitnessEncoding)
+			err = sp.server.pushTxMsg(sp, &iv.Hash, false, c, waitChan, wire.WitnessEncoding)
 		case wire.InvTypeTx:
-			err = sp.server.pushTxMsg(sp, &iv.Hash, c, waitChan, wire.BaseEncoding)
+			err = sp.server.pushTxMsg(sp, &iv.Hash, false, c, waitChan, wire.BaseEncoding)
+		case wire.InvTypeWTx:
+			err = sp.server.pushTxMsg(sp, &iv.Hash, true, c, waitChan, wire.WitnessEncoding)
 		case wire.InvTypeWitnessBlock:
 			err = sp.server.pushBlockMsg(sp, &iv.Hash, c, waitChan, wire.WitnessEncoding)
 		case wire.InvTypeBlock:
 			err = sp.server.pushBlockMsg(sp, &iv.Hash, c, waitChan, wire.BaseEncoding)
+		case wire.InvTypeCmpctBlock:
+			err = sp.server.pushCmpctBlockMsg(sp, &iv.Hash, c, waitChan)
 		case wire.InvTypeFilteredWitnessBlock:
 			err = sp.server.pushMerkleBlockMsg(sp, &iv.Hash, c, waitChan, wire.WitnessEncoding)
 		case wire.InvTypeFilteredBlock:
@@ -1215,7 +1570,10 @@ func (sp *serverPeer) OnFilterLoad(_ *peer.Peer, msg *wire.MsgFilterLoad) {
 		return
 	}
 
-	sp.setDisableRelayTx(false)
+	// Transactions are never relayed to block-relay-only peers.
+	if !sp.isBlockRelayOnly() {
+		sp.setDisableRelayTx(false)
+	}
 
 	sp.filter.Reload(msg)
 }
@@ -1241,8 +1599,9 @@ func (sp *serverPeer) OnGetAddr(_ *peer.Peer, msg *wire.MsgGetAddr) {
 	}
 
 	// Only allow one getaddr request per connection to discourage
-	// address stamping of inv announcements.
-	if sp.sentAddrs {
+	// address stamping of inv announcements unless the peer has the addr
+	// permission.
+	if sp.sentAddrs && !sp.permissions.has(permAddr) {
 		peerLog.Debugf("Ignoring repeated getaddr request from peer ",
 			"%v", sp)
 		return
@@ -1259,6 +1618,23 @@ func (sp *serverPeer) OnGetAddr(_ *peer.Peer, msg *wire.MsgGetAddr) {
 // OnAddr is invoked when a peer receives an addr bitcoin message and is
 // used to notify the server about advertised addresses.
 func (sp *serverPeer) OnAddr(_ *peer.Peer, msg *wire.MsgAddr) {
+	addrs := make([]*wire.NetAddressV2, 0, len(msg.AddrList))
+	for _, na := range msg.AddrList {
+		addrs = append(addrs, wire.NetAddressV2FromLegacy(na))
+	}
+	sp.handleAddrs(msg.Command(), addrs)
+}
+
+// OnAddrV2 is invoked when a peer receives an addrv2 bitcoin message and is
+// used to notify the server about advertised addresses, which may include Tor
+// v3, I2P and CJDNS addresses.
+func (sp *serverPeer) OnAddrV2(_ *peer.Peer, msg *wire.MsgAddrV2) {
+	sp.handleAddrs(msg.Command(), msg.AddrList)
+}
+
+// handleAddrs adds the addresses advertised by the peer in an addr or addrv2
+// message identified by command to the server address manager.
+func (sp *serverPeer) handleAddrs(command string, addrs []*wire.NetAddressV2) {
 	// Ignore addresses when running on the simulation test network.  This
 	// helps prevent the network from becoming another public test network
 	// since it will not be able to learn about other peers that have not
@@ -1272,15 +1648,22 @@ func (sp *serverPeer) OnAddr(_ *peer.Peer, msg *wire.MsgAddr) {
 		return
 	}
 
+	// Addresses are never exchanged with block-relay-only peers.
+	if sp.isBlockRelayOnly() {
+		peerLog.Debugf("Ignoring %s from block-relay-only peer %v",
+			command, sp)
+		return
+	}
+
 	// A message that has no addresses is invalid.
-	if len(msg.AddrList) == 0 {
+	if len(addrs) == 0 {
 		peerLog.Errorf("Command [%s] from %s does not contain any addresses",
-			msg.Command(), sp.Peer)
+			command, sp.Peer)
 		sp.Disconnect()
 		return
 	}
 
-	for _, na := range msg.AddrList {
+	for _, na := range addrs {
 		// Don't add more address if we're disconnecting.
 		if !sp.Connected() {
 			return
@@ -1295,7 +1678,7 @@ func (sp *serverPeer) OnAddr(_ *peer.Peer, msg *wire.MsgAddr) {
 		}
 
 		// Add address to known addresses for this peer.
-		sp.addKnownAddresses([]*wire.NetAddress{na})
+		sp.addKnownAddresses([]*wire.NetAddressV2{na})
 	}
 
 	// Add addresses to server address manager.  The address manager handles
@@ -1303,7 +1686,7 @@ func (sp *serverPeer) OnAddr(_ *peer.Peer, msg *wire.MsgAddr) {
 	// addresses, and last seen updates.
 	// XXX bitcoind gives a 2 hour time penalty here, do we want to do the
 	// same?
-	sp.server.addrManager.AddAddresses(msg.AddrList, sp.NA())
+	sp.server.addrManager.AddAddresses(addrs, sp.NA())
 }
 
 // OnRead is invoked when a peer receives a message and it is used to update
@@ -1383,6 +1766,43 @@ func (s *server) AnnounceNewTransactions(txns []*mempool.TxDesc) {
 	}
 }
 
+// RelayRejectedTransaction relays the passed transaction, which was rejected
+// by the mempool due to the local relay policy, to the other peers on behalf of
+// a peer with the relay permission.  The transaction is kept in the relay cache
+// for a while so it can be served to the peers which request it.
+func (s *server) RelayRejectedTransaction(tx *btcutil.Tx) {
+	now := time.Now()
+	entry := relayCacheEntry{tx: tx, expires: now.Add(relayCacheExpiry)}
+
+	s.relayCacheMtx.Lock()
+	for hash, e := range s.relayCache {
+		if now.After(e.expires) {
+			delete(s.relayCache, hash)
+		}
+	}
+	s.relayCache[*tx.Hash()] = entry
+	s.relayCache[*tx.WitnessHash()] = entry
+	s.relayCacheMtx.Unlock()
+
+	iv := wire.NewInvVect(wire.InvTypeTx, tx.Hash())
+	s.RelayInventory(iv, &mempool.TxDesc{
+		TxDesc: mining.TxDesc{Tx: tx, Added: now},
+	})
+}
+
+// relayCacheTransaction returns the transaction with the passed transaction or
+// witness hash from the relay cache or nil when it is not cached.
+func (s *server) relayCacheTransaction(hash *chainhash.Hash) *btcutil.Tx {
+	s.relayCacheMtx.Lock()
+	defer s.relayCacheMtx.Unlock()
+
+	entry, ok := s.relayCache[*hash]
+	if !ok || time.Now().After(entry.expires) {
+		return nil
+	}
+	return entry.tx
+}
+
 // Transaction has one confirmation on the main chain. Now we can mark it as no
 // longer needing rebroadcasting.
 func (s *server) TransactionConfirmed(tx *btcutil.Tx) {
@@ -1395,15 +1815,80 @@ func (s *server) TransactionConfirmed(tx *btcutil.Tx) {
 	s.RemoveRebroadcastInventory(iv)
 }
 
+// AddBanScore increases the ban score of the passed peer by the given values,
+// banning and disconnecting it once the ban threshold is exceeded.  It is used
+// by the sync manager to penalize peers which misbehave while syncing.
+func (s *server) AddBanScore(p *peer.Peer, persistent, transient uint32, reason string) {
+	replyChan := make(chan []*serverPeer)
+	select {
+	case s.query <- getPeersMsg{reply: replyChan}:
+	case <-s.quit:
+		return
+	}
+	for _, sp := range <-replyChan {
+		if sp.Peer == p {
+			sp.addBanScore(persistent, transient, reason)
+			return
+		}
+	}
+}
+
+// historicalBlockDenied returns whether serving the block requested by the
+// passed inventory vector to the peer is denied by the upload target.  Filtered
+// blocks and blocks older than historicalBlockAge relative to the best chain
+// tip are denied once the upload target is about to be reached unless the peer
+// has the download permission.
+func (s *server) historicalBlockDenied(sp *serverPeer, iv *wire.InvVect) bool {
+	var filtered bool
+	switch iv.Type {
+	case wire.InvTypeBlock, wire.InvTypeWitnessBlock:
+	case wire.InvTypeFilteredBlock, wire.InvTypeFilteredWitnessBlock:
+		filtered = true
+	default:
+		return false
+	}
+	if sp.permissions.has(permDownload) || !s.uploadTarget.Reached(true) {
+		return false
+	}
+	if filtered {
+		return true
+	}
+
+	header, err := s.chain.HeaderByHash(&iv.Hash)
+	if err != nil {
+		// Unknown blocks are answered with a notfound message.
+		return false
+	}
+	best := s.chain.BestSnapshot()
+	bestHeader, err := s.chain.HeaderByHash(&best.Hash)
+	if err != nil {
+		return false
+	}
+	return bestHeader.Timestamp.Sub(header.Timestamp) > historicalBlockAge
+}
+
 // pushTxMsg sends a tx message for the provided transaction hash to the
-// connected peer.  An error is returned if the transaction hash is not known.
-func (s *server) pushTxMsg(sp *serverPeer, hash *chainhash.Hash, doneChan chan<- struct{},
-	waitChan <-chan struct{}, encoding wire.MessageEncoding) error {
+// connected peer.  The hash is a witness transaction hash when byWTxID is set.
+// An error is returned if the transaction hash is not known.
+func (s *server) pushTxMsg(sp *serverPeer, hash *chainhash.Hash, byWTxID bool,
+	doneChan chan<- struct{}, waitChan <-chan struct{},
+	encoding wire.MessageEncoding) error {
 
 	// Attempt to fetch the requested transaction from the pool.  A
 	// call could be made to check for existence first, but simply trying
 	// to fetch a missing transaction results in the same behavior.
-	tx, err := s.txMemPool.FetchTransaction(hash)
+	var tx *btcutil.Tx
+	var err error
+	if byWTxID {
+		tx, err = s.txMemPool.FetchTransactionByWTxID(hash)
+	} else {
+		tx, err = s.txMemPool.FetchTransaction(hash)
+	}
+	if err != nil {
+		if cached := s.relayCacheTransaction(hash); cached != nil {
+			tx, err = cached, nil
+		}
+	}
 	if err != nil {
 		peerLog.Tracef("Unable to fetch tx %v from transaction "+
 			"pool: %v", hash, err)
@@ -1490,6 +1975,65 @@ func (s *server) pushBlockMsg(sp *serverPeer, hash *chainhash.Hash, doneChan cha
 	return nil
 }
 
+// pushCmpctBlockMsg sends a cmpctblock message for the provided block hash to
+// the connected peer.  The full block is sent instead when the block is not
+// recent or the peer has not negotiated compact blocks.  An error is returned
+// if the block hash is not known.
+func (s *server) pushCmpctBlockMsg(sp *serverPeer, hash *chainhash.Hash,
+	doneChan chan<- struct{}, waitChan <-chan struct{}) error {
+
+	version := sp.CmpctBlockVersion()
+	encoding := wire.BaseEncoding
+	if version == wire.CmpctBlockVersionWTxID || (version == 0 &&
+		sp.IsWitnessEnabled()) {
+
+		encoding = wire.WitnessEncoding
+	}
+
+	height, err := s.chain.BlockHeightByHash(hash)
+	if err != nil || version == 0 ||
+		s.chain.BestSnapshot().Height-height >= maxCmpctBlockDepth {
+
+		return s.pushBlockMsg(sp, hash, doneChan, waitChan, encoding)
+	}
+
+	blk, err := s.chain.BlockByHash(hash)
+	if err != nil {
+		peerLog.Tracef("Unable to fetch requested block hash %v: %v",
+			hash, err)
+
+		if doneChan != nil {
+			doneChan <- struct{}{}
+		}
+		return err
+	}
+	msg, err := newCmpctBlock(blk, version)
+	if err != nil {
+		if doneChan != nil {
+			doneChan <- struct{}{}
+		}
+		return err
+	}
+
+	// Once we have fetched data wait for any previous operation to finish.
+	if waitChan != nil {
+		<-waitChan
+	}
+	sp.QueueMessageWithEncoding(msg, doneChan, encoding)
+	return nil
+}
+
+// newCmpctBlock returns a cmpctblock message of the passed compact block
+// version for the passed block.  Only the coinbase transaction is prefilled.
+func newCmpctBlock(block *btcutil.Block, version uint64) (*wire.MsgCmpctBlock, error) {
+	nonce, err := wire.RandomUint64()
+	if err != nil {
+		return nil, err
+	}
+	return wire.NewMsgCmpctBlock(block.MsgBlock(), nonce, nil,
+		version == wire.CmpctBlockVersionWTxID), nil
+}
+
 // pushMerkleBlockMsg sends a merkleblock message for the provided block hash to
 // the connected peer.  Since a merkle block requires the peer to have a filter
 // loaded, this call will simply be ignored if there is no filter loaded.  An
@@ -1614,8 +2158,11 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 
 	// TODO: Check for max peers from a single IP.
 
-	// Limit max number of total peers.
-	if state.Count() >= cfg.MaxPeers {
+	// Limit max number of total peers.  Inbound peers take the place of
+	// an evicted inbound peer when possible.
+	if state.Count() >= cfg.MaxPeers &&
+		(!sp.Inbound() || !s.evictInboundPeer(state)) {
+
 		srvrLog.Infof("Max peers reached [%d] - disconnecting peer %s",
 			cfg.MaxPeers, sp)
 		sp.Disconnect()
@@ -1629,7 +2176,7 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 	if sp.Inbound() {
 		state.inboundPeers[sp.ID()] = sp
 	} else {
-		state.outboundGroups[addrmgr.GroupKey(sp.NA())]++
+		state.outboundGroups[s.addrManager.GroupKey(sp.NA())]++
 		if sp.persistent {
 			state.persistentPeers[sp.ID()] = sp
 		} else {
@@ -1640,9 +2187,155 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 	return true
 }
 
+// evictInboundPeer disconnects an inbound peer to make room for a new inbound
+// peer and returns whether a peer was evicted.  Whitelisted peers are never
+// evicted and peers which are hard for an attacker to replace are protected as
+// described by selectPeerToEvict.  It is invoked from the peerHandler
+// goroutine.
+func (s *server) evictInboundPeer(state *peerState) bool {
+	candidates := make([]*evictionCandidate, 0, len(state.inboundPeers))
+	for _, sp := range state.inboundPeers {
+		if sp.permissions.has(permNoBan) || !sp.Connected() {
+			continue
+		}
+
+		stats := sp.StatsSnapshot()
+		netGroup := s.addrManager.GroupKey(sp.NA())
+		candidates = append(candidates, &evictionCandidate{
+			id:       sp.ID(),
+			netGroup: netGroup,
+			keyedNetGroup: siphash.Sum64([]byte(netGroup),
+				&s.netGroupKey),
+			pingTime:  time.Duration(stats.LastPingMicros) * time.Microsecond,
+			connected: stats.ConnTime,
+			lastTx:    time.Unix(0, atomic.LoadInt64(&sp.lastTxTime)),
+			lastBlock: time.Unix(0, atomic.LoadInt64(&sp.lastBlockTime)),
+			relayTxs:  !sp.relayTxDisabled(),
+		})
+	}
+
+	id, ok := selectPeerToEvict(candidates)
+	if !ok {
+		return false
+	}
+	sp := state.inboundPeers[id]
+	srvrLog.Infof("Evicting inbound peer %s to make room for a new peer", sp)
+	delete(state.inboundPeers, id)
+	sp.Disconnect()
+	return true
+}
+
+// staleTipAction describes the action taken by the stale tip check.
+type staleTipAction int
+
+const (
+	// staleTipNone indicates the outbound peers are left as they are.
+	staleTipNone staleTipAction = iota
+
+	// staleTipConnect indicates an extra outbound peer is tried.
+	staleTipConnect
+
+	// staleTipEvict indicates an extra outbound peer is disconnected.
+	staleTipEvict
+)
+
+// String returns the staleTipAction as a human-readable name.
+func (a staleTipAction) String() string {
+	switch a {
+	case staleTipNone:
+		return "none"
+	case staleTipConnect:
+		return "connect"
+	case staleTipEvict:
+		return "evict"
+	}
+	return fmt.Sprintf("unknown stale tip action (%d)", int(a))
+}
+
+// selectStaleTipAction returns the action the stale tip check takes given
+// whether the best chain tip is stale, whether the chain is current and the
+// number of full relay outbound peers.
+//
+// An extra outbound peer is tried while the tip is stale or the chain is not
+// current, which includes the best chain having less than the minimum chain
+// work, in case the outbound peers are not relaying the blocks of the best
+// chain.  The connection manager is already making new connections while there
+// are fewer outbound peers than targeted, so an extra one is only tried when
+// the target is met.  Once the chain is current with a fresh tip again, the
+// extra outbound peers are disconnected.
+func selectStaleTipAction(stale, current bool, numFullRelay, targetOutbound int) staleTipAction {
+	if stale || !current {
+		if numFullRelay == targetOutbound {
+			return staleTipConnect
+		}
+		return staleTipNone
+	}
+	if numFullRelay > targetOutbound {
+		return staleTipEvict
+	}
+	return staleTipNone
+}
+
+// handleStaleTipCheck opens an extra outbound connection while the best chain
+// tip is stale or the chain is not current in case the current outbound peers
+// are not relaying new blocks.  Once the tip advances again, the extra
+// outbound peer which least recently relayed a new block is disconnected.  It
+// is invoked from the peerHandler goroutine.
+func (s *server) handleStaleTipCheck(state *peerState) {
+	// Blocks are only mined on demand on the regression and simulation
+	// test networks, so there is nothing to check there.
+	if cfg.RegressionTest || cfg.SimNet {
+		return
+	}
+
+	var fullRelay []*serverPeer
+	for _, sp := range state.outboundPeers {
+		if sp.connReq != nil && sp.connReq.Type == connmgr.ConnTypeFullRelay {
+			fullRelay = append(fullRelay, sp)
+		}
+	}
+
+	action := selectStaleTipAction(s.isTipStale(), s.chain.IsCurrent(),
+		len(fullRelay), s.targetOutbound)
+	switch action {
+	case staleTipConnect:
+		srvrLog.Infof("Trying an extra outbound peer since the best "+
+			"chain tip %v may be stale or lack work",
+			s.chain.BestSnapshot().Hash)
+		go s.connManager.NewConnReq()
+		return
+
+	case staleTipNone:
+		return
+	}
+
+	// Disconnect the peer which least recently relayed a new block,
+	// preferring the most recently connected one.
+	var evict *serverPeer
+	var evictLastBlock int64
+	for _, sp := range fullRelay {
+		lastBlock := atomic.LoadInt64(&sp.lastBlockTime)
+		if evict == nil || lastBlock < evictLastBlock ||
+			(lastBlock == evictLastBlock && sp.ID() > evict.ID()) {
+
+			evict = sp
+			evictLastBlock = lastBlock
+		}
+	}
+	srvrLog.Infof("Disconnecting extra outbound peer %s", evict)
+	evict.Disconnect()
+}
+
 // handleDonePeerMsg deals with peers that have signalled they are done.  It is
 // invoked from the peerHandler goroutine.
 func (s *server) handleDonePeerMsg(state *peerState, sp *serverPeer) {
+	// Fall back to the v1 transport when connecting to the address again
+	// if the v2 transport handshake with the peer failed.
+	if sp.triedV2 && !sp.V2Transport() && !sp.VersionKnown() {
+		s.v1OnlyAddrs.add(sp.connReq.Addr.String(), time.Now())
+		srvrLog.Debugf("Falling back to the v1 transport for %s", sp)
+	}
+
 	var list map[int32]*serverPeer
 	if sp.persistent {
 		list = state.persistentPeers
@@ -1653,7 +2346,7 @@ func (s *server) handleDonePeerMsg(state *peerState, sp *serverPeer) {
 	}
 	if _, ok := list[sp.ID()]; ok {
 		if !sp.Inbound() && sp.VersionKnown() {
-			state.outboundGroups[addrmgr.GroupKey(sp.NA())]--
+			state.outboundGroups[s.addrManager.GroupKey(sp.NA())]--
 		}
 		if !sp.Inbound() && sp.connReq != nil {
 			s.connManager.Disconnect(sp.connReq.ID())
@@ -1694,11 +2387,56 @@ func (s *server) handleBanPeerMsg(state *peerState, sp *serverPeer) {
 // handleRelayInvMsg deals with relaying inventory to peers that are not already
 // known to have it.  It is invoked from the peerHandler goroutine.
 func (s *server) handleRelayInvMsg(state *peerState, msg relayMsg) {
+	// Compact blocks are created on demand for the peers which requested
+	// new blocks to be announced that way and are shared among all peers
+	// of the same compact block version.
+	var cmpctBlock *btcutil.Block
+	cmpctBlocks := make(map[uint64]*wire.MsgCmpctBlock)
 	state.forAllPeers(func(sp *serverPeer) {
 		if !sp.Connected() {
 			return
 		}
 
+		// If the inventory is a block and the peer requested
+		// high-bandwidth compact block relay, send the block as a
+		// compact block straight away.
+		if msg.invVect.Type == wire.InvTypeBlock && sp.WantsCmpctBlocks() {
+			if sp.IsKnownInventory(msg.invVect) {
+				return
+			}
+
+			version := sp.CmpctBlockVersion()
+			cmsg, ok := cmpctBlocks[version]
+			if !ok {
+				var err error
+				if cmpctBlock == nil {
+					cmpctBlock, err = s.chain.BlockByHash(
+						&msg.invVect.Hash)
+					if err != nil {
+						peerLog.Errorf("Failed to fetch "+
+							"block %v for relay: %v",
+							msg.invVect.Hash, err)
+						return
+					}
+				}
+				cmsg, err = newCmpctBlock(cmpctBlock, version)
+				if err != nil {
+					peerLog.Errorf("Failed to create compact "+
+						"block: %v", err)
+					return
+				}
+				cmpctBlocks[version] = cmsg
+			}
+
+			encoding := wire.BaseEncoding
+			if version == wire.CmpctBlockVersionWTxID {
+				encoding = wire.WitnessEncoding
+			}
+			sp.AddKnownInventory(msg.invVect)
+			sp.QueueMessageWithEncoding(cmsg, nil, encoding)
+			return
+		}
+
 		// If the inventory is a block and the peer prefers headers,
 		// generate and send a headers message instead of an inventory
 		// message.
@@ -1748,6 +2486,13 @@ func (s *server) handleRelayInvMsg(state *peerState, msg relayMsg) {
 					return
 				}
 			}
+
+			// Announce the transaction by witness transaction hash
+			// when the peer negotiated wtxid relay.
+			if sp.WTxIDRelay() {
+				sp.QueueInventory(sp.txInvVect(txD.Tx))
+				return
+			}
 		}
 
 		// Queue the inventory to be relayed with the next batch.
@@ -1859,13 +2604,14 @@ func (s *server) handleQuery(state *peerState, querymsg interface{}) {
 		go s.connManager.Connect(&connmgr.ConnReq{
 			Addr:      netAddr,
 			Permanent: msg.permanent,
+			Type:      connmgr.ConnTypeManual,
 		})
 		msg.reply <- nil
 	case removeNodeMsg:
 		found := disconnectPeer(state.persistentPeers, msg.cmp, func(sp *serverPeer) {
 			// Keep group counts ok since we remove from
 			// the list now.
-			state.outboundGroups[addrmgr.GroupKey(sp.NA())]--
+			state.outboundGroups[s.addrManager.GroupKey(sp.NA())]--
 		})
 
 		if found {
@@ -1901,7 +2647,7 @@ func (s *server) handleQuery(state *peerState, querymsg interface{}) {
 		found = disconnectPeer(state.outboundPeers, msg.cmp, func(sp *serverPeer) {
 			// Keep group counts ok since we remove from
 			// the list now.
-			state.outboundGroups[addrmgr.GroupKey(sp.NA())]--
+			state.outboundGroups[s.addrManager.GroupKey(sp.NA())]--
 		})
 		if found {
 			// If there are multiple outbound connections to the same
@@ -1909,7 +2655,7 @@ func (s *server) handleQuery(state *peerState, querymsg interface{}) {
 			// peers are found.
 			for found {
 				found = disconnectPeer(state.outboundPeers, msg.cmp, func(sp *serverPeer) {
-					state.outboundGroups[addrmgr.GroupKey(sp.NA())]--
+					state.outboundGroups[s.addrManager.GroupKey(sp.NA())]--
 				})
 			}
 			msg.reply <- nil
@@ -1952,6 +2698,9 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 			OnMemPool:      sp.OnMemPool,
 			OnTx:           sp.OnTx,
 			OnBlock:        sp.OnBlock,
+			OnCmpctBlock:   sp.OnCmpctBlock,
+			OnGetBlockTxn:  sp.OnGetBlockTxn,
+			OnBlockTxn:     sp.OnBlockTxn,
 			OnInv:          sp.OnInv,
 			OnHeaders:      sp.OnHeaders,
 			OnGetData:      sp.OnGetData,
@@ -1966,6 +2715,7 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 			OnFilterLoad:   sp.OnFilterLoad,
 			OnGetAddr:      sp.OnGetAddr,
 			OnAddr:         sp.OnAddr,
+			OnAddrV2:       sp.OnAddrV2,
 			OnRead:         sp.OnRead,
 			OnWrite:        sp.OnWrite,
 
@@ -1983,9 +2733,10 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 		UserAgentComments: cfg.UserAgentComments,
 		ChainParams:       sp.server.chainParams,
 		Services:          sp.server.services,
-		DisableRelayTx:    cfg.BlocksOnly,
+		DisableRelayTx:    cfg.BlocksOnly || sp.isBlockRelayOnly(),
 		ProtocolVersion:   peer.MaxProtocolVersion,
 		TrickleInterval:   cfg.TrickleInterval,
+		V2Transport:       cfg.V2Transport,
 	}
 }
 
@@ -1995,12 +2746,34 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 // for disconnection.
 func (s *server) inboundPeerConnected(conn net.Conn) {
 	sp := newServerPeer(s, false)
-	sp.isWhitelisted = isWhitelisted(conn.RemoteAddr())
+	sp.permissions = whitelistPermissions(conn.RemoteAddr())
 	sp.Peer = peer.NewInboundPeer(newPeerConfig(sp))
 	sp.AssociateConnection(conn)
 	go s.peerDoneHandler(sp)
 }
 
+// useV2Transport returns whether an outbound connection for the passed
+// connection request should attempt the v2 transport handshake.  Automatic
+// connections only try it with addresses known to advertise SFNodeP2PV2,
+// while permanent connections, whose services are usually unknown, always
+// try it.  Addresses which recently failed the handshake use the v1
+// transport.
+func (s *server) useV2Transport(c *connmgr.ConnReq) bool {
+	addr := c.Addr.String()
+	if s.v1OnlyAddrs.contains(addr, time.Now()) {
+		return false
+	}
+	if c.Permanent {
+		return true
+	}
+
+	na, err := s.addrManager.DeserializeNetAddress(addr, 0)
+	if err != nil {
+		return false
+	}
+	return s.addrManager.Services(na)&wire.SFNodeP2PV2 == wire.SFNodeP2PV2
+}
+
 // outboundPeerConnected is invoked by the connection manager when a new
 // outbound connection is established.  It initializes a new outbound server
 // peer instance, associates it with the relevant state such as the connection
@@ -2008,14 +2781,19 @@ func (s *server) inboundPeerConnected(conn net.Conn) {
 // manager of the attempt.
 func (s *server) outboundPeerConnected(c *connmgr.ConnReq, conn net.Conn) {
 	sp := newServerPeer(s, c.Permanent)
-	p, err := peer.NewOutboundPeer(newPeerConfig(sp), c.Addr.String())
+	sp.connReq = c
+	peerCfg := newPeerConfig(sp)
+	if peerCfg.V2Transport {
+		peerCfg.V2Transport = s.useV2Transport(c)
+	}
+	sp.triedV2 = peerCfg.V2Transport
+	p, err := peer.NewOutboundPeer(peerCfg, c.Addr.String())
 	if err != nil {
 		srvrLog.Debugf("Cannot create outbound peer %s: %v", c.Addr, err)
 		s.connManager.Disconnect(c.ID())
 	}
 	sp.Peer = p
-	sp.connReq = c
-	sp.isWhitelisted = isWhitelisted(conn.RemoteAddr())
+	sp.permissions = whitelistPermissions(conn.RemoteAddr())
 	sp.AssociateConnection(conn)
 	go s.peerDoneHandler(sp)
 	s.addrManager.Attempt(sp.NA())
@@ -2028,7 +2806,8 @@ func (s *server) peerDoneHandler(sp *serverPeer) {
 	s.donePeers <- sp
 
 	// Only tell sync manager we are gone if we ever told it we existed.
-	if sp.VersionKnown() {
+	// Feelers are disconnected before the sync manager learns about them.
+	if sp.VersionKnown() && !sp.isFeeler() {
 		s.syncManager.DonePeer(sp.Peer)
 
 		// Evict any remaining orphans that were sent by the peer.
@@ -2056,6 +2835,9 @@ func (s *server) peerHandler() {
 
 	srvrLog.Tracef("Starting peer handler")
 
+	staleTipTicker := time.NewTicker(staleTipCheckInterval)
+	defer staleTipTicker.Stop()
+
 	state := &peerState{
 		inboundPeers:    make(map[int32]*serverPeer),
 		persistentPeers: make(map[int32]*serverPeer),
@@ -2067,7 +2849,7 @@ func (s *server) peerHandler() {
 	if !cfg.DisableDNSSeed {
 		// Add peers discovered through DNS to the address manager.
 		connmgr.SeedFromDNS(activeNetParams.Params, defaultRequiredServices,
-			btcdLookup, func(addrs []*wire.NetAddress) {
+			btcdLookup, func(addrs []*wire.NetAddressV2) {
 				// Bitcoind uses a lookup of the dns seeder here. This
 				// is rather strange since the values looked up by the
 				// DNS seed lookups will vary quite a lot.
@@ -2109,7 +2891,18 @@ out:
 		case qmsg := <-s.query:
 			s.handleQuery(state, qmsg)
 
+		case <-staleTipTicker.C:
+			s.handleStaleTipCheck(state)
+
 		case <-s.quit:
+			// Remember the block-relay-only peers so they are
+			// reconnected to on the next start.
+			if s.connManager != nil && !cfg.SimNet &&
+				len(cfg.ConnectPeers) == 0 {
+
+				saveAnchors(cfg.DataDir, state.anchorAddrs())
+			}
+
 			// Disconnect all peers on server shutdown.
 			state.forAllPeers(func(sp *serverPeer) {
 				srvrLog.Tracef("Shutdown peer %s", sp)
@@ -2123,6 +2916,19 @@ out:
 	s.syncManager.Stop()
 	s.addrManager.Stop()
 
+	// Write the utxo set changes held in memory to the database now that
+	// no more blocks are processed so they don't have to be replayed on
+	// the next start.
+	if err := s.chain.FlushUtxoCache(); err != nil {
+		srvrLog.Errorf("Unable to flush the utxo cache: %v", err)
+	}
+	if s.bgChain != nil {
+		if err := s.bgChain.FlushUtxoCache(); err != nil {
+			srvrLog.Errorf("Unable to flush the utxo cache of the "+
+				"background chain: %v", err)
+		}
+	}
+
 	// Drain channels before exiting so nothing is left waiting around
 	// to send.
 cleanup:
@@ -2188,6 +2994,7 @@ func (s *server) OutboundGroupCount(key string) int {
 // for the server.  It is safe for concurrent access.
 func (s *server) AddBytesSent(bytesSent uint64) {
 	atomic.AddUint64(&s.bytesSent, bytesSent)
+	s.uploadTarget.AddBytesSent(bytesSent)
 }
 
 // AddBytesReceived adds the passed number of bytes to the total bytes received
@@ -2203,6 +3010,44 @@ func (s *server) NetTotals() (uint64, uint64) {
 		atomic.LoadUint64(&s.bytesSent)
 }
 
+// handleBlockchainNotification records the time the best chain tip last
+// changed so stale tips can be detected.
+func (s *server) handleBlockchainNotification(notification *blockchain.Notification) {
+	if notification.Type == blockchain.NTBlockConnected {
+		atomic.StoreInt64(&s.lastTipTime, time.Now().UnixNano())
+	}
+}
+
+// isTipStale returns whether no new best chain tip has been seen for the
+// duration of staleTipBlocks target block intervals.  Blocks are only mined on
+// demand on the regression and simulation test networks, so their tips are
+// never considered stale.  It is safe for concurrent access.
+func (s *server) isTipStale() bool {
+	if cfg.RegressionTest || cfg.SimNet {
+		return false
+	}
+	lastTip := time.Unix(0, atomic.LoadInt64(&s.lastTipTime))
+	return isStaleTip(lastTip, time.Now(), s.chainParams.TargetTimePerBlock)
+}
+
+// isStaleTip returns whether a best chain tip last seen at the passed time is
+// stale at the passed current time, which is the case once no new tip has been
+// seen for staleTipBlocks of the passed target block intervals.
+func isStaleTip(lastTip, now time.Time, targetTimePerBlock time.Duration) bool {
+	return now.Sub(lastTip) > staleTipBlocks*targetTimePerBlock
+}
+
+// staleTipWarning returns a warning when the best chain tip is stale or an
+// empty string otherwise.  It is safe for concurrent access.
+func (s *server) staleTipWarning() string {
+	if !s.isTipStale() {
+		return ""
+	}
+	lastTip := time.Unix(0, atomic.LoadInt64(&s.lastTipTime))
+	return fmt.Sprintf("No new block has been received for %v, the best "+
+		"chain tip may be stale", time.Since(lastTip).Truncate(time.Second))
+}
+
 // UpdatePeerHeights updates the heights of all peers who have have announced
 // the latest connected main chain block, or a recognized orphan. These height
 // updates allow us to dynamically refresh peer heights, ensuring sync peer
@@ -2460,7 +3305,7 @@ out:
 					srvrLog.Warnf("UPnP can't get external address: %v", err)
 					continue out
 				}
-				na := wire.NewNetAddressIPPort(externalip, uint16(listenPort),
+				na := wire.NewNetAddressV2IPPort(externalip, uint16(listenPort),
 					s.services)
 				err = s.addrManager.AddLocalAddress(na, addrmgr.UpnpPrio)
 				if err != nil {
@@ -2538,16 +3383,47 @@ func setupRPCListeners() ([]net.Listener, error) {
 // newServer returns a new btcd server configured to listen on addr for the
 // bitcoin network type specified by chainParams.  Use start to begin accepting
 // connections from peers.
-func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Params, interrupt <-chan struct{}) (*server, error) {
+func newServer(listenAddrs []string, db, snapshotDB database.DB, chainParams *chaincfg.Params, interrupt <-chan struct{}) (*server, error) {
+	// The chain state bootstrapped from a utxo snapshot is the active one
+	// when there is one, while the history up to its base block is
+	// validated in the background on the original database.  The blocks
+	// before the base block are not available to serve to peers or to
+	// build the optional indexes from.
+	historyDB := db
 	services := defaultServices
+	if snapshotDB != nil {
+		db = snapshotDB
+		services &^= wire.SFNodeNetwork
+		if cfg.TxIndex || cfg.AddrIndex {
+			return nil, errors.New("the txindex and addrindex " +
+				"options can't be used while running from a " +
+				"utxo snapshot")
+		}
+		if !cfg.NoCFilters {
+			srvrLog.Warnf("Committed filters are disabled while " +
+				"running from a utxo snapshot")
+			cfg.NoCFilters = true
+		}
+	}
 	if cfg.NoPeerBloomFilters {
 		services &^= wire.SFNodeBloom
 	}
 	if cfg.NoCFilters {
 		services &^= wire.SFNodeCF
 	}
+	if cfg.V2Transport {
+		services |= wire.SFNodeP2PV2
+	}
 
 	amgr := addrmgr.New(cfg.DataDir, btcdLookup)
+	if cfg.ASMap != "" {
+		asmap, err := addrmgr.LoadASMap(cfg.ASMap)
+		if err != nil {
+			return nil, err
+		}
+		amgr.UseASMap(asmap)
+		srvrLog.Infof("Using asmap %s to group peers", cfg.ASMap)
+	}
 
 	var listeners []net.Listener
 	var nat NAT
@@ -2581,6 +3457,13 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 		sigCache:             txscript.NewSigCache(cfg.SigCacheMaxSize),
 		hashCache:            txscript.NewHashCache(cfg.SigCacheMaxSize),
 		cfCheckptCaches:      make(map[wire.FilterType][]cfHeaderKV),
+		v1OnlyAddrs:          newV1OnlyAddrSet(maxV1OnlyAddrs),
+		relayCache:           make(map[chainhash.Hash]relayCacheEntry),
+		uploadTarget:         newUploadTarget(cfg.MaxUploadTarget * 1024 * 1024),
+		lastTipTime:          time.Now().UnixNano(),
+	}
+	if _, err := rand.Read(s.netGroupKey[:]); err != nil {
+		return nil, err
 	}
 
 	// Create the transaction and address indexes if needed.
@@ -2626,23 +3509,51 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 	if !cfg.DisableCheckpoints {
 		checkpoints = mergeCheckpoints(s.chainParams.Checkpoints, cfg.addCheckpoints)
 	}
+	if cfg.assumeValid != nil {
+		srvrLog.Infof("Assuming block %v and its ancestors have valid "+
+			"scripts", cfg.assumeValid)
+	}
 
 	// Create a new block chain instance with the appropriate configuration.
 	var err error
 	s.chain, err = blockchain.New(&blockchain.Config{
-		DB:           s.db,
-		Interrupt:    interrupt,
-		ChainParams:  s.chainParams,
-		Checkpoints:  checkpoints,
-		TimeSource:   s.timeSource,
-		SigCache:     s.sigCache,
-		IndexManager: indexManager,
-		HashCache:    s.hashCache,
+		DB:               s.db,
+		Interrupt:        interrupt,
+		ChainParams:      s.chainParams,
+		Checkpoints:      checkpoints,
+		AssumeValid:      cfg.assumeValid,
+		TimeSource:       s.timeSource,
+		SigCache:         s.sigCache,
+		IndexManager:     indexManager,
+		HashCache:        s.hashCache,
+		UtxoCacheMaxSize: uint64(cfg.UtxoCacheMaxSizeMiB) * 1024 * 1024,
 	})
 	if err != nil {
 		return nil, err
 	}
 
+	s.chain.Subscribe(s.handleBlockchainNotification)
+
+	// Create the chain instance which validates the history up to the base
+	// block of the utxo snapshot in the background unless it already has
+	// been.
+	if snapshotDB != nil && !s.chain.IsSnapshotValidated() {
+		s.bgChain, err = blockchain.New(&blockchain.Config{
+			DB:               historyDB,
+			Interrupt:        interrupt,
+			ChainParams:      s.chainParams,
+			Checkpoints:      checkpoints,
+			AssumeValid:      cfg.assumeValid,
+			TimeSource:       s.timeSource,
+			SigCache:         s.sigCache,
+			HashCache:        s.hashCache,
+			UtxoCacheMaxSize: uint64(cfg.UtxoCacheMaxSizeMiB) * 1024 * 1024,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Search for a FeeEstimator state in the database. If none can be found
 	// or if it cannot be loaded, create a new one.
 	db.Update(func(tx database.Tx) error {
@@ -2707,6 +3618,12 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 		DisableCheckpoints: cfg.DisableCheckpoints,
 		MaxPeers:           cfg.MaxPeers,
 		FeeEstimator:       s.feeEstimator,
+		BackgroundChain:    s.bgChain,
+		RequestShutdown: func() {
+			go func() {
+				shutdownRequestChannel <- struct{}{}
+			}()
+		},
 	})
 	if err != nil {
 		return nil, err
@@ -2732,6 +3649,7 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 		ChainParams:            chainParams,
 		BlockTemplateGenerator: blockTemplateGenerator,
 		MiningAddrs:            cfg.miningAddrs,
+		SignetKeys:             cfg.signetKeys,
 		ProcessBlock:           s.syncManager.ProcessBlock,
 		ConnectedCount:         s.ConnectedCount,
 		IsCurrent:              s.syncManager.IsCurrent,
@@ -2758,20 +3676,27 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 				// in the same group so that we are not connecting
 				// to the same network segment at the expense of
 				// others.
-				key := addrmgr.GroupKey(addr.NetAddress())
+				key := s.addrManager.GroupKey(addr.NetAddress())
 				if s.OutboundGroupCount(key) != 0 {
 					continue
 				}
 
+				// Skip addresses of networks we can't connect to.
+				if !isReachable(addr.NetAddress()) {
+					continue
+				}
+
 				// only allow recent nodes (10mins) after we failed 30
 				// times
 				if tries < 30 && time.Since(addr.LastAttempt()) < 10*time.Minute {
 					continue
 				}
 
-				// allow nondefault ports after 50 failed tries.
+				// allow nondefault ports after 50 failed tries.  I2P
+				// doesn't use ports so those addresses are exempt.
 				if tries < 50 && fmt.Sprintf("%d", addr.NetAddress().Port) !=
-					activeNetParams.DefaultPort {
+					activeNetParams.DefaultPort &&
+					!addrmgr.IsI2P(addr.NetAddress()) {
 					continue
 				}
 
@@ -2783,24 +3708,75 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 		}
 	}
 
+	// Feeler connections are made to addresses in the new table of the
+	// address manager in order to move the reachable ones to the tried
+	// table, which makes it harder for an attacker to fill the tables.
+	var feelerAddressFunc func() (net.Addr, error)
+	if newAddressFunc != nil {
+		feelerAddressFunc = func() (net.Addr, error) {
+			for tries := 0; tries < 50; tries++ {
+				addr := s.addrManager.GetNewTableAddress()
+				if addr == nil {
+					break
+				}
+
+				// Skip addresses in groups which are already
+				// connected to and of networks we can't connect
+				// to.
+				key := s.addrManager.GroupKey(addr.NetAddress())
+				if s.OutboundGroupCount(key) != 0 {
+					continue
+				}
+				if !isReachable(addr.NetAddress()) {
+					continue
+				}
+
+				// Don't retry addresses which were recently
+				// attempted.
+				if time.Since(addr.LastAttempt()) < 10*time.Minute {
+					continue
+				}
+
+				addrString := addrmgr.NetAddressKey(addr.NetAddress())
+				return addrStringToNetAddr(addrString)
+			}
+
+			return nil, errors.New("no valid feeler address")
+		}
+	}
+
 	// Create a connection manager.
 	targetOutbound := defaultTargetOutbound
 	if cfg.MaxPeers < targetOutbound {
 		targetOutbound = cfg.MaxPeers
 	}
+	var targetBlockRelay int
+	var anchors []net.Addr
+	if newAddressFunc != nil {
+		targetBlockRelay = defaultTargetBlockRelay
+		if cfg.MaxPeers-targetOutbound < targetBlockRelay {
+			targetBlockRelay = cfg.MaxPeers - targetOutbound
+		}
+		anchors = loadAnchors(cfg.DataDir, targetBlockRelay)
+	}
 	cmgr, err := connmgr.New(&connmgr.Config{
-		Listeners:      listeners,
-		OnAccept:       s.inboundPeerConnected,
-		RetryDuration:  connectionRetryInterval,
-		TargetOutbound: uint32(targetOutbound),
-		Dial:           btcdDial,
-		OnConnection:   s.outboundPeerConnected,
-		GetNewAddress:  newAddressFunc,
+		Listeners:        listeners,
+		OnAccept:         s.inboundPeerConnected,
+		RetryDuration:    connectionRetryInterval,
+		TargetOutbound:   uint32(targetOutbound),
+		TargetBlockRelay: uint32(targetBlockRelay),
+		Anchors:          anchors,
+		FeelerInterval:   feelerInterval,
+		Dial:             btcdDial,
+		OnConnection:     s.outboundPeerConnected,
+		GetNewAddress:    newAddressFunc,
+		GetFeelerAddress: feelerAddressFunc,
 	})
 	if err != nil {
 		return nil, err
 	}
 	s.connManager = cmgr
+	s.targetOutbound = targetOutbound
 
 	// Start up persistent peers.
 	permanentPeers := cfg.ConnectPeers
@@ -2816,6 +3792,7 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 		go s.connManager.Connect(&connmgr.ConnReq{
 			Addr:      netAddr,
 			Permanent: true,
+			Type:      connmgr.ConnTypeManual,
 		})
 	}
 
@@ -2939,10 +3916,26 @@ func initListeners(amgr *addrmgr.AddrManager, listenAddrs []string, services wir
 	return listeners, nat, nil
 }
 
+// isReachable returns whether the passed address belongs to a network this
+// node is configured to connect to.  Tor addresses are reachable unless
+// --noonion is specified, while I2P and CJDNS addresses require --i2pproxy and
+// --cjdnsreachable respectively.
+func isReachable(na *wire.NetAddressV2) bool {
+	switch {
+	case addrmgr.IsTor(na):
+		return !cfg.NoOnion
+	case addrmgr.IsI2P(na):
+		return cfg.I2PProxy != ""
+	case addrmgr.IsCJDNS(na):
+		return cfg.CJDNSReachable
+	}
+	return true
+}
+
 // addrStringToNetAddr takes an address in the form of 'host:port' and returns
 // a net.Addr which maps to the original address with any host names resolved
-// to IP addresses.  It also handles tor addresses properly by returning a
-// net.Addr that encapsulates the address.
+// to IP addresses.  It also handles tor and i2p addresses properly by
+// returning a net.Addr that encapsulates the address.
 func addrStringToNetAddr(addr string) (net.Addr, error) {
 	host, strPort, err := net.SplitHostPort(addr)
 	if err != nil {
@@ -2972,6 +3965,16 @@ func addrStringToNetAddr(addr string) (net.Addr, error) {
 		return &onionAddr{addr: addr}, nil
 	}
 
+	// I2P addresses cannot be resolved to an IP either and are dialed
+	// through the I2P proxy.
+	if strings.HasSuffix(host, ".i2p") {
+		if cfg.I2PProxy == "" {
+			return nil, errors.New("i2p proxy is not configured")
+		}
+
+		return simpleAddr{net: "tcp", addr: addr}, nil
+	}
+
 	// Attempt to look up an IP address associated with the parsed host.
 	ips, err := btcdLookup(host)
 	if err != nil {
@@ -3018,7 +4021,7 @@ func addLocalAddress(addrMgr *addrmgr.AddrManager, addr string, services wire.Se
 				continue
 			}
 
-			netAddr := wire.NewNetAddressIPPort(ifaceIP, uint16(port), services)
+			netAddr := wire.NewNetAddressV2IPPort(ifaceIP, uint16(port), services)
 			addrMgr.AddLocalAddress(netAddr, addrmgr.BoundPrio)
 		}
 	} else {
@@ -3055,30 +4058,31 @@ func dynamicTickDuration(remaining time.Duration) time.Duration {
 	return time.Hour
 }
 
-// isWhitelisted returns whether the IP address is included in the whitelisted
-// networks and IPs.
-func isWhitelisted(addr net.Addr) bool {
+// whitelistPermissions returns the permissions granted by all of the
+// whitelisted networks and IPs which include the IP address.
+func whitelistPermissions(addr net.Addr) peerPermissions {
 	if len(cfg.whitelists) == 0 {
-		return false
+		return 0
 	}
 
 	host, _, err := net.SplitHostPort(addr.String())
 	if err != nil {
 		srvrLog.Warnf("Unable to SplitHostPort on '%s': %v", addr, err)
-		return false
+		return 0
 	}
 	ip := net.ParseIP(host)
 	if ip == nil {
 		srvrLog.Warnf("Unable to parse IP '%s'", addr)
-		return false
+		return 0
 	}
 
-	for _, ipnet := range cfg.whitelists {
-		if ipnet.Contains(ip) {
-			return true
+	var perms peerPermissions
+	for _, wl := range cfg.whitelists {
+		if wl.ipnet.Contains(ip) {
+			perms |= wl.perms
 		}
 	}
-	return false
+	return perms
 }
 
 // checkpointSorter implements sort.Interface to allow a slice of checkpoints to
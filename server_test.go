@@ -0,0 +1,198 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/organicbitcoin/obtcd/addrmgr"
+	"github.com/organicbitcoin/obtcd/connmgr"
+	"github.com/organicbitcoin/obtcd/wire"
+)
+
+// TestStaleTipCheck ensures the stale tip check tries an extra outbound peer
+// while the best chain tip is stale or the chain is not current, such as when
+// it has less than the minimum chain work, and disconnects the extra outbound
+// peers once the chain is current with a fresh tip again.
+func TestStaleTipCheck(t *testing.T) {
+	const targetOutbound = 8
+	targetTimePerBlock := time.Minute * 10
+	now := time.Now()
+
+	tests := []struct {
+		name         string
+		lastTipAge   time.Duration // time since the last new tip
+		current      bool          // whether the chain is current
+		numFullRelay int           // number of full relay outbound peers
+		stale        bool          // expected staleness of the tip
+		want         staleTipAction
+	}{{
+		name:         "fresh tip at target",
+		lastTipAge:   targetTimePerBlock,
+		current:      true,
+		numFullRelay: targetOutbound,
+		stale:        false,
+		want:         staleTipNone,
+	}, {
+		name:         "tip at staleness window",
+		lastTipAge:   staleTipBlocks * targetTimePerBlock,
+		current:      true,
+		numFullRelay: targetOutbound,
+		stale:        false,
+		want:         staleTipNone,
+	}, {
+		name:         "stale tip at target",
+		lastTipAge:   staleTipBlocks*targetTimePerBlock + time.Second,
+		current:      true,
+		numFullRelay: targetOutbound,
+		stale:        true,
+		want:         staleTipConnect,
+	}, {
+		name:         "stale tip below target",
+		lastTipAge:   time.Hour * 2,
+		current:      true,
+		numFullRelay: targetOutbound - 1,
+		stale:        true,
+		want:         staleTipNone,
+	}, {
+		name:         "stale tip with extra peer",
+		lastTipAge:   time.Hour * 2,
+		current:      true,
+		numFullRelay: targetOutbound + 1,
+		stale:        true,
+		want:         staleTipNone,
+	}, {
+		name:         "not enough work at target",
+		lastTipAge:   targetTimePerBlock,
+		current:      false,
+		numFullRelay: targetOutbound,
+		stale:        false,
+		want:         staleTipConnect,
+	}, {
+		name:         "not enough work below target",
+		lastTipAge:   targetTimePerBlock,
+		current:      false,
+		numFullRelay: targetOutbound - 1,
+		stale:        false,
+		want:         staleTipNone,
+	}, {
+		name:         "not enough work with extra peer",
+		lastTipAge:   targetTimePerBlock,
+		current:      false,
+		numFullRelay: targetOutbound + 1,
+		stale:        false,
+		want:         staleTipNone,
+	}, {
+		name:         "fresh tip with extra peer",
+		lastTipAge:   targetTimePerBlock,
+		current:      true,
+		numFullRelay: targetOutbound + 1,
+		stale:        false,
+		want:         staleTipEvict,
+	}}
+
+	for _, test := range tests {
+		stale := isStaleTip(now.Add(-test.lastTipAge), now,
+			targetTimePerBlock)
+		if stale != test.stale {
+			t.Errorf("%s: unexpected staleness -- got %v, want %v",
+				test.name, stale, test.stale)
+			continue
+		}
+
+		got := selectStaleTipAction(stale, test.current,
+			test.numFullRelay, targetOutbound)
+		if got != test.want {
+			t.Errorf("%s: unexpected action -- got %v, want %v",
+				test.name, got, test.want)
+		}
+	}
+}
+
+// TestV1OnlyAddrSet ensures addresses which failed the v2 transport handshake
+// expire and that the set never grows beyond its limit.
+func TestV1OnlyAddrSet(t *testing.T) {
+	const limit = 3
+	now := time.Now()
+	set := newV1OnlyAddrSet(limit)
+
+	set.add("1.2.3.4:8333", now)
+	if !set.contains("1.2.3.4:8333", now) {
+		t.Fatal("added address is not in the set")
+	}
+	if set.contains("1.2.3.4:8333", now.Add(v1OnlyAddrExpiry)) {
+		t.Fatal("address did not expire")
+	}
+
+	// Filling the set beyond its limit evicts the address which expires
+	// first.
+	for i := 0; i < limit; i++ {
+		set.add(fmt.Sprintf("10.0.0.%d:8333", i),
+			now.Add(time.Duration(i+1)*time.Second))
+	}
+	if len(set.expires) != limit {
+		t.Fatalf("set size: got %d, want %d", len(set.expires), limit)
+	}
+	if set.contains("1.2.3.4:8333", now) {
+		t.Fatal("oldest address was not evicted")
+	}
+
+	// Expired addresses are pruned when a new address is added.
+	set.add("5.6.7.8:8333", now.Add(v1OnlyAddrExpiry+time.Hour))
+	if len(set.expires) != 1 {
+		t.Fatalf("set size after expiry: got %d, want 1",
+			len(set.expires))
+	}
+}
+
+// TestUseV2Transport ensures the v2 transport is only tried with automatic
+// connections to addresses advertising SFNodeP2PV2 and with permanent
+// connections, unless the address recently failed the v2 handshake.
+func TestUseV2Transport(t *testing.T) {
+	s := &server{
+		addrManager: addrmgr.New("testusev2transport", nil),
+		v1OnlyAddrs: newV1OnlyAddrSet(maxV1OnlyAddrs),
+	}
+	addService := func(host string, services wire.ServiceFlag) {
+		na, err := s.addrManager.HostToNetAddress(host, 8333, services)
+		if err != nil {
+			t.Fatalf("HostToNetAddress: %v", err)
+		}
+		s.addrManager.AddAddress(na, na)
+	}
+	addService("173.194.115.66", wire.SFNodeNetwork|wire.SFNodeP2PV2)
+	addService("173.194.115.67", wire.SFNodeNetwork)
+	s.v1OnlyAddrs.add("173.194.115.68:8333", time.Now())
+	addService("173.194.115.68", wire.SFNodeNetwork|wire.SFNodeP2PV2)
+
+	tests := []struct {
+		name      string
+		ip        string
+		permanent bool
+		want      bool
+	}{
+		{"advertises v2", "173.194.115.66", false, true},
+		{"does not advertise v2", "173.194.115.67", false, false},
+		{"unknown address", "173.194.115.69", false, false},
+		{"permanent unknown address", "173.194.115.69", true, true},
+		{"failed v2 handshake", "173.194.115.68", false, false},
+		{"permanent failed v2 handshake", "173.194.115.68", true, false},
+	}
+	for _, test := range tests {
+		c := &connmgr.ConnReq{
+			Addr: &net.TCPAddr{
+				IP:   net.ParseIP(test.ip),
+				Port: 8333,
+			},
+			Permanent: test.permanent,
+		}
+		if got := s.useV2Transport(c); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
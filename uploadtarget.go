@@ -0,0 +1,146 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/organicbitcoin/obtcd/wire"
+)
+
+const (
+	// uploadTargetTimeframe is the duration of the cycles the upload
+	// target applies to.
+	uploadTargetTimeframe = time.Hour * 24
+
+	// historicalBlockAge is the age relative to the best chain tip after
+	// which blocks are considered historical.  Historical blocks are no
+	// longer served to peers without the download permission once the
+	// upload target is about to be reached.
+	historicalBlockAge = time.Hour * 24 * 7
+)
+
+// uploadTarget keeps track of the number of bytes sent to peers in the current
+// cycle in order to keep them under a target.
+//
+// Serving historical blocks is stopped early enough to leave room for serving
+// a full block every ten minutes for the remainder of the cycle, so peers that
+// are in sync keep being served new blocks.
+type uploadTarget struct {
+	mtx         sync.Mutex
+	limit       uint64
+	cycleStart  time.Time
+	sentInCycle uint64
+}
+
+// uploadTargetStatus describes the state of the upload target as reported by
+// the getnettotals RPC.
+type uploadTargetStatus struct {
+	Timeframe             time.Duration
+	Target                uint64
+	TargetReached         bool
+	ServeHistoricalBlocks bool
+	BytesLeftInCycle      uint64
+	TimeLeftInCycle       time.Duration
+}
+
+// newUploadTarget returns an upload target which limits the number of bytes
+// sent per cycle to the passed limit.  A limit of zero disables the target.
+func newUploadTarget(limit uint64) *uploadTarget {
+	return &uploadTarget{limit: limit}
+}
+
+// maybeStartCycle starts a new cycle when the current one has ended.
+//
+// This function MUST be called with the mutex held (for writes).
+func (u *uploadTarget) maybeStartCycle(now time.Time) {
+	if now.Sub(u.cycleStart) > uploadTargetTimeframe {
+		u.cycleStart = now
+		u.sentInCycle = 0
+	}
+}
+
+// timeLeftInCycle returns the time until the current cycle ends.
+//
+// This function MUST be called with the mutex held (for reads).
+func (u *uploadTarget) timeLeftInCycle(now time.Time) time.Duration {
+	if u.cycleStart.IsZero() {
+		return 0
+	}
+	left := u.cycleStart.Add(uploadTargetTimeframe).Sub(now)
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// reached returns whether the upload target is reached.  When historical is
+// set, it returns whether the target is about to be reached such that
+// historical blocks should no longer be served.
+//
+// This function MUST be called with the mutex held (for reads).
+func (u *uploadTarget) reached(now time.Time, historical bool) bool {
+	if u.limit == 0 {
+		return false
+	}
+
+	if historical {
+		// Keep enough room to serve one full block every ten minutes
+		// for the remainder of the cycle.
+		blocksLeft := uint64(u.timeLeftInCycle(now) / (time.Minute * 10))
+		buffer := blocksLeft * wire.MaxBlockPayload
+		if buffer >= u.limit || u.sentInCycle >= u.limit-buffer {
+			return true
+		}
+	}
+	return u.sentInCycle >= u.limit
+}
+
+// AddBytesSent adds the passed number of bytes to the bytes sent in the
+// current cycle.  It is safe for concurrent access.
+func (u *uploadTarget) AddBytesSent(n uint64) {
+	now := time.Now()
+	u.mtx.Lock()
+	u.maybeStartCycle(now)
+	u.sentInCycle += n
+	u.mtx.Unlock()
+}
+
+// Reached returns whether the upload target is reached.  When historical is
+// set, it returns whether the target is about to be reached such that
+// historical blocks should no longer be served.  It is safe for concurrent
+// access.
+func (u *uploadTarget) Reached(historical bool) bool {
+	now := time.Now()
+	u.mtx.Lock()
+	u.maybeStartCycle(now)
+	reached := u.reached(now, historical)
+	u.mtx.Unlock()
+	return reached
+}
+
+// Status returns the current state of the upload target.  It is safe for
+// concurrent access.
+func (u *uploadTarget) Status() *uploadTargetStatus {
+	now := time.Now()
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+
+	u.maybeStartCycle(now)
+	status := &uploadTargetStatus{
+		Timeframe:             uploadTargetTimeframe,
+		Target:                u.limit,
+		TargetReached:         u.reached(now, false),
+		ServeHistoricalBlocks: !u.reached(now, true),
+	}
+	if u.limit != 0 {
+		if u.sentInCycle < u.limit {
+			status.BytesLeftInCycle = u.limit - u.sentInCycle
+		}
+		status.TimeLeftInCycle = u.timeLeftInCycle(now)
+	}
+	return status
+}
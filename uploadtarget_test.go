@@ -0,0 +1,80 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/organicbitcoin/obtcd/wire"
+)
+
+// TestUploadTarget ensures historical blocks stop being served early enough to
+// leave room for new blocks for the rest of the cycle, the target is reached
+// once the limit is sent and a new cycle resets the counters.
+func TestUploadTarget(t *testing.T) {
+	// No limit.
+	u := newUploadTarget(0)
+	u.AddBytesSent(1 << 40)
+	if u.Reached(true) || u.Reached(false) {
+		t.Fatal("upload target without limit reached")
+	}
+
+	// A limit of a hundred full blocks leaves no room for historical
+	// blocks at the start of a cycle since a day has 144 ten minute
+	// intervals.
+	u = newUploadTarget(100 * wire.MaxBlockPayload)
+	u.AddBytesSent(1)
+	if !u.Reached(true) {
+		t.Fatal("historical blocks served without room for new blocks")
+	}
+	if u.Reached(false) {
+		t.Fatal("upload target reached before the limit was sent")
+	}
+
+	// Half way through the cycle, there is room for 72 new blocks, which
+	// leaves room for 28 historical blocks.
+	now := time.Now()
+	u.cycleStart = now.Add(-uploadTargetTimeframe / 2)
+	u.sentInCycle = 27 * wire.MaxBlockPayload
+	if u.reached(now, true) {
+		t.Fatal("historical blocks not served with room left")
+	}
+	u.sentInCycle = 28 * wire.MaxBlockPayload
+	if !u.reached(now, true) {
+		t.Fatal("historical blocks served without room left")
+	}
+
+	u.sentInCycle = 30 * wire.MaxBlockPayload
+	status := u.Status()
+	if status.TargetReached || status.ServeHistoricalBlocks {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if status.BytesLeftInCycle != 70*wire.MaxBlockPayload {
+		t.Fatalf("unexpected bytes left in cycle %d",
+			status.BytesLeftInCycle)
+	}
+	if status.TimeLeftInCycle <= 0 ||
+		status.TimeLeftInCycle > uploadTargetTimeframe/2 {
+
+		t.Fatalf("unexpected time left in cycle %v",
+			status.TimeLeftInCycle)
+	}
+
+	u.AddBytesSent(70 * wire.MaxBlockPayload)
+	if !u.Reached(false) {
+		t.Fatal("upload target not reached after sending the limit")
+	}
+
+	// The counters are reset once the cycle ends.
+	u.cycleStart = now.Add(-uploadTargetTimeframe - time.Second)
+	if u.Reached(false) {
+		t.Fatal("upload target reached in a new cycle")
+	}
+	if status := u.Status(); status.BytesLeftInCycle != u.limit {
+		t.Fatalf("unexpected bytes left in new cycle %d",
+			status.BytesLeftInCycle)
+	}
+}
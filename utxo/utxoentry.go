@@ -1,8 +1,6 @@
 package utxo
 
-import (
-	"github.com/organicbitcoin/obtcd/chaincfg"
-)
+import "math"
 
 // TxoFlags is a bitmask defining additional information and state for a
 // transaction output in a utxo view.
@@ -19,8 +17,15 @@ const (
 	// loaded.
 	TfModified
 
-	// TfExpired indicates that a txout has been expired
+	// TfExpired indicates that a txout was expired at the height it was
+	// last checked at with CheckExpired.  It is not persisted since it is
+	// relative to that height.
 	TfExpired
+
+	// TfFresh indicates that a txout is not in the database yet, so it
+	// never needs to be written when it is spent before the utxo cache of
+	// the blockchain is flushed.
+	TfFresh
 )
 
 // UtxoEntry houses details about an individual transaction output in a utxo
@@ -34,9 +39,10 @@ type UtxoEntry struct {
 	// specifically crafted to result in minimal padding.  There will be a
 	// lot of these in memory, so a few extra bytes of padding adds up.
 
-	Amount      int64
-	PkScript    []byte // The public key script for the output.
-	BlockHeight int32  // Height of block containing tx.
+	Amount       int64
+	PkScript     []byte // The public key script for the output.
+	BlockHeight  int32  // Height of block containing tx.
+	ExpiryHeight int32  // Height of the first block the output is expired in.
 
 	// packedFlags contains additional info about output such as whether it
 	// is a coinbase, whether it is spent, and whether it has been modified
@@ -57,30 +63,62 @@ func (entry *UtxoEntry) IsCoinBase() bool {
 	return entry.PackedFlags&TfCoinBase == TfCoinBase
 }
 
+// IsFresh returns whether or not the output is not in the database yet.
+func (entry *UtxoEntry) IsFresh() bool {
+	return entry.PackedFlags&TfFresh == TfFresh
+}
+
 // IsSpent returns whether or not the output has been spent based upon the
 // current state of the unspent transaction output view it was obtained from.
 func (entry *UtxoEntry) IsSpent() bool {
 	return entry.PackedFlags&TfSpent == TfSpent
 }
 
-// CheckExpired returns if utxo has expired or not by giving current height.
+// CheckExpired returns if utxo has expired or not by giving current height and
+// the valid chain length of the network.
 // Active utxo means that it's existed in the active blockchain.
 // Expired utxo means that it's not existed in the active blockchain.
-// Active blockchain keeps the latest 368208 blocks.
+// Active blockchain keeps the latest validChainLength blocks, which is 368208
+// on the main network.
 // 368208 = (7y x 365d x 24h + 2d x 24h) x 6
-func (entry *UtxoEntry) CheckExpired(txHeight int32) bool {
-	if txHeight-entry.BlockHeight > chaincfg.MainNetParams.ValidChainLength {
+//
+// The expired flag of the entry is updated to reflect the result.
+func (entry *UtxoEntry) CheckExpired(txHeight, validChainLength int32) bool {
+	if txHeight-entry.BlockHeight > validChainLength {
 		entry.Expired()
 		return true
 	}
+	entry.PackedFlags &^= TfExpired
 	return false
 }
 
 // IsExpired returns if utxo has expired from the packedFlags information.
+// The flag reflects the height the output was last checked at with
+// CheckExpired, so IsExpiredAt should be used to query the expiry of the
+// output at a given height.
 func (entry *UtxoEntry) IsExpired() bool {
 	return entry.PackedFlags&TfExpired == TfExpired
 }
 
+// IsExpiredAt returns whether the output is expired at the passed height.  It
+// is based on the expiry height of the output, so it returns false when the
+// expiry height is not known.
+func (entry *UtxoEntry) IsExpiredAt(height int32) bool {
+	return entry.ExpiryHeight != 0 && height >= entry.ExpiryHeight
+}
+
+// CalcExpiryHeight returns the height of the first block in which an output
+// created at the passed height is expired given the valid chain length of the
+// network.  It is consistent with CheckExpired.  Zero, which means the expiry
+// height is not known, is returned when it can't be represented, such as for
+// outputs of unmined transactions.
+func CalcExpiryHeight(blockHeight, validChainLength int32) int32 {
+	if blockHeight > math.MaxInt32-validChainLength-1 {
+		return 0
+	}
+	return blockHeight + validChainLength + 1
+}
+
 // Expired marks the output as expired
 func (entry *UtxoEntry) Expired() {
 	// Mark the output as expired
@@ -106,9 +144,10 @@ func (entry *UtxoEntry) Clone() *UtxoEntry {
 	}
 
 	return &UtxoEntry{
-		Amount:      entry.Amount,
-		PkScript:    entry.PkScript,
-		BlockHeight: entry.BlockHeight,
-		PackedFlags: entry.PackedFlags,
+		Amount:       entry.Amount,
+		PkScript:     entry.PkScript,
+		BlockHeight:  entry.BlockHeight,
+		ExpiryHeight: entry.ExpiryHeight,
+		PackedFlags:  entry.PackedFlags,
 	}
 }
@@ -0,0 +1,194 @@
+// Copyright (c) 2023 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package v2transport
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"math/bits"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// rekeyInterval is the number of messages encrypted with the same key
+	// before both ciphers switch to a new key derived from the old one.
+	rekeyInterval = 224
+
+	// chachaBlockSize is the size of a ChaCha20 keystream block.
+	chachaBlockSize = 64
+
+	// keySize is the size of the keys used by both ciphers.
+	keySize = 32
+)
+
+// chacha20Block computes the ChaCha20 keystream block for the passed key,
+// 96-bit nonce and block counter as specified by RFC 8439.
+func chacha20Block(key *[keySize]byte, nonce *[12]byte, counter uint32,
+	out *[chachaBlockSize]byte) {
+
+	var state [16]uint32
+	state[0] = 0x61707865
+	state[1] = 0x3320646e
+	state[2] = 0x79622d32
+	state[3] = 0x6b206574
+	for i := 0; i < 8; i++ {
+		state[4+i] = binary.LittleEndian.Uint32(key[i*4:])
+	}
+	state[12] = counter
+	for i := 0; i < 3; i++ {
+		state[13+i] = binary.LittleEndian.Uint32(nonce[i*4:])
+	}
+
+	x := state
+	quarterRound := func(a, b, c, d int) {
+		x[a] += x[b]
+		x[d] = bits.RotateLeft32(x[d]^x[a], 16)
+		x[c] += x[d]
+		x[b] = bits.RotateLeft32(x[b]^x[c], 12)
+		x[a] += x[b]
+		x[d] = bits.RotateLeft32(x[d]^x[a], 8)
+		x[c] += x[d]
+		x[b] = bits.RotateLeft32(x[b]^x[c], 7)
+	}
+	for i := 0; i < 10; i++ {
+		quarterRound(0, 4, 8, 12)
+		quarterRound(1, 5, 9, 13)
+		quarterRound(2, 6, 10, 14)
+		quarterRound(3, 7, 11, 15)
+		quarterRound(0, 5, 10, 15)
+		quarterRound(1, 6, 11, 12)
+		quarterRound(2, 7, 8, 13)
+		quarterRound(3, 4, 9, 14)
+	}
+	for i := range x {
+		binary.LittleEndian.PutUint32(out[i*4:], x[i]+state[i])
+	}
+}
+
+// makeNonce returns the 96-bit nonce made of the passed 32-bit and 64-bit
+// little-endian counters used by both ciphers.
+func makeNonce(low uint32, high uint64) [12]byte {
+	var nonce [12]byte
+	binary.LittleEndian.PutUint32(nonce[:4], low)
+	binary.LittleEndian.PutUint64(nonce[4:], high)
+	return nonce
+}
+
+// fsChaCha20 is the forward secure ChaCha20 stream cipher used to encrypt the
+// length of each packet.  Its keystream continues across messages, and every
+// rekeyInterval messages the next 32 bytes of keystream become the new key.
+type fsChaCha20 struct {
+	key          [keySize]byte
+	chunkCounter uint32
+	rekeyCounter uint64
+	blockCounter uint32
+	keystream    []byte
+}
+
+// newFSChaCha20 returns a forward secure ChaCha20 cipher using the passed key.
+func newFSChaCha20(key []byte) *fsChaCha20 {
+	c := &fsChaCha20{}
+	copy(c.key[:], key)
+	return c
+}
+
+// keystreamBytes returns the next n bytes of keystream.
+func (c *fsChaCha20) keystreamBytes(n int) []byte {
+	for len(c.keystream) < n {
+		var block [chachaBlockSize]byte
+		nonce := makeNonce(0, c.rekeyCounter)
+		chacha20Block(&c.key, &nonce, c.blockCounter, &block)
+		c.keystream = append(c.keystream, block[:]...)
+		c.blockCounter++
+	}
+	ks := c.keystream[:n]
+	c.keystream = c.keystream[n:]
+	return ks
+}
+
+// crypt encrypts or decrypts the passed chunk in place.
+func (c *fsChaCha20) crypt(chunk []byte) {
+	ks := c.keystreamBytes(len(chunk))
+	for i := range chunk {
+		chunk[i] ^= ks[i]
+	}
+
+	c.chunkCounter++
+	if c.chunkCounter == rekeyInterval {
+		copy(c.key[:], c.keystreamBytes(keySize))
+		c.chunkCounter = 0
+		c.rekeyCounter++
+		c.blockCounter = 0
+		c.keystream = nil
+	}
+}
+
+// fsChaCha20Poly1305 is the forward secure ChaCha20-Poly1305 AEAD used to
+// encrypt the contents of each packet.  Every rekeyInterval messages the key
+// is replaced with keystream derived from the old key.
+type fsChaCha20Poly1305 struct {
+	key           [keySize]byte
+	aead          cipher.AEAD
+	packetCounter uint32
+	rekeyCounter  uint64
+}
+
+// newFSChaCha20Poly1305 returns a forward secure ChaCha20-Poly1305 AEAD using
+// the passed key.
+func newFSChaCha20Poly1305(key []byte) *fsChaCha20Poly1305 {
+	c := &fsChaCha20Poly1305{}
+	copy(c.key[:], key)
+	c.setKey()
+	return c
+}
+
+// setKey initializes the underlying AEAD with the current key.
+func (c *fsChaCha20Poly1305) setKey() {
+	aead, err := chacha20poly1305.New(c.key[:])
+	if err != nil {
+		// The key always has the correct size.
+		panic(err)
+	}
+	c.aead = aead
+}
+
+// nextPacket advances the packet counter and switches to a new key once the
+// rekey interval is reached.  The new key is the AEAD encryption of 32 zero
+// bytes with the rekey nonce, which is the keystream starting at block one
+// since block zero is reserved for the Poly1305 key.
+func (c *fsChaCha20Poly1305) nextPacket() {
+	c.packetCounter++
+	if c.packetCounter == rekeyInterval {
+		var block [chachaBlockSize]byte
+		nonce := makeNonce(0xffffffff, c.rekeyCounter)
+		chacha20Block(&c.key, &nonce, 1, &block)
+		copy(c.key[:], block[:keySize])
+		c.setKey()
+		c.packetCounter = 0
+		c.rekeyCounter++
+	}
+}
+
+// seal encrypts and authenticates plaintext along with the additional data
+// and appends the result to dst.
+func (c *fsChaCha20Poly1305) seal(dst, plaintext, aad []byte) []byte {
+	nonce := makeNonce(c.packetCounter, c.rekeyCounter)
+	dst = c.aead.Seal(dst, nonce[:], plaintext, aad)
+	c.nextPacket()
+	return dst
+}
+
+// open authenticates and decrypts ciphertext along with the additional data
+// and appends the plaintext to dst.
+func (c *fsChaCha20Poly1305) open(dst, ciphertext, aad []byte) ([]byte, error) {
+	nonce := makeNonce(c.packetCounter, c.rekeyCounter)
+	dst, err := c.aead.Open(dst, nonce[:], ciphertext, aad)
+	if err != nil {
+		return nil, err
+	}
+	c.nextPacket()
+	return dst, nil
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2023 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package v2transport
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// TestChaCha20Block ensures the ChaCha20 block function matches the test
+// vector of RFC 8439 section 2.3.2 and the keystream used by the
+// ChaCha20-Poly1305 AEAD.
+func TestChaCha20Block(t *testing.T) {
+	var key [keySize]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	nonce := [12]byte{0, 0, 0, 0x09, 0, 0, 0, 0x4a, 0, 0, 0, 0}
+
+	var block [chachaBlockSize]byte
+	chacha20Block(&key, &nonce, 1, &block)
+	want, _ := hex.DecodeString("10f1e7e4d13b5915500fdd1fa32071c4")
+	if !bytes.Equal(block[:len(want)], want) {
+		t.Fatalf("unexpected keystream - got %x, want %x",
+			block[:len(want)], want)
+	}
+
+	// The AEAD encrypts with the keystream starting at block one, so
+	// encrypting zeros reveals it.
+	aead, err := chacha20poly1305.New(key[:])
+	if err != nil {
+		t.Fatalf("chacha20poly1305.New: %v", err)
+	}
+	sealed := aead.Seal(nil, nonce[:], make([]byte, 2*chachaBlockSize), nil)
+	for i := uint32(0); i < 2; i++ {
+		chacha20Block(&key, &nonce, i+1, &block)
+		got := sealed[i*chachaBlockSize : (i+1)*chachaBlockSize]
+		if !bytes.Equal(block[:], got) {
+			t.Fatalf("block %d mismatch - got %x, want %x", i+1,
+				block, got)
+		}
+	}
+}
+
+// TestFSChaCha20 ensures the forward secure ChaCha20 cipher round trips across
+// several rekeys and that the keystream changes after rekeying.
+func TestFSChaCha20(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, keySize)
+	enc := newFSChaCha20(key)
+	dec := newFSChaCha20(key)
+
+	seen := make(map[string]struct{})
+	for i := 0; i < 3*rekeyInterval; i++ {
+		chunk := []byte{byte(i), byte(i >> 8), 0}
+		orig := append([]byte(nil), chunk...)
+		enc.crypt(chunk)
+		if i%rekeyInterval == 0 {
+			// Record the ciphertext of the first message of each
+			// key to ensure the keys differ.
+			seen[string(chunk)] = struct{}{}
+		}
+		dec.crypt(chunk)
+		if !bytes.Equal(chunk, orig) {
+			t.Fatalf("#%d: round trip mismatch - got %x, want %x", i,
+				chunk, orig)
+		}
+	}
+	if len(seen) != 3 {
+		t.Fatalf("keystream did not change after rekeying")
+	}
+	if enc.rekeyCounter != 3 {
+		t.Fatalf("unexpected rekey counter - got %d, want 3",
+			enc.rekeyCounter)
+	}
+}
+
+// TestFSChaCha20Poly1305 ensures the forward secure AEAD round trips across
+// several rekeys and rejects tampered packets and mismatched additional data.
+func TestFSChaCha20Poly1305(t *testing.T) {
+	key := bytes.Repeat([]byte{0x24}, keySize)
+	enc := newFSChaCha20Poly1305(key)
+	dec := newFSChaCha20Poly1305(key)
+
+	for i := 0; i < 2*rekeyInterval+1; i++ {
+		plaintext := []byte{byte(i), 0xaa, 0xbb}
+		sealed := enc.seal(nil, plaintext, []byte("aad"))
+		opened, err := dec.open(nil, sealed, []byte("aad"))
+		if err != nil {
+			t.Fatalf("#%d: open: %v", i, err)
+		}
+		if !bytes.Equal(opened, plaintext) {
+			t.Fatalf("#%d: round trip mismatch - got %x, want %x", i,
+				opened, plaintext)
+		}
+	}
+	if enc.rekeyCounter != 2 {
+		t.Fatalf("unexpected rekey counter - got %d, want 2",
+			enc.rekeyCounter)
+	}
+
+	sealed := enc.seal(nil, []byte("payload"), nil)
+	tampered := append([]byte(nil), sealed...)
+	tampered[0] ^= 1
+	if _, err := dec.open(nil, tampered, nil); err == nil {
+		t.Fatal("open accepted tampered packet")
+	}
+	if _, err := dec.open(nil, sealed, []byte("aad")); err == nil {
+		t.Fatal("open accepted mismatched additional data")
+	}
+
+	// A failed open must not advance the packet counter.
+	if _, err := dec.open(nil, sealed, nil); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+}
+
+// TestFSChaCha20Poly1305Rekey ensures the packets sealed by the forward secure
+// AEAD match the BIP0324 definition, which derives each new key by encrypting
+// 32 zero bytes with the old key and a nonce of 0xffffffff followed by the
+// rekey counter.
+func TestFSChaCha20Poly1305Rekey(t *testing.T) {
+	key := bytes.Repeat([]byte{0x5a}, keySize)
+	c := newFSChaCha20Poly1305(key)
+
+	refKey := append([]byte(nil), key...)
+	for rekey := uint64(0); rekey < 3; rekey++ {
+		aead, err := chacha20poly1305.New(refKey)
+		if err != nil {
+			t.Fatalf("chacha20poly1305.New: %v", err)
+		}
+		for i := uint32(0); i < rekeyInterval; i++ {
+			plaintext := []byte{byte(i), byte(rekey)}
+			nonce := makeNonce(i, rekey)
+			want := aead.Seal(nil, nonce[:], plaintext, nil)
+			got := c.seal(nil, plaintext, nil)
+			if !bytes.Equal(got, want) {
+				t.Fatalf("rekey %d packet %d: got %x, want %x",
+					rekey, i, got, want)
+			}
+		}
+
+		nonce := makeNonce(0xffffffff, rekey)
+		refKey = aead.Seal(nil, nonce[:], make([]byte, keySize),
+			nil)[:keySize]
+	}
+}
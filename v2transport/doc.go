@@ -0,0 +1,36 @@
+// Copyright (c) 2023 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+/*
+Package v2transport implements the encrypted v2 peer-to-peer transport
+protocol specified by BIP0324.
+
+Overview
+
+The plaintext v1 transport frames every message with the network magic, the
+command and a checksum, which allows anyone on the path between two nodes to
+identify, inspect and tamper with their traffic.  The v2 transport replaces it
+with an opportunistically encrypted and authenticated stream which is
+indistinguishable from random bytes.
+
+The handshake starts with both sides exchanging ElligatorSwift encoded public
+keys followed by a random amount of garbage.  The keys are used to derive a
+shared secret, from which the session keys, a session id and the garbage
+terminators are derived.  Each side then sends its garbage terminator followed
+by a version packet which authenticates the garbage it sent.
+
+Every message is sent as a packet made of its length, encrypted with a forward
+secure ChaCha20 cipher, followed by a header byte and the contents, encrypted
+and authenticated with a forward secure ChaCha20-Poly1305 AEAD.  Common
+messages are identified by a one byte short message type id rather than their
+full command.  Packets with the ignore bit set in their header are decoys and
+are skipped by the receiver.
+
+Fallback
+
+Responders detect initiators which use the v1 transport by inspecting the
+first bytes they send with IsV1Prefix, which allows serving both transports on
+the same listener.
+*/
+package v2transport
@@ -0,0 +1,486 @@
+// Copyright (c) 2023 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package v2transport
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	"github.com/organicbitcoin/obtcd/btcec"
+	"github.com/organicbitcoin/obtcd/wire"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// GarbageTerminatorSize is the size of the garbage terminator which
+	// follows the garbage sent after the public key of each side.
+	GarbageTerminatorSize = 16
+
+	// MaxGarbageSize is the maximum amount of garbage either side may send
+	// before its garbage terminator.
+	MaxGarbageSize = 4095
+
+	// V1PrefixSize is the number of bytes the responder inspects to detect
+	// an initiator which uses the plaintext v1 transport.  It covers the
+	// network magic and the command of the v1 version message header.
+	V1PrefixSize = 4 + wire.CommandSize
+
+	// lengthFieldSize is the size of the encrypted length of a packet.
+	lengthFieldSize = 3
+
+	// headerSize is the size of the header byte of a packet.
+	headerSize = 1
+
+	// tagSize is the size of the authentication tag of a packet.
+	tagSize = 16
+
+	// ignoreBit is set in the header of decoy packets which must be
+	// ignored by the receiver.
+	ignoreBit = 1 << 7
+
+	// sharedSecretSalt is combined with the network magic to form the salt
+	// used to derive the session keys from the shared secret.
+	sharedSecretSalt = "bitcoin_v2_shared_secret"
+
+	// maxContentsSize is the largest packet contents accepted, which is the
+	// largest message payload along with its long message type encoding.
+	maxContentsSize = 1 + wire.CommandSize + wire.MaxMessagePayload
+)
+
+var (
+	// ErrGarbageTerminator is returned when the garbage terminator of the
+	// remote peer is not found within the maximum amount of garbage.
+	ErrGarbageTerminator = errors.New("garbage terminator not found")
+
+	// ErrDecryption is returned when a packet fails authentication.
+	ErrDecryption = errors.New("packet authentication failed")
+)
+
+// shortMsgIDs maps the one byte message type IDs defined by BIP0324 to the
+// commands they abbreviate.  Index zero denotes a command encoded in full.
+var shortMsgIDs = []string{
+	1:  wire.CmdAddr,
+	2:  wire.CmdBlock,
+	3:  wire.CmdBlockTxn,
+	4:  wire.CmdCmpctBlock,
+	5:  wire.CmdFeeFilter,
+	6:  wire.CmdFilterAdd,
+	7:  wire.CmdFilterClear,
+	8:  wire.CmdFilterLoad,
+	9:  wire.CmdGetBlocks,
+	10: wire.CmdGetBlockTxn,
+	11: wire.CmdGetData,
+	12: wire.CmdGetHeaders,
+	13: wire.CmdHeaders,
+	14: wire.CmdInv,
+	15: wire.CmdMemPool,
+	16: wire.CmdMerkleBlock,
+	17: wire.CmdNotFound,
+	18: wire.CmdPing,
+	19: wire.CmdPong,
+	20: wire.CmdSendCmpct,
+	21: wire.CmdTx,
+	22: wire.CmdGetCFilters,
+	23: wire.CmdCFilter,
+	24: wire.CmdGetCFHeaders,
+	25: wire.CmdCFHeaders,
+	26: wire.CmdGetCFCheckpt,
+	27: wire.CmdCFCheckpt,
+	28: wire.CmdAddrV2,
+}
+
+// shortMsgIDsByCommand is the reverse of shortMsgIDs.
+var shortMsgIDsByCommand = func() map[string]byte {
+	m := make(map[string]byte, len(shortMsgIDs))
+	for id, cmd := range shortMsgIDs {
+		if cmd != "" {
+			m[cmd] = byte(id)
+		}
+	}
+	return m
+}()
+
+// v1Prefix returns the first bytes of a v1 version message on the passed
+// network.
+func v1Prefix(btcnet wire.BitcoinNet) []byte {
+	prefix := make([]byte, V1PrefixSize)
+	binary.LittleEndian.PutUint32(prefix, uint32(btcnet))
+	copy(prefix[4:], wire.CmdVersion)
+	return prefix
+}
+
+// IsV1Prefix returns whether the passed bytes, which are the first bytes
+// received from an initiator, are the start of a plaintext v1 version message
+// rather than an ElligatorSwift encoded public key.  Responders use this to
+// fall back to the v1 transport.
+func IsV1Prefix(prefix []byte, btcnet wire.BitcoinNet) bool {
+	return bytes.Equal(prefix, v1Prefix(btcnet))
+}
+
+// Transport is an established encrypted v2 transport session.  Messages may be
+// read and written concurrently, but not by multiple readers or multiple
+// writers at the same time.
+type Transport struct {
+	r *bufio.Reader
+	w io.Writer
+
+	sendL *fsChaCha20
+	sendP *fsChaCha20Poly1305
+	recvL *fsChaCha20
+	recvP *fsChaCha20Poly1305
+
+	sessionID [32]byte
+}
+
+// SessionID returns the session id both sides derived from the key exchange.
+// It may be compared out of band to detect a man in the middle.
+func (t *Transport) SessionID() [32]byte {
+	return t.sessionID
+}
+
+// Handshake performs the v2 key exchange over rw and returns the established
+// transport.  The initiator is the side which opened the connection.  Writes
+// happen concurrently with reads so both sides may use synchronous, unbuffered
+// connections such as those created by net.Pipe.
+//
+// Responders which want to fall back to the v1 transport should inspect the
+// first V1PrefixSize bytes with IsV1Prefix before calling Handshake, and then
+// provide those bytes again as the start of rw.
+func Handshake(rw io.ReadWriter, btcnet wire.BitcoinNet, initiator bool) (*Transport, error) {
+	privKey, ourEnc, err := btcec.NewEllSwiftKey()
+	if err != nil {
+		return nil, err
+	}
+	garbage, err := randomGarbage()
+	if err != nil {
+		return nil, err
+	}
+
+	// Writes are queued to a separate goroutine so that a side which is
+	// blocked sending its garbage does not prevent it from receiving the
+	// key of the remote peer.
+	sendQueue := make(chan []byte, 2)
+	writeErr := make(chan error, 1)
+	go func() {
+		var err error
+		for b := range sendQueue {
+			if err == nil {
+				_, err = rw.Write(b)
+			}
+		}
+		writeErr <- err
+	}()
+	defer func() {
+		if sendQueue != nil {
+			close(sendQueue)
+		}
+	}()
+
+	if initiator {
+		sendQueue <- append(ourEnc[:], garbage...)
+	}
+
+	t := &Transport{r: bufio.NewReader(rw), w: rw}
+	var theirEnc [btcec.EllSwiftEncodingSize]byte
+	if _, err := io.ReadFull(t.r, theirEnc[:]); err != nil {
+		return nil, err
+	}
+	if !initiator && IsV1Prefix(theirEnc[:V1PrefixSize], btcnet) {
+		return nil, errors.New("remote peer uses the v1 transport")
+	}
+
+	secret, err := btcec.EllSwiftECDH(privKey, ourEnc, theirEnc, initiator)
+	if err != nil {
+		return nil, err
+	}
+	sendTerminator, recvTerminator := t.deriveKeys(secret, btcnet,
+		initiator)
+
+	// Send the garbage terminator followed by the version packet, which
+	// authenticates the garbage we sent.
+	var out []byte
+	if !initiator {
+		out = append(ourEnc[:], garbage...)
+	}
+	out = append(out, sendTerminator...)
+	out = t.encryptPacket(out, nil, garbage, false)
+	sendQueue <- out
+
+	// Skip the garbage of the remote peer until its terminator.
+	theirGarbage, err := t.readGarbage(recvTerminator)
+	if err != nil {
+		return nil, err
+	}
+
+	// The first packet authenticates the garbage.  Any decoy packets
+	// preceding the version packet are skipped, and the contents of the
+	// version packet are reserved for future extensions and ignored.
+	aad := theirGarbage
+	for {
+		_, header, _, err := t.readPacket(aad)
+		if err != nil {
+			return nil, err
+		}
+		aad = nil
+		if header&ignoreBit == 0 {
+			break
+		}
+	}
+
+	close(sendQueue)
+	sendQueue = nil
+	if err := <-writeErr; err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// randomGarbage returns a random amount of random bytes no larger than
+// MaxGarbageSize.
+func randomGarbage() ([]byte, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(MaxGarbageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	garbage := make([]byte, n.Int64())
+	if _, err := rand.Read(garbage); err != nil {
+		return nil, err
+	}
+	return garbage, nil
+}
+
+// deriveKeys derives the session keys and id from the shared secret and
+// returns the garbage terminators to send and to expect.
+func (t *Transport) deriveKeys(secret [32]byte, btcnet wire.BitcoinNet,
+	initiator bool) ([]byte, []byte) {
+
+	salt := []byte(sharedSecretSalt)
+	var magic [4]byte
+	binary.LittleEndian.PutUint32(magic[:], uint32(btcnet))
+	salt = append(salt, magic[:]...)
+
+	expand := func(info string, size int) []byte {
+		out := make([]byte, size)
+		r := hkdf.New(sha256.New, secret[:], salt, []byte(info))
+		if _, err := io.ReadFull(r, out); err != nil {
+			// HKDF can produce far more output than requested.
+			panic(err)
+		}
+		return out
+	}
+
+	initiatorL := expand("initiator_L", keySize)
+	initiatorP := expand("initiator_P", keySize)
+	responderL := expand("responder_L", keySize)
+	responderP := expand("responder_P", keySize)
+	terminators := expand("garbage_terminators", 2*GarbageTerminatorSize)
+	copy(t.sessionID[:], expand("session_id", 32))
+
+	initiatorTerm := terminators[:GarbageTerminatorSize]
+	responderTerm := terminators[GarbageTerminatorSize:]
+	if initiator {
+		t.sendL, t.sendP = newFSChaCha20(initiatorL), newFSChaCha20Poly1305(initiatorP)
+		t.recvL, t.recvP = newFSChaCha20(responderL), newFSChaCha20Poly1305(responderP)
+		return initiatorTerm, responderTerm
+	}
+	t.sendL, t.sendP = newFSChaCha20(responderL), newFSChaCha20Poly1305(responderP)
+	t.recvL, t.recvP = newFSChaCha20(initiatorL), newFSChaCha20Poly1305(initiatorP)
+	return responderTerm, initiatorTerm
+}
+
+// readGarbage reads until the passed garbage terminator and returns the
+// garbage which preceded it.
+func (t *Transport) readGarbage(terminator []byte) ([]byte, error) {
+	buf := make([]byte, GarbageTerminatorSize,
+		MaxGarbageSize+GarbageTerminatorSize)
+	if _, err := io.ReadFull(t.r, buf); err != nil {
+		return nil, err
+	}
+	for !bytes.Equal(buf[len(buf)-GarbageTerminatorSize:], terminator) {
+		if len(buf) == cap(buf) {
+			return nil, ErrGarbageTerminator
+		}
+		b, err := t.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b)
+	}
+	return buf[:len(buf)-GarbageTerminatorSize], nil
+}
+
+// encryptPacket appends the encrypted packet with the passed contents to dst.
+func (t *Transport) encryptPacket(dst, contents, aad []byte, ignore bool) []byte {
+	var length [lengthFieldSize]byte
+	length[0] = byte(len(contents))
+	length[1] = byte(len(contents) >> 8)
+	length[2] = byte(len(contents) >> 16)
+	t.sendL.crypt(length[:])
+	dst = append(dst, length[:]...)
+
+	plaintext := make([]byte, headerSize, headerSize+len(contents))
+	if ignore {
+		plaintext[0] = ignoreBit
+	}
+	plaintext = append(plaintext, contents...)
+	return t.sendP.seal(dst, plaintext, aad)
+}
+
+// readPacket reads and decrypts the next packet.  It returns the number of
+// bytes read along with the header and contents of the packet.
+func (t *Transport) readPacket(aad []byte) (int, byte, []byte, error) {
+	var length [lengthFieldSize]byte
+	n, err := io.ReadFull(t.r, length[:])
+	if err != nil {
+		return n, 0, nil, err
+	}
+	t.recvL.crypt(length[:])
+	size := int(length[0]) | int(length[1])<<8 | int(length[2])<<16
+	if size > maxContentsSize {
+		str := fmt.Sprintf("packet contents are too large - length "+
+			"indicates %d bytes, but max contents size is %d bytes",
+			size, maxContentsSize)
+		return n, 0, nil, &wire.MessageError{Func: "ReadMessage",
+			Description: str}
+	}
+
+	ciphertext := make([]byte, headerSize+size+tagSize)
+	read, err := io.ReadFull(t.r, ciphertext)
+	n += read
+	if err != nil {
+		return n, 0, nil, err
+	}
+	plaintext, err := t.recvP.open(ciphertext[:0], ciphertext, aad)
+	if err != nil {
+		return n, 0, nil, ErrDecryption
+	}
+	return n, plaintext[0], plaintext[headerSize:], nil
+}
+
+// WriteMessage encodes msg using the protocol version and message encoding
+// and sends it as a single packet.  It returns the number of bytes written.
+func (t *Transport) WriteMessage(msg wire.Message, pver uint32,
+	enc wire.MessageEncoding) (int, error) {
+
+	var contents bytes.Buffer
+	cmd := msg.Command()
+	if id, ok := shortMsgIDsByCommand[cmd]; ok {
+		contents.WriteByte(id)
+	} else {
+		if len(cmd) > wire.CommandSize {
+			str := fmt.Sprintf("command [%s] is too long [max %v]",
+				cmd, wire.CommandSize)
+			return 0, &wire.MessageError{Func: "WriteMessage",
+				Description: str}
+		}
+		var command [wire.CommandSize]byte
+		copy(command[:], cmd)
+		contents.WriteByte(0)
+		contents.Write(command[:])
+	}
+
+	prefixLen := contents.Len()
+	if err := msg.BtcEncode(&contents, pver, enc); err != nil {
+		return 0, err
+	}
+	lenp := contents.Len() - prefixLen
+	if lenp > wire.MaxMessagePayload {
+		str := fmt.Sprintf("message payload is too large - encoded "+
+			"%d bytes, but maximum message payload is %d bytes",
+			lenp, wire.MaxMessagePayload)
+		return 0, &wire.MessageError{Func: "WriteMessage",
+			Description: str}
+	}
+	if mpl := msg.MaxPayloadLength(pver); uint32(lenp) > mpl {
+		str := fmt.Sprintf("message payload is too large - encoded "+
+			"%d bytes, but maximum message payload size for "+
+			"messages of type [%s] is %d.", lenp, cmd, mpl)
+		return 0, &wire.MessageError{Func: "WriteMessage",
+			Description: str}
+	}
+
+	packet := t.encryptPacket(nil, contents.Bytes(), nil, false)
+	return t.w.Write(packet)
+}
+
+// ReadMessage reads, decrypts and parses the next message for the protocol
+// version and message encoding, skipping any decoy packets.  It returns the
+// number of bytes read in addition to the parsed message and its raw payload,
+// mirroring wire.ReadMessageWithEncodingN.
+func (t *Transport) ReadMessage(pver uint32, enc wire.MessageEncoding) (int, wire.Message, []byte, error) {
+	totalBytes := 0
+	var contents []byte
+	for {
+		n, header, c, err := t.readPacket(nil)
+		totalBytes += n
+		if err != nil {
+			return totalBytes, nil, nil, err
+		}
+		if header&ignoreBit == 0 {
+			contents = c
+			break
+		}
+	}
+
+	if len(contents) == 0 {
+		return totalBytes, nil, nil, &wire.MessageError{
+			Func: "ReadMessage", Description: "missing message type"}
+	}
+
+	var command string
+	payload := contents[1:]
+	if id := contents[0]; id != 0 {
+		if int(id) >= len(shortMsgIDs) {
+			str := fmt.Sprintf("unknown short message type id %d", id)
+			return totalBytes, nil, nil, &wire.MessageError{
+				Func: "ReadMessage", Description: str}
+		}
+		command = shortMsgIDs[id]
+	} else {
+		if len(payload) < wire.CommandSize {
+			return totalBytes, nil, nil, &wire.MessageError{
+				Func:        "ReadMessage",
+				Description: "truncated message type"}
+		}
+		command = strings.TrimRight(string(payload[:wire.CommandSize]),
+			"\x00")
+		payload = payload[wire.CommandSize:]
+		if strings.IndexByte(command, 0) != -1 {
+			str := fmt.Sprintf("invalid command %v", []byte(command))
+			return totalBytes, nil, nil, &wire.MessageError{
+				Func: "ReadMessage", Description: str}
+		}
+	}
+
+	msg, err := wire.MakeEmptyMessage(command)
+	if err != nil {
+		return totalBytes, nil, nil, &wire.MessageError{
+			Func: "ReadMessage", Description: err.Error()}
+	}
+
+	// Check for maximum length based on the message type.
+	if mpl := msg.MaxPayloadLength(pver); uint32(len(payload)) > mpl {
+		str := fmt.Sprintf("payload exceeds max length - packet "+
+			"contains %v bytes, but max payload size for messages "+
+			"of type [%v] is %v.", len(payload), command, mpl)
+		return totalBytes, nil, nil, &wire.MessageError{
+			Func: "ReadMessage", Description: str}
+	}
+
+	// NOTE: This must be a *bytes.Buffer since the MsgVersion BtcDecode
+	// function requires it.
+	if err := msg.BtcDecode(bytes.NewBuffer(payload), pver, enc); err != nil {
+		return totalBytes, nil, nil, err
+	}
+	return totalBytes, msg, payload, nil
+}
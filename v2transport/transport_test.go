@@ -0,0 +1,201 @@
+// Copyright (c) 2023 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package v2transport
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
+	"github.com/organicbitcoin/obtcd/wire"
+)
+
+// pipeTransports performs a handshake over an in-memory connection and
+// returns the transports of the initiator and the responder.
+func pipeTransports(t *testing.T) (*Transport, *Transport, func()) {
+	t.Helper()
+
+	c1, c2 := net.Pipe()
+	type result struct {
+		t   *Transport
+		err error
+	}
+	respCh := make(chan result, 1)
+	go func() {
+		tr, err := Handshake(c2, wire.MainNet, false)
+		respCh <- result{tr, err}
+	}()
+
+	initiator, err := Handshake(c1, wire.MainNet, true)
+	if err != nil {
+		t.Fatalf("initiator handshake: %v", err)
+	}
+	resp := <-respCh
+	if resp.err != nil {
+		t.Fatalf("responder handshake: %v", resp.err)
+	}
+	return initiator, resp.t, func() {
+		c1.Close()
+		c2.Close()
+	}
+}
+
+// TestHandshake ensures both sides of a handshake derive the same session and
+// can exchange messages in both directions.
+func TestHandshake(t *testing.T) {
+	initiator, responder, cleanup := pipeTransports(t)
+	defer cleanup()
+
+	if initiator.SessionID() != responder.SessionID() {
+		t.Fatalf("session id mismatch - initiator %x, responder %x",
+			initiator.SessionID(), responder.SessionID())
+	}
+
+	pver := wire.ProtocolVersion
+	hash := chainhash.Hash{0x01}
+	tests := []wire.Message{
+		// Short message type id.
+		wire.NewMsgPing(42),
+		wire.NewMsgGetHeaders(),
+		// Command encoded in full.
+		wire.NewMsgVerAck(),
+		wire.NewMsgSendAddrV2(),
+		wire.NewMsgGetCFCheckpt(wire.GCSFilterRegular, &hash),
+	}
+
+	for i, msg := range tests {
+		for _, pair := range [][2]*Transport{
+			{initiator, responder}, {responder, initiator},
+		} {
+			from, to := pair[0], pair[1]
+			errCh := make(chan error, 1)
+			go func() {
+				_, err := from.WriteMessage(msg, pver,
+					wire.LatestEncoding)
+				errCh <- err
+			}()
+
+			_, got, _, err := to.ReadMessage(pver, wire.LatestEncoding)
+			if err != nil {
+				t.Fatalf("#%d: ReadMessage: %v", i, err)
+			}
+			if err := <-errCh; err != nil {
+				t.Fatalf("#%d: WriteMessage: %v", i, err)
+			}
+			if !reflect.DeepEqual(got, msg) {
+				t.Fatalf("#%d: mismatched message - got %v, want %v",
+					i, got, msg)
+			}
+		}
+	}
+}
+
+// TestDecoyPackets ensures packets with the ignore bit set are skipped.
+func TestDecoyPackets(t *testing.T) {
+	initiator, responder, cleanup := pipeTransports(t)
+	defer cleanup()
+
+	go func() {
+		decoy := initiator.encryptPacket(nil, []byte("decoy"), nil, true)
+		initiator.w.Write(decoy)
+		initiator.WriteMessage(wire.NewMsgPong(7), wire.ProtocolVersion,
+			wire.LatestEncoding)
+	}()
+
+	_, msg, _, err := responder.ReadMessage(wire.ProtocolVersion,
+		wire.LatestEncoding)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	pong, ok := msg.(*wire.MsgPong)
+	if !ok || pong.Nonce != 7 {
+		t.Fatalf("unexpected message %v", msg)
+	}
+}
+
+// TestTamperedPacket ensures a modified packet is rejected.
+func TestTamperedPacket(t *testing.T) {
+	initiator, responder, cleanup := pipeTransports(t)
+	defer cleanup()
+
+	// Corrupt the last byte of the next packet on its way through.
+	pr, pw := io.Pipe()
+	responder.r.Reset(pr)
+	go func() {
+		var buf bytes.Buffer
+		initiator.w = &buf
+		initiator.WriteMessage(wire.NewMsgPing(1), wire.ProtocolVersion,
+			wire.LatestEncoding)
+		b := buf.Bytes()
+		b[len(b)-1] ^= 0x01
+		pw.Write(b)
+	}()
+
+	_, _, _, err := responder.ReadMessage(wire.ProtocolVersion,
+		wire.LatestEncoding)
+	if err != ErrDecryption {
+		t.Fatalf("unexpected error - got %v, want %v", err, ErrDecryption)
+	}
+}
+
+// TestV1Prefix ensures the start of a v1 version message is detected.
+func TestV1Prefix(t *testing.T) {
+	var buf bytes.Buffer
+	msg := wire.NewMsgVersion(&wire.NetAddress{}, &wire.NetAddress{}, 0, 0)
+	if err := wire.WriteMessage(&buf, msg, wire.ProtocolVersion,
+		wire.MainNet); err != nil {
+
+		t.Fatalf("WriteMessage: %v", err)
+	}
+
+	prefix := buf.Bytes()[:V1PrefixSize]
+	if !IsV1Prefix(prefix, wire.MainNet) {
+		t.Fatal("v1 version message not detected")
+	}
+	if IsV1Prefix(prefix, wire.TestNet3) {
+		t.Fatal("v1 version message detected on the wrong network")
+	}
+
+	ping := new(bytes.Buffer)
+	wire.WriteMessage(ping, wire.NewMsgPing(1), wire.ProtocolVersion,
+		wire.MainNet)
+	if IsV1Prefix(ping.Bytes()[:V1PrefixSize], wire.MainNet) {
+		t.Fatal("v1 ping message detected as version message")
+	}
+}
+
+// TestHandshakeV1Initiator ensures a responder rejects an initiator which uses
+// the v1 transport instead of hanging.
+func TestHandshakeV1Initiator(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	go func() {
+		msg := wire.NewMsgVersion(&wire.NetAddress{}, &wire.NetAddress{},
+			0, 0)
+		wire.WriteMessage(c1, msg, wire.ProtocolVersion, wire.MainNet)
+		io.Copy(ioutil.Discard, c1)
+	}()
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := Handshake(c2, wire.MainNet, false)
+		errCh <- err
+	}()
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Fatal("handshake with v1 initiator succeeded")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("handshake with v1 initiator timed out")
+	}
+}
@@ -158,5 +158,8 @@ This package includes spec changes outlined by the following BIPs:
 	BIP0111	(https://github.com/bitcoin/bips/blob/master/bip-0111.mediawiki)
 	BIP0130 (https://github.com/bitcoin/bips/blob/master/bip-0130.mediawiki)
 	BIP0133 (https://github.com/bitcoin/bips/blob/master/bip-0133.mediawiki)
+	BIP0152 (https://github.com/bitcoin/bips/blob/master/bip-0152.mediawiki)
+	BIP0155 (https://github.com/bitcoin/bips/blob/master/bip-0155.mediawiki)
+	BIP0339 (https://github.com/bitcoin/bips/blob/master/bip-0339.mediawiki)
 */
 package wire
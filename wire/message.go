@@ -57,6 +57,13 @@ const (
 	CmdCFilter      = "cfilter"
 	CmdCFHeaders    = "cfheaders"
 	CmdCFCheckpt    = "cfcheckpt"
+	CmdSendCmpct    = "sendcmpct"
+	CmdCmpctBlock   = "cmpctblock"
+	CmdGetBlockTxn  = "getblocktxn"
+	CmdBlockTxn     = "blocktxn"
+	CmdWTxIDRelay   = "wtxidrelay"
+	CmdAddrV2       = "addrv2"
+	CmdSendAddrV2   = "sendaddrv2"
 )
 
 // MessageEncoding represents the wire message encoding format to be used.
@@ -180,12 +187,41 @@ func makeEmptyMessage(command string) (Message, error) {
 	case CmdCFCheckpt:
 		msg = &MsgCFCheckpt{}
 
+	case CmdSendCmpct:
+		msg = &MsgSendCmpct{}
+
+	case CmdCmpctBlock:
+		msg = &MsgCmpctBlock{}
+
+	case CmdGetBlockTxn:
+		msg = &MsgGetBlockTxn{}
+
+	case CmdBlockTxn:
+		msg = &MsgBlockTxn{}
+
+	case CmdWTxIDRelay:
+		msg = &MsgWTxIDRelay{}
+
+	case CmdAddrV2:
+		msg = &MsgAddrV2{}
+
+	case CmdSendAddrV2:
+		msg = &MsgSendAddrV2{}
+
 	default:
 		return nil, fmt.Errorf("unhandled command [%s]", command)
 	}
 	return msg, nil
 }
 
+// MakeEmptyMessage creates a message of the appropriate concrete type based
+// on the command.  It is intended for transports which frame messages
+// differently than the message header used by ReadMessage, such as the
+// encrypted v2 transport (BIP0324).
+func MakeEmptyMessage(command string) (Message, error) {
+	return makeEmptyMessage(command)
+}
+
 // messageHeader defines the header structure for all bitcoin protocol messages.
 type messageHeader struct {
 	magic    BitcoinNet // 4 bytes
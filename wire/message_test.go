@@ -76,6 +76,14 @@ func TestMessage(t *testing.T) {
 		[]byte("payload"))
 	msgCFHeaders := NewMsgCFHeaders()
 	msgCFCheckpt := NewMsgCFCheckpt(GCSFilterRegular, &chainhash.Hash{}, 0)
+	msgSendCmpct := NewMsgSendCmpct(false, CmpctBlockVersionWTxID)
+	msgCmpctBlock := &MsgCmpctBlock{Header: *bh, ShortIDs: []uint64{},
+		PrefilledTxns: []PrefilledTx{}}
+	msgGetBlockTxn := NewMsgGetBlockTxn(&chainhash.Hash{}, []uint32{})
+	msgBlockTxn := NewMsgBlockTxn(&chainhash.Hash{}, []*MsgTx{})
+	msgWTxIDRelay := NewMsgWTxIDRelay()
+	msgAddrV2 := NewMsgAddrV2()
+	msgSendAddrV2 := NewMsgSendAddrV2()
 
 	tests := []struct {
 		in     Message    // Value to encode
@@ -111,6 +119,13 @@ func TestMessage(t *testing.T) {
 		{msgCFilter, msgCFilter, pver, MainNet, 65},
 		{msgCFHeaders, msgCFHeaders, pver, MainNet, 90},
 		{msgCFCheckpt, msgCFCheckpt, pver, MainNet, 58},
+		{msgSendCmpct, msgSendCmpct, pver, MainNet, 33},
+		{msgCmpctBlock, msgCmpctBlock, pver, MainNet, 114},
+		{msgGetBlockTxn, msgGetBlockTxn, pver, MainNet, 57},
+		{msgBlockTxn, msgBlockTxn, pver, MainNet, 57},
+		{msgWTxIDRelay, msgWTxIDRelay, pver, MainNet, 24},
+		{msgAddrV2, msgAddrV2, pver, MainNet, 25},
+		{msgSendAddrV2, msgSendAddrV2, pver, MainNet, 24},
 	}
 
 	t.Logf("Running %d tests", len(tests))
@@ -0,0 +1,118 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestAddrV2 tests the MsgAddrV2 API.
+func TestAddrV2(t *testing.T) {
+	pver := ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "addrv2"
+	msg := NewMsgAddrV2()
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgAddrV2: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	// Num addresses (varInt) + max allowed addresses.
+	wantPayload := uint32(537009)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Ensure adding more than the max allowed addresses per message returns
+	// error.
+	na := NewNetAddressV2(time.Now(), 0, NetIDTorV3, make([]byte, 32), 0)
+	for i := 0; i < MaxAddrPerMsg+1; i++ {
+		err := msg.AddAddress(na)
+		if i < MaxAddrPerMsg && err != nil {
+			t.Fatalf("AddAddress: unexpected error: %v", err)
+		}
+		if i == MaxAddrPerMsg && err == nil {
+			t.Errorf("AddAddress: expected error on too many " +
+				"addresses not received")
+		}
+	}
+	msg.ClearAddresses()
+	if len(msg.AddrList) != 0 {
+		t.Errorf("ClearAddresses: address list is not empty - got %v",
+			len(msg.AddrList))
+	}
+
+	// Ensure the message is rejected prior to AddrV2Version.
+	var buf bytes.Buffer
+	if err := msg.BtcEncode(&buf, AddrV2Version-1, BaseEncoding); err == nil {
+		t.Errorf("BtcEncode: expected error for protocol version %d",
+			AddrV2Version-1)
+	}
+	if err := msg.BtcDecode(&buf, AddrV2Version-1, BaseEncoding); err == nil {
+		t.Errorf("BtcDecode: expected error for protocol version %d",
+			AddrV2Version-1)
+	}
+}
+
+// TestAddrV2Wire tests the MsgAddrV2 wire encode and decode, ensuring
+// addresses of unknown networks are skipped.
+func TestAddrV2Wire(t *testing.T) {
+	pver := ProtocolVersion
+	ts := time.Unix(0x495fab29, 0)
+	torV3 := NewNetAddressV2(ts, SFNodeNetwork, NetIDTorV3,
+		bytes.Repeat([]byte{0x01}, 32), 8333)
+	i2p := NewNetAddressV2(ts, 0, NetIDI2P, bytes.Repeat([]byte{0x02}, 32),
+		0)
+
+	msg := NewMsgAddrV2()
+	msg.AddAddresses(torV3, i2p)
+	var buf bytes.Buffer
+	if err := msg.BtcEncode(&buf, pver, BaseEncoding); err != nil {
+		t.Fatalf("BtcEncode: unexpected error: %v", err)
+	}
+
+	var readmsg MsgAddrV2
+	err := readmsg.BtcDecode(bytes.NewReader(buf.Bytes()), pver,
+		BaseEncoding)
+	if err != nil {
+		t.Fatalf("BtcDecode: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(readmsg.AddrList, msg.AddrList) {
+		t.Fatalf("BtcDecode: got %v, want %v",
+			spew.Sdump(readmsg.AddrList), spew.Sdump(msg.AddrList))
+	}
+
+	// Replace the network id of the first address with an unknown one
+	// which must be skipped.
+	encoded := buf.Bytes()
+	encoded[1+4+1] = 0x2a
+	err = readmsg.BtcDecode(bytes.NewReader(encoded), pver, BaseEncoding)
+	if err != nil {
+		t.Fatalf("BtcDecode: unexpected error: %v", err)
+	}
+	if len(readmsg.AddrList) != 1 ||
+		!reflect.DeepEqual(readmsg.AddrList[0], i2p) {
+
+		t.Fatalf("BtcDecode: got %v, want only %v",
+			spew.Sdump(readmsg.AddrList), spew.Sdump(i2p))
+	}
+
+	// Ensure a count larger than the max allowed is rejected.
+	tooMany := []byte{0xfd, 0xe9, 0x03} // Varint for number of addresses
+	err = readmsg.BtcDecode(bytes.NewReader(tooMany), pver, BaseEncoding)
+	if _, ok := err.(*MessageError); !ok {
+		t.Fatalf("BtcDecode: got error %v, want MessageError", err)
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/aead/siphash"
+	"github.com/organicbitcoin/obtcd/chaincfg/chainhash"
+)
+
+const (
+	// ShortTxIDSize is the number of bytes a short transaction id occupies
+	// in a cmpctblock message.
+	ShortTxIDSize = 6
+
+	// shortTxIDMask masks a SipHash digest down to the size of a short
+	// transaction id.
+	shortTxIDMask = (1 << (ShortTxIDSize * 8)) - 1
+
+	// maxCmpctBlockTxIndex is the maximum index a transaction in a compact
+	// block or a getblocktxn request may have.
+	maxCmpctBlockTxIndex = math.MaxUint16
+)
+
+// ShortTxIDKey is the SipHash key used to compute short transaction ids for a
+// compact block.
+type ShortTxIDKey [siphash.KeySize]byte
+
+// NewShortTxIDKey returns the key used to compute the short transaction ids of
+// the compact block with the passed header and nonce.  The key is the first 16
+// bytes of the single SHA256 of the serialized header followed by the little
+// endian encoded nonce.
+func NewShortTxIDKey(header *BlockHeader, nonce uint64) ShortTxIDKey {
+	h := sha256.New()
+	writeBlockHeader(h, 0, header)
+	writeElement(h, nonce)
+
+	var key ShortTxIDKey
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// ShortTxID returns the short transaction id of the transaction with the
+// passed hash.  Depending on the compact block version, the hash is either the
+// transaction hash or the witness transaction hash.
+func (key *ShortTxIDKey) ShortTxID(hash *chainhash.Hash) uint64 {
+	k := [siphash.KeySize]byte(*key)
+	return siphash.Sum64(hash[:], &k) & shortTxIDMask
+}
+
+// PrefilledTx is a transaction included in full in a compact block along with
+// its index in the block.
+type PrefilledTx struct {
+	Index uint32
+	Tx    *MsgTx
+}
+
+// MsgCmpctBlock implements the Message interface and represents a bitcoin
+// cmpctblock message.  It is used to relay a block as its header along with
+// short ids for the transactions the receiver likely already has and the
+// remaining transactions in full (BIP0152).
+//
+// The indexes of the prefilled transactions are absolute indexes into the
+// block.  They are differentially encoded on the wire.
+//
+// This message was not added until protocol versions starting with
+// CompactBlocksVersion.
+type MsgCmpctBlock struct {
+	Header        BlockHeader
+	Nonce         uint64
+	ShortIDs      []uint64
+	PrefilledTxns []PrefilledTx
+}
+
+// BlockHash computes the block identifier hash for the compact block.
+func (msg *MsgCmpctBlock) BlockHash() chainhash.Hash {
+	return msg.Header.BlockHash()
+}
+
+// ShortTxIDKey returns the key used to compute the short transaction ids of
+// the compact block.
+func (msg *MsgCmpctBlock) ShortTxIDKey() ShortTxIDKey {
+	return NewShortTxIDKey(&msg.Header, msg.Nonce)
+}
+
+// TxCount returns the number of transactions in the block described by the
+// compact block.
+func (msg *MsgCmpctBlock) TxCount() int {
+	return len(msg.ShortIDs) + len(msg.PrefilledTxns)
+}
+
+// readShortTxID reads a 6-byte little endian short transaction id from r.
+func readShortTxID(r io.Reader) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:ShortTxIDSize]); err != nil {
+		return 0, err
+	}
+	return littleEndian.Uint64(buf[:]), nil
+}
+
+// writeShortTxID writes a 6-byte little endian short transaction id to w.
+func writeShortTxID(w io.Writer, id uint64) error {
+	var buf [8]byte
+	littleEndian.PutUint64(buf[:], id)
+	_, err := w.Write(buf[:ShortTxIDSize])
+	return err
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) BtcDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	if pver < CompactBlocksVersion {
+		str := fmt.Sprintf("cmpctblock message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCmpctBlock.BtcDecode", str)
+	}
+
+	err := readBlockHeader(r, pver, &msg.Header)
+	if err != nil {
+		return err
+	}
+	err = readElement(r, &msg.Nonce)
+	if err != nil {
+		return err
+	}
+
+	count, err := ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > maxTxPerBlock {
+		str := fmt.Sprintf("too many short ids to fit into a block "+
+			"[count %d, max %d]", count, maxTxPerBlock)
+		return messageError("MsgCmpctBlock.BtcDecode", str)
+	}
+	msg.ShortIDs = make([]uint64, count)
+	for i := range msg.ShortIDs {
+		msg.ShortIDs[i], err = readShortTxID(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	count, err = ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count+uint64(len(msg.ShortIDs)) > maxTxPerBlock {
+		str := fmt.Sprintf("too many transactions to fit into a "+
+			"block [count %d, max %d]",
+			count+uint64(len(msg.ShortIDs)), maxTxPerBlock)
+		return messageError("MsgCmpctBlock.BtcDecode", str)
+	}
+	msg.PrefilledTxns = make([]PrefilledTx, count)
+	var index uint64
+	for i := range msg.PrefilledTxns {
+		diff, err := ReadVarInt(r, pver)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			index++
+		}
+		index += diff
+		if index > maxCmpctBlockTxIndex {
+			str := fmt.Sprintf("prefilled transaction index %d "+
+				"is too large", index)
+			return messageError("MsgCmpctBlock.BtcDecode", str)
+		}
+
+		tx := MsgTx{}
+		if err := tx.BtcDecode(r, pver, enc); err != nil {
+			return err
+		}
+		msg.PrefilledTxns[i] = PrefilledTx{Index: uint32(index), Tx: &tx}
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) BtcEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	if pver < CompactBlocksVersion {
+		str := fmt.Sprintf("cmpctblock message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCmpctBlock.BtcEncode", str)
+	}
+
+	err := writeBlockHeader(w, pver, &msg.Header)
+	if err != nil {
+		return err
+	}
+	err = writeElement(w, msg.Nonce)
+	if err != nil {
+		return err
+	}
+
+	err = WriteVarInt(w, pver, uint64(len(msg.ShortIDs)))
+	if err != nil {
+		return err
+	}
+	for _, id := range msg.ShortIDs {
+		if err := writeShortTxID(w, id); err != nil {
+			return err
+		}
+	}
+
+	err = WriteVarInt(w, pver, uint64(len(msg.PrefilledTxns)))
+	if err != nil {
+		return err
+	}
+	for i, ptx := range msg.PrefilledTxns {
+		diff := ptx.Index
+		if i > 0 {
+			prev := msg.PrefilledTxns[i-1].Index
+			if ptx.Index <= prev {
+				str := fmt.Sprintf("prefilled transaction "+
+					"indexes are not increasing [%d after "+
+					"%d]", ptx.Index, prev)
+				return messageError("MsgCmpctBlock.BtcEncode",
+					str)
+			}
+			diff = ptx.Index - prev - 1
+		}
+		if err := WriteVarInt(w, pver, uint64(diff)); err != nil {
+			return err
+		}
+		if err := ptx.Tx.BtcEncode(w, pver, enc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgCmpctBlock) Command() string {
+	return CmdCmpctBlock
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) MaxPayloadLength(pver uint32) uint32 {
+	// A compact block is never larger than the block it describes.
+	return MaxBlockPayload
+}
+
+// NewMsgCmpctBlock returns a new bitcoin cmpctblock message which describes
+// the passed block.  The coinbase transaction and the transactions at the
+// passed additional indexes, which must be in increasing order, are prefilled
+// and short ids are computed for the others.  When useWTxID is set, the short
+// ids are computed from the witness transaction hashes as required by compact
+// block version 2.
+func NewMsgCmpctBlock(block *MsgBlock, nonce uint64, prefill []int,
+	useWTxID bool) *MsgCmpctBlock {
+
+	msg := &MsgCmpctBlock{
+		Header: block.Header,
+		Nonce:  nonce,
+	}
+	key := msg.ShortTxIDKey()
+
+	next := 0
+	for i, tx := range block.Transactions {
+		for next < len(prefill) && prefill[next] < i {
+			next++
+		}
+		if i == 0 || (next < len(prefill) && prefill[next] == i) {
+			msg.PrefilledTxns = append(msg.PrefilledTxns,
+				PrefilledTx{Index: uint32(i), Tx: tx})
+			continue
+		}
+
+		var hash chainhash.Hash
+		if useWTxID {
+			hash = tx.WitnessHash()
+		} else {
+			hash = tx.TxHash()
+		}
+		msg.ShortIDs = append(msg.ShortIDs, key.ShortTxID(&hash))
+	}
+	return msg
+}
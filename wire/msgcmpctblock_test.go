@@ -0,0 +1,165 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"reflect"
+	"testing"
+
+	"github.com/aead/siphash"
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestShortTxID ensures short transaction ids are computed as specified by
+// BIP0152.
+func TestShortTxID(t *testing.T) {
+	header := blockOne.Header
+	nonce := uint64(0x0102030405060708)
+
+	// The key is the first 16 bytes of the SHA256 of the header followed by
+	// the little endian nonce.
+	var buf bytes.Buffer
+	writeBlockHeader(&buf, 0, &header)
+	buf.Write([]byte{0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01})
+	digest := sha256.Sum256(buf.Bytes())
+	var wantKey [siphash.KeySize]byte
+	copy(wantKey[:], digest[:])
+
+	key := NewShortTxIDKey(&header, nonce)
+	if [siphash.KeySize]byte(key) != wantKey {
+		t.Fatalf("NewShortTxIDKey: got %x, want %x", key, wantKey)
+	}
+
+	// The short id is the SipHash-2-4 of the hash with the upper two bytes
+	// dropped.
+	hash := blockOne.Transactions[0].TxHash()
+	want := siphash.Sum64(hash[:], &wantKey) & 0xffffffffffff
+	if got := key.ShortTxID(&hash); got != want {
+		t.Fatalf("ShortTxID: got %x, want %x", got, want)
+	}
+}
+
+// TestCmpctBlock tests building a compact block from a block and its wire
+// encoding.
+func TestCmpctBlock(t *testing.T) {
+	pver := ProtocolVersion
+
+	// Build a block with a coinbase and three more transactions, one of
+	// which is prefilled.
+	block := blockOne
+	block.Transactions = nil
+	for i := 0; i < 4; i++ {
+		tx := blockOne.Transactions[0].Copy()
+		tx.LockTime = uint32(i)
+		block.AddTransaction(tx)
+	}
+
+	msg := NewMsgCmpctBlock(&block, 42, []int{2}, true)
+	if cmd := msg.Command(); cmd != "cmpctblock" {
+		t.Errorf("NewMsgCmpctBlock: wrong command - got %v want %v",
+			cmd, "cmpctblock")
+	}
+	if msg.BlockHash() != block.BlockHash() {
+		t.Errorf("BlockHash: got %v, want %v", msg.BlockHash(),
+			block.BlockHash())
+	}
+	if msg.TxCount() != 4 {
+		t.Fatalf("TxCount: got %d, want 4", msg.TxCount())
+	}
+	if len(msg.PrefilledTxns) != 2 || msg.PrefilledTxns[0].Index != 0 ||
+		msg.PrefilledTxns[1].Index != 2 {
+
+		t.Fatalf("unexpected prefilled transactions %v",
+			spew.Sdump(msg.PrefilledTxns))
+	}
+	key := msg.ShortTxIDKey()
+	for i, txIdx := range []int{1, 3} {
+		hash := block.Transactions[txIdx].WitnessHash()
+		if want := key.ShortTxID(&hash); msg.ShortIDs[i] != want {
+			t.Errorf("short id %d: got %x, want %x", i,
+				msg.ShortIDs[i], want)
+		}
+	}
+
+	// Ensure the message round trips and the short ids only use six bytes
+	// each.
+	var buf bytes.Buffer
+	if err := msg.BtcEncode(&buf, pver, WitnessEncoding); err != nil {
+		t.Fatalf("BtcEncode: unexpected error %v", err)
+	}
+	var readmsg MsgCmpctBlock
+	err := readmsg.BtcDecode(bytes.NewReader(buf.Bytes()), pver,
+		WitnessEncoding)
+	if err != nil {
+		t.Fatalf("BtcDecode: unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(&readmsg, msg) {
+		t.Fatalf("BtcDecode\n got: %s want: %s", spew.Sdump(readmsg),
+			spew.Sdump(msg))
+	}
+	shortIDsStart := blockHeaderLen + 8 + 1
+	for i, id := range msg.ShortIDs {
+		var idBuf bytes.Buffer
+		writeShortTxID(&idBuf, id)
+		offset := shortIDsStart + i*ShortTxIDSize
+		got := buf.Bytes()[offset : offset+ShortTxIDSize]
+		if !bytes.Equal(got, idBuf.Bytes()) {
+			t.Errorf("short id %d: got encoding %x, want %x", i,
+				got, idBuf.Bytes())
+		}
+	}
+
+	// Prefilled indexes which are not strictly increasing can't be
+	// encoded.
+	msg.PrefilledTxns[1].Index = 0
+	err = msg.BtcEncode(&buf, pver, WitnessEncoding)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BtcEncode: expected MessageError for duplicate "+
+			"indexes, got %v", err)
+	}
+
+	// Older protocol versions do not support the message.
+	err = readmsg.BtcDecode(bytes.NewReader(buf.Bytes()),
+		CompactBlocksVersion-1, WitnessEncoding)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BtcDecode: expected MessageError for old protocol "+
+			"version, got %v", err)
+	}
+}
+
+// TestBlockTxn tests the MsgBlockTxn wire encoding.
+func TestBlockTxn(t *testing.T) {
+	pver := ProtocolVersion
+	hash := blockOne.BlockHash()
+	msg := NewMsgBlockTxn(&hash, blockOne.Transactions)
+	if cmd := msg.Command(); cmd != "blocktxn" {
+		t.Errorf("NewMsgBlockTxn: wrong command - got %v want %v",
+			cmd, "blocktxn")
+	}
+
+	var buf bytes.Buffer
+	if err := msg.BtcEncode(&buf, pver, WitnessEncoding); err != nil {
+		t.Fatalf("BtcEncode: unexpected error %v", err)
+	}
+	var readmsg MsgBlockTxn
+	err := readmsg.BtcDecode(bytes.NewReader(buf.Bytes()), pver,
+		WitnessEncoding)
+	if err != nil {
+		t.Fatalf("BtcDecode: unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(&readmsg, msg) {
+		t.Fatalf("BtcDecode\n got: %s want: %s", spew.Sdump(readmsg),
+			spew.Sdump(msg))
+	}
+
+	err = readmsg.BtcDecode(bytes.NewReader(buf.Bytes()),
+		CompactBlocksVersion-1, WitnessEncoding)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BtcDecode: expected MessageError for old protocol "+
+			"version, got %v", err)
+	}
+}
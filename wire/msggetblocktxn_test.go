@@ -0,0 +1,78 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestGetBlockTxn tests the MsgGetBlockTxn wire encoding, including the
+// differential encoding of the transaction indexes.
+func TestGetBlockTxn(t *testing.T) {
+	pver := ProtocolVersion
+	hash := blockOne.BlockHash()
+
+	msg := NewMsgGetBlockTxn(&hash, []uint32{1, 2, 5, 300})
+	if cmd := msg.Command(); cmd != "getblocktxn" {
+		t.Errorf("NewMsgGetBlockTxn: wrong command - got %v want %v",
+			cmd, "getblocktxn")
+	}
+
+	encoded := append([]byte{}, hash[:]...)
+	encoded = append(encoded,
+		0x04,             // Number of indexes
+		0x01,             // 1
+		0x00,             // 2
+		0x02,             // 5
+		0xfd, 0x26, 0x01, // 300
+	)
+	var buf bytes.Buffer
+	if err := msg.BtcEncode(&buf, pver, BaseEncoding); err != nil {
+		t.Fatalf("BtcEncode: unexpected error %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), encoded) {
+		t.Fatalf("BtcEncode\n got: %s want: %s",
+			spew.Sdump(buf.Bytes()), spew.Sdump(encoded))
+	}
+
+	var readmsg MsgGetBlockTxn
+	err := readmsg.BtcDecode(bytes.NewReader(encoded), pver, BaseEncoding)
+	if err != nil {
+		t.Fatalf("BtcDecode: unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(&readmsg, msg) {
+		t.Fatalf("BtcDecode\n got: %s want: %s", spew.Sdump(readmsg),
+			spew.Sdump(msg))
+	}
+
+	// Indexes which are not strictly increasing can't be encoded.
+	msg.Indexes = []uint32{3, 3}
+	err = msg.BtcEncode(&buf, pver, BaseEncoding)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BtcEncode: expected MessageError for duplicate "+
+			"indexes, got %v", err)
+	}
+
+	// Indexes larger than any block may contain are rejected.
+	tooLarge := append([]byte{}, hash[:]...)
+	tooLarge = append(tooLarge, 0x01, 0xfe, 0x00, 0x00, 0x01, 0x00)
+	err = readmsg.BtcDecode(bytes.NewReader(tooLarge), pver, BaseEncoding)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BtcDecode: expected MessageError for large index, "+
+			"got %v", err)
+	}
+
+	// Older protocol versions do not support the message.
+	err = readmsg.BtcDecode(bytes.NewReader(encoded),
+		CompactBlocksVersion-1, BaseEncoding)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BtcDecode: expected MessageError for old protocol "+
+			"version, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestSendCmpct tests the MsgSendCmpct API and wire encoding.
+func TestSendCmpct(t *testing.T) {
+	pver := ProtocolVersion
+
+	msg := NewMsgSendCmpct(true, CmpctBlockVersionWTxID)
+	if cmd := msg.Command(); cmd != "sendcmpct" {
+		t.Errorf("NewMsgSendCmpct: wrong command - got %v want %v",
+			cmd, "sendcmpct")
+	}
+	if maxPayload := msg.MaxPayloadLength(pver); maxPayload != 9 {
+		t.Errorf("MaxPayloadLength: wrong max payload length - got "+
+			"%v, want %v", maxPayload, 9)
+	}
+
+	encoded := []byte{
+		0x01,                                           // Announce
+		0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Version
+	}
+	var buf bytes.Buffer
+	if err := msg.BtcEncode(&buf, pver, BaseEncoding); err != nil {
+		t.Fatalf("BtcEncode: unexpected error %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), encoded) {
+		t.Fatalf("BtcEncode\n got: %s want: %s",
+			spew.Sdump(buf.Bytes()), spew.Sdump(encoded))
+	}
+
+	var readmsg MsgSendCmpct
+	err := readmsg.BtcDecode(bytes.NewReader(encoded), pver, BaseEncoding)
+	if err != nil {
+		t.Fatalf("BtcDecode: unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(&readmsg, msg) {
+		t.Fatalf("BtcDecode\n got: %s want: %s", spew.Sdump(readmsg),
+			spew.Sdump(msg))
+	}
+
+	// Older protocol versions do not support the message.
+	oldPver := CompactBlocksVersion - 1
+	err = msg.BtcEncode(&buf, oldPver, BaseEncoding)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BtcEncode: expected MessageError for protocol "+
+			"version %d, got %v", oldPver, err)
+	}
+	err = readmsg.BtcDecode(bytes.NewReader(encoded), oldPver, BaseEncoding)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BtcDecode: expected MessageError for protocol "+
+			"version %d, got %v", oldPver, err)
+	}
+}
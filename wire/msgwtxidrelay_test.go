@@ -0,0 +1,193 @@
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestWTxIDRelay tests the MsgWTxIDRelay API against the latest protocol
+// version.
+func TestWTxIDRelay(t *testing.T) {
+	pver := ProtocolVersion
+	enc := BaseEncoding
+
+	// Ensure the command is expected value.
+	wantCmd := "wtxidrelay"
+	msg := NewMsgWTxIDRelay()
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgWTxIDRelay: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value.
+	wantPayload := uint32(0)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Test encode with latest protocol version.
+	var buf bytes.Buffer
+	err := msg.BtcEncode(&buf, pver, enc)
+	if err != nil {
+		t.Errorf("encode of MsgWTxIDRelay failed %v err <%v>", msg,
+			err)
+	}
+
+	// Older protocol versions should fail encode since message didn't
+	// exist yet.
+	oldPver := WTxIDRelayVersion - 1
+	err = msg.BtcEncode(&buf, oldPver, enc)
+	if err == nil {
+		s := "encode of MsgWTxIDRelay passed for old protocol " +
+			"version %v err <%v>"
+		t.Errorf(s, msg, err)
+	}
+
+	// Test decode with latest protocol version.
+	readmsg := NewMsgWTxIDRelay()
+	err = readmsg.BtcDecode(&buf, pver, enc)
+	if err != nil {
+		t.Errorf("decode of MsgWTxIDRelay failed [%v] err <%v>", buf,
+			err)
+	}
+
+	// Older protocol versions should fail decode since message didn't
+	// exist yet.
+	err = readmsg.BtcDecode(&buf, oldPver, enc)
+	if err == nil {
+		s := "decode of MsgWTxIDRelay passed for old protocol " +
+			"version %v err <%v>"
+		t.Errorf(s, msg, err)
+	}
+}
+
+// TestWTxIDRelayBIP0339 tests the MsgWTxIDRelay API against the protocol
+// prior to version WTxIDRelayVersion.
+func TestWTxIDRelayBIP0339(t *testing.T) {
+	// Use the protocol version just prior to WTxIDRelayVersion changes.
+	pver := WTxIDRelayVersion - 1
+	enc := BaseEncoding
+
+	msg := NewMsgWTxIDRelay()
+
+	// Test encode with old protocol version.
+	var buf bytes.Buffer
+	err := msg.BtcEncode(&buf, pver, enc)
+	if err == nil {
+		t.Errorf("encode of MsgWTxIDRelay succeeded when it should " +
+			"have failed")
+	}
+
+	// Test decode with old protocol version.
+	readmsg := NewMsgWTxIDRelay()
+	err = readmsg.BtcDecode(&buf, pver, enc)
+	if err == nil {
+		t.Errorf("decode of MsgWTxIDRelay succeeded when it should " +
+			"have failed")
+	}
+}
+
+// TestWTxIDRelayCrossProtocol tests the MsgWTxIDRelay API when encoding with
+// the latest protocol version and decoding with WTxIDRelayVersion.
+func TestWTxIDRelayCrossProtocol(t *testing.T) {
+	enc := BaseEncoding
+	msg := NewMsgWTxIDRelay()
+
+	// Encode with latest protocol version.
+	var buf bytes.Buffer
+	err := msg.BtcEncode(&buf, ProtocolVersion, enc)
+	if err != nil {
+		t.Errorf("encode of MsgWTxIDRelay failed %v err <%v>", msg,
+			err)
+	}
+
+	// Decode with old protocol version.
+	readmsg := NewMsgWTxIDRelay()
+	err = readmsg.BtcDecode(&buf, WTxIDRelayVersion, enc)
+	if err != nil {
+		t.Errorf("decode of MsgWTxIDRelay failed [%v] err <%v>", buf,
+			err)
+	}
+}
+
+// TestWTxIDRelayWire tests the MsgWTxIDRelay wire encode and decode for
+// various protocol versions.
+func TestWTxIDRelayWire(t *testing.T) {
+	msgWTxIDRelay := NewMsgWTxIDRelay()
+	msgWTxIDRelayEncoded := []byte{}
+
+	tests := []struct {
+		in   *MsgWTxIDRelay  // Message to encode
+		out  *MsgWTxIDRelay  // Expected decoded message
+		buf  []byte          // Wire encoding
+		pver uint32          // Protocol version for wire encoding
+		enc  MessageEncoding // Message encoding format
+	}{
+		// Latest protocol version.
+		{
+			msgWTxIDRelay,
+			msgWTxIDRelay,
+			msgWTxIDRelayEncoded,
+			ProtocolVersion,
+			BaseEncoding,
+		},
+
+		// Protocol version WTxIDRelayVersion+1
+		{
+			msgWTxIDRelay,
+			msgWTxIDRelay,
+			msgWTxIDRelayEncoded,
+			WTxIDRelayVersion + 1,
+			BaseEncoding,
+		},
+
+		// Protocol version WTxIDRelayVersion
+		{
+			msgWTxIDRelay,
+			msgWTxIDRelay,
+			msgWTxIDRelayEncoded,
+			WTxIDRelayVersion,
+			BaseEncoding,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver, test.enc)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg MsgWTxIDRelay
+		rbuf := bytes.NewReader(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver, test.enc)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
@@ -0,0 +1,355 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// NetworkID identifies the network of an address in an addrv2 message as
+// defined by BIP0155.
+type NetworkID uint8
+
+const (
+	// NetIDIPv4 identifies a 4 byte IPv4 address.
+	NetIDIPv4 NetworkID = 1
+
+	// NetIDIPv6 identifies a 16 byte IPv6 address.
+	NetIDIPv6 NetworkID = 2
+
+	// NetIDTorV2 identifies a 10 byte Tor v2 onion service address.
+	NetIDTorV2 NetworkID = 3
+
+	// NetIDTorV3 identifies a 32 byte Tor v3 onion service public key.
+	NetIDTorV3 NetworkID = 4
+
+	// NetIDI2P identifies a 32 byte SHA256 hash of an I2P destination.
+	NetIDI2P NetworkID = 5
+
+	// NetIDCJDNS identifies a 16 byte CJDNS IPv6 address within fc00::/8.
+	NetIDCJDNS NetworkID = 6
+)
+
+// netIDStrings is a map of network ids back to their constant names for pretty
+// printing.
+var netIDStrings = map[NetworkID]string{
+	NetIDIPv4:  "IPv4",
+	NetIDIPv6:  "IPv6",
+	NetIDTorV2: "TorV2",
+	NetIDTorV3: "TorV3",
+	NetIDI2P:   "I2P",
+	NetIDCJDNS: "CJDNS",
+}
+
+// String returns the NetworkID in human-readable form.
+func (id NetworkID) String() string {
+	if s, ok := netIDStrings[id]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown NetworkID (%d)", uint8(id))
+}
+
+// netIDAddrSizes houses the address size required for each known network.
+var netIDAddrSizes = map[NetworkID]int{
+	NetIDIPv4:  4,
+	NetIDIPv6:  16,
+	NetIDTorV2: 10,
+	NetIDTorV3: 32,
+	NetIDI2P:   32,
+	NetIDCJDNS: 16,
+}
+
+const (
+	// maxNetAddressV2Size is the maximum size of the address field of a
+	// NetAddressV2 as defined by BIP0155.  Addresses of unknown networks
+	// up to this size are skipped rather than rejected.
+	maxNetAddressV2Size = 512
+
+	// maxNetAddressV2Payload is the maximum payload size of a NetAddressV2.
+	// Timestamp 4 bytes + services (varInt) + network id 1 byte + address
+	// (varInt + max address size) + port 2 bytes.
+	maxNetAddressV2Payload = 4 + MaxVarIntPayload + 1 + MaxVarIntPayload +
+		maxNetAddressV2Size + 2
+
+	// torV3Version is the version byte appended to Tor v3 onion addresses.
+	torV3Version = 0x03
+
+	// torV3ChecksumPrefix is hashed along with the public key and version to
+	// produce the checksum of Tor v3 onion addresses.
+	torV3ChecksumPrefix = ".onion checksum"
+)
+
+// onionCatPrefix is the IPv6 prefix used to encode Tor v2 onion addresses as
+// IPv6 addresses in legacy addr messages.
+var onionCatPrefix = []byte{0xfd, 0x87, 0xd8, 0x7e, 0xeb, 0x43}
+
+// onionEncoding is the base32 encoding used by Tor and I2P addresses.
+var onionEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NetAddressV2 defines information about a peer on the network including the
+// time it was last seen, the services it supports, its address, and port.
+// Unlike NetAddress, the address is variable length and may belong to any of
+// the networks defined by BIP0155, such as Tor v3, I2P and CJDNS.
+type NetAddressV2 struct {
+	// Last time the address was seen.  This is encoded as a uint32 on the
+	// wire and therefore is limited to 2106.
+	Timestamp time.Time
+
+	// Bitfield which identifies the services supported by the address.
+	Services ServiceFlag
+
+	// NetID identifies the network the address belongs to.
+	NetID NetworkID
+
+	// Addr is the raw address whose size depends on NetID.
+	Addr []byte
+
+	// Port the peer is using.  This is encoded in big endian on the wire
+	// which differs from most everything else.
+	Port uint16
+}
+
+// HasService returns whether the specified service is supported by the address.
+func (na *NetAddressV2) HasService(service ServiceFlag) bool {
+	return na.Services&service == service
+}
+
+// AddService adds service as a supported service by the peer generating the
+// message.
+func (na *NetAddressV2) AddService(service ServiceFlag) {
+	na.Services |= service
+}
+
+// IP returns the address as an IP for the networks which use IP addresses,
+// namely IPv4, IPv6 and CJDNS.  Nil is returned for all other networks.
+func (na *NetAddressV2) IP() net.IP {
+	switch na.NetID {
+	case NetIDIPv4:
+		return net.IP(na.Addr).To16()
+	case NetIDIPv6, NetIDCJDNS:
+		return net.IP(na.Addr)
+	}
+	return nil
+}
+
+// Host returns the host portion of the address in string form.  IP addresses
+// are returned in their usual notation while Tor and I2P addresses are
+// returned as .onion and .b32.i2p names respectively.
+func (na *NetAddressV2) Host() string {
+	switch na.NetID {
+	case NetIDTorV2:
+		return strings.ToLower(onionEncoding.EncodeToString(na.Addr)) +
+			".onion"
+
+	case NetIDTorV3:
+		checksum := TorV3Checksum(na.Addr)
+		var buf bytes.Buffer
+		buf.Write(na.Addr)
+		buf.Write(checksum[:])
+		buf.WriteByte(torV3Version)
+		return strings.ToLower(onionEncoding.EncodeToString(
+			buf.Bytes())) + ".onion"
+
+	case NetIDI2P:
+		return strings.ToLower(onionEncoding.EncodeToString(na.Addr)) +
+			".b32.i2p"
+	}
+
+	if ip := na.IP(); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// String returns the address in host:port form.
+func (na *NetAddressV2) String() string {
+	return net.JoinHostPort(na.Host(), fmt.Sprint(na.Port))
+}
+
+// IsAddrV1Compatible returns whether the address can be relayed to peers which
+// only understand the legacy addr message.  Only IPv4, IPv6 and Tor v2
+// addresses, via the onioncat encoding, fit into a legacy address.
+func (na *NetAddressV2) IsAddrV1Compatible() bool {
+	switch na.NetID {
+	case NetIDIPv4, NetIDIPv6, NetIDTorV2:
+		return true
+	}
+	return false
+}
+
+// ToLegacy converts the address to a legacy NetAddress.  Nil is returned when
+// the address is not compatible with legacy addresses.
+func (na *NetAddressV2) ToLegacy() *NetAddress {
+	var ip net.IP
+	switch na.NetID {
+	case NetIDIPv4, NetIDIPv6:
+		ip = na.IP()
+	case NetIDTorV2:
+		ip = make(net.IP, 0, net.IPv6len)
+		ip = append(ip, onionCatPrefix...)
+		ip = append(ip, na.Addr...)
+	default:
+		return nil
+	}
+	return NewNetAddressTimestamp(na.Timestamp, na.Services, ip, na.Port)
+}
+
+// TorV3Checksum returns the checksum of the passed Tor v3 onion service public
+// key as defined by the Tor rendezvous specification.
+func TorV3Checksum(pubKey []byte) [2]byte {
+	h := sha3.New256()
+	h.Write([]byte(torV3ChecksumPrefix))
+	h.Write(pubKey)
+	h.Write([]byte{torV3Version})
+	var checksum [2]byte
+	copy(checksum[:], h.Sum(nil))
+	return checksum
+}
+
+// NewNetAddressV2 returns a new NetAddressV2 using the provided timestamp,
+// network id, address, port, and supported services.  The timestamp is
+// rounded to single second precision.
+func NewNetAddressV2(timestamp time.Time, services ServiceFlag,
+	netID NetworkID, addr []byte, port uint16) *NetAddressV2 {
+
+	// Limit the timestamp to one second precision since the protocol
+	// doesn't support better.
+	return &NetAddressV2{
+		Timestamp: time.Unix(timestamp.Unix(), 0),
+		Services:  services,
+		NetID:     netID,
+		Addr:      addr,
+		Port:      port,
+	}
+}
+
+// NewNetAddressV2IPPort returns a new NetAddressV2 using the provided IP, port,
+// and supported services with defaults for the remaining fields.  IPv6
+// addresses using the onioncat prefix are converted to Tor v2 addresses.
+func NewNetAddressV2IPPort(ip net.IP, port uint16,
+	services ServiceFlag) *NetAddressV2 {
+
+	return newNetAddressV2FromIP(time.Now(), services, ip, port)
+}
+
+// NetAddressV2FromLegacy converts a legacy NetAddress to a NetAddressV2.
+func NetAddressV2FromLegacy(na *NetAddress) *NetAddressV2 {
+	return newNetAddressV2FromIP(na.Timestamp, na.Services, na.IP, na.Port)
+}
+
+// newNetAddressV2FromIP returns a NetAddressV2 for the passed IP which is
+// classified as IPv4, Tor v2 (onioncat), CJDNS or IPv6 in that order.
+func newNetAddressV2FromIP(timestamp time.Time, services ServiceFlag,
+	ip net.IP, port uint16) *NetAddressV2 {
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return NewNetAddressV2(timestamp, services, NetIDIPv4,
+			[]byte(ip4), port)
+	}
+
+	ip6 := ip.To16()
+	if ip6 == nil {
+		ip6 = make(net.IP, net.IPv6len)
+	}
+	if bytes.HasPrefix(ip6, onionCatPrefix) {
+		addr := make([]byte, net.IPv6len-len(onionCatPrefix))
+		copy(addr, ip6[len(onionCatPrefix):])
+		return NewNetAddressV2(timestamp, services, NetIDTorV2, addr, port)
+	}
+	return NewNetAddressV2(timestamp, services, NetIDIPv6, []byte(ip6), port)
+}
+
+// readNetAddressV2 reads an encoded NetAddressV2 from r.  The returned bool is
+// false when the address belongs to an unknown network, in which case it must
+// be ignored as required by BIP0155.
+func readNetAddressV2(r io.Reader, pver uint32, na *NetAddressV2) (bool, error) {
+	err := readElement(r, (*uint32Time)(&na.Timestamp))
+	if err != nil {
+		return false, err
+	}
+
+	services, err := ReadVarInt(r, pver)
+	if err != nil {
+		return false, err
+	}
+	netID, err := binarySerializer.Uint8(r)
+	if err != nil {
+		return false, err
+	}
+	addr, err := ReadVarBytes(r, pver, maxNetAddressV2Size, "addr")
+	if err != nil {
+		return false, err
+	}
+
+	// Sigh.  Bitcoin protocol mixes little and big endian.
+	port, err := binarySerializer.Uint16(r, bigEndian)
+	if err != nil {
+		return false, err
+	}
+
+	size, known := netIDAddrSizes[NetworkID(netID)]
+	if !known {
+		return false, nil
+	}
+	if len(addr) != size {
+		str := fmt.Sprintf("invalid address size for network %v "+
+			"[size %d, want %d]", NetworkID(netID), len(addr), size)
+		return false, messageError("readNetAddressV2", str)
+	}
+
+	*na = NetAddressV2{
+		Timestamp: na.Timestamp,
+		Services:  ServiceFlag(services),
+		NetID:     NetworkID(netID),
+		Addr:      addr,
+		Port:      port,
+	}
+	return true, nil
+}
+
+// writeNetAddressV2 serializes a NetAddressV2 to w.
+func writeNetAddressV2(w io.Writer, pver uint32, na *NetAddressV2) error {
+	size, known := netIDAddrSizes[na.NetID]
+	if !known {
+		str := fmt.Sprintf("unknown network id %d", uint8(na.NetID))
+		return messageError("writeNetAddressV2", str)
+	}
+	if len(na.Addr) != size {
+		str := fmt.Sprintf("invalid address size for network %v "+
+			"[size %d, want %d]", na.NetID, len(na.Addr), size)
+		return messageError("writeNetAddressV2", str)
+	}
+
+	// NOTE: The bitcoin protocol uses a uint32 for the timestamp so it will
+	// stop working somewhere around 2106.
+	err := writeElement(w, uint32(na.Timestamp.Unix()))
+	if err != nil {
+		return err
+	}
+	err = WriteVarInt(w, pver, uint64(na.Services))
+	if err != nil {
+		return err
+	}
+	err = binarySerializer.PutUint8(w, uint8(na.NetID))
+	if err != nil {
+		return err
+	}
+	err = WriteVarBytes(w, pver, na.Addr)
+	if err != nil {
+		return err
+	}
+
+	// Sigh.  Bitcoin protocol mixes little and big endian.
+	return binary.Write(w, bigEndian, na.Port)
+}
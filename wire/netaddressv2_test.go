@@ -0,0 +1,177 @@
+// Copyright (c) 2021 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNetAddressV2 tests the NetAddressV2 API.
+func TestNetAddressV2(t *testing.T) {
+	// A Tor v3 address from the Tor rendezvous specification test vectors
+	// along with its public key.
+	torV3Host := "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion"
+	decoded, err := onionEncoding.DecodeString(strings.ToUpper(
+		strings.TrimSuffix(torV3Host, ".onion")))
+	if err != nil {
+		t.Fatalf("DecodeString: unexpected error: %v", err)
+	}
+	torV3Key := decoded[:32]
+	checksum := TorV3Checksum(torV3Key)
+	if !bytes.Equal(checksum[:], decoded[32:34]) {
+		t.Fatalf("TorV3Checksum: got %x, want %x", checksum,
+			decoded[32:34])
+	}
+
+	ts := time.Unix(0x495fab29, 0)
+	tests := []struct {
+		name   string
+		na     *NetAddressV2
+		ip     net.IP
+		host   string
+		legacy bool
+	}{
+		{
+			name: "ipv4",
+			na: NewNetAddressV2IPPort(net.ParseIP("127.0.0.1"),
+				8333, 0),
+			ip:     net.ParseIP("127.0.0.1"),
+			host:   "127.0.0.1",
+			legacy: true,
+		},
+		{
+			name: "ipv6",
+			na: NewNetAddressV2IPPort(net.ParseIP("2001:db8::1"),
+				8333, 0),
+			ip:     net.ParseIP("2001:db8::1"),
+			host:   "2001:db8::1",
+			legacy: true,
+		},
+		{
+			name: "onioncat",
+			na: NewNetAddressV2IPPort(net.ParseIP(
+				"fd87:d87e:eb43:8e28:6c37:d88b:5dd2:ee21"), 8333, 0),
+			host:   "ryugyn6yrno5f3rb.onion",
+			legacy: true,
+		},
+		{
+			name: "torv3",
+			na: NewNetAddressV2(ts, 0, NetIDTorV3, torV3Key,
+				8333),
+			host: torV3Host,
+		},
+		{
+			name: "i2p",
+			na: NewNetAddressV2(ts, 0, NetIDI2P,
+				make([]byte, 32), 8333),
+			host: strings.Repeat("a", 52) + ".b32.i2p",
+		},
+		{
+			name: "cjdns",
+			na: NewNetAddressV2(ts, 0, NetIDCJDNS,
+				net.ParseIP("fc00::1"), 8333),
+			ip:   net.ParseIP("fc00::1"),
+			host: "fc00::1",
+		},
+	}
+
+	for _, test := range tests {
+		if !test.na.IP().Equal(test.ip) {
+			t.Errorf("%s: IP got %v, want %v", test.name,
+				test.na.IP(), test.ip)
+		}
+		if host := test.na.Host(); host != test.host {
+			t.Errorf("%s: Host got %v, want %v", test.name, host,
+				test.host)
+		}
+		if test.na.IsAddrV1Compatible() != test.legacy {
+			t.Errorf("%s: IsAddrV1Compatible got %v, want %v",
+				test.name, !test.legacy, test.legacy)
+		}
+
+		// Addresses compatible with legacy addresses must survive
+		// the round trip through them.
+		legacy := test.na.ToLegacy()
+		if !test.legacy {
+			if legacy != nil {
+				t.Errorf("%s: ToLegacy got %v, want nil",
+					test.name, legacy)
+			}
+			continue
+		}
+		got := NetAddressV2FromLegacy(legacy)
+		if !reflect.DeepEqual(got, test.na) {
+			t.Errorf("%s: NetAddressV2FromLegacy got %v, want %v",
+				test.name, got, test.na)
+		}
+	}
+
+	// Ensure adding the full service node flag works.
+	na := NewNetAddressV2IPPort(net.ParseIP("127.0.0.1"), 8333, 0)
+	na.AddService(SFNodeNetwork)
+	if !na.HasService(SFNodeNetwork) {
+		t.Errorf("AddService: SFNodeNetwork service not set")
+	}
+}
+
+// TestNetAddressV2Wire tests the NetAddressV2 wire encode and decode including
+// the handling of unknown networks and invalid address sizes.
+func TestNetAddressV2Wire(t *testing.T) {
+	pver := ProtocolVersion
+	na := NewNetAddressV2(time.Unix(0x495fab29, 0), SFNodeNetwork,
+		NetIDIPv4, []byte{127, 0, 0, 1}, 8333)
+	naEncoded := []byte{
+		0x29, 0xab, 0x5f, 0x49, // Timestamp
+		0x01,                         // Services
+		0x01,                         // NetID
+		0x04, 0x7f, 0x00, 0x00, 0x01, // Addr
+		0x20, 0x8d, // Port 8333 in big-endian
+	}
+
+	var buf bytes.Buffer
+	if err := writeNetAddressV2(&buf, pver, na); err != nil {
+		t.Fatalf("writeNetAddressV2: unexpected error: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), naEncoded) {
+		t.Fatalf("writeNetAddressV2: got %x, want %x", buf.Bytes(),
+			naEncoded)
+	}
+	var got NetAddressV2
+	known, err := readNetAddressV2(bytes.NewReader(naEncoded), pver, &got)
+	if err != nil || !known {
+		t.Fatalf("readNetAddressV2: unexpected result %v, %v", known,
+			err)
+	}
+	if !reflect.DeepEqual(&got, na) {
+		t.Fatalf("readNetAddressV2: got %v, want %v", got, na)
+	}
+
+	// Unknown networks are skipped.
+	unknown := append([]byte(nil), naEncoded...)
+	unknown[5] = 0x2a
+	known, err = readNetAddressV2(bytes.NewReader(unknown), pver, &got)
+	if err != nil || known {
+		t.Fatalf("readNetAddressV2: unexpected result for unknown "+
+			"network %v, %v", known, err)
+	}
+
+	// Known networks with the wrong address size are rejected.
+	badSize := append([]byte(nil), naEncoded...)
+	badSize[5] = byte(NetIDIPv6)
+	_, err = readNetAddressV2(bytes.NewReader(badSize), pver, &got)
+	if _, ok := err.(*MessageError); !ok {
+		t.Fatalf("readNetAddressV2: got error %v, want MessageError",
+			err)
+	}
+	na.NetID = NetIDTorV3
+	if err := writeNetAddressV2(&buf, pver, na); err == nil {
+		t.Fatal("writeNetAddressV2: expected error for invalid size")
+	}
+}